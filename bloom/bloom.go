@@ -0,0 +1,77 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+// Package bloom implements a Bloom filter of program signatures.
+// It is used to compare corpora of two parties without transferring the corpora:
+// Test never has false negatives, but has false positives with a configured rate.
+package bloom
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/google/syzkaller/hash"
+)
+
+type Filter struct {
+	bits []byte
+	k    int // number of hash functions
+}
+
+// New creates a filter for n signatures with the given false positive rate.
+func New(n int, fpRate float64) *Filter {
+	if n < 1 {
+		n = 1
+	}
+	m := int(math.Ceil(-float64(n) * math.Log(fpRate) / (math.Ln2 * math.Ln2)))
+	k := int(math.Ceil(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &Filter{
+		bits: make([]byte, (m+7)/8),
+		k:    k,
+	}
+}
+
+func (f *Filter) Add(sig hash.Sig) {
+	h1, h2, m := f.hashes(sig)
+	for i := 0; i < f.k; i++ {
+		bit := (h1 + uint64(i)*h2) % m
+		f.bits[bit/8] |= 1 << (bit % 8)
+	}
+}
+
+// Test returns false if sig was definitely not added to the filter.
+func (f *Filter) Test(sig hash.Sig) bool {
+	h1, h2, m := f.hashes(sig)
+	for i := 0; i < f.k; i++ {
+		bit := (h1 + uint64(i)*h2) % m
+		if f.bits[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// hashes returns two independent hashes of sig used to derive all k bit positions
+// (signatures are already cryptographic hashes, so their parts are used as is).
+func (f *Filter) hashes(sig hash.Sig) (uint64, uint64, uint64) {
+	return binary.LittleEndian.Uint64(sig[0:]), binary.LittleEndian.Uint64(sig[8:]) | 1, uint64(len(f.bits)) * 8
+}
+
+// Serialize returns compact representation of the filter that can be restored with Deserialize.
+func (f *Filter) Serialize() []byte {
+	return append([]byte{byte(f.k)}, f.bits...)
+}
+
+func Deserialize(data []byte) (*Filter, error) {
+	if len(data) < 2 || data[0] == 0 {
+		return nil, fmt.Errorf("bad bloom filter data")
+	}
+	return &Filter{
+		bits: data[1:],
+		k:    int(data[0]),
+	}, nil
+}
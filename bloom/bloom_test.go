@@ -0,0 +1,48 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package bloom
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/syzkaller/hash"
+)
+
+func TestFilter(t *testing.T) {
+	const n = 10000
+	f := New(n, 0.01)
+	for i := 0; i < n; i++ {
+		f.Add(hash.Hash([]byte(fmt.Sprint(i))))
+	}
+	f, err := Deserialize(f.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if !f.Test(hash.Hash([]byte(fmt.Sprint(i)))) {
+			t.Fatalf("false negative for %v", i)
+		}
+	}
+	fp := 0
+	for i := n; i < 2*n; i++ {
+		if f.Test(hash.Hash([]byte(fmt.Sprint(i)))) {
+			fp++
+		}
+	}
+	if fp > n/50 {
+		t.Fatalf("too many false positives: %v/%v", fp, n)
+	}
+	if size := len(f.Serialize()); size > n*20/8 {
+		t.Fatalf("filter for %v signatures takes %v bytes", n, size)
+	}
+}
+
+func TestDeserialize(t *testing.T) {
+	for _, data := range [][]byte{nil, {3}, {0, 1, 2}} {
+		if _, err := Deserialize(data); err == nil {
+			t.Errorf("deserialized bad data %v", data)
+		}
+	}
+}
@@ -13,6 +13,8 @@ import (
 	"strings"
 
 	"github.com/google/syzkaller/fileutil"
+	"github.com/google/syzkaller/prog"
+	"github.com/google/syzkaller/report"
 	"github.com/google/syzkaller/sys"
 	"github.com/google/syzkaller/vm"
 )
@@ -36,9 +38,16 @@ type Config struct {
 	Debug    bool   // dump all VM output to console
 	Output   string // one of stdout/dmesg/file (useful only for local VM)
 
+	Kernel_Src string // kernel source dir, its MAINTAINERS file is used to find maintainers of crashes (optional)
+
+	Os string // kernel OS: linux (default), freebsd, netbsd, fuchsia or windows (determines format of crash reports)
+
 	Hub_Addr string
 	Hub_Key  string
 
+	Dashboard_Addr string // syz-dash rpc address, crashes and reproducers are reported to it (optional)
+	Dashboard_Key  string
+
 	Syzkaller string   // path to syzkaller checkout (syz-manager will look for binaries in bin subdir)
 	Type      string   // VM type (qemu, kvm, local)
 	Count     int      // number of VMs (don't secify for adb, instead specify devices)
@@ -51,11 +60,78 @@ type Config struct {
 	// "namespace": create a new namespace for fuzzer using CLONE_NEWNS/CLONE_NEWNET/CLONE_NEWPID/etc,
 	//	requires building kernel with CONFIG_NAMESPACES, CONFIG_UTS_NS, CONFIG_USER_NS, CONFIG_PID_NS and CONFIG_NET_NS.
 
+	Netns bool // create a separate network namespace with a bridge and a veth pair for every test process
+	// (ignored by "namespace" sandbox which creates own empty network namespace).
+
+	Seccomp_Deny []string // syscalls denied with a seccomp filter in "namespace" sandbox (e.g. ["reboot", "kexec_load"])
+
 	Machine_Type string // GCE machine type (e.g. "n1-highcpu-2")
 
-	Cover     bool // use kcov coverage (default: true)
-	Leak      bool // do memory leak checking
-	Reproduce bool // reproduce, localize and minimize crashers (on by default)
+	// Pools allows to use a heterogeneous set of VMs (e.g. some GCE VMs plus some physical devices).
+	// If specified, type/count/devices must not be specified at the top level.
+	// VM indexes are assigned to pools sequentially in the order of pools.
+	Pools []*Pool
+
+	Cover     bool   // use kcov coverage (default: true)
+	Signal    string // coverage feedback signal: "pc" (default) or "edge" (hashed pairs of consecutive PCs)
+	Leak      bool   // do memory leak checking
+	Reproduce bool   // reproduce, localize and minimize crashers (on by default)
+
+	Leak_Period int // period of memory leak checking in seconds (default: 60)
+
+	Max_Data_Races int // max number of KCSAN data race reports saved per hour (default: 0 - unlimited)
+
+	Reproduce_Hangs bool // reproduce hangs (hung tasks, lockups, RCU stalls) (on by default, ignored if reproduce is off)
+	Hang_Logs       int  // number of logs saved per hang, crashes keep up to 100 logs (default: 10)
+
+	// Weights of program mutation strategies (e.g. {"insert_call": 60, "mutate_arg": 30, "splice": 1}) (optional).
+	// If specified, only the listed strategies are used, otherwise all of them are used with default weights.
+	// Available strategies: splice, insert_call, mutate_arg, remove_call, shuffle, resource,
+	// squash (disabled unless listed).
+	Mutation_Strategies map[string]int
+
+	Call_Timeout int // how long executor waits for a call to complete before proceeding to the next call, in ms (default: 20)
+	Slow_Call    int // calls that take longer are considered slow and deprioritized if it happens consistently, in ms (default: 100)
+
+	Result_Sample int // fuzzers send every n-th call result (errno, coverage, duration) to manager (default: 100, -1 to disable)
+
+	// Address space shaping in generated programs (e.g. ["gaps", "scatter", "unaligned"]):
+	// gaps: leave unmapped pages around new mappings,
+	// scatter: place mappings at random (including the lowest/highest) pages of the data area,
+	// unaligned: frequently misalign pointers.
+	Addr_Layout []string
+
+	Cleanup_Calls bool // append destructor calls for resources created by a program to half of generated programs
+
+	Binary_Corpus bool // store programs in corpus.db in the compact binary format (existing corpus is converted on start)
+
+	Import_Prios []string // priority tables (workdir/prios files of other managers) to merge into the learned priorities
+
+	// Dictionaries of tokens (protocol keywords, magic values, device names) in the AFL format
+	// that are inserted into string and blob arguments during mutation.
+	// Files are re-read when fuzzers connect, new tokens are sent to running fuzzers.
+	Dictionaries []string
+
+	// Directory that is periodically scanned for new seed files: programs, raw strace logs
+	// or corpus.db files. New files are detected by contents and added as candidates (optional).
+	Seeds string
+
+	// Directories that are searched (recursively) for object files of loaded kernel modules (<name>.ko),
+	// used to symbolize coverage of module code (optional, defaults to the directory of vmlinux).
+	Module_Obj []string
+
+	// Fault injection: after a new input is added to corpus, re-execute it failing
+	// 0-th, 1-st, ..., up to Fault_Nth-th fault site (e.g. memory allocation) in the new call.
+	// Requires a kernel with CONFIG_FAULT_INJECTION, CONFIG_FAILSLAB, CONFIG_FAIL_PAGE_ALLOC
+	// and /proc/thread-self/fail-nth support.
+	Fault_Injection bool
+	Fault_Nth       int // max fault site index per call (default: 100)
+	Fault_Percent   int // percent of new inputs to inject faults into (default: 100)
+
+	// Fuzz USB drivers by connecting emulated USB devices (syz_usb_* pseudo-syscalls).
+	// Requires a kernel with CONFIG_USB_RAW_GADGET and CONFIG_USB_DUMMY_HCD.
+	// syz_usb_* syscalls are disabled if not set.
+	Usb_Emulation bool
 
 	Enable_Syscalls  []string
 	Disable_Syscalls []string
@@ -65,33 +141,168 @@ type Config struct {
 	// Implementation details beyond this point.
 	ParsedSuppressions []*regexp.Regexp `json:"-"`
 	ParsedIgnores      []*regexp.Regexp `json:"-"`
+	Reporter           report.Reporter  `json:"-"`
+}
+
+// Pool describes a set of VMs of the same type.
+// Unspecified parameters are inherited from the top-level config.
+type Pool struct {
+	Type         string   // VM type (qemu, kvm, local, adb, gce)
+	Count        int      // number of VMs (don't specify for adb, instead specify devices)
+	Devices      []string // device IDs for adb
+	Machine_Type string   // GCE machine type
+	Kernel       string
+	Image        string
+	Initrd       string
+	Sshkey       string
+	Bin          string
+	Bin_Args     string
+	Cpu          int
+	Mem          int
 }
 
+// Parse reads and validates the config file (json, or YAML/TOML if the file has .yaml/.yml/.toml extension).
+// String values can refer to environment variables as ${VAR}. The "include" key lists
+// other config files (paths are relative to the including file) that are loaded first,
+// fields of the including file override fields of the included files.
 func Parse(filename string) (*Config, map[int]bool, error) {
 	if filename == "" {
 		return nil, nil, fmt.Errorf("supply config in -config flag")
 	}
-	data, err := ioutil.ReadFile(filename)
+	fields, err := load(filename, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := json.Marshal(fields)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to read config file: %v", err)
+		return nil, nil, fmt.Errorf("failed to serialize config: %v", err)
 	}
 	return parse(data)
 }
 
-func parse(data []byte) (*Config, map[int]bool, error) {
-	unknown, err := checkUnknownFields(data)
+// load reads the config file along with the files it includes and returns the merged top-level fields
+// with environment variables expanded. Keys are lower-cased as json matches field names case-insensitively.
+// stack is the chain of files that include this file (to detect include cycles).
+func load(filename string, stack []string) (map[string]interface{}, error) {
+	for _, file := range stack {
+		if file == filename {
+			return nil, fmt.Errorf("config file %v includes itself", filename)
+		}
+	}
+	data, err := ioutil.ReadFile(filename)
 	if err != nil {
-		return nil, nil, err
+		return nil, fmt.Errorf("failed to read config file: %v", err)
 	}
-	if unknown != "" {
-		return nil, nil, fmt.Errorf("unknown field '%v' in config", unknown)
+	raw, err := decode(filename, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %v: %v", filename, err)
 	}
+	fields := make(map[string]interface{})
+	var includes []string
+	for k, v := range raw {
+		v, err := expandEnv(v)
+		if err != nil {
+			return nil, fmt.Errorf("bad config file %v: %v", filename, err)
+		}
+		if strings.ToLower(k) != "include" {
+			fields[strings.ToLower(k)] = v
+			continue
+		}
+		switch inc := v.(type) {
+		case string:
+			includes = append(includes, inc)
+		case []interface{}:
+			for _, file := range inc {
+				file, ok := file.(string)
+				if !ok {
+					return nil, fmt.Errorf("bad config file %v: include must be a file name or a list of file names", filename)
+				}
+				includes = append(includes, file)
+			}
+		default:
+			return nil, fmt.Errorf("bad config file %v: include must be a file name or a list of file names", filename)
+		}
+	}
+	res := make(map[string]interface{})
+	for _, file := range includes {
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(filepath.Dir(filename), file)
+		}
+		included, err := load(file, append(stack, filename))
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range included {
+			res[k] = v
+		}
+	}
+	for k, v := range fields {
+		res[k] = v
+	}
+	return res, nil
+}
+
+// decode decodes config data according to the file extension: .yaml/.yml, .toml or json otherwise.
+func decode(filename string, data []byte) (map[string]interface{}, error) {
+	switch filepath.Ext(filename) {
+	case ".yaml", ".yml":
+		return parseYAML(data)
+	case ".toml":
+		return parseTOML(data)
+	}
+	raw := make(map[string]interface{})
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// expandEnv replaces ${VAR} in all strings in the json value v with values of environment variables.
+// Other forms ($VAR) are left intact as they are common in regexps (e.g. in suppressions).
+func expandEnv(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		var err error
+		res := envRe.ReplaceAllStringFunc(v, func(ref string) string {
+			name := ref[2 : len(ref)-1]
+			val, ok := os.LookupEnv(name)
+			if !ok && err == nil {
+				err = fmt.Errorf("environment variable %v is not set", name)
+			}
+			return val
+		})
+		return res, err
+	case []interface{}:
+		for i, elem := range v {
+			elem, err := expandEnv(elem)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = elem
+		}
+	case map[string]interface{}:
+		for k, elem := range v {
+			elem, err := expandEnv(elem)
+			if err != nil {
+				return nil, err
+			}
+			v[k] = elem
+		}
+	}
+	return v, nil
+}
+
+var envRe = regexp.MustCompile(`\$\{[A-Za-z_][A-Za-z0-9_]*\}`)
+
+func parse(data []byte) (*Config, map[int]bool, error) {
 	cfg := new(Config)
 	cfg.Cover = true
 	cfg.Reproduce = true
+	cfg.Reproduce_Hangs = true
 	cfg.Sandbox = "setuid"
-	if err := json.Unmarshal(data, cfg); err != nil {
-		return nil, nil, fmt.Errorf("failed to parse config file: %v", err)
+	cfg.Signal = "pc"
+	if err := LoadData(data, cfg); err != nil {
+		return nil, nil, err
 	}
 	if _, err := os.Stat(filepath.Join(cfg.Syzkaller, "bin/syz-fuzzer")); err != nil {
 		return nil, nil, fmt.Errorf("bad config syzkaller param: can't find bin/syz-fuzzer")
@@ -108,39 +319,32 @@ func parse(data []byte) (*Config, map[int]bool, error) {
 	if cfg.Vmlinux == "" {
 		return nil, nil, fmt.Errorf("config param vmlinux is empty")
 	}
-	if cfg.Type == "" {
-		return nil, nil, fmt.Errorf("config param type is empty")
-	}
-	switch cfg.Type {
-	case "none":
-		if cfg.Count != 0 {
-			return nil, nil, fmt.Errorf("invalid config param count: %v, type \"none\" does not support param count", cfg.Count)
+	if len(cfg.Pools) != 0 {
+		if cfg.Type != "" || cfg.Count != 0 || len(cfg.Devices) != 0 {
+			return nil, nil, fmt.Errorf("don't specify type/count/devices along with pools")
 		}
-		if cfg.Rpc == "" {
-			return nil, nil, fmt.Errorf("config param rpc is empty (required for type \"none\")")
-		}
-		if len(cfg.Devices) != 0 {
-			return nil, nil, fmt.Errorf("type %v does not support devices param", cfg.Type)
-		}
-	case "adb":
-		if cfg.Count != 0 {
-			return nil, nil, fmt.Errorf("don't specify count for adb, instead specify devices")
-		}
-		if len(cfg.Devices) == 0 {
-			return nil, nil, fmt.Errorf("specify at least 1 adb device")
+		cfg.Type = "pool"
+		for i, pool := range cfg.Pools {
+			if pool.Machine_Type == "" {
+				pool.Machine_Type = cfg.Machine_Type
+			}
+			if err := checkVMType(pool.Type, &pool.Count, pool.Devices, pool.Machine_Type); err != nil {
+				return nil, nil, fmt.Errorf("pool #%v: %v", i, err)
+			}
+			if pool.Type == "none" {
+				return nil, nil, fmt.Errorf("pool #%v: type \"none\" can't be used in pools", i)
+			}
+			cfg.Count += pool.Count
 		}
-		cfg.Count = len(cfg.Devices)
-	case "gce":
-		if cfg.Machine_Type == "" {
-			return nil, nil, fmt.Errorf("machine_type parameter is empty (required for gce)")
+		if cfg.Count > 1000 {
+			return nil, nil, fmt.Errorf("invalid total VM count in pools: %v, want (1, 1000]", cfg.Count)
 		}
-		fallthrough
-	default:
-		if cfg.Count <= 0 || cfg.Count > 1000 {
-			return nil, nil, fmt.Errorf("invalid config param count: %v, want (1, 1000]", cfg.Count)
+	} else {
+		if err := checkVMType(cfg.Type, &cfg.Count, cfg.Devices, cfg.Machine_Type); err != nil {
+			return nil, nil, err
 		}
-		if len(cfg.Devices) != 0 {
-			return nil, nil, fmt.Errorf("type %v does not support devices param", cfg.Type)
+		if cfg.Type == "none" && cfg.Rpc == "" {
+			return nil, nil, fmt.Errorf("config param rpc is empty (required for type \"none\")")
 		}
 	}
 	if cfg.Rpc == "" {
@@ -149,6 +353,59 @@ func parse(data []byte) (*Config, map[int]bool, error) {
 	if cfg.Procs <= 0 {
 		cfg.Procs = 1
 	}
+	if cfg.Leak_Period == 0 {
+		cfg.Leak_Period = 60
+	}
+	if cfg.Leak_Period < 0 {
+		return nil, nil, fmt.Errorf("config param leak_period must be positive")
+	}
+	if cfg.Max_Data_Races < 0 {
+		return nil, nil, fmt.Errorf("config param max_data_races must not be negative")
+	}
+	if cfg.Hang_Logs == 0 {
+		cfg.Hang_Logs = 10
+	}
+	if cfg.Hang_Logs < 0 {
+		return nil, nil, fmt.Errorf("config param hang_logs must be positive")
+	}
+	for name, w := range cfg.Mutation_Strategies {
+		if w < 0 {
+			return nil, nil, fmt.Errorf("config param mutation_strategies: strategy %v has negative weight", name)
+		}
+	}
+	if _, err := prog.ParseAddrLayout(cfg.Addr_Layout); err != nil {
+		return nil, nil, fmt.Errorf("config param addr_layout: %v", err)
+	}
+	if cfg.Call_Timeout == 0 {
+		cfg.Call_Timeout = 20
+	}
+	if cfg.Call_Timeout < 0 {
+		return nil, nil, fmt.Errorf("config param call_timeout must be positive")
+	}
+	if cfg.Slow_Call == 0 {
+		cfg.Slow_Call = 100
+	}
+	if cfg.Slow_Call < 0 {
+		return nil, nil, fmt.Errorf("config param slow_call must be positive")
+	}
+	if cfg.Result_Sample == 0 {
+		cfg.Result_Sample = 100
+	}
+	if cfg.Result_Sample < -1 {
+		return nil, nil, fmt.Errorf("config param result_sample must be positive or -1")
+	}
+	if cfg.Fault_Nth == 0 {
+		cfg.Fault_Nth = 100
+	}
+	if cfg.Fault_Nth < 0 {
+		return nil, nil, fmt.Errorf("config param fault_nth must be positive")
+	}
+	if cfg.Fault_Percent == 0 {
+		cfg.Fault_Percent = 100
+	}
+	if cfg.Fault_Percent < 0 || cfg.Fault_Percent > 100 {
+		return nil, nil, fmt.Errorf("config param fault_percent must be within [1, 100]")
+	}
 	if cfg.Procs > 32 {
 		return nil, nil, fmt.Errorf("config param procs has higher value '%v' then the max supported 32", cfg.Procs)
 	}
@@ -164,10 +421,32 @@ func parse(data []byte) (*Config, map[int]bool, error) {
 	default:
 		return nil, nil, fmt.Errorf("config param output must contain one of none/stdout/dmesg/file")
 	}
-	switch cfg.Sandbox {
-	case "none", "setuid", "namespace":
+	if err := CheckSandbox(cfg.Sandbox); err != nil {
+		return nil, nil, err
+	}
+	switch cfg.Signal {
+	case "pc":
+	case "edge":
+		if !cfg.Cover {
+			return nil, nil, fmt.Errorf("config param signal=edge requires cover")
+		}
 	default:
-		return nil, nil, fmt.Errorf("config param sandbox must contain one of none/setuid/namespace")
+		return nil, nil, fmt.Errorf("config param signal must contain one of pc/edge")
+	}
+	if len(cfg.Seccomp_Deny) != 0 && cfg.Sandbox != "namespace" {
+		return nil, nil, fmt.Errorf("config param seccomp_deny requires namespace sandbox")
+	}
+	for _, name := range cfg.Seccomp_Deny {
+		found := false
+		for _, c := range sys.Calls {
+			if c.CallName == name && !strings.HasPrefix(name, "syz_") {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, nil, fmt.Errorf("unknown syscall '%v' in seccomp_deny", name)
+		}
 	}
 
 	wd, err := os.Getwd()
@@ -183,10 +462,25 @@ func parse(data []byte) (*Config, map[int]bool, error) {
 	cfg.Workdir = abs(cfg.Workdir)
 	cfg.Kernel = abs(cfg.Kernel)
 	cfg.Vmlinux = abs(cfg.Vmlinux)
+	cfg.Kernel_Src = abs(cfg.Kernel_Src)
 	cfg.Syzkaller = abs(cfg.Syzkaller)
 	cfg.Initrd = abs(cfg.Initrd)
 	cfg.Sshkey = abs(cfg.Sshkey)
 	cfg.Bin = abs(cfg.Bin)
+	cfg.Seeds = abs(cfg.Seeds)
+	if len(cfg.Module_Obj) == 0 && cfg.Vmlinux != "" {
+		cfg.Module_Obj = []string{filepath.Dir(cfg.Vmlinux)}
+	}
+	for i, dir := range cfg.Module_Obj {
+		cfg.Module_Obj[i] = abs(dir)
+	}
+	for _, pool := range cfg.Pools {
+		pool.Kernel = abs(pool.Kernel)
+		pool.Image = abs(pool.Image)
+		pool.Initrd = abs(pool.Initrd)
+		pool.Sshkey = abs(pool.Sshkey)
+		pool.Bin = abs(pool.Bin)
+	}
 
 	syscalls, err := parseSyscalls(cfg)
 	if err != nil {
@@ -196,10 +490,52 @@ func parse(data []byte) (*Config, map[int]bool, error) {
 	if err := parseSuppressions(cfg); err != nil {
 		return nil, nil, err
 	}
+	reporter, err := report.NewReporter(cfg.Os, cfg.ParsedIgnores)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg.Reporter = reporter
 
 	return cfg, syscalls, nil
 }
 
+// checkVMType validates VM type-specific params.
+// For adb count is set to the number of devices.
+func checkVMType(typ string, count *int, devices []string, machineType string) error {
+	switch typ {
+	case "":
+		return fmt.Errorf("config param type is empty")
+	case "none":
+		if *count != 0 {
+			return fmt.Errorf("invalid config param count: %v, type \"none\" does not support param count", *count)
+		}
+		if len(devices) != 0 {
+			return fmt.Errorf("type %v does not support devices param", typ)
+		}
+	case "adb":
+		if *count != 0 {
+			return fmt.Errorf("don't specify count for adb, instead specify devices")
+		}
+		if len(devices) == 0 {
+			return fmt.Errorf("specify at least 1 adb device")
+		}
+		*count = len(devices)
+	case "gce":
+		if machineType == "" {
+			return fmt.Errorf("machine_type parameter is empty (required for gce)")
+		}
+		fallthrough
+	default:
+		if *count <= 0 || *count > 1000 {
+			return fmt.Errorf("invalid config param count: %v, want (1, 1000]", *count)
+		}
+		if len(devices) != 0 {
+			return fmt.Errorf("type %v does not support devices param", typ)
+		}
+	}
+	return nil
+}
+
 func parseSyscalls(cfg *Config) (map[int]bool, error) {
 	match := func(call *sys.Call, str string) bool {
 		if str == call.CallName || str == call.Name {
@@ -242,6 +578,13 @@ func parseSyscalls(cfg *Config) (map[int]bool, error) {
 			return nil, fmt.Errorf("unknown disabled syscall: %v", c)
 		}
 	}
+	if !cfg.Usb_Emulation {
+		for _, call := range sys.Calls {
+			if strings.HasPrefix(call.CallName, "syz_usb_") {
+				delete(syscalls, call.ID)
+			}
+		}
+	}
 	// mmap is used to allocate memory.
 	syscalls[sys.CallMap["mmap"].ID] = true
 
@@ -288,6 +631,7 @@ func CreateVMConfig(cfg *Config, index int) (*vm.Config, error) {
 	}
 	vmCfg := &vm.Config{
 		Name:        fmt.Sprintf("%v-%v-%v", cfg.Type, cfg.Name, index),
+		Type:        cfg.Type,
 		Index:       index,
 		Workdir:     workdir,
 		Bin:         cfg.Bin,
@@ -303,65 +647,47 @@ func CreateVMConfig(cfg *Config, index int) (*vm.Config, error) {
 		Debug:       cfg.Debug,
 		MachineType: cfg.Machine_Type,
 	}
-	if len(cfg.Devices) != 0 {
-		vmCfg.Device = cfg.Devices[index]
+	if len(cfg.Pools) == 0 {
+		if len(cfg.Devices) != 0 {
+			vmCfg.Device = cfg.Devices[index]
+		}
+		return vmCfg, nil
+	}
+	pool, poolIndex := poolForIndex(cfg, index)
+	vmCfg.Name = fmt.Sprintf("%v-%v-%v", pool.Type, cfg.Name, index)
+	vmCfg.Type = pool.Type
+	override := func(v *string, pv string) {
+		if pv != "" {
+			*v = pv
+		}
+	}
+	override(&vmCfg.Kernel, pool.Kernel)
+	override(&vmCfg.Image, pool.Image)
+	override(&vmCfg.Initrd, pool.Initrd)
+	override(&vmCfg.Sshkey, pool.Sshkey)
+	override(&vmCfg.Bin, pool.Bin)
+	override(&vmCfg.BinArgs, pool.Bin_Args)
+	override(&vmCfg.MachineType, pool.Machine_Type)
+	if pool.Cpu != 0 {
+		vmCfg.Cpu = pool.Cpu
+	}
+	if pool.Mem != 0 {
+		vmCfg.Mem = pool.Mem
+	}
+	if len(pool.Devices) != 0 {
+		vmCfg.Device = pool.Devices[poolIndex]
 	}
 	return vmCfg, nil
 }
 
-func checkUnknownFields(data []byte) (string, error) {
-	// While https://github.com/golang/go/issues/15314 is not resolved
-	// we don't have a better way than to enumerate all known fields.
-	var fields = []string{
-		"Name",
-		"Http",
-		"Rpc",
-		"Workdir",
-		"Vmlinux",
-		"Kernel",
-		"Tag",
-		"Cmdline",
-		"Image",
-		"Cpu",
-		"Mem",
-		"Sshkey",
-		"Bin",
-		"Bin_Args",
-		"Debug",
-		"Output",
-		"Hub_Addr",
-		"Hub_Key",
-		"Syzkaller",
-		"Type",
-		"Count",
-		"Devices",
-		"Procs",
-		"Cover",
-		"Reproduce",
-		"Sandbox",
-		"Leak",
-		"Enable_Syscalls",
-		"Disable_Syscalls",
-		"Suppressions",
-		"Ignores",
-		"Initrd",
-		"Machine_Type",
-	}
-	f := make(map[string]interface{})
-	if err := json.Unmarshal(data, &f); err != nil {
-		return "", fmt.Errorf("failed to parse config file: %v", err)
-	}
-	for k := range f {
-		ok := false
-		for _, k1 := range fields {
-			if strings.ToLower(k) == strings.ToLower(k1) {
-				ok = true
-				break
-			}
-		}
-		if !ok {
-			return k, nil
+// poolForIndex returns the pool that VM with the global index belongs to
+// and index of the VM within the pool.
+func poolForIndex(cfg *Config, index int) (*Pool, int) {
+	for _, pool := range cfg.Pools {
+		if index < pool.Count {
+			return pool, index
 		}
+		index -= pool.Count
 	}
-	return "", nil
+	panic("bad VM index")
 }
@@ -4,6 +4,11 @@
 package config
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -14,3 +19,264 @@ func TestUnknown(t *testing.T) {
 		t.Fatalf("unknown field is not detected (%v)", err)
 	}
 }
+
+func TestPools(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syz-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, bin := range []string{"syz-fuzzer", "syz-executor"} {
+		if err := os.MkdirAll(filepath.Join(dir, "bin"), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, "bin", bin), nil, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data := fmt.Sprintf(`{
+		"http": "localhost:0",
+		"workdir": "%[1]v",
+		"vmlinux": "vmlinux",
+		"syzkaller": "%[1]v",
+		"mem": 1024,
+		"pools": [
+			{"type": "qemu", "count": 2, "mem": 2048},
+			{"type": "adb", "devices": ["dev0", "dev1", "dev2"]}
+		]
+	}`, dir)
+	cfg, _, err := parse([]byte(data))
+	if err != nil {
+		t.Fatalf("failed to parse config: %v", err)
+	}
+	if cfg.Count != 5 {
+		t.Fatalf("bad total count: %v, want 5", cfg.Count)
+	}
+	tests := []struct {
+		typ    string
+		device string
+		mem    int
+	}{
+		{"qemu", "", 2048},
+		{"qemu", "", 2048},
+		{"adb", "dev0", 1024},
+		{"adb", "dev1", 1024},
+		{"adb", "dev2", 1024},
+	}
+	for i, test := range tests {
+		vmCfg, err := CreateVMConfig(cfg, i)
+		if err != nil {
+			t.Fatalf("failed to create VM config: %v", err)
+		}
+		os.RemoveAll(vmCfg.Workdir)
+		if vmCfg.Type != test.typ || vmCfg.Device != test.device || vmCfg.Mem != test.mem {
+			t.Errorf("VM #%v: got type=%v device=%v mem=%v, want type=%v device=%v mem=%v",
+				i, vmCfg.Type, vmCfg.Device, vmCfg.Mem, test.typ, test.device, test.mem)
+		}
+	}
+	if _, err := CreateVMConfig(cfg, 5); err == nil {
+		t.Fatalf("VM index out of range is not detected")
+	}
+}
+
+func TestInclude(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syz-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("SYZ_CONFIG_TEST_NAME", "test-manager")
+	defer os.Unsetenv("SYZ_CONFIG_TEST_NAME")
+	files := map[string]string{
+		"base.cfg":      `{"Http": "localhost:0", "procs": 4, "name": "base", "suppressions": ["foo$"]}`,
+		"sub/mgr.cfg":   `{"include": "../base.cfg", "name": "${SYZ_CONFIG_TEST_NAME}-${SYZ_CONFIG_TEST_NAME}", "procs": 8}`,
+		"top.cfg":       `{"include": ["sub/mgr.cfg"], "workdir": "/${SYZ_CONFIG_TEST_NAME}/workdir"}`,
+		"cycle.cfg":     `{"include": "cycle2.cfg"}`,
+		"cycle2.cfg":    `{"include": "cycle.cfg"}`,
+		"undefined.cfg": `{"name": "${SYZ_CONFIG_TEST_UNDEFINED}"}`,
+	}
+	for name, data := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fields, err := load(filepath.Join(dir, "top.cfg"), nil)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	want := map[string]interface{}{
+		"http":         "localhost:0",
+		"procs":        float64(8),
+		"name":         "test-manager-test-manager",
+		"suppressions": []interface{}{"foo$"},
+		"workdir":      "/test-manager/workdir",
+	}
+	if !reflect.DeepEqual(fields, want) {
+		t.Fatalf("bad config:\n%+v\nwant:\n%+v", fields, want)
+	}
+	for _, name := range []string{"cycle.cfg", "undefined.cfg", "missing.cfg"} {
+		if _, err := load(filepath.Join(dir, name), nil); err == nil {
+			t.Errorf("loading %v did not fail", name)
+		}
+	}
+}
+
+func TestStrict(t *testing.T) {
+	tests := []struct {
+		data string
+		err  string
+	}{
+		{`{"workdri": "dir"}`, "unknown field 'workdri' in config (did you mean 'workdir'?)"},
+		{`{"Sandbx": "none"}`, "unknown field 'sandbx' in config (did you mean 'sandbox'?)"},
+		{`{"pools": [{"type": "qemu"}, {"cout": 1}]}`, "unknown field 'pools[1].cout' in config (did you mean 'count'?)"},
+		{`{"procs": "8"}`, `config param procs: want integer, got string "8"`},
+		{`{"procs": 1.5}`, `config param procs: want integer, got number 1.5`},
+		{`{"cover": "true"}`, `config param cover: want bool, got string "true"`},
+		{`{"enable_syscalls": "open"}`, `config param enable_syscalls: want list, got string "open"`},
+		{`{"enable_syscalls": ["open", 1]}`, `config param enable_syscalls[1]: want string, got number 1`},
+		{`{"mutation_strategies": {"splice": "1"}}`, `config param mutation_strategies["splice"]: want integer, got string "1"`},
+		{`{"pools": [{"mem": false}]}`, `config param pools[0].mem: want integer, got bool false`},
+		{`{"parsedsuppressions": []}`, "unknown field 'parsedsuppressions' in config (did you mean 'suppressions'?)"},
+		{`{"sandbox": "foo", "http": ""}`, ""},
+	}
+	for _, test := range tests {
+		cfg := new(Config)
+		err := LoadData([]byte(test.data), cfg)
+		if test.err == "" && err != nil || test.err != "" && (err == nil || err.Error() != test.err) {
+			t.Errorf("config %v: got error %v, want %v", test.data, err, test.err)
+		}
+	}
+	if err := CheckSandbox("foo"); err == nil {
+		t.Errorf("bad sandbox is not detected")
+	}
+}
+
+func TestFormats(t *testing.T) {
+	jsonCfg := `{
+		"http": "localhost:50000",
+		"workdir": "/syzkaller/workdir",
+		"procs": 8,
+		"cover": false,
+		"leak": true,
+		"fault_percent": 16,
+		"enable_syscalls": ["open", "read$eventfd", "don't"],
+		"suppressions": ["some known bug # not a comment", "it's"],
+		"mutation_strategies": {"splice": 1, "insert_call": 60},
+		"pools": [
+			{"type": "qemu", "count": 2, "mem": 2048},
+			{"type": "adb", "devices": ["dev0", "dev1"]}
+		],
+		"addr_layout": [],
+		"tag": null,
+		"name": "a: b"
+	}`
+	yamlCfg := `
+# Comment.
+---
+http: localhost:50000
+workdir: "/syzkaller/workdir"   # trailing comment
+procs: 8
+cover: false
+leak: True
+fault_percent: 0x10
+enable_syscalls: [open, "read$eventfd", don't]
+suppressions:
+- "some known bug # not a comment" # comment
+- 'it''s'
+mutation_strategies: {splice: 1, insert_call: 60}
+pools:
+  - type: qemu
+    count: 2
+    mem: 2048
+  -
+    type: adb
+    devices:
+      - dev0
+      - dev1
+addr_layout: []
+tag:
+name: "a: b"
+`
+	tomlCfg := `
+# Comment.
+http = "localhost:50000"
+workdir = '/syzkaller/workdir'   # trailing comment
+procs = 8
+cover = false
+leak = true
+fault_percent = 0x10
+enable_syscalls = [
+	"open",
+	"read$eventfd", # comment
+	"don't",
+]
+suppressions = ["some known bug # not a comment", "it's"]
+addr_layout = []
+name = "a: b"
+
+[mutation_strategies]
+splice = 1
+"insert_call" = 6_0
+
+[[pools]]
+type = "qemu"
+count = 2
+mem = 2048
+
+[[pools]]
+type = "adb"
+devices = ["dev0", "dev1"]
+`
+	want, err := decode("cfg.json", []byte(jsonCfg))
+	if err != nil {
+		t.Fatalf("failed to parse json: %v", err)
+	}
+	for _, file := range []string{"cfg.yaml", "cfg.yml"} {
+		got, err := decode(file, []byte(yamlCfg))
+		if err != nil {
+			t.Fatalf("failed to parse yaml: %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("bad yaml config:\n%#v\nwant:\n%#v", got, want)
+		}
+	}
+	got, err := decode("cfg.toml", []byte(tomlCfg))
+	if err != nil {
+		t.Fatalf("failed to parse toml: %v", err)
+	}
+	delete(want, "tag") // TOML has no null
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("bad toml config:\n%#v\nwant:\n%#v", got, want)
+	}
+}
+
+func TestFormatErrors(t *testing.T) {
+	tests := []struct {
+		file string
+		data string
+		err  string
+	}{
+		{"cfg.yaml", "a: 1\n  b: 2", "line 2: bad indentation"},
+		{"cfg.yaml", "a: 1\na: 2", "line 2: duplicate key a"},
+		{"cfg.yaml", "a: [1, 2", "line 1: want ',' or ']', got end of line"},
+		{"cfg.yaml", "a: |\n  text", "line 1: multi-line strings are not supported"},
+		{"cfg.yaml", "- a\n- b", "config must be a mapping"},
+		{"cfg.yaml", "a:\n\t- b", "line 2: tabs can't be used for indentation"},
+		{"cfg.yaml", "just text", `line 1: want 'key: value', got "just text"`},
+		{"cfg.toml", "a = b", `line 1: bad value "b" (strings must be quoted)`},
+		{"cfg.toml", "a = 1\na = 2", "line 2: duplicate key a"},
+		{"cfg.toml", "a = 1\n[a]", "line 2: key a is not a table"},
+		{"cfg.toml", "a = \"\"\"\ntext\"\"\"", "line 1: multi-line strings are not supported"},
+		{"cfg.toml", "a", `line 1: want 'key = value', got "a"`},
+		{"cfg.toml", "a b = 1", `line 1: bad key "a b"`},
+	}
+	for _, test := range tests {
+		_, err := decode(test.file, []byte(test.data))
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%v %q: got error %v, want %v", test.file, test.data, err, test.err)
+		}
+	}
+}
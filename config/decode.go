@@ -0,0 +1,238 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// LoadFile reads the config file (see Parse for includes and environment variables)
+// and strictly decodes it into cfg (a pointer to a struct), see LoadData.
+func LoadFile(filename string, cfg interface{}) error {
+	if filename == "" {
+		return fmt.Errorf("supply config in -config flag")
+	}
+	fields, err := load(filename, nil)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return fmt.Errorf("failed to serialize config: %v", err)
+	}
+	return LoadData(data, cfg)
+}
+
+// LoadData decodes json config data into cfg (a pointer to a struct).
+// Unlike json.Unmarshal, unknown fields and values of wrong types are errors
+// that name the offending param (e.g. "pools[1].count") and suggest similar known fields.
+// Fields of cfg that are not present in data are left intact, so cfg can be pre-filled with defaults.
+func LoadData(data []byte, cfg interface{}) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return fmt.Errorf("failed to parse config file: %v", err)
+	}
+	if err := checkValue("", v, reflect.TypeOf(cfg).Elem()); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return fmt.Errorf("failed to parse config file: %v", err)
+	}
+	return nil
+}
+
+// checkValue checks that the decoded json value v can be stored in a value of type typ.
+// path is the name of the value in error messages.
+func checkValue(path string, v interface{}, typ reflect.Type) error {
+	if v == nil {
+		// json leaves the value intact.
+		return nil
+	}
+	switch typ.Kind() {
+	case reflect.Ptr:
+		return checkValue(path, v, typ.Elem())
+	case reflect.Interface:
+		return nil
+	case reflect.String:
+		if _, ok := v.(string); ok {
+			return nil
+		}
+	case reflect.Bool:
+		if _, ok := v.(bool); ok {
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if f, ok := v.(float64); ok && f == float64(int64(f)) {
+			return nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if f, ok := v.(float64); ok && f == float64(int64(f)) && f >= 0 {
+			return nil
+		}
+	case reflect.Float32, reflect.Float64:
+		if _, ok := v.(float64); ok {
+			return nil
+		}
+	case reflect.Slice, reflect.Array:
+		if elems, ok := v.([]interface{}); ok {
+			for i, elem := range elems {
+				if err := checkValue(fmt.Sprintf("%v[%v]", path, i), elem, typ.Elem()); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case reflect.Map:
+		if fields, ok := v.(map[string]interface{}); ok {
+			for _, k := range sortedKeys(fields) {
+				elem := fields[k]
+				if err := checkValue(fmt.Sprintf("%v[%q]", path, k), elem, typ.Elem()); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case reflect.Struct:
+		if fields, ok := v.(map[string]interface{}); ok {
+			return checkStruct(path, fields, typ)
+		}
+	default:
+		return nil
+	}
+	return fmt.Errorf("config param %v: want %v, got %v", path, typeName(typ), jsonTypeName(v))
+}
+
+func checkStruct(path string, fields map[string]interface{}, typ reflect.Type) error {
+	var names []string
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if f.PkgPath != "" || f.Tag.Get("json") == "-" {
+			continue
+		}
+		names = append(names, f.Name)
+	}
+	for _, k := range sortedKeys(fields) {
+		v := fields[k]
+		name := ""
+		for _, name1 := range names {
+			if strings.ToLower(k) == strings.ToLower(name1) {
+				name = name1
+				break
+			}
+		}
+		fieldPath := strings.ToLower(k)
+		if path != "" {
+			fieldPath = path + "." + fieldPath
+		}
+		if name == "" {
+			if hint := suggest(k, names); hint != "" {
+				return fmt.Errorf("unknown field '%v' in config (did you mean '%v'?)", fieldPath, strings.ToLower(hint))
+			}
+			return fmt.Errorf("unknown field '%v' in config", fieldPath)
+		}
+		f, _ := typ.FieldByName(name)
+		if err := checkValue(fieldPath, v, f.Type); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sortedKeys returns keys of the json object in sorted order, so that errors are deterministic.
+func sortedKeys(fields map[string]interface{}) []string {
+	var keys []string
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// suggest returns the name from names that is the most similar to the unknown name,
+// or "" if none of them is similar enough.
+func suggest(name string, names []string) string {
+	best, bestDist := "", len(name)/3+1
+	for _, name1 := range names {
+		if dist := editDistance(strings.ToLower(name), strings.ToLower(name1)); dist < bestDist {
+			best, bestDist = name1, dist
+		}
+	}
+	return best
+}
+
+// editDistance returns Levenshtein distance between the strings.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func min(vals ...int) int {
+	res := vals[0]
+	for _, v := range vals[1:] {
+		if res > v {
+			res = v
+		}
+	}
+	return res
+}
+
+func typeName(typ reflect.Type) string {
+	switch typ.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "integer"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "non-negative integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "list"
+	case reflect.Map, reflect.Struct:
+		return "object"
+	}
+	return typ.Kind().String()
+}
+
+func jsonTypeName(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return fmt.Sprintf("string %q", v)
+	case float64:
+		return fmt.Sprintf("number %v", v)
+	case bool:
+		return fmt.Sprintf("bool %v", v)
+	case []interface{}:
+		return "list"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// CheckSandbox checks the sandbox param shared by syz-manager and syz-gce configs.
+func CheckSandbox(sandbox string) error {
+	switch sandbox {
+	case "none", "setuid", "namespace":
+		return nil
+	}
+	return fmt.Errorf("config param sandbox must contain one of none/setuid/namespace, got %q", sandbox)
+}
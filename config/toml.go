@@ -0,0 +1,203 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parseTOML parses a config in a subset of TOML: key/value pairs (including dotted keys),
+// [tables], [[arrays of tables]], basic and literal strings, integers, floats, booleans,
+// arrays (can span several lines) and inline tables. Multi-line strings and dates are not supported.
+func parseTOML(data []byte) (map[string]interface{}, error) {
+	root := make(map[string]interface{})
+	cur := root
+	lines := strings.Split(string(data), "\n")
+	for i := 0; i < len(lines); i++ {
+		num := i + 1
+		line := strings.TrimSpace(stripComment(lines[i]))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[[") {
+			if !strings.HasSuffix(line, "]]") {
+				return nil, fmt.Errorf("line %v: bad array of tables header %v", num, line)
+			}
+			keys, err := splitTOMLKey(line[2 : len(line)-2])
+			if err != nil {
+				return nil, fmt.Errorf("line %v: %v", num, err)
+			}
+			parent, err := tomlTable(root, keys[:len(keys)-1])
+			if err != nil {
+				return nil, fmt.Errorf("line %v: %v", num, err)
+			}
+			last := keys[len(keys)-1]
+			arr, ok := parent[last].([]interface{})
+			if parent[last] != nil && !ok {
+				return nil, fmt.Errorf("line %v: key %v is not an array of tables", num, last)
+			}
+			cur = make(map[string]interface{})
+			parent[last] = append(arr, cur)
+			continue
+		}
+		if line[0] == '[' {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("line %v: bad table header %v", num, line)
+			}
+			keys, err := splitTOMLKey(line[1 : len(line)-1])
+			if err != nil {
+				return nil, fmt.Errorf("line %v: %v", num, err)
+			}
+			if cur, err = tomlTable(root, keys); err != nil {
+				return nil, fmt.Errorf("line %v: %v", num, err)
+			}
+			continue
+		}
+		eq := indexUnquoted(line, '=')
+		if eq == -1 {
+			return nil, fmt.Errorf("line %v: want 'key = value', got %q", num, line)
+		}
+		keys, err := splitTOMLKey(line[:eq])
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %v", num, err)
+		}
+		value := strings.TrimSpace(line[eq+1:])
+		// Arrays and inline tables can span several lines.
+		for bracketDepth(value) > 0 && i+1 < len(lines) {
+			i++
+			value += " " + strings.TrimSpace(stripComment(lines[i]))
+		}
+		table, err := tomlTable(cur, keys[:len(keys)-1])
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %v", num, err)
+		}
+		last := keys[len(keys)-1]
+		if _, dup := table[last]; dup {
+			return nil, fmt.Errorf("line %v: duplicate key %v", num, last)
+		}
+		if table[last], err = parseFlow(value, true); err != nil {
+			return nil, fmt.Errorf("line %v: %v", num, err)
+		}
+	}
+	return root, nil
+}
+
+// tomlTable returns the (possibly nested) table for the keys, creating missing tables.
+// For arrays of tables the last table of the array is used.
+func tomlTable(m map[string]interface{}, keys []string) (map[string]interface{}, error) {
+	for _, key := range keys {
+		switch v := m[key].(type) {
+		case nil:
+			t := make(map[string]interface{})
+			m[key] = t
+			m = t
+		case map[string]interface{}:
+			m = v
+		case []interface{}:
+			t, ok := v[len(v)-1].(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("key %v is not a table", key)
+			}
+			m = t
+		default:
+			return nil, fmt.Errorf("key %v is not a table", key)
+		}
+	}
+	return m, nil
+}
+
+// splitTOMLKey splits a dotted key (a.b."c.d") into parts.
+func splitTOMLKey(s string) ([]string, error) {
+	var keys []string
+	for {
+		s = strings.TrimSpace(s)
+		var key string
+		if s != "" && (s[0] == '"' || s[0] == '\'') {
+			p := &flowParser{s: s, toml: true}
+			var err error
+			if key, err = p.quoted(); err != nil {
+				return nil, err
+			}
+			s = strings.TrimSpace(s[p.pos:])
+		} else {
+			end := strings.IndexByte(s, '.')
+			if end == -1 {
+				end = len(s)
+			}
+			key = strings.TrimSpace(s[:end])
+			s = s[end:]
+			if key == "" || strings.IndexFunc(key, func(c rune) bool {
+				return !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-')
+			}) != -1 {
+				return nil, fmt.Errorf("bad key %q", key)
+			}
+		}
+		keys = append(keys, key)
+		if s == "" {
+			return keys, nil
+		}
+		if s[0] != '.' {
+			return nil, fmt.Errorf("bad key, unexpected %q", s)
+		}
+		s = s[1:]
+	}
+}
+
+// tomlScalar resolves an unquoted TOML value.
+func tomlScalar(s string) (interface{}, error) {
+	switch s {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	if f, ok := parseNumber(strings.Replace(s, "_", "", -1)); ok {
+		return f, nil
+	}
+	return nil, fmt.Errorf("bad value %q (strings must be quoted)", s)
+}
+
+// indexUnquoted returns index of the first c outside of quoted strings, or -1.
+func indexUnquoted(s string, c byte) int {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quote != 0:
+			if s[i] == '\\' && quote == '"' {
+				i++
+			} else if s[i] == quote {
+				quote = 0
+			}
+		case s[i] == '"' || s[i] == '\'':
+			quote = s[i]
+		case s[i] == c:
+			return i
+		}
+	}
+	return -1
+}
+
+// bracketDepth returns the number of unclosed brackets and braces in s (outside of quoted strings).
+func bracketDepth(s string) int {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quote != 0:
+			if s[i] == '\\' && quote == '"' {
+				i++
+			} else if s[i] == quote {
+				quote = 0
+			}
+		case s[i] == '"' || s[i] == '\'':
+			quote = s[i]
+		case s[i] == '[' || s[i] == '{':
+			depth++
+		case s[i] == ']' || s[i] == '}':
+			depth--
+		}
+	}
+	return depth
+}
@@ -0,0 +1,436 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package config
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// YAML and TOML configs are decoded into the same values as json.Unmarshal produces
+// (map[string]interface{}, []interface{}, string, float64, bool and nil),
+// so the rest of config loading does not depend on the format.
+// Only the subsets of the formats that are needed for configs are supported.
+
+// parseYAML parses a config in a subset of YAML: block mappings and sequences,
+// flow sequences and mappings ([a, b], {a: 1}), plain, single- and double-quoted scalars and comments.
+// Multi-line scalars, anchors, tags and multiple documents are not supported.
+func parseYAML(data []byte) (map[string]interface{}, error) {
+	p := new(yamlParser)
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(stripComment(line), " \t\r")
+		text := strings.TrimLeft(line, " ")
+		if text == "" || line == "---" {
+			continue
+		}
+		if text[0] == '\t' {
+			return nil, fmt.Errorf("line %v: tabs can't be used for indentation", i+1)
+		}
+		p.lines = append(p.lines, yamlLine{i + 1, len(line) - len(text), text})
+	}
+	if len(p.lines) == 0 {
+		return make(map[string]interface{}), nil
+	}
+	v, err := p.block(p.lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.lines) {
+		return nil, fmt.Errorf("line %v: bad indentation", p.lines[p.pos].num)
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("config must be a mapping")
+	}
+	return m, nil
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+type yamlLine struct {
+	num    int
+	indent int
+	text   string // without indentation and comments
+}
+
+func (l yamlLine) errorf(msg string, args ...interface{}) error {
+	return fmt.Errorf("line %v: %v", l.num, fmt.Sprintf(msg, args...))
+}
+
+func (p *yamlParser) block(indent int) (interface{}, error) {
+	if isSeqItem(p.lines[p.pos].text) {
+		return p.sequence(indent)
+	}
+	return p.mapping(indent)
+}
+
+func (p *yamlParser) mapping(indent int) (interface{}, error) {
+	m := make(map[string]interface{})
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if l.indent < indent {
+			break
+		}
+		if l.indent > indent {
+			return nil, l.errorf("bad indentation")
+		}
+		key, rest, ok, err := splitYAMLKey(l.text)
+		if err != nil {
+			return nil, l.errorf("%v", err)
+		}
+		if !ok {
+			return nil, l.errorf("want 'key: value', got %q", l.text)
+		}
+		if _, dup := m[key]; dup {
+			return nil, l.errorf("duplicate key %v", key)
+		}
+		p.pos++
+		if rest != "" {
+			v, err := parseFlow(rest, false)
+			if err != nil {
+				return nil, l.errorf("%v", err)
+			}
+			m[key] = v
+			continue
+		}
+		// The value is on the following lines, a sequence can have the same indentation as the key.
+		m[key] = nil
+		if p.pos < len(p.lines) {
+			next := p.lines[p.pos]
+			if next.indent > indent || next.indent == indent && isSeqItem(next.text) {
+				v, err := p.block(next.indent)
+				if err != nil {
+					return nil, err
+				}
+				m[key] = v
+			}
+		}
+	}
+	return m, nil
+}
+
+func (p *yamlParser) sequence(indent int) (interface{}, error) {
+	s := []interface{}{}
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if l.indent > indent {
+			return nil, l.errorf("bad indentation")
+		}
+		if l.indent < indent || !isSeqItem(l.text) {
+			break
+		}
+		rest := strings.TrimLeft(l.text[1:], " ")
+		if rest == "" {
+			p.pos++
+			var v interface{}
+			if p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+				var err error
+				if v, err = p.block(p.lines[p.pos].indent); err != nil {
+					return nil, err
+				}
+			}
+			s = append(s, v)
+			continue
+		}
+		if _, _, ok, _ := splitYAMLKey(rest); ok || isSeqItem(rest) {
+			// Compact nested collection ("- key: value"), it continues at the indentation of rest.
+			p.lines[p.pos] = yamlLine{l.num, indent + len(l.text) - len(rest), rest}
+			v, err := p.block(p.lines[p.pos].indent)
+			if err != nil {
+				return nil, err
+			}
+			s = append(s, v)
+			continue
+		}
+		p.pos++
+		v, err := parseFlow(rest, false)
+		if err != nil {
+			return nil, l.errorf("%v", err)
+		}
+		s = append(s, v)
+	}
+	return s, nil
+}
+
+func isSeqItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// splitYAMLKey splits "key: value" line, ok is false if the line is not a mapping entry.
+func splitYAMLKey(text string) (key, rest string, ok bool, err error) {
+	if text == "" || text[0] == '[' || text[0] == '{' || isSeqItem(text) {
+		return "", "", false, nil
+	}
+	pos := 0
+	if text[0] == '"' || text[0] == '\'' {
+		fp := &flowParser{s: text}
+		if key, err = fp.quoted(); err != nil {
+			return "", "", false, err
+		}
+		pos = fp.pos
+		if pos != len(text) && text[pos] != ':' {
+			return "", "", false, nil
+		}
+	} else {
+		pos = strings.Index(text, ": ")
+		if pos == -1 && strings.HasSuffix(text, ":") {
+			pos = len(text) - 1
+		}
+		if pos == -1 {
+			return "", "", false, nil
+		}
+		key = strings.TrimSpace(text[:pos])
+	}
+	if pos == len(text) || pos+1 != len(text) && text[pos+1] != ' ' {
+		return "", "", false, nil
+	}
+	return key, strings.TrimSpace(text[pos+1:]), true, nil
+}
+
+// stripComment removes '#' comment from the line (a '#' inside of a quoted string is not a comment).
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			// A quote in the middle of a plain scalar (e.g. don't) does not start a string.
+			if i == 0 || strings.IndexByte(" \t:-[{,=", line[i-1]) != -1 {
+				quote = c
+			}
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// flowParser parses a single-line value: a scalar, a flow sequence [a, b] or a flow mapping {a: b}.
+// In TOML mode inline tables use '=' ({a = b}) and strings must be quoted.
+type flowParser struct {
+	s    string
+	pos  int
+	toml bool
+}
+
+func parseFlow(s string, toml bool) (interface{}, error) {
+	if strings.HasPrefix(s, `"""`) || strings.HasPrefix(s, "'''") || s == "|" || s == ">" {
+		return nil, fmt.Errorf("multi-line strings are not supported")
+	}
+	p := &flowParser{s: s, toml: toml}
+	v, err := p.value(false)
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos != len(p.s) {
+		return nil, fmt.Errorf("unexpected %q after value", p.s[p.pos:])
+	}
+	return v, nil
+}
+
+func (p *flowParser) value(inFlow bool) (interface{}, error) {
+	p.skipSpace()
+	if p.pos == len(p.s) {
+		if p.toml {
+			return nil, fmt.Errorf("missing value")
+		}
+		return nil, nil
+	}
+	switch p.s[p.pos] {
+	case '[':
+		p.pos++
+		list := []interface{}{}
+		for {
+			p.skipSpace()
+			if p.consume(']') {
+				return list, nil
+			}
+			v, err := p.value(true)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+			p.skipSpace()
+			if p.consume(']') {
+				return list, nil
+			}
+			if !p.consume(',') {
+				return nil, p.unexpected("',' or ']'")
+			}
+		}
+	case '{':
+		p.pos++
+		sep := byte(':')
+		if p.toml {
+			sep = '='
+		}
+		m := make(map[string]interface{})
+		for {
+			p.skipSpace()
+			if p.consume('}') {
+				return m, nil
+			}
+			key, err := p.key(sep)
+			if err != nil {
+				return nil, err
+			}
+			if _, dup := m[key]; dup {
+				return nil, fmt.Errorf("duplicate key %v", key)
+			}
+			p.skipSpace()
+			if !p.consume(sep) {
+				return nil, p.unexpected(fmt.Sprintf("'%c'", sep))
+			}
+			if m[key], err = p.value(true); err != nil {
+				return nil, err
+			}
+			p.skipSpace()
+			if p.consume('}') {
+				return m, nil
+			}
+			if !p.consume(',') {
+				return nil, p.unexpected("',' or '}'")
+			}
+		}
+	case '"', '\'':
+		return p.quoted()
+	}
+	start := p.pos
+	for p.pos < len(p.s) && (!inFlow || strings.IndexByte(",]}", p.s[p.pos]) == -1) {
+		p.pos++
+	}
+	s := strings.TrimSpace(p.s[start:p.pos])
+	if p.toml {
+		return tomlScalar(s)
+	}
+	return yamlScalar(s), nil
+}
+
+func (p *flowParser) key(sep byte) (string, error) {
+	p.skipSpace()
+	if p.pos < len(p.s) && (p.s[p.pos] == '"' || p.s[p.pos] == '\'') {
+		return p.quoted()
+	}
+	start := p.pos
+	for p.pos < len(p.s) && p.s[p.pos] != sep && p.s[p.pos] != ',' && p.s[p.pos] != '}' {
+		p.pos++
+	}
+	key := strings.TrimSpace(p.s[start:p.pos])
+	if key == "" {
+		return "", p.unexpected("key")
+	}
+	return key, nil
+}
+
+// quoted parses a double-quoted string with escape sequences or a single-quoted string
+// (a doubled quote stands for a quote in YAML, TOML literal strings have no escapes).
+func (p *flowParser) quoted() (string, error) {
+	q := p.s[p.pos]
+	end := p.pos + 1
+	for ; end < len(p.s); end++ {
+		if p.s[end] == '\\' && q == '"' {
+			end++
+			continue
+		}
+		if p.s[end] == q {
+			if q == '\'' && !p.toml && end+1 < len(p.s) && p.s[end+1] == '\'' {
+				end++
+				continue
+			}
+			break
+		}
+	}
+	if end >= len(p.s) {
+		return "", fmt.Errorf("unterminated string %v", p.s[p.pos:])
+	}
+	raw := p.s[p.pos : end+1]
+	p.pos = end + 1
+	if q == '"' {
+		s, err := strconv.Unquote(raw)
+		if err != nil {
+			return "", fmt.Errorf("bad string %v", raw)
+		}
+		return s, nil
+	}
+	s := raw[1 : len(raw)-1]
+	if !p.toml {
+		s = strings.Replace(s, "''", "'", -1)
+	}
+	return s, nil
+}
+
+func (p *flowParser) skipSpace() {
+	for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *flowParser) consume(c byte) bool {
+	if p.pos < len(p.s) && p.s[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *flowParser) unexpected(want string) error {
+	if p.pos == len(p.s) {
+		return fmt.Errorf("want %v, got end of line", want)
+	}
+	return fmt.Errorf("want %v, got %q", want, p.s[p.pos:])
+}
+
+// yamlScalar resolves a plain scalar according to the YAML core schema.
+func yamlScalar(s string) interface{} {
+	switch s {
+	case "", "~", "null", "Null", "NULL":
+		return nil
+	case "true", "True", "TRUE":
+		return true
+	case "false", "False", "FALSE":
+		return false
+	}
+	if f, ok := parseNumber(s); ok {
+		return f
+	}
+	return s
+}
+
+// parseNumber parses decimal integers and floats and hex integers (0x...).
+func parseNumber(s string) (float64, bool) {
+	t := s
+	if t != "" && (t[0] == '-' || t[0] == '+') {
+		t = t[1:]
+	}
+	if strings.HasPrefix(t, "0x") {
+		v, err := strconv.ParseUint(t[2:], 16, 64)
+		if err != nil {
+			return 0, false
+		}
+		if s[0] == '-' {
+			return -float64(v), true
+		}
+		return float64(v), true
+	}
+	if t == "" || (t[0] < '0' || t[0] > '9') && t[0] != '.' {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+		return 0, false
+	}
+	return f, true
+}
@@ -5,6 +5,8 @@
 package cover
 
 import (
+	"encoding/binary"
+	"fmt"
 	"sort"
 )
 
@@ -156,3 +158,32 @@ type minInputArray []*minInput
 func (a minInputArray) Len() int           { return len(a) }
 func (a minInputArray) Less(i, j int) bool { return len(a[i].cov) > len(a[j].cov) }
 func (a minInputArray) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+// Serialize encodes canonical coverage compactly: as varint deltas between sorted PCs.
+func Serialize(cov Cover) []byte {
+	buf := make([]byte, 0, len(cov)*2)
+	tmp := make([]byte, binary.MaxVarintLen32)
+	prev := uint32(0)
+	for _, pc := range cov {
+		n := binary.PutUvarint(tmp, uint64(pc-prev))
+		buf = append(buf, tmp[:n]...)
+		prev = pc
+	}
+	return buf
+}
+
+// Deserialize decodes coverage encoded with Serialize.
+func Deserialize(data []byte) (Cover, error) {
+	var cov Cover
+	prev := uint64(0)
+	for len(data) != 0 {
+		v, n := binary.Uvarint(data)
+		if n <= 0 || prev+v > uint64(sent) {
+			return nil, fmt.Errorf("bad coverage encoding")
+		}
+		prev += v
+		cov = append(cov, uint32(prev))
+		data = data[n:]
+	}
+	return cov, nil
+}
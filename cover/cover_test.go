@@ -4,6 +4,8 @@
 package cover
 
 import (
+	"bytes"
+	"encoding/json"
 	"math/rand"
 	"reflect"
 	"sort"
@@ -233,3 +235,107 @@ func BenchmarkHasDifference(b *testing.B) {
 		_ = HasDifference(cov1, cov0)
 	}
 }
+
+func TestSerialize(t *testing.T) {
+	rnd, iters := initTest(t)
+	for i := 0; i < iters/100; i++ {
+		cov := make(Cover, rnd.Intn(1000))
+		for j := range cov {
+			cov[j] = rnd.Uint32()
+		}
+		cov = Canonicalize(cov)
+		cov1, err := Deserialize(Serialize(cov))
+		if err != nil {
+			t.Fatalf("failed to deserialize %v: %v", cov, err)
+		}
+		if len(cov) == 0 && len(cov1) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(cov, cov1) {
+			t.Fatalf("coverage changed after serialization:\n%v\n%v", cov, cov1)
+		}
+	}
+	if _, err := Deserialize([]byte{0x80}); err == nil {
+		t.Fatalf("deserialized truncated coverage")
+	}
+}
+
+var exportLines = []LineCover{
+	{"net/socket.c", 20, "sock_close", 0},
+	{"fs/open.c", 10, "do_sys_open", 3},
+	{"net/socket.c", 10, "sock_create", 2},
+	{"fs/open.c", 11, "do_sys_open", 0},
+	{"fs/open.c", 10, "do_sys_open", 5},
+	{"fs/open.c", 12, "do_sys_open", 1},
+	{"net/socket.c", 21, "sock_close", 0},
+}
+
+func TestWriteLCOV(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := WriteLCOV(buf, "test", exportLines); err != nil {
+		t.Fatal(err)
+	}
+	want := `TN:test
+SF:fs/open.c
+FN:10,do_sys_open
+FNDA:5,do_sys_open
+FNF:1
+FNH:1
+DA:10,5
+DA:11,0
+DA:12,1
+LF:3
+LH:2
+end_of_record
+SF:net/socket.c
+FN:20,sock_close
+FN:10,sock_create
+FNDA:0,sock_close
+FNDA:2,sock_create
+FNF:2
+FNH:1
+DA:10,2
+DA:20,0
+DA:21,0
+LF:3
+LH:1
+end_of_record
+`
+	if got := buf.String(); got != want {
+		t.Fatalf("bad lcov output:\n%v\nwant:\n%v", got, want)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := WriteJSON(buf, exportLines); err != nil {
+		t.Fatal(err)
+	}
+	var got JSONCover
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := JSONCover{
+		Lines:   6,
+		Covered: 3,
+		Files: []*JSONFile{
+			{
+				File:    "fs/open.c",
+				Lines:   3,
+				Covered: 2,
+				Hits:    [][2]int{{10, 5}, {11, 0}, {12, 1}},
+				Funcs:   []*JSONFunc{{"do_sys_open", 10, 5}},
+			},
+			{
+				File:    "net/socket.c",
+				Lines:   3,
+				Covered: 1,
+				Hits:    [][2]int{{10, 2}, {20, 0}, {21, 0}},
+				Funcs:   []*JSONFunc{{"sock_close", 20, 0}, {"sock_create", 10, 2}},
+			},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("bad json output:\n%s", buf.Bytes())
+	}
+}
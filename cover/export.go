@@ -0,0 +1,167 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package cover
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// LineCover is coverage of a single source line (e.g. of a symbolized coverage PC).
+// There can be several entries for the same line, they are merged by the exporters.
+type LineCover struct {
+	File string
+	Line int
+	Func string
+	Hits int // how many times the line is covered (e.g. number of inputs), 0 if it is instrumented but not covered
+}
+
+// fileCover is merged coverage of a single source file.
+type fileCover struct {
+	name  string
+	lines map[int]int         // line -> hits
+	funcs map[string]*fnCover // function -> coverage
+}
+
+type fnCover struct {
+	line int // first line
+	hits int
+}
+
+// mergeLines groups the lines by file (in sorted order), hits of the same line are merged with max.
+func mergeLines(lines []LineCover) []*fileCover {
+	files := make(map[string]*fileCover)
+	var names []string
+	for _, ln := range lines {
+		f := files[ln.File]
+		if f == nil {
+			f = &fileCover{ln.File, make(map[int]int), make(map[string]*fnCover)}
+			files[ln.File] = f
+			names = append(names, ln.File)
+		}
+		if hits, ok := f.lines[ln.Line]; !ok || hits < ln.Hits {
+			f.lines[ln.Line] = ln.Hits
+		}
+		if ln.Func == "" {
+			continue
+		}
+		fn := f.funcs[ln.Func]
+		if fn == nil {
+			fn = &fnCover{line: ln.Line}
+			f.funcs[ln.Func] = fn
+		}
+		if fn.line > ln.Line {
+			fn.line = ln.Line
+		}
+		if fn.hits < ln.Hits {
+			fn.hits = ln.Hits
+		}
+	}
+	sort.Strings(names)
+	res := make([]*fileCover, len(names))
+	for i, name := range names {
+		res[i] = files[name]
+	}
+	return res
+}
+
+func (f *fileCover) sortedLines() []int {
+	var lines []int
+	for ln := range f.lines {
+		lines = append(lines, ln)
+	}
+	sort.Ints(lines)
+	return lines
+}
+
+func (f *fileCover) sortedFuncs() []string {
+	var funcs []string
+	for fn := range f.funcs {
+		funcs = append(funcs, fn)
+	}
+	sort.Strings(funcs)
+	return funcs
+}
+
+// WriteLCOV writes coverage as an lcov tracefile (see geninfo(1)), which can be consumed by genhtml
+// and coverage dashboards. testName is the TN record (can be empty).
+func WriteLCOV(w io.Writer, testName string, lines []LineCover) error {
+	buf := bufio.NewWriter(w)
+	fmt.Fprintf(buf, "TN:%v\n", testName)
+	for _, f := range mergeLines(lines) {
+		fmt.Fprintf(buf, "SF:%v\n", f.name)
+		funcs := f.sortedFuncs()
+		funcsHit := 0
+		for _, name := range funcs {
+			fmt.Fprintf(buf, "FN:%v,%v\n", f.funcs[name].line, name)
+		}
+		for _, name := range funcs {
+			fmt.Fprintf(buf, "FNDA:%v,%v\n", f.funcs[name].hits, name)
+			if f.funcs[name].hits != 0 {
+				funcsHit++
+			}
+		}
+		fmt.Fprintf(buf, "FNF:%v\nFNH:%v\n", len(funcs), funcsHit)
+		linesHit := 0
+		for _, ln := range f.sortedLines() {
+			fmt.Fprintf(buf, "DA:%v,%v\n", ln, f.lines[ln])
+			if f.lines[ln] != 0 {
+				linesHit++
+			}
+		}
+		fmt.Fprintf(buf, "LF:%v\nLH:%v\nend_of_record\n", len(f.lines), linesHit)
+	}
+	return buf.Flush()
+}
+
+// JSONCover is the format of WriteJSON output.
+type JSONCover struct {
+	Lines   int         `json:"lines"`   // instrumented lines
+	Covered int         `json:"covered"` // covered lines
+	Files   []*JSONFile `json:"files"`
+}
+
+type JSONFile struct {
+	File    string      `json:"file"`
+	Lines   int         `json:"lines"`
+	Covered int         `json:"covered"`
+	Hits    [][2]int    `json:"hits"` // pairs of line number and hits, sorted by line
+	Funcs   []*JSONFunc `json:"funcs"`
+}
+
+type JSONFunc struct {
+	Func string `json:"func"`
+	Line int    `json:"line"`
+	Hits int    `json:"hits"`
+}
+
+// WriteJSON writes coverage in a simple JSON format (see JSONCover) for custom dashboards.
+func WriteJSON(w io.Writer, lines []LineCover) error {
+	res := &JSONCover{Files: []*JSONFile{}}
+	for _, f := range mergeLines(lines) {
+		file := &JSONFile{File: f.name, Hits: [][2]int{}, Funcs: []*JSONFunc{}}
+		for _, ln := range f.sortedLines() {
+			file.Hits = append(file.Hits, [2]int{ln, f.lines[ln]})
+			if f.lines[ln] != 0 {
+				file.Covered++
+			}
+		}
+		for _, name := range f.sortedFuncs() {
+			file.Funcs = append(file.Funcs, &JSONFunc{name, f.funcs[name].line, f.funcs[name].hits})
+		}
+		file.Lines = len(f.lines)
+		res.Lines += file.Lines
+		res.Covered += file.Covered
+		res.Files = append(res.Files, file)
+	}
+	data, err := json.MarshalIndent(res, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
@@ -2,7 +2,7 @@
 // Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
 
 // I heard you like shell...
-//go:generate bash -c "echo -e '// AUTOGENERATED FROM executor/common.h\npackage csource\nvar commonHeader = `' > common.go; cat ../executor/common.h | sed -e '/#include \"common_kvm_amd64.h\"/ {' -e 'r ../executor/common_kvm_amd64.h' -e 'd' -e '}' - | sed -e '/#include \"common_kvm_arm64.h\"/ {' -e 'r ../executor/common_kvm_arm64.h' -e 'd' -e '}' - | sed -e '/#include \"kvm.h\"/ {' -e 'r ../executor/kvm.h' -e 'd' -e '}' - | sed -e '/#include \"kvm.S.h\"/ {' -e 'r ../executor/kvm.S.h' -e 'd' -e '}' - | egrep -v '^[   ]*//' | sed '/^[ 	]*\\/\\/.*/d' | sed 's#[ 	]*//.*##g' >> common.go; echo '`' >> common.go"
+//go:generate bash -c "echo -e '// AUTOGENERATED FROM executor/common.h\npackage csource\nvar commonHeader = `' > common.go; cat ../executor/common.h | sed -e '/#include \"common_kvm_amd64.h\"/ {' -e 'r ../executor/common_kvm_amd64.h' -e 'd' -e '}' - | sed -e '/#include \"common_kvm_arm64.h\"/ {' -e 'r ../executor/common_kvm_arm64.h' -e 'd' -e '}' - | sed -e '/#include \"kvm.h\"/ {' -e 'r ../executor/kvm.h' -e 'd' -e '}' - | sed -e '/#include \"kvm.S.h\"/ {' -e 'r ../executor/kvm.S.h' -e 'd' -e '}' - | sed -e '/#include \"pseudo.h\"/ {' -e 'r ../executor/pseudo.h' -e 'd' -e '}' - | egrep -v '^[   ]*//' | sed '/^[ 	]*\\/\\/.*/d' | sed 's#[ 	]*//.*##g' >> common.go; echo '`' >> common.go"
 
 package csource
 
@@ -11,6 +11,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"os"
 	"os/exec"
 	"strings"
@@ -26,12 +27,53 @@ type Options struct {
 	Repeat   bool
 	Procs    int
 	Sandbox  string
+	Netns    bool // create a separate network namespace with virtual devices
 	Repro    bool // generate code for use with repro package
+
+	// Harness of the program.
+	NoFork  bool // with Repeat, run iterations in the same process instead of forking a process per iteration
+	Tun     bool // set up tun device even if the program does not use it (fuzzer always does)
+	Minimal bool // one-shot program without sandbox, tmp dir and process setup (Repeat, Procs, Sandbox, Netns and Tun are ignored)
+
+	// Fault injects a fault into FaultNth (0-based) fault site of FaultCall (0-based call index),
+	// the same way executor does it (requires CONFIG_FAULT_INJECTION and debugfs).
+	// Collide is ignored with Fault, because the fault call would be executed twice.
+	Fault     bool
+	FaultCall int
+	FaultNth  int
+
+	// Faithful makes threaded programs execute calls the same way executor does:
+	// on a pool of worker threads, waiting up to CallTimeout ms for every call to complete,
+	// and with Collide, rerunning the program without waiting for every other call.
+	Faithful    bool
+	CallTimeout int // in ms, 20 if not set
+
+	// Profile is the target build environment (see Profiles), the default one if empty.
+	Profile string
 }
 
 func Write(p *prog.Prog, opts Options) ([]byte, error) {
+	profile, err := getProfile(opts.Profile)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Fault {
+		if opts.FaultCall < 0 || opts.FaultCall >= len(p.Calls) {
+			return nil, fmt.Errorf("bad fault call index %v, program has %v calls", opts.FaultCall, len(p.Calls))
+		}
+		if opts.Collide {
+			opts.Collide = false
+			opts.Threaded = true
+		}
+	}
+	if opts.Minimal {
+		opts.Repeat = false
+		opts.Procs = 1
+		opts.Netns = false
+		opts.Tun = false
+	}
 	exec := make([]byte, prog.ExecBufferSize)
-	if err := p.SerializeForExec(exec, 0); err != nil {
+	if _, err := p.SerializeForExec(exec, 0); err != nil {
 		return nil, fmt.Errorf("failed to serialize program: %v", err)
 	}
 	w := new(bytes.Buffer)
@@ -42,7 +84,18 @@ func Write(p *prog.Prog, opts Options) ([]byte, error) {
 	for _, c := range p.Calls {
 		handled[c.Meta.CallName] = c.Meta.NR
 	}
+	if c := sys.CallMap["syz_emit_ethernet"]; opts.Tun && c != nil {
+		// Tun setup code is compiled in only if syz_emit_ethernet is used.
+		handled[c.CallName] = c.NR
+	}
+	if err := profile.checkPseudoCalls(handled); err != nil {
+		return nil, err
+	}
 	for name, nr := range handled {
+		if !profile.native() && !strings.HasPrefix(name, "syz_") {
+			// Numbers of real syscalls are valid only for the host arch.
+			continue
+		}
 		fmt.Fprintf(w, "#ifndef __NR_%v\n", name)
 		fmt.Fprintf(w, "#define __NR_%v %v\n", name, nr)
 		fmt.Fprintf(w, "#endif\n")
@@ -54,40 +107,69 @@ func Write(p *prog.Prog, opts Options) ([]byte, error) {
 		enableTun = "true"
 	}
 
-	hdr, err := preprocessCommonHeader(opts, handled)
+	hdr, err := preprocessCommonHeader(opts, profile, handled)
 	if err != nil {
 		return nil, err
 	}
 	fmt.Fprint(w, hdr)
 	fmt.Fprint(w, "\n")
+	if _, ok := handled["mmap"]; ok && profile.is32Bit() {
+		// mmap is the old one-argument mmap on 32-bit arches.
+		fmt.Fprint(w, "#undef __NR_mmap\n")
+		fmt.Fprint(w, "#define __NR_mmap __NR_mmap2\n\n")
+	}
+	if opts.Faithful && (opts.Threaded || opts.Collide) {
+		fmt.Fprint(w, "#include <linux/futex.h>\n\n")
+	}
 
-	calls, nvar := generateCalls(exec)
+	calls, nvar := generateCalls(exec, opts, profile)
 	fmt.Fprintf(w, "long r[%v];\n", nvar)
 
-	if !opts.Repeat {
+	if opts.Minimal {
 		generateTestFunc(w, opts, calls, "loop")
 
 		fmt.Fprint(w, "int main()\n{\n")
-		fmt.Fprintf(w, "\tsetup_main_process(0, %v);\n", enableTun)
+		generateSetupFault(w, opts)
+		fmt.Fprint(w, "\tinstall_segv_handler();\n")
+		if enableTun == "true" {
+			// The program can't work without tun device.
+			fmt.Fprint(w, "\tinitialize_tun(0);\n")
+		}
+		fmt.Fprint(w, "\tloop();\n")
+		fmt.Fprint(w, "\treturn 0;\n}\n")
+	} else if !opts.Repeat {
+		generateTestFunc(w, opts, calls, "loop")
+
+		fmt.Fprint(w, "int main()\n{\n")
+		generateSetupFault(w, opts)
+		fmt.Fprintf(w, "\tsetup_main_process(0, %v, %v);\n", enableTun, opts.Netns)
 		fmt.Fprintf(w, "\tint pid = do_sandbox_%v();\n", opts.Sandbox)
 		fmt.Fprint(w, "\tint status = 0;\n")
 		fmt.Fprint(w, "\twhile (waitpid(pid, &status, __WALL) != pid) {}\n")
 		fmt.Fprint(w, "\treturn 0;\n}\n")
 	} else {
 		generateTestFunc(w, opts, calls, "test")
+		if opts.NoFork {
+			fmt.Fprint(w, "void loop()\n{\n")
+			fmt.Fprint(w, "\tfor (;;)\n")
+			fmt.Fprint(w, "\t\ttest();\n")
+			fmt.Fprint(w, "}\n\n")
+		}
 		if opts.Procs <= 1 {
 			fmt.Fprint(w, "int main()\n{\n")
-			fmt.Fprintf(w, "\tsetup_main_process(0, %v);\n", enableTun)
+			generateSetupFault(w, opts)
+			fmt.Fprintf(w, "\tsetup_main_process(0, %v, %v);\n", enableTun, opts.Netns)
 			fmt.Fprintf(w, "\tint pid = do_sandbox_%v();\n", opts.Sandbox)
 			fmt.Fprint(w, "\tint status = 0;\n")
 			fmt.Fprint(w, "\twhile (waitpid(pid, &status, __WALL) != pid) {}\n")
 			fmt.Fprint(w, "\treturn 0;\n}\n")
 		} else {
 			fmt.Fprint(w, "int main()\n{\n")
+			generateSetupFault(w, opts)
 			fmt.Fprint(w, "\tint i;")
 			fmt.Fprintf(w, "\tfor (i = 0; i < %v; i++) {\n", opts.Procs)
 			fmt.Fprint(w, "\t\tif (fork() == 0) {\n")
-			fmt.Fprintf(w, "\t\t\tsetup_main_process(i, %v);\n", enableTun)
+			fmt.Fprintf(w, "\t\t\tsetup_main_process(i, %v, %v);\n", enableTun, opts.Netns)
 			fmt.Fprintf(w, "\t\t\tdo_sandbox_%v();\n", opts.Sandbox)
 			fmt.Fprint(w, "\t\t\treturn 0;\n")
 			fmt.Fprint(w, "\t\t}\n")
@@ -108,6 +190,12 @@ func Write(p *prog.Prog, opts Options) ([]byte, error) {
 	return out, nil
 }
 
+func generateSetupFault(w io.Writer, opts Options) {
+	if opts.Fault {
+		fmt.Fprint(w, "\tsetup_fault();\n")
+	}
+}
+
 func generateTestFunc(w io.Writer, opts Options, calls []string, name string) {
 	if !opts.Threaded && !opts.Collide {
 		fmt.Fprintf(w, "void %v()\n{\n", name)
@@ -119,6 +207,8 @@ func generateTestFunc(w io.Writer, opts Options, calls []string, name string) {
 			fmt.Fprintf(w, "%s", c)
 		}
 		fmt.Fprintf(w, "}\n")
+	} else if opts.Faithful {
+		generateFaithfulTestFunc(w, opts, calls, name)
 	} else {
 		fmt.Fprintf(w, "void *thr(void *arg)\n{\n")
 		fmt.Fprintf(w, "\tswitch ((long)arg) {\n")
@@ -155,7 +245,97 @@ func generateTestFunc(w io.Writer, opts Options, calls []string, name string) {
 	}
 }
 
-func generateCalls(exec []byte) ([]string, int) {
+// generateFaithfulTestFunc generates test function that mimics executor's threaded mode:
+// calls are scheduled on free worker threads and the main thread waits for completion
+// of each call for up to the call timeout; in collide mode every odd call is not waited for,
+// so that it runs concurrently with the next call.
+func generateFaithfulTestFunc(w io.Writer, opts Options, calls []string, name string) {
+	timeout := opts.CallTimeout
+	if timeout <= 0 {
+		timeout = 20
+	}
+	fmt.Fprintf(w, "struct thread_t {\n")
+	fmt.Fprintf(w, "\tint created, call;\n")
+	fmt.Fprintf(w, "\tint ready, done;\n")
+	fmt.Fprintf(w, "\tpthread_t th;\n")
+	fmt.Fprintf(w, "};\n\n")
+	fmt.Fprintf(w, "static struct thread_t threads[16];\n")
+	fmt.Fprintf(w, "static int running;\n")
+	fmt.Fprintf(w, "static int collide;\n\n")
+
+	fmt.Fprintf(w, "static void execute_call(int call)\n{\n")
+	fmt.Fprintf(w, "\tswitch (call) {\n")
+	for i, c := range calls {
+		fmt.Fprintf(w, "\tcase %v:\n", i)
+		fmt.Fprintf(w, "%s", strings.Replace(c, "\t", "\t\t", -1))
+		fmt.Fprintf(w, "\t\tbreak;\n")
+	}
+	fmt.Fprintf(w, "\t}\n")
+	fmt.Fprintf(w, "}\n\n")
+
+	fmt.Fprintf(w, "static void* thr(void* arg)\n{\n")
+	fmt.Fprintf(w, "\tstruct thread_t* th = (struct thread_t*)arg;\n")
+	fmt.Fprintf(w, "\tfor (;;) {\n")
+	fmt.Fprintf(w, "\t\twhile (!__atomic_load_n(&th->ready, __ATOMIC_ACQUIRE))\n")
+	fmt.Fprintf(w, "\t\t\tsyscall(SYS_futex, &th->ready, FUTEX_WAIT, 0, 0);\n")
+	fmt.Fprintf(w, "\t\t__atomic_store_n(&th->ready, 0, __ATOMIC_RELAXED);\n")
+	fmt.Fprintf(w, "\t\texecute_call(th->call);\n")
+	fmt.Fprintf(w, "\t\t__atomic_fetch_sub(&running, 1, __ATOMIC_RELAXED);\n")
+	fmt.Fprintf(w, "\t\t__atomic_store_n(&th->done, 1, __ATOMIC_RELEASE);\n")
+	fmt.Fprintf(w, "\t\tsyscall(SYS_futex, &th->done, FUTEX_WAKE);\n")
+	fmt.Fprintf(w, "\t}\n")
+	fmt.Fprintf(w, "\treturn 0;\n}\n\n")
+
+	fmt.Fprintf(w, "static void execute(int num_calls)\n{\n")
+	fmt.Fprintf(w, "\tint call, thread;\n")
+	fmt.Fprintf(w, "\trunning = 0;\n")
+	fmt.Fprintf(w, "\tfor (call = 0; call < num_calls; call++) {\n")
+	fmt.Fprintf(w, "\t\tfor (thread = 0; thread < sizeof(threads) / sizeof(threads[0]); thread++) {\n")
+	fmt.Fprintf(w, "\t\t\tstruct thread_t* th = &threads[thread];\n")
+	fmt.Fprintf(w, "\t\t\tif (!th->created) {\n")
+	fmt.Fprintf(w, "\t\t\t\tth->created = 1;\n")
+	fmt.Fprintf(w, "\t\t\t\tth->done = 1;\n")
+	fmt.Fprintf(w, "\t\t\t\tpthread_attr_t attr;\n")
+	fmt.Fprintf(w, "\t\t\t\tpthread_attr_init(&attr);\n")
+	fmt.Fprintf(w, "\t\t\t\tpthread_attr_setstacksize(&attr, 128 << 10);\n")
+	fmt.Fprintf(w, "\t\t\t\tpthread_create(&th->th, &attr, thr, th);\n")
+	fmt.Fprintf(w, "\t\t\t\tpthread_attr_destroy(&attr);\n")
+	fmt.Fprintf(w, "\t\t\t}\n")
+	fmt.Fprintf(w, "\t\t\tif (!__atomic_load_n(&th->done, __ATOMIC_ACQUIRE))\n")
+	fmt.Fprintf(w, "\t\t\t\tcontinue;\n")
+	fmt.Fprintf(w, "\t\t\tth->call = call;\n")
+	fmt.Fprintf(w, "\t\t\t__atomic_fetch_add(&running, 1, __ATOMIC_RELAXED);\n")
+	fmt.Fprintf(w, "\t\t\t__atomic_store_n(&th->done, 0, __ATOMIC_RELAXED);\n")
+	fmt.Fprintf(w, "\t\t\t__atomic_store_n(&th->ready, 1, __ATOMIC_RELEASE);\n")
+	fmt.Fprintf(w, "\t\t\tsyscall(SYS_futex, &th->ready, FUTEX_WAKE);\n")
+	fmt.Fprintf(w, "\t\t\tif (collide && call %% 2)\n")
+	fmt.Fprintf(w, "\t\t\t\tbreak;\n")
+	fmt.Fprintf(w, "\t\t\tstruct timespec ts;\n")
+	fmt.Fprintf(w, "\t\t\tts.tv_sec = %v;\n", timeout/1000)
+	fmt.Fprintf(w, "\t\t\tts.tv_nsec = %v;\n", timeout%1000*1000*1000)
+	fmt.Fprintf(w, "\t\t\tsyscall(SYS_futex, &th->done, FUTEX_WAIT, 0, &ts);\n")
+	fmt.Fprintf(w, "\t\t\tif (__atomic_load_n(&running, __ATOMIC_RELAXED))\n")
+	fmt.Fprintf(w, "\t\t\t\tusleep((call == num_calls - 1) ? 1000 : 100);\n")
+	fmt.Fprintf(w, "\t\t\tbreak;\n")
+	fmt.Fprintf(w, "\t\t}\n")
+	fmt.Fprintf(w, "\t}\n")
+	fmt.Fprintf(w, "}\n\n")
+
+	fmt.Fprintf(w, "void %v()\n{\n", name)
+	if opts.Repro {
+		fmt.Fprintf(w, "\tsyscall(SYS_write, 1, \"executing program\\n\", strlen(\"executing program\\n\"));\n")
+	}
+	fmt.Fprintf(w, "\tmemset(r, -1, sizeof(r));\n")
+	fmt.Fprintf(w, "\tcollide = 0;\n")
+	fmt.Fprintf(w, "\texecute(%v);\n", len(calls))
+	if opts.Collide {
+		fmt.Fprintf(w, "\tcollide = 1;\n")
+		fmt.Fprintf(w, "\texecute(%v);\n", len(calls))
+	}
+	fmt.Fprintf(w, "}\n\n")
+}
+
+func generateCalls(exec []byte, opts Options, profile Profile) ([]string, int) {
 	read := func() uintptr {
 		if len(exec) < 8 {
 			panic("exec program overflow")
@@ -235,7 +415,8 @@ loop:
 			// Normal syscall.
 			newCall()
 			meta := sys.Calls[instr]
-			fmt.Fprintf(w, "\tr[%v] = execute_syscall(__NR_%v", n, meta.CallName)
+			call := new(bytes.Buffer)
+			fmt.Fprintf(call, "execute_syscall(__NR_%v", meta.CallName)
 			nargs := read()
 			for i := uintptr(0); i < nargs; i++ {
 				typ := read()
@@ -243,20 +424,44 @@ loop:
 				_ = size
 				switch typ {
 				case prog.ExecArgConst:
-					fmt.Fprintf(w, ", 0x%xul", read())
+					if v := read(); profile.is32Bit() && uint64(v) > math.MaxUint32 {
+						// Truncate explicitly, otherwise the compiler warns about overflow.
+						fmt.Fprintf(call, ", (uintptr_t)0x%xull", v)
+					} else {
+						fmt.Fprintf(call, ", 0x%xul", v)
+					}
 					// Bitfields can't be args of a normal syscall, so just ignore them.
 					read() // bit field offset
 					read() // bit field length
 				case prog.ExecArgResult:
-					fmt.Fprintf(w, ", %v", resultRef())
+					fmt.Fprintf(call, ", %v", resultRef())
 				default:
 					panic("unknown arg type")
 				}
 			}
 			for i := nargs; i < 9; i++ {
-				fmt.Fprintf(w, ", 0")
+				fmt.Fprintf(call, ", 0")
+			}
+			fmt.Fprintf(call, ")")
+			guard := !profile.native() && !strings.HasPrefix(meta.CallName, "syz_")
+			if guard {
+				// The syscall may not exist on the target arch.
+				fmt.Fprintf(w, "#ifdef __NR_%v\n", meta.CallName)
+			}
+			if opts.Fault && len(calls) == opts.FaultCall {
+				// Arm fault injection in the thread that executes the call and disarm it afterwards,
+				// so that the fault is not injected into subsequent calls.
+				fmt.Fprintf(w, "\t{\n")
+				fmt.Fprintf(w, "\t\tint fail_fd = inject_fault(%v);\n", opts.FaultNth)
+				fmt.Fprintf(w, "\t\tr[%v] = %s;\n", n, call.Bytes())
+				fmt.Fprintf(w, "\t\tfault_injected(fail_fd);\n")
+				fmt.Fprintf(w, "\t}\n")
+			} else {
+				fmt.Fprintf(w, "\tr[%v] = %s;\n", n, call.Bytes())
+			}
+			if guard {
+				fmt.Fprintf(w, "#endif\n")
 			}
-			fmt.Fprintf(w, ");\n")
 			lastCall = n
 			seenCall = true
 		}
@@ -265,26 +470,32 @@ loop:
 	return calls, n
 }
 
-func preprocessCommonHeader(opts Options, handled map[string]int) (string, error) {
+func preprocessCommonHeader(opts Options, profile Profile, handled map[string]int) (string, error) {
 	var defines []string
-	switch opts.Sandbox {
-	case "none":
+	switch {
+	case opts.Minimal:
+	case opts.Sandbox == "none":
 		defines = append(defines, "SYZ_SANDBOX_NONE")
-	case "setuid":
+	case opts.Sandbox == "setuid":
 		defines = append(defines, "SYZ_SANDBOX_SETUID")
-	case "namespace":
+	case opts.Sandbox == "namespace":
 		defines = append(defines, "SYZ_SANDBOX_NAMESPACE")
 	default:
 		return "", fmt.Errorf("unknown sandbox mode: %v", opts.Sandbox)
 	}
-	if opts.Repeat {
+	if opts.Repeat && !opts.NoFork {
 		defines = append(defines, "SYZ_REPEAT")
 	}
+	if opts.Netns {
+		defines = append(defines, "SYZ_NETNS")
+	}
+	if opts.Fault {
+		defines = append(defines, "SYZ_FAULT_INJECTION")
+	}
 	for name, _ := range handled {
 		defines = append(defines, "__NR_"+name)
 	}
-	// TODO: need to know target arch + do cross-compilation
-	defines = append(defines, "__x86_64__")
+	defines = append(defines, archDefines[profile.Arch])
 
 	cmd := exec.Command("cpp", "-nostdinc", "-undef", "-fdirectives-only", "-dDI", "-E", "-P", "-")
 	for _, def := range defines {
@@ -305,25 +516,45 @@ func preprocessCommonHeader(opts Options, handled map[string]int) (string, error
 		"__STDC_UTF_16__",
 		"__STDC_UTF_32__",
 	}...)
-	out := stdout.String()
-	for _, def := range remove {
-		out = strings.Replace(out, "#define "+def+" 1\n", "", -1)
+	var out []string
+	for _, line := range strings.SplitAfter(stdout.String(), "\n") {
+		removed := false
+		for _, def := range remove {
+			// Values of predefined macros depend on the compiler (e.g. __STDC_VERSION__ 201710L).
+			if strings.HasPrefix(line, "#define "+def+" ") {
+				removed = true
+				break
+			}
+		}
+		if !removed {
+			out = append(out, line)
+		}
 	}
-	return out, nil
+	return strings.Join(out, ""), nil
 }
 
 // Build builds a C/C++ program from source src and returns name of the resulting binary.
 // lang can be "c" or "c++".
 func Build(lang, src string) (string, error) {
+	return BuildProfile("", lang, src)
+}
+
+// BuildProfile is Build for the target build environment profile (see Profiles).
+func BuildProfile(profileName, lang, src string) (string, error) {
+	profile, err := getProfile(profileName)
+	if err != nil {
+		return "", err
+	}
 	bin, err := ioutil.TempFile("", "syzkaller")
 	if err != nil {
 		return "", fmt.Errorf("failed to create temp file: %v", err)
 	}
 	bin.Close()
-	out, err := exec.Command("gcc", "-x", lang, "-Wall", "-Werror", src, "-o", bin.Name(), "-pthread", "-static", "-O1", "-g").CombinedOutput()
-	if err != nil {
+	args := append([]string{"-x", lang, "-Wall", "-Werror", src, "-o", bin.Name(), "-pthread", "-O1", "-g"}, profile.CFlags...)
+	out, err := exec.Command(profile.CC, append(args, "-static")...).CombinedOutput()
+	if err != nil && !profile.Static {
 		// Some distributions don't have static libraries.
-		out, err = exec.Command("gcc", "-x", lang, "-Wall", "-Werror", src, "-o", bin.Name(), "-pthread", "-O1", "-g").CombinedOutput()
+		out, err = exec.Command(profile.CC, args...).CombinedOutput()
 	}
 	if err != nil {
 		os.Remove(bin.Name())
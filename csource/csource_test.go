@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"math/rand"
 	"os"
+	"os/exec"
+	"runtime"
 	"testing"
 	"time"
 
@@ -30,20 +32,25 @@ func allOptionsPermutations() []Options {
 	var opt Options
 	for _, opt.Threaded = range []bool{false, true} {
 		for _, opt.Collide = range []bool{false, true} {
-			for _, opt.Repeat = range []bool{false, true} {
-				for _, opt.Repro = range []bool{false, true} {
-					for _, opt.Procs = range []int{1, 4} {
-						for _, opt.Sandbox = range []string{"none", "setuid", "namespace"} {
-							if opt.Collide && !opt.Threaded {
-								continue
+			for _, opt.Faithful = range []bool{false, true} {
+				for _, opt.Repeat = range []bool{false, true} {
+					for _, opt.Repro = range []bool{false, true} {
+						for _, opt.Procs = range []int{1, 4} {
+							for _, opt.Sandbox = range []string{"none", "setuid", "namespace"} {
+								if opt.Collide && !opt.Threaded {
+									continue
+								}
+								if opt.Faithful && !opt.Threaded {
+									continue
+								}
+								if !opt.Repeat && opt.Procs != 1 {
+									continue
+								}
+								if testing.Short() && opt.Procs != 1 {
+									continue
+								}
+								options = append(options, opt)
 							}
-							if !opt.Repeat && opt.Procs != 1 {
-								continue
-							}
-							if testing.Short() && opt.Procs != 1 {
-								continue
-							}
-							options = append(options, opt)
 						}
 					}
 				}
@@ -61,12 +68,93 @@ func TestSyz(t *testing.T) {
 		Repeat:   true,
 		Procs:    2,
 		Sandbox:  "namespace",
+		Netns:    true,
 		Repro:    true,
 	}
 	p := prog.GenerateAllSyzProg(rs)
 	testOne(t, p, opts)
 }
 
+func TestFault(t *testing.T) {
+	rs, iters := initTest(t)
+	for i := 0; i < iters; i++ {
+		p := prog.Generate(rs, 10, nil)
+		for _, threaded := range []bool{false, true} {
+			opts := Options{
+				Threaded:  threaded,
+				Collide:   threaded,
+				Repeat:    true,
+				Procs:     1,
+				Sandbox:   "none",
+				Fault:     true,
+				FaultCall: len(p.Calls) - 1,
+				FaultNth:  i,
+			}
+			testOne(t, p, opts)
+		}
+	}
+}
+
+func TestHarness(t *testing.T) {
+	rs, iters := initTest(t)
+	syzProg := prog.GenerateAllSyzProg(rs)
+	options := []Options{
+		{Minimal: true},
+		{Minimal: true, Threaded: true, Collide: true, Faithful: true, Sandbox: "namespace"},
+		{Repeat: true, NoFork: true, Procs: 1, Sandbox: "none"},
+		{Repeat: true, NoFork: true, Threaded: true, Faithful: true, Procs: 4, Sandbox: "setuid"},
+		{Repeat: true, Tun: true, Procs: 1, Sandbox: "namespace", Netns: true},
+	}
+	for i, opts := range options {
+		t.Run(fmt.Sprintf("%v", i), func(t *testing.T) {
+			t.Logf("opts: %+v", opts)
+			for i := 0; i < iters; i++ {
+				testOne(t, prog.Generate(rs, 10, nil), opts)
+			}
+			testOne(t, syzProg, opts)
+		})
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	rs, _ := initTest(t)
+	p := prog.Generate(rs, 10, nil)
+	for name, profile := range Profiles {
+		opts := Options{
+			Threaded: true,
+			Collide:  true,
+			Repeat:   true,
+			Procs:    1,
+			Sandbox:  "none",
+			Profile:  name,
+		}
+		src, err := Write(p, opts)
+		if err != nil {
+			t.Fatalf("profile %q: %v", name, err)
+		}
+		if profile.Arch != runtime.GOARCH {
+			// Compilers for other arches are usually not installed.
+			continue
+		}
+		if _, err := exec.LookPath(profile.CC); err != nil {
+			continue
+		}
+		srcf, err := fileutil.WriteTempFile(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bin, err := BuildProfile(name, "c", srcf)
+		os.Remove(srcf)
+		if err != nil {
+			t.Fatalf("profile %q: %v", name, err)
+		}
+		os.Remove(bin)
+	}
+	if _, err := Write(p, Options{Sandbox: "none", Profile: "plan9"}); err == nil {
+		t.Fatalf("unknown profile is accepted")
+	}
+}
+
 func Test(t *testing.T) {
 	rs, iters := initTest(t)
 	syzProg := prog.GenerateAllSyzProg(rs)
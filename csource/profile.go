@@ -0,0 +1,76 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package csource
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// Profile describes target build environment of generated programs.
+type Profile struct {
+	Arch   string   // target architecture: amd64, 386 or arm64
+	Libc   string   // C library: glibc, musl or bionic
+	Static bool     // build fully static binaries (otherwise static linking is tried first)
+	CC     string   // C compiler
+	CFlags []string // additional compiler flags
+}
+
+// Profiles are the supported build environments, the empty name denotes the default one.
+// Programs are always generated from descriptions of the host architecture,
+// for other architectures syscall numbers are taken from the target headers and
+// syscalls that are not defined there are skipped.
+var Profiles = map[string]Profile{
+	"":        {Arch: "amd64", Libc: "glibc", CC: "gcc"},
+	"static":  {Arch: "amd64", Libc: "glibc", Static: true, CC: "gcc"},
+	"musl":    {Arch: "amd64", Libc: "musl", Static: true, CC: "musl-gcc"},
+	"386":     {Arch: "386", Libc: "glibc", CC: "gcc", CFlags: []string{"-m32"}},
+	"android": {Arch: "arm64", Libc: "bionic", Static: true, CC: "aarch64-linux-android21-clang"},
+}
+
+// archDefines are predefined compiler macros of the supported architectures.
+var archDefines = map[string]string{
+	"amd64": "__x86_64__",
+	"386":   "__i386__",
+	"arm64": "__aarch64__",
+}
+
+// ProfileNames returns names of all non-default profiles.
+func ProfileNames() []string {
+	var names []string
+	for name := range Profiles {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func getProfile(name string) (Profile, error) {
+	profile, ok := Profiles[name]
+	if !ok {
+		return Profile{}, fmt.Errorf("unknown profile %q, supported: %v", name, strings.Join(ProfileNames(), ", "))
+	}
+	return profile, nil
+}
+
+// native says if syscall numbers of the host descriptions are valid for the profile.
+func (profile Profile) native() bool {
+	return profile.Arch == runtime.GOARCH
+}
+
+func (profile Profile) is32Bit() bool {
+	return profile.Arch == "386"
+}
+
+// checkPseudoCalls checks that pseudo syscalls used by the program are implemented for the profile.
+func (profile Profile) checkPseudoCalls(handled map[string]int) error {
+	if _, ok := handled["syz_kvm_setup_cpu"]; ok && profile.Arch != "amd64" && profile.Arch != "arm64" {
+		return fmt.Errorf("syz_kvm_setup_cpu is not supported on %v", profile.Arch)
+	}
+	return nil
+}
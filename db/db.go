@@ -17,6 +17,7 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"sort"
 
 	. "github.com/google/syzkaller/log"
 )
@@ -42,9 +43,12 @@ func Open(filename string) (*DB, error) {
 	if err != nil {
 		return nil, err
 	}
-	db.Records, db.uncompacted = deserializeDB(bufio.NewReader(f))
+	var ver uint32
+	db.Records, db.uncompacted, ver = deserializeDB(bufio.NewReader(f))
 	f.Close()
-	if len(db.Records) == 0 || db.uncompacted/10*9 > len(db.Records) {
+	// Files of older versions are converted to the current format,
+	// new frames can't be appended to them.
+	if len(db.Records) == 0 || ver != curVersion || db.uncompacted/10*9 > len(db.Records) {
 		db.compact()
 	}
 	return db, nil
@@ -79,23 +83,46 @@ func (db *DB) Flush() error {
 	if db.pending == nil {
 		return nil
 	}
+	buf := new(bytes.Buffer)
+	serializeFrame(buf, db.pending.Bytes())
 	f, err := os.OpenFile(db.filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
-	if _, err := f.Write(db.pending.Bytes()); err != nil {
+	if _, err := f.Write(buf.Bytes()); err != nil {
 		return err
 	}
 	db.pending = nil
 	return nil
 }
 
+// Compact rewrites the file without deleted and overwritten records.
+// Flush compacts the file only when it grows too large, so Compact allows
+// to reclaim space periodically. It is a no-op if the file is already compact.
+func (db *DB) Compact() error {
+	if db.uncompacted == len(db.Records) && db.pending == nil {
+		return nil
+	}
+	return db.compact()
+}
+
 func (db *DB) compact() error {
+	// Records are written in key order, so that the file does not depend on map iteration order.
+	keys := make([]string, 0, len(db.Records))
+	for key := range db.Records {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	records := new(bytes.Buffer)
+	for _, key := range keys {
+		rec := db.Records[key]
+		serializeRecord(records, key, rec.Val, rec.Seq)
+	}
 	buf := new(bytes.Buffer)
 	serializeHeader(buf)
-	for key, rec := range db.Records {
-		serializeRecord(buf, key, rec.Val, rec.Seq)
+	if len(keys) != 0 {
+		serializeFrame(buf, records.Bytes())
 	}
 	f, err := os.Create(db.filename + ".tmp")
 	if err != nil {
@@ -121,18 +148,47 @@ func (db *DB) serialize(key string, val []byte, seq uint64) {
 	serializeRecord(db.pending, key, val, seq)
 }
 
+// File format: header (dbMagic, version) followed by records.
+// Version 1: every record value is compressed with flate separately.
+// Version 2: records are not compressed individually, instead they are grouped into frames
+// (frameMagic, compressed size, flate stream of records). Compaction writes all records
+// into a single frame and every Flush appends a frame with the pending records,
+// so similar values (e.g. programs) are compressed together.
+// Older versions are converted to the current one on Open.
 const (
 	dbMagic    = uint32(0xbaddb)
 	recMagic   = uint32(0xfee1bad)
-	curVersion = uint32(1)
+	frameMagic = uint32(0xf4a3e)
+	curVersion = uint32(2)
 	seqDeleted = ^uint64(0)
 )
 
+// IsDB returns true if data looks like contents of a database file (checks the header magic).
+func IsDB(data []byte) bool {
+	return len(data) >= 4 && binary.LittleEndian.Uint32(data) == dbMagic
+}
+
 func serializeHeader(w *bytes.Buffer) {
 	binary.Write(w, binary.LittleEndian, dbMagic)
 	binary.Write(w, binary.LittleEndian, curVersion)
 }
 
+func serializeFrame(w *bytes.Buffer, records []byte) {
+	binary.Write(w, binary.LittleEndian, frameMagic)
+	lenPos := len(w.Bytes())
+	binary.Write(w, binary.LittleEndian, uint32(0))
+	startPos := len(w.Bytes())
+	fw, err := flate.NewWriter(w, flate.BestCompression)
+	if err != nil {
+		panic(err)
+	}
+	if _, err := fw.Write(records); err != nil {
+		panic(err)
+	}
+	fw.Close()
+	binary.LittleEndian.PutUint32(w.Bytes()[lenPos:], uint32(len(w.Bytes())-startPos))
+}
+
 func serializeRecord(w *bytes.Buffer, key string, val []byte, seq uint64) {
 	binary.Write(w, binary.LittleEndian, recMagic)
 	binary.Write(w, binary.LittleEndian, uint32(len(key)))
@@ -144,49 +200,160 @@ func serializeRecord(w *bytes.Buffer, key string, val []byte, seq uint64) {
 		}
 		return
 	}
-	if len(val) == 0 {
-		binary.Write(w, binary.LittleEndian, uint32(len(val)))
-	} else {
-		lenPos := len(w.Bytes())
-		binary.Write(w, binary.LittleEndian, uint32(0))
-		startPos := len(w.Bytes())
-		fw, err := flate.NewWriter(w, flate.BestCompression)
-		if err != nil {
-			panic(err)
-		}
-		if _, err := fw.Write(val); err != nil {
-			panic(err)
+	binary.Write(w, binary.LittleEndian, uint32(len(val)))
+	w.Write(val)
+}
+
+func deserializeDB(r *bufio.Reader) (records map[string]Record, uncompacted int, ver uint32) {
+	records = make(map[string]Record)
+	it := newIterator(r)
+	defer func() { ver = it.ver }()
+	for it.Next() {
+		uncompacted++
+		if it.Deleted {
+			delete(records, it.Key)
+		} else {
+			records[it.Key] = it.Rec
 		}
-		fw.Flush()
-		fw.Close()
-		binary.Write(bytes.NewBuffer(w.Bytes()[lenPos:lenPos:lenPos+8]), binary.LittleEndian, uint32(len(w.Bytes())-startPos))
 	}
+	if err := it.Err(); err != nil {
+		Logf(0, "failed to deserialize database: %v", err)
+	}
+	return
 }
 
-func deserializeDB(r *bufio.Reader) (records map[string]Record, uncompacted int) {
-	records = make(map[string]Record)
-	ver, err := deserializeHeader(r)
+// Iterator reads database file sequentially without loading the whole database into memory.
+// It returns raw log entries: a key can be returned several times (the last one wins)
+// and deletions are returned as entries with Deleted set.
+type Iterator struct {
+	Key     string
+	Rec     Record
+	Deleted bool
+
+	r       *bufio.Reader
+	f       *os.File
+	err     error
+	started bool
+	ver     uint32
+	frame   *bufio.Reader // records of the current frame (version 2)
+	fr      io.ReadCloser
+}
+
+// NewIterator opens database file filename for iteration.
+func NewIterator(filename string) (*Iterator, error) {
+	f, err := os.Open(filename)
 	if err != nil {
-		Logf(0, "failed to deserialize database header: %v", err)
-		return
+		return nil, err
 	}
-	_ = ver
-	for {
-		key, val, seq, err := deserializeRecord(r)
-		if err == io.EOF {
-			return
-		}
+	it := newIterator(bufio.NewReader(f))
+	it.f = f
+	return it, nil
+}
+
+func newIterator(r *bufio.Reader) *Iterator {
+	return &Iterator{r: r}
+}
+
+// Next advances to the next entry and returns false when there are no more entries or on error.
+func (it *Iterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	if !it.started {
+		it.started = true
+		ver, err := deserializeHeader(it.r)
 		if err != nil {
-			Logf(0, "failed to deserialize database record: %v", err)
-			return
+			it.err = fmt.Errorf("failed to deserialize database header: %v", err)
+			return false
 		}
-		uncompacted++
-		if seq == seqDeleted {
-			delete(records, key)
+		it.ver = ver
+	}
+	var key string
+	var val []byte
+	var seq uint64
+	var err error
+	if it.ver == 1 {
+		key, val, seq, err = deserializeRecord(it.r, true)
+	} else {
+		for {
+			if it.frame == nil {
+				if err = it.nextFrame(); err != nil {
+					break
+				}
+			}
+			key, val, seq, err = deserializeRecord(it.frame, false)
+			if err != io.EOF {
+				break
+			}
+			it.fr.Close()
+			it.frame, it.fr = nil, nil
+		}
+	}
+	if err == io.EOF {
+		return false
+	}
+	if err != nil {
+		it.err = fmt.Errorf("failed to deserialize database record: %v", err)
+		return false
+	}
+	it.Key = key
+	it.Deleted = seq == seqDeleted
+	it.Rec = Record{val, seq}
+	if it.Deleted {
+		it.Rec = Record{}
+	}
+	return true
+}
+
+func (it *Iterator) nextFrame() error {
+	var magic, size uint32
+	if err := binary.Read(it.r, binary.LittleEndian, &magic); err != nil {
+		return err
+	}
+	if magic != frameMagic {
+		return fmt.Errorf("bad frame header: 0x%x", magic)
+	}
+	if err := binary.Read(it.r, binary.LittleEndian, &size); err != nil {
+		return err
+	}
+	it.fr = flate.NewReader(&io.LimitedReader{R: it.r, N: int64(size)})
+	it.frame = bufio.NewReader(it.fr)
+	return nil
+}
+
+// Err returns the error that stopped iteration, if any.
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Close closes the underlying file.
+func (it *Iterator) Close() error {
+	if it.f == nil {
+		return nil
+	}
+	return it.f.Close()
+}
+
+// ReadRecords reads all records from database file filename without modifying the file
+// (Open can compact the file, e.g. under a running manager).
+func ReadRecords(filename string) (map[string]Record, error) {
+	it, err := NewIterator(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	records := make(map[string]Record)
+	for it.Next() {
+		if it.Deleted {
+			delete(records, it.Key)
 		} else {
-			records[key] = Record{val, seq}
+			records[it.Key] = it.Rec
 		}
 	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	return records, nil
 }
 
 func deserializeHeader(r *bufio.Reader) (uint32, error) {
@@ -209,7 +376,8 @@ func deserializeHeader(r *bufio.Reader) (uint32, error) {
 	return ver, nil
 }
 
-func deserializeRecord(r *bufio.Reader) (key string, val []byte, seq uint64, err error) {
+// deserializeRecord reads a record, compressed says if the value is compressed (version 1).
+func deserializeRecord(r *bufio.Reader, compressed bool) (key string, val []byte, seq uint64, err error) {
 	var magic uint32
 	if err = binary.Read(r, binary.LittleEndian, &magic); err != nil {
 		return
@@ -237,12 +405,18 @@ func deserializeRecord(r *bufio.Reader) (key string, val []byte, seq uint64, err
 	if err = binary.Read(r, binary.LittleEndian, &valLen); err != nil {
 		return
 	}
-	if valLen != 0 {
-		fr := flate.NewReader(&io.LimitedReader{r, int64(valLen)})
-		if val, err = ioutil.ReadAll(fr); err != nil {
-			return
-		}
-		fr.Close()
+	if valLen == 0 {
+		return
+	}
+	if !compressed {
+		val = make([]byte, valLen)
+		_, err = io.ReadFull(r, val)
+		return
+	}
+	fr := flate.NewReader(&io.LimitedReader{R: r, N: int64(valLen)})
+	if val, err = ioutil.ReadAll(fr); err != nil {
+		return
 	}
+	fr.Close()
 	return
 }
@@ -5,10 +5,13 @@ package db
 
 import (
 	"bytes"
+	"compress/flate"
+	"encoding/binary"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
 	"os"
+	"reflect"
 	"testing"
 )
 
@@ -112,6 +115,31 @@ func TestModify(t *testing.T) {
 		t.Fatalf("failed to open db: %v", err)
 	}
 	checkContents("after reopen")
+	db.Save("1", []byte("x"), 7)
+	db.Save("1", nil, 5)
+	if err := db.Flush(); err != nil {
+		t.Fatalf("failed to flush db: %v", err)
+	}
+	before, err := os.Stat(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatalf("failed to compact db: %v", err)
+	}
+	checkContents("after compaction")
+	after, err := os.Stat(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Size() >= before.Size() {
+		t.Fatalf("compaction did not shrink db: %v -> %v", before.Size(), after.Size())
+	}
+	db, err = Open(fn)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	checkContents("after compaction reopen")
 }
 
 func TestLarge(t *testing.T) {
@@ -141,6 +169,185 @@ func TestLarge(t *testing.T) {
 	}
 }
 
+func TestIterator(t *testing.T) {
+	fn := tempFile(t)
+	defer os.Remove(fn)
+	db, err := Open(fn)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	db.Save("a", []byte("1"), 1)
+	db.Save("b", []byte("2"), 2)
+	db.Delete("a")
+	db.Save("b", []byte("3"), 3)
+	if err := db.Flush(); err != nil {
+		t.Fatalf("failed to flush db: %v", err)
+	}
+	type entry struct {
+		key     string
+		val     string
+		seq     uint64
+		deleted bool
+	}
+	want := []entry{
+		{"a", "1", 1, false},
+		{"b", "2", 2, false},
+		{"a", "", 0, true},
+		{"b", "3", 3, false},
+	}
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		t.Fatalf("failed to read db: %v", err)
+	}
+	if !IsDB(data) || IsDB([]byte("mmap(&(0x7f0000000000/0x1000)=nil)")) || IsDB(nil) {
+		t.Fatalf("IsDB does not detect database files")
+	}
+	it, err := NewIterator(fn)
+	if err != nil {
+		t.Fatalf("failed to open iterator: %v", err)
+	}
+	defer it.Close()
+	var got []entry
+	for it.Next() {
+		got = append(got, entry{it.Key, string(it.Rec.Val), it.Rec.Seq, it.Deleted})
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("iteration failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("bad entries:\n%+v\nwant:\n%+v", got, want)
+	}
+	records, err := ReadRecords(fn)
+	if err != nil {
+		t.Fatalf("failed to read records: %v", err)
+	}
+	if want := map[string]Record{"b": {[]byte("3"), 3}}; !reflect.DeepEqual(records, want) {
+		t.Fatalf("bad records: %+v, want %+v", records, want)
+	}
+	if data2, err := ioutil.ReadFile(fn); err != nil || !bytes.Equal(data, data2) {
+		t.Fatalf("ReadRecords modified the file")
+	}
+}
+
+// serializeV1 returns contents of a version 1 database file with the given log entries
+// (each record value is compressed separately).
+func serializeV1(entries []Record, keys []string) []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, dbMagic)
+	binary.Write(buf, binary.LittleEndian, uint32(1))
+	for i, rec := range entries {
+		binary.Write(buf, binary.LittleEndian, recMagic)
+		binary.Write(buf, binary.LittleEndian, uint32(len(keys[i])))
+		buf.WriteString(keys[i])
+		binary.Write(buf, binary.LittleEndian, rec.Seq)
+		if rec.Seq == seqDeleted {
+			continue
+		}
+		val := new(bytes.Buffer)
+		if len(rec.Val) != 0 {
+			fw, _ := flate.NewWriter(val, flate.BestCompression)
+			fw.Write(rec.Val)
+			fw.Close()
+		}
+		binary.Write(buf, binary.LittleEndian, uint32(val.Len()))
+		buf.Write(val.Bytes())
+	}
+	return buf.Bytes()
+}
+
+func TestMigrateV1(t *testing.T) {
+	fn := tempFile(t)
+	defer os.Remove(fn)
+	keys := []string{"a", "b", "", "a", "c"}
+	entries := []Record{
+		{[]byte("1"), 1},
+		{[]byte("getpid()\n"), 2},
+		{nil, 3},
+		{nil, seqDeleted},
+		{bytes.Repeat([]byte("c"), 1000), 4},
+	}
+	if err := ioutil.WriteFile(fn, serializeV1(entries, keys), 0640); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]Record{
+		"b": {[]byte("getpid()\n"), 2},
+		"":  {nil, 3},
+		"c": {bytes.Repeat([]byte("c"), 1000), 4},
+	}
+	it, err := NewIterator(fn)
+	if err != nil {
+		t.Fatalf("failed to open iterator: %v", err)
+	}
+	n := 0
+	for ; it.Next(); n++ {
+		if it.Key != keys[n] || it.Deleted != (entries[n].Seq == seqDeleted) {
+			t.Fatalf("entry #%v: bad key %q or deleted %v", n, it.Key, it.Deleted)
+		}
+	}
+	it.Close()
+	if it.Err() != nil || n != len(entries) {
+		t.Fatalf("iterated over %v entries of version 1 file: %v", n, it.Err())
+	}
+	for i := 0; i < 2; i++ {
+		db, err := Open(fn)
+		if err != nil {
+			t.Fatalf("failed to open db: %v", err)
+		}
+		if !reflect.DeepEqual(db.Records, want) {
+			t.Fatalf("open #%v: bad records:\n%+v\nwant:\n%+v", i, db.Records, want)
+		}
+		data, err := ioutil.ReadFile(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ver := binary.LittleEndian.Uint32(data[4:]); ver != curVersion {
+			t.Fatalf("open #%v: file is not converted, version %v", i, ver)
+		}
+		db.Save("d", []byte("5"), 5)
+		if err := db.Flush(); err != nil {
+			t.Fatalf("failed to flush db: %v", err)
+		}
+		want["d"] = Record{[]byte("5"), 5}
+	}
+}
+
+func TestCompression(t *testing.T) {
+	// Similar records must be compressed together.
+	fn := tempFile(t)
+	defer os.Remove(fn)
+	db, err := Open(fn)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	var keys []string
+	var entries []Record
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("%040x", i)
+		val := []byte(fmt.Sprintf("mmap(&(0x7f0000000000/0x1000)=nil, 0x1000, 0x3, 0x32, 0xffffffffffffffff, 0x0)\n"+
+			"r0 = open(&(0x7f0000000000)=\"2e2f66696c653000\", 0x%x, 0x0)\nclose(r0)\n", i))
+		db.Save(key, val, uint64(i))
+		keys = append(keys, key)
+		entries = append(entries, Record{val, uint64(i)})
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatalf("failed to compact db: %v", err)
+	}
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v1 := len(serializeV1(entries, keys)); len(data)*3 > v1 {
+		t.Fatalf("database is not compressed: %v bytes, version 1: %v bytes", len(data), v1)
+	}
+	db, err = Open(fn)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	if len(db.Records) != len(keys) || !bytes.Equal(db.Records[keys[10]].Val, entries[10].Val) {
+		t.Fatalf("bad records after reopen")
+	}
+}
+
 func tempFile(t *testing.T) string {
 	f, err := ioutil.TempFile("", "syzkaller.test.db")
 	if err != nil {
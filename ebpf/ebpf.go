@@ -0,0 +1,372 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+// Package ebpf generates and mutates eBPF programs (see include/uapi/linux/bpf.h).
+// Generated programs are well-formed and, modulo kernel version and program type
+// specifics, pass the verifier most of the time: registers and stack slots
+// are written before they are read, pointers are not used in arithmetic,
+// jumps go forward only and the program ends with exit with r0 set.
+// A small fraction of instructions is random to exercise verifier error paths.
+package ebpf
+
+import (
+	"encoding/binary"
+	"math/rand"
+)
+
+const (
+	InsnSize = 8
+
+	maxInsns   = 40
+	stackSize  = 512
+	numRegs    = 11
+	regCtx     = 1
+	regFP      = 10
+	randomProb = 50 // 1 out of randomProb instructions is random
+)
+
+// Instruction classes, sizes, modes and operations.
+const (
+	classLD    = 0x00
+	classLDX   = 0x01
+	classST    = 0x02
+	classSTX   = 0x03
+	classALU   = 0x04
+	classJMP   = 0x05
+	classALU64 = 0x07
+
+	sizeW  = 0x00
+	sizeH  = 0x08
+	sizeB  = 0x10
+	sizeDW = 0x18
+
+	modeIMM = 0x00
+	modeMEM = 0x60
+
+	srcK = 0x00
+	srcX = 0x08
+
+	aluNeg = 0x80
+	aluMov = 0xb0
+
+	jmpCall = 0x80
+	jmpExit = 0x90
+)
+
+var (
+	aluOps = []uint8{0x00, 0x10, 0x20, 0x30, 0x40, 0x50, 0x60, 0x70, aluNeg, 0x90, 0xa0, aluMov, 0xc0}
+	jmpOps = []uint8{0x10, 0x20, 0x30, 0x40, 0x50, 0x60, 0x70}
+	sizes  = []struct {
+		code  uint8
+		bytes int
+	}{{sizeB, 1}, {sizeH, 2}, {sizeW, 4}, {sizeDW, 8}}
+	// Helpers that don't take arguments and are available to all program types:
+	// ktime_get_ns, get_prandom_u32, get_smp_processor_id.
+	helpers = []int32{5, 7, 8}
+	// Special immediate values that are likely to trigger corner cases.
+	specialImms = []int32{0, 1, -1, 0x7fffffff, -0x80000000, 31, 32, 63, 64, 0xff, 0xffff}
+)
+
+type Insn struct {
+	Code uint8
+	Dst  uint8
+	Src  uint8
+	Off  int16
+	Imm  int32
+}
+
+// Encode returns binary representation of the instructions (struct bpf_insn array).
+func Encode(insns []Insn) []byte {
+	text := make([]byte, len(insns)*InsnSize)
+	for i, insn := range insns {
+		b := text[i*InsnSize:]
+		b[0] = insn.Code
+		b[1] = insn.Dst&0xf | insn.Src<<4
+		binary.LittleEndian.PutUint16(b[2:], uint16(insn.Off))
+		binary.LittleEndian.PutUint32(b[4:], uint32(insn.Imm))
+	}
+	return text
+}
+
+// Decode parses binary representation of instructions, a trailing partial instruction is dropped.
+func Decode(text []byte) []Insn {
+	var insns []Insn
+	for ; len(text) >= InsnSize; text = text[InsnSize:] {
+		insns = append(insns, Insn{
+			Code: text[0],
+			Dst:  text[1] & 0xf,
+			Src:  text[1] >> 4,
+			Off:  int16(binary.LittleEndian.Uint16(text[2:])),
+			Imm:  int32(binary.LittleEndian.Uint32(text[4:])),
+		})
+	}
+	return insns
+}
+
+// Generate generates a random program.
+func Generate(r *rand.Rand) []byte {
+	g := &generator{r: r, random: true}
+	return Encode(g.generate(1 + r.Intn(maxInsns)))
+}
+
+// Mutate mutates program text.
+func Mutate(r *rand.Rand, text []byte) []byte {
+	insns := Decode(text)
+	if len(insns) == 0 || r.Intn(4) == 0 {
+		return Generate(r)
+	}
+	for stop := false; !stop; stop = r.Intn(2) == 0 {
+		i := r.Intn(len(insns))
+		switch r.Intn(4) {
+		case 0:
+			// Change the immediate, this keeps the program well-formed in most cases.
+			insns[i].Imm = randImm(r)
+		case 1:
+			// Change the offset.
+			insns[i].Off += int16(r.Intn(9) - 4)
+		case 2:
+			// Insert a freshly generated fragment that ends with exit.
+			g := &generator{r: r, random: true}
+			frag := g.generate(1 + r.Intn(5))
+			insns = append(insns[:i], append(frag, insns[i:]...)...)
+		case 3:
+			// Replace with a random instruction.
+			insns[i] = randInsn(r)
+		}
+	}
+	return Encode(insns)
+}
+
+// state is what the verifier tracks for a program point.
+type state struct {
+	regs  [numRegs]regType
+	stack [stackSize / 8]bool // 8-byte stack slots that were written with 8-byte stores
+}
+
+type regType int
+
+const (
+	regUninit regType = iota
+	regScalar
+	regPtr
+)
+
+type generator struct {
+	r      *rand.Rand
+	random bool // emit random instructions from time to time
+	st     state
+	insns  []Insn
+	joins  map[int][]state // states that come to an instruction from jumps
+}
+
+func (g *generator) generate(n int) []Insn {
+	g.st.regs[regCtx] = regPtr
+	g.st.regs[regFP] = regPtr
+	g.joins = make(map[int][]state)
+	for len(g.insns) < n {
+		g.join()
+		if g.random && g.r.Intn(randomProb) == 0 {
+			g.emit(randInsn(g.r))
+			continue
+		}
+		switch g.r.Intn(10) {
+		case 0, 1, 2:
+			g.alu()
+		case 3:
+			g.ldImm64(n)
+		case 4, 5:
+			g.store()
+		case 6:
+			g.load()
+		case 7, 8:
+			g.jump(n)
+		case 9:
+			g.call()
+		}
+	}
+	g.join()
+	g.emit(Insn{Code: classALU64 | aluMov | srcK, Dst: 0, Imm: randImm(g.r)})
+	g.emit(Insn{Code: classJMP | jmpExit})
+	return g.insns
+}
+
+func (g *generator) emit(insn Insn) {
+	g.insns = append(g.insns, insn)
+}
+
+// join merges states of jumps that target the current instruction:
+// a register or a stack slot is initialized only if it is initialized on all paths.
+func (g *generator) join() {
+	for _, st := range g.joins[len(g.insns)] {
+		for i := range g.st.regs {
+			if g.st.regs[i] != st.regs[i] {
+				g.st.regs[i] = regUninit
+			}
+		}
+		for i := range g.st.stack {
+			g.st.stack[i] = g.st.stack[i] && st.stack[i]
+		}
+	}
+	delete(g.joins, len(g.insns))
+}
+
+// reg returns a random register of type typ (or any writable register if typ is regUninit).
+func (g *generator) reg(typ regType) (uint8, bool) {
+	var regs []uint8
+	for i := uint8(0); i < regFP; i++ {
+		if typ == regUninit || g.st.regs[i] == typ {
+			regs = append(regs, i)
+		}
+	}
+	if len(regs) == 0 {
+		return 0, false
+	}
+	return regs[g.r.Intn(len(regs))], true
+}
+
+func (g *generator) alu() {
+	class := uint8(classALU64)
+	if g.r.Intn(3) == 0 {
+		class = classALU
+	}
+	op := aluOps[g.r.Intn(len(aluOps))]
+	if op == aluMov {
+		dst, _ := g.reg(regUninit)
+		if src, ok := g.reg(regScalar); ok && g.r.Intn(2) == 0 {
+			g.emit(Insn{Code: class | op | srcX, Dst: dst, Src: src})
+		} else {
+			g.emit(Insn{Code: class | op | srcK, Dst: dst, Imm: randImm(g.r)})
+		}
+		g.st.regs[dst] = regScalar
+		return
+	}
+	dst, ok := g.reg(regScalar)
+	if !ok {
+		return
+	}
+	if op == aluNeg {
+		g.emit(Insn{Code: class | op, Dst: dst})
+		return
+	}
+	if src, ok := g.reg(regScalar); ok && g.r.Intn(2) == 0 {
+		g.emit(Insn{Code: class | op | srcX, Dst: dst, Src: src})
+		return
+	}
+	imm := randImm(g.r)
+	switch op {
+	case 0x30, 0x90: // div, mod: the verifier rejects division by constant zero
+		if imm == 0 {
+			imm = 1
+		}
+	case 0x60, 0x70, 0xc0: // shifts: the verifier rejects too large shifts
+		bits := int32(64)
+		if class == classALU {
+			bits = 32
+		}
+		imm = int32(g.r.Intn(int(bits)))
+	}
+	g.emit(Insn{Code: class | op | srcK, Dst: dst, Imm: imm})
+}
+
+// ldImm64 emits a 16-byte load of a 64-bit immediate.
+func (g *generator) ldImm64(n int) {
+	if len(g.insns)+2 > n || len(g.joins[len(g.insns)+1]) != 0 {
+		// Jumps must not land in the middle of the instruction.
+		return
+	}
+	dst, _ := g.reg(regUninit)
+	v := g.r.Int63()
+	g.emit(Insn{Code: classLD | sizeDW | modeIMM, Dst: dst, Imm: int32(v)})
+	g.emit(Insn{Imm: int32(v >> 32)})
+	g.st.regs[dst] = regScalar
+}
+
+func (g *generator) store() {
+	size := sizes[g.r.Intn(len(sizes))]
+	slot := g.r.Intn(len(g.st.stack))
+	off := -(slot+1)*8 + g.r.Intn(8/size.bytes)*size.bytes
+	if src, ok := g.reg(regScalar); ok && g.r.Intn(2) == 0 {
+		g.emit(Insn{Code: classSTX | size.code | modeMEM, Dst: regFP, Src: src, Off: int16(off)})
+	} else if src, ok := g.reg(regPtr); ok && size.bytes == 8 && g.r.Intn(2) == 0 {
+		// Pointer spill, the slot can't be read back as a scalar, so it's not marked.
+		g.emit(Insn{Code: classSTX | size.code | modeMEM, Dst: regFP, Src: src, Off: int16(off)})
+		g.st.stack[slot] = false
+		return
+	} else {
+		g.emit(Insn{Code: classST | size.code | modeMEM, Dst: regFP, Off: int16(off), Imm: randImm(g.r)})
+	}
+	if size.bytes == 8 {
+		g.st.stack[slot] = true
+	}
+}
+
+func (g *generator) load() {
+	var slots []int
+	for i, written := range g.st.stack {
+		if written {
+			slots = append(slots, i)
+		}
+	}
+	if len(slots) == 0 {
+		return
+	}
+	slot := slots[g.r.Intn(len(slots))]
+	size := sizes[g.r.Intn(len(sizes))]
+	off := -(slot+1)*8 + g.r.Intn(8/size.bytes)*size.bytes
+	dst, _ := g.reg(regUninit)
+	g.emit(Insn{Code: classLDX | size.code | modeMEM, Dst: dst, Src: regFP, Off: int16(off)})
+	g.st.regs[dst] = regScalar
+}
+
+// jump emits a forward jump, the target is at most the final mov r0 instruction.
+func (g *generator) jump(n int) {
+	maxOff := n - len(g.insns) - 1
+	if maxOff < 0 {
+		return
+	}
+	dst, ok := g.reg(regScalar)
+	if !ok {
+		return
+	}
+	// Only conditional jumps are generated, because the verifier rejects
+	// programs with unreachable instructions.
+	off := g.r.Intn(maxOff + 1)
+	target := len(g.insns) + 1 + off
+	op := jmpOps[g.r.Intn(len(jmpOps))]
+	if src, ok := g.reg(regScalar); ok && g.r.Intn(2) == 0 {
+		g.emit(Insn{Code: classJMP | op | srcX, Dst: dst, Src: src, Off: int16(off)})
+	} else {
+		g.emit(Insn{Code: classJMP | op | srcK, Dst: dst, Off: int16(off), Imm: randImm(g.r)})
+	}
+	g.joins[target] = append(g.joins[target], g.st)
+}
+
+func (g *generator) call() {
+	g.emit(Insn{Code: classJMP | jmpCall, Imm: helpers[g.r.Intn(len(helpers))]})
+	// Helpers clobber argument registers and return the result in r0.
+	for i := 1; i <= 5; i++ {
+		g.st.regs[i] = regUninit
+	}
+	g.st.regs[0] = regScalar
+}
+
+func randImm(r *rand.Rand) int32 {
+	switch r.Intn(3) {
+	case 0:
+		return specialImms[r.Intn(len(specialImms))]
+	case 1:
+		return int32(r.Intn(256))
+	default:
+		return int32(r.Uint32())
+	}
+}
+
+func randInsn(r *rand.Rand) Insn {
+	return Insn{
+		Code: uint8(r.Intn(256)),
+		Dst:  uint8(r.Intn(16)),
+		Src:  uint8(r.Intn(16)),
+		Off:  int16(r.Intn(1<<16) - 1<<15),
+		Imm:  randImm(r),
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package ebpf
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestGenerate(t *testing.T) {
+	seed := int64(time.Now().UnixNano())
+	t.Logf("seed=%v", seed)
+	r := rand.New(rand.NewSource(seed))
+	for i := 0; i < 1000; i++ {
+		// Random instructions may jump anywhere, so they are disabled.
+		g := &generator{r: r}
+		text := Encode(g.generate(1 + r.Intn(maxInsns)))
+		if len(text)%InsnSize != 0 {
+			t.Fatalf("program size %v is not a multiple of %v", len(text), InsnSize)
+		}
+		insns := Decode(text)
+		if !bytes.Equal(Encode(insns), text) {
+			t.Fatalf("encode/decode mismatch")
+		}
+		last := insns[len(insns)-1]
+		if last.Code != classJMP|jmpExit {
+			t.Fatalf("program does not end with exit: %+v", last)
+		}
+		// Instructions that start the second half of ld_imm64.
+		second := make(map[int]bool)
+		for pc := 0; pc < len(insns); pc++ {
+			if insns[pc].Code == classLD|sizeDW|modeIMM {
+				pc++
+				second[pc] = true
+			}
+		}
+		for pc, insn := range insns {
+			if second[pc] || insn.Code&0x7 != classJMP {
+				continue
+			}
+			op := insn.Code & 0xf0
+			if op == jmpCall || op == jmpExit {
+				continue
+			}
+			target := pc + 1 + int(insn.Off)
+			if target <= pc || target >= len(insns)-1 {
+				t.Fatalf("jump at %v to %v is out of range (%v instructions)", pc, target, len(insns))
+			}
+			if second[target] {
+				t.Fatalf("jump at %v lands in the middle of ld_imm64 at %v", pc, target-1)
+			}
+		}
+	}
+}
+
+func TestMutate(t *testing.T) {
+	seed := int64(time.Now().UnixNano())
+	t.Logf("seed=%v", seed)
+	r := rand.New(rand.NewSource(seed))
+	for i := 0; i < 1000; i++ {
+		text := Generate(r)
+		for j := 0; j < 10; j++ {
+			text = Mutate(r, text)
+			if len(text) == 0 || len(text)%InsnSize != 0 {
+				t.Fatalf("bad mutated program size %v", len(text))
+			}
+		}
+	}
+}
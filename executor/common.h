@@ -15,15 +15,21 @@
 #include <sys/socket.h>
 #include <sys/stat.h>
 #include <sys/syscall.h>
+#include <sys/sysmacros.h>
 #include <sys/time.h>
 #include <sys/types.h>
 #include <sys/wait.h>
 
+#include <linux/audit.h>
 #include <linux/capability.h>
+#include <linux/filter.h>
 #include <linux/if.h>
 #include <linux/if_tun.h>
 #include <linux/kvm.h>
+#include <linux/loop.h>
 #include <linux/sched.h>
+#include <linux/seccomp.h>
+#include <linux/usb/ch9.h>
 #include <net/if_arp.h>
 
 #include <assert.h>
@@ -184,7 +190,7 @@ static void install_segv_handler()
 		*(type*)(addr) = new_val;                                         \
 	}
 
-#ifdef __NR_syz_emit_ethernet
+#if defined(__NR_syz_emit_ethernet) || defined(SYZ_EXECUTOR) || defined(SYZ_NETNS)
 static void vsnprintf_check(char* str, size_t size, const char* format, va_list args)
 {
 	int rv;
@@ -220,7 +226,36 @@ static void execute_command(const char* format, ...)
 
 	va_end(args);
 }
+#endif
+
+#if defined(SYZ_EXECUTOR) || defined(SYZ_NETNS)
+// setup_netns moves the process into a fresh network namespace
+// and creates a standard set of virtual network devices there:
+// a bridge with one end of a veth pair attached to it.
+// Tests then can't break connectivity of the machine
+// and always start with the same network configuration.
+static void setup_netns()
+{
+	if (getuid() != 0)
+		return;
+	if (unshare(CLONE_NEWNET))
+		fail("netns: unshare(CLONE_NEWNET) failed");
+
+	execute_command("ip link set dev lo up");
+	execute_command("ip link add name syz_bridge0 type bridge");
+	execute_command("ip link add name syz_veth0 type veth peer name syz_veth1");
+	execute_command("ip link set dev syz_veth1 master syz_bridge0");
+	execute_command("ip addr add 172.30.0.1/24 dev syz_bridge0");
+	execute_command("ip addr add 172.30.0.2/24 dev syz_veth0");
+	execute_command("ip -6 addr add fd01::1/64 dev syz_bridge0");
+	execute_command("ip -6 addr add fd01::2/64 dev syz_veth0");
+	execute_command("ip link set dev syz_bridge0 up");
+	execute_command("ip link set dev syz_veth0 up");
+	execute_command("ip link set dev syz_veth1 up");
+}
+#endif
 
+#ifdef __NR_syz_emit_ethernet
 int tunfd = -1;
 
 // sysgen knowns about this constant (maxPids)
@@ -293,6 +328,270 @@ static uintptr_t syz_emit_ethernet(uintptr_t a0, uintptr_t a1)
 }
 #endif // __NR_syz_emit_ethernet
 
+#ifdef __NR_syz_usb_connect
+// Definitions from <linux/usb/raw_gadget.h> which is not present in older kernel headers.
+#define UDC_NAME_LENGTH_MAX 128
+
+struct usb_raw_init {
+	uint8_t driver_name[UDC_NAME_LENGTH_MAX];
+	uint8_t device_name[UDC_NAME_LENGTH_MAX];
+	uint8_t speed;
+};
+
+enum usb_raw_event_type {
+	USB_RAW_EVENT_INVALID = 0,
+	USB_RAW_EVENT_CONNECT = 1,
+	USB_RAW_EVENT_CONTROL = 2,
+};
+
+struct usb_raw_event {
+	uint32_t type;
+	uint32_t length;
+	uint8_t data[0];
+};
+
+struct usb_raw_ep_io {
+	uint16_t ep;
+	uint16_t flags;
+	uint32_t length;
+	uint8_t data[0];
+};
+
+#define USB_RAW_IOCTL_INIT _IOW('U', 0, struct usb_raw_init)
+#define USB_RAW_IOCTL_RUN _IO('U', 1)
+#define USB_RAW_IOCTL_EVENT_FETCH _IOR('U', 2, struct usb_raw_event)
+#define USB_RAW_IOCTL_EP0_WRITE _IOW('U', 3, struct usb_raw_ep_io)
+#define USB_RAW_IOCTL_EP0_READ _IOWR('U', 4, struct usb_raw_ep_io)
+#define USB_RAW_IOCTL_CONFIGURE _IO('U', 9)
+#define USB_RAW_IOCTL_VBUS_DRAW _IOW('U', 10, uint32_t)
+#define USB_RAW_IOCTL_EP0_STALL _IO('U', 12)
+
+#define USB_MAX_DEVICES 32
+#define USB_MAX_DESCRIPTORS_LEN 4096
+#define USB_MAX_CONTROL_REQUESTS 64
+
+struct usb_raw_control_event {
+	struct usb_raw_event inner;
+	struct usb_ctrlrequest ctrl;
+};
+
+struct usb_raw_control_io {
+	struct usb_raw_ep_io inner;
+	char data[USB_MAX_DESCRIPTORS_LEN];
+};
+
+// String descriptor 0 contains the list of supported languages (en-US),
+// all other string descriptors contain the same string.
+static const char usb_lang_descriptor[] = {4, USB_DT_STRING, 0x09, 0x04};
+static const char usb_string_descriptor[] = {20, USB_DT_STRING, 's', 0, 'y', 0, 'z', 0, 'k', 0, 'a', 0, 'l', 0, 'l', 0, 'e', 0, 'r', 0};
+
+static int usb_raw_open(uint8_t speed)
+{
+	// dummy_hcd can be loaded with several UDCs (num=N),
+	// take the first one that is not used by other test processes.
+	for (int i = 0; i < USB_MAX_DEVICES; i++) {
+		int fd = open("/dev/raw-gadget", O_RDWR);
+		if (fd < 0)
+			return -1;
+		struct usb_raw_init init;
+		memset(&init, 0, sizeof(init));
+		strcpy((char*)init.driver_name, "dummy_udc");
+		sprintf((char*)init.device_name, "dummy_udc.%d", i);
+		init.speed = speed;
+		if (ioctl(fd, USB_RAW_IOCTL_INIT, &init) == 0 && ioctl(fd, USB_RAW_IOCTL_RUN, 0) == 0)
+			return fd;
+		close(fd);
+	}
+	return -1;
+}
+
+static uintptr_t syz_usb_connect(uintptr_t a0, uintptr_t a1, uintptr_t a2)
+{
+	// syz_usb_connect(speed flags[usb_device_speed], dev_len len[dev], dev ptr[in, usb_device_descriptor]) fd_usb
+	uint64_t speed = a0;
+	uint64_t dev_len = a1;
+	char* dev = (char*)a2;
+	// The device descriptor is followed by a single configuration descriptor
+	// with all its interface and endpoint descriptors.
+	static __thread char descs[USB_MAX_DESCRIPTORS_LEN];
+	if (dev_len < USB_DT_DEVICE_SIZE + USB_DT_CONFIG_SIZE || dev_len > sizeof(descs))
+		return -1;
+	memset(descs, 0, sizeof(descs));
+	NONFAILING(memcpy(descs, dev, dev_len));
+	char* config = descs + USB_DT_DEVICE_SIZE;
+	uint64_t config_len = dev_len - USB_DT_DEVICE_SIZE;
+
+	int fd = usb_raw_open(speed);
+	if (fd < 0)
+		return -1;
+
+	for (int i = 0; i < USB_MAX_CONTROL_REQUESTS; i++) {
+		struct usb_raw_control_event event;
+		memset(&event, 0, sizeof(event));
+		event.inner.length = sizeof(event.ctrl);
+		if (ioctl(fd, USB_RAW_IOCTL_EVENT_FETCH, &event)) {
+			close(fd);
+			return -1;
+		}
+		if (event.inner.type != USB_RAW_EVENT_CONTROL)
+			continue;
+
+		struct usb_ctrlrequest* ctrl = &event.ctrl;
+		const char* data = NULL;
+		uint32_t length = 0;
+		bool configured = false;
+		bool stall = true;
+		if ((ctrl->bRequestType & USB_TYPE_MASK) == USB_TYPE_STANDARD) {
+			switch (ctrl->bRequest) {
+			case USB_REQ_GET_DESCRIPTOR:
+				switch (ctrl->wValue >> 8) {
+				case USB_DT_DEVICE:
+					data = descs;
+					length = USB_DT_DEVICE_SIZE;
+					stall = false;
+					break;
+				case USB_DT_CONFIG:
+					data = config;
+					length = config_len;
+					stall = false;
+					break;
+				case USB_DT_STRING:
+					if ((ctrl->wValue & 0xff) == 0) {
+						data = usb_lang_descriptor;
+						length = sizeof(usb_lang_descriptor);
+					} else {
+						data = usb_string_descriptor;
+						length = sizeof(usb_string_descriptor);
+					}
+					stall = false;
+					break;
+				}
+				break;
+			case USB_REQ_SET_CONFIGURATION:
+				if (ioctl(fd, USB_RAW_IOCTL_VBUS_DRAW, (uint32_t)(uint8_t)config[8])) {
+					close(fd);
+					return -1;
+				}
+				if (ioctl(fd, USB_RAW_IOCTL_CONFIGURE, 0)) {
+					close(fd);
+					return -1;
+				}
+				configured = true;
+				stall = false;
+				break;
+			}
+		}
+		if (stall) {
+			ioctl(fd, USB_RAW_IOCTL_EP0_STALL, 0);
+			continue;
+		}
+
+		struct usb_raw_control_io response;
+		memset(&response, 0, sizeof(response.inner));
+		if (length > ctrl->wLength)
+			length = ctrl->wLength;
+		response.inner.length = length;
+		int rv;
+		if (ctrl->bRequestType & USB_DIR_IN) {
+			if (length)
+				memcpy(response.data, data, length);
+			rv = ioctl(fd, USB_RAW_IOCTL_EP0_WRITE, &response);
+		} else {
+			rv = ioctl(fd, USB_RAW_IOCTL_EP0_READ, &response);
+		}
+		if (rv < 0) {
+			close(fd);
+			return -1;
+		}
+		if (configured)
+			return fd;
+	}
+	close(fd);
+	return -1;
+}
+#endif // __NR_syz_usb_connect
+
+#ifdef __NR_syz_usb_disconnect
+static uintptr_t syz_usb_disconnect(uintptr_t a0)
+{
+	// syz_usb_disconnect(fd fd_usb)
+	return close(a0);
+}
+#endif
+
+#ifdef __NR_syz_binder_transaction
+// Definitions from <linux/android/binder.h> which is not present in older kernel headers.
+struct binder_write_read {
+	uint64_t write_size;
+	uint64_t write_consumed;
+	uint64_t write_buffer;
+	uint64_t read_size;
+	uint64_t read_consumed;
+	uint64_t read_buffer;
+};
+
+struct binder_transaction_data {
+	uint64_t target;
+	uint64_t cookie;
+	uint32_t code;
+	uint32_t flags;
+	int32_t sender_pid;
+	uint32_t sender_euid;
+	uint64_t data_size;
+	uint64_t offsets_size;
+	uint64_t buffer;
+	uint64_t offsets;
+};
+
+#define BINDER_WRITE_READ _IOWR('b', 1, struct binder_write_read)
+
+// Size of binder_object union in sys/binder.txt, all objects are padded to it.
+#define BINDER_OBJECT_SIZE 40
+#define BINDER_MAX_OBJECTS 8
+#define BINDER_MAX_DATA (4 << 10)
+
+static uintptr_t syz_binder_transaction(uintptr_t a0, uintptr_t a1, uintptr_t a2, uintptr_t a3, uintptr_t a4, uintptr_t a5, uintptr_t a6, uintptr_t a7, uintptr_t a8)
+{
+	// syz_binder_transaction(fd fd_binder, cmd flags[binder_transaction_cmd], handle intptr[0:4], code int32, flags flags[binder_transaction_flags], objs ptr[in, array[binder_object, 0:8]], nobjs len[objs], data buffer[in], size len[data])
+	// The parcel consists of the objects followed by the plain data.
+	// Kernel needs offsets of all objects in the parcel, since descriptions
+	// can't express them we compute them here.
+	static __thread char parcel[BINDER_MAX_OBJECTS * BINDER_OBJECT_SIZE + BINDER_MAX_DATA];
+	static __thread uint64_t offsets[BINDER_MAX_OBJECTS];
+	uint64_t nobjs = a6;
+	uint64_t size = a8;
+	if (nobjs > BINDER_MAX_OBJECTS || size > BINDER_MAX_DATA) {
+		errno = EINVAL;
+		return -1;
+	}
+	uint64_t objs_size = nobjs * BINDER_OBJECT_SIZE;
+	NONFAILING(memcpy(parcel, (char*)a5, objs_size));
+	NONFAILING(memcpy(parcel + objs_size, (char*)a7, size));
+	for (uint64_t i = 0; i < nobjs; i++)
+		offsets[i] = i * BINDER_OBJECT_SIZE;
+
+	struct {
+		uint32_t cmd;
+		struct binder_transaction_data tr;
+	} __attribute__((packed)) cmd;
+	memset(&cmd, 0, sizeof(cmd));
+	cmd.cmd = a1;
+	cmd.tr.target = (uint32_t)a2;
+	cmd.tr.code = a3;
+	cmd.tr.flags = a4;
+	cmd.tr.data_size = objs_size + size;
+	cmd.tr.offsets_size = nobjs * sizeof(offsets[0]);
+	cmd.tr.buffer = (uint64_t)(uintptr_t)parcel;
+	cmd.tr.offsets = (uint64_t)(uintptr_t)offsets;
+
+	struct binder_write_read bwr;
+	memset(&bwr, 0, sizeof(bwr));
+	bwr.write_size = sizeof(cmd);
+	bwr.write_buffer = (uint64_t)(uintptr_t)&cmd;
+	return ioctl(a0, BINDER_WRITE_READ, &bwr);
+}
+#endif // __NR_syz_binder_transaction
+
 #ifdef __NR_syz_open_dev
 static uintptr_t syz_open_dev(uintptr_t a0, uintptr_t a1, uintptr_t a2)
 {
@@ -392,6 +691,112 @@ static uintptr_t syz_fuseblk_mount(uintptr_t a0, uintptr_t a1, uintptr_t a2, uin
 }
 #endif
 
+#ifdef __NR_syz_mount_image
+#ifndef __NR_memfd_create
+#if defined(__x86_64__)
+#define __NR_memfd_create 319
+#elif defined(__aarch64__)
+#define __NR_memfd_create 279
+#elif defined(__ppc64__) || defined(__PPC64__) || defined(__powerpc64__)
+#define __NR_memfd_create 360
+#endif
+#endif
+
+struct fs_image_segment {
+	void* data;
+	uintptr_t size;
+	uintptr_t offset;
+};
+
+#define IMAGE_MAX_SEGMENTS 4096
+#define IMAGE_MAX_SIZE (32 << 20)
+
+static uintptr_t syz_mount_image(uintptr_t a0, uintptr_t a1, uintptr_t a2, uintptr_t a3, uintptr_t a4, uintptr_t a5, uintptr_t a6)
+{
+	// syz_mount_image(fs ptr[in, string], dir ptr[in, filename], size intptr, nsegs len[segments], segments ptr[in, array[fs_image_segment]], flags flags[mount_flags], opts buffer[in])
+	char* fs = (char*)a0;
+	char* dir = (char*)a1;
+	uintptr_t size = a2;
+	uintptr_t nsegs = a3;
+	struct fs_image_segment* segs = (struct fs_image_segment*)a4;
+	uintptr_t flags = a5;
+	char* opts = (char*)a6;
+	char loopname[64];
+	int memfd, loopfd, loopno, err = 0, res = -1;
+	uintptr_t i;
+
+	// The image is created in a memfd with the segments written at their offsets,
+	// and then attached to a free loop device which is mounted.
+	memfd = syscall(__NR_memfd_create, "syz_mount_image", 0);
+	if (memfd == -1) {
+		err = errno;
+		goto error;
+	}
+	if (nsegs > IMAGE_MAX_SEGMENTS)
+		nsegs = IMAGE_MAX_SEGMENTS;
+	for (i = 0; i < nsegs; i++) {
+		struct fs_image_segment seg;
+		memset(&seg, 0, sizeof(seg));
+		NONFAILING(seg = segs[i]);
+		if (seg.size > IMAGE_MAX_SIZE)
+			seg.size = IMAGE_MAX_SIZE;
+		seg.offset %= IMAGE_MAX_SIZE;
+		if (seg.offset > IMAGE_MAX_SIZE - seg.size)
+			seg.offset = IMAGE_MAX_SIZE - seg.size;
+		if (size < seg.offset + seg.size)
+			size = seg.offset + seg.size;
+		if (pwrite(memfd, seg.data, seg.size, seg.offset) < 0) {
+			// Errors (e.g. EFAULT for a bad data pointer) leave a hole in the image.
+		}
+	}
+	if (size > IMAGE_MAX_SIZE)
+		size = IMAGE_MAX_SIZE;
+	if (ftruncate(memfd, size)) {
+		err = errno;
+		goto error_close_memfd;
+	}
+	// Several test processes can get the same free device, so retry on EBUSY.
+	for (i = 0;; i++) {
+		int ctlfd = open("/dev/loop-control", O_RDWR);
+		if (ctlfd == -1) {
+			err = errno;
+			goto error_close_memfd;
+		}
+		loopno = ioctl(ctlfd, LOOP_CTL_GET_FREE);
+		close(ctlfd);
+		if (loopno < 0) {
+			err = errno;
+			goto error_close_memfd;
+		}
+		sprintf(loopname, "/dev/loop%d", loopno);
+		loopfd = open(loopname, O_RDWR);
+		if (loopfd == -1) {
+			err = errno;
+			goto error_close_memfd;
+		}
+		if (ioctl(loopfd, LOOP_SET_FD, memfd) == 0)
+			break;
+		err = errno;
+		close(loopfd);
+		if (err != EBUSY || i == 10)
+			goto error_close_memfd;
+		usleep(1000);
+	}
+	mkdir(dir, 0777);
+	res = mount(loopname, dir, fs, flags, opts);
+	if (res == -1)
+		err = errno;
+	// If the device is mounted, this only marks it for autoclear on unmount.
+	ioctl(loopfd, LOOP_CLR_FD, 0);
+	close(loopfd);
+error_close_memfd:
+	close(memfd);
+error:
+	errno = err;
+	return res;
+}
+#endif
+
 #ifdef __NR_syz_kvm_setup_cpu
 #if defined(__x86_64__)
 #include "common_kvm_amd64.h"
@@ -400,11 +805,77 @@ static uintptr_t syz_fuseblk_mount(uintptr_t a0, uintptr_t a1, uintptr_t a2, uin
 #endif
 #endif // #ifdef __NR_syz_kvm_setup_cpu
 
+#if defined(SYZ_EXECUTOR) || defined(SYZ_FAULT_INJECTION)
+static void setup_fault()
+{
+	static struct {
+		const char* file;
+		const char* val;
+		bool fatal;
+	} files[] = {
+	    {"/sys/kernel/debug/failslab/ignore-gfp-wait", "N", true},
+	    {"/sys/kernel/debug/fail_futex/ignore-private", "N", false},
+	    {"/sys/kernel/debug/fail_page_alloc/ignore-gfp-highmem", "N", false},
+	    {"/sys/kernel/debug/fail_page_alloc/ignore-gfp-wait", "N", false},
+	    {"/sys/kernel/debug/fail_page_alloc/min-order", "0", false},
+	};
+	unsigned i;
+	for (i = 0; i < sizeof(files) / sizeof(files[0]); i++) {
+		int fd = open(files[i].file, O_WRONLY);
+		if (fd == -1) {
+			if (files[i].fatal)
+				fail("failed to open %s", files[i].file);
+			continue;
+		}
+		if (write(fd, files[i].val, strlen(files[i].val)) != (ssize_t)strlen(files[i].val)) {
+			if (files[i].fatal)
+				fail("failed to write %s", files[i].file);
+		}
+		close(fd);
+	}
+}
+
+// inject_fault arranges for the nth (0-based) fault site in the current thread to fail.
+// Returns fd that needs to be passed to fault_injected after the call.
+static int inject_fault(int nth)
+{
+	int fd;
+	char buf[16];
+
+	fd = open("/proc/thread-self/fail-nth", O_RDWR);
+	if (fd == -1)
+		fail("failed to open /proc/thread-self/fail-nth");
+	sprintf(buf, "%d", nth + 1);
+	if (write(fd, buf, strlen(buf)) != (ssize_t)strlen(buf))
+		fail("failed to write /proc/thread-self/fail-nth");
+	return fd;
+}
+
+// fault_injected checks whether the fault was actually injected
+// (kernel resets fail-nth to 0 after injecting the fault), disarms it and closes fd.
+static bool fault_injected(int fd)
+{
+	char buf[16];
+	int n = read(fd, buf, sizeof(buf) - 1);
+	if (n <= 0)
+		fail("failed to read /proc/thread-self/fail-nth");
+	bool res = n == 2 && buf[0] == '0' && buf[1] == '\n';
+	buf[0] = '0';
+	if (write(fd, buf, 1) != 1)
+		fail("failed to write /proc/thread-self/fail-nth");
+	close(fd);
+	return res;
+}
+#endif
+
+// Pseudo-syscall plugins, generated by sysgen from executor/pseudo/*.h.
+#include "pseudo.h"
+
 static uintptr_t execute_syscall(int nr, uintptr_t a0, uintptr_t a1, uintptr_t a2, uintptr_t a3, uintptr_t a4, uintptr_t a5, uintptr_t a6, uintptr_t a7, uintptr_t a8)
 {
 	switch (nr) {
 	default:
-		return syscall(nr, a0, a1, a2, a3, a4, a5);
+		return execute_pseudo_syscall(nr, a0, a1, a2, a3, a4, a5, a6, a7, a8);
 #ifdef __NR_syz_test
 	case __NR_syz_test:
 		return 0;
@@ -429,14 +900,31 @@ static uintptr_t execute_syscall(int nr, uintptr_t a0, uintptr_t a1, uintptr_t a
 	case __NR_syz_emit_ethernet:
 		return syz_emit_ethernet(a0, a1);
 #endif
+#ifdef __NR_syz_mount_image
+	case __NR_syz_mount_image:
+		return syz_mount_image(a0, a1, a2, a3, a4, a5, a6);
+#endif
 #ifdef __NR_syz_kvm_setup_cpu
 	case __NR_syz_kvm_setup_cpu:
 		return syz_kvm_setup_cpu(a0, a1, a2, a3, a4, a5, a6, a7);
+#endif
+#ifdef __NR_syz_usb_connect
+	case __NR_syz_usb_connect:
+		return syz_usb_connect(a0, a1, a2);
+#endif
+#ifdef __NR_syz_usb_disconnect
+	case __NR_syz_usb_disconnect:
+		return syz_usb_disconnect(a0);
+#endif
+#ifdef __NR_syz_binder_transaction
+	case __NR_syz_binder_transaction:
+		return syz_binder_transaction(a0, a1, a2, a3, a4, a5, a6, a7, a8);
 #endif
 	}
 }
 
-static void setup_main_process(uint64_t pid, bool enable_tun)
+#if defined(SYZ_EXECUTOR) || defined(SYZ_SANDBOX_NONE) || defined(SYZ_SANDBOX_SETUID) || defined(SYZ_SANDBOX_NAMESPACE)
+static void setup_main_process(uint64_t pid, bool enable_tun, bool enable_netns)
 {
 	// Don't need that SIGCANCEL/SIGSETXID glibc stuff.
 	// SIGCANCEL sent to main thread causes it to exit
@@ -448,6 +936,11 @@ static void setup_main_process(uint64_t pid, bool enable_tun)
 	syscall(SYS_rt_sigaction, 0x21, &sa, NULL, 8);
 	install_segv_handler();
 
+#if defined(SYZ_EXECUTOR) || defined(SYZ_NETNS)
+	// Must go before tun initialization, so that tun device is created in the new namespace.
+	if (enable_netns)
+		setup_netns();
+#endif
 #ifdef __NR_syz_emit_ethernet
 	if (enable_tun)
 		initialize_tun(pid);
@@ -486,6 +979,7 @@ static void sandbox_common()
 	unshare(CLONE_NEWIPC);
 	unshare(CLONE_IO);
 }
+#endif
 
 #if defined(SYZ_EXECUTOR) || defined(SYZ_SANDBOX_NONE)
 static int do_sandbox_none()
@@ -547,6 +1041,62 @@ static bool write_file(const char* file, const char* what, ...)
 	return true;
 }
 
+#if defined(SYZ_EXECUTOR)
+const int kMaxSeccompDeny = 64;
+static int seccomp_deny[kMaxSeccompDeny];
+static int seccomp_deny_count;
+
+// Some administrative syscalls (e.g. reboot or kexec_load) are still allowed
+// inside of the namespace sandbox and can take down the whole machine.
+// Make them fail with EPERM with a seccomp filter.
+// Syscall numbers differ between architectures, so syscalls made via
+// a compat entry (e.g. int $0x80 on x86_64) fail with EPERM as well,
+// otherwise they would bypass the filter.
+#if defined(__x86_64__)
+#define SYZ_AUDIT_ARCH AUDIT_ARCH_X86_64
+#elif defined(__i386__)
+#define SYZ_AUDIT_ARCH AUDIT_ARCH_I386
+#elif defined(__aarch64__)
+#define SYZ_AUDIT_ARCH AUDIT_ARCH_AARCH64
+#elif defined(__arm__)
+#define SYZ_AUDIT_ARCH AUDIT_ARCH_ARM
+#elif defined(__ppc64__) || defined(__PPC64__) || defined(__powerpc64__)
+#if __BYTE_ORDER__ == __ORDER_LITTLE_ENDIAN__
+#define SYZ_AUDIT_ARCH AUDIT_ARCH_PPC64LE
+#else
+#define SYZ_AUDIT_ARCH AUDIT_ARCH_PPC64
+#endif
+#endif
+
+static void setup_seccomp_deny()
+{
+	if (seccomp_deny_count == 0)
+		return;
+	struct sock_filter filter[2 * kMaxSeccompDeny + 7];
+	int n = 0;
+	filter[n++] = (struct sock_filter)BPF_STMT(BPF_LD | BPF_W | BPF_ABS, offsetof(struct seccomp_data, arch));
+	filter[n++] = (struct sock_filter)BPF_JUMP(BPF_JMP | BPF_JEQ | BPF_K, SYZ_AUDIT_ARCH, 1, 0);
+	filter[n++] = (struct sock_filter)BPF_STMT(BPF_RET | BPF_K, SECCOMP_RET_ERRNO | EPERM);
+	filter[n++] = (struct sock_filter)BPF_STMT(BPF_LD | BPF_W | BPF_ABS, offsetof(struct seccomp_data, nr));
+	// x32 syscalls have the same arch as x86_64 ones, but numbers with __X32_SYSCALL_BIT set.
+	filter[n++] = (struct sock_filter)BPF_JUMP(BPF_JMP | BPF_JGE | BPF_K, 0x40000000, 0, 1);
+	filter[n++] = (struct sock_filter)BPF_STMT(BPF_RET | BPF_K, SECCOMP_RET_ERRNO | EPERM);
+	for (int i = 0; i < seccomp_deny_count; i++) {
+		filter[n++] = (struct sock_filter)BPF_JUMP(BPF_JMP | BPF_JEQ | BPF_K, (uint32_t)seccomp_deny[i], 0, 1);
+		filter[n++] = (struct sock_filter)BPF_STMT(BPF_RET | BPF_K, SECCOMP_RET_ERRNO | EPERM);
+	}
+	filter[n++] = (struct sock_filter)BPF_STMT(BPF_RET | BPF_K, SECCOMP_RET_ALLOW);
+	struct sock_fprog prog = {};
+	prog.len = n;
+	prog.filter = filter;
+	if (prctl(PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0))
+		fail("prctl(PR_SET_NO_NEW_PRIVS) failed");
+	if (prctl(PR_SET_SECCOMP, SECCOMP_MODE_FILTER, &prog, 0, 0))
+		fail("prctl(PR_SET_SECCOMP) failed");
+	debug("installed seccomp filter for %d syscalls\n", seccomp_deny_count);
+}
+#endif
+
 static int namespace_sandbox_proc(void* arg)
 {
 	sandbox_common();
@@ -601,6 +1151,10 @@ static int namespace_sandbox_proc(void* arg)
 	if (syscall(SYS_capset, &cap_hdr, &cap_data))
 		fail("capset failed");
 
+#if defined(SYZ_EXECUTOR)
+	setup_seccomp_deny();
+#endif
+
 	loop();
 	doexit(1);
 }
@@ -267,7 +267,12 @@ struct kvm_opt {
 #define KVM_SETUP_SMM (1 << 5)
 #define KVM_SETUP_VM (1 << 6)
 
-// syz_kvm_setup_cpu(fd fd_kvmvm, cpufd fd_kvmcpu, usermem vma[24], text ptr[in, array[kvm_text, 1]], ntext len[text], flags flags[kvm_setup_flags], opts ptr[in, array[kvm_setup_opt, 0:2]], nopt len[opts])
+// Options with type KVM_SETUP_OPT_REG + N set N-th general purpose register
+// (in struct kvm_regs order: rax, rbx, rcx, rdx, rsi, rdi, rsp, rbp, r8-r15).
+#define KVM_SETUP_OPT_REG 0x100
+#define KVM_SETUP_MAX_OPTS 4
+
+// syz_kvm_setup_cpu(fd fd_kvmvm, cpufd fd_kvmcpu, usermem vma[24], text ptr[in, array[kvm_text, 1]], ntext len[text], flags flags[kvm_setup_flags], opts ptr[in, array[kvm_setup_opt, 0:4]], nopt len[opts])
 static uintptr_t syz_kvm_setup_cpu(uintptr_t a0, uintptr_t a1, uintptr_t a2, uintptr_t a3, uintptr_t a4, uintptr_t a5, uintptr_t a6, uintptr_t a7)
 {
 	const int vmfd = a0;
@@ -322,8 +327,12 @@ static uintptr_t syz_kvm_setup_cpu(uintptr_t a0, uintptr_t a1, uintptr_t a2, uin
 	regs.rip = guest_mem + ADDR_TEXT;
 	regs.rsp = ADDR_STACK0;
 
-	if (opt_count > 2)
-		opt_count = 2;
+	struct kvm_debugregs dregs;
+	memset(&dregs, 0, sizeof(dregs));
+	bool set_dregs = false;
+
+	if (opt_count > KVM_SETUP_MAX_OPTS)
+		opt_count = KVM_SETUP_MAX_OPTS;
 	for (i = 0; i < opt_count; i++) {
 		uint64_t typ = 0;
 		uint64_t val = 0;
@@ -345,6 +354,14 @@ static uintptr_t syz_kvm_setup_cpu(uintptr_t a0, uintptr_t a1, uintptr_t a2, uin
 			regs.rflags ^= val & ((1 << 8) | (1 << 9) | (1 << 10) | (1 << 12) | (1 << 13) | (1 << 14) |
 					      (1 << 15) | (1 << 18) | (1 << 19) | (1 << 20) | (1 << 21));
 			break;
+		case 5:
+			dregs.dr7 = val;
+			set_dregs = true;
+			break;
+		default:
+			if (typ >= KVM_SETUP_OPT_REG && typ < KVM_SETUP_OPT_REG + 16)
+				((uint64_t*)&regs)[typ - KVM_SETUP_OPT_REG] = val;
+			break;
 		}
 	}
 	regs.rflags |= 2; // bit 1 is always set
@@ -764,5 +781,7 @@ static uintptr_t syz_kvm_setup_cpu(uintptr_t a0, uintptr_t a1, uintptr_t a2, uin
 		return -1;
 	if (ioctl(cpufd, KVM_SET_REGS, &regs))
 		return -1;
+	if (set_dregs && ioctl(cpufd, KVM_SET_DEBUGREGS, &dregs))
+		return -1;
 	return 0;
 }
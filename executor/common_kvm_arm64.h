@@ -16,7 +16,12 @@ struct kvm_opt {
 	uint64_t val;
 };
 
-// syz_kvm_setup_cpu(fd fd_kvmvm, cpufd fd_kvmcpu, usermem vma[24], text ptr[in, array[kvm_text, 1]], ntext len[text], flags flags[kvm_setup_flags], opts ptr[in, array[kvm_setup_opt, 0:2]], nopt len[opts])
+// Options with type KVM_SETUP_OPT_REG + N set N-th core register
+// (in struct user_pt_regs order: x0-x30, sp, pc).
+#define KVM_SETUP_OPT_REG 0x100
+#define KVM_SETUP_MAX_OPTS 4
+
+// syz_kvm_setup_cpu(fd fd_kvmvm, cpufd fd_kvmcpu, usermem vma[24], text ptr[in, array[kvm_text, 1]], ntext len[text], flags flags[kvm_setup_flags], opts ptr[in, array[kvm_setup_opt, 0:4]], nopt len[opts])
 static uintptr_t syz_kvm_setup_cpu(uintptr_t a0, uintptr_t a1, uintptr_t a2, uintptr_t a3, uintptr_t a4, uintptr_t a5, uintptr_t a6, uintptr_t a7)
 {
 	const int vmfd = a0;
@@ -29,7 +34,6 @@ static uintptr_t syz_kvm_setup_cpu(uintptr_t a0, uintptr_t a1, uintptr_t a2, uin
 	uintptr_t opt_count = a7;
 
 	(void)flags;
-	(void)opt_count;
 
 	const uintptr_t page_size = 4 << 10;
 	const uintptr_t guest_mem = 0;
@@ -43,11 +47,13 @@ static uintptr_t syz_kvm_setup_cpu(uintptr_t a0, uintptr_t a1, uintptr_t a2, uin
 	NONFAILING(text = text_array_ptr[0].text);
 	NONFAILING(text_size = text_array_ptr[0].size);
 	(void)text_type;
-	(void)opt_array_ptr;
 
 	uint32_t features = 0;
-	if (opt_count > 1)
-		opt_count = 1;
+	uint64_t reg_vals[KVM_SETUP_MAX_OPTS];
+	uint64_t reg_ids[KVM_SETUP_MAX_OPTS];
+	int nregs = 0;
+	if (opt_count > KVM_SETUP_MAX_OPTS)
+		opt_count = KVM_SETUP_MAX_OPTS;
 	uintptr_t i;
 	for (i = 0; i < opt_count; i++) {
 		uint64_t typ = 0;
@@ -58,6 +64,15 @@ static uintptr_t syz_kvm_setup_cpu(uintptr_t a0, uintptr_t a1, uintptr_t a2, uin
 		case 1:
 			features = val;
 			break;
+		default:
+			if (typ >= KVM_SETUP_OPT_REG && typ <= KVM_SETUP_OPT_REG + 32) {
+				// Registers can be set only after KVM_ARM_VCPU_INIT, so remember them for now.
+				reg_ids[nregs] = KVM_REG_ARM64 | KVM_REG_SIZE_U64 | KVM_REG_ARM_CORE |
+						 (offsetof(struct kvm_regs, regs) + (typ - KVM_SETUP_OPT_REG) * sizeof(uint64_t)) / sizeof(uint32_t);
+				reg_vals[nregs] = val;
+				nregs++;
+			}
+			break;
 		}
 	}
 
@@ -76,6 +91,14 @@ static uintptr_t syz_kvm_setup_cpu(uintptr_t a0, uintptr_t a1, uintptr_t a2, uin
 	init.features[0] = features;
 	ioctl(cpufd, KVM_ARM_VCPU_INIT, &init);
 
+	int r;
+	for (r = 0; r < nregs; r++) {
+		struct kvm_one_reg reg;
+		reg.id = reg_ids[r];
+		reg.addr = (uintptr_t)&reg_vals[r];
+		ioctl(cpufd, KVM_SET_ONE_REG, &reg);
+	}
+
 	if (text_size > 1000)
 		text_size = 1000;
 	NONFAILING(memcpy(host_mem, text, text_size));
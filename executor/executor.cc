@@ -38,6 +38,12 @@
 #define KCOV_ENABLE _IO('c', 100)
 #define KCOV_DISABLE _IO('c', 101)
 
+const unsigned long KCOV_TRACE_PC = 0;
+const unsigned long KCOV_TRACE_CMP = 1;
+
+// Comparison record layout in KCOV_TRACE_CMP mode: type, arg1, arg2, pc.
+const int kcov_cmp_words = 4;
+
 const int kInFd = 3;
 const int kOutFd = 4;
 const int kInPipeFd = 5;
@@ -49,6 +55,12 @@ const int kMaxThreads = 16;
 const int kMaxCommands = 4 << 10;
 const int kCoverSize = 64 << 10;
 
+// Handshake with fuzzer that chooses between shared memory and pipe transport (see ipc.go).
+const uint64_t kHandshakeMagic = 0xbadc0ffeebadface;
+const uint64_t kShmemVersion = 1;
+const uint64_t kTransportShmem = 0;
+const uint64_t kTransportPipe = 1;
+
 const uint64_t instr_eof = -1;
 const uint64_t instr_copyin = -2;
 const uint64_t instr_copyout = -3;
@@ -75,6 +87,18 @@ bool flag_deduplicate;
 bool flag_sandbox_privs;
 sandbox_type flag_sandbox;
 bool flag_enable_tun;
+bool flag_enable_fault_injection;
+bool flag_enable_netns;
+bool flag_edge_signal;
+bool flag_pipe;
+
+// Max time to wait for a call to complete in threaded mode before proceeding to the next call.
+uint64_t flag_call_timeout_ms;
+
+bool flag_inject_fault;
+bool flag_collect_comps;
+int flag_fault_call;
+int flag_fault_nth;
 
 __attribute__((aligned(64 << 10))) char input_data[kMaxInput];
 __attribute__((aligned(64 << 10))) char output_data[kMaxOutput];
@@ -107,11 +131,21 @@ struct thread_t {
 	uint64_t res;
 	uint64_t reserrno;
 	uint64_t cover_size;
+	uint64_t comps_size;
+	uint64_t signal_size;
+	bool fault_injected;
+	uint64_t duration_ms;
 	int cover_fd;
+	uint32_t signal_data[kCoverSize];
 };
 
 thread_t threads[kMaxThreads];
 
+void handshake();
+void read_pipe(void* buf, uint64_t size);
+void write_pipe(const void* buf, uint64_t size);
+void read_pipe_input();
+void write_pipe_output();
 void execute_one();
 uint64_t read_input(uint64_t** input_posp, bool peek = false);
 uint64_t read_arg(uint64_t** input_posp);
@@ -130,6 +164,8 @@ void cover_enable(thread_t* th);
 void cover_reset(thread_t* th);
 uint64_t cover_read(thread_t* th);
 uint64_t cover_dedup(thread_t* th, uint64_t n);
+uint64_t signal_build(thread_t* th, uint64_t n);
+uint64_t comps_dedup(thread_t* th, uint64_t n);
 
 int main(int argc, char** argv)
 {
@@ -137,18 +173,24 @@ int main(int argc, char** argv)
 		reboot(LINUX_REBOOT_CMD_RESTART);
 		return 0;
 	}
+	for (int i = 1; i < argc; i++) {
+		const char* nrs = argv[i];
+		if (strncmp(nrs, "seccomp_deny=", strlen("seccomp_deny=")) != 0)
+			fail("unknown argument '%s'", argv[i]);
+		nrs += strlen("seccomp_deny=");
+		while (*nrs) {
+			if (seccomp_deny_count == kMaxSeccompDeny)
+				fail("too many denied syscalls");
+			char* end = NULL;
+			seccomp_deny[seccomp_deny_count++] = strtol(nrs, &end, 10);
+			if (end == nrs || (*end != ',' && *end != 0))
+				fail("bad seccomp_deny argument '%s'", argv[i]);
+			nrs = *end ? end + 1 : end;
+		}
+	}
 
 	prctl(PR_SET_PDEATHSIG, SIGKILL, 0, 0, 0);
-	if (mmap(&input_data[0], kMaxInput, PROT_READ, MAP_PRIVATE | MAP_FIXED, kInFd, 0) != &input_data[0])
-		fail("mmap of input file failed");
-	if (mmap(&output_data[0], kMaxOutput, PROT_READ | PROT_WRITE, MAP_SHARED | MAP_FIXED, kOutFd, 0) != &output_data[0])
-		fail("mmap of output file failed");
-	// Prevent random programs to mess with these fds.
-	// Due to races in collider mode, a program can e.g. ftruncate one of these fds,
-	// which will cause fuzzer to crash.
-	// That's also the reason why we close kInPipeFd/kOutPipeFd below.
-	close(kInFd);
-	close(kOutFd);
+	handshake();
 
 	uint64_t flags = *(uint64_t*)input_data;
 	flag_debug = flags & (1 << 0);
@@ -164,10 +206,18 @@ int main(int argc, char** argv)
 	if (!flag_threaded)
 		flag_collide = false;
 	flag_enable_tun = flags & (1 << 7);
+	flag_enable_fault_injection = flags & (1 << 8);
+	flag_enable_netns = flags & (1 << 9);
+	flag_edge_signal = flags & (1 << 10);
 	uint64_t executor_pid = *((uint64_t*)input_data + 1);
+	flag_call_timeout_ms = *((uint64_t*)input_data + 2);
+	if (flag_call_timeout_ms == 0)
+		fail("bad call timeout");
 
 	cover_open();
-	setup_main_process(executor_pid, flag_enable_tun);
+	setup_main_process(executor_pid, flag_enable_tun, flag_enable_netns);
+	if (flag_enable_fault_injection)
+		setup_fault();
 
 	int pid = -1;
 	switch (flag_sandbox) {
@@ -201,6 +251,92 @@ int main(int argc, char** argv)
 	return 0;
 }
 
+void handshake()
+{
+	// Fuzzer does not pass shared memory files if it failed to create them.
+	bool shmem_ok = mmap(&input_data[0], kMaxInput, PROT_READ, MAP_PRIVATE | MAP_FIXED, kInFd, 0) == &input_data[0] &&
+			mmap(&output_data[0], kMaxOutput, PROT_READ | PROT_WRITE, MAP_SHARED | MAP_FIXED, kOutFd, 0) == &output_data[0];
+	// Prevent random programs to mess with these fds.
+	// Due to races in collider mode, a program can e.g. ftruncate one of these fds,
+	// which will cause fuzzer to crash.
+	// That's also the reason why we close kInPipeFd/kOutPipeFd below.
+	close(kInFd);
+	close(kOutFd);
+
+	uint64_t hello[3] = {kHandshakeMagic, kShmemVersion, shmem_ok};
+	write_pipe(hello, sizeof(hello));
+	uint64_t reply[2];
+	read_pipe(reply, sizeof(reply));
+	if (reply[0] != kHandshakeMagic)
+		fail("bad handshake magic 0x%llx", reply[0]);
+	switch (reply[1]) {
+	case kTransportShmem:
+		if (!shmem_ok)
+			fail("shared memory transport is requested, but shared memory is not available");
+		break;
+	case kTransportPipe:
+		flag_pipe = true;
+		// Input is read into the loop process memory and inherited by test processes,
+		// but output needs to be shared between test processes and the loop process.
+		if (mmap(&input_data[0], kMaxInput, PROT_READ | PROT_WRITE, MAP_PRIVATE | MAP_ANONYMOUS | MAP_FIXED, -1, 0) != &input_data[0])
+			fail("mmap of input buffer failed");
+		if (mmap(&output_data[0], kMaxOutput, PROT_READ | PROT_WRITE, MAP_SHARED | MAP_ANONYMOUS | MAP_FIXED, -1, 0) != &output_data[0])
+			fail("mmap of output buffer failed");
+		// Fuzzer sends the input header right away, we need it for setup.
+		read_pipe_input();
+		break;
+	default:
+		fail("unknown transport %llu", reply[1]);
+	}
+}
+
+void read_pipe(void* buf, uint64_t size)
+{
+	for (uint64_t pos = 0; pos < size;) {
+		ssize_t n = read(kInPipeFd, (char*)buf + pos, size - pos);
+		if (n <= 0)
+			fail("control pipe read failed");
+		pos += n;
+	}
+}
+
+void write_pipe(const void* buf, uint64_t size)
+{
+	for (uint64_t pos = 0; pos < size;) {
+		ssize_t n = write(kOutPipeFd, (const char*)buf + pos, size - pos);
+		if (n <= 0)
+			fail("control pipe write failed");
+		pos += n;
+	}
+}
+
+void read_pipe_input()
+{
+	uint64_t size = 0;
+	read_pipe(&size, sizeof(size));
+	if (size > kMaxInput)
+		fail("input is too large: %llu", size);
+	read_pipe(&input_data[0], size);
+}
+
+// Sends output of the last test process to fuzzer.
+// Only completed call records are sent (see handle_completion for the record format).
+void write_pipe_output()
+{
+	uint32_t* pos = (uint32_t*)&output_data[0];
+	uint32_t* end = (uint32_t*)&output_data[kMaxOutput];
+	uint32_t ncmd = __atomic_load_n(pos++, __ATOMIC_ACQUIRE);
+	for (uint32_t i = 0; i < ncmd && end - pos >= 8; i++) {
+		// Record: index, num, errno, fault injected, duration, cover size, comps size, signal size,
+		// followed by cover size PCs, comps size comparisons of 5 words each and signal size signals.
+		uint64_t words = 8 + (uint64_t)pos[5] + (uint64_t)pos[6] * 5 + (uint64_t)pos[7];
+		pos = words < (uint64_t)(end - pos) ? pos + words : end;
+	}
+	uint64_t size = (char*)pos - &output_data[0];
+	write_pipe(&size, sizeof(size));
+	write_pipe(&output_data[0], size);
+}
+
 void loop()
 {
 	// Tell parent that we are ready to serve.
@@ -215,8 +351,13 @@ void loop()
 		if (mkdir(cwdbuf, 0777))
 			fail("failed to mkdir");
 
-		if (read(kInPipeFd, &tmp, 1) != 1)
+		if (flag_pipe) {
+			read_pipe_input();
+			// Don't send stale output if the test process dies early.
+			*(uint32_t*)&output_data[0] = 0;
+		} else if (read(kInPipeFd, &tmp, 1) != 1) {
 			fail("control pipe read failed");
+		}
 
 		int pid = fork();
 		if (pid < 0)
@@ -269,7 +410,9 @@ void loop()
 		if (status == kErrorStatus)
 			error("child errored");
 		remove_dir(cwdbuf);
-		if (write(kOutPipeFd, &tmp, 1) != 1)
+		if (flag_pipe)
+			write_pipe_output();
+		else if (write(kOutPipeFd, &tmp, 1) != 1)
 			fail("control pipe write failed");
 	}
 }
@@ -280,6 +423,12 @@ retry:
 	uint64_t* input_pos = (uint64_t*)&input_data[0];
 	read_input(&input_pos); // flags
 	read_input(&input_pos); // pid
+	read_input(&input_pos); // call timeout
+	uint64_t exec_flags = read_input(&input_pos);
+	flag_inject_fault = exec_flags & (1 << 0);
+	flag_collect_comps = exec_flags & (1 << 1);
+	flag_fault_call = read_input(&input_pos);
+	flag_fault_nth = read_input(&input_pos);
 	output_pos = (uint32_t*)&output_data[0];
 	write_output(0); // Number of executed syscalls (updated later).
 
@@ -351,12 +500,14 @@ retry:
 			for (;;) {
 				timespec ts = {};
 				ts.tv_sec = 0;
-				ts.tv_nsec = (20 - (now - start)) * 1000 * 1000;
+				uint64_t left = flag_call_timeout_ms - (now - start);
+				ts.tv_sec = left / 1000;
+				ts.tv_nsec = (left % 1000) * 1000 * 1000;
 				syscall(SYS_futex, &th->done, FUTEX_WAIT, 0, &ts);
 				if (__atomic_load_n(&th->done, __ATOMIC_RELAXED))
 					break;
 				now = current_time_ms();
-				if (now - start > 20)
+				if (now - start >= flag_call_timeout_ms)
 					break;
 			}
 			if (__atomic_load_n(&th->done, __ATOMIC_ACQUIRE))
@@ -384,7 +535,9 @@ retry:
 		}
 	}
 
-	if (flag_collide && !collide) {
+	// Colliding can't be combined with fault injection,
+	// because the fault call would be executed twice.
+	if (flag_collide && !flag_inject_fault && !collide) {
 		debug("enabling collider\n");
 		collide = true;
 		goto retry;
@@ -458,11 +611,27 @@ void handle_completion(thread_t* th)
 		write_output(th->call_index);
 		write_output(th->call_num);
 		write_output(th->res != (uint64_t)-1 ? 0 : th->reserrno);
+		write_output(th->fault_injected);
+		write_output(th->duration_ms);
 		write_output(th->cover_size);
+		write_output(th->comps_size);
+		write_output(th->signal_size);
 		// Truncate PCs to uint32_t assuming that they fit into 32-bits.
 		// True for x86_64 and arm64 without KASLR.
 		for (uint64_t i = 0; i < th->cover_size; i++)
 			write_output((uint32_t)th->cover_data[i + 1]);
+		// Comparisons are written as type followed by both 64-bit operands (low half first).
+		// PCs of comparisons are not needed by fuzzer.
+		for (uint64_t i = 0; i < th->comps_size; i++) {
+			uint64_t* comp = &th->cover_data[1 + i * kcov_cmp_words];
+			write_output((uint32_t)comp[0]);
+			write_output((uint32_t)comp[1]);
+			write_output((uint32_t)(comp[1] >> 32));
+			write_output((uint32_t)comp[2]);
+			write_output((uint32_t)(comp[2] >> 32));
+		}
+		for (uint64_t i = 0; i < th->signal_size; i++)
+			write_output(th->signal_data[i]);
 		completed++;
 		__atomic_store_n((uint32_t*)&output_data[0], completed, __ATOMIC_RELEASE);
 	}
@@ -511,10 +680,32 @@ void execute_call(thread_t* th)
 	}
 	debug(")\n");
 
+	int fail_fd = -1;
+	if (flag_inject_fault && th->call_index == flag_fault_call) {
+		if (collide)
+			fail("both collide and fault injection are enabled");
+		debug("injecting fault into %d-th operation\n", flag_fault_nth);
+		fail_fd = inject_fault(flag_fault_nth);
+	}
+
 	cover_reset(th);
+	uint64_t start = current_time_ms();
 	th->res = execute_syscall(call->sys_nr, th->args[0], th->args[1], th->args[2], th->args[3], th->args[4], th->args[5], th->args[6], th->args[7], th->args[8]);
 	th->reserrno = errno;
-	th->cover_size = cover_read(th);
+	th->duration_ms = current_time_ms() - start;
+	th->cover_size = 0;
+	th->comps_size = 0;
+	th->signal_size = 0;
+	if (flag_collect_comps)
+		th->comps_size = cover_read(th);
+	else
+		th->cover_size = cover_read(th);
+	th->fault_injected = false;
+
+	if (fail_fd != -1) {
+		th->fault_injected = fault_injected(fail_fd);
+		debug("fault injected: %d\n", th->fault_injected);
+	}
 
 	if (th->res == (uint64_t)-1)
 		debug("#%d: %s = errno(%d)\n", th->id, call->name, th->reserrno);
@@ -545,8 +736,8 @@ void cover_enable(thread_t* th)
 {
 	if (!flag_cover)
 		return;
-	debug("#%d: enabling /sys/kernel/debug/kcov\n", th->id);
-	if (ioctl(th->cover_fd, KCOV_ENABLE, 0))
+	debug("#%d: enabling /sys/kernel/debug/kcov (comps=%d)\n", th->id, flag_collect_comps);
+	if (ioctl(th->cover_fd, KCOV_ENABLE, flag_collect_comps ? KCOV_TRACE_CMP : KCOV_TRACE_PC))
 		fail("cover enable write failed");
 	debug("#%d: enabled /sys/kernel/debug/kcov\n", th->id);
 }
@@ -563,9 +754,23 @@ uint64_t cover_read(thread_t* th)
 	if (!flag_cover)
 		return 0;
 	uint64_t n = __atomic_load_n(&th->cover_data[0], __ATOMIC_RELAXED);
+	if (flag_collect_comps) {
+		// In comparisons mode n is the number of comparison records.
+		debug("#%d: read comps = %d\n", th->id, n);
+		if (n * kcov_cmp_words >= kCoverSize)
+			fail("#%d: too many comps %d", th->id, n);
+		n = comps_dedup(th, n);
+		debug("#%d: dedup comps %d\n", th->id, n);
+		return n;
+	}
 	debug("#%d: read cover = %d\n", th->id, n);
 	if (n >= kCoverSize)
 		fail("#%d: too much cover %d", th->id, n);
+	if (flag_edge_signal) {
+		// Must be done before dedup as it needs the original order of PCs.
+		th->signal_size = signal_build(th, n);
+		debug("#%d: edge signal %d\n", th->id, th->signal_size);
+	}
 	if (flag_deduplicate) {
 		n = cover_dedup(th, n);
 		debug("#%d: dedup cover %d\n", th->id, n);
@@ -588,6 +793,75 @@ uint64_t cover_dedup(thread_t* th, uint64_t n)
 	return w;
 }
 
+// hash_pc is a cheap integer hash used to mix PCs into edges.
+uint32_t hash_pc(uint32_t a)
+{
+	a = (a ^ 61) ^ (a >> 16);
+	a = a + (a << 3);
+	a = a ^ (a >> 4);
+	a = a * 0x27d4eb2d;
+	a = a ^ (a >> 15);
+	return a;
+}
+
+// signal_build computes edge signal: every pair of consecutive PCs (prev, cur)
+// is hashed into a single value, so that new paths through already covered code
+// produce new signal. The result is sorted and deduplicated.
+uint64_t signal_build(thread_t* th, uint64_t n)
+{
+	uint64_t* cover_data = th->cover_data + 1;
+	uint32_t* signal = th->signal_data;
+	uint32_t prev = 0;
+	for (uint64_t i = 0; i < n; i++) {
+		uint32_t pc = (uint32_t)cover_data[i];
+		signal[i] = pc ^ hash_pc(prev);
+		prev = pc;
+	}
+	std::sort(signal, signal + n);
+	uint64_t w = 0;
+	for (uint64_t i = 0; i < n; i++) {
+		if (w != 0 && signal[i] == signal[w - 1])
+			continue;
+		signal[w++] = signal[i];
+	}
+	return w;
+}
+
+struct kcov_comparison_t {
+	uint64_t type;
+	uint64_t arg1;
+	uint64_t arg2;
+	uint64_t pc;
+
+	bool operator<(const kcov_comparison_t& other) const
+	{
+		if (type != other.type)
+			return type < other.type;
+		if (arg1 != other.arg1)
+			return arg1 < other.arg1;
+		return arg2 < other.arg2;
+	}
+};
+
+// comps_dedup sorts comparisons and removes duplicates (ignoring PCs)
+// and comparisons of equal operands as they don't give any new values.
+uint64_t comps_dedup(thread_t* th, uint64_t n)
+{
+	kcov_comparison_t* comps = (kcov_comparison_t*)(th->cover_data + 1);
+	std::sort(comps, comps + n);
+	uint64_t w = 0;
+	for (uint64_t i = 0; i < n; i++) {
+		kcov_comparison_t* comp = &comps[i];
+		if (comp->arg1 == comp->arg2)
+			continue;
+		if (w != 0 && comps[w - 1].type == comp->type &&
+		    comps[w - 1].arg1 == comp->arg1 && comps[w - 1].arg2 == comp->arg2)
+			continue;
+		comps[w++] = *comp;
+	}
+	return w;
+}
+
 void copyin(char* addr, uint64_t val, uint64_t size, uint64_t bf_off, uint64_t bf_len)
 {
 	NONFAILING(switch (size) {
@@ -0,0 +1,63 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+// This file is shared between executor and csource package.
+
+// Implementation of syz_tcp_socketpair pseudo-syscall: a pair of TCP sockets
+// connected over loopback (socketpair does not support TCP).
+
+#include <arpa/inet.h>
+#include <netinet/in.h>
+
+// syz_tcp_socketpair(domain flags[tcp_socketpair_domain], fds ptr[out, tcp_socketpair])
+static uintptr_t syz_tcp_socketpair(uintptr_t a0, uintptr_t a1)
+{
+	int domain = a0;
+	int* fds = (int*)a1;
+
+	struct sockaddr_storage addr;
+	socklen_t addrlen;
+	memset(&addr, 0, sizeof(addr));
+	if (domain == AF_INET6) {
+		struct sockaddr_in6* sin6 = (struct sockaddr_in6*)&addr;
+		sin6->sin6_family = AF_INET6;
+		sin6->sin6_addr = in6addr_loopback;
+		addrlen = sizeof(*sin6);
+	} else {
+		struct sockaddr_in* sin = (struct sockaddr_in*)&addr;
+		domain = AF_INET;
+		sin->sin_family = AF_INET;
+		sin->sin_addr.s_addr = htonl(INADDR_LOOPBACK);
+		addrlen = sizeof(*sin);
+	}
+	int err = 0;
+	int cfd = -1;
+	int sfd = -1;
+	int lfd = socket(domain, SOCK_STREAM, IPPROTO_TCP);
+	if (lfd == -1)
+		return -1;
+	// Bind to an ephemeral port and find out which one it is.
+	if (bind(lfd, (struct sockaddr*)&addr, addrlen) || listen(lfd, 1) ||
+	    getsockname(lfd, (struct sockaddr*)&addr, &addrlen))
+		goto error;
+	cfd = socket(domain, SOCK_STREAM, IPPROTO_TCP);
+	if (cfd == -1)
+		goto error;
+	if (connect(cfd, (struct sockaddr*)&addr, addrlen))
+		goto error;
+	sfd = accept(lfd, 0, 0);
+	if (sfd == -1)
+		goto error;
+	close(lfd);
+	NONFAILING(fds[0] = cfd);
+	NONFAILING(fds[1] = sfd);
+	return 0;
+
+error:
+	err = errno;
+	if (cfd != -1)
+		close(cfd);
+	close(lfd);
+	errno = err;
+	return -1;
+}
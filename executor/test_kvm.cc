@@ -27,7 +27,8 @@ extern "C" int test_copyin()
 static unsigned host_kernel_version();
 static void dump_cpu_state(int cpufd, char* vm_mem);
 
-static int test_one(int text_type, const char* text, int text_size, int flags, int reason, bool check_rax)
+static int test_one(int text_type, const char* text, int text_size, int flags, int reason, bool check_rax,
+		    const struct kvm_opt* opts = 0, int nopt = 0)
 {
 	printf("=== testing text %d, text size 0x%x, flags 0x%x\n", text_type, text_size, flags);
 	int kvmfd = open("/dev/kvm", O_RDWR);
@@ -73,7 +74,7 @@ static int test_one(int text_type, const char* text, int text_size, int flags, i
 	kvm_text.typ = text_type;
 	kvm_text.text = text;
 	kvm_text.size = text_size;
-	if (syz_kvm_setup_cpu(vmfd, cpufd, (uintptr_t)vm_mem, (uintptr_t)&kvm_text, 1, flags, 0, 0)) {
+	if (syz_kvm_setup_cpu(vmfd, cpufd, (uintptr_t)vm_mem, (uintptr_t)&kvm_text, 1, flags, (uintptr_t)opts, nopt)) {
 		printf("syz_kvm_setup_cpu failed (%d)\n", errno);
 		return 1;
 	}
@@ -146,6 +147,14 @@ extern "C" int test_kvm()
 	if (res = test_one(32, text32, sizeof(text32) - 1, KVM_SETUP_CPL3, KVM_EXIT_SHUTDOWN, true))
 		return res;
 
+	// The value of rax comes from the register option, the text is just nop.
+	const char text32_nop[] = "\x90";
+	struct kvm_opt opt_rax;
+	opt_rax.typ = KVM_SETUP_OPT_REG + 0;
+	opt_rax.val = 0xbadc0de;
+	if (res = test_one(32, text32_nop, sizeof(text32_nop) - 1, 0, KVM_EXIT_HLT, true, &opt_rax, 1))
+		return res;
+
 	const char text64[] = "\x90\xb8\xde\xc0\xad\x0b";
 	if (res = test_one(64, text64, sizeof(text64) - 1, 0, KVM_EXIT_HLT, true))
 		return res;
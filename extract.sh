@@ -22,43 +22,19 @@ COMMON_FILES="sys/socket.txt sys/tty.txt sys/perf.txt sys/kvm.txt \
 	sys/key.txt sys/bpf.txt sys/fuse.txt sys/dri.txt sys/sctp.txt \
 	sys/sndseq.txt sys/sndtimer.txt sys/sndcontrol.txt sys/input.txt \
 	sys/netlink.txt sys/tun.txt sys/random.txt sys/netrom.txt \
-	sys/vnet.txt"
+	sys/vnet.txt sys/usb.txt"
 
 UPSTREAM_FILES="sys/sys.txt sys/kcm.txt"
-ANDROID_FILES=sys/tlk_device.txt
+ANDROID_FILES="sys/tlk_device.txt sys/binder.txt sys/ashmem.txt"
 
 if [ "$BUILD_FOR_ANDROID" == "no" ]; then
 	FILES="$COMMON_FILES $UPSTREAM_FILES"
+	ARCHS="amd64,arm64,ppc64le"
 else
 	FILES="$ANDROID_FILES"
+	ARCHS="amd64,arm64"
 fi
 
-generate_arch() {
-	echo generating arch $1...
-	echo "cd $LINUX; make defconfig"
-	OUT=`(cd $LINUX; make ARCH=$2 CROSS_COMPILE=$3-linux-gnu- defconfig 2>&1)`
-	if [ $? -ne 0 ]; then
-		echo "$OUT"
-		exit 1
-	fi
-	echo "cd $LINUX; make"
-	OUT=`(cd $LINUX; make ARCH=$2 CROSS_COMPILE=$3-linux-gnu- init/main.o 2>&1)`
-	if [ $? -ne 0 ]; then
-		echo "$OUT"
-		exit 1
-	fi
-	for F in $FILES; do
-		echo "extracting from $F"
-		bin/syz-extract -arch $1 -linux "$LINUX" -linuxbld "$LINUXBLD" $F
-		if [ $? -ne 0 ]; then
-			exit 1
-		fi
-	done
-	echo
-}
-
-generate_arch amd64 x86_64 x86_64
-generate_arch arm64 arm64 aarch64
-if [ "$BUILD_FOR_ANDROID" == "no" ]; then
-	generate_arch ppc64le powerpc powerpc64le
-fi
+# syz-extract configures and builds the kernel for every arch and fails
+# if some constants are missing for an arch (pass EXTRACT_FLAGS=-allowmissing to only warn).
+bin/syz-extract -build -arch $ARCHS -linux "$LINUX" -linuxbld "$LINUXBLD" $EXTRACT_FLAGS $FILES
@@ -5,11 +5,13 @@ package host
 
 import (
 	"bytes"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 
 	"github.com/google/syzkaller/sys"
@@ -37,6 +39,227 @@ func DetectSupportedSyscalls() (map[*sys.Call]bool, error) {
 	return supported, nil
 }
 
+// MachineInfo describes features of the machine that matter for fuzzing
+// (rpctype.RpcMachineInfo must have the same fields, it is converted directly).
+type MachineInfo struct {
+	Kernel          string   // kernel release (uname -r)
+	Syscalls        int      // number of supported syscalls
+	Namespaces      []string // supported namespace types (see /proc/self/ns), e.g. "user", "net"
+	Kcov            bool     // coverage collection with /sys/kernel/debug/kcov
+	KcovComparisons bool     // see KcovComparisonsSupported
+	FaultInjection  bool     // see FaultInjectionSupported
+	LeakChecking    bool     // memory leak checking with /sys/kernel/debug/kmemleak
+	UsbEmulation    bool     // see UsbEmulationSupported
+}
+
+var (
+	machineInfoOnce sync.Once
+	machineInfo     *MachineInfo
+	machineInfoErr  error
+)
+
+// DetectMachineInfo returns features of the host. Detection is done only once, the result is cached.
+func DetectMachineInfo() (*MachineInfo, error) {
+	machineInfoOnce.Do(func() {
+		machineInfo, machineInfoErr = detectMachineInfo()
+	})
+	return machineInfo, machineInfoErr
+}
+
+func detectMachineInfo() (*MachineInfo, error) {
+	info := new(MachineInfo)
+	var uts syscall.Utsname
+	if err := syscall.Uname(&uts); err != nil {
+		return nil, fmt.Errorf("uname failed: %v", err)
+	}
+	for _, c := range uts.Release {
+		if c == 0 {
+			break
+		}
+		info.Kernel += string(byte(c))
+	}
+	supp, err := DetectSupportedSyscalls()
+	if err != nil {
+		return nil, err
+	}
+	info.Syscalls = len(supp)
+	for _, ns := range []string{"cgroup", "ipc", "mnt", "net", "pid", "user", "uts"} {
+		if _, err := os.Stat("/proc/self/ns/" + ns); err == nil {
+			info.Namespaces = append(info.Namespaces, ns)
+		}
+	}
+	if fd, err := syscall.Open("/sys/kernel/debug/kcov", syscall.O_RDWR, 0); err == nil {
+		syscall.Close(fd)
+		info.Kcov = true
+		info.KcovComparisons = KcovComparisonsSupported()
+	}
+	info.FaultInjection = FaultInjectionSupported()
+	if _, err := os.Stat("/sys/kernel/debug/kmemleak"); err == nil {
+		info.LeakChecking = true
+	}
+	info.UsbEmulation = UsbEmulationSupported()
+	return info, nil
+}
+
+// FaultInjectionSupported returns true if the kernel supports
+// systematic fault injection via /proc/thread-self/fail-nth.
+func FaultInjectionSupported() bool {
+	if _, err := os.Stat("/proc/self/make-it-fail"); err != nil {
+		return false
+	}
+	if _, err := os.Stat("/proc/thread-self/fail-nth"); err != nil {
+		return false
+	}
+	if _, err := os.Stat("/sys/kernel/debug/failslab/ignore-gfp-wait"); err != nil {
+		return false
+	}
+	return true
+}
+
+// UsbEmulationSupported returns true if the kernel supports emulation
+// of USB devices via /dev/raw-gadget (requires CONFIG_USB_RAW_GADGET and CONFIG_USB_DUMMY_HCD).
+func UsbEmulationSupported() bool {
+	_, err := os.Stat("/dev/raw-gadget")
+	return err == nil && syscall.Getuid() == 0
+}
+
+// DiscoverPaths returns up to max existing paths for the filename pool
+// (see sys.FilenamePools). Directories are traversed breadth-first,
+// so that a limited number of paths covers more of the tree.
+func DiscoverPaths(pool string, max int) []string {
+	type dir struct {
+		path  string
+		depth int
+	}
+	var queue []dir
+	switch pool {
+	case "dev":
+		queue = []dir{{"/dev", 2}}
+	case "sysfs":
+		queue = []dir{{"/sys", 3}}
+	case "proc":
+		queue = []dir{{"/proc", 1}, {"/proc/self", 1}, {"/proc/sys", 3}}
+	}
+	var paths []string
+	for len(queue) != 0 && len(paths) < max {
+		d := queue[0]
+		queue = queue[1:]
+		entries, err := ioutil.ReadDir(d.path)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			if len(paths) >= max {
+				break
+			}
+			if d.path == "/proc" {
+				if _, err := strconv.Atoi(e.Name()); err == nil {
+					continue // skip processes
+				}
+			}
+			path := d.path + "/" + e.Name()
+			paths = append(paths, path)
+			if e.IsDir() && d.depth > 1 {
+				queue = append(queue, dir{path, d.depth - 1})
+			}
+		}
+	}
+	return paths
+}
+
+// Module is a loaded kernel module.
+type Module struct {
+	Name string
+	Addr uint64 // load address of the module core
+	Size uint64 // size of the module core
+	Text uint64 // address of the .text section
+}
+
+// Modules returns kernel modules loaded on host (requires root, otherwise addresses are hidden).
+// Modules with hidden addresses are omitted.
+func Modules() ([]Module, error) {
+	data, err := ioutil.ReadFile("/proc/modules")
+	if err != nil {
+		return nil, err
+	}
+	modules, err := parseModules(data)
+	if err != nil {
+		return nil, err
+	}
+	for i := range modules {
+		m := &modules[i]
+		// .text is not necessarily at the start of the module core, fall back to it only if the section is unknown.
+		m.Text = m.Addr
+		if data, err := ioutil.ReadFile("/sys/module/" + m.Name + "/sections/.text"); err == nil {
+			if addr, err := strconv.ParseUint(strings.TrimSpace(string(data)), 0, 64); err == nil && addr != 0 {
+				m.Text = addr
+			}
+		}
+	}
+	return modules, nil
+}
+
+// parseModules parses /proc/modules contents.
+// A line looks as: "nf_tables 249856 3 nft_ct, Live 0xffffffffc0a1e000 (E)".
+func parseModules(data []byte) ([]Module, error) {
+	var modules []Module
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 6 {
+			return nil, fmt.Errorf("bad /proc/modules line: %q", line)
+		}
+		size, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad module size in /proc/modules line: %q", line)
+		}
+		addr, err := strconv.ParseUint(fields[5], 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad module address in /proc/modules line: %q", line)
+		}
+		if addr == 0 {
+			continue
+		}
+		modules = append(modules, Module{Name: fields[0], Addr: addr, Size: size})
+	}
+	return modules, nil
+}
+
+// KcovComparisonsSupported returns true if the kernel supports
+// collection of comparison operands with KCOV (KCOV_TRACE_CMP mode).
+func KcovComparisonsSupported() bool {
+	const (
+		kcovInitTrace = 0x80086301
+		kcovEnable    = 0x6364
+		kcovDisable   = 0x6365
+		kcovTraceCmp  = 1
+		coverSize     = 64 << 10
+	)
+	// KCOV is enabled for the current thread.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	fd, err := syscall.Open("/sys/kernel/debug/kcov", syscall.O_RDWR, 0)
+	if err != nil {
+		return false
+	}
+	defer syscall.Close(fd)
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), kcovInitTrace, coverSize); errno != 0 {
+		return false
+	}
+	mem, err := syscall.Mmap(fd, 0, coverSize*8, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return false
+	}
+	defer syscall.Munmap(mem)
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), kcovEnable, kcovTraceCmp); errno != 0 {
+		return false
+	}
+	syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), kcovDisable, 0)
+	return true
+}
+
 func isSupported(kallsyms []byte, c *sys.Call) bool {
 	if c.NR == -1 {
 		return false // don't even have a syscall number
@@ -96,6 +319,14 @@ func isSupportedSyzkall(c *sys.Call) bool {
 	case "syz_emit_ethernet":
 		_, err := os.Stat("/dev/net/tun")
 		return err == nil && syscall.Getuid() == 0
+	case "syz_usb_connect", "syz_usb_disconnect":
+		return UsbEmulationSupported()
+	case "syz_binder_transaction":
+		_, err := os.Stat("/dev/binder")
+		return err == nil
+	case "syz_mount_image":
+		_, err := os.Stat("/dev/loop-control")
+		return err == nil && syscall.Getuid() == 0
 	case "syz_kvm_setup_cpu":
 		switch c.Name {
 		case "syz_kvm_setup_cpu$x86":
@@ -104,7 +335,8 @@ func isSupportedSyzkall(c *sys.Call) bool {
 			return runtime.GOARCH == "arm64"
 		}
 	}
-	panic("unknown syzkall: " + c.Name)
+	// Pseudo-syscall plugins (executor/pseudo) don't have specific requirements.
+	return true
 }
 
 func isSupportedSocket(c *sys.Call) bool {
@@ -4,6 +4,7 @@
 package host
 
 import (
+	"reflect"
 	"syscall"
 	"testing"
 
@@ -70,3 +71,41 @@ func TestSupportedSyscalls(t *testing.T) {
 		}
 	}
 }
+
+func TestParseModules(t *testing.T) {
+	data := []byte(`nf_tables 249856 3 nft_ct,nft_chain_nat, Live 0xffffffffc0a1e000 (E)
+hidden 16384 0 - Live 0x0000000000000000
+loop 40960 0 - Live 0xffffffffc0010000
+`)
+	modules, err := parseModules(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Module{
+		{Name: "nf_tables", Addr: 0xffffffffc0a1e000, Size: 249856},
+		{Name: "loop", Addr: 0xffffffffc0010000, Size: 40960},
+	}
+	if !reflect.DeepEqual(modules, want) {
+		t.Fatalf("got %+v, want %+v", modules, want)
+	}
+	if _, err := parseModules([]byte("loop 40960 0 -\n")); err == nil {
+		t.Fatalf("truncated line is not detected")
+	}
+	if _, err := parseModules([]byte("loop 40960 0 - Live foo\n")); err == nil {
+		t.Fatalf("bad address is not detected")
+	}
+}
+
+func TestMachineInfo(t *testing.T) {
+	info, err := DetectMachineInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("%+v", *info)
+	if info.Kernel == "" || info.Syscalls == 0 {
+		t.Fatalf("kernel release or syscalls are not detected")
+	}
+	if info1, _ := DetectMachineInfo(); info1 != info {
+		t.Fatalf("machine info is not cached")
+	}
+}
@@ -5,8 +5,10 @@ package ipc
 
 import (
 	"bytes"
+	"encoding/binary"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
@@ -19,19 +21,25 @@ import (
 
 	"github.com/google/syzkaller/fileutil"
 	"github.com/google/syzkaller/prog"
+	"github.com/google/syzkaller/sys"
 )
 
 type Env struct {
 	In  []byte
 	Out []byte
 
-	cmd     *command
-	inFile  *os.File
-	outFile *os.File
-	bin     []string
-	timeout time.Duration
-	flags   uint64
-	pid     int
+	header   []byte
+	progSize int // size of the serialized program in In
+	cmd      *command
+	inFile   *os.File // nil if shared memory is not used
+	outFile  *os.File
+	bin      []string
+	timeout  time.Duration
+	flags    uint64
+	pid      int
+
+	needRestart uint32
+	executorPid int32 // pid of the running executor process (0 if it's not running)
 
 	StatExecs    uint64
 	StatRestarts uint64
@@ -46,18 +54,108 @@ const (
 	FlagSandboxSetuid                        // impersonate nobody user
 	FlagSandboxNamespace                     // use namespaces for sandboxing
 	FlagEnableTun                            // initialize and use tun in executor
+	FlagEnableFault                          // setup fault injection in executor
+	FlagEnableNetns                          // create a separate network namespace with virtual devices for every executor
+	FlagEdgeSignal                           // collect hashed (prev, cur) PC pairs as signal in addition to coverage (requires FlagCover)
+)
+
+// ExecOpts contains per-execution options
+// (as opposed to Env flags that are fixed for the lifetime of executor process).
+type ExecOpts struct {
+	Flags     uint64 // ExecFlag* flags
+	FaultCall int    // call index for fault injection
+	FaultNth  int    // fault n-th operation in the call (0-based)
+}
+
+const (
+	ExecFlagInjectFault  = uint64(1) << iota // inject a fault in FaultCall/FaultNth (requires FlagEnableFault)
+	ExecFlagCollectComps                     // collect KCOV comparisons instead of coverage (requires FlagCover)
+)
+
+// CallInfo contains results of execution of a single call.
+type CallInfo struct {
+	Cover         []uint32      // per-call coverage
+	Signal        []uint32      // per-call edge signal (if FlagEdgeSignal is set)
+	Errno         int           // call errno (0 if the call was successful, -1 if the call was not executed)
+	FaultInjected bool          // a fault was injected into this call
+	Duration      time.Duration // call execution time (with millisecond precision)
+	Comps         prog.CompMap  // per-call comparison operands (if ExecFlagCollectComps is set)
+}
+
+// Result classifies the call outcome for mutation (see prog.CallResult).
+// Errors that are usually returned by argument validation before the call does any real work
+// mean that the call is rejected and it's worth retrying it with different arguments.
+func (inf *CallInfo) Result() prog.CallResult {
+	switch inf.Errno {
+	case -1:
+		return prog.CallNotExecuted
+	case 0:
+		return prog.CallSucceeded
+	case int(syscall.EINVAL), int(syscall.EFAULT), int(syscall.EBADF), int(syscall.ENOTTY),
+		int(syscall.ENOTSOCK), int(syscall.EOPNOTSUPP), int(syscall.ENOPROTOOPT), int(syscall.ERANGE),
+		int(syscall.E2BIG), int(syscall.ENAMETOOLONG), int(syscall.EMSGSIZE):
+		return prog.CallRejected
+	default:
+		return prog.CallFailed
+	}
+}
+
+// CallResults returns results of all calls (see CallInfo.Result).
+func CallResults(info []CallInfo) []prog.CallResult {
+	results := make([]prog.CallResult, len(info))
+	for i := range info {
+		results[i] = info[i].Result()
+	}
+	return results
+}
+
+// Comparison type flags as defined by KCOV_TRACE_CMP mode.
+const (
+	compConst = 1 // one of the operands (arg1) is a compile-time constant
+)
+
+// Input memory starts with a header:
+// executor flags, pid, call timeout, exec flags, fault call, fault nth.
+const (
+	inHeaderSize     = 6 * 8
+	inCallTimeoutOff = 2 * 8
+	inExecFlagsOff   = 3 * 8
+	inFaultCallOff   = 4 * 8
+	inFaultNthOff    = 5 * 8
+)
+
+// When executor starts, it sends handshake magic, shmem version and whether it
+// managed to map the shared memory files. Fuzzer replies with handshake magic
+// and the chosen transport. If executor has a different shared memory layout
+// (e.g. an old executor binary is used with a new fuzzer) or the shared memory
+// is not available, all data is transferred over the control pipes instead,
+// every message is prefixed with its size. The handshake format must never change.
+const (
+	handshakeMagic = uint64(0xbadc0ffeebadface)
+	// Must be bumped on any change of the shared memory layout (mapping sizes,
+	// input header, output format), must match kShmemVersion in executor.
+	shmemVersion   = uint64(1)
+	transportShmem = uint64(0)
+	transportPipe  = uint64(1)
 )
 
 var (
 	flagThreaded = flag.Bool("threaded", true, "use threaded mode in executor")
 	flagCollide  = flag.Bool("collide", true, "collide syscalls to provoke data races")
 	flagCover    = flag.Bool("cover", true, "collect coverage")
+	flagSignal   = flag.String("signal", "pc", "coverage feedback signal (pc/edge)")
 	flagSandbox  = flag.String("sandbox", "setuid", "sandbox for fuzzing (none/setuid/namespace)")
 	flagDebug    = flag.Bool("debug", false, "debug output from executor")
+	flagNetns    = flag.Bool("netns", false, "create a separate network namespace with virtual devices for every executor")
+	flagSeccomp  = flag.String("seccomp_deny", "", "comma-separated list of syscalls denied with seccomp in namespace sandbox (e.g. reboot,kexec_load)")
+	flagPipe     = flag.Bool("ipc_pipe", false, "communicate with executor over pipes instead of shared memory")
 	// Executor protects against most hangs, so we use quite large timeout here.
 	// Executor can be slow due to global locks in namespaces and other things,
 	// so let's better wait than report false misleading crashes.
 	flagTimeout = flag.Duration("timeout", 1*time.Minute, "execution timeout")
+	// In threaded mode executor waits for a call to complete for this long,
+	// then proceeds to the next call leaving the blocked call running.
+	flagCallTimeout = flag.Duration("call_timeout", 20*time.Millisecond, "per-call timeout in threaded mode")
 )
 
 // ExecutorFailure is returned from MakeEnv or from env.Exec when executor terminates by calling fail function.
@@ -80,6 +178,16 @@ func DefaultFlags() (uint64, time.Duration, error) {
 		flags |= FlagCover
 		flags |= FlagDedupCover
 	}
+	switch *flagSignal {
+	case "pc":
+	case "edge":
+		if !*flagCover {
+			return 0, 0, fmt.Errorf("flag signal=edge requires coverage")
+		}
+		flags |= FlagEdgeSignal
+	default:
+		return 0, 0, fmt.Errorf("flag signal must contain one of pc/edge")
+	}
 	switch *flagSandbox {
 	case "none":
 	case "setuid":
@@ -92,40 +200,72 @@ func DefaultFlags() (uint64, time.Duration, error) {
 	if *flagDebug {
 		flags |= FlagDebug
 	}
+	if *flagNetns {
+		flags |= FlagEnableNetns
+	}
+	if *flagCallTimeout < time.Millisecond {
+		return 0, 0, fmt.Errorf("flag call_timeout must be at least 1ms")
+	}
+	if *flagSeccomp != "" {
+		if flags&FlagSandboxNamespace == 0 {
+			return 0, 0, fmt.Errorf("flag seccomp_deny requires namespace sandbox")
+		}
+		if _, err := seccompDenyNRs(*flagSeccomp); err != nil {
+			return 0, 0, err
+		}
+	}
 	return flags, *flagTimeout, nil
 }
 
+// seccompDenyNRs converts a comma-separated list of syscall names to syscall numbers.
+func seccompDenyNRs(names string) ([]int, error) {
+	var nrs []int
+	for _, name := range strings.Split(names, ",") {
+		nr := -1
+		for _, c := range sys.Calls {
+			if c.CallName == name {
+				nr = c.NR
+				break
+			}
+		}
+		if nr == -1 || strings.HasPrefix(name, "syz_") {
+			return nil, fmt.Errorf("unknown syscall '%v' in seccomp_deny", name)
+		}
+		nrs = append(nrs, nr)
+	}
+	return nrs, nil
+}
+
 func MakeEnv(bin string, timeout time.Duration, flags uint64, pid int) (*Env, error) {
 	// IPC timeout must be larger then executor timeout.
 	// Otherwise IPC will kill parent executor but leave child executor alive.
 	if timeout < 7*time.Second {
 		timeout = 7 * time.Second
 	}
-	inf, inmem, err := createMapping(prog.ExecBufferSize)
-	if err != nil {
-		return nil, err
+	var inf, outf *os.File
+	var inmem, outmem []byte
+	if !*flagPipe {
+		inf, inmem, outf, outmem = createMappings()
 	}
 	defer func() {
 		if inf != nil {
 			closeMapping(inf, inmem)
-		}
-	}()
-	outf, outmem, err := createMapping(16 << 20)
-	if err != nil {
-		return nil, err
-	}
-	defer func() {
-		if outf != nil {
 			closeMapping(outf, outmem)
 		}
 	}()
+	if inf == nil {
+		// Shared memory is not available, executor will talk to us over pipes.
+		inmem = make([]byte, prog.ExecBufferSize)
+		outmem = make([]byte, outputSize)
+	}
 	for i := 0; i < 8; i++ {
 		inmem[i] = byte(flags >> (8 * uint(i)))
 	}
 	*(*uint64)(unsafe.Pointer(&inmem[8])) = uint64(pid)
-	inmem = inmem[16:]
+	*(*uint64)(unsafe.Pointer(&inmem[inCallTimeoutOff])) = uint64(*flagCallTimeout / time.Millisecond)
 	env := &Env{
-		In:      inmem,
+		In:      inmem[inHeaderSize:],
+		header:  inmem[:inHeaderSize],
 		Out:     outmem,
 		inFile:  inf,
 		outFile: outf,
@@ -137,10 +277,25 @@ func MakeEnv(bin string, timeout time.Duration, flags uint64, pid int) (*Env, er
 	if len(env.bin) == 0 {
 		return nil, fmt.Errorf("binary is empty string")
 	}
-	env.bin[0], err = filepath.Abs(env.bin[0]) // we are going to chdir
+	if *flagSeccomp != "" && flags&FlagSandboxNamespace != 0 {
+		nrs, err := seccompDenyNRs(*flagSeccomp)
+		if err != nil {
+			return nil, err
+		}
+		arg := "seccomp_deny="
+		for i, nr := range nrs {
+			if i != 0 {
+				arg += ","
+			}
+			arg += fmt.Sprint(nr)
+		}
+		env.bin = append(env.bin, arg)
+	}
+	bin, err := filepath.Abs(env.bin[0]) // we are going to chdir
 	if err != nil {
 		return nil, fmt.Errorf("filepath.Abs failed: %v", err)
 	}
+	env.bin[0] = bin
 	// Append pid to binary name.
 	// E.g. if binary is 'syz-executor' and pid=15,
 	// we create a link from 'syz-executor15' to 'syz-executor' and use 'syz-executor15' as binary.
@@ -164,8 +319,12 @@ func MakeEnv(bin string, timeout time.Duration, flags uint64, pid int) (*Env, er
 func (env *Env) Close() error {
 	if env.cmd != nil {
 		env.cmd.close()
+		atomic.StoreInt32(&env.executorPid, 0)
 	}
-	err1 := closeMapping(env.inFile, env.In)
+	if env.inFile == nil {
+		return nil
+	}
+	err1 := closeMapping(env.inFile, env.header[:cap(env.header)])
 	err2 := closeMapping(env.outFile, env.Out)
 	switch {
 	case err1 != nil:
@@ -177,52 +336,86 @@ func (env *Env) Close() error {
 	}
 }
 
+// ExecutorPid returns pid of the running executor process, or 0 if it is not running.
+// The executor forks test processes, they are its descendants.
+// It is safe to call concurrently with Exec.
+func (env *Env) ExecutorPid() int {
+	return int(atomic.LoadInt32(&env.executorPid))
+}
+
+// RequestRestart asks env to restart executor process before the next execution.
+// It is safe to call concurrently with Exec.
+func (env *Env) RequestRestart() {
+	atomic.StoreUint32(&env.needRestart, 1)
+}
+
 // Exec starts executor binary to execute program p and returns information about the execution:
 // output: process output
-// cov: per-call coverage, len(cov) == len(p.Calls)
+// info: per-call info, len(info) == len(p.Calls)
 // failed: true if executor has detected a kernel bug
 // hanged: program hanged and was killed
 // err0: failed to start process, or executor has detected a logical error
-func (env *Env) Exec(p *prog.Prog) (output []byte, cov [][]uint32, errnos []int, failed, hanged bool, err0 error) {
+func (env *Env) Exec(opts *ExecOpts, p *prog.Prog) (output []byte, info []CallInfo, failed, hanged bool, err0 error) {
 	if p != nil {
 		// Copy-in serialized program.
-		if err := p.SerializeForExec(env.In, env.pid); err != nil {
+		n, err := p.SerializeForExec(env.In, env.pid)
+		if err != nil {
 			err0 = fmt.Errorf("executor %v: failed to serialize: %v", env.pid, err)
 			return
 		}
+		env.progSize = n
 	}
-	if env.flags&FlagCover != 0 {
-		// Zero out the first word (ncmd), so that we don't have garbage there
-		// if executor crashes before writing non-garbage there.
-		for i := 0; i < 4; i++ {
-			env.Out[i] = 0
-		}
+	if opts == nil {
+		opts = new(ExecOpts)
+	}
+	if opts.Flags&ExecFlagInjectFault != 0 && env.flags&FlagEnableFault == 0 {
+		err0 = fmt.Errorf("executor %v: fault injection is requested, but not enabled", env.pid)
+		return
+	}
+	if opts.Flags&ExecFlagCollectComps != 0 && env.flags&FlagCover == 0 {
+		err0 = fmt.Errorf("executor %v: comparisons collection is requested, but coverage is not enabled", env.pid)
+		return
+	}
+	*(*uint64)(unsafe.Pointer(&env.header[inExecFlagsOff])) = opts.Flags
+	*(*uint64)(unsafe.Pointer(&env.header[inFaultCallOff])) = uint64(opts.FaultCall)
+	*(*uint64)(unsafe.Pointer(&env.header[inFaultNthOff])) = uint64(opts.FaultNth)
+	// Zero out the first word (ncmd), so that we don't have garbage there
+	// if executor crashes before writing non-garbage there.
+	for i := 0; i < 4; i++ {
+		env.Out[i] = 0
 	}
 
 	atomic.AddUint64(&env.StatExecs, 1)
+	if atomic.SwapUint32(&env.needRestart, 0) != 0 && env.cmd != nil {
+		env.cmd.close()
+		env.cmd = nil
+		atomic.StoreInt32(&env.executorPid, 0)
+	}
 	if env.cmd == nil {
 		atomic.AddUint64(&env.StatRestarts, 1)
-		env.cmd, err0 = makeCommand(env.pid, env.bin, env.timeout, env.flags, env.inFile, env.outFile)
+		env.cmd, err0 = makeCommand(env.pid, env.bin, env.timeout, env.flags, env.header, env.inFile, env.outFile)
 		if err0 != nil {
 			return
 		}
+		atomic.StoreInt32(&env.executorPid, int32(env.cmd.cmd.Process.Pid))
 	}
 	var restart bool
-	output, failed, hanged, restart, err0 = env.cmd.exec()
+	output, failed, hanged, restart, err0 = env.cmd.exec(env.header[:inHeaderSize+env.progSize], env.Out)
 	if err0 != nil || restart {
 		env.cmd.close()
 		env.cmd = nil
+		atomic.StoreInt32(&env.executorPid, 0)
 		return
 	}
 
-	if env.flags&FlagCover == 0 || p == nil {
+	if p == nil {
 		return
 	}
-	cov, errnos, err0 = env.readOutCoverage(p)
+	info, err0 = env.readOutCoverage(opts, p)
 	return
 }
 
-func (env *Env) readOutCoverage(p *prog.Prog) (cov [][]uint32, errnos []int, err0 error) {
+func (env *Env) readOutCoverage(opts *ExecOpts, p *prog.Prog) (info []CallInfo, err0 error) {
 	out := ((*[1 << 28]uint32)(unsafe.Pointer(&env.Out[0])))[:len(env.Out)/int(unsafe.Sizeof(uint32(0)))]
 	readOut := func(v *uint32) bool {
 		if len(out) == 0 {
@@ -238,24 +431,23 @@ func (env *Env) readOutCoverage(p *prog.Prog) (cov [][]uint32, errnos []int, err
 		err0 = fmt.Errorf("executor %v: failed to read output coverage", env.pid)
 		return
 	}
-	cov = make([][]uint32, len(p.Calls))
-	errnos = make([]int, len(p.Calls))
-	for i := range errnos {
-		errnos[i] = -1 // not executed
+	info = make([]CallInfo, len(p.Calls))
+	for i := range info {
+		info[i].Errno = -1 // not executed
 	}
 	dumpCov := func() string {
 		buf := new(bytes.Buffer)
-		for i, c := range cov {
+		for i, inf := range info {
 			str := "nil"
-			if c != nil {
-				str = fmt.Sprint(len(c))
+			if inf.Cover != nil {
+				str = fmt.Sprint(len(inf.Cover))
 			}
 			fmt.Fprintf(buf, "%v:%v|", i, str)
 		}
 		return buf.String()
 	}
 	for i := uint32(0); i < ncmd; i++ {
-		var callIndex, callNum, errno, coverSize uint32
+		var callIndex, callNum, errno, faultInjected, duration, coverSize, compsSize, signalSize uint32
 		if !readOut(&callIndex) {
 			err0 = fmt.Errorf("executor %v: failed to read output coverage", env.pid)
 			return
@@ -268,17 +460,33 @@ func (env *Env) readOutCoverage(p *prog.Prog) (cov [][]uint32, errnos []int, err
 			err0 = fmt.Errorf("executor %v: failed to read output errno", env.pid)
 			return
 		}
-		errnos[callIndex] = int(errno)
+		if !readOut(&faultInjected) {
+			err0 = fmt.Errorf("executor %v: failed to read output fault injection status", env.pid)
+			return
+		}
+		if !readOut(&duration) {
+			err0 = fmt.Errorf("executor %v: failed to read output call duration", env.pid)
+			return
+		}
 		if !readOut(&coverSize) {
 			err0 = fmt.Errorf("executor %v: failed to read output coverage", env.pid)
 			return
 		}
-		if int(callIndex) > len(cov) {
+		if !readOut(&compsSize) {
+			err0 = fmt.Errorf("executor %v: failed to read output comparisons", env.pid)
+			return
+		}
+		if !readOut(&signalSize) {
+			err0 = fmt.Errorf("executor %v: failed to read output signal", env.pid)
+			return
+		}
+		if int(callIndex) >= len(info) {
 			err0 = fmt.Errorf("executor %v: failed to read output coverage: record %v, call %v, total calls %v (cov: %v)",
-				env.pid, i, callIndex, len(cov), dumpCov())
+				env.pid, i, callIndex, len(info), dumpCov())
 			return
 		}
-		if cov[callIndex] != nil {
+		inf := &info[callIndex]
+		if inf.Errno != -1 {
 			err0 = fmt.Errorf("executor %v: failed to read output coverage: double coverage for call %v (cov: %v)",
 				env.pid, callIndex, dumpCov())
 			return
@@ -293,8 +501,60 @@ func (env *Env) readOutCoverage(p *prog.Prog) (cov [][]uint32, errnos []int, err
 			err0 = fmt.Errorf("executor %v: failed to read output coverage: record %v, call %v, coversize=%v", env.pid, i, callIndex, coverSize)
 			return
 		}
-		cov[callIndex] = out[:coverSize:coverSize]
+		inf.Errno = int(errno)
+		inf.FaultInjected = faultInjected != 0
+		inf.Duration = time.Duration(duration) * time.Millisecond
+		if env.flags&FlagCover != 0 {
+			inf.Cover = out[:coverSize:coverSize]
+		}
 		out = out[coverSize:]
+		// Every comparison is type and two 64-bit operands.
+		const compWords = 5
+		if uint64(compsSize)*compWords > uint64(len(out)) {
+			err0 = fmt.Errorf("executor %v: failed to read output comparisons: record %v, call %v, compssize=%v", env.pid, i, callIndex, compsSize)
+			return
+		}
+		if opts.Flags&ExecFlagCollectComps != 0 {
+			inf.Comps = make(prog.CompMap)
+			for j := uint32(0); j < compsSize; j++ {
+				comp := out[j*compWords : (j+1)*compWords]
+				typ := comp[0]
+				arg1 := uint64(comp[1]) | uint64(comp[2])<<32
+				arg2 := uint64(comp[3]) | uint64(comp[4])<<32
+				// The constant operand can't come from the input,
+				// so we are only interested in replacing arg2 with arg1.
+				inf.Comps.AddComp(arg2, arg1)
+				if typ&compConst == 0 {
+					inf.Comps.AddComp(arg1, arg2)
+				}
+			}
+		}
+		out = out[compsSize*compWords:]
+		if signalSize > uint32(len(out)) {
+			err0 = fmt.Errorf("executor %v: failed to read output signal: record %v, call %v, signalsize=%v", env.pid, i, callIndex, signalSize)
+			return
+		}
+		if env.flags&FlagEdgeSignal != 0 {
+			inf.Signal = out[:signalSize:signalSize]
+		}
+		out = out[signalSize:]
+	}
+	return
+}
+
+const outputSize = 16 << 20
+
+// createMappings creates shared memory for executor input and output.
+// Returns nil files if shared memory is not available.
+func createMappings() (inf *os.File, inmem []byte, outf *os.File, outmem []byte) {
+	inf, inmem, err := createMapping(prog.ExecBufferSize)
+	if err != nil {
+		return nil, nil, nil, nil
+	}
+	outf, outmem, err = createMapping(outputSize)
+	if err != nil {
+		closeMapping(inf, inmem)
+		return nil, nil, nil, nil
 	}
 	return
 }
@@ -354,9 +614,10 @@ type command struct {
 	readDone chan []byte
 	inrp     *os.File
 	outwp    *os.File
+	pipe     bool // input/output are transferred over the control pipes rather than shared memory
 }
 
-func makeCommand(pid int, bin []string, timeout time.Duration, flags uint64, inFile *os.File, outFile *os.File) (*command, error) {
+func makeCommand(pid int, bin []string, timeout time.Duration, flags uint64, header []byte, inFile *os.File, outFile *os.File) (*command, error) {
 	dir, err := ioutil.TempDir("./", "syzkaller-testdir")
 	if err != nil {
 		return nil, fmt.Errorf("failed to create temp dir: %v", err)
@@ -406,6 +667,7 @@ func makeCommand(pid int, bin []string, timeout time.Duration, flags uint64, inF
 	c.readDone = make(chan []byte, 1)
 
 	cmd := exec.Command(bin[0], bin[1:]...)
+	// Nil files are closed in executor, it then detects that shared memory is not available.
 	cmd.ExtraFiles = []*os.File{inFile, outFile, outrp, inwp}
 	cmd.Env = []string{}
 	cmd.Dir = dir
@@ -444,7 +706,7 @@ func makeCommand(pid int, bin []string, timeout time.Duration, flags uint64, inF
 	c.cmd = cmd
 	wp.Close()
 	inwp.Close()
-	if err := c.waitServing(); err != nil {
+	if err := c.waitServing(header[:inHeaderSize], inFile != nil); err != nil {
 		return nil, err
 	}
 
@@ -469,9 +731,13 @@ func (c *command) close() {
 }
 
 // Wait for executor to start serving (sandbox setup can take significant time).
-func (c *command) waitServing() error {
+func (c *command) waitServing(header []byte, shmem bool) error {
 	read := make(chan error, 1)
 	go func() {
+		if err := c.handshake(header, shmem); err != nil {
+			read <- err
+			return
+		}
 		var buf [1]byte
 		_, err := c.inrp.Read(buf[:])
 		read <- err
@@ -501,13 +767,66 @@ func (c *command) waitServing() error {
 	}
 }
 
+// handshake chooses transport for communication with executor (see handshakeMagic).
+// If pipes are chosen, it also sends the input header as executor needs it for setup.
+func (c *command) handshake(header []byte, shmem bool) error {
+	var hello [3]uint64
+	if err := binary.Read(c.inrp, binary.LittleEndian, &hello); err != nil {
+		return err
+	}
+	if hello[0] != handshakeMagic {
+		return fmt.Errorf("bad executor handshake magic 0x%x", hello[0])
+	}
+	c.pipe = !shmem || hello[1] != shmemVersion || hello[2] == 0
+	reply := [2]uint64{handshakeMagic, transportShmem}
+	if c.pipe {
+		reply[1] = transportPipe
+	}
+	if err := binary.Write(c.outwp, binary.LittleEndian, reply); err != nil {
+		return fmt.Errorf("failed to write control pipe: %v", err)
+	}
+	if c.pipe {
+		return c.writeInput(header)
+	}
+	return nil
+}
+
+func (c *command) writeInput(data []byte) error {
+	if err := binary.Write(c.outwp, binary.LittleEndian, uint64(len(data))); err != nil {
+		return err
+	}
+	_, err := c.outwp.Write(data)
+	return err
+}
+
+func (c *command) readOutput(out []byte) error {
+	var size uint64
+	if err := binary.Read(c.inrp, binary.LittleEndian, &size); err != nil {
+		return err
+	}
+	if size > uint64(len(out)) {
+		return fmt.Errorf("executor %v: output is too large: %v", c.pid, size)
+	}
+	_, err := io.ReadFull(c.inrp, out[:size])
+	return err
+}
+
 func (c *command) kill() {
 	syscall.Kill(c.cmd.Process.Pid, syscall.SIGKILL)
 }
 
-func (c *command) exec() (output []byte, failed, hanged, restart bool, err0 error) {
+// exec executes the program from input and waits for completion.
+// in and out are used only if the pipe transport is used,
+// otherwise executor accesses them directly through shared memory.
+func (c *command) exec(in, out []byte) (output []byte, failed, hanged, restart bool, err0 error) {
 	var tmp [1]byte
-	if _, err := c.outwp.Write(tmp[:]); err != nil {
+	var err error
+	if c.pipe {
+		err = c.writeInput(in)
+	} else {
+		_, err = c.outwp.Write(tmp[:])
+	}
+	if err != nil {
 		output = <-c.readDone
 		err0 = fmt.Errorf("failed to write control pipe: %v", err)
 		return
@@ -525,12 +844,18 @@ func (c *command) exec() (output []byte, failed, hanged, restart bool, err0 erro
 			hang <- false
 		}
 	}()
-	readN, readErr := c.inrp.Read(tmp[:])
-	close(done)
-	if readErr == nil {
-		if readN != len(tmp) {
+	var readErr error
+	if c.pipe {
+		readErr = c.readOutput(out)
+	} else {
+		var readN int
+		readN, readErr = c.inrp.Read(tmp[:])
+		if readErr == nil && readN != len(tmp) {
 			panic(fmt.Sprintf("executor %v: read only %v bytes", c.pid, readN))
 		}
+	}
+	close(done)
+	if readErr == nil {
 		<-hang
 		return
 	}
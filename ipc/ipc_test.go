@@ -6,6 +6,7 @@ package ipc
 import (
 	"math/rand"
 	"os"
+	"syscall"
 	"testing"
 	"time"
 
@@ -59,15 +60,15 @@ func TestEmptyProg(t *testing.T) {
 	defer env.Close()
 
 	p := new(prog.Prog)
-	output, cov, _, failed, hanged, err := env.Exec(p)
+	output, info, failed, hanged, err := env.Exec(nil, p)
 	if err != nil {
 		t.Fatalf("failed to run executor: %v", err)
 	}
 	if len(output) != 0 {
 		t.Fatalf("output on empty program")
 	}
-	if cov != nil {
-		t.Fatalf("haven't asked for coverage, but got it")
+	if len(info) != 0 {
+		t.Fatalf("got call info for empty program")
 	}
 	if failed || hanged {
 		t.Fatalf("empty program failed")
@@ -90,7 +91,7 @@ func TestExecute(t *testing.T) {
 
 		for i := 0; i < iters/len(flags); i++ {
 			p := prog.Generate(rs, 10, nil)
-			output, _, _, _, _, err := env.Exec(p)
+			output, _, _, _, err := env.Exec(nil, p)
 			if err != nil {
 				t.Logf("program:\n%s\n", p.Serialize())
 				t.Fatalf("failed to run executor: %v\n%s", err, output)
@@ -98,3 +99,77 @@ func TestExecute(t *testing.T) {
 		}
 	}
 }
+
+func TestExecutePipe(t *testing.T) {
+	bin := buildExecutor(t)
+	defer os.Remove(bin)
+
+	*flagPipe = true
+	defer func() { *flagPipe = false }()
+	env, err := MakeEnv(bin, timeout, FlagThreaded, 0)
+	if err != nil {
+		t.Fatalf("failed to create env: %v", err)
+	}
+	defer env.Close()
+
+	p, err := prog.Deserialize([]byte("getpid()\nclose(0xffffffffffffffff)\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, info, _, _, err := env.Exec(nil, p)
+	if err != nil {
+		t.Fatalf("failed to run executor: %v\n%s", err, output)
+	}
+	if !env.cmd.pipe {
+		t.Fatalf("executor does not use pipe transport")
+	}
+	if len(info) != 2 || info[0].Errno != 0 || info[1].Errno != int(syscall.EBADF) {
+		t.Fatalf("bad call info: %+v", info)
+	}
+}
+
+func TestExecutePipeCover(t *testing.T) {
+	if _, err := os.Stat("/sys/kernel/debug/kcov"); err != nil {
+		t.Skipf("coverage is not supported: %v", err)
+	}
+	bin := buildExecutor(t)
+	defer os.Remove(bin)
+
+	*flagPipe = true
+	defer func() { *flagPipe = false }()
+	env, err := MakeEnv(bin, timeout, FlagThreaded|FlagCover|FlagDedupCover|FlagEdgeSignal, 0)
+	if err != nil {
+		t.Fatalf("failed to create env: %v", err)
+	}
+	defer env.Close()
+
+	// All records must be transferred, including cover and signal of the last call.
+	p, err := prog.Deserialize([]byte("getpid()\nclose(0xffffffffffffffff)\ngetuid()\nclose(0xffffffffffffffff)\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		output, info, _, _, err := env.Exec(nil, p)
+		if err != nil {
+			t.Fatalf("failed to run executor: %v\n%s", err, output)
+		}
+		if !env.cmd.pipe {
+			t.Fatalf("executor does not use pipe transport")
+		}
+		if len(info) != len(p.Calls) {
+			t.Fatalf("got info for %v calls, want %v", len(info), len(p.Calls))
+		}
+		for j, inf := range info {
+			errno := 0
+			if p.Calls[j].Meta.CallName == "close" {
+				errno = int(syscall.EBADF)
+			}
+			if inf.Errno != errno {
+				t.Fatalf("call #%v: errno %v, want %v", j, inf.Errno, errno)
+			}
+			if len(inf.Cover) == 0 || len(inf.Signal) == 0 {
+				t.Fatalf("call #%v: got %v PCs and %v signals", j, len(inf.Cover), len(inf.Signal))
+			}
+		}
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// LogResponse is the JSON response of ServeHTTP.
+type LogResponse struct {
+	Name    string   `json:"name"`
+	Next    uint64   `json:"next"` // pass as since to get only newer messages
+	Entries []Entry  `json:"entries,omitempty"`
+	Buffers []string `json:"buffers,omitempty"` // names of all buffers (if name is not specified)
+}
+
+// ServeHTTP serves cached log output. Params:
+//  - name: named buffer (see Named), all output if empty
+//  - since: return only messages with larger sequence numbers (see CachedEntries)
+//  - format: "text" for plain text instead of JSON
+func ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := r.FormValue("name")
+	var since uint64
+	if str := r.FormValue("since"); str != "" {
+		var err error
+		if since, err = strconv.ParseUint(str, 10, 64); err != nil {
+			http.Error(w, fmt.Sprintf("bad since param: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	entries, next := CachedEntries(name, since)
+	if r.FormValue("format") == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Log-Next", fmt.Sprint(next))
+		fmt.Fprint(w, formatEntries(entries))
+		return
+	}
+	res := &LogResponse{
+		Name:    name,
+		Next:    next,
+		Entries: entries,
+	}
+	if name == "" {
+		res.Buffers = Buffers()
+	}
+	data, err := json.MarshalIndent(res, "", "\t")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
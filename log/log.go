@@ -6,6 +6,7 @@
 //  - global verbosity setting that can be used by multiple packages
 //  - ability to disable all output
 //  - ability to cache recent output in memory
+//  - named ring buffers (e.g. per VM instance or per subsystem) that can be queried over HTTP
 package log
 
 import (
@@ -13,83 +14,205 @@ import (
 	"flag"
 	"fmt"
 	golog "log"
+	"sort"
 	"sync"
 	"time"
 )
 
 var (
-	flagV        = flag.Int("v", 0, "verbosity")
-	mu           sync.Mutex
-	cacheMem     int
-	cacheMaxMem  int
-	cachePos     int
-	cacheEntries []string
-	prependTime  = true // for testing
+	flagV       = flag.Int("v", 0, "verbosity")
+	mu          sync.Mutex
+	cacheLines  int
+	cacheMaxMem int
+	cache       *ring            // all output, nil if caching is not enabled
+	named       map[string]*ring // named ring buffers
+	prependTime = true           // for testing
 )
 
+// Entry is a single cached log message.
+type Entry struct {
+	Seq  uint64    `json:"seq"` // sequence number of the message in the buffer, starts from 1
+	Time time.Time `json:"time"`
+	Msg  string    `json:"msg"`
+}
+
+// ring keeps up to len(entries) recent messages, but no more than maxMem bytes.
+type ring struct {
+	entries []Entry
+	pos     int // index of the oldest entry (the next one to overwrite)
+	mem     int
+	maxMem  int
+	seq     uint64 // sequence number of the last message
+}
+
+func newRing(maxLines, maxMem int) *ring {
+	return &ring{entries: make([]Entry, maxLines), maxMem: maxMem}
+}
+
+func (r *ring) add(t time.Time, msg string) {
+	r.mem -= len(r.entries[r.pos].Msg)
+	r.seq++
+	r.entries[r.pos] = Entry{Seq: r.seq, Time: t, Msg: msg}
+	r.mem += len(msg)
+	r.pos = (r.pos + 1) % len(r.entries)
+	for i := 0; i < len(r.entries)-1 && r.mem > r.maxMem; i++ {
+		pos := (r.pos + i) % len(r.entries)
+		r.mem -= len(r.entries[pos].Msg)
+		r.entries[pos] = Entry{}
+	}
+	if r.mem < 0 {
+		panic("log cache size underflow")
+	}
+}
+
+// since returns cached messages with sequence numbers larger than seq, oldest first.
+func (r *ring) since(seq uint64) []Entry {
+	var res []Entry
+	for i := range r.entries {
+		e := r.entries[(r.pos+i)%len(r.entries)]
+		if e.Seq > seq {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+func (e Entry) String() string {
+	if prependTime {
+		return e.Time.Format("2006/01/02 15:04:05 ") + e.Msg
+	}
+	return e.Msg
+}
+
 // EnableCaching enables in memory caching of log output.
 // Caches up to maxLines, but no more than maxMem bytes.
 // Cached output can later be queried with CachedOutput.
+// The same limits apply to every named buffer (see Named).
 func EnableLogCaching(maxLines, maxMem int) {
 	mu.Lock()
 	defer mu.Unlock()
-	if cacheEntries != nil {
+	if cache != nil {
 		Fatalf("log caching is already enabled")
 	}
 	if maxLines < 1 || maxMem < 1 {
 		panic("invalid maxLines/maxMem")
 	}
+	cacheLines = maxLines
 	cacheMaxMem = maxMem
-	cacheEntries = make([]string, maxLines)
+	cache = newRing(maxLines, maxMem)
+	named = make(map[string]*ring)
 }
 
 // Retrieves cached log output.
 func CachedLogOutput() string {
 	mu.Lock()
 	defer mu.Unlock()
+	if cache == nil {
+		return ""
+	}
+	return formatEntries(cache.since(0))
+}
+
+// CachedNamedOutput retrieves cached output of the named buffer (see Named).
+func CachedNamedOutput(name string) string {
+	mu.Lock()
+	defer mu.Unlock()
+	if named[name] == nil {
+		return ""
+	}
+	return formatEntries(named[name].since(0))
+}
+
+func formatEntries(entries []Entry) string {
 	buf := new(bytes.Buffer)
-	for i := range cacheEntries {
-		pos := (cachePos + i) % len(cacheEntries)
-		if cacheEntries[pos] == "" {
+	for _, e := range entries {
+		if e.Msg == "" {
 			continue
 		}
-		buf.WriteString(cacheEntries[pos])
+		buf.WriteString(e.String())
 		buf.Write([]byte{'\n'})
 	}
 	return buf.String()
 }
 
+// CachedEntries returns messages of the named buffer ("" for all output) with sequence numbers larger than since,
+// and the sequence number of the last message that can be used as since for the next query.
+// Messages that were evicted from the buffer are silently skipped.
+func CachedEntries(name string, since uint64) ([]Entry, uint64) {
+	mu.Lock()
+	defer mu.Unlock()
+	r := cache
+	if name != "" {
+		r = named[name]
+	}
+	if r == nil {
+		return nil, since
+	}
+	return r.since(since), r.seq
+}
+
+// Buffers returns sorted names of the named buffers.
+func Buffers() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	var names []string
+	for name := range named {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Logger writes to a named ring buffer in addition to the common log.
+// Messages in the common log are prefixed with the buffer name.
+type Logger struct {
+	name string
+}
+
+// Named returns a logger for the named buffer (e.g. "vm-0" or "hub").
+func Named(name string) *Logger {
+	return &Logger{name}
+}
+
+func (l *Logger) Logf(v int, msg string, args ...interface{}) {
+	logf(l.name, v, msg, args...)
+}
+
 func Logf(v int, msg string, args ...interface{}) {
+	logf("", v, msg, args...)
+}
+
+func logf(name string, v int, msg string, args ...interface{}) {
 	mu.Lock()
 	doLog := v <= *flagV
-	if cacheEntries != nil && v <= 1 {
-		cacheMem -= len(cacheEntries[cachePos])
-		if cacheMem < 0 {
-			panic("log cache size underflow")
-		}
-		timeStr := ""
-		if prependTime {
-			timeStr = time.Now().Format("2006/01/02 15:04:05 ")
-		}
-		cacheEntries[cachePos] = fmt.Sprintf(timeStr+msg, args...)
-		cacheMem += len(cacheEntries[cachePos])
-		cachePos++
-		if cachePos == len(cacheEntries) {
-			cachePos = 0
-		}
-		for i := 0; i < len(cacheEntries)-1 && cacheMem > cacheMaxMem; i++ {
-			pos := (cachePos + i) % len(cacheEntries)
-			cacheMem -= len(cacheEntries[pos])
-			cacheEntries[pos] = ""
-		}
-		if cacheMem < 0 {
-			panic("log cache size underflow")
+	doCache := cache != nil && v <= 1
+	mu.Unlock()
+	if !doLog && !doCache {
+		return
+	}
+	text := fmt.Sprintf(msg, args...)
+	if doCache {
+		mu.Lock()
+		now := time.Now()
+		if name != "" {
+			r := named[name]
+			if r == nil {
+				r = newRing(cacheLines, cacheMaxMem)
+				named[name] = r
+			}
+			r.add(now, text)
+			cache.add(now, name+": "+text)
+		} else {
+			cache.add(now, text)
 		}
+		mu.Unlock()
 	}
-	mu.Unlock()
 
 	if doLog {
-		golog.Printf(msg, args...)
+		if name != "" {
+			text = name + ": " + text
+		}
+		golog.Print(text)
 	}
 }
 
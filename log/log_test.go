@@ -4,9 +4,18 @@
 package log
 
 import (
+	"encoding/json"
+	"net/http/httptest"
 	"testing"
 )
 
+func resetCaching() {
+	mu.Lock()
+	cache = nil
+	named = nil
+	mu.Unlock()
+}
+
 func TestCaching(t *testing.T) {
 	tests := []struct{ str, want string }{
 		{"", ""},
@@ -20,13 +29,92 @@ func TestCaching(t *testing.T) {
 		{"hhhhhhhh", "ggggggg\nhhhhhhhh\n"},
 		{"jjjjjjjjjjjjjjjjjjjjjjjjj", "jjjjjjjjjjjjjjjjjjjjjjjjj\n"},
 	}
+	resetCaching()
 	EnableLogCaching(4, 20)
 	prependTime = false
 	for _, test := range tests {
-		Logf(1, test.str)
+		Logf(1, "%v", test.str)
 		out := CachedLogOutput()
 		if out != test.want {
 			t.Fatalf("wrote: %v\nwant: %v\ngot: %v", test.str, test.want, out)
 		}
 	}
 }
+
+func TestNamed(t *testing.T) {
+	resetCaching()
+	EnableLogCaching(3, 100)
+	prependTime = false
+	vm0, vm1 := Named("vm-0"), Named("vm-1")
+	vm0.Logf(0, "booted")
+	Logf(0, "common")
+	vm1.Logf(0, "booted")
+	vm0.Logf(0, "crash %v", 1)
+	vm0.Logf(2, "too verbose")
+	if got, want := CachedLogOutput(), "common\nvm-1: booted\nvm-0: crash 1\n"; got != want {
+		t.Fatalf("bad common output:\n%v\nwant:\n%v", got, want)
+	}
+	if got, want := Buffers(), []string{"vm-0", "vm-1"}; len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("got buffers %v, want %v", got, want)
+	}
+	entries, next := CachedEntries("vm-0", 0)
+	if len(entries) != 2 || entries[0].Msg != "booted" || entries[1].Msg != "crash 1" || next != 2 {
+		t.Fatalf("bad vm-0 entries: %+v, next %v", entries, next)
+	}
+	if entries, next := CachedEntries("vm-0", next); len(entries) != 0 || next != 2 {
+		t.Fatalf("bad vm-0 entries after cursor: %+v, next %v", entries, next)
+	}
+	vm0.Logf(1, "a")
+	vm0.Logf(1, "b")
+	// "booted" is evicted.
+	entries, next = CachedEntries("vm-0", 0)
+	if len(entries) != 3 || entries[0].Msg != "crash 1" || entries[0].Seq != 2 || next != 4 {
+		t.Fatalf("bad vm-0 entries after eviction: %+v, next %v", entries, next)
+	}
+	if entries, _ := CachedEntries("vm-0", 3); len(entries) != 1 || entries[0].Msg != "b" {
+		t.Fatalf("bad vm-0 entries since 3: %+v", entries)
+	}
+	if entries, next := CachedEntries("foo", 5); len(entries) != 0 || next != 5 {
+		t.Fatalf("bad entries of unknown buffer: %+v, next %v", entries, next)
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	resetCaching()
+	EnableLogCaching(10, 1000)
+	prependTime = false
+	Named("hub").Logf(0, "connected")
+	Named("hub").Logf(0, "synced %v", 10)
+
+	w := httptest.NewRecorder()
+	ServeHTTP(w, httptest.NewRequest("GET", "/log?name=hub&since=1", nil))
+	res := new(LogResponse)
+	if err := json.Unmarshal(w.Body.Bytes(), res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Name != "hub" || res.Next != 2 || len(res.Entries) != 1 || res.Entries[0].Msg != "synced 10" {
+		t.Fatalf("bad response: %+v", res)
+	}
+
+	w = httptest.NewRecorder()
+	ServeHTTP(w, httptest.NewRequest("GET", "/log", nil))
+	res = new(LogResponse)
+	if err := json.Unmarshal(w.Body.Bytes(), res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Buffers) != 1 || res.Buffers[0] != "hub" || len(res.Entries) != 2 || res.Entries[1].Msg != "hub: synced 10" {
+		t.Fatalf("bad response: %+v", res)
+	}
+
+	w = httptest.NewRecorder()
+	ServeHTTP(w, httptest.NewRequest("GET", "/log?name=hub&format=text", nil))
+	if got, want := w.Body.String(), "connected\nsynced 10\n"; got != want || w.Header().Get("X-Log-Next") != "2" {
+		t.Fatalf("bad text response: %q, want %q", got, want)
+	}
+
+	w = httptest.NewRecorder()
+	ServeHTTP(w, httptest.NewRequest("GET", "/log?since=foo", nil))
+	if w.Code != 400 {
+		t.Fatalf("bad since is not detected")
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package prog
+
+import (
+	"fmt"
+)
+
+// AddrLayout controls how generated programs shape the data area of the address space.
+// The zero value gives the compact layout: new mappings are placed at the lowest free pages.
+// Shaping exercises kernel address validation (access_ok, page faults on unmapped
+// or misaligned memory). Programs record the resulting addresses, so they are
+// reproducible regardless of the layout that was used to generate them.
+type AddrLayout struct {
+	GuardGaps bool // leave an unmapped page before and after new mappings
+	Scatter   bool // place new mappings at random free pages, including the lowest/highest pages of the data area
+	Unaligned bool // frequently misalign pointers
+}
+
+var addrLayoutFeatures = map[string]func(*AddrLayout){
+	"gaps":      func(l *AddrLayout) { l.GuardGaps = true },
+	"scatter":   func(l *AddrLayout) { l.Scatter = true },
+	"unaligned": func(l *AddrLayout) { l.Unaligned = true },
+}
+
+// ParseAddrLayout returns layout with the given features enabled (gaps, scatter, unaligned).
+func ParseAddrLayout(features []string) (AddrLayout, error) {
+	var layout AddrLayout
+	for _, name := range features {
+		enable := addrLayoutFeatures[name]
+		if enable == nil {
+			return layout, fmt.Errorf("unknown address layout feature %q (supported: gaps, scatter, unaligned)", name)
+		}
+		enable(&layout)
+	}
+	return layout, nil
+}
+
+var addrLayout AddrLayout
+
+// SetAddrLayout sets layout used by generation and mutation.
+// It must be called before programs are generated.
+func SetAddrLayout(layout AddrLayout) {
+	addrLayout = layout
+}
+
+// allocPages chooses the first page of a new mapping of npages pages.
+// It returns false if there is no suitable free range.
+func (r *randGen) allocPages(s *state, npages uintptr) (uintptr, bool) {
+	gap := uintptr(0)
+	if r.layout.GuardGaps {
+		gap = 1
+	}
+	free := func(start uintptr) bool {
+		from, to := start, start+npages+gap
+		if from >= gap {
+			from -= gap
+		}
+		if to > maxPages {
+			to = maxPages
+		}
+		for i := from; i < to; i++ {
+			if s.pages[i] {
+				return false
+			}
+		}
+		return true
+	}
+	if r.layout.Scatter {
+		switch {
+		case r.oneOf(10):
+			if free(0) {
+				return 0, true
+			}
+		case r.oneOf(10):
+			if free(maxPages - npages) {
+				return maxPages - npages, true
+			}
+		}
+		for try := 0; try < 10; try++ {
+			if start := r.rand(int(maxPages - npages)); free(start) {
+				return start, true
+			}
+		}
+	}
+	for i := uintptr(0); i < maxPages-npages; i++ {
+		if free(i) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// misalign shifts pointer arg by a few bytes away from natural alignment.
+func (r *randGen) misalign(arg *Arg) {
+	shift := 1 + r.Intn(7)
+	if arg.AddrOffset < 0 {
+		arg.AddrOffset -= shift
+	} else {
+		arg.AddrOffset += shift
+	}
+}
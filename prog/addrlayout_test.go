@@ -0,0 +1,69 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package prog
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseAddrLayout(t *testing.T) {
+	layout, err := ParseAddrLayout([]string{"gaps", "unaligned"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (AddrLayout{GuardGaps: true, Unaligned: true}); layout != want {
+		t.Fatalf("got layout %+v, want %+v", layout, want)
+	}
+	if _, err := ParseAddrLayout([]string{"gaps", "foo"}); err == nil {
+		t.Fatalf("parsed unknown layout feature")
+	}
+}
+
+func TestAllocPagesGuardGaps(t *testing.T) {
+	rs, iters := initTest(t)
+	for i := 0; i < iters; i++ {
+		r := newRand(rs, defaultTarget)
+		r.layout = AddrLayout{GuardGaps: true, Scatter: r.bin()}
+		s := newState(nil)
+		for j := 0; j < 100; j++ {
+			s.pages[r.Intn(maxPages)] = true
+		}
+		npages := 1 + r.rand(10)
+		page, ok := r.allocPages(s, npages)
+		if !ok {
+			t.Fatalf("failed to allocate %v pages", npages)
+		}
+		for j := int(page) - 1; j <= int(page+npages); j++ {
+			if j >= 0 && j < maxPages && s.pages[j] {
+				t.Fatalf("allocated pages [%v, %v) without guard gaps, page %v is mapped",
+					page, page+npages, j)
+			}
+		}
+	}
+}
+
+func TestAddrLayoutGeneration(t *testing.T) {
+	rs, iters := initTest(t)
+	for i := 0; i < iters; i++ {
+		r := newRand(rs, defaultTarget)
+		r.layout = AddrLayout{GuardGaps: true, Scatter: true, Unaligned: true}
+		p := r.generate(10, nil)
+		if err := p.validate(); err != nil {
+			t.Fatalf("generated invalid program: %v\n%s", err, p.Serialize())
+		}
+		data := p.Serialize()
+		p1, err := Deserialize(data)
+		if err != nil {
+			t.Fatalf("failed to deserialize program: %v\n%s", err, data)
+		}
+		if data1 := p1.Serialize(); !bytes.Equal(data, data1) {
+			t.Fatalf("program changed after serialize/deserialize\noriginal:\n%s\n\nnew:\n%s\n", data, data1)
+		}
+		buf := make([]byte, ExecBufferSize)
+		if _, err := p.SerializeForExec(buf, 0); err != nil {
+			t.Fatalf("failed to serialize for exec: %v\n%s", err, data)
+		}
+	}
+}
@@ -10,6 +10,7 @@ package prog
 
 import (
 	"fmt"
+	"sort"
 
 	"github.com/google/syzkaller/sys"
 )
@@ -48,6 +49,26 @@ func newState(ct *ChoiceTable) *state {
 	return s
 }
 
+// resourceKinds returns sorted kinds of available resources,
+// map iteration order is random and would make generation non-deterministic.
+func (s *state) resourceKinds() []string {
+	kinds := make([]string, 0, len(s.resources))
+	for kind := range s.resources {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func (s *state) analyze(c *Call) {
 	foreachArgArray(&c.Args, c.Ret, func(arg, base *Arg, _ *[]*Arg) {
 		switch typ := arg.Type.(type) {
@@ -0,0 +1,96 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package prog
+
+import (
+	"strings"
+)
+
+// Programs and calls can carry comments: free-form text lines that are preserved
+// by serialization (as '#'-comments in the text format).
+// Comment lines of the form "key: value" are annotations that allow to attach
+// metadata to programs (e.g. provenance of a reproducer) without sidecar files:
+//
+//	# origin: hub
+//	# time: 2017-06-01T12:00:00Z
+//
+//	# cover: 9f2d4d87c2e1f1cb
+//	mmap(&(0x7f0000000000/0x1000)=nil, 0x1000, 0x3, 0x32, 0xffffffffffffffff, 0x0)
+//
+// Comments before the first empty line belong to the program, the rest to the following calls.
+// The binary format does not preserve comments.
+// Mutation drops all comments of the program, since they describe the original program
+// (e.g. its coverage) and would be stale for the mutated one.
+
+// Well-known annotation keys.
+const (
+	AnnotationOrigin = "origin" // where the program comes from (e.g. "hub", "repro", "fuzzer")
+	AnnotationTime   = "time"   // when the program was created (RFC 3339)
+	AnnotationCover  = "cover"  // hash of coverage of the program or call
+)
+
+// Annotation returns value of annotation key in comments, or "" if it's not present.
+func Annotation(comments []string, key string) string {
+	for _, comment := range comments {
+		if k, v, ok := parseAnnotation(comment); ok && k == key {
+			return v
+		}
+	}
+	return ""
+}
+
+// SetAnnotation sets value of annotation key in comments and returns the updated comments.
+// Empty value removes the annotation.
+func SetAnnotation(comments []string, key, value string) []string {
+	var res []string
+	set := false
+	for _, comment := range comments {
+		if k, _, ok := parseAnnotation(comment); ok && k == key {
+			if value != "" && !set {
+				res = append(res, key+": "+value)
+				set = true
+			}
+			continue
+		}
+		res = append(res, comment)
+	}
+	if value != "" && !set {
+		res = append(res, key+": "+value)
+	}
+	return res
+}
+
+func parseAnnotation(comment string) (key, value string, ok bool) {
+	colon := strings.Index(comment, ": ")
+	if colon <= 0 {
+		return "", "", false
+	}
+	key = comment[:colon]
+	for _, ch := range key {
+		if !(ch >= 'a' && ch <= 'z' || ch >= '0' && ch <= '9' || ch == '_' || ch == '-') {
+			return "", "", false
+		}
+	}
+	return key, comment[colon+2:], true
+}
+
+// parseComment returns text of a comment line (without the '#' marker).
+func parseComment(s string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(s, " "), "\r")
+}
+
+func cloneComments(comments []string) []string {
+	if comments == nil {
+		return nil
+	}
+	return append([]string{}, comments...)
+}
+
+// dropComments removes comments of the program and all its calls.
+func (p *Prog) dropComments() {
+	p.Comments = nil
+	for _, c := range p.Calls {
+		c.Comments = nil
+	}
+}
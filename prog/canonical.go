@@ -0,0 +1,166 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package prog
+
+import (
+	"sort"
+
+	"github.com/google/syzkaller/sys"
+)
+
+// Canonicalize brings the program into a canonical form in-place,
+// so that programs that differ only in semantically irrelevant details
+// serialize identically (e.g. for corpus deduplication). Namely:
+//  - memory is compacted: all used address ranges are moved to the beginning
+//    of the data area preserving their order, adjacency and a 1-page gap between
+//    non-adjacent ranges (so that out-of-bounds accesses still hit unmapped memory);
+//  - values of in-memory input ints, flags, lens and resources are truncated to their size
+//    (bitfields to their length), higher bits are dropped by the executor anyway;
+//  - values of checksum fields are zeroed (they are calculated before execution);
+//  - contents of output buffers are zeroed.
+// Variables (resources) don't need renumbering: serialization numbers them
+// in order of appearance, which is canonical by construction.
+func (p *Prog) Canonicalize() {
+	t := p.target()
+	for _, c := range p.Calls {
+		for _, arg := range c.Args {
+			canonicalizeArg(t, arg, false)
+		}
+	}
+	p.compactMemory()
+}
+
+func canonicalizeArg(t *Target, arg *Arg, inMemory bool) {
+	if arg == nil {
+		return
+	}
+	switch arg.Kind {
+	case ArgConst:
+		switch typ := arg.Type.(type) {
+		case *sys.CsumType:
+			arg.Val = 0
+		case *sys.IntType, *sys.FlagsType, *sys.LenType, *sys.ResourceType:
+			if !inMemory || typ.Dir() == sys.DirOut {
+				// Syscall arguments are passed as full machine words,
+				// and output args must have default values.
+				break
+			}
+			bits := typ.Size() * 8
+			if typ.BitfieldLength() != 0 {
+				bits = typ.BitfieldLength()
+			}
+			if bits < t.PtrSize*8 {
+				arg.Val &= 1<<bits - 1
+			}
+		}
+	case ArgData:
+		if arg.Type.Dir() == sys.DirOut {
+			for i := range arg.Data {
+				arg.Data[i] = 0
+			}
+		}
+	case ArgPointer:
+		canonicalizeArg(t, arg.Res, true)
+	case ArgGroup:
+		for _, arg1 := range arg.Inner {
+			canonicalizeArg(t, arg1, inMemory)
+		}
+	case ArgUnion:
+		canonicalizeArg(t, arg.Option, inMemory)
+	}
+}
+
+// pageRange is a half-open range of data pages [start, end) used by a pointer.
+type pageRange struct {
+	start, end uintptr
+	arg        *Arg
+}
+
+type pageRanges []pageRange
+
+func (r pageRanges) Len() int           { return len(r) }
+func (r pageRanges) Less(i, j int) bool { return r[i].start < r[j].start }
+func (r pageRanges) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+
+func (p *Prog) compactMemory() {
+	t := p.target()
+	var ranges pageRanges
+	for _, c := range p.Calls {
+		// mmap/munmap/mremap can affect more pages than the address arg says.
+		var vma, size *Arg
+		switch c.Meta.CallName {
+		case "mmap", "munmap":
+			vma, size = c.Args[0], c.Args[1]
+		case "mremap":
+			vma, size = c.Args[4], c.Args[2]
+		}
+		foreachArg(c, func(arg, _ *Arg, _ *[]*Arg) {
+			if arg.Kind != ArgPointer {
+				return
+			}
+			start, end := pointerPages(t, arg)
+			if arg == vma && size != nil && size.Kind == ArgPageSize {
+				n := size.AddrPage
+				if size.AddrOffset != 0 {
+					n++
+				}
+				if end < start+n {
+					end = start + n
+				}
+			}
+			ranges = append(ranges, pageRange{start, end, arg})
+		})
+	}
+	sort.Stable(ranges)
+	next, blockStart, blockEnd, shift := uintptr(0), uintptr(0), uintptr(0), uintptr(0)
+	for i, r := range ranges {
+		if i == 0 || r.start > blockEnd {
+			// Start of a new block of adjacent/overlapping ranges.
+			if i != 0 {
+				next += blockEnd - blockStart + 1
+			}
+			blockStart, blockEnd = r.start, r.end
+			shift = blockStart - next
+		}
+		if blockEnd < r.end {
+			blockEnd = r.end
+		}
+		r.arg.AddrPage -= shift
+	}
+}
+
+// pointerPages returns range of pages that pointer arg refers to.
+func pointerPages(t *Target, arg *Arg) (uintptr, uintptr) {
+	if arg.Res == nil {
+		n := arg.AddrPagesNum
+		if n == 0 {
+			n = 1
+		}
+		return arg.AddrPage, arg.AddrPage + n
+	}
+	// This mirrors address calculation in physicalAddr,
+	// but negative offsets can point before the data area.
+	pageSize := int(t.PageSize)
+	addr := int(arg.AddrPage) * pageSize
+	if arg.AddrOffset >= 0 {
+		addr += arg.AddrOffset
+	} else {
+		addr += pageSize + arg.AddrOffset
+	}
+	if addr < 0 {
+		addr = 0
+	}
+	size := int(arg.Res.Size())
+	if size == 0 {
+		size = 1
+	}
+	start, end := uintptr(addr/pageSize), uintptr((addr+size+pageSize-1)/pageSize)
+	if start > arg.AddrPage {
+		start = arg.AddrPage
+	}
+	if end <= arg.AddrPage {
+		end = arg.AddrPage + 1
+	}
+	return start, end
+}
@@ -0,0 +1,65 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package prog
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCanonicalizeRandom(t *testing.T) {
+	rs, iters := initTest(t)
+	for i := 0; i < iters; i++ {
+		p := Generate(rs, 10, nil)
+		p.Mutate(rs, 10, nil, nil)
+		orig := p.Serialize()
+		p.Canonicalize()
+		if err := p.validate(); err != nil {
+			t.Fatalf("canonical program is invalid: %v\n%s", err, orig)
+		}
+		data := p.Serialize()
+		p1, err := Deserialize(data)
+		if err != nil {
+			t.Fatalf("failed to deserialize canonical program: %v\n%s", err, data)
+		}
+		p1.Canonicalize()
+		if data1 := p1.Serialize(); !bytes.Equal(data, data1) {
+			t.Fatalf("canonicalization is not idempotent:\n%s\n\nvs:\n%s", data, data1)
+		}
+		if _, err := p.SerializeForExec(make([]byte, ExecBufferSize), i%16); err != nil {
+			t.Fatalf("failed to serialize canonical program for exec: %v", err)
+		}
+	}
+}
+
+func TestCanonicalize(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		{
+			"mmap(&(0x7f0000010000/0x2000)=nil, (0x2000), 0x3, 0x32, 0xffffffffffffffff, 0x0)\n" +
+				"syz_test$length0(&(0x7f0000011000+0xffe)={0x1, 0x2})\n" +
+				"syz_test$length10(&(0x7f0000030000/0x1000)=nil, 0x0)\n",
+			"mmap(&(0x7f0000000000/0x2000)=nil, (0x2000), 0x3, 0x32, 0xffffffffffffffff, 0x0)\n" +
+				"syz_test$length0(&(0x7f0000001000+0xffe)={0x1, 0x2})\n" +
+				"syz_test$length10(&(0x7f0000004000/0x1000)=nil, 0x0)\n",
+		},
+		{
+			"syz_test$length0(&(0x7f0000005000)={0x12345, 0xffffffffffff0002})\n" +
+				"syz_test$int(0xffffffffffffffff, 0x1ff, 0x0, 0x0, 0x0)\n",
+			"syz_test$length0(&(0x7f0000000000)={0x2345, 0x2})\n" +
+				"syz_test$int(0xffffffffffffffff, 0x1ff, 0x0, 0x0, 0x0)\n",
+		},
+	}
+	for i, test := range tests {
+		p, err := Deserialize([]byte(test.in))
+		if err != nil {
+			t.Fatalf("#%v: failed to deserialize program: %v", i, err)
+		}
+		p.Canonicalize()
+		if out := string(p.Serialize()); out != test.out {
+			t.Fatalf("#%v: bad canonical program:\n%s\nwant:\n%s", i, out, test.out)
+		}
+	}
+}
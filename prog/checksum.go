@@ -5,6 +5,8 @@ package prog
 
 import (
 	"fmt"
+	"hash/crc32"
+	"sort"
 	"unsafe"
 
 	"github.com/google/syzkaller/sys"
@@ -38,6 +40,64 @@ func ipChecksum(data []byte) uint16 {
 	return csum.Digest()
 }
 
+// CRC32Checksum is the IEEE 802.3 CRC-32 (crc32_le in Linux, also used by zlib).
+type CRC32Checksum struct {
+	crc uint32
+}
+
+func (csum *CRC32Checksum) Update(data []byte) {
+	csum.crc = crc32.Update(csum.crc, crc32.IEEETable, data)
+}
+
+func (csum *CRC32Checksum) Digest() uint32 {
+	return csum.crc
+}
+
+func crc32Checksum(data []byte) uint32 {
+	var csum CRC32Checksum
+	csum.Update(data)
+	return csum.Digest()
+}
+
+// crc16Table is the table for the CRC-16 polynomial x^16 + x^15 + x^2 + 1 (0x8005)
+// in the reversed bit order, the same as used by crc16 in Linux.
+var crc16Table = func() [256]uint16 {
+	var table [256]uint16
+	for i := range table {
+		crc := uint16(i)
+		for j := 0; j < 8; j++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xa001
+			} else {
+				crc >>= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
+
+// CRC16Checksum is the CRC-16 (also known as CRC-16/ARC) with zero initial value.
+type CRC16Checksum struct {
+	crc uint16
+}
+
+func (csum *CRC16Checksum) Update(data []byte) {
+	for _, b := range data {
+		csum.crc = csum.crc>>8 ^ crc16Table[byte(csum.crc)^b]
+	}
+}
+
+func (csum *CRC16Checksum) Digest() uint16 {
+	return csum.crc
+}
+
+func crc16Checksum(data []byte) uint16 {
+	var csum CRC16Checksum
+	csum.Update(data)
+	return csum.Digest()
+}
+
 func bitmaskLen(bfLen uint64) uint64 {
 	return (1 << bfLen) - 1
 }
@@ -90,11 +150,76 @@ func storeByBitmask64(addr *uint64, value uint64, bfOff uint64, bfLen uint64) {
 	}
 }
 
-func encodeStruct(arg *Arg, pid int) []byte {
+// bitfieldByte is a part of a bitfield that falls into a single byte of the storage unit.
+type bitfieldByte struct {
+	offset uintptr // offset of the byte within the storage unit
+	val    uintptr // bits of the bitfield value, starting from the lowest bit stored in this byte
+	bfOff  uintptr
+	bfLen  uintptr
+}
+
+// splitBigEndianBitfield splits a big-endian bitfield into per-byte parts.
+// If a big-endian bitfield crosses a byte boundary, its bits are not contiguous
+// in a native little-endian load of the storage unit, so it can't be stored with a single
+// masked store. Bits within a single byte are always contiguous.
+// val is the (not swapped) value of the bitfield.
+func splitBigEndianBitfield(val, size, bfOff, bfLen uintptr) []bitfieldByte {
+	var parts []bitfieldByte
+	for i := uintptr(0); i < size; i++ {
+		lo := (size - 1 - i) * 8 // the lowest bit of the unit value stored in byte i
+		start, end := bfOff, bfOff+bfLen
+		if start < lo {
+			start = lo
+		}
+		if end > lo+8 {
+			end = lo + 8
+		}
+		if start >= end {
+			continue
+		}
+		n := end - start
+		parts = append(parts, bitfieldByte{
+			offset: i,
+			val:    val >> (start - bfOff) & (1<<n - 1),
+			bfOff:  start - lo,
+			bfLen:  n,
+		})
+	}
+	return parts
+}
+
+// isSwappedBitfield returns true if arg is a bitfield that needs to be stored
+// byte-by-byte with splitBigEndianBitfield.
+func isSwappedBitfield(arg *Arg) bool {
+	return arg.Kind == ArgConst && arg.Type.BitfieldLength() != 0 && sys.IsBigEndian(arg.Type)
+}
+
+// encodeStruct returns binary representation of arg.
+// Checksum fields that are present in csumMap are replaced with the calculated values.
+func encodeStruct(arg *Arg, pid int, csumMap map[*Arg]*Arg) []byte {
 	bytes := make([]byte, arg.Size())
 	foreachSubargOffset(arg, func(arg *Arg, offset uintptr) {
 		switch arg.Kind {
 		case ArgConst:
+			if sys.IsPad(arg.Type) {
+				// Padding is zero and can have arbitrary size.
+				break
+			}
+			if newArg, ok := csumMap[arg]; ok {
+				arg = newArg
+			}
+			if _, ok := arg.Type.(*sys.VarintType); ok {
+				copy(bytes[offset:], encodeVarint(arg.Val))
+				break
+			}
+			if isSwappedBitfield(arg) {
+				for _, part := range splitBigEndianBitfield(arg.value(pid, false), arg.Size(),
+					arg.Type.BitfieldOffset(), arg.Type.BitfieldLength()) {
+					storeByBitmask8(&bytes[offset+part.offset], uint8(part.val),
+						uint64(part.bfOff), uint64(part.bfLen))
+				}
+				break
+			}
 			addr := unsafe.Pointer(&bytes[offset])
 			val := arg.Value(pid)
 			bfOff := uint64(arg.Type.BitfieldOffset())
@@ -120,38 +245,213 @@ func encodeStruct(arg *Arg, pid int) []byte {
 	return bytes
 }
 
-func calcChecksumIPv4(arg *Arg, pid int) (*Arg, *Arg) {
-	var csumField *Arg
-	for _, field := range arg.Inner {
-		if _, ok := field.Type.(*sys.CsumType); ok {
-			csumField = field
-			break
+func calcChecksum(kind sys.CsumKind, data, header []byte) uintptr {
+	switch kind {
+	case sys.CsumInet, sys.CsumPseudo:
+		var csum IPChecksum
+		csum.Update(header)
+		csum.Update(data)
+		return uintptr(csum.Digest())
+	case sys.CsumCRC32:
+		return uintptr(crc32Checksum(data))
+	case sys.CsumCRC16:
+		return uintptr(crc16Checksum(data))
+	default:
+		panic(fmt.Sprintf("unknown csum kind %v", kind))
+	}
+}
+
+// pseudoHeader returns IPv4 (RFC 793) or IPv6 (RFC 2460) pseudo-header
+// for a packet of the given length and protocol sent within ipHeader.
+func pseudoHeader(ipHeader *Arg, protocol uint8, length int, pid int) []byte {
+	var srcAddr, dstAddr []byte
+	for _, field := range ipHeader.Inner {
+		switch field.Type.FieldName() {
+		case "src_ip":
+			srcAddr = encodeStruct(field, pid, nil)
+		case "dst_ip":
+			dstAddr = encodeStruct(field, pid, nil)
+		}
+	}
+	if srcAddr == nil || dstAddr == nil || len(srcAddr) != len(dstAddr) {
+		panic(fmt.Sprintf("bad ip header %v: can't find src/dst address", ipHeader.Type.Name()))
+	}
+	var header []byte
+	header = append(header, srcAddr...)
+	header = append(header, dstAddr...)
+	switch len(srcAddr) {
+	case 4:
+		header = append(header, 0, protocol, byte(length>>8), byte(length))
+	case 16:
+		header = append(header, byte(length>>24), byte(length>>16), byte(length>>8), byte(length))
+		header = append(header, 0, 0, 0, protocol)
+	default:
+		panic(fmt.Sprintf("bad ip header %v: address size %v", ipHeader.Type.Name(), len(srcAddr)))
+	}
+	return header
+}
+
+// isIPHeader says if arg contains source and destination addresses
+// that go into the pseudo-header of the encapsulated packets.
+func isIPHeader(arg *Arg) bool {
+	switch arg.Type.Name() {
+	case "ipv4_header", "ipv6_packet":
+		return true
+	case "syz_csum_ipv4_header", "syz_csum_ipv6_header":
+		// These are used in tests.
+		return true
+	}
+	return false
+}
+
+// findIPHeader returns the IP header of the closest packet that encloses arg.
+func findIPHeader(arg *Arg, parentsMap map[*Arg]*Arg) *Arg {
+	for parent := arg; parent != nil; parent = parentsMap[parent] {
+		if isIPHeader(parent) {
+			return parent
+		}
+		for _, field := range parent.Inner {
+			if isIPHeader(field) {
+				return field
+			}
 		}
 	}
-	if csumField == nil {
-		panic(fmt.Sprintf("failed to find csum field in %v", arg.Type.Name()))
+	return nil
+}
+
+func findCsummedArg(csumField *Arg, typ *sys.CsumType, parentsMap map[*Arg]*Arg) *Arg {
+	if parent := parentsMap[csumField]; parent != nil {
+		for _, field := range parent.Inner {
+			if field != csumField && field.Type.FieldName() == typ.Buf {
+				return field
+			}
+		}
 	}
-	if csumField.Value(pid) != 0 {
-		panic(fmt.Sprintf("checksum field has nonzero value %v, arg: %+v", csumField.Value(pid), csumField))
+	if typ.Buf == "parent" {
+		if parent := parentsMap[csumField]; parent != nil {
+			return parent
+		}
+	} else {
+		for parent := parentsMap[csumField]; parent != nil; parent = parentsMap[parent] {
+			if typ.Buf == parent.Type.Name() {
+				return parent
+			}
+		}
 	}
-	bytes := encodeStruct(arg, pid)
-	csum := ipChecksum(bytes)
-	newCsumField := *csumField
-	newCsumField.Val = uintptr(csum)
-	return csumField, &newCsumField
+	panic(fmt.Sprintf("csum field '%v' references non existent struct '%v'", typ.FieldName(), typ.Buf))
 }
 
 func calcChecksumsCall(c *Call, pid int) map[*Arg]*Arg {
-	var m map[*Arg]*Arg
+	var csumFields []*Arg
+	foreachArgArray(&c.Args, nil, func(arg, base *Arg, _ *[]*Arg) {
+		if _, ok := arg.Type.(*sys.CsumType); ok {
+			csumFields = append(csumFields, arg)
+		}
+	})
+	if len(csumFields) == 0 {
+		return nil
+	}
+	// Checksums are calculated over parts of packets that don't cross pointers,
+	// so we track parents of fields within structs and unions only.
+	parentsMap := make(map[*Arg]*Arg)
 	foreachArgArray(&c.Args, nil, func(arg, base *Arg, _ *[]*Arg) {
-		// syz_csum_ipv4 struct is used in tests
-		if arg.Type.Name() == "ipv4_header" || arg.Type.Name() == "syz_csum_ipv4" {
-			if m == nil {
-				m = make(map[*Arg]*Arg)
+		switch arg.Type.(type) {
+		case *sys.StructType:
+			for _, field := range arg.Inner {
+				parentsMap[field] = arg
 			}
-			k, v := calcChecksumIPv4(arg, pid)
-			m[k] = v
+		case *sys.UnionType:
+			parentsMap[arg.Option] = arg
 		}
 	})
+	// Checksums can cover other checksums (e.g. CRC of a whole filesystem
+	// structure that contains CRCs of its parts), so the innermost ones go first.
+	csummedArgs := make(map[*Arg]*Arg)
+	depths := make(map[*Arg]int)
+	for _, csumField := range csumFields {
+		var arg *Arg
+		if len(csumField.Type.(*sys.CsumType).Regions) != 0 {
+			// Regions are fields of the struct that contains the checksum.
+			arg = parentsMap[csumField]
+		} else {
+			arg = findCsummedArg(csumField, csumField.Type.(*sys.CsumType), parentsMap)
+		}
+		csummedArgs[csumField] = arg
+		for ; arg != nil; arg = parentsMap[arg] {
+			depths[csumField]++
+		}
+	}
+	sort.Stable(csumFieldsByDepth{csumFields, depths})
+	m := make(map[*Arg]*Arg)
+	for _, csumField := range csumFields {
+		if csumField.Value(pid) != 0 {
+			panic(fmt.Sprintf("checksum field has nonzero value %v, arg: %+v", csumField.Value(pid), csumField))
+		}
+		typ := csumField.Type.(*sys.CsumType)
+		arg := csummedArgs[csumField]
+		data := encodeStruct(arg, pid, m)
+		if len(typ.Regions) != 0 {
+			data = csumRegionsData(csumField, typ.Regions, arg, data)
+		}
+		var header []byte
+		if typ.Kind == sys.CsumPseudo {
+			// If the packet is not encapsulated into IPv4/IPv6 packet,
+			// there is no pseudo-header and we checksum only the packet itself.
+			if ipHeader := findIPHeader(arg, parentsMap); ipHeader != nil {
+				header = pseudoHeader(ipHeader, uint8(typ.Protocol), len(data), pid)
+			}
+		}
+		newCsumField := *csumField
+		newCsumField.Val = calcChecksum(typ.Kind, data, header)
+		m[csumField] = &newCsumField
+	}
 	return m
 }
+
+// csumRegionsData returns concatenation of regions of struct arg
+// (data is the binary representation of arg) excluding the checksum field itself.
+func csumRegionsData(csumField *Arg, regions []sys.CsumRegion, arg *Arg, data []byte) []byte {
+	if arg == nil || len(arg.Inner) == 0 {
+		panic(fmt.Sprintf("checksum field '%v' over regions is not in a struct", csumField.Type.FieldName()))
+	}
+	// Bitfields that share the same storage unit have the same offset.
+	offsets := make(map[string][2]uintptr)
+	var csumOffset uintptr
+	offset := uintptr(0)
+	for _, fld := range arg.Inner {
+		if !sys.IsPad(fld.Type) {
+			offsets[fld.Type.FieldName()] = [2]uintptr{offset, offset + fld.Size()}
+		}
+		if fld == csumField {
+			csumOffset = offset
+		}
+		if fld.Type.BitfieldLength() == 0 || fld.Type.BitfieldLast() {
+			offset += fld.Size()
+		}
+	}
+	var res []byte
+	for _, r := range regions {
+		first, ok1 := offsets[r.First]
+		last, ok2 := offsets[r.Last]
+		if !ok1 || !ok2 {
+			panic(fmt.Sprintf("checksum field '%v' references non existent fields '%v:%v'",
+				csumField.Type.FieldName(), r.First, r.Last))
+		}
+		start, end := first[0], last[1]
+		if csumOffset >= start && csumOffset < end {
+			res = append(res, data[start:csumOffset]...)
+			start = csumOffset + csumField.Size()
+		}
+		res = append(res, data[start:end]...)
+	}
+	return res
+}
+
+type csumFieldsByDepth struct {
+	fields []*Arg
+	depths map[*Arg]int
+}
+
+func (a csumFieldsByDepth) Len() int           { return len(a.fields) }
+func (a csumFieldsByDepth) Less(i, j int) bool { return a.depths[a.fields[i]] > a.depths[a.fields[j]] }
+func (a csumFieldsByDepth) Swap(i, j int)      { a.fields[i], a.fields[j] = a.fields[j], a.fields[i] }
@@ -5,7 +5,10 @@ package prog
 
 import (
 	"bytes"
+	"reflect"
 	"testing"
+
+	"github.com/google/syzkaller/sys"
 )
 
 func TestChecksumIP(t *testing.T) {
@@ -65,7 +68,7 @@ func TestChecksumIP(t *testing.T) {
 
 func TestChecksumIPAcc(t *testing.T) {
 	rs, iters := initTest(t)
-	r := newRand(rs)
+	r := newRand(rs, defaultTarget)
 
 	for i := 0; i < iters; i++ {
 		bytes := make([]byte, r.Intn(256))
@@ -87,6 +90,56 @@ func TestChecksumIPAcc(t *testing.T) {
 	}
 }
 
+func TestChecksumCRC(t *testing.T) {
+	tests := []struct {
+		data  string
+		crc32 uint32
+		crc16 uint16
+	}{
+		{"", 0x00000000, 0x0000},
+		{"\x00", 0xd202ef8d, 0x0000},
+		{"123456789", 0xcbf43926, 0xbb3d},
+		{"\xff\xff\xff\xff", 0xffffffff, 0x9401},
+	}
+	for _, test := range tests {
+		if crc := crc32Checksum([]byte(test.data)); crc != test.crc32 {
+			t.Fatalf("incorrect crc32, got: %x, want: %x, data: %+v", crc, test.crc32, []byte(test.data))
+		}
+		if crc := crc16Checksum([]byte(test.data)); crc != test.crc16 {
+			t.Fatalf("incorrect crc16, got: %x, want: %x, data: %+v", crc, test.crc16, []byte(test.data))
+		}
+	}
+}
+
+func TestChecksumCRCAcc(t *testing.T) {
+	rs, iters := initTest(t)
+	r := newRand(rs, defaultTarget)
+
+	for i := 0; i < iters; i++ {
+		bytes := make([]byte, r.Intn(256))
+		for i := 0; i < len(bytes); i++ {
+			bytes[i] = byte(r.Intn(256))
+		}
+		var crc32Acc CRC32Checksum
+		var crc16Acc CRC16Checksum
+		for pos := 0; pos < len(bytes); {
+			step := int(r.randRange(1, 16))
+			if pos+step > len(bytes) {
+				step = len(bytes) - pos
+			}
+			crc32Acc.Update(bytes[pos : pos+step])
+			crc16Acc.Update(bytes[pos : pos+step])
+			pos += step
+		}
+		if crc := crc32Checksum(bytes); crc != crc32Acc.Digest() {
+			t.Fatalf("inconsistent crc32: %x vs %x, data: %+v", crc, crc32Acc.Digest(), bytes)
+		}
+		if crc := crc16Checksum(bytes); crc != crc16Acc.Digest() {
+			t.Fatalf("inconsistent crc16: %x vs %x, data: %+v", crc, crc16Acc.Digest(), bytes)
+		}
+	}
+}
+
 func TestChecksumEncode(t *testing.T) {
 	tests := []struct {
 		prog    string
@@ -102,21 +155,37 @@ func TestChecksumEncode(t *testing.T) {
 		if err != nil {
 			t.Fatalf("failed to deserialize prog %v: %v", test.prog, err)
 		}
-		encoded := encodeStruct(p.Calls[0].Args[0].Res, 0)
+		encoded := encodeStruct(p.Calls[0].Args[0].Res, 0, nil)
 		if !bytes.Equal(encoded, []byte(test.encoded)) {
 			t.Fatalf("incorrect encoding for prog #%v, got: %+v, want: %+v", i, encoded, []byte(test.encoded))
 		}
 	}
 }
 
-func TestChecksumIPv4Calc(t *testing.T) {
+func TestChecksumCalc(t *testing.T) {
 	tests := []struct {
-		prog string
-		csum uint16
+		prog  string
+		csums []uintptr // in the order of csum fields in the program
 	}{
 		{
 			"syz_test$csum_ipv4(&(0x7f0000000000)={0x0, {0x42, 0x43, [0x44, 0x45], 0xa, 0xb, \"aabbccdd\"}})",
-			0xe143,
+			[]uintptr{0xe143},
+		},
+		{
+			"syz_test$csum_ipv4_tcp(&(0x7f0000000000)={{0x0, 0x12345678, 0x23456789}, {{0x42, 0x0}, \"aabbcc\"}})",
+			[]uintptr{0x0c85, 0x0e02},
+		},
+		{
+			"syz_test$csum_ipv6_tcp(&(0x7f0000000000)={{0x0, \"0102030405060708090a0b0c0d0e0f10\", \"1112131415161718191a1b1c1d1e1f20\"}, {{0x42, 0x0}, \"aabbcc\"}})",
+			[]uintptr{0x006c},
+		},
+		{
+			"syz_test$csum_crc(&(0x7f0000000000)={0x0, 0x0, {\"313233343536373839\"}})",
+			[]uintptr{0x6fc658ba, 0xbb3d},
+		},
+		{
+			"syz_test$csum_regions(&(0x7f0000000000)={0x31323334, 0x0, 0x35363738, 0xff, 0x39, 0x0})",
+			[]uintptr{0xcbf43926, 0x9c5f},
 		},
 	}
 	for i, test := range tests {
@@ -124,11 +193,17 @@ func TestChecksumIPv4Calc(t *testing.T) {
 		if err != nil {
 			t.Fatalf("failed to deserialize prog %v: %v", test.prog, err)
 		}
-		_, csumField := calcChecksumIPv4(p.Calls[0].Args[0].Res, i%32)
-		// Can't compare serialized progs, since checksums are zerod on serialization.
-		csum := csumField.Value(i % 32)
-		if csum != uintptr(test.csum) {
-			t.Fatalf("failed to calc ipv4 checksum, got %x, want %x, prog: '%v'", csum, test.csum, test.prog)
+		pid := i % 32
+		csumMap := calcChecksumsCall(p.Calls[0], pid)
+		var csums []uintptr
+		foreachArg(p.Calls[0], func(arg, _ *Arg, _ *[]*Arg) {
+			if _, ok := arg.Type.(*sys.CsumType); ok {
+				// Can't compare serialized progs, since checksums are zerod on serialization.
+				csums = append(csums, csumMap[arg].Val)
+			}
+		})
+		if !reflect.DeepEqual(csums, test.csums) {
+			t.Fatalf("bad checksums for prog #%v, got %x, want %x, prog: '%v'", i, csums, test.csums, test.prog)
 		}
 	}
 }
@@ -4,11 +4,15 @@
 package prog
 
 func (p *Prog) Clone() *Prog {
-	p1 := new(Prog)
+	p1 := &Prog{
+		Comments: cloneComments(p.Comments),
+		Target:   p.Target,
+	}
 	newargs := make(map[*Arg]*Arg)
 	for _, c := range p.Calls {
 		c1 := new(Call)
 		c1.Meta = c.Meta
+		c1.Comments = cloneComments(c.Comments)
 		c1.Ret = c.Ret.clone(c1, newargs)
 		for _, arg := range c.Args {
 			c1.Args = append(c1.Args, arg.clone(c1, newargs))
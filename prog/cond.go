@@ -0,0 +1,109 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package prog
+
+import (
+	"github.com/google/syzkaller/sys"
+)
+
+// Conditional struct fields (sys.CondType) are represented as ArgGroup
+// with either no inner args (the field is not present) or a single inner arg.
+// A field can be present only if the controlling field has the condition value.
+
+// condControl returns the controlling field for conditional field typ among struct fields.
+func condControl(fields []*Arg, typ *sys.CondType) *Arg {
+	for _, fld := range fields {
+		if fld.Type.FieldName() == typ.Field {
+			return fld
+		}
+	}
+	panic("conditional field references non existent field " + typ.Field)
+}
+
+// condMatches says if conditional field arg of struct fields can be present.
+func condMatches(fields []*Arg, arg *Arg) bool {
+	typ := arg.Type.(*sys.CondType)
+	ctrl := condControl(fields, typ)
+	return ctrl.Kind == ArgConst && ctrl.Val == typ.Value
+}
+
+// canSetCond says if conditional field arg of struct fields can be made present
+// by changing the controlling field.
+func canSetCond(fields []*Arg, arg *Arg) bool {
+	ctrl := condControl(fields, arg.Type.(*sys.CondType))
+	switch ctrl.Type.(type) {
+	case *sys.IntType, *sys.FlagsType:
+		return ctrl.Kind == ArgConst && ctrl.Type.Dir() != sys.DirOut
+	}
+	return false
+}
+
+// generateCond makes conditional field arg of struct fields present:
+// sets the controlling field to the condition value and generates the field.
+func (r *randGen) generateCond(s *state, fields []*Arg, arg *Arg) []*Call {
+	typ := arg.Type.(*sys.CondType)
+	condControl(fields, typ).Val = typ.Value
+	inner, calls := r.generateArg(s, typ.Type)
+	arg.Inner = []*Arg{inner}
+	return calls
+}
+
+// generateConds fills in conditional fields of a newly generated struct arg.
+// A field is generated if the controlling field already has the condition value,
+// otherwise one of the alternative fields is sometimes selected by changing the controlling field.
+func (r *randGen) generateConds(s *state, group *Arg) []*Call {
+	var calls []*Call
+	selected := make(map[string]bool)
+	for _, arg := range group.Inner {
+		typ, ok := arg.Type.(*sys.CondType)
+		if !ok {
+			continue
+		}
+		if !condMatches(group.Inner, arg) {
+			if selected[typ.Field] || !canSetCond(group.Inner, arg) || r.oneOf(3) {
+				continue
+			}
+		}
+		selected[typ.Field] = true
+		calls = append(calls, r.generateCond(s, group.Inner, arg)...)
+	}
+	return calls
+}
+
+// assignConds removes present conditional fields of call c
+// that don't match current values of their controlling fields
+// (e.g. after the controlling field was mutated).
+func (p *Prog) assignConds(c *Call) {
+	foreachArg(c, func(arg, _ *Arg, parent *[]*Arg) {
+		if _, ok := arg.Type.(*sys.CondType); !ok || len(arg.Inner) == 0 {
+			return
+		}
+		if !condMatches(*parent, arg) {
+			p.removeArg(c, arg.Inner[0])
+			arg.Inner = nil
+		}
+	})
+}
+
+// condsMatch says if all present conditional fields of call c match their controlling fields.
+func condsMatch(c *Call) bool {
+	ok := true
+	foreachArg(c, func(arg, _ *Arg, parent *[]*Arg) {
+		if _, isCond := arg.Type.(*sys.CondType); isCond && len(arg.Inner) != 0 && !condMatches(*parent, arg) {
+			ok = false
+		}
+	})
+	return ok
+}
+
+// condParent returns fields of the struct that contains arg in call c.
+func condParent(c *Call, arg0 *Arg) []*Arg {
+	var fields []*Arg
+	foreachArg(c, func(arg, _ *Arg, parent *[]*Arg) {
+		if arg == arg0 {
+			fields = *parent
+		}
+	})
+	return fields
+}
@@ -0,0 +1,90 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package prog
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/syzkaller/sys"
+)
+
+func TestCondGenerate(t *testing.T) {
+	rs, iters := initTest(t)
+	enabled := map[*sys.Call]bool{
+		sys.CallMap["syz_test$cond0"]: true,
+		sys.CallMap["syz_test$cond1"]: true,
+	}
+	ct := BuildChoiceTable(CalculatePriorities(nil), enabled)
+	present, absent := 0, 0
+	for i := 0; i < iters; i++ {
+		p := Generate(rs, 5, ct)
+		p.Mutate(rs, 10, ct, nil)
+		for _, c := range p.Calls {
+			foreachArg(c, func(arg, _ *Arg, parent *[]*Arg) {
+				if _, ok := arg.Type.(*sys.CondType); !ok {
+					return
+				}
+				if len(arg.Inner) == 0 {
+					absent++
+					return
+				}
+				present++
+				if !condMatches(*parent, arg) {
+					t.Fatalf("present conditional field does not match:\n%s", p.Serialize())
+				}
+			})
+		}
+		data := p.Serialize()
+		p1, err := Deserialize(data)
+		if err != nil {
+			t.Fatalf("failed to deserialize program: %v\n%s", err, data)
+		}
+		if data1 := p1.Serialize(); !bytes.Equal(data, data1) {
+			t.Fatalf("program changed after round-trip:\n%s\n\nvs:\n%s", data, data1)
+		}
+	}
+	if present == 0 || absent == 0 {
+		t.Fatalf("conditional fields are not generated: present %v, absent %v", present, absent)
+	}
+}
+
+func TestCondDeserialize(t *testing.T) {
+	tests := []struct {
+		prog string
+		ok   bool
+	}{
+		{"syz_test$cond0(&(0x7f0000000000)={0x1, 0x10, [0x42], []})", true},
+		{"syz_test$cond0(&(0x7f0000000000)={0x3, 0x8, [], []})", true},
+		{"syz_test$cond0(&(0x7f0000000000)={0x2, 0x10, [0x42], []})", false},
+		{"syz_test$cond0(&(0x7f0000000000)={0x1, 0x10, [0x42, 0x43], []})", false},
+		{"syz_test$cond1(&(0x7f0000000000)={0x5, [0x42], 0x43})", true},
+		{"syz_test$cond1(&(0x7f0000000000)={0x5, [], 0x43})", true},
+		{"syz_test$cond1(&(0x7f0000000000)={0x4, [0x42], 0x43})", false},
+	}
+	for i, test := range tests {
+		_, err := Deserialize([]byte(test.prog))
+		if test.ok && err != nil {
+			t.Fatalf("#%v: failed to deserialize program: %v", i, err)
+		}
+		if !test.ok && err == nil {
+			t.Fatalf("#%v: deserialized bad program", i)
+		}
+	}
+}
+
+func TestCondAssign(t *testing.T) {
+	p, err := Deserialize([]byte("syz_test$cond0(&(0x7f0000000000)={0x1, 0x10, [0x42], []})"))
+	if err != nil {
+		t.Fatalf("failed to deserialize program: %v", err)
+	}
+	c := p.Calls[0]
+	c.Args[0].Res.Inner[0].Val = 0x2
+	p.assignConds(c)
+	assignSizesCall(defaultTarget, c)
+	want := "syz_test$cond0(&(0x7f0000000000)={0x2, 0x8, [], []})\n"
+	if got := string(p.Serialize()); got != want {
+		t.Fatalf("bad program after assignConds:\n%s\nwant:\n%s", got, want)
+	}
+}
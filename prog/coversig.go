@@ -0,0 +1,81 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package prog
+
+import (
+	"sort"
+)
+
+const coverSigSize = 16
+
+// CoverSig is a compact signature of coverage of a program.
+// It is a MinHash sketch of covered PCs: the largest coverSigSize hashes of the PCs
+// in descending order (0 denotes an unused slot, so zero value is an empty signature).
+// Signatures allow to estimate how much coverage of two programs overlaps
+// without keeping the coverage itself around.
+type CoverSig [coverSigSize]uint32
+
+// MakeCoverSig returns signature of the coverage pcs.
+func MakeCoverSig(pcs []uint32) CoverSig {
+	var sig CoverSig
+	for _, pc := range pcs {
+		h := hashPC(pc)
+		if h <= sig[coverSigSize-1] {
+			continue
+		}
+		i := sort.Search(coverSigSize, func(i int) bool { return sig[i] <= h })
+		if sig[i] == h {
+			continue
+		}
+		copy(sig[i+1:], sig[i:coverSigSize-1])
+		sig[i] = h
+	}
+	return sig
+}
+
+// Empty returns true if the signature does not contain any coverage.
+func (sig CoverSig) Empty() bool {
+	return sig[0] == 0
+}
+
+// Similarity returns an estimation of Jaccard index of the coverage sets: a value in [0, 1],
+// 0 means that the coverage does not overlap, 1 means that the coverage is the same.
+func (sig CoverSig) Similarity(sig1 CoverSig) float64 {
+	// Take the largest hashes of the union and count how many of them are present in both.
+	total, common := 0, 0
+	for i, j := 0, 0; total < coverSigSize; total++ {
+		h, h1 := sig[i], sig1[j]
+		if h == 0 && h1 == 0 {
+			break
+		}
+		switch {
+		case h == h1:
+			common++
+			i++
+			j++
+		case h > h1:
+			i++
+		default:
+			j++
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(common) / float64(total)
+}
+
+func hashPC(pc uint32) uint32 {
+	// Finalizer of murmur3: spreads PCs that are close to each other.
+	h := pc
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	if h == 0 {
+		h = 1
+	}
+	return h
+}
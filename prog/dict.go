@@ -0,0 +1,168 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package prog
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Dictionary is a set of tokens (protocol keywords, filesystem magic values, device names, etc)
+// that buffer mutation splices into string and blob arguments.
+// Tokens can be added while the dictionary is used for mutation.
+type Dictionary struct {
+	mu     sync.RWMutex
+	tokens [][]byte
+	index  map[string]bool
+}
+
+// NewDictionary creates a dictionary with the given tokens.
+func NewDictionary(tokens [][]byte) *Dictionary {
+	dict := &Dictionary{index: make(map[string]bool)}
+	dict.Add(tokens...)
+	return dict
+}
+
+// Add adds tokens to the dictionary and returns the number of new tokens.
+// Empty tokens and tokens that are already present are ignored.
+func (dict *Dictionary) Add(tokens ...[]byte) int {
+	dict.mu.Lock()
+	defer dict.mu.Unlock()
+	added := 0
+	for _, tok := range tokens {
+		if len(tok) == 0 || dict.index[string(tok)] {
+			continue
+		}
+		dict.index[string(tok)] = true
+		dict.tokens = append(dict.tokens, append([]byte{}, tok...))
+		added++
+	}
+	return added
+}
+
+// Len returns the number of tokens in the dictionary.
+func (dict *Dictionary) Len() int {
+	if dict == nil {
+		return 0
+	}
+	dict.mu.RLock()
+	defer dict.mu.RUnlock()
+	return len(dict.tokens)
+}
+
+// Tokens returns all tokens in the order they were added.
+func (dict *Dictionary) Tokens() [][]byte {
+	dict.mu.RLock()
+	defer dict.mu.RUnlock()
+	return append([][]byte{}, dict.tokens...)
+}
+
+func (dict *Dictionary) choose(r *randGen) []byte {
+	dict.mu.RLock()
+	defer dict.mu.RUnlock()
+	if len(dict.tokens) == 0 {
+		return nil
+	}
+	// Tokens are never modified after addition, so it's safe to return them without copying.
+	return dict.tokens[r.Intn(len(dict.tokens))]
+}
+
+// ParseDictionary parses dictionary in the AFL format: one token per line
+// in the form of `"token"` or `name="token"`, tokens can contain \\, \" and \xNN escapes,
+// empty lines and lines starting with # are ignored.
+func ParseDictionary(data []byte) ([][]byte, error) {
+	var tokens [][]byte
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; s.Scan(); line++ {
+		ln := strings.TrimSpace(s.Text())
+		if ln == "" || ln[0] == '#' {
+			continue
+		}
+		quote := strings.IndexByte(ln, '"')
+		if quote == -1 || len(ln) < quote+2 || ln[len(ln)-1] != '"' {
+			return nil, fmt.Errorf("line #%v: token must be enclosed in quotes: %v", line, ln)
+		}
+		tok, err := unescapeToken(ln[quote+1 : len(ln)-1])
+		if err != nil {
+			return nil, fmt.Errorf("line #%v: %v", line, err)
+		}
+		tokens = append(tokens, tok)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func unescapeToken(s string) ([]byte, error) {
+	var tok []byte
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ch == '"' {
+			return nil, fmt.Errorf("unescaped quote in token")
+		}
+		if ch != '\\' {
+			tok = append(tok, ch)
+			continue
+		}
+		if i+1 >= len(s) {
+			return nil, fmt.Errorf("bad escape sequence at the end of token")
+		}
+		i++
+		switch s[i] {
+		case '\\', '"':
+			tok = append(tok, s[i])
+		case 'x':
+			if i+2 >= len(s) {
+				return nil, fmt.Errorf("bad \\x escape sequence")
+			}
+			v, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+			if err != nil {
+				return nil, fmt.Errorf("bad \\x escape sequence: %v", err)
+			}
+			tok = append(tok, byte(v))
+			i += 2
+		default:
+			return nil, fmt.Errorf("unknown escape sequence \\%c", s[i])
+		}
+	}
+	return tok, nil
+}
+
+// insertToken inserts or writes over a dictionary token at a plausible offset in data:
+// at the beginning, at the end, right after a separator or at an aligned offset.
+func insertToken(r *randGen, data []byte, tok []byte, maxLen int) []byte {
+	var offsets []int
+	offsets = append(offsets, 0, len(data))
+	for i, ch := range data {
+		switch ch {
+		case 0, ' ', '\t', '\n', '/', ':', '=', ',':
+			offsets = append(offsets, i+1)
+		}
+	}
+	for i := 4; i < len(data); i += 4 {
+		offsets = append(offsets, i)
+	}
+	pos := offsets[r.Intn(len(offsets))]
+	if r.bin() && len(data)+len(tok) <= maxLen {
+		// Insert.
+		data = append(data, tok...)
+		copy(data[pos+len(tok):], data[pos:])
+		copy(data[pos:], tok)
+		return data
+	}
+	// Overwrite, extending data if necessary.
+	if end := pos + len(tok); end > len(data) {
+		if end > maxLen {
+			return nil
+		}
+		data = append(data, make([]byte, end-len(data))...)
+	}
+	copy(data[pos:], tok)
+	return data
+}
@@ -0,0 +1,313 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package prog
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/google/syzkaller/sys"
+)
+
+type DiffKind int
+
+const (
+	DiffCallRemoved DiffKind = iota // call is present only in the first program
+	DiffCallAdded                   // call is present only in the second program
+	DiffArgChanged                  // arg of a call present in both programs differs
+)
+
+// Difference describes a single difference between two programs.
+type Difference struct {
+	Kind  DiffKind
+	Call1 int    // index of the call in the first program, -1 for DiffCallAdded
+	Call2 int    // index of the call in the second program, -1 for DiffCallRemoved
+	Name  string // syscall name
+	Path  string // path to the changed arg for DiffArgChanged, e.g. "a1.f0[2]"
+	Old   string // old arg value for DiffArgChanged, "" if the arg was added
+	New   string // new arg value for DiffArgChanged, "" if the arg was removed
+}
+
+func (d Difference) String() string {
+	switch d.Kind {
+	case DiffCallRemoved:
+		return fmt.Sprintf("-#%v %v", d.Call1, d.Name)
+	case DiffCallAdded:
+		return fmt.Sprintf("+#%v %v", d.Call2, d.Name)
+	case DiffArgChanged:
+		return fmt.Sprintf("~#%v %v %v: %v -> %v", d.Call1, d.Name, d.Path, diffValue(d.Old), diffValue(d.New))
+	default:
+		panic("unknown diff kind")
+	}
+}
+
+func diffValue(v string) string {
+	if v == "" {
+		return "none"
+	}
+	return v
+}
+
+// Diff returns differences between programs p1 and p2 in the order of calls.
+// Calls are matched by syscall name using longest common subsequence,
+// args of matched calls are compared recursively. Result args are equal
+// if they refer to results of matched calls (or to matched args of such calls).
+func Diff(p1, p2 *Prog) []Difference {
+	match1, match2 := matchCalls(p1, p2)
+	d := &differ{
+		defs1:  argDefs(p1),
+		defs2:  argDefs(p2),
+		match2: match2,
+	}
+	var diffs []Difference
+	i2 := 0
+	for i1, c1 := range p1.Calls {
+		if match1[i1] == -1 {
+			diffs = append(diffs, Difference{Kind: DiffCallRemoved, Call1: i1, Call2: -1, Name: c1.Meta.Name})
+			continue
+		}
+		for ; i2 < match1[i1]; i2++ {
+			diffs = append(diffs, Difference{Kind: DiffCallAdded, Call1: -1, Call2: i2, Name: p2.Calls[i2].Meta.Name})
+		}
+		c2 := p2.Calls[i2]
+		d.diffs = nil
+		for i := range c1.Args {
+			if sys.IsPad(c1.Meta.Args[i]) {
+				continue
+			}
+			d.diffArg(c1.Args[i], c2.Args[i], fmt.Sprintf("a%v", i))
+		}
+		for _, diff := range d.diffs {
+			diff.Call1, diff.Call2, diff.Name = i1, i2, c1.Meta.Name
+			diffs = append(diffs, diff)
+		}
+		i2++
+	}
+	for ; i2 < len(p2.Calls); i2++ {
+		diffs = append(diffs, Difference{Kind: DiffCallAdded, Call1: -1, Call2: i2, Name: p2.Calls[i2].Meta.Name})
+	}
+	return diffs
+}
+
+// matchCalls matches calls of p1 and p2 with the same names using longest common subsequence.
+// match1[i] is index of the call in p2 matched with i-th call in p1 or -1, match2 is the reverse.
+func matchCalls(p1, p2 *Prog) ([]int, []int) {
+	n1, n2 := len(p1.Calls), len(p2.Calls)
+	lcs := make([][]int, n1+1)
+	for i := range lcs {
+		lcs[i] = make([]int, n2+1)
+	}
+	for i := n1 - 1; i >= 0; i-- {
+		for j := n2 - 1; j >= 0; j-- {
+			if p1.Calls[i].Meta == p2.Calls[j].Meta {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	match1, match2 := make([]int, n1), make([]int, n2)
+	for i := range match1 {
+		match1[i] = -1
+	}
+	for j := range match2 {
+		match2[j] = -1
+	}
+	for i, j := 0, 0; i < n1 && j < n2; {
+		if p1.Calls[i].Meta == p2.Calls[j].Meta && lcs[i][j] == lcs[i+1][j+1]+1 {
+			match1[i], match2[j] = j, i
+			i++
+			j++
+		} else if lcs[i+1][j] >= lcs[i][j+1] {
+			i++
+		} else {
+			j++
+		}
+	}
+	return match1, match2
+}
+
+// argDef identifies an arg that can be referenced by result args:
+// the call it belongs to and its path within the call.
+type argDef struct {
+	call int
+	path string
+}
+
+func argDefs(p *Prog) map[*Arg]argDef {
+	defs := make(map[*Arg]argDef)
+	for i, c := range p.Calls {
+		defs[c.Ret] = argDef{i, "ret"}
+		for j, arg := range c.Args {
+			foreachSubargPath(arg, fmt.Sprintf("a%v", j), func(arg *Arg, path string) {
+				if len(arg.Uses) != 0 {
+					defs[arg] = argDef{i, path}
+				}
+			})
+		}
+	}
+	return defs
+}
+
+func foreachSubargPath(arg *Arg, path string, f func(arg *Arg, path string)) {
+	if arg == nil {
+		return
+	}
+	f(arg, path)
+	switch arg.Kind {
+	case ArgPointer:
+		foreachSubargPath(arg.Res, path, f)
+	case ArgGroup:
+		for i, arg1 := range arg.Inner {
+			foreachSubargPath(arg1, subargPath(arg, path, i), f)
+		}
+	case ArgUnion:
+		foreachSubargPath(arg.Option, path+"@"+arg.OptionType.FieldName(), f)
+	}
+}
+
+func subargPath(arg *Arg, path string, i int) string {
+	if t, ok := arg.Type.(*sys.StructType); ok {
+		return path + "." + t.Fields[i].FieldName()
+	}
+	return fmt.Sprintf("%v[%v]", path, i)
+}
+
+type differ struct {
+	defs1  map[*Arg]argDef
+	defs2  map[*Arg]argDef
+	match2 []int
+	diffs  []Difference
+}
+
+func (d *differ) diffArg(a1, a2 *Arg, path string) {
+	if a1 == nil && a2 == nil {
+		return
+	}
+	if a1 == nil || a2 == nil || a1.Kind != a2.Kind || a1.Type != a2.Type {
+		d.changed(a1, a2, path, false)
+		return
+	}
+	switch a1.Kind {
+	case ArgPointer:
+		if a1.AddrPage != a2.AddrPage || a1.AddrOffset != a2.AddrOffset || a1.AddrPagesNum != a2.AddrPagesNum {
+			d.diffs = append(d.diffs, Difference{Kind: DiffArgChanged, Path: path,
+				Old: "&" + serializeAddr(a1, true), New: "&" + serializeAddr(a2, true)})
+		}
+		d.diffArg(a1.Res, a2.Res, path)
+	case ArgGroup:
+		n := len(a1.Inner)
+		if n > len(a2.Inner) {
+			n = len(a2.Inner)
+		}
+		for i := 0; i < len(a1.Inner) || i < len(a2.Inner); i++ {
+			var in1, in2 *Arg
+			if i < len(a1.Inner) {
+				in1 = a1.Inner[i]
+			}
+			if i < len(a2.Inner) {
+				in2 = a2.Inner[i]
+			}
+			if (in1 != nil && sys.IsPad(in1.Type)) || (in2 != nil && sys.IsPad(in2.Type)) {
+				continue
+			}
+			if i >= n {
+				// Element was added to or removed from an array.
+				d.changed(in1, in2, subargPath(a1, path, i), true)
+				continue
+			}
+			d.diffArg(in1, in2, subargPath(a1, path, i))
+		}
+	case ArgUnion:
+		if a1.OptionType != a2.OptionType {
+			d.changed(a1, a2, path, false)
+			return
+		}
+		d.diffArg(a1.Option, a2.Option, path+"@"+a1.OptionType.FieldName())
+	default:
+		if v1, v2 := d.format(a1, d.defs1, false), d.format(a2, d.defs2, true); v1 != v2 {
+			d.diffs = append(d.diffs, Difference{Kind: DiffArgChanged, Path: path, Old: v1, New: v2})
+		}
+	}
+}
+
+func (d *differ) changed(a1, a2 *Arg, path string, missing bool) {
+	diff := Difference{Kind: DiffArgChanged, Path: path}
+	if a1 != nil || !missing {
+		diff.Old = d.format(a1, d.defs1, false)
+	}
+	if a2 != nil || !missing {
+		diff.New = d.format(a2, d.defs2, true)
+	}
+	d.diffs = append(d.diffs, diff)
+}
+
+// format returns a textual representation of the arg.
+// Results are formatted as references to call indexes in the first program
+// (if the call is not matched, it's formatted as a reference to an unmatched call in the second program).
+func (d *differ) format(arg *Arg, defs map[*Arg]argDef, second bool) string {
+	buf := new(bytes.Buffer)
+	var rec func(arg *Arg)
+	rec = func(arg *Arg) {
+		if arg == nil {
+			fmt.Fprintf(buf, "nil")
+			return
+		}
+		switch arg.Kind {
+		case ArgConst:
+			fmt.Fprintf(buf, "0x%x", arg.Val)
+		case ArgResult:
+			def := defs[arg.Res]
+			switch {
+			case !second:
+				fmt.Fprintf(buf, "<#%v.%v>", def.call, def.path)
+			case d.match2[def.call] != -1:
+				fmt.Fprintf(buf, "<#%v.%v>", d.match2[def.call], def.path)
+			default:
+				fmt.Fprintf(buf, "<+#%v.%v>", def.call, def.path)
+			}
+			if arg.OpDiv != 0 {
+				fmt.Fprintf(buf, "/%v", arg.OpDiv)
+			}
+			if arg.OpAdd != 0 {
+				fmt.Fprintf(buf, "+%v", arg.OpAdd)
+			}
+		case ArgPointer:
+			fmt.Fprintf(buf, "&%v=", serializeAddr(arg, true))
+			rec(arg.Res)
+		case ArgPageSize:
+			fmt.Fprintf(buf, "%v", serializeAddr(arg, false))
+		case ArgData:
+			fmt.Fprintf(buf, "\"%v\"", hex.EncodeToString(arg.Data))
+		case ArgGroup:
+			delims := []string{"[", "]"}
+			if _, ok := arg.Type.(*sys.StructType); ok {
+				delims = []string{"{", "}"}
+			}
+			fmt.Fprintf(buf, "%v", delims[0])
+			first := true
+			for _, arg1 := range arg.Inner {
+				if arg1 != nil && sys.IsPad(arg1.Type) {
+					continue
+				}
+				if !first {
+					fmt.Fprintf(buf, ", ")
+				}
+				first = false
+				rec(arg1)
+			}
+			fmt.Fprintf(buf, "%v", delims[1])
+		case ArgUnion:
+			fmt.Fprintf(buf, "@%v=", arg.OptionType.FieldName())
+			rec(arg.Option)
+		default:
+			panic("unknown arg kind")
+		}
+	}
+	rec(arg)
+	return buf.String()
+}
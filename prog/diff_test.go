@@ -0,0 +1,80 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package prog
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		p1, p2 string
+		diffs  []string
+	}{
+		{
+			"r0 = open(&(0x7f0000000000)=\"2e2f66696c653000\", 0x42, 0x0)\nclose(r0)\n",
+			"r0 = open(&(0x7f0000000000)=\"2e2f66696c653000\", 0x42, 0x0)\nclose(r0)\n",
+			nil,
+		},
+		{
+			"r0 = open(&(0x7f0000000000)=\"2e2f66696c653000\", 0x42, 0x0)\nclose(r0)\n",
+			"r0 = open(&(0x7f0000001000)=\"2e2f66696c653100\", 0x42, 0x1)\nclose(0xffffffffffffffff)\n",
+			[]string{
+				"~#0 open a0: &(0x7f0000000000) -> &(0x7f0000001000)",
+				"~#0 open a0: \"2e2f66696c653000\" -> \"2e2f66696c653100\"",
+				"~#0 open a2: 0x0 -> 0x1",
+				"~#1 close a0: <#0.ret> -> 0xffffffffffffffff",
+			},
+		},
+		{
+			"getpid()\nr0 = open(&(0x7f0000000000)=\"2e2f66696c653000\", 0x42, 0x0)\nclose(r0)\n",
+			"r0 = open(&(0x7f0000000000)=\"2e2f66696c653000\", 0x42, 0x0)\nsync()\nclose(r0)\n",
+			[]string{
+				"-#0 getpid",
+				"+#1 sync",
+			},
+		},
+		{
+			"syz_test$array0(&(0x7f0000000000)={0x1, [@f0=0x2, @f1=0x3], 0x4})\n",
+			"syz_test$array0(&(0x7f0000000000)={0x1, [@f0=0x2, @f0=0x3, @f1=0x5], 0x4})\n",
+			[]string{
+				"~#0 syz_test$array0 a0.f1[1]: @f1=0x3 -> @f0=0x3",
+				"~#0 syz_test$array0 a0.f1[2]: none -> @f1=0x5",
+			},
+		},
+	}
+	for i, test := range tests {
+		p1, err := Deserialize([]byte(test.p1))
+		if err != nil {
+			t.Fatalf("#%v: failed to deserialize program: %v", i, err)
+		}
+		p2, err := Deserialize([]byte(test.p2))
+		if err != nil {
+			t.Fatalf("#%v: failed to deserialize program: %v", i, err)
+		}
+		var diffs []string
+		for _, d := range Diff(p1, p2) {
+			diffs = append(diffs, d.String())
+		}
+		if strings.Join(diffs, "\n") != strings.Join(test.diffs, "\n") {
+			t.Fatalf("#%v: bad diff:\n%v\nwant:\n%v", i, strings.Join(diffs, "\n"), strings.Join(test.diffs, "\n"))
+		}
+	}
+}
+
+func TestDiffRandom(t *testing.T) {
+	rs, iters := initTest(t)
+	for i := 0; i < iters; i++ {
+		p := Generate(rs, 10, nil)
+		if diffs := Diff(p, p.Clone()); len(diffs) != 0 {
+			t.Fatalf("clone differs from the original: %v\n%s", diffs, p.Serialize())
+		}
+		p1 := p.Clone()
+		p1.Mutate(rs, 10, nil, nil)
+		for _, d := range Diff(p, p1) {
+			_ = d.String()
+		}
+	}
+}
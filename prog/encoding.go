@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"io"
 	"strconv"
+	"strings"
 
 	"github.com/google/syzkaller/sys"
 )
@@ -33,9 +34,15 @@ func (p *Prog) Serialize() []byte {
 		}
 	}
 	buf := new(bytes.Buffer)
+	if len(p.Comments) != 0 {
+		// Program comments are separated from comments of the first call by an empty line.
+		serializeComments(buf, p.Comments)
+		fmt.Fprintf(buf, "\n")
+	}
 	vars := make(map[*Arg]int)
 	varSeq := 0
 	for _, c := range p.Calls {
+		serializeComments(buf, c.Comments)
 		if len(c.Ret.Uses) != 0 {
 			fmt.Fprintf(buf, "r%v = ", varSeq)
 			vars[c.Ret] = varSeq
@@ -56,6 +63,14 @@ func (p *Prog) Serialize() []byte {
 	return buf.Bytes()
 }
 
+func serializeComments(buf io.Writer, comments []string) {
+	for _, comment := range comments {
+		for _, ln := range strings.Split(comment, "\n") {
+			fmt.Fprintf(buf, "# %v\n", ln)
+		}
+	}
+}
+
 func (a *Arg) serialize(buf io.Writer, vars map[*Arg]int, varSeq *int) {
 	if a == nil {
 		fmt.Fprintf(buf, "nil")
@@ -93,7 +108,7 @@ func (a *Arg) serialize(buf io.Writer, vars map[*Arg]int, varSeq *int) {
 		switch a.Type.(type) {
 		case *sys.StructType:
 			delims = []byte{'{', '}'}
-		case *sys.ArrayType:
+		case *sys.ArrayType, *sys.CondType:
 			delims = []byte{'[', ']'}
 		default:
 			panic("unknown group type")
@@ -117,13 +132,29 @@ func (a *Arg) serialize(buf io.Writer, vars map[*Arg]int, varSeq *int) {
 	}
 }
 
+// Deserialize parses a program in either the text or the binary format.
 func Deserialize(data []byte) (prog *Prog, err error) {
+	if IsBinary(data) {
+		return deserializeBinary(data)
+	}
 	prog = new(Prog)
 	p := &parser{r: bufio.NewScanner(bytes.NewReader(data))}
 	p.r.Buffer(nil, maxLineLen)
 	vars := make(map[string]*Arg)
+	// Comment lines are attached to the next call. Comments before an empty line
+	// at the beginning of the program and comments after the last call
+	// are attached to the program.
+	var comments []string
 	for p.Scan() {
-		if p.EOF() || p.Char() == '#' {
+		if p.EOF() {
+			if len(prog.Calls) == 0 {
+				prog.Comments = append(prog.Comments, comments...)
+				comments = nil
+			}
+			continue
+		}
+		if p.Char() == '#' {
+			comments = append(comments, parseComment(p.s[p.i+1:]))
 			continue
 		}
 		name := p.Ident()
@@ -139,9 +170,11 @@ func Deserialize(data []byte) (prog *Prog, err error) {
 			return nil, fmt.Errorf("unknown syscall %v", name)
 		}
 		c := &Call{
-			Meta: meta,
-			Ret:  returnArg(meta.Ret),
+			Meta:     meta,
+			Ret:      returnArg(meta.Ret),
+			Comments: comments,
 		}
+		comments = nil
 		prog.Calls = append(prog.Calls, c)
 		p.Parse('(')
 		for i := 0; p.Char() != ')'; i++ {
@@ -175,6 +208,7 @@ func Deserialize(data []byte) (prog *Prog, err error) {
 	if err := p.Err(); err != nil {
 		return nil, err
 	}
+	prog.Comments = append(prog.Comments, comments...)
 	// This validation is done even in non-debug mode because deserialization
 	// procedure does not catch all bugs (e.g. mismatched types).
 	// And we can receive bad programs from corpus and hub.
@@ -295,14 +329,23 @@ func parseArg(typ sys.Type, p *parser, vars map[string]*Arg) (*Arg, error) {
 		}
 		arg = groupArg(typ, inner)
 	case '[':
-		t1, ok := typ.(*sys.ArrayType)
-		if !ok {
+		var elemType sys.Type
+		switch t1 := typ.(type) {
+		case *sys.ArrayType:
+			elemType = t1.Type
+		case *sys.CondType:
+			// Conditional field is serialized as an array of 0 or 1 elements.
+			elemType = t1.Type
+		default:
 			return nil, fmt.Errorf("'[' arg is not an array: %#v", typ)
 		}
 		p.Parse('[')
 		var inner []*Arg
 		for i := 0; p.Char() != ']'; i++ {
-			arg, err := parseArg(t1.Type, p, vars)
+			if _, ok := typ.(*sys.CondType); ok && i != 0 {
+				return nil, fmt.Errorf("conditional field %v has more than 1 element", typ.FieldName())
+			}
+			arg, err := parseArg(elemType, p, vars)
 			if err != nil {
 				return nil, err
 			}
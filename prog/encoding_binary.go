@@ -0,0 +1,416 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package prog
+
+// Compact binary program encoding. It is used to store large corpuses:
+// it is considerably smaller than the text format and faster to decode.
+// Deserialize transparently accepts both formats.
+//
+// The encoding starts with binaryMagic followed by the format version,
+// then goes the number of calls and the calls themselves. A call is encoded as
+// its name, a flag that says if the return value is referenced and the arguments.
+// An argument starts with a tag byte that contains the arg kind and
+// a flag that says if the arg is referenced as a result, followed by kind-specific data.
+// All integers are encoded as varints, strings and data as length + bytes.
+// Syscalls and union options are referenced by name rather than by index,
+// so that the encoding is stable across description changes as the text format is.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/google/syzkaller/sys"
+)
+
+const (
+	binaryMagic   = "\x00syz"
+	binaryVersion = 1
+
+	binaryTagNil  = 0
+	binaryTagUsed = 0x80 // the arg is referenced by other args
+)
+
+// IsBinary says if data contains a program in the binary format.
+func IsBinary(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(binaryMagic))
+}
+
+// SerializeBinary serializes the program in the compact binary format.
+func (p *Prog) SerializeBinary() []byte {
+	if debug {
+		if err := p.validate(); err != nil {
+			panic("serializing invalid program")
+		}
+	}
+	w := &binaryWriter{vars: make(map[*Arg]uint64)}
+	w.buf = append(w.buf, binaryMagic...)
+	w.uint(binaryVersion)
+	w.uint(uint64(len(p.Calls)))
+	for _, c := range p.Calls {
+		w.string(c.Meta.Name)
+		if len(c.Ret.Uses) != 0 {
+			w.uint(1)
+			w.def(c.Ret)
+		} else {
+			w.uint(0)
+		}
+		w.uint(uint64(len(c.Args)))
+		for _, a := range c.Args {
+			w.arg(a)
+		}
+	}
+	return w.buf
+}
+
+// ConvertToBinary converts a program in any format to the binary format.
+func ConvertToBinary(data []byte) ([]byte, error) {
+	if IsBinary(data) {
+		return data, nil
+	}
+	p, err := Deserialize(data)
+	if err != nil {
+		return nil, err
+	}
+	return p.SerializeBinary(), nil
+}
+
+// ConvertToText converts a program in any format to the text format.
+func ConvertToText(data []byte) ([]byte, error) {
+	if !IsBinary(data) {
+		return data, nil
+	}
+	p, err := Deserialize(data)
+	if err != nil {
+		return nil, err
+	}
+	return p.Serialize(), nil
+}
+
+type binaryWriter struct {
+	buf    []byte
+	vars   map[*Arg]uint64
+	varSeq uint64
+	tmp    [binary.MaxVarintLen64]byte
+}
+
+func (w *binaryWriter) uint(v uint64) {
+	n := binary.PutUvarint(w.tmp[:], v)
+	w.buf = append(w.buf, w.tmp[:n]...)
+}
+
+func (w *binaryWriter) int(v int64) {
+	n := binary.PutVarint(w.tmp[:], v)
+	w.buf = append(w.buf, w.tmp[:n]...)
+}
+
+func (w *binaryWriter) string(s string) {
+	w.uint(uint64(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+func (w *binaryWriter) def(a *Arg) {
+	w.vars[a] = w.varSeq
+	w.varSeq++
+}
+
+func (w *binaryWriter) arg(a *Arg) {
+	if a == nil {
+		w.buf = append(w.buf, binaryTagNil)
+		return
+	}
+	tag := byte(a.Kind) + 1
+	if len(a.Uses) != 0 {
+		tag |= binaryTagUsed
+		w.def(a)
+	}
+	w.buf = append(w.buf, tag)
+	switch a.Kind {
+	case ArgConst:
+		w.uint(uint64(a.Val))
+	case ArgResult:
+		id, ok := w.vars[a.Res]
+		if !ok {
+			panic("no result")
+		}
+		w.uint(id)
+		w.uint(uint64(a.OpDiv))
+		w.uint(uint64(a.OpAdd))
+	case ArgPointer:
+		w.uint(uint64(a.AddrPage))
+		w.int(int64(a.AddrOffset))
+		w.uint(uint64(a.AddrPagesNum))
+		w.arg(a.Res)
+	case ArgPageSize:
+		w.uint(uint64(a.AddrPage))
+		w.int(int64(a.AddrOffset))
+	case ArgData:
+		w.uint(uint64(len(a.Data)))
+		w.buf = append(w.buf, a.Data...)
+	case ArgGroup:
+		n := 0
+		for _, a1 := range a.Inner {
+			if a1 == nil || !sys.IsPad(a1.Type) {
+				n++
+			}
+		}
+		w.uint(uint64(n))
+		for _, a1 := range a.Inner {
+			if a1 != nil && sys.IsPad(a1.Type) {
+				continue
+			}
+			w.arg(a1)
+		}
+	case ArgUnion:
+		w.string(a.OptionType.FieldName())
+		w.arg(a.Option)
+	default:
+		panic("unknown arg kind")
+	}
+}
+
+func deserializeBinary(data []byte) (*Prog, error) {
+	r := &binaryReader{data: data[len(binaryMagic):]}
+	if v := r.uint(); r.err == nil && v != binaryVersion {
+		return nil, fmt.Errorf("unsupported binary program version %v", v)
+	}
+	prog := new(Prog)
+	ncalls := r.count()
+	for i := uint64(0); i < ncalls && r.err == nil; i++ {
+		name := r.string()
+		if r.err != nil {
+			break
+		}
+		meta := sys.CallMap[name]
+		if meta == nil {
+			return nil, fmt.Errorf("unknown syscall %v", name)
+		}
+		c := &Call{
+			Meta: meta,
+			Ret:  returnArg(meta.Ret),
+		}
+		prog.Calls = append(prog.Calls, c)
+		if r.uint() != 0 {
+			r.vars = append(r.vars, c.Ret)
+		}
+		if nargs := r.count(); r.err == nil && nargs != uint64(len(meta.Args)) {
+			return nil, fmt.Errorf("wrong call arg count: %v, want %v", nargs, len(meta.Args))
+		}
+		for _, typ := range meta.Args {
+			if sys.IsPad(typ) {
+				return nil, fmt.Errorf("padding in syscall %v arguments", name)
+			}
+			c.Args = append(c.Args, r.arg(typ))
+		}
+	}
+	if r.err == nil && len(r.data) != 0 {
+		r.err = fmt.Errorf("%v bytes of trailing data", len(r.data))
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if err := prog.validate(); err != nil {
+		return nil, err
+	}
+	return prog, nil
+}
+
+type binaryReader struct {
+	data []byte
+	vars []*Arg
+	err  error
+}
+
+func (r *binaryReader) failf(msg string, args ...interface{}) {
+	if r.err == nil {
+		r.err = fmt.Errorf(msg, args...)
+	}
+}
+
+func (r *binaryReader) uint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.failf("bad varint")
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *binaryReader) int() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.data)
+	if n <= 0 {
+		r.failf("bad varint")
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+// count reads number of subsequent elements, each of them occupies at least 1 byte.
+func (r *binaryReader) count() uint64 {
+	n := r.uint()
+	if n > uint64(len(r.data)) {
+		r.failf("bad element count %v", n)
+		return 0
+	}
+	return n
+}
+
+func (r *binaryReader) bytes() []byte {
+	n := r.uint()
+	if r.err != nil {
+		return nil
+	}
+	if n > uint64(len(r.data)) {
+		r.failf("bad data length %v", n)
+		return nil
+	}
+	data := r.data[:n:n]
+	r.data = r.data[n:]
+	return data
+}
+
+func (r *binaryReader) string() string {
+	return string(r.bytes())
+}
+
+func (r *binaryReader) arg(typ sys.Type) *Arg {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.data) == 0 {
+		r.failf("unexpected end of data")
+		return nil
+	}
+	tag := r.data[0]
+	r.data = r.data[1:]
+	if tag == binaryTagNil {
+		return nil
+	}
+	if typ == nil {
+		r.failf("non-nil arg without type")
+		return nil
+	}
+	var arg *Arg
+	switch ArgKind(tag&^binaryTagUsed - 1) {
+	case ArgConst:
+		arg = constArg(typ, uintptr(r.uint()))
+	case ArgResult:
+		id := r.uint()
+		if r.err == nil && id >= uint64(len(r.vars)) {
+			r.failf("result references unknown variable %v", id)
+			return nil
+		}
+		opDiv, opAdd := r.uint(), r.uint()
+		if r.err != nil {
+			return nil
+		}
+		arg = resultArg(typ, r.vars[id])
+		arg.OpDiv = uintptr(opDiv)
+		arg.OpAdd = uintptr(opAdd)
+	case ArgPointer:
+		var typ1 sys.Type
+		switch t1 := typ.(type) {
+		case *sys.PtrType:
+			typ1 = t1.Type
+		case *sys.VmaType:
+		default:
+			r.failf("pointer arg is not a pointer: %#v", typ)
+			return nil
+		}
+		page, off, npages := r.uint(), r.int(), r.uint()
+		arg = pointerArg(typ, uintptr(page), int(off), uintptr(npages), nil)
+		if tag&binaryTagUsed != 0 {
+			// Referenced args are numbered in pre-order.
+			r.vars = append(r.vars, arg)
+			tag &^= binaryTagUsed
+		}
+		arg.Res = r.arg(typ1)
+	case ArgPageSize:
+		page, off := r.uint(), r.int()
+		arg = pageSizeArg(typ, uintptr(page), int(off))
+	case ArgData:
+		arg = dataArg(typ, r.bytes())
+	case ArgGroup:
+		arg = groupArg(typ, nil)
+		if tag&binaryTagUsed != 0 {
+			r.vars = append(r.vars, arg)
+			tag &^= binaryTagUsed
+		}
+		n := r.count()
+		switch t1 := typ.(type) {
+		case *sys.StructType:
+			nfields := uint64(0)
+			for _, fld := range t1.Fields {
+				if !sys.IsPad(fld) {
+					nfields++
+				}
+			}
+			if n != nfields {
+				r.failf("wrong struct arg count: %v, want %v", n, nfields)
+				return nil
+			}
+			for _, fld := range t1.Fields {
+				if sys.IsPad(fld) {
+					arg.Inner = append(arg.Inner, constArg(fld, 0))
+				} else {
+					arg.Inner = append(arg.Inner, r.arg(fld))
+				}
+			}
+		case *sys.ArrayType:
+			for i := uint64(0); i < n && r.err == nil; i++ {
+				arg.Inner = append(arg.Inner, r.arg(t1.Type))
+			}
+		case *sys.CondType:
+			if n > 1 {
+				r.failf("conditional field %v has %v elements", typ.FieldName(), n)
+				return nil
+			}
+			for i := uint64(0); i < n && r.err == nil; i++ {
+				arg.Inner = append(arg.Inner, r.arg(t1.Type))
+			}
+		default:
+			r.failf("group arg is not a struct or array: %#v", typ)
+			return nil
+		}
+	case ArgUnion:
+		t1, ok := typ.(*sys.UnionType)
+		if !ok {
+			r.failf("union arg is not a union: %#v", typ)
+			return nil
+		}
+		name := r.string()
+		var optType sys.Type
+		for _, t2 := range t1.Options {
+			if name == t2.FieldName() {
+				optType = t2
+				break
+			}
+		}
+		if r.err == nil && optType == nil {
+			r.failf("union arg %v has unknown option: %v", typ.Name(), name)
+			return nil
+		}
+		arg = unionArg(typ, nil, optType)
+		if tag&binaryTagUsed != 0 {
+			r.vars = append(r.vars, arg)
+			tag &^= binaryTagUsed
+		}
+		arg.Option = r.arg(optType)
+	default:
+		r.failf("bad arg tag 0x%x", tag)
+		return nil
+	}
+	if tag&binaryTagUsed != 0 {
+		r.vars = append(r.vars, arg)
+	}
+	return arg
+}
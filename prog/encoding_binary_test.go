@@ -0,0 +1,85 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package prog
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSerializeBinaryRandom(t *testing.T) {
+	rs, iters := initTest(t)
+	textSize, binSize := 0, 0
+	for i := 0; i < iters; i++ {
+		p := Generate(rs, 10, nil)
+		p.Mutate(rs, 10, nil, nil)
+		text := p.Serialize()
+		bin := p.SerializeBinary()
+		if !IsBinary(bin) || IsBinary(text) {
+			t.Fatalf("bad format detection")
+		}
+		p1, err := Deserialize(bin)
+		if err != nil {
+			t.Fatalf("failed to deserialize binary program: %v\n%s", err, text)
+		}
+		if text1 := p1.Serialize(); !bytes.Equal(text, text1) {
+			t.Fatalf("binary program changed after round-trip:\n%s\n\nvs:\n%s", text, text1)
+		}
+		if bin1 := p1.SerializeBinary(); !bytes.Equal(bin, bin1) {
+			t.Fatalf("binary encoding is not stable for program:\n%s", text)
+		}
+		textSize += len(text)
+		binSize += len(bin)
+	}
+	if binSize >= textSize {
+		t.Fatalf("binary encoding is not smaller than text: %v vs %v", binSize, textSize)
+	}
+}
+
+func TestSerializeBinaryConvert(t *testing.T) {
+	text := []byte("r0 = open(&(0x7f0000000000)=\"2e2f66696c653000\", 0x42, 0x0)\n" +
+		"mmap(&(0x7f0000001000/0x1000)=nil, (0x1000), 0x3, 0x32, r0, 0x0)\n" +
+		"close(r0)\n")
+	bin, err := ConvertToBinary(text)
+	if err != nil {
+		t.Fatalf("failed to convert to binary: %v", err)
+	}
+	if !IsBinary(bin) {
+		t.Fatalf("converted program is not binary")
+	}
+	if bin1, err := ConvertToBinary(bin); err != nil || !bytes.Equal(bin, bin1) {
+		t.Fatalf("conversion of binary program to binary changed it: %v", err)
+	}
+	text1, err := ConvertToText(bin)
+	if err != nil {
+		t.Fatalf("failed to convert to text: %v", err)
+	}
+	if !bytes.Equal(text, text1) {
+		t.Fatalf("program changed after conversion:\n%s\n\nvs:\n%s", text, text1)
+	}
+	if _, err := ConvertToBinary([]byte("foobar(0x1)\n")); err == nil {
+		t.Fatalf("converted bad program")
+	}
+}
+
+func TestDeserializeBinaryCorrupted(t *testing.T) {
+	rs, iters := initTest(t)
+	r := newRand(rs, defaultTarget)
+	for i := 0; i < iters; i++ {
+		bin := Generate(rs, 10, nil).SerializeBinary()
+		// Truncated programs must be rejected.
+		if _, err := Deserialize(bin[:len(binaryMagic)+r.Intn(len(bin)-len(binaryMagic))]); err == nil {
+			t.Fatalf("deserialized truncated program")
+		}
+		// Corrupted programs must not crash the decoder.
+		for j := 0; j < 10; j++ {
+			bin1 := append([]byte{}, bin...)
+			bin1[len(binaryMagic)+r.Intn(len(bin)-len(binaryMagic))] = byte(r.Intn(256))
+			Deserialize(bin1)
+		}
+	}
+	if _, err := Deserialize([]byte(binaryMagic + "\x02")); err == nil {
+		t.Fatalf("deserialized program of unknown version")
+	}
+}
@@ -0,0 +1,351 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package prog
+
+// JSON program encoding intended for external analysis tooling.
+// The format is stable and is described by the jsonProg, jsonCall and jsonArg types:
+//
+//	{"comments": ["origin: hub"], "calls": [
+//	  {"name": "open", "ret": "r0", "args": [
+//	    {"kind": "pointer", "type": "ptr", "field": "file", "dir": "in",
+//	      "page": 0, "offset": 0, "pages": 0,
+//	      "res": {"kind": "data", "type": "filename", "dir": "in", "data": "2e2f66696c653000"}},
+//	    {"kind": "const", "type": "flags", "field": "flags", "dir": "in", "val": 66},
+//	    {"kind": "const", "type": "flags", "field": "mode", "dir": "in", "val": 0}]},
+//	  {"name": "close", "args": [
+//	    {"kind": "result", "type": "fd", "field": "fd", "dir": "in", "use": "r0"}]}]}
+//
+// Arg kinds and kind-specific fields:
+//	const:    val
+//	result:   use (name of the referenced variable), div, add
+//	pointer:  page, offset, pages, res (the pointee, null for vma)
+//	pagesize: page, offset
+//	data:     data (hex-encoded)
+//	group:    inner (struct fields, array elements or 0/1 elements of a conditional field)
+//	union:    option (field name of the selected option), res (option value)
+// Any arg can have "var" set to the name of the variable that the arg defines,
+// such variable is referenced by "use" of subsequent result args.
+// Programs and calls can have optional "comments" (see annotation.go).
+// Omitted args (e.g. optional pointee) are encoded as null.
+// Struct padding is not included in the output and is not expected in input.
+// Type information ("type", "field" and "dir") is informational only
+// and is ignored during deserialization: types are inferred from syscall descriptions.
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/syzkaller/sys"
+)
+
+type jsonProg struct {
+	Comments []string    `json:"comments,omitempty"`
+	Calls    []*jsonCall `json:"calls"`
+}
+
+type jsonCall struct {
+	Comments []string   `json:"comments,omitempty"`
+	Name     string     `json:"name"`
+	Ret      string     `json:"ret,omitempty"`
+	Args     []*jsonArg `json:"args"`
+}
+
+type jsonArg struct {
+	Kind   string     `json:"kind"`
+	Type   string     `json:"type,omitempty"`
+	Field  string     `json:"field,omitempty"`
+	Dir    string     `json:"dir,omitempty"`
+	Var    string     `json:"var,omitempty"`
+	Val    *uint64    `json:"val,omitempty"`
+	Use    string     `json:"use,omitempty"`
+	Div    uint64     `json:"div,omitempty"`
+	Add    uint64     `json:"add,omitempty"`
+	Page   *uint64    `json:"page,omitempty"`
+	Offset *int       `json:"offset,omitempty"`
+	Pages  *uint64    `json:"pages,omitempty"`
+	Data   *string    `json:"data,omitempty"`
+	Inner  []*jsonArg `json:"inner,omitempty"`
+	Option string     `json:"option,omitempty"`
+	Res    *jsonArg   `json:"res,omitempty"`
+}
+
+var jsonArgKinds = map[ArgKind]string{
+	ArgConst:    "const",
+	ArgResult:   "result",
+	ArgPointer:  "pointer",
+	ArgPageSize: "pagesize",
+	ArgData:     "data",
+	ArgGroup:    "group",
+	ArgUnion:    "union",
+}
+
+var jsonDirs = map[sys.Dir]string{
+	sys.DirIn:    "in",
+	sys.DirOut:   "out",
+	sys.DirInOut: "inout",
+}
+
+// SerializeJSON serializes the program in the JSON format.
+func (p *Prog) SerializeJSON() []byte {
+	if debug {
+		if err := p.validate(); err != nil {
+			panic("serializing invalid program")
+		}
+	}
+	vars := make(map[*Arg]string)
+	jp := &jsonProg{Comments: p.Comments, Calls: []*jsonCall{}}
+	for _, c := range p.Calls {
+		jc := &jsonCall{Comments: c.Comments, Name: c.Meta.Name, Args: []*jsonArg{}}
+		if len(c.Ret.Uses) != 0 {
+			jc.Ret = fmt.Sprintf("r%v", len(vars))
+			vars[c.Ret] = jc.Ret
+		}
+		for _, a := range c.Args {
+			jc.Args = append(jc.Args, a.serializeJSON(vars))
+		}
+		jp.Calls = append(jp.Calls, jc)
+	}
+	data, err := json.MarshalIndent(jp, "", "\t")
+	if err != nil {
+		panic(fmt.Sprintf("failed to marshal program: %v", err))
+	}
+	return append(data, '\n')
+}
+
+func (a *Arg) serializeJSON(vars map[*Arg]string) *jsonArg {
+	if a == nil {
+		return nil
+	}
+	ja := &jsonArg{
+		Kind:  jsonArgKinds[a.Kind],
+		Type:  a.Type.Name(),
+		Field: a.Type.FieldName(),
+		Dir:   jsonDirs[a.Type.Dir()],
+	}
+	if len(a.Uses) != 0 {
+		ja.Var = fmt.Sprintf("r%v", len(vars))
+		vars[a] = ja.Var
+	}
+	switch a.Kind {
+	case ArgConst:
+		val := uint64(a.Val)
+		ja.Val = &val
+	case ArgResult:
+		id, ok := vars[a.Res]
+		if !ok {
+			panic("no result")
+		}
+		ja.Use = id
+		ja.Div = uint64(a.OpDiv)
+		ja.Add = uint64(a.OpAdd)
+	case ArgPointer, ArgPageSize:
+		page, off := uint64(a.AddrPage), a.AddrOffset
+		ja.Page, ja.Offset = &page, &off
+		if a.Kind == ArgPointer {
+			npages := uint64(a.AddrPagesNum)
+			ja.Pages = &npages
+			ja.Res = a.Res.serializeJSON(vars)
+		}
+	case ArgData:
+		data := hex.EncodeToString(a.Data)
+		ja.Data = &data
+	case ArgGroup:
+		ja.Inner = []*jsonArg{}
+		for _, a1 := range a.Inner {
+			if a1 != nil && sys.IsPad(a1.Type) {
+				continue
+			}
+			ja.Inner = append(ja.Inner, a1.serializeJSON(vars))
+		}
+	case ArgUnion:
+		ja.Option = a.OptionType.FieldName()
+		ja.Res = a.Option.serializeJSON(vars)
+	default:
+		panic("unknown arg kind")
+	}
+	return ja
+}
+
+// DeserializeJSON parses a program in the JSON format produced by SerializeJSON.
+func DeserializeJSON(data []byte) (*Prog, error) {
+	jp := new(jsonProg)
+	if err := json.Unmarshal(data, jp); err != nil {
+		return nil, fmt.Errorf("failed to parse program: %v", err)
+	}
+	prog := &Prog{Comments: jp.Comments}
+	vars := make(map[string]*Arg)
+	for _, jc := range jp.Calls {
+		if jc == nil {
+			return nil, fmt.Errorf("null call")
+		}
+		meta := sys.CallMap[jc.Name]
+		if meta == nil {
+			return nil, fmt.Errorf("unknown syscall %v", jc.Name)
+		}
+		c := &Call{
+			Meta:     meta,
+			Ret:      returnArg(meta.Ret),
+			Comments: jc.Comments,
+		}
+		prog.Calls = append(prog.Calls, c)
+		if jc.Ret != "" {
+			vars[jc.Ret] = c.Ret
+		}
+		if len(jc.Args) != len(meta.Args) {
+			return nil, fmt.Errorf("wrong call arg count: %v, want %v", len(jc.Args), len(meta.Args))
+		}
+		for i, typ := range meta.Args {
+			arg, err := jc.Args[i].deserialize(typ, vars)
+			if err != nil {
+				return nil, fmt.Errorf("call %v: %v", jc.Name, err)
+			}
+			c.Args = append(c.Args, arg)
+		}
+	}
+	if err := prog.validate(); err != nil {
+		return nil, err
+	}
+	return prog, nil
+}
+
+func (ja *jsonArg) deserialize(typ sys.Type, vars map[string]*Arg) (*Arg, error) {
+	if ja == nil {
+		return nil, nil
+	}
+	if typ == nil {
+		return nil, fmt.Errorf("non-null arg without type")
+	}
+	var arg *Arg
+	switch ja.Kind {
+	case "const":
+		if ja.Val == nil {
+			return nil, fmt.Errorf("const arg %v without val", typ.Name())
+		}
+		arg = constArg(typ, uintptr(*ja.Val))
+	case "result":
+		res := vars[ja.Use]
+		if res == nil {
+			return nil, fmt.Errorf("result arg %v references unknown variable %q", typ.Name(), ja.Use)
+		}
+		arg = resultArg(typ, res)
+		arg.OpDiv = uintptr(ja.Div)
+		arg.OpAdd = uintptr(ja.Add)
+	case "pointer", "pagesize":
+		if ja.Page == nil || ja.Offset == nil {
+			return nil, fmt.Errorf("%v arg %v without page/offset", ja.Kind, typ.Name())
+		}
+		if ja.Kind == "pagesize" {
+			arg = pageSizeArg(typ, uintptr(*ja.Page), *ja.Offset)
+			break
+		}
+		var typ1 sys.Type
+		switch t1 := typ.(type) {
+		case *sys.PtrType:
+			typ1 = t1.Type
+		case *sys.VmaType:
+		default:
+			return nil, fmt.Errorf("pointer arg is not a pointer: %v", typ.Name())
+		}
+		var npages uintptr
+		if ja.Pages != nil {
+			npages = uintptr(*ja.Pages)
+		}
+		arg = pointerArg(typ, uintptr(*ja.Page), *ja.Offset, npages, nil)
+		// Variables are defined in pre-order, so define it before descending into the pointee.
+		ja.define(arg, vars)
+		inner, err := ja.Res.deserialize(typ1, vars)
+		if err != nil {
+			return nil, err
+		}
+		arg.Res = inner
+	case "data":
+		if ja.Data == nil {
+			return nil, fmt.Errorf("data arg %v without data", typ.Name())
+		}
+		data, err := hex.DecodeString(*ja.Data)
+		if err != nil {
+			return nil, fmt.Errorf("data arg %v has bad data: %v", typ.Name(), err)
+		}
+		arg = dataArg(typ, data)
+	case "group":
+		arg = groupArg(typ, nil)
+		ja.define(arg, vars)
+		switch t1 := typ.(type) {
+		case *sys.StructType:
+			inner := ja.Inner
+			for _, fld := range t1.Fields {
+				if sys.IsPad(fld) {
+					arg.Inner = append(arg.Inner, constArg(fld, 0))
+					continue
+				}
+				if len(inner) == 0 {
+					return nil, fmt.Errorf("struct arg %v has too few fields", typ.Name())
+				}
+				a1, err := inner[0].deserialize(fld, vars)
+				if err != nil {
+					return nil, err
+				}
+				arg.Inner = append(arg.Inner, a1)
+				inner = inner[1:]
+			}
+			if len(inner) != 0 {
+				return nil, fmt.Errorf("struct arg %v has too many fields", typ.Name())
+			}
+		case *sys.ArrayType:
+			for _, ja1 := range ja.Inner {
+				a1, err := ja1.deserialize(t1.Type, vars)
+				if err != nil {
+					return nil, err
+				}
+				arg.Inner = append(arg.Inner, a1)
+			}
+		case *sys.CondType:
+			if len(ja.Inner) > 1 {
+				return nil, fmt.Errorf("conditional field %v has %v elements", typ.FieldName(), len(ja.Inner))
+			}
+			for _, ja1 := range ja.Inner {
+				a1, err := ja1.deserialize(t1.Type, vars)
+				if err != nil {
+					return nil, err
+				}
+				arg.Inner = append(arg.Inner, a1)
+			}
+		default:
+			return nil, fmt.Errorf("group arg is not a struct or array: %v", typ.Name())
+		}
+	case "union":
+		t1, ok := typ.(*sys.UnionType)
+		if !ok {
+			return nil, fmt.Errorf("union arg is not a union: %v", typ.Name())
+		}
+		var optType sys.Type
+		for _, t2 := range t1.Options {
+			if ja.Option == t2.FieldName() {
+				optType = t2
+				break
+			}
+		}
+		if optType == nil {
+			return nil, fmt.Errorf("union arg %v has unknown option: %v", typ.Name(), ja.Option)
+		}
+		arg = unionArg(typ, nil, optType)
+		ja.define(arg, vars)
+		opt, err := ja.Res.deserialize(optType, vars)
+		if err != nil {
+			return nil, err
+		}
+		arg.Option = opt
+	default:
+		return nil, fmt.Errorf("arg %v has unknown kind %q", typ.Name(), ja.Kind)
+	}
+	ja.define(arg, vars)
+	return arg, nil
+}
+
+func (ja *jsonArg) define(arg *Arg, vars map[string]*Arg) {
+	if ja.Var != "" && vars[ja.Var] == nil {
+		vars[ja.Var] = arg
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package prog
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestSerializeJSONRandom(t *testing.T) {
+	rs, iters := initTest(t)
+	for i := 0; i < iters; i++ {
+		p := Generate(rs, 10, nil)
+		p.Mutate(rs, 10, nil, nil)
+		text := p.Serialize()
+		data := p.SerializeJSON()
+		var v interface{}
+		if err := json.Unmarshal(data, &v); err != nil {
+			t.Fatalf("serialized invalid json: %v\n%s", err, data)
+		}
+		p1, err := DeserializeJSON(data)
+		if err != nil {
+			t.Fatalf("failed to deserialize json program: %v\n%s\n%s", err, text, data)
+		}
+		if text1 := p1.Serialize(); !bytes.Equal(text, text1) {
+			t.Fatalf("program changed after json round-trip:\n%s\n\nvs:\n%s", text, text1)
+		}
+	}
+}
+
+func TestSerializeJSON(t *testing.T) {
+	p, err := Deserialize([]byte("r0 = open(&(0x7f0000000000)=\"2e2f66696c653000\", 0x42, 0x0)\nclose(r0)\n"))
+	if err != nil {
+		t.Fatalf("failed to deserialize program: %v", err)
+	}
+	want := `{"calls":[` +
+		`{"name":"open","ret":"r0","args":[` +
+		`{"kind":"pointer","type":"ptr","field":"file","dir":"in","page":0,"offset":0,"pages":0,` +
+		`"res":{"kind":"data","type":"filename","dir":"in","data":"2e2f66696c653000"}},` +
+		`{"kind":"const","type":"flags","field":"flags","dir":"in","val":66},` +
+		`{"kind":"const","type":"flags","field":"mode","dir":"in","val":0}]},` +
+		`{"name":"close","args":[` +
+		`{"kind":"result","type":"fd","field":"fd","dir":"in","use":"r0"}]}]}`
+	got := new(bytes.Buffer)
+	if err := json.Compact(got, p.SerializeJSON()); err != nil {
+		t.Fatalf("failed to compact json: %v", err)
+	}
+	if got.String() != want {
+		t.Fatalf("bad json:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestDeserializeJSONBad(t *testing.T) {
+	for _, data := range []string{
+		`{"calls":[{"name":"foo","args":[]}]}`,
+		`{"calls":[{"name":"close","args":[]}]}`,
+		`{"calls":[{"name":"close","args":[{"kind":"result","use":"r0"}]}]}`,
+		`{"calls":[{"name":"close","args":[{"kind":"foo"}]}]}`,
+		`{"calls":[{"name":"close","args":[{"kind":"const"}]}]}`,
+		`{"calls":[null]}`,
+		`{"calls":`,
+	} {
+		if _, err := DeserializeJSON([]byte(data)); err == nil {
+			t.Fatalf("deserialized bad program: %s", data)
+		}
+	}
+}
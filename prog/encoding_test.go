@@ -4,6 +4,7 @@
 package prog
 
 import (
+	"bytes"
 	"fmt"
 	"reflect"
 	"sort"
@@ -93,3 +94,76 @@ func TestCallSetRandom(t *testing.T) {
 		}
 	}
 }
+
+func TestSerializeComments(t *testing.T) {
+	text := `# origin: hub
+# time: 2017-06-01T12:00:00Z
+
+# cover: 1234
+getpid()
+# free-form
+# comment
+gettid()
+`
+	p, err := Deserialize([]byte(text))
+	if err != nil {
+		t.Fatalf("failed to deserialize: %v", err)
+	}
+	if want := []string{"origin: hub", "time: 2017-06-01T12:00:00Z"}; !reflect.DeepEqual(p.Comments, want) {
+		t.Fatalf("bad program comments: %q, want %q", p.Comments, want)
+	}
+	if want := []string{"cover: 1234"}; !reflect.DeepEqual(p.Calls[0].Comments, want) {
+		t.Fatalf("bad call comments: %q, want %q", p.Calls[0].Comments, want)
+	}
+	if want := []string{"free-form", "comment"}; !reflect.DeepEqual(p.Calls[1].Comments, want) {
+		t.Fatalf("bad call comments: %q, want %q", p.Calls[1].Comments, want)
+	}
+	if got := Annotation(p.Comments, AnnotationOrigin); got != "hub" {
+		t.Fatalf("bad origin annotation: %q", got)
+	}
+	if got := Annotation(p.Calls[1].Comments, "free"); got != "" {
+		t.Fatalf("free-form comment parsed as annotation: %q", got)
+	}
+	if data := p.Clone().Serialize(); string(data) != text {
+		t.Fatalf("comments are not preserved:\n%s\nwant:\n%s", data, text)
+	}
+	p1, err := DeserializeJSON(p.SerializeJSON())
+	if err != nil {
+		t.Fatalf("failed to deserialize json: %v", err)
+	}
+	if data := p1.Serialize(); string(data) != text {
+		t.Fatalf("comments are not preserved by json:\n%s\nwant:\n%s", data, text)
+	}
+
+	p.Comments = SetAnnotation(p.Comments, AnnotationOrigin, "repro")
+	p.Comments = SetAnnotation(p.Comments, AnnotationTime, "")
+	p.Calls[0].Comments = SetAnnotation(p.Calls[0].Comments, AnnotationCover, "5678")
+	p.Calls[1].Comments = SetAnnotation(p.Calls[1].Comments, AnnotationOrigin, "fuzzer")
+	want := `# origin: repro
+
+# cover: 5678
+getpid()
+# free-form
+# comment
+# origin: fuzzer
+gettid()
+`
+	if data := p.Serialize(); string(data) != want {
+		t.Fatalf("bad annotations:\n%s\nwant:\n%s", data, want)
+	}
+}
+
+func TestMutateDropsComments(t *testing.T) {
+	rs, _ := initTest(t)
+	text := "# origin: hub\n\n# cover: 1234\ngetpid()\n# comment\ngettid()\n"
+	for i := 0; i < 10; i++ {
+		p, err := Deserialize([]byte(text))
+		if err != nil {
+			t.Fatalf("failed to deserialize: %v", err)
+		}
+		p.Mutate(rs, 10, nil, nil)
+		if data := p.Serialize(); bytes.Contains(data, []byte("#")) {
+			t.Fatalf("comments are preserved by mutation:\n%s", data)
+		}
+	}
+}
@@ -26,25 +26,24 @@ const (
 
 const (
 	ExecBufferSize = 2 << 20
-
-	ptrSize    = 8
-	pageSize   = 4 << 10
-	dataOffset = 512 << 20
 )
 
 // SerializeForExec serializes program p for execution by process pid into the provided buffer.
+// Returns number of bytes written to the buffer.
 // If the provided buffer is too small for the program an error is returned.
-func (p *Prog) SerializeForExec(buffer []byte, pid int) error {
+func (p *Prog) SerializeForExec(buffer []byte, pid int) (int, error) {
 	if debug {
 		if err := p.validate(); err != nil {
 			panic(fmt.Errorf("serializing invalid program: %v", err))
 		}
 	}
 	var instrSeq uintptr
+	t := p.target()
 	w := &execContext{
-		buf:  buffer,
-		eof:  false,
-		args: make(map[*Arg]argInfo),
+		target: t,
+		buf:    buffer,
+		eof:    false,
+		args:   make(map[*Arg]argInfo),
 	}
 	for _, c := range p.Calls {
 		// Calculate checksums.
@@ -57,11 +56,36 @@ func (p *Prog) SerializeForExec(buffer []byte, pid int) error {
 					if len(arg1.Uses) != 0 {
 						w.args[arg1] = argInfo{Offset: offset}
 					}
-					if !sys.IsPad(arg1.Type) &&
+					if isSwappedBitfield(arg1) && arg1.Type.Dir() != sys.DirOut {
+						// Store big-endian bitfields byte-by-byte, see splitBigEndianBitfield.
+						for _, part := range splitBigEndianBitfield(arg1.value(pid, false), arg1.Size(),
+							arg1.Type.BitfieldOffset(), arg1.Type.BitfieldLength()) {
+							w.write(ExecInstrCopyin)
+							w.write(t.physicalAddr(arg) + offset + part.offset)
+							w.write(ExecArgConst)
+							w.write(1)
+							w.write(part.val)
+							w.write(part.bfOff)
+							w.write(part.bfLen)
+							instrSeq++
+						}
+					} else if isZeroedPad(arg1) {
+						// Explicit padding is zeroed, since the kernel frequently checks it.
+						for _, part := range splitPad(arg1.Size()) {
+							w.write(ExecInstrCopyin)
+							w.write(t.physicalAddr(arg) + offset + part.offset)
+							w.write(ExecArgConst)
+							w.write(part.size)
+							w.write(0)
+							w.write(0)
+							w.write(0)
+							instrSeq++
+						}
+					} else if !sys.IsPad(arg1.Type) &&
 						!(arg1.Kind == ArgData && len(arg1.Data) == 0) &&
 						arg1.Type.Dir() != sys.DirOut {
 						w.write(ExecInstrCopyin)
-						w.write(physicalAddr(arg) + offset)
+						w.write(t.physicalAddr(arg) + offset)
 						w.writeArg(arg1, pid, csumMap)
 						instrSeq++
 					}
@@ -96,7 +120,7 @@ func (p *Prog) SerializeForExec(buffer []byte, pid int) error {
 				instrSeq++
 				w.args[arg] = info
 				w.write(ExecInstrCopyout)
-				w.write(physicalAddr(base) + info.Offset)
+				w.write(t.physicalAddr(base) + info.Offset)
 				w.write(arg.Size())
 			default:
 				panic("bad arg kind in copyout")
@@ -105,28 +129,16 @@ func (p *Prog) SerializeForExec(buffer []byte, pid int) error {
 	}
 	w.write(ExecInstrEOF)
 	if w.eof {
-		return fmt.Errorf("provided buffer is too small")
-	}
-	return nil
-}
-
-func physicalAddr(arg *Arg) uintptr {
-	if arg.Kind != ArgPointer {
-		panic("physicalAddr: bad arg kind")
-	}
-	addr := arg.AddrPage*pageSize + dataOffset
-	if arg.AddrOffset >= 0 {
-		addr += uintptr(arg.AddrOffset)
-	} else {
-		addr += pageSize - uintptr(-arg.AddrOffset)
+		return 0, fmt.Errorf("provided buffer is too small")
 	}
-	return addr
+	return len(buffer) - len(w.buf), nil
 }
 
 type execContext struct {
-	buf  []byte
-	eof  bool
-	args map[*Arg]argInfo
+	target *Target
+	buf    []byte
+	eof    bool
+	args   map[*Arg]argInfo
 }
 
 type argInfo struct {
@@ -139,14 +151,10 @@ func (w *execContext) write(v uintptr) {
 		w.eof = true
 		return
 	}
-	w.buf[0] = byte(v >> 0)
-	w.buf[1] = byte(v >> 8)
-	w.buf[2] = byte(v >> 16)
-	w.buf[3] = byte(v >> 24)
-	w.buf[4] = byte(v >> 32)
-	w.buf[5] = byte(v >> 40)
-	w.buf[6] = byte(v >> 48)
-	w.buf[7] = byte(v >> 56)
+	// Executor reads the program as an array of native (little-endian) machine words.
+	for i := uint(0); i < 8; i++ {
+		w.buf[i] = byte(uint64(v) >> (i * 8))
+	}
 	w.buf = w.buf[8:]
 }
 
@@ -158,6 +166,11 @@ func (w *execContext) writeArg(arg *Arg, pid int, csumMap map[*Arg]*Arg) {
 				panic("csum arg is not in csum map")
 			}
 		}
+		if _, ok := arg.Type.(*sys.VarintType); ok {
+			// Varints are passed as already encoded data.
+			w.writeData(encodeVarint(arg.Val))
+			break
+		}
 		w.write(ExecArgConst)
 		w.write(arg.Size())
 		w.write(arg.Value(pid))
@@ -172,29 +185,58 @@ func (w *execContext) writeArg(arg *Arg, pid int, csumMap map[*Arg]*Arg) {
 	case ArgPointer:
 		w.write(ExecArgConst)
 		w.write(arg.Size())
-		w.write(physicalAddr(arg))
+		w.write(w.target.physicalAddr(arg))
 		w.write(0) // bit field offset
 		w.write(0) // bit field length
 	case ArgPageSize:
 		w.write(ExecArgConst)
 		w.write(arg.Size())
-		w.write(arg.AddrPage * pageSize)
+		w.write(arg.AddrPage * w.target.PageSize)
 		w.write(0) // bit field offset
 		w.write(0) // bit field length
 	case ArgData:
-		w.write(ExecArgData)
-		w.write(uintptr(len(arg.Data)))
-		padded := len(arg.Data)
-		if pad := 8 - len(arg.Data)%8; pad != 8 {
-			padded += pad
-		}
-		if len(w.buf) < padded {
-			w.eof = true
-		} else {
-			copy(w.buf, arg.Data)
-			w.buf = w.buf[padded:]
-		}
+		w.writeData(arg.Data)
 	default:
 		panic("unknown arg type")
 	}
 }
+
+func (w *execContext) writeData(data []byte) {
+	w.write(ExecArgData)
+	w.write(uintptr(len(data)))
+	padded := len(data)
+	if pad := 8 - len(data)%8; pad != 8 {
+		padded += pad
+	}
+	if len(w.buf) < padded {
+		w.eof = true
+	} else {
+		copy(w.buf, data)
+		w.buf = w.buf[padded:]
+	}
+}
+
+func isZeroedPad(arg *Arg) bool {
+	ct, ok := arg.Type.(*sys.ConstType)
+	return ok && ct.IsPad && ct.PadZero && arg.Type.Dir() != sys.DirOut
+}
+
+type padPart struct {
+	offset uintptr
+	size   uintptr
+}
+
+// splitPad splits padding of the given size into parts that can be stored as consts
+// (1, 2, 4 or 8 bytes).
+func splitPad(size uintptr) []padPart {
+	var parts []padPart
+	for off := uintptr(0); off < size; {
+		n := uintptr(8)
+		for n > size-off {
+			n /= 2
+		}
+		parts = append(parts, padPart{off, n})
+		off += n
+	}
+	return parts
+}
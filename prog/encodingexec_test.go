@@ -17,7 +17,7 @@ func TestSerializeForExecRandom(t *testing.T) {
 	buf := make([]byte, ExecBufferSize)
 	for i := 0; i < iters; i++ {
 		p := Generate(rs, 10, nil)
-		if err := p.SerializeForExec(buf, i%16); err != nil {
+		if _, err := p.SerializeForExec(buf, i%16); err != nil {
 			t.Fatalf("failed to serialize: %v", err)
 		}
 	}
@@ -44,6 +44,10 @@ func TestSerializeForExec(t *testing.T) {
 		argResult    = uint64(ExecArgResult)
 		argData      = uint64(ExecArgData)
 	)
+	var (
+		ptrSize    = uint64(defaultTarget.PtrSize)
+		dataOffset = uint64(defaultTarget.DataOffset)
+	)
 	callID := func(name string) uint64 {
 		c := sys.CallMap[name]
 		if c == nil {
@@ -145,6 +149,34 @@ func TestSerializeForExec(t *testing.T) {
 				instrEOF,
 			},
 		},
+		{
+			"syz_test$align7(&(0x7f0000000000)={0x42, 0x43, 0x44, 0x45})",
+			[]uint64{
+				instrCopyin, dataOffset + 0, argConst, 1, 0x42, 0, 0,
+				instrCopyin, dataOffset + 1, argConst, 2, 0, 0, 0,
+				instrCopyin, dataOffset + 3, argConst, 1, 0, 0, 0,
+				instrCopyin, dataOffset + 4, argConst, 4, 0x43, 0, 0,
+				instrCopyin, dataOffset + 8, argConst, 2, 0x44, 0, 0,
+				instrCopyin, dataOffset + 10, argConst, 4, 0, 0, 0,
+				instrCopyin, dataOffset + 14, argConst, 2, 0, 0, 0,
+				instrCopyin, dataOffset + 16, argConst, 4, 0x45, 0, 0,
+				callID("syz_test$align7"), 1, argConst, ptrSize, dataOffset, 0, 0,
+				instrEOF,
+			},
+		},
+		{
+			"syz_test$align8(&(0x7f0000000000)={0x42, 0x43}, 0x20)",
+			[]uint64{
+				instrCopyin, dataOffset + 0, argConst, 1, 0x42, 0, 0,
+				instrCopyin, dataOffset + 1, argConst, 8, 0, 0, 0,
+				instrCopyin, dataOffset + 9, argConst, 4, 0, 0, 0,
+				instrCopyin, dataOffset + 13, argConst, 2, 0, 0, 0,
+				instrCopyin, dataOffset + 15, argConst, 1, 0, 0, 0,
+				instrCopyin, dataOffset + 16, argConst, 1, 0x43, 0, 0,
+				callID("syz_test$align8"), 2, argConst, ptrSize, dataOffset, 0, 0, argConst, ptrSize, 0x20, 0, 0,
+				instrEOF,
+			},
+		},
 		{
 			"syz_test$union0(&(0x7f0000000000)={0x1, @f2=0x2})",
 			[]uint64{
@@ -233,7 +265,8 @@ func TestSerializeForExec(t *testing.T) {
 				instrCopyin, dataOffset + 16, argConst, 2, 0x42, 5, 6,
 				instrCopyin, dataOffset + 20, argConst, 4, 0x42, 0, 15,
 				instrCopyin, dataOffset + 24, argConst, 2, 0x42, 0, 11,
-				instrCopyin, dataOffset + 26, argConst, 2, 0x4200, 0, 11,
+				instrCopyin, dataOffset + 26, argConst, 1, 0x8, 0, 8,
+				instrCopyin, dataOffset + 27, argConst, 1, 0x2, 5, 3,
 				instrCopyin, dataOffset + 28, argConst, 1, 0x42, 0, 0,
 				callID("syz_test$bf0"), 1, argConst, ptrSize, dataOffset, 0, 0,
 				instrEOF,
@@ -250,6 +283,61 @@ func TestSerializeForExec(t *testing.T) {
 				instrEOF,
 			},
 		},
+		{
+			"syz_test$bf2(&(0x7f0000000000)={0xa, 0xabc, 0x5, 0x2a5, 0x9, 0x6, 0x11})",
+			[]uint64{
+				instrCopyin, dataOffset + 0, argConst, 4, 0xa, 4, 4,
+				instrCopyin, dataOffset + 0, argConst, 4, 0xabc, 12, 12,
+				instrCopyin, dataOffset + 4, argConst, 1, 0x5, 5, 3,
+				instrCopyin, dataOffset + 4, argConst, 1, 0x15, 0, 5,
+				instrCopyin, dataOffset + 5, argConst, 1, 0x5, 3, 5,
+				instrCopyin, dataOffset + 7, argConst, 1, 0x9, 0, 4,
+				instrCopyin, dataOffset + 8, argConst, 1, 0x6, 5, 3,
+				instrCopyin, dataOffset + 8, argConst, 1, 0x11, 0, 5,
+				callID("syz_test$bf2"), 1, argConst, ptrSize, dataOffset, 0, 0,
+				instrEOF,
+			},
+		},
+		{
+			"syz_test$cond0(&(0x7f0000000000)={0x1, 0x10, [0x42], []})",
+			[]uint64{
+				instrCopyin, dataOffset + 0, argConst, 4, 0x1, 0, 0,
+				instrCopyin, dataOffset + 4, argConst, 4, 0x10, 0, 0,
+				instrCopyin, dataOffset + 8, argConst, 8, 0x42, 0, 0,
+				callID("syz_test$cond0"), 1, argConst, ptrSize, dataOffset, 0, 0,
+				instrEOF,
+			},
+		},
+		{
+			"syz_test$cond1(&(0x7f0000000000)={0x5, [0x42], 0x43})",
+			[]uint64{
+				instrCopyin, dataOffset + 0, argConst, 1, 0x5, 0, 0,
+				instrCopyin, dataOffset + 1, argConst, 4, 0x42, 0, 0,
+				instrCopyin, dataOffset + 5, argConst, 2, 0x43, 0, 0,
+				callID("syz_test$cond1"), 1, argConst, ptrSize, dataOffset, 0, 0,
+				instrEOF,
+			},
+		},
+		{
+			"syz_test$cond1(&(0x7f0000000000)={0x6, [], 0x43})",
+			[]uint64{
+				instrCopyin, dataOffset + 0, argConst, 1, 0x6, 0, 0,
+				instrCopyin, dataOffset + 1, argConst, 2, 0x43, 0, 0,
+				callID("syz_test$cond1"), 1, argConst, ptrSize, dataOffset, 0, 0,
+				instrEOF,
+			},
+		},
+		{
+			"syz_test$varint0(&(0x7f0000000000)={0x96, 0x6, 0x1, 0x42})",
+			[]uint64{
+				instrCopyin, dataOffset + 0, argData, 2, 0x0196,
+				instrCopyin, dataOffset + 2, argConst, 1, 0x6, 0, 0,
+				instrCopyin, dataOffset + 3, argData, 1, 0x1,
+				instrCopyin, dataOffset + 4, argConst, 2, 0x42, 0, 0,
+				callID("syz_test$varint0"), 1, argConst, ptrSize, dataOffset, 0, 0,
+				instrEOF,
+			},
+		},
 	}
 
 	buf := make([]byte, ExecBufferSize)
@@ -259,11 +347,15 @@ func TestSerializeForExec(t *testing.T) {
 			t.Fatalf("failed to deserialize prog %v: %v", i, err)
 		}
 		t.Run(fmt.Sprintf("%v:%v", i, p.String()), func(t *testing.T) {
-			if err := p.SerializeForExec(buf, i%16); err != nil {
+			n, err := p.SerializeForExec(buf, i%16)
+			if err != nil {
 				t.Fatalf("failed to serialize: %v", err)
 			}
 			w := new(bytes.Buffer)
 			binary.Write(w, binary.LittleEndian, test.serialized)
+			if n != len(w.Bytes()) {
+				t.Fatalf("serialized size %v, want %v", n, len(w.Bytes()))
+			}
 			data := buf
 			if len(data) > len(w.Bytes()) {
 				data = data[:len(w.Bytes())]
@@ -279,3 +371,44 @@ func TestSerializeForExec(t *testing.T) {
 		})
 	}
 }
+
+func TestSerializeForExecTarget(t *testing.T) {
+	p, err := Deserialize([]byte("syz_test$end1(&(0x7f0000000000)={0xe, 0x42, 0x1})"))
+	if err != nil {
+		t.Fatalf("failed to deserialize prog: %v", err)
+	}
+	p.Target = &Target{
+		Arch:       "test",
+		PtrSize:    8,
+		PageSize:   8 << 10,
+		DataOffset: 1 << 30,
+	}
+	// Pointers must be laid out according to the target data offset.
+	want := []uint64{
+		uint64(ExecInstrCopyin), 1<<30 + 0, uint64(ExecArgConst), 2, 0x0e00, 0, 0,
+		uint64(ExecInstrCopyin), 1<<30 + 2, uint64(ExecArgConst), 4, 0x42000000, 0, 0,
+		uint64(ExecInstrCopyin), 1<<30 + 6, uint64(ExecArgConst), 8, 0x0100000000000000, 0, 0,
+		uint64(sys.CallMap["syz_test$end1"].ID), 1, uint64(ExecArgConst), 8, 1 << 30, 0, 0,
+		uint64(ExecInstrEOF),
+	}
+	buf := make([]byte, ExecBufferSize)
+	n, err := p.SerializeForExec(buf, 0)
+	if err != nil {
+		t.Fatalf("failed to serialize: %v", err)
+	}
+	if n != len(want)*8 {
+		t.Fatalf("serialized size %v, want %v", n, len(want)*8)
+	}
+	got := make([]uint64, len(want))
+	binary.Read(bytes.NewReader(buf[:n]), binary.LittleEndian, &got)
+	for i := range want {
+		if got[i] != want[i] {
+			t.Logf("want: %v", want)
+			t.Logf("got:  %v", got)
+			t.Fatalf("mismatch at word %v", i)
+		}
+	}
+	if p1 := p.Clone(); p1.Target != p.Target {
+		t.Fatalf("clone lost target")
+	}
+}
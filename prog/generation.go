@@ -4,14 +4,22 @@
 package prog
 
 import (
+	"encoding/binary"
+	"fmt"
 	"math/rand"
+
+	"github.com/google/syzkaller/hash"
+	"github.com/google/syzkaller/sys"
 )
 
 // Generate generates a random program of length ~ncalls.
 // calls is a set of allowed syscalls, if nil all syscalls are used.
 func Generate(rs rand.Source, ncalls int, ct *ChoiceTable) *Prog {
+	return newRand(rs, defaultTarget).generate(ncalls, ct)
+}
+
+func (r *randGen) generate(ncalls int, ct *ChoiceTable) *Prog {
 	p := new(Prog)
-	r := newRand(rs)
 	s := newState(ct)
 	for len(p.Calls) < ncalls {
 		calls := r.generateCall(s, p)
@@ -20,6 +28,42 @@ func Generate(rs rand.Source, ncalls int, ct *ChoiceTable) *Prog {
 			p.Calls = append(p.Calls, c)
 		}
 	}
+	if generateCleanupCalls && r.bin() {
+		// Release resources created by the program, so that they don't accumulate
+		// (e.g. exhaust fd table) when programs are executed one after another.
+		for _, c := range r.generateCleanup(s, p) {
+			s.analyze(c)
+			p.Calls = append(p.Calls, c)
+		}
+	}
+	if debug {
+		if err := p.validate(); err != nil {
+			panic(err)
+		}
+	}
+	return p
+}
+
+var generateCleanupCalls bool
+
+// SetGenerateCleanup enables appending of destructor calls for resources created by the program
+// to half of generated programs (see generateCleanup). It is off by default.
+// It must be called before programs are generated.
+func SetGenerateCleanup(enable bool) {
+	generateCleanupCalls = enable
+}
+
+// GenerateCall generates a program that invokes meta with random arguments.
+// The program also contains calls that create resources for meta (chosen from ct),
+// meta is the last call of the program.
+func GenerateCall(rs rand.Source, meta *sys.Call, ct *ChoiceTable) *Prog {
+	r := newRand(rs, defaultTarget)
+	p := new(Prog)
+	s := newState(ct)
+	for _, c := range r.generateParticularCall(s, meta) {
+		s.analyze(c)
+		p.Calls = append(p.Calls, c)
+	}
 	if debug {
 		if err := p.validate(); err != nil {
 			panic(err)
@@ -27,3 +71,62 @@ func Generate(rs rand.Source, ncalls int, ct *ChoiceTable) *Prog {
 	}
 	return p
 }
+
+// GenerateSeeded is like Generate, but the program is fully determined by the seed,
+// ncalls, ct and the syscall descriptions (sys.Revision). This allows to reproduce
+// exactly the same program on a different host.
+func GenerateSeeded(seed uint64, ncalls int, ct *ChoiceTable) *Prog {
+	return Generate(rand.NewSource(SeededSource(seed)), ncalls, ct)
+}
+
+// SeededSource returns a seed for rand.NewSource that mixes seed with sys.Revision,
+// so that the same seed gives different programs for different descriptions.
+func SeededSource(seed uint64) int64 {
+	return seededSource(sys.Revision, seed)
+}
+
+func seededSource(revision string, seed uint64) int64 {
+	sig := hash.Hash([]byte(fmt.Sprintf("%v-%v", revision, seed)))
+	return int64(binary.LittleEndian.Uint64(sig[:8]))
+}
+
+// generateCleanup generates destructor calls for all resources created by p
+// that are not yet released, in the reverse order of creation.
+func (r *randGen) generateCleanup(s *state, p *Prog) []*Call {
+	var live []*Arg
+	released := make(map[*Arg]bool)
+	for _, c := range p.Calls {
+		if sys.IsDestructor(c.Meta) && len(c.Args) != 0 && c.Args[0].Kind == ArgResult {
+			released[c.Args[0].Res] = true
+		}
+		foreachArgArray(&c.Args, c.Ret, func(arg, _ *Arg, _ *[]*Arg) {
+			if typ, ok := arg.Type.(*sys.ResourceType); ok && typ.Dir() != sys.DirIn && typ.Desc.Destructor != "" {
+				live = append(live, arg)
+			}
+		})
+	}
+	var calls []*Call
+	for i := len(live) - 1; i >= 0; i-- {
+		res := live[i]
+		if released[res] {
+			continue
+		}
+		meta := sys.CallMap[res.Type.(*sys.ResourceType).Desc.Destructor]
+		if meta == nil || s.ct != nil && !s.ct.enabled[meta] {
+			continue
+		}
+		c := &Call{
+			Meta: meta,
+			Ret:  returnArg(meta.Ret),
+		}
+		args, calls1 := r.generateArgs(s, meta.Args[1:])
+		c.Args = append([]*Arg{resultArg(meta.Args[0], res)}, args...)
+		assignSizesCall(r.target, c)
+		calls1 = append(calls1, c)
+		for _, c1 := range calls1 {
+			sanitizeCall(c1)
+		}
+		calls = append(calls, calls1...)
+	}
+	return calls
+}
@@ -0,0 +1,208 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package prog
+
+// Hints are a mutation technique that uses comparison operands observed by kernel
+// (KCOV_TRACE_CMP mode). If an argument value of a call is compared with some
+// other value in kernel (e.g. a magic number or a command code), we substitute
+// the value in the argument with the other comparison operand and execute
+// the resulting program. This allows to get past checks that are hard
+// to satisfy with random mutations.
+
+import (
+	"encoding/binary"
+
+	"github.com/google/syzkaller/sys"
+)
+
+// CompMap maps a comparison operand that could come from the input
+// to the set of other operands it was compared with.
+type CompMap map[uint64]map[uint64]bool
+
+// AddComp records that arg1 was compared with arg2.
+func (m CompMap) AddComp(arg1, arg2 uint64) {
+	if m[arg1] == nil {
+		m[arg1] = make(map[uint64]bool)
+	}
+	m[arg1][arg2] = true
+}
+
+// MutateWithHints generates new programs by replacing argument values
+// of the callIndex-th call of p that match operands in comps
+// with the other operands and invokes exec for each of the programs.
+// Large comparison sets can produce thousands of programs, so if maxExecs is not 0
+// and there are more replacements, only maxExecs of them evenly spread
+// over all replacements are tried.
+// p itself is not modified.
+func (p *Prog) MutateWithHints(callIndex int, comps CompMap, maxExecs int, exec func(p *Prog)) {
+	if len(comps) == 0 {
+		return
+	}
+	type replacement struct {
+		arg    int
+		update func(arg *Arg)
+	}
+	var replacements []replacement
+	args, _ := mutationArgs(p.Calls[callIndex])
+	for i, arg := range args {
+		switch typ := arg.Type.(type) {
+		case *sys.IntType, *sys.FlagsType, *sys.VarintType:
+			if arg.Kind != ArgConst {
+				continue
+			}
+			size := uintptr(8) // varints are decoded into 64-bit values
+			if _, ok := typ.(*sys.VarintType); !ok {
+				size = typ.Size()
+			}
+			bigEndian := isBigEndian(typ)
+			v := uint64(encodeValue(arg.Val, size, bigEndian))
+			for _, r := range intReplacers(v, size, comps) {
+				val := encodeValue(uintptr(r), size, bigEndian)
+				replacements = append(replacements, replacement{i, func(arg *Arg) { arg.Val = val }})
+			}
+		case *sys.BufferType:
+			if arg.Kind != ArgData {
+				continue
+			}
+			switch typ.Kind {
+			case sys.BufferBlobRand, sys.BufferBlobRange, sys.BufferString:
+			default:
+				continue
+			}
+			for _, data := range dataReplacers(arg.Data, comps) {
+				data := data
+				replacements = append(replacements, replacement{i, func(arg *Arg) { arg.Data = data }})
+			}
+		}
+	}
+	n := len(replacements)
+	if maxExecs != 0 && n > maxExecs {
+		n = maxExecs
+	}
+	for j := 0; j < n; j++ {
+		r := replacements[j*len(replacements)/n]
+		p1 := p.Clone()
+		p1.dropComments()
+		c1 := p1.Calls[callIndex]
+		args1, _ := mutationArgs(c1)
+		r.update(args1[r.arg])
+		p1.assignConds(c1)
+		assignSizesCall(p1.target(), c1)
+		sanitizeCall(c1)
+		exec(p1)
+	}
+}
+
+func isBigEndian(typ sys.Type) bool {
+	switch t := typ.(type) {
+	case *sys.IntType:
+		return t.BigEndian
+	case *sys.FlagsType:
+		return t.BigEndian
+	}
+	return false
+}
+
+// hintOffsets are added to comparison operands before substitution.
+// Kernel often checks bounds (x < MAX, x <= MAX), and values next to the operand
+// are needed to get to both sides of such checks.
+var hintOffsets = []uint64{0, 1, ^uint64(0)}
+
+// intReplacers returns new values for an integer of the given size with value v.
+// Kernel can compare only the lower bytes of the value (e.g. after a cast
+// to a narrower type), a sign-extended value, or a byte-swapped value
+// (e.g. after ntohs/be32_to_cpu), so we check all such variants.
+// Every matching operand is also substituted with hintOffsets added.
+func intReplacers(v uint64, size uintptr, comps CompMap) []uint64 {
+	var res []uint64
+	dedup := make(map[uint64]bool)
+	for _, width := range []uint{1, 2, 4, 8} {
+		if uintptr(width) > size {
+			break
+		}
+		mask := uint64(1)<<(width*8) - 1
+		if width == 8 {
+			mask = ^uint64(0)
+		}
+		low := v & mask
+		replace := func(op uint64, inverse func(uint64) uint64) {
+			for r := range comps[op] {
+				// The replacer must fit into the compared part of the value.
+				rlow := r & mask
+				if r != rlow && r != signExtend(rlow, width) {
+					continue
+				}
+				for _, off := range hintOffsets {
+					newV := v&^mask | inverse((rlow+off)&mask)
+					if size < 8 {
+						newV &= uint64(1)<<(size*8) - 1
+					}
+					if newV == v || dedup[newV] {
+						continue
+					}
+					dedup[newV] = true
+					res = append(res, newV)
+				}
+			}
+		}
+		same := func(v uint64) uint64 { return v }
+		replace(low, same)
+		if ext := signExtend(low, width); ext != low {
+			replace(ext, same)
+		}
+		if width > 1 {
+			swap := func(v uint64) uint64 { return byteSwap(v, width) }
+			replace(swap(low), swap)
+		}
+	}
+	return res
+}
+
+// dataReplacers returns new contents for a buffer. Every 1, 2, 4 and 8-byte
+// integer inside of data is replaced with matching operands (see intReplacers).
+func dataReplacers(data []byte, comps CompMap) [][]byte {
+	var res [][]byte
+	dedup := make(map[string]bool)
+	var buf [8]byte
+	for i := range data {
+		for _, width := range []uint{1, 2, 4, 8} {
+			if i+int(width) > len(data) {
+				break
+			}
+			copy(buf[:], data[i:i+int(width)])
+			for j := width; j < 8; j++ {
+				buf[j] = 0
+			}
+			v := binary.LittleEndian.Uint64(buf[:])
+			for _, r := range intReplacers(v, uintptr(width), comps) {
+				binary.LittleEndian.PutUint64(buf[:], r)
+				newData := append([]byte{}, data...)
+				copy(newData[i:], buf[:width])
+				if dedup[string(newData)] {
+					continue
+				}
+				dedup[string(newData)] = true
+				res = append(res, newData)
+			}
+		}
+	}
+	return res
+}
+
+func signExtend(v uint64, width uint) uint64 {
+	if width >= 8 {
+		return v
+	}
+	shift := 64 - width*8
+	return uint64(int64(v<<shift) >> shift)
+}
+
+func byteSwap(v uint64, width uint) uint64 {
+	var res uint64
+	for i := uint(0); i < width; i++ {
+		res = res<<8 | v&0xff
+		v >>= 8
+	}
+	return res
+}
@@ -0,0 +1,121 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package prog
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestHintsIntReplacers(t *testing.T) {
+	tests := []struct {
+		v     uint64
+		size  uintptr
+		comps CompMap
+		res   []uint64
+	}{
+		{
+			v:     0x1234,
+			size:  8,
+			comps: CompMap{0x1234: {0x5678: true}},
+			res:   []uint64{0x5678, 0x5679, 0x5677},
+		},
+		{
+			// Lower byte is compared.
+			v:     0x1234,
+			size:  8,
+			comps: CompMap{0x34: {0x56: true, 0x1234: true}},
+			res:   []uint64{0x1256, 0x1257, 0x1255},
+		},
+		{
+			// Sign-extended value is compared.
+			v:     0xff,
+			size:  1,
+			comps: CompMap{0xffffffffffffffff: {0xfffffffffffffffe: true}},
+			res:   []uint64{0xfe, 0xfd},
+		},
+		{
+			// Byte-swapped value is compared.
+			v:     0x1234,
+			size:  2,
+			comps: CompMap{0x3412: {0x5678: true}},
+			res:   []uint64{0x7856, 0x7956, 0x7756},
+		},
+		{
+			// Offsets wrap around within the compared part.
+			v:     0x12ff,
+			size:  2,
+			comps: CompMap{0xff: {0xff: true, 0x1ff: true}},
+			res:   []uint64{0x1200, 0x12fe},
+		},
+		{
+			// Replacer does not fit into the value.
+			v:     0x12,
+			size:  1,
+			comps: CompMap{0x12: {0x1234: true}},
+			res:   nil,
+		},
+	}
+	for i, test := range tests {
+		res := intReplacers(test.v, test.size, test.comps)
+		if !reflect.DeepEqual(res, test.res) {
+			t.Errorf("test #%v: got %#v, want %#v", i, res, test.res)
+		}
+	}
+}
+
+func TestHintsDataReplacers(t *testing.T) {
+	data := []byte{0x00, 0x11, 0x22, 0x33}
+	comps := CompMap{0x3322: {0xabcd: true}}
+	res := dataReplacers(data, comps)
+	want := [][]byte{
+		{0x00, 0x11, 0xcd, 0xab},
+		{0x00, 0x11, 0xce, 0xab},
+		{0x00, 0x11, 0xcc, 0xab},
+	}
+	if !reflect.DeepEqual(res, want) {
+		t.Fatalf("got %#v, want %#v", res, want)
+	}
+}
+
+func TestHintsMutate(t *testing.T) {
+	p, err := Deserialize([]byte("mmap(&(0x7f0000000000/0x1000)=nil, (0x1000), 0x3, 0x32, 0xffffffffffffffff, 0x0)\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data0 := p.Serialize()
+	comps := CompMap{0x3: {0x7: true}}
+	var got []string
+	p.MutateWithHints(0, comps, 0, func(p1 *Prog) {
+		got = append(got, string(p1.Serialize()))
+	})
+	want := []string{
+		"mmap(&(0x7f0000000000/0x1000)=nil, (0x1000), 0x7, 0x32, 0xffffffffffffffff, 0x0)\n",
+		"mmap(&(0x7f0000000000/0x1000)=nil, (0x1000), 0x8, 0x32, 0xffffffffffffffff, 0x0)\n",
+		"mmap(&(0x7f0000000000/0x1000)=nil, (0x1000), 0x6, 0x32, 0xffffffffffffffff, 0x0)\n",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	if data := p.Serialize(); string(data) != string(data0) {
+		t.Fatalf("original program changed:\n%s", data)
+	}
+	// With the cap, the replacements are sampled evenly.
+	comps = CompMap{0x3: {0x7: true, 0x10: true}}
+	got = nil
+	p.MutateWithHints(0, comps, 2, func(p1 *Prog) {
+		got = append(got, string(p1.Serialize()))
+	})
+	want = []string{
+		"mmap(&(0x7f0000000000/0x1000)=nil, (0x1000), 0x7, 0x32, 0xffffffffffffffff, 0x0)\n",
+		"mmap(&(0x7f0000000000/0x1000)=nil, (0x1000), 0x10, 0x32, 0xffffffffffffffff, 0x0)\n",
+	}
+	// Operands are stored in a map, so the order of replacements is random.
+	sort.Strings(got)
+	sort.Strings(want)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
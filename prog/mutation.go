@@ -7,239 +7,478 @@ import (
 	"fmt"
 	"math"
 	"math/rand"
+	"sort"
+	"sync"
 	"unsafe"
 
 	"github.com/google/syzkaller/sys"
 )
 
+// Mutate mutates p using the built-in mutation strategies with default weights.
 func (p *Prog) Mutate(rs rand.Source, ncalls int, ct *ChoiceTable, corpus []*Prog) {
-	r := newRand(rs)
-
-	if r.oneOf(100) && corpus != nil {
-		// Splice with another prog from corpus.
-		p0 := corpus[r.Intn(len(corpus))]
-		p0c := p0.Clone()
-		idx := r.Intn(len(p.Calls))
-		p.Calls = append(p.Calls[:idx], append(p0c.Calls, p.Calls[idx:]...)...)
-	} else {
-		// Mutate current prog without splicing.
-		retry := false
-		for stop := false; !stop || retry; stop = r.oneOf(3) {
-			retry = false
-			switch {
-			case r.nOutOf(20, 31):
-				// Insert a new call.
-				if len(p.Calls) >= ncalls {
-					retry = true
-					continue
+	defaultMutator.Mutate(rs, p, ncalls, ct, corpus)
+}
+
+var defaultMutator = &Mutator{
+	strategies: []MutationStrategy{spliceStrategy{}, insertCallStrategy{}, mutateArgStrategy{},
+		removeCallStrategy{}, shuffleStrategy{}, resourceStrategy{}},
+	weights: []int{1, 60, 30, 3, 2, 4},
+}
+
+func init() {
+	for i, s := range defaultMutator.strategies {
+		RegisterMutationStrategy(s, defaultMutator.weights[i])
+	}
+}
+
+// spliceStrategy inserts all calls of a random corpus program into the program.
+// If coverage signatures are available, it prefers programs with coverage complementary to the program.
+type spliceStrategy struct{}
+
+func (spliceStrategy) Name() string { return "splice" }
+
+func (spliceStrategy) Mutate(rnd *rand.Rand, p *Prog, ctx *MutationContext) bool {
+	if len(ctx.Corpus) == 0 || len(p.Calls) == 0 {
+		return false
+	}
+	p0 := ctx.Corpus[chooseSpliceDonor(rnd, ctx)]
+	p0c := p0.Clone()
+	idx := rnd.Intn(len(p.Calls))
+	p.Calls = append(p.Calls[:idx], append(p0c.Calls, p.Calls[idx:]...)...)
+	return true
+}
+
+// chooseSpliceDonor returns index of a corpus program to splice.
+// It selects the program that overlaps least with the mutated program
+// among several randomly chosen ones (tournament selection), so that
+// uniformly random choice is still possible but less likely.
+func chooseSpliceDonor(rnd *rand.Rand, ctx *MutationContext) int {
+	best := rnd.Intn(len(ctx.Corpus))
+	if ctx.CorpusSigs == nil || ctx.Sig.Empty() {
+		return best
+	}
+	const candidates = 4
+	bestSim := ctx.Sig.Similarity(ctx.CorpusSigs[best])
+	for i := 1; i < candidates; i++ {
+		idx := rnd.Intn(len(ctx.Corpus))
+		if sim := ctx.Sig.Similarity(ctx.CorpusSigs[idx]); sim < bestSim {
+			best, bestSim = idx, sim
+		}
+	}
+	return best
+}
+
+// insertCallStrategy inserts a new call (biased towards the end of the program).
+type insertCallStrategy struct{}
+
+func (insertCallStrategy) Name() string { return "insert_call" }
+
+func (insertCallStrategy) Mutate(rnd *rand.Rand, p *Prog, ctx *MutationContext) bool {
+	if len(p.Calls) >= ctx.Ncalls {
+		return false
+	}
+	r := &randGen{Rand: rnd, target: p.target(), layout: addrLayout}
+	idx := r.biasedRand(len(p.Calls)+1, 5)
+	var c *Call
+	if idx < len(p.Calls) {
+		c = p.Calls[idx]
+	}
+	s := analyze(ctx.Ct, p, c)
+	calls := r.generateCall(s, p)
+	p.insertBefore(c, calls)
+	return true
+}
+
+// mutateArgStrategy changes args of a random call.
+type mutateArgStrategy struct{}
+
+func (mutateArgStrategy) Name() string { return "mutate_arg" }
+
+func (mutateArgStrategy) Mutate(rnd *rand.Rand, p *Prog, ctx *MutationContext) bool {
+	if len(p.Calls) == 0 {
+		return false
+	}
+	r := &randGen{Rand: rnd, target: p.target(), dict: ctx.Dict, layout: addrLayout}
+	return r.mutateCallArgs(p, p.Calls[r.Intn(len(p.Calls))], ctx.Ct)
+}
+
+// MutateCallArgs mutates arguments of the idx-th call of p
+// (e.g. to retry a call that failed due to invalid arguments).
+// New calls can be inserted before the call to produce resources for new arguments.
+// Returns false if the call has no arguments that can be mutated.
+func (p *Prog) MutateCallArgs(rs rand.Source, idx int, ct *ChoiceTable) bool {
+	r := newRand(rs, p.target())
+	if !r.mutateCallArgs(p, p.Calls[idx], ct) {
+		return false
+	}
+	p.dropComments()
+	for _, c := range p.Calls {
+		sanitizeCall(c)
+	}
+	if debug {
+		if err := p.validate(); err != nil {
+			panic(err)
+		}
+	}
+	return true
+}
+
+// CallResult is the outcome of execution of a call that is used to guide mutation.
+type CallResult int
+
+const (
+	CallNotExecuted CallResult = iota
+	CallRejected               // the call failed argument validation (e.g. with EINVAL)
+	CallFailed                 // the call was executed, but failed
+	CallSucceeded
+)
+
+// MutateRejected mutates arguments of a random call of p that was rejected at argument validation
+// (results[i] is the result of p.Calls[i] in the last execution of p).
+// Returns index of the mutated call in the mutated program,
+// or -1 if there are no rejected calls with arguments that can be mutated.
+func (p *Prog) MutateRejected(rs rand.Source, results []CallResult, ct *ChoiceTable) int {
+	var rejected []int
+	for i, res := range results {
+		if res == CallRejected && i < len(p.Calls) {
+			rejected = append(rejected, i)
+		}
+	}
+	r := newRand(rs, p.target())
+	for len(rejected) != 0 {
+		i := r.Intn(len(rejected))
+		c := p.Calls[rejected[i]]
+		if p.MutateCallArgs(rs, rejected[i], ct) {
+			// New calls can be inserted before the mutated call.
+			for idx := range p.Calls {
+				if p.Calls[idx] == c {
+					return idx
 				}
-				idx := r.biasedRand(len(p.Calls)+1, 5)
-				var c *Call
-				if idx < len(p.Calls) {
-					c = p.Calls[idx]
+			}
+		}
+		rejected = append(rejected[:i], rejected[i+1:]...)
+	}
+	return -1
+}
+
+func (r *randGen) mutateCallArgs(p *Prog, c *Call, ct *ChoiceTable) bool {
+	if len(c.Args) == 0 {
+		return false
+	}
+	if args, _ := mutationArgs(c); len(args) == 0 {
+		return false
+	}
+	s := analyze(ct, p, c)
+	for stop := false; !stop; stop = r.oneOf(3) {
+		args, bases := mutationArgs(c)
+		if len(args) == 0 {
+			break
+		}
+		idx := r.Intn(len(args))
+		arg, base := args[idx], bases[idx]
+		var baseSize uintptr
+		if base != nil {
+			if base.Kind != ArgPointer || base.Res == nil {
+				panic("bad base arg")
+			}
+			baseSize = base.Res.Size()
+		}
+		switch a := arg.Type.(type) {
+		case *sys.IntType, *sys.FlagsType, *sys.VarintType:
+			if r.bin() {
+				arg1, calls1 := r.generateArg(s, arg.Type)
+				p.replaceArg(c, arg, arg1, calls1)
+			} else {
+				switch {
+				case r.nOutOf(1, 3):
+					arg.Val += uintptr(r.Intn(4)) + 1
+				case r.nOutOf(1, 2):
+					arg.Val -= uintptr(r.Intn(4)) + 1
+				default:
+					arg.Val ^= 1 << uintptr(r.Intn(64))
 				}
-				s := analyze(ct, p, c)
-				calls := r.generateCall(s, p)
-				p.insertBefore(c, calls)
-			case r.nOutOf(10, 11):
-				// Change args of a call.
-				if len(p.Calls) == 0 {
-					retry = true
-					continue
+			}
+		case *sys.ResourceType, *sys.VmaType, *sys.ProcType:
+			arg1, calls1 := r.generateArg(s, arg.Type)
+			p.replaceArg(c, arg, arg1, calls1)
+		case *sys.BufferType:
+			switch a.Kind {
+			case sys.BufferBlobRand, sys.BufferBlobRange:
+				var data []byte
+				switch arg.Kind {
+				case ArgData:
+					data = append([]byte{}, arg.Data...)
+				case ArgConst:
+					// 0 is OK for optional args.
+					if arg.Val != 0 {
+						panic(fmt.Sprintf("BufferType has non-zero const value: %v", arg.Val))
+					}
+				default:
+					panic(fmt.Sprintf("bad arg kind for BufferType: %v", arg.Kind))
 				}
-				c := p.Calls[r.Intn(len(p.Calls))]
-				if len(c.Args) == 0 {
-					retry = true
-					continue
+				minLen := int(0)
+				maxLen := math.MaxInt32
+				if a.Kind == sys.BufferBlobRange {
+					minLen = int(a.RangeBegin)
+					maxLen = int(a.RangeEnd)
 				}
-				s := analyze(ct, p, c)
-				for stop := false; !stop; stop = r.oneOf(3) {
-					args, bases := mutationArgs(c)
-					if len(args) == 0 {
-						retry = true
-						continue
+				arg.Data = mutateData(r, data, minLen, maxLen)
+			case sys.BufferString:
+				if a.Charset != "" && !r.oneOf(10) {
+					arg.Data = r.mutateCharsetString(a, arg.Data)
+				} else if r.bin() {
+					minLen := int(0)
+					maxLen := math.MaxInt32
+					if a.Length != 0 {
+						minLen = int(a.Length)
+						maxLen = int(a.Length)
 					}
-					idx := r.Intn(len(args))
-					arg, base := args[idx], bases[idx]
-					var baseSize uintptr
-					if base != nil {
-						if base.Kind != ArgPointer || base.Res == nil {
-							panic("bad base arg")
-						}
-						baseSize = base.Res.Size()
-					}
-					switch a := arg.Type.(type) {
-					case *sys.IntType, *sys.FlagsType:
-						if r.bin() {
-							arg1, calls1 := r.generateArg(s, arg.Type)
-							p.replaceArg(c, arg, arg1, calls1)
-						} else {
-							switch {
-							case r.nOutOf(1, 3):
-								arg.Val += uintptr(r.Intn(4)) + 1
-							case r.nOutOf(1, 2):
-								arg.Val -= uintptr(r.Intn(4)) + 1
-							default:
-								arg.Val ^= 1 << uintptr(r.Intn(64))
-							}
-						}
-					case *sys.ResourceType, *sys.VmaType, *sys.ProcType:
-						arg1, calls1 := r.generateArg(s, arg.Type)
-						p.replaceArg(c, arg, arg1, calls1)
-					case *sys.BufferType:
-						switch a.Kind {
-						case sys.BufferBlobRand, sys.BufferBlobRange:
-							var data []byte
-							switch arg.Kind {
-							case ArgData:
-								data = append([]byte{}, arg.Data...)
-							case ArgConst:
-								// 0 is OK for optional args.
-								if arg.Val != 0 {
-									panic(fmt.Sprintf("BufferType has non-zero const value: %v", arg.Val))
-								}
-							default:
-								panic(fmt.Sprintf("bad arg kind for BufferType: %v", arg.Kind))
-							}
-							minLen := int(0)
-							maxLen := math.MaxInt32
-							if a.Kind == sys.BufferBlobRange {
-								minLen = int(a.RangeBegin)
-								maxLen = int(a.RangeEnd)
-							}
-							arg.Data = mutateData(r, data, minLen, maxLen)
-						case sys.BufferString:
-							if r.bin() {
-								minLen := int(0)
-								maxLen := math.MaxInt32
-								if a.Length != 0 {
-									minLen = int(a.Length)
-									maxLen = int(a.Length)
-								}
-								arg.Data = mutateData(r, append([]byte{}, arg.Data...), minLen, maxLen)
-							} else {
-								arg.Data = r.randString(s, a.Values, a.Dir())
-							}
-						case sys.BufferFilename:
-							arg.Data = []byte(r.filename(s))
-						case sys.BufferText:
-							arg.Data = r.mutateText(a.Text, arg.Data)
-						default:
-							panic("unknown buffer kind")
-						}
-					case *sys.ArrayType:
-						count := uintptr(0)
-						switch a.Kind {
-						case sys.ArrayRandLen:
-							for count == uintptr(len(arg.Inner)) {
-								count = r.randArrayLen()
-							}
-						case sys.ArrayRangeLen:
-							if a.RangeBegin == a.RangeEnd {
-								panic("trying to mutate fixed length array")
-							}
-							for count == uintptr(len(arg.Inner)) {
-								count = r.randRange(int(a.RangeBegin), int(a.RangeEnd))
-							}
-						}
-						if count > uintptr(len(arg.Inner)) {
-							var calls []*Call
-							for count > uintptr(len(arg.Inner)) {
-								arg1, calls1 := r.generateArg(s, a.Type)
-								arg.Inner = append(arg.Inner, arg1)
-								for _, c1 := range calls1 {
-									calls = append(calls, c1)
-									s.analyze(c1)
-								}
-							}
-							for _, c1 := range calls {
-								sanitizeCall(c1)
-							}
-							sanitizeCall(c)
-							p.insertBefore(c, calls)
-						} else if count < uintptr(len(arg.Inner)) {
-							for _, arg := range arg.Inner[count:] {
-								p.removeArg(c, arg)
-							}
-							arg.Inner = arg.Inner[:count]
-						}
-						// TODO: swap elements of the array
-					case *sys.PtrType:
-						// TODO: we don't know size for out args
-						size := uintptr(1)
-						if arg.Res != nil {
-							size = arg.Res.Size()
-						}
-						arg1, calls1 := r.addr(s, a, size, arg.Res)
-						p.replaceArg(c, arg, arg1, calls1)
-					case *sys.StructType:
-						ctor := isSpecialStruct(a)
-						if ctor == nil {
-							panic("bad arg returned by mutationArgs: StructType")
-						}
-						arg1, calls1 := ctor(r, s)
-						for i, f := range arg1.Inner {
-							p.replaceArg(c, arg.Inner[i], f, calls1)
-							calls1 = nil
-						}
-					case *sys.UnionType:
-						optType := a.Options[r.Intn(len(a.Options))]
-						maxIters := 1000
-						for i := 0; optType.FieldName() == arg.OptionType.FieldName(); i++ {
-							optType = a.Options[r.Intn(len(a.Options))]
-							if i >= maxIters {
-								panic(fmt.Sprintf("couldn't generate a different union option after %v iterations, type: %+v", maxIters, a))
-							}
-						}
-						p.removeArg(c, arg.Option)
-						opt, calls := r.generateArg(s, optType)
-						arg1 := unionArg(a, opt, optType)
-						p.replaceArg(c, arg, arg1, calls)
-					case *sys.LenType:
-						panic("bad arg returned by mutationArgs: LenType")
-					case *sys.CsumType:
-						panic("bad arg returned by mutationArgs: CsumType")
-					case *sys.ConstType:
-						panic("bad arg returned by mutationArgs: ConstType")
-					default:
-						panic(fmt.Sprintf("bad arg returned by mutationArgs: %#v, type=%#v", *arg, arg.Type))
-					}
-
-					// Update base pointer if size has increased.
-					if base != nil && baseSize < base.Res.Size() {
-						arg1, calls1 := r.addr(s, base.Type, base.Res.Size(), base.Res)
-						for _, c1 := range calls1 {
-							sanitizeCall(c1)
-						}
-						p.insertBefore(c, calls1)
-						arg.AddrPage = arg1.AddrPage
-						arg.AddrOffset = arg1.AddrOffset
-						arg.AddrPagesNum = arg1.AddrPagesNum
-					}
-
-					// Update all len fields.
-					assignSizesCall(c)
+					arg.Data = mutateData(r, append([]byte{}, arg.Data...), minLen, maxLen)
+				} else {
+					arg.Data = r.randString(s, a)
 				}
+			case sys.BufferFilename:
+				arg.Data = []byte(r.filename(s, a))
+			case sys.BufferText:
+				arg.Data = r.mutateText(a.Text, arg.Data)
 			default:
-				// Remove a random call.
-				if len(p.Calls) == 0 {
-					retry = true
-					continue
+				panic("unknown buffer kind")
+			}
+		case *sys.ArrayType:
+			if a.Unordered && len(arg.Inner) > 1 && r.oneOf(4) {
+				// Reorder elements (e.g. netlink attributes).
+				i, j := r.Intn(len(arg.Inner)), r.Intn(len(arg.Inner))
+				arg.Inner[i], arg.Inner[j] = arg.Inner[j], arg.Inner[i]
+				break
+			}
+			count := uintptr(0)
+			switch a.Kind {
+			case sys.ArrayRandLen:
+				for count == uintptr(len(arg.Inner)) {
+					count = r.randArrayLen()
+				}
+			case sys.ArrayRangeLen:
+				if a.RangeBegin == a.RangeEnd {
+					panic("trying to mutate fixed length array")
+				}
+				for count == uintptr(len(arg.Inner)) {
+					count = r.randRange(int(a.RangeBegin), int(a.RangeEnd))
+				}
+			}
+			if count > uintptr(len(arg.Inner)) {
+				var calls []*Call
+				for count > uintptr(len(arg.Inner)) {
+					arg1, calls1 := r.generateArg(s, a.Type)
+					arg.Inner = append(arg.Inner, arg1)
+					for _, c1 := range calls1 {
+						calls = append(calls, c1)
+						s.analyze(c1)
+					}
+				}
+				for _, c1 := range calls {
+					sanitizeCall(c1)
+				}
+				sanitizeCall(c)
+				p.insertBefore(c, calls)
+			} else if count < uintptr(len(arg.Inner)) {
+				for _, arg := range arg.Inner[count:] {
+					p.removeArg(c, arg)
+				}
+				arg.Inner = arg.Inner[:count]
+			}
+		case *sys.PtrType:
+			// TODO: we don't know size for out args
+			size := uintptr(1)
+			if arg.Res != nil {
+				size = arg.Res.Size()
+			}
+			arg1, calls1 := r.addr(s, a, size, arg.Res)
+			p.replaceArg(c, arg, arg1, calls1)
+		case *sys.StructType:
+			ctor := isSpecialStruct(a)
+			if ctor == nil {
+				panic("bad arg returned by mutationArgs: StructType")
+			}
+			arg1, calls1 := ctor(r, s)
+			for i, f := range arg1.Inner {
+				p.replaceArg(c, arg.Inner[i], f, calls1)
+				calls1 = nil
+			}
+		case *sys.UnionType:
+			optType := a.Options[r.Intn(len(a.Options))]
+			maxIters := 1000
+			for i := 0; optType.FieldName() == arg.OptionType.FieldName(); i++ {
+				optType = a.Options[r.Intn(len(a.Options))]
+				if i >= maxIters {
+					panic(fmt.Sprintf("couldn't generate a different union option after %v iterations, type: %+v", maxIters, a))
 				}
-				idx := r.Intn(len(p.Calls))
-				p.removeCall(idx)
 			}
+			p.removeArg(c, arg.Option)
+			opt, calls := r.generateArg(s, optType)
+			arg1 := unionArg(a, opt, optType)
+			p.replaceArg(c, arg, arg1, calls)
+		case *sys.CondType:
+			if len(arg.Inner) != 0 {
+				p.removeArg(c, arg.Inner[0])
+				arg.Inner = nil
+			} else {
+				// Alternative fields that depend on the same field are removed by assignConds below.
+				calls := r.generateCond(s, condParent(c, arg), arg)
+				for _, c1 := range calls {
+					sanitizeCall(c1)
+				}
+				p.insertBefore(c, calls)
+			}
+		case *sys.LenType:
+			panic("bad arg returned by mutationArgs: LenType")
+		case *sys.CsumType:
+			panic("bad arg returned by mutationArgs: CsumType")
+		case *sys.ConstType:
+			panic("bad arg returned by mutationArgs: ConstType")
+		default:
+			panic(fmt.Sprintf("bad arg returned by mutationArgs: %#v, type=%#v", *arg, arg.Type))
+		}
+
+		// Update base pointer if size has increased.
+		if base != nil && baseSize < base.Res.Size() {
+			arg1, calls1 := r.addr(s, base.Type, base.Res.Size(), base.Res)
+			for _, c1 := range calls1 {
+				sanitizeCall(c1)
+			}
+			p.insertBefore(c, calls1)
+			arg.AddrPage = arg1.AddrPage
+			arg.AddrOffset = arg1.AddrOffset
+			arg.AddrPagesNum = arg1.AddrPagesNum
 		}
+
+		// Remove conditional fields that don't match their controlling fields anymore
+		// and update all len fields.
+		p.assignConds(c)
+		assignSizesCall(p.target(), c)
 	}
+	return true
+}
 
-	for _, c := range p.Calls {
-		sanitizeCall(c)
+// removeCallStrategy removes a random call.
+type removeCallStrategy struct{}
+
+func (removeCallStrategy) Name() string { return "remove_call" }
+
+func (removeCallStrategy) Mutate(rnd *rand.Rand, p *Prog, ctx *MutationContext) bool {
+	if len(p.Calls) == 0 {
+		return false
 	}
-	if debug {
-		if err := p.validate(); err != nil {
-			panic(err)
+	idx := rnd.Intn(len(p.Calls))
+	p.removeCall(idx)
+	return true
+}
+
+// shuffleStrategy swaps two adjacent calls if the second one does not use results of the first one.
+type shuffleStrategy struct{}
+
+func (shuffleStrategy) Name() string { return "shuffle" }
+
+func (shuffleStrategy) Mutate(rnd *rand.Rand, p *Prog, ctx *MutationContext) bool {
+	if len(p.Calls) < 2 {
+		return false
+	}
+	idx := rnd.Intn(len(p.Calls) - 1)
+	c0, c1 := p.Calls[idx], p.Calls[idx+1]
+	results := make(map[*Arg]bool)
+	foreachArgArray(&c0.Args, c0.Ret, func(arg, _ *Arg, _ *[]*Arg) {
+		results[arg] = true
+	})
+	uses := false
+	foreachArg(c1, func(arg, _ *Arg, _ *[]*Arg) {
+		if arg.Kind == ArgResult && results[arg.Res] {
+			uses = true
+		}
+	})
+	if uses {
+		return false
+	}
+	p.Calls[idx], p.Calls[idx+1] = c1, c0
+	return true
+}
+
+// resourceStrategy inserts a call that consumes a resource right after a call
+// that produces the resource. This focuses fuzzing on operations on existing resources.
+type resourceStrategy struct{}
+
+func (resourceStrategy) Name() string { return "resource" }
+
+func (resourceStrategy) Mutate(rnd *rand.Rand, p *Prog, ctx *MutationContext) bool {
+	if len(p.Calls) == 0 || len(p.Calls) >= ctx.Ncalls {
+		return false
+	}
+	r := &randGen{Rand: rnd, target: p.target(), layout: addrLayout}
+	type producer struct {
+		idx  int
+		kind string
+	}
+	var producers []producer
+	for i, c := range p.Calls {
+		foreachArgArray(&c.Args, c.Ret, func(arg, _ *Arg, _ *[]*Arg) {
+			if typ, ok := arg.Type.(*sys.ResourceType); ok && typ.Dir() != sys.DirIn {
+				producers = append(producers, producer{i, typ.Desc.Name})
+			}
+		})
+	}
+	if len(producers) == 0 {
+		return false
+	}
+	pr := producers[r.Intn(len(producers))]
+	// Iterate over kinds in a fixed order, so that the mutation is determined by the random source.
+	consumers := resourceConsumers()
+	var kinds []string
+	for kind := range consumers {
+		if sys.IsCompatibleResource(kind, pr.kind) {
+			kinds = append(kinds, kind)
+		}
+	}
+	sort.Strings(kinds)
+	var metas []*sys.Call
+	for _, kind := range kinds {
+		for _, meta := range consumers[kind] {
+			if ctx.Ct == nil || ctx.Ct.run[meta.ID] != nil {
+				metas = append(metas, meta)
+			}
 		}
 	}
+	if len(metas) == 0 {
+		return false
+	}
+	var c *Call
+	if pr.idx+1 < len(p.Calls) {
+		c = p.Calls[pr.idx+1]
+	}
+	s := analyze(ctx.Ct, p, c)
+	calls := r.generateParticularCall(s, metas[r.Intn(len(metas))])
+	p.insertBefore(c, calls)
+	return true
+}
+
+var (
+	resourceConsumersOnce sync.Once
+	resourceConsumersMap  map[string][]*sys.Call
+)
+
+// resourceConsumers returns a map from resource kind to calls that accept the resource as input.
+func resourceConsumers() map[string][]*sys.Call {
+	resourceConsumersOnce.Do(func() {
+		resourceConsumersMap = make(map[string][]*sys.Call)
+		for _, meta := range sys.Calls {
+			dedup := make(map[string]bool)
+			for _, res := range meta.InputResources() {
+				if dedup[res.Desc.Name] {
+					continue
+				}
+				dedup[res.Desc.Name] = true
+				resourceConsumersMap[res.Desc.Name] = append(resourceConsumersMap[res.Desc.Name], meta)
+			}
+		}
+	})
+	return resourceConsumersMap
 }
 
 // Minimize minimizes program p into an equivalent program using the equivalence
@@ -247,6 +486,57 @@ func (p *Prog) Mutate(rs rand.Source, ncalls int, ct *ChoiceTable, corpus []*Pro
 // whether it is equal to the orginal program or not. If it is equivalent then
 // the simplification attempt is committed and the process continues.
 func Minimize(p0 *Prog, callIndex0 int, pred func(*Prog, int) bool, crash bool) (*Prog, int) {
+	return MinimizeWith(p0, callIndex0, MinimizeOptions{Pred: pred, Crash: crash})
+}
+
+// MinimizeCost returns cost of a program (e.g. number of calls, size or execution time),
+// lower is better.
+type MinimizeCost func(p *Prog) int
+
+// CostCalls is a MinimizeCost that counts calls in the program.
+func CostCalls(p *Prog) int {
+	return len(p.Calls)
+}
+
+// CostSize is a MinimizeCost that returns size of the serialized program.
+func CostSize(p *Prog) int {
+	return len(p.Serialize())
+}
+
+// MinimizeOptions describe what minimization needs to preserve and what it needs to minimize.
+type MinimizeOptions struct {
+	// Pred says if the simplified program is still equivalent to the original one
+	// (e.g. still reproduces the crash or still covers the PC). Required.
+	Pred func(p *Prog, callIndex int) bool
+	// Cost, if set, rejects simplifications that increase cost of the program.
+	// It is checked before Pred, so that pred is not invoked for such programs.
+	Cost MinimizeCost
+	// Crash says that the program is a crash reproducer, in such case minimization
+	// does not try to simplify args that are unlikely to affect the crash.
+	Crash bool
+}
+
+// MinimizeWith is like Minimize, but accepts arbitrary predicate and cost function.
+func MinimizeWith(p0 *Prog, callIndex0 int, opts MinimizeOptions) (*Prog, int) {
+	crash := opts.Crash
+	cost0 := 0
+	if opts.Cost != nil {
+		cost0 = opts.Cost(p0)
+	}
+	pred := func(p *Prog, callIndex int) bool {
+		cost := 0
+		if opts.Cost != nil {
+			if cost = opts.Cost(p); cost > cost0 {
+				return false
+			}
+		}
+		if !opts.Pred(p, callIndex) {
+			return false
+		}
+		cost0 = cost
+		return true
+	}
+
 	name0 := ""
 	if callIndex0 != -1 {
 		if callIndex0 < 0 || callIndex0 >= len(p0.Calls) {
@@ -289,6 +579,12 @@ func Minimize(p0 *Prog, callIndex0 int, pred func(*Prog, int) bool, crash bool)
 		}
 	}
 
+	// Try to remove all cleanup calls (resource destructors) at once.
+	if p, callIndex := removeDestructors(p0, callIndex0); p != nil && pred(p, callIndex) {
+		p0 = p
+		callIndex0 = callIndex
+	}
+
 	// Try to remove all calls except the last one one-by-one.
 	for i := len(p0.Calls) - 1; i >= 0; i-- {
 		if i == callIndex0 {
@@ -328,6 +624,10 @@ func Minimize(p0 *Prog, callIndex0 int, pred func(*Prog, int) bool, crash bool)
 			if arg.Res != nil {
 				return rec(p, call, arg.Res, path)
 			}
+		case *sys.CondType:
+			if len(arg.Inner) != 0 {
+				return rec(p, call, arg.Inner[0], path)
+			}
 		case *sys.ArrayType:
 			for i, innerArg := range arg.Inner {
 				innerPath := fmt.Sprintf("%v-%v", path, i)
@@ -337,7 +637,7 @@ func Minimize(p0 *Prog, callIndex0 int, pred func(*Prog, int) bool, crash bool)
 						copy(arg.Inner[i:], arg.Inner[i+1:])
 						arg.Inner = arg.Inner[:len(arg.Inner)-1]
 						p.removeArg(call, innerArg)
-						assignSizesCall(call)
+						assignSizesCall(p.target(), call)
 
 						if pred(p, callIndex0) {
 							p0 = p
@@ -352,7 +652,7 @@ func Minimize(p0 *Prog, callIndex0 int, pred func(*Prog, int) bool, crash bool)
 					return true
 				}
 			}
-		case *sys.IntType, *sys.FlagsType, *sys.ResourceType, *sys.ProcType:
+		case *sys.IntType, *sys.FlagsType, *sys.ResourceType, *sys.ProcType, *sys.VarintType:
 			// TODO: try to reset bits in ints
 			// TODO: try to set separate flags
 			if crash {
@@ -367,6 +667,11 @@ func Minimize(p0 *Prog, callIndex0 int, pred func(*Prog, int) bool, crash bool)
 			}
 			v0 := arg.Val
 			arg.Val = typ.Default()
+			if !condsMatch(call) {
+				// The arg controls a present conditional field.
+				arg.Val = v0
+				return false
+			}
 			if pred(p, callIndex0) {
 				p0 = p
 				return true
@@ -386,12 +691,12 @@ func Minimize(p0 *Prog, callIndex0 int, pred func(*Prog, int) bool, crash bool)
 			for step := len(arg.Data) - minLen; len(arg.Data) > minLen && step > 0; {
 				if len(arg.Data)-step >= minLen {
 					arg.Data = arg.Data[:len(arg.Data)-step]
-					assignSizesCall(call)
+					assignSizesCall(p.target(), call)
 					if pred(p, callIndex0) {
 						continue
 					}
 					arg.Data = arg.Data[:len(arg.Data)+step]
-					assignSizesCall(call)
+					assignSizesCall(p.target(), call)
 				}
 				step /= 2
 				if crash {
@@ -430,6 +735,26 @@ func Minimize(p0 *Prog, callIndex0 int, pred func(*Prog, int) bool, crash bool)
 	return p0, callIndex0
 }
 
+// removeDestructors returns a copy of p0 without destructor calls (except for the call callIndex),
+// or nil if p0 does not contain any.
+func removeDestructors(p0 *Prog, callIndex int) (*Prog, int) {
+	p := p0.Clone()
+	removed := false
+	for i := len(p.Calls) - 1; i >= 0; i-- {
+		if i != callIndex && sys.IsDestructor(p.Calls[i].Meta) {
+			p.removeCall(i)
+			if i < callIndex {
+				callIndex--
+			}
+			removed = true
+		}
+	}
+	if !removed {
+		return nil, callIndex
+	}
+	return p, callIndex
+}
+
 func (p *Prog) TrimAfter(idx int) {
 	if idx < 0 || idx >= len(p.Calls) {
 		panic("trimming non-existing call")
@@ -446,7 +771,7 @@ func (p *Prog) TrimAfter(idx int) {
 }
 
 func mutationArgs(c *Call) (args, bases []*Arg) {
-	foreachArg(c, func(arg, base *Arg, _ *[]*Arg) {
+	foreachArg(c, func(arg, base *Arg, parent *[]*Arg) {
 		switch typ := arg.Type.(type) {
 		case *sys.StructType:
 			if isSpecialStruct(typ) == nil {
@@ -468,6 +793,12 @@ func mutationArgs(c *Call) (args, bases []*Arg) {
 		case *sys.ConstType:
 			// Well, this is const.
 			return
+		case *sys.CondType:
+			// Conditional fields are added/removed, but an absent field
+			// can be added only if we can change the controlling field.
+			if len(arg.Inner) == 0 && !canSetCond(*parent, arg) {
+				return
+			}
 		case *sys.BufferType:
 			if typ.Kind == sys.BufferString && len(typ.Values) == 1 {
 				return // string const
@@ -533,11 +864,15 @@ func swap64(v uint64) uint64 {
 
 func mutateData(r *randGen, data []byte, minLen, maxLen int) []byte {
 	const maxInc = 35
+	ops := 13
+	if r.dict.Len() != 0 {
+		ops++
+	}
 	retry := false
 loop:
 	for stop := false; !stop || retry; stop = r.oneOf(3) {
 		retry = false
-		switch r.Intn(13) {
+		switch r.Intn(ops) {
 		case 0:
 			// Append byte.
 			if len(data) >= maxLen {
@@ -685,6 +1020,14 @@ loop:
 				value = swap64(value)
 			}
 			*(*uint64)(unsafe.Pointer(&data[i])) = value
+		case 13:
+			// Insert a dictionary token.
+			data1 := insertToken(r, data, r.dict.choose(r), maxLen)
+			if data1 == nil {
+				retry = true
+				continue loop
+			}
+			data = data1
 		default:
 			panic("bad")
 		}
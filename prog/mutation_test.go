@@ -6,6 +6,8 @@ package prog
 import (
 	"bytes"
 	"fmt"
+	"math/rand"
+	"reflect"
 	"testing"
 )
 
@@ -46,6 +48,73 @@ next:
 	}
 }
 
+func TestMutateCallArgs(t *testing.T) {
+	rs, iters := initTest(t)
+	for i := 0; i < iters; i++ {
+		p := Generate(rs, 10, nil)
+		for idx := range p.Calls {
+			p1 := p.Clone()
+			c := p1.Calls[idx]
+			if !p1.MutateCallArgs(rs, idx, nil) {
+				continue
+			}
+			if err := p1.validate(); err != nil {
+				t.Fatalf("invalid program after mutation of call %v: %v\n%s", idx, err, p1.Serialize())
+			}
+			found := false
+			for _, c1 := range p1.Calls {
+				found = found || c1 == c
+			}
+			if !found {
+				t.Fatalf("mutated call %v is removed from program:\n%s", idx, p1.Serialize())
+			}
+		}
+	}
+	p, err := Deserialize([]byte("getpid()\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.MutateCallArgs(rs, 0, nil) {
+		t.Fatalf("mutated call without arguments")
+	}
+}
+
+func TestMutateRejected(t *testing.T) {
+	rs, iters := initTest(t)
+	for i := 0; i < iters; i++ {
+		p := Generate(rs, 10, nil)
+		results := make([]CallResult, len(p.Calls))
+		for idx := range results {
+			results[idx] = CallResult(rand.New(rs).Intn(4))
+		}
+		p1 := p.Clone()
+		orig := make(map[*Call]bool)
+		for idx, c := range p1.Calls {
+			orig[c] = results[idx] == CallRejected
+		}
+		idx := p1.MutateRejected(rs, results, nil)
+		if idx == -1 {
+			continue
+		}
+		if !orig[p1.Calls[idx]] {
+			t.Fatalf("mutated call %v that was not rejected:\n%s", idx, p1.Serialize())
+		}
+		if err := p1.validate(); err != nil {
+			t.Fatalf("invalid program after mutation: %v\n%s", err, p1.Serialize())
+		}
+	}
+	p, err := Deserialize([]byte("getpid()\nsyz_test$int(0x0, 0x0, 0x0, 0x0, 0x0)\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx := p.MutateRejected(rs, []CallResult{CallRejected, CallFailed}, nil); idx != -1 {
+		t.Fatalf("mutated call %v without arguments or not rejected", idx)
+	}
+	if idx := p.MutateRejected(rs, []CallResult{CallSucceeded, CallRejected}, nil); idx != 1 {
+		t.Fatalf("mutated call %v, want 1", idx)
+	}
+}
+
 func TestMutateTable(t *testing.T) {
 	tests := [][2]string{
 		// Insert calls.
@@ -148,6 +217,42 @@ nextTest:
 	}
 }
 
+func TestMutateUnorderedArrays(t *testing.T) {
+	rs, _ := initTest(t)
+	// Elements are swapped only in arrays of netlink attributes.
+	tests := []struct {
+		orig    string
+		swapped string
+		canSwap bool
+	}{
+		{
+			"syz_test$nla0(&(0x7f0000000000)=[@a0={0x5, 0x1, 0x0, 0x0, 0x42}, @a1={0x8, 0x2, 0x0, 0x0, 0x43}])\n",
+			"syz_test$nla0(&(0x7f0000000000)=[@a1={0x8, 0x2, 0x0, 0x0, 0x43}, @a0={0x5, 0x1, 0x0, 0x0, 0x42}])\n",
+			true,
+		},
+		{
+			"writev(0xffffffffffffffff, &(0x7f0000000000)=[{&(0x7f0000001000)=\"00\", 0x1}, {&(0x7f0000002000)=\"01\", 0x1}], 0x2)\n",
+			"writev(0xffffffffffffffff, &(0x7f0000000000)=[{&(0x7f0000002000)=\"01\", 0x1}, {&(0x7f0000001000)=\"00\", 0x1}], 0x2)\n",
+			false,
+		},
+	}
+	for i, test := range tests {
+		p, err := Deserialize([]byte(test.orig))
+		if err != nil {
+			t.Fatalf("test #%v: failed to deserialize program: %v", i, err)
+		}
+		swapped := false
+		for iter := 0; iter < 10000 && !swapped; iter++ {
+			p1 := p.Clone()
+			p1.MutateCallArgs(rs, 0, nil)
+			swapped = string(p1.Serialize()) == test.swapped
+		}
+		if swapped != test.canSwap {
+			t.Fatalf("test #%v: swapped %v, want %v", i, swapped, test.canSwap)
+		}
+	}
+}
+
 func TestMinimize(t *testing.T) {
 	tests := []struct {
 		orig            string
@@ -190,6 +295,21 @@ func TestMinimize(t *testing.T) {
 				"pipe2(&(0x7f0000000000)={0xffffffffffffffff, 0xffffffffffffffff}, 0x0)\n",
 			1,
 		},
+		// Remove all cleanup calls at once.
+		{
+			"mmap(&(0x7f0000000000/0x1000)=nil, (0x1000), 0x3, 0x32, 0xffffffffffffffff, 0x0)\n" +
+				"pipe2(&(0x7f0000000000)={<r0=>0xffffffffffffffff, <r1=>0xffffffffffffffff}, 0x0)\n" +
+				"close(r0)\n" +
+				"close(r1)\n",
+			1,
+			func(p *Prog, callIndex int) bool {
+				// Removal of a single close is not accepted.
+				return len(p.Calls) == 2 && p.Calls[0].Meta.Name == "mmap" && p.Calls[1].Meta.Name == "pipe2"
+			},
+			"mmap(&(0x7f0000000000/0x1000)=nil, (0x1000), 0x0, 0x0, 0xffffffffffffffff, 0x0)\n" +
+				"pipe2(&(0x7f0000000000)={0xffffffffffffffff, 0xffffffffffffffff}, 0x0)\n",
+			1,
+		},
 		// Remove two dependent calls.
 		{
 			"mmap(&(0x7f0000000000/0x1000)=nil, (0x1000), 0x3, 0x32, 0xffffffffffffffff, 0x0)\n" +
@@ -274,6 +394,41 @@ func TestMinimize(t *testing.T) {
 	}
 }
 
+func TestMinimizeCost(t *testing.T) {
+	p, err := Deserialize([]byte("mmap(&(0x7f0000000000/0x1000)=nil, (0x1000), 0x3, 0x32, 0xffffffffffffffff, 0x0)\n" +
+		"sched_yield()\n" +
+		"getpid()\n" +
+		"pipe2(&(0x7f0000000000)={0xffffffffffffffff, 0xffffffffffffffff}, 0x0)\n"))
+	if err != nil {
+		t.Fatalf("failed to deserialize program: %v", err)
+	}
+	preds := 0
+	p1, callIndex := MinimizeWith(p, 3, MinimizeOptions{
+		Pred: func(p *Prog, callIndex int) bool {
+			preds++
+			return true
+		},
+		// Pretend that the program runs slower without getpid.
+		Cost: func(p *Prog) int {
+			cost := len(p.Calls)
+			for _, c := range p.Calls {
+				if c.Meta.Name == "getpid" {
+					return cost
+				}
+			}
+			return cost + 10
+		},
+	})
+	want := "getpid()\n" +
+		"pipe2(&(0x7f0000000000)={0xffffffffffffffff, 0xffffffffffffffff}, 0x0)\n"
+	if got := string(p1.Serialize()); got != want || callIndex != 1 {
+		t.Fatalf("got program (call %v):\n%v\nwant (call 1):\n%v", callIndex, got, want)
+	}
+	if preds == 0 {
+		t.Fatalf("predicate was not called")
+	}
+}
+
 func TestMinimizeRandom(t *testing.T) {
 	rs, iters := initTest(t)
 	for i := 0; i < iters; i++ {
@@ -307,3 +462,53 @@ func TestMinimizeRandom(t *testing.T) {
 		}, false)
 	}
 }
+
+func TestParseDictionary(t *testing.T) {
+	data := []byte(`
+# comment
+"GET"
+magic="\x53\xef"
+  quote = "a\"b\\"
+`)
+	tokens, err := ParseDictionary(data)
+	if err != nil {
+		t.Fatalf("failed to parse dictionary: %v", err)
+	}
+	want := [][]byte{[]byte("GET"), {0x53, 0xef}, []byte("a\"b\\")}
+	if !reflect.DeepEqual(tokens, want) {
+		t.Fatalf("bad tokens: %q, want %q", tokens, want)
+	}
+	for _, bad := range []string{"GET", "\"", "x=\"\\x5\"", "\"a\"b\"", "\"\\q\""} {
+		if _, err := ParseDictionary([]byte(bad)); err == nil {
+			t.Fatalf("parsed bad dictionary %q", bad)
+		}
+	}
+	dict := NewDictionary(tokens)
+	if n := dict.Add([]byte("GET"), []byte("POST"), nil); n != 1 || dict.Len() != 4 {
+		t.Fatalf("added %v tokens, dictionary has %v tokens", n, dict.Len())
+	}
+}
+
+func TestMutateDataDictionary(t *testing.T) {
+	rs, _ := initTest(t)
+	tok := []byte("syzkaller-token")
+	r := newRand(rs, defaultTarget)
+	r.dict = NewDictionary([][]byte{tok})
+	found := false
+	for i := 0; i < 1000 && !found; i++ {
+		data := mutateData(r, []byte("0123456789abcdef"), 0, 32)
+		if len(data) > 32 {
+			t.Fatalf("data is longer than max len: %q", data)
+		}
+		found = bytes.Contains(data, tok)
+	}
+	if !found {
+		t.Fatalf("dictionary token was not inserted")
+	}
+	// Fixed-length data must not grow.
+	for i := 0; i < 100; i++ {
+		if data := mutateData(r, []byte("01234567"), 8, 8); len(data) != 8 {
+			t.Fatalf("fixed-length data changed size: %q", data)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package prog
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+)
+
+// MutationStrategy is a single program mutation technique (e.g. insertion of a new call).
+// Besides the built-in strategies, out-of-tree strategies can be registered
+// with RegisterMutationStrategy and then enabled by name in a Mutator.
+type MutationStrategy interface {
+	// Name returns a unique name of the strategy used to refer to it in configs.
+	Name() string
+	// Mutate mutates p in place. If the strategy is not applicable to p
+	// (e.g. there are no calls to remove), it returns false and leaves p unchanged.
+	Mutate(r *rand.Rand, p *Prog, ctx *MutationContext) bool
+}
+
+// MutationContext contains additional information available to mutation strategies.
+type MutationContext struct {
+	Ncalls     int          // max number of calls in a program
+	Ct         *ChoiceTable // enabled calls and their priorities (can be nil)
+	Corpus     []*Prog      // programs that can be used for splicing (can be nil)
+	CorpusSigs []CoverSig   // coverage signatures of Corpus programs (can be nil)
+	Sig        CoverSig     // coverage signature of the mutated program (can be empty)
+	Dict       *Dictionary  // tokens that are inserted into buffers (can be nil)
+}
+
+type registeredStrategy struct {
+	strategy MutationStrategy
+	weight   int
+}
+
+var mutationStrategies = make(map[string]registeredStrategy)
+
+// RegisterMutationStrategy makes a mutation strategy available to NewMutator.
+// The weight is used when the strategy is not explicitly mentioned in weights passed to NewMutator
+// (0 means that the strategy is disabled by default).
+func RegisterMutationStrategy(s MutationStrategy, weight int) {
+	if _, ok := mutationStrategies[s.Name()]; ok {
+		panic(fmt.Sprintf("mutation strategy %v is registered twice", s.Name()))
+	}
+	if weight < 0 {
+		panic(fmt.Sprintf("mutation strategy %v has negative weight %v", s.Name(), weight))
+	}
+	mutationStrategies[s.Name()] = registeredStrategy{s, weight}
+}
+
+// MutationStrategies returns names of all registered mutation strategies.
+func MutationStrategies() []string {
+	var names []string
+	for name := range mutationStrategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Mutator mutates programs with a set of strategies chosen randomly according to their weights.
+type Mutator struct {
+	strategies []MutationStrategy
+	weights    []int
+	dict       *Dictionary
+}
+
+// NewMutator creates a mutator with the given strategy weights (strategy name -> weight).
+// If weights is non-empty, only the strategies mentioned in it are used,
+// otherwise all registered strategies are used with default weights.
+func NewMutator(weights map[string]int) (*Mutator, error) {
+	m := new(Mutator)
+	if len(weights) == 0 {
+		weights = make(map[string]int)
+		for name, rs := range mutationStrategies {
+			weights[name] = rs.weight
+		}
+	}
+	for _, name := range MutationStrategies() {
+		w, ok := weights[name]
+		if !ok || w == 0 {
+			continue
+		}
+		if w < 0 {
+			return nil, fmt.Errorf("mutation strategy %v has negative weight %v", name, w)
+		}
+		m.strategies = append(m.strategies, mutationStrategies[name].strategy)
+		m.weights = append(m.weights, w)
+	}
+	for name := range weights {
+		if _, ok := mutationStrategies[name]; !ok {
+			return nil, fmt.Errorf("unknown mutation strategy %v", name)
+		}
+	}
+	if len(m.strategies) == 0 {
+		return nil, fmt.Errorf("no mutation strategies enabled")
+	}
+	return m, nil
+}
+
+// SetDictionary sets dictionary of tokens that are inserted into string and blob arguments.
+// The dictionary can be extended while the mutator is in use.
+func (m *Mutator) SetDictionary(dict *Dictionary) {
+	m.dict = dict
+}
+
+// Mutate applies a random number of randomly chosen strategies to p.
+func (m *Mutator) Mutate(rs rand.Source, p *Prog, ncalls int, ct *ChoiceTable, corpus []*Prog) {
+	m.MutateWithCover(rs, p, CoverSig{}, ncalls, ct, corpus, nil)
+}
+
+// MutateWithCover is like Mutate, but additionally accepts coverage signatures of p
+// and of corpus programs (sigs[i] corresponds to corpus[i]).
+// This allows splicing to prefer programs with coverage complementary to p.
+func (m *Mutator) MutateWithCover(rs rand.Source, p *Prog, sig CoverSig, ncalls int, ct *ChoiceTable,
+	corpus []*Prog, sigs []CoverSig) {
+	if sigs != nil && len(sigs) != len(corpus) {
+		panic(fmt.Sprintf("got %v coverage signatures for %v corpus programs", len(sigs), len(corpus)))
+	}
+	p.dropComments()
+	r := newRand(rs, p.target())
+	ctx := &MutationContext{
+		Ncalls:     ncalls,
+		Ct:         ct,
+		Corpus:     corpus,
+		CorpusSigs: sigs,
+		Sig:        sig,
+		Dict:       m.dict,
+	}
+	total := 0
+	for _, w := range m.weights {
+		total += w
+	}
+	// Strategies can be not applicable to the program, so we retry in such case.
+	// But all enabled strategies can be not applicable (e.g. only call removal
+	// is enabled and the program is empty), so limit the number of attempts.
+	const maxAttempts = 100
+	for attempt, stop := 0, false; !stop && attempt < maxAttempts; attempt++ {
+		x := r.Intn(total)
+		i := 0
+		for ; x >= m.weights[i]; i++ {
+			x -= m.weights[i]
+		}
+		if m.strategies[i].Mutate(r.Rand, p, ctx) {
+			stop = r.oneOf(3)
+		}
+	}
+
+	for _, c := range p.Calls {
+		sanitizeCall(c)
+	}
+	if debug {
+		if err := p.validate(); err != nil {
+			panic(err)
+		}
+	}
+}
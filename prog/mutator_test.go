@@ -0,0 +1,153 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package prog
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestMutatorStrategies(t *testing.T) {
+	rs, iters := initTest(t)
+	for _, name := range MutationStrategies() {
+		m, err := NewMutator(map[string]int{name: 1})
+		if err != nil {
+			t.Fatalf("failed to create mutator for %v: %v", name, err)
+		}
+		for i := 0; i < iters/10; i++ {
+			p := Generate(rs, 10, nil)
+			m.Mutate(rs, p, 20, nil, []*Prog{Generate(rs, 5, nil)})
+			if err := p.validate(); err != nil {
+				t.Fatalf("strategy %v produced invalid program: %v\n%s", name, err, p.Serialize())
+			}
+			data := p.Serialize()
+			if _, err := Deserialize(data); err != nil {
+				t.Fatalf("strategy %v produced program that can't be deserialized: %v\n%s", name, err, data)
+			}
+		}
+	}
+}
+
+func TestMutatorShuffle(t *testing.T) {
+	p, err := Deserialize([]byte("getpid()\ngettid()\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewMutator(map[string]int{"shuffle": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs, _ := initTest(t)
+	m.Mutate(rs, p, 10, nil, nil)
+	// An even number of swaps restores the original order.
+	if data := string(p.Serialize()); data != "gettid()\ngetpid()\n" && data != "getpid()\ngettid()\n" {
+		t.Fatalf("unexpected program after shuffle:\n%s", data)
+	}
+	p, err = Deserialize([]byte("r0 = getpid()\ntkill(r0, 0x9)\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Mutate(rs, p, 10, nil, nil)
+	if data := string(p.Serialize()); data != "r0 = getpid()\ntkill(r0, 0x9)\n" {
+		t.Fatalf("shuffle moved a call before its resource producer:\n%s", data)
+	}
+}
+
+func TestMutatorResourceDeterministic(t *testing.T) {
+	p, err := Deserialize([]byte("r0 = open(&(0x7f0000000000)=\"2e2f66696c653000\", 0x0, 0x0)\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewMutator(map[string]int{"resource": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for seed := int64(0); seed < 100; seed++ {
+		p0, p1 := p.Clone(), p.Clone()
+		m.Mutate(rand.NewSource(seed), p0, 10, nil, nil)
+		m.Mutate(rand.NewSource(seed), p1, 10, nil, nil)
+		if data0, data1 := p0.Serialize(), p1.Serialize(); !bytes.Equal(data0, data1) {
+			t.Fatalf("seed=%v: different programs:\n%s\n\n%s", seed, data0, data1)
+		}
+	}
+}
+
+func TestMutatorErrors(t *testing.T) {
+	tests := []map[string]int{
+		{"foo": 1},
+		{"splice": -1},
+		{"splice": 0},
+	}
+	for i, weights := range tests {
+		if _, err := NewMutator(weights); err == nil {
+			t.Errorf("test #%v: no error for weights %v", i, weights)
+		}
+	}
+}
+
+func TestCoverSig(t *testing.T) {
+	var empty CoverSig
+	if !empty.Empty() || !MakeCoverSig(nil).Empty() {
+		t.Fatalf("empty signature is not empty")
+	}
+	var pcs0, pcs1, pcs2 []uint32
+	for pc := uint32(0x81000000); pc < 0x81000000+4000; pc += 4 {
+		pcs0 = append(pcs0, pc)
+		pcs1 = append(pcs1, pc+4000)
+		if pc%8 == 0 {
+			pcs2 = append(pcs2, pc)
+		}
+	}
+	sig0, sig1, sig2 := MakeCoverSig(pcs0), MakeCoverSig(pcs1), MakeCoverSig(pcs2)
+	if sig0.Empty() {
+		t.Fatalf("non-empty signature is empty")
+	}
+	if sim := sig0.Similarity(sig0); sim != 1 {
+		t.Fatalf("similarity of equal coverage is %v, want 1", sim)
+	}
+	if sim := sig0.Similarity(sig1); sim != 0 {
+		t.Fatalf("similarity of disjoint coverage is %v, want 0", sim)
+	}
+	if sim := sig0.Similarity(sig2); sim <= 0 || sim >= 1 {
+		t.Fatalf("similarity of overlapping coverage is %v, want (0, 1)", sim)
+	}
+	if sim := sig0.Similarity(empty); sim != 0 {
+		t.Fatalf("similarity with empty signature is %v, want 0", sim)
+	}
+}
+
+func TestMutatorSpliceCover(t *testing.T) {
+	p, err := Deserialize([]byte("getpid()\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	similar, err := Deserialize([]byte("gettid()\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	complementary, err := Deserialize([]byte("sched_yield()\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := MakeCoverSig([]uint32{1, 2, 3, 4, 5, 6, 7, 8})
+	corpus := []*Prog{similar, complementary}
+	sigs := []CoverSig{sig, MakeCoverSig([]uint32{11, 12, 13, 14, 15, 16, 17, 18})}
+	m, err := NewMutator(map[string]int{"splice": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs, _ := initTest(t)
+	counts := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		p1 := p.Clone()
+		m.MutateWithCover(rs, p1, sig, 10, nil, corpus, sigs)
+		for _, c := range p1.Calls {
+			counts[c.Meta.Name]++
+		}
+	}
+	if counts["sched_yield"] <= 2*counts["gettid"] {
+		t.Fatalf("complementary program is not preferred: %v", counts)
+	}
+}
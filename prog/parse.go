@@ -14,6 +14,10 @@ type LogEntry struct {
 	Proc  int // index of parallel proc
 	Start int // start offset in log
 	End   int // end offset in log
+
+	Fault     bool // program was executed with fault injection
+	FaultCall int  // call index for fault injection
+	FaultNth  int  // fault n-th operation in the call
 }
 
 func ParseLog(data []byte) []*LogEntry {
@@ -46,6 +50,12 @@ func ParseLog(data []byte) []*LogEntry {
 				Proc:  proc,
 				Start: pos0,
 			}
+			// Fault injection parameters are printed as "(fault-call:N fault-nth:M)".
+			if faultCall, ok := extractInt(line, "fault-call:"); ok {
+				ent.Fault = true
+				ent.FaultCall = faultCall
+				ent.FaultNth, _ = extractInt(line, "fault-nth:")
+			}
 			cur = nil
 			continue
 		}
@@ -66,3 +76,20 @@ func ParseLog(data []byte) []*LogEntry {
 	}
 	return entries
 }
+
+func extractInt(line []byte, prefix string) (int, bool) {
+	pos := bytes.Index(line, []byte(prefix))
+	if pos == -1 {
+		return 0, false
+	}
+	pos += len(prefix)
+	end := pos
+	for end != len(line) && line[end] >= '0' && line[end] <= '9' {
+		end++
+	}
+	v, err := strconv.Atoi(string(line[pos:end]))
+	if err != nil {
+		return 0, false
+	}
+	return v, true
+}
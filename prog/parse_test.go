@@ -89,3 +89,25 @@ getpid()
 2015/12/21 12:18:05 executing program 9:
 munlockall()
 `
+
+func TestParseFault(t *testing.T) {
+	const execLog = `2015/12/21 12:18:05 executing program 1 (fault-call:1 fault-nth:55):
+gettid()
+getpid()
+`
+	entries := ParseLog([]byte(execLog))
+	if len(entries) != 1 {
+		t.Fatalf("got %v programs, want 1", len(entries))
+	}
+	ent := entries[0]
+	if !ent.Fault || ent.FaultCall != 1 || ent.FaultNth != 55 {
+		t.Fatalf("bad fault injection parameters: fault=%v call=%v nth=%v, want true/1/55",
+			ent.Fault, ent.FaultCall, ent.FaultNth)
+	}
+	if ent.Proc != 1 {
+		t.Fatalf("proc %v, want 1", ent.Proc)
+	}
+	if s := ent.P.String(); s != "gettid-getpid" {
+		t.Fatalf("bad program: %s", s)
+	}
+}
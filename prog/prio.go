@@ -4,6 +4,7 @@
 package prog
 
 import (
+	"encoding/json"
 	"fmt"
 	"math/rand"
 	"sort"
@@ -27,8 +28,14 @@ import (
 // constants.
 
 func CalculatePriorities(corpus []*Prog) [][]float32 {
+	return CalculatePrioritiesFrom(LearnPrioTable(corpus))
+}
+
+// CalculatePrioritiesFrom is like CalculatePriorities, but the dynamic component
+// is taken from the learned table (e.g. a persisted one or a merge of several tables).
+func CalculatePrioritiesFrom(table *PrioTable) [][]float32 {
 	static := calcStaticPriorities()
-	dynamic := calcDynamicPrio(corpus)
+	dynamic := calcDynamicPrio(table)
 	for i, prios := range static {
 		for j, p := range prios {
 			dynamic[i][j] *= p
@@ -107,7 +114,14 @@ func calcStaticPriorities() [][]float32 {
 	for i := range prios {
 		prios[i] = make([]float32, len(sys.Calls))
 	}
-	for _, calls := range uses {
+	// Sum in a fixed order, so that float rounding does not depend on map iteration order.
+	ids := make([]string, 0, len(uses))
+	for id := range uses {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		calls := uses[id]
 		for c0, w0 := range calls {
 			for c1, w1 := range calls {
 				if c0 == c1 {
@@ -134,23 +148,127 @@ func calcStaticPriorities() [][]float32 {
 	return prios
 }
 
-func calcDynamicPrio(corpus []*Prog) [][]float32 {
+func calcDynamicPrio(table *PrioTable) [][]float32 {
 	prios := make([][]float32, len(sys.Calls))
 	for i := range prios {
 		prios[i] = make([]float32, len(sys.Calls))
+		copy(prios[i], table.Counts[i])
 	}
+	normalizePrio(prios)
+	return prios
+}
+
+// PrioTable is the learned (dynamic) component of call-to-call priorities.
+// Counts[X][Y] is the number of corpus programs that contain both calls X and Y
+// (X and Y are call IDs, rows of calls that were never seen are nil).
+// The serialized form identifies calls by names rather than IDs, so the table can be persisted
+// across manager restarts and shared between managers (even with different descriptions).
+type PrioTable struct {
+	Counts [][]float32
+}
+
+// NewPrioTable returns an empty table.
+func NewPrioTable() *PrioTable {
+	return &PrioTable{Counts: make([][]float32, len(sys.Calls))}
+}
+
+// LearnPrioTable calculates the table from corpus.
+func LearnPrioTable(corpus []*Prog) *PrioTable {
+	table := NewPrioTable()
 	for _, p := range corpus {
-		for i0 := 0; i0 < len(p.Calls); i0++ {
-			for i1 := 0; i1 < len(p.Calls); i1++ {
+		for i0, c0 := range p.Calls {
+			for i1, c1 := range p.Calls {
 				if i0 == i1 {
 					continue
 				}
-				prios[i0][i1] += 1.0
+				table.add(c0.Meta.ID, c1.Meta.ID, 1)
 			}
 		}
 	}
-	normalizePrio(prios)
-	return prios
+	return table
+}
+
+func (table *PrioTable) add(id0, id1 int, n float32) {
+	counts := table.Counts[id0]
+	if counts == nil {
+		counts = make([]float32, len(sys.Calls))
+		table.Counts[id0] = counts
+	}
+	counts[id1] += n
+}
+
+// Calls returns the number of calls that have learned priorities.
+func (table *PrioTable) Calls() int {
+	n := 0
+	for _, counts := range table.Counts {
+		if counts != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// Merge adds counts of table1 scaled by weight to the table.
+// Weight < 1 allows to gradually decay old knowledge when a table
+// is repeatedly merged into a freshly learned one.
+func (table *PrioTable) Merge(table1 *PrioTable, weight float32) {
+	for id0, counts := range table1.Counts {
+		for id1, n := range counts {
+			if n != 0 {
+				table.add(id0, id1, n*weight)
+			}
+		}
+	}
+}
+
+// serializedPrioTable is the persistent representation of PrioTable.
+type serializedPrioTable struct {
+	Counts map[string]map[string]float32
+}
+
+// Serialize returns persistent representation of the table.
+func (table *PrioTable) Serialize() []byte {
+	st := serializedPrioTable{Counts: make(map[string]map[string]float32)}
+	for id0, counts := range table.Counts {
+		for id1, n := range counts {
+			if n == 0 {
+				continue
+			}
+			name0 := sys.Calls[id0].Name
+			if st.Counts[name0] == nil {
+				st.Counts[name0] = make(map[string]float32)
+			}
+			st.Counts[name0][sys.Calls[id1].Name] = n
+		}
+	}
+	data, err := json.Marshal(st)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// DeserializePrioTable parses table serialized with PrioTable.Serialize.
+// Calls that are not present in the current descriptions are ignored.
+func DeserializePrioTable(data []byte) (*PrioTable, error) {
+	st := new(serializedPrioTable)
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, fmt.Errorf("failed to parse priority table: %v", err)
+	}
+	table := NewPrioTable()
+	for name0, counts := range st.Counts {
+		c0 := sys.CallMap[name0]
+		if c0 == nil {
+			// The table was learned with different descriptions.
+			continue
+		}
+		for name1, n := range counts {
+			if c1 := sys.CallMap[name1]; c1 != nil && n != 0 {
+				table.add(c0.ID, c1.ID, n)
+			}
+		}
+	}
+	return table, nil
 }
 
 // normalizePrio assigns some minimal priorities to calls with zero priority,
@@ -207,8 +325,10 @@ func BuildChoiceTable(prios [][]float32, enabled map[*sys.Call]bool) *ChoiceTabl
 		}
 	}
 	var enabledCalls []*sys.Call
-	for c := range enabled {
-		enabledCalls = append(enabledCalls, c)
+	for _, c := range sys.Calls {
+		if enabled[c] {
+			enabledCalls = append(enabledCalls, c)
+		}
 	}
 	run := make([][]int, len(sys.Calls))
 	for i := range run {
@@ -10,13 +10,16 @@ import (
 )
 
 type Prog struct {
-	Calls []*Call
+	Calls    []*Call
+	Comments []string // program annotations, see annotation.go
+	Target   *Target  // nil means the default target
 }
 
 type Call struct {
-	Meta *sys.Call
-	Args []*Arg
-	Ret  *Arg
+	Meta     *sys.Call
+	Args     []*Arg
+	Ret      *Arg
+	Comments []string // call annotations, see annotation.go
 }
 
 type Arg struct {
@@ -68,11 +71,22 @@ func (a *Arg) InnerArg() *Arg {
 	}
 }
 
+// encodeVarint returns unsigned LEB128 encoding of v.
+func encodeVarint(v uintptr) []byte {
+	var data []byte
+	for ; v >= 0x80; v >>= 7 {
+		data = append(data, byte(v)|0x80)
+	}
+	return append(data, byte(v))
+}
+
 func encodeValue(value, size uintptr, bigEndian bool) uintptr {
 	if !bigEndian {
 		return value
 	}
 	switch size {
+	case 1:
+		return value
 	case 2:
 		return uintptr(swap16(uint16(value)))
 	case 4:
@@ -86,20 +100,27 @@ func encodeValue(value, size uintptr, bigEndian bool) uintptr {
 
 // Returns value taking endianness into consideration.
 func (a *Arg) Value(pid int) uintptr {
+	return a.value(pid, true)
+}
+
+// value returns value of the arg, big-endian fields are byte-swapped if swap is set.
+// Values are stored by the executor in native (little-endian) byte order,
+// so the swapped value is what needs to be stored.
+func (a *Arg) value(pid int, swap bool) uintptr {
 	switch typ := a.Type.(type) {
 	case *sys.IntType:
-		return encodeValue(a.Val, typ.Size(), typ.BigEndian)
+		return encodeValue(a.Val, typ.Size(), typ.BigEndian && swap)
 	case *sys.ConstType:
-		return encodeValue(a.Val, typ.Size(), typ.BigEndian)
+		return encodeValue(a.Val, typ.Size(), typ.BigEndian && swap)
 	case *sys.FlagsType:
-		return encodeValue(a.Val, typ.Size(), typ.BigEndian)
+		return encodeValue(a.Val, typ.Size(), typ.BigEndian && swap)
 	case *sys.LenType:
-		return encodeValue(a.Val, typ.Size(), typ.BigEndian)
+		return encodeValue(a.Val, typ.Size(), typ.BigEndian && swap)
 	case *sys.CsumType:
-		return encodeValue(a.Val, typ.Size(), typ.BigEndian)
+		return encodeValue(a.Val, typ.Size(), typ.BigEndian && swap)
 	case *sys.ProcType:
 		val := uintptr(typ.ValuesStart) + uintptr(typ.ValuesPerProc)*uintptr(pid) + a.Val
-		return encodeValue(val, typ.Size(), typ.BigEndian)
+		return encodeValue(val, typ.Size(), typ.BigEndian && swap)
 	}
 	return a.Val
 }
@@ -111,6 +132,8 @@ func (a *Arg) Size() uintptr {
 		return typ.Size()
 	case *sys.BufferType:
 		return uintptr(len(a.Data))
+	case *sys.VarintType:
+		return uintptr(len(encodeVarint(a.Val)))
 	case *sys.StructType:
 		var size uintptr
 		for _, fld := range a.Inner {
@@ -133,7 +156,7 @@ func (a *Arg) Size() uintptr {
 		} else {
 			return a.Option.Size()
 		}
-	case *sys.ArrayType:
+	case *sys.ArrayType, *sys.CondType:
 		var size uintptr
 		for _, in := range a.Inner {
 			size += in.Size()
@@ -249,6 +272,12 @@ func (p *Prog) removeArg(c *Call, arg0 *Arg) {
 	})
 }
 
+// RemoveCall removes call idx from p,
+// uses of resources created by the call are replaced with default resource values.
+func (p *Prog) RemoveCall(idx int) {
+	p.removeCall(idx)
+}
+
 // removeCall removes call idx from p.
 func (p *Prog) removeCall(idx int) {
 	c := p.Calls[idx]
@@ -6,6 +6,8 @@ package prog
 import (
 	"bytes"
 	"math/rand"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -60,7 +62,7 @@ func TestSerialize(t *testing.T) {
 func TestVmaType(t *testing.T) {
 	rs, iters := initTest(t)
 	meta := sys.CallMap["syz_test$vma0"]
-	r := newRand(rs)
+	r := newRand(rs, defaultTarget)
 	for i := 0; i < iters; i++ {
 		s := newState(nil)
 		calls := r.generateParticularCall(s, meta)
@@ -90,3 +92,249 @@ func TestVmaType(t *testing.T) {
 		check(c.Args[4], c.Args[5], 7, 9)
 	}
 }
+
+func TestEncodeVarint(t *testing.T) {
+	tests := []struct {
+		v    uintptr
+		data []byte
+	}{
+		{0, []byte{0x00}},
+		{1, []byte{0x01}},
+		{0x7f, []byte{0x7f}},
+		{0x80, []byte{0x80, 0x01}},
+		{0x96, []byte{0x96, 0x01}},
+		{0x3fff, []byte{0xff, 0x7f}},
+		{0x4000, []byte{0x80, 0x80, 0x01}},
+		{624485, []byte{0xe5, 0x8e, 0x26}},
+		{^uintptr(0), []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}},
+	}
+	for _, test := range tests {
+		if data := encodeVarint(test.v); !bytes.Equal(data, test.data) {
+			t.Errorf("value 0x%x: got %x, want %x", test.v, data, test.data)
+		}
+	}
+}
+
+func TestGenerateCleanup(t *testing.T) {
+	p, err := Deserialize([]byte("mmap(&(0x7f0000000000/0x1000)=nil, (0x1000), 0x3, 0x32, 0xffffffffffffffff, 0x0)\n" +
+		"pipe2(&(0x7f0000000000)={<r0=>0xffffffffffffffff, <r1=>0xffffffffffffffff}, 0x0)\n" +
+		"close(r0)\n"))
+	if err != nil {
+		t.Fatalf("failed to deserialize program: %v", err)
+	}
+	rs, _ := initTest(t)
+	r := newRand(rs, defaultTarget)
+	s := analyze(nil, p, nil)
+	p.Calls = append(p.Calls, r.generateCleanup(s, p)...)
+	if err := p.validate(); err != nil {
+		t.Fatalf("invalid program: %v", err)
+	}
+	want := "mmap(&(0x7f0000000000/0x1000)=nil, (0x1000), 0x3, 0x32, 0xffffffffffffffff, 0x0)\n" +
+		"pipe2(&(0x7f0000000000)={<r0=>0xffffffffffffffff, <r1=>0xffffffffffffffff}, 0x0)\n" +
+		"close(r0)\n" +
+		"close(r1)\n"
+	if got := string(p.Serialize()); got != want {
+		t.Fatalf("got program:\n%v\nwant:\n%v", got, want)
+	}
+}
+
+func TestRemoveCall(t *testing.T) {
+	p, err := Deserialize([]byte("mmap(&(0x7f0000000000/0x1000)=nil, (0x1000), 0x3, 0x32, 0xffffffffffffffff, 0x0)\n" +
+		"pipe2(&(0x7f0000000000)={<r0=>0xffffffffffffffff, <r1=>0xffffffffffffffff}, 0x0)\n" +
+		"close(r0)\n" +
+		"close(r1)\n"))
+	if err != nil {
+		t.Fatalf("failed to deserialize program: %v", err)
+	}
+	p.RemoveCall(1)
+	if err := p.validate(); err != nil {
+		t.Fatalf("invalid program: %v", err)
+	}
+	want := "mmap(&(0x7f0000000000/0x1000)=nil, (0x1000), 0x3, 0x32, 0xffffffffffffffff, 0x0)\n" +
+		"close(0xffffffffffffffff)\n" +
+		"close(0xffffffffffffffff)\n"
+	if got := string(p.Serialize()); got != want {
+		t.Fatalf("got program:\n%v\nwant:\n%v", got, want)
+	}
+}
+
+func TestGenerateSeeded(t *testing.T) {
+	t.Parallel()
+	iters := 100
+	if testing.Short() {
+		iters = 10
+	}
+	ct := BuildChoiceTable(CalculatePriorities(nil), nil)
+	for i := 0; i < iters; i++ {
+		seed := uint64(time.Now().UnixNano())
+		p0 := GenerateSeeded(seed, 20, ct).Serialize()
+		p1 := GenerateSeeded(seed, 20, ct).Serialize()
+		if !bytes.Equal(p0, p1) {
+			t.Fatalf("seed=%v: generated different programs:\n%s\n\n%s", seed, p0, p1)
+		}
+	}
+}
+
+func TestSeededSourceGolden(t *testing.T) {
+	// The values must not change, otherwise seeds recorded on other hosts
+	// (or by older versions) produce different programs.
+	tests := []struct {
+		seed uint64
+		want int64
+	}{
+		{0, -8319337860079373151},
+		{1, -8069370110018099473},
+		{42, 1714521153096771163},
+		{1 << 63, 9101144649283204391},
+	}
+	for _, test := range tests {
+		if got := seededSource("rev", test.seed); got != test.want {
+			t.Errorf("seed %v: got %v, want %v", test.seed, got, test.want)
+		}
+	}
+}
+
+func TestGenerateSeededGolden(t *testing.T) {
+	// The program is determined by the seed and the descriptions only, so it must be the same
+	// in every process (e.g. it must not depend on map iteration order).
+	// Update goldenRevision and the program when descriptions change.
+	const goldenRevision = "35803777479bf929e225f1f4666576787a8f6c81"
+	if sys.Revision != goldenRevision {
+		t.Skipf("descriptions changed (revision %v), golden program needs to be updated", sys.Revision)
+	}
+	want := `mmap(&(0x7f0000000000/0x1000)=nil, (0x1000), 0x3, 0x32, 0xffffffffffffffff, 0x0)
+r0 = syz_open_dev$usbmon(&(0x7f0000001000-0xd)="2f6465762f7573626d6f6e2300", 0x2, 0x101000)
+connect$nfc_raw(r0, &(0x7f0000f36000)={0x27, 0x7, 0x90c2, 0x3}, 0x10)
+ioctl$KVM_SMI(r0, 0xaeb7)
+openat$irnet(0xffffffffffffff9c, &(0x7f0000001000-0xb)="2f6465762f69726e657400", 0x101400, 0x0)
+`
+	ct := BuildChoiceTable(CalculatePriorities(nil), nil)
+	if got := string(GenerateSeeded(21, 5, ct).Serialize()); got != want {
+		t.Fatalf("got program:\n%v\nwant:\n%v", got, want)
+	}
+}
+
+func TestGenerateCall(t *testing.T) {
+	rs, _ := initTest(t)
+	ct := BuildChoiceTable(CalculatePriorities(nil), nil)
+	for _, meta := range sys.Calls {
+		p := GenerateCall(rs, meta, ct)
+		if last := p.Calls[len(p.Calls)-1]; last.Meta != meta {
+			t.Fatalf("generated program for %v ends with %v:\n%s", meta.Name, last.Meta.Name, p.Serialize())
+		}
+	}
+}
+
+func TestTargets(t *testing.T) {
+	for _, arch := range Arches() {
+		target, err := GetTarget(arch)
+		if err != nil {
+			t.Fatalf("failed to get target %v: %v", arch, err)
+		}
+		if target.Arch != arch || target.PtrSize != 8 || target.PageSize == 0 {
+			t.Fatalf("bad target %v: %+v", arch, *target)
+		}
+	}
+	if _, err := GetTarget("foo"); err == nil {
+		t.Fatalf("got target for unknown arch")
+	}
+	if DefaultTarget() == nil {
+		t.Fatalf("no default target")
+	}
+}
+
+func TestPrioTable(t *testing.T) {
+	var corpus []*Prog
+	for _, text := range []string{
+		"getpid()\ngettid()\n",
+		"getpid()\npipe(&(0x7f0000000000)={0x0, 0x0})\n",
+	} {
+		p, err := Deserialize([]byte(text))
+		if err != nil {
+			t.Fatalf("failed to deserialize: %v", err)
+		}
+		corpus = append(corpus, p)
+	}
+	table := LearnPrioTable(corpus)
+	getpid, gettid, pipe := sys.CallMap["getpid"].ID, sys.CallMap["gettid"].ID, sys.CallMap["pipe"].ID
+	want := NewPrioTable()
+	want.add(getpid, gettid, 1)
+	want.add(getpid, pipe, 1)
+	want.add(gettid, getpid, 1)
+	want.add(pipe, getpid, 1)
+	if !reflect.DeepEqual(table, want) || table.Calls() != 3 {
+		t.Fatalf("bad learned table")
+	}
+	table1, err := DeserializePrioTable(table.Serialize())
+	if err != nil {
+		t.Fatalf("failed to deserialize table: %v", err)
+	}
+	if !reflect.DeepEqual(table1, table) {
+		t.Fatalf("table changed after serialization")
+	}
+	if !reflect.DeepEqual(CalculatePrioritiesFrom(table1), CalculatePriorities(corpus)) {
+		t.Fatalf("priorities of deserialized table differ")
+	}
+	table.Merge(table1, 0.5)
+	if table.Counts[getpid][gettid] != 1.5 || table.Counts[pipe][getpid] != 1.5 || table.Calls() != 3 {
+		t.Fatalf("bad merged table")
+	}
+	// Unknown calls must be ignored.
+	table2, err := DeserializePrioTable([]byte(`{"Counts":{"foo$bar":{"getpid":1},"getpid":{"foo$bar":1,"gettid":2}}}`))
+	if err != nil {
+		t.Fatalf("failed to deserialize table: %v", err)
+	}
+	if table2.Calls() != 1 || table2.Counts[getpid][gettid] != 2 {
+		t.Fatalf("bad table with unknown calls")
+	}
+}
+
+func TestCharsetStrings(t *testing.T) {
+	rs, iters := initTest(t)
+	r := newRand(rs, defaultTarget)
+	s := newState(nil)
+	for _, name := range []string{"syz_test$strchars0", "syz_test$strchars1"} {
+		typ := sys.CallMap[name].Args[0].(*sys.PtrType).Type.(*sys.BufferType)
+		check := func(data []byte) {
+			if len(data) == 0 || data[len(data)-1] != 0 {
+				t.Fatalf("%v: string %q is not zero-terminated", name, data)
+			}
+			str := data[:len(data)-1]
+			if n := uintptr(len(str)); n < typ.MinLen || n > typ.MaxLen {
+				t.Fatalf("%v: string %q length is out of range [%v, %v]", name, str, typ.MinLen, typ.MaxLen)
+			}
+			for _, ch := range str {
+				if strings.IndexByte(typ.Charset, ch) == -1 {
+					t.Fatalf("%v: string %q contains char %q not from charset %q", name, str, ch, typ.Charset)
+				}
+			}
+		}
+		for i := 0; i < iters; i++ {
+			data := r.randString(s, typ)
+			check(data)
+			check(r.mutateCharsetString(typ, data))
+		}
+	}
+}
+
+func TestFilenamePool(t *testing.T) {
+	rs, iters := initTest(t)
+	r := newRand(rs, defaultTarget)
+	s := newState(nil)
+	typ := sys.CallMap["syz_test$filename_pool"].Args[0].(*sys.PtrType).Type.(*sys.BufferType)
+	if f := r.filename(s, typ); f == "" {
+		t.Fatalf("empty filename without pool paths")
+	}
+	SetFilenamePool(typ.SubKind, []string{"/dev/null", "/dev/zero"})
+	defer SetFilenamePool(typ.SubKind, nil)
+	pooled := 0
+	for i := 0; i < iters; i++ {
+		switch r.filename(s, typ) {
+		case "/dev/null\x00", "/dev/zero\x00":
+			pooled++
+		}
+	}
+	if pooled < iters/2 {
+		t.Fatalf("only %v out of %v filenames are from pool", pooled, iters)
+	}
+}
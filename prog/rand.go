@@ -8,9 +8,11 @@ import (
 	"fmt"
 	"math"
 	"math/rand"
+	"sort"
 	"strings"
 	"sync"
 
+	"github.com/google/syzkaller/ebpf"
 	"github.com/google/syzkaller/ifuzz"
 	"github.com/google/syzkaller/sys"
 )
@@ -19,11 +21,14 @@ var pageStartPool = sync.Pool{New: func() interface{} { return new([]uintptr) }}
 
 type randGen struct {
 	*rand.Rand
+	target           *Target
+	dict             *Dictionary // tokens for buffer mutation (can be nil)
+	layout           AddrLayout
 	inCreateResource bool
 }
 
-func newRand(rs rand.Source) *randGen {
-	return &randGen{rand.New(rs), false}
+func newRand(rs rand.Source, target *Target) *randGen {
+	return &randGen{Rand: rand.New(rs), target: target, layout: addrLayout}
 }
 
 func (r *randGen) rand(n int) uintptr {
@@ -98,7 +103,7 @@ func (r *randGen) randRangeInt(begin int64, end int64) uintptr {
 // probability of n-1 is k times higher than probability of 0.
 func (r *randGen) biasedRand(n, k int) int {
 	nf, kf := float64(n), float64(k)
-	rf := nf * (kf/2 + 1) * rand.Float64()
+	rf := nf * (kf/2 + 1) * r.Float64()
 	bf := (-1 + math.Sqrt(1+2*kf*rf/nf)) * nf / kf
 	return int(bf)
 }
@@ -148,14 +153,38 @@ func (r *randGen) flags(vv []uintptr) (v uintptr) {
 	return
 }
 
-func (r *randGen) filename(s *state) string {
-	// TODO: support procfs and sysfs
+var filenamePools = struct {
+	sync.RWMutex
+	paths map[string][]string
+}{paths: make(map[string][]string)}
+
+// SetFilenamePool sets existing paths (e.g. /dev entries discovered on the target)
+// that filename arguments of the pool (see sys.FilenamePools) are chosen from.
+func SetFilenamePool(pool string, paths []string) {
+	filenamePools.Lock()
+	defer filenamePools.Unlock()
+	filenamePools.paths[pool] = append([]string{}, paths...)
+}
+
+func (r *randGen) poolFilename(pool string) string {
+	filenamePools.RLock()
+	defer filenamePools.RUnlock()
+	paths := filenamePools.paths[pool]
+	if len(paths) == 0 {
+		return ""
+	}
+	return paths[r.Intn(len(paths))] + "\x00"
+}
+
+func (r *randGen) filename(s *state, typ *sys.BufferType) string {
+	if typ.SubKind != "" && !r.oneOf(10) {
+		if f := r.poolFilename(typ.SubKind); f != "" {
+			return f
+		}
+	}
 	dir := "."
 	if r.oneOf(2) && len(s.files) != 0 {
-		files := make([]string, 0, len(s.files))
-		for f := range s.files {
-			files = append(files, f)
-		}
+		files := sortedKeys(s.files)
 		dir = files[r.Intn(len(files))]
 		if len(dir) > 0 && dir[len(dir)-1] == 0 {
 			dir = dir[:len(dir)-1]
@@ -170,16 +199,18 @@ func (r *randGen) filename(s *state) string {
 			}
 		}
 	}
-	files := make([]string, 0, len(s.files))
-	for f := range s.files {
-		files = append(files, f)
-	}
+	files := sortedKeys(s.files)
 	return files[r.Intn(len(files))]
 }
 
-func (r *randGen) randString(s *state, vals []string, dir sys.Dir) []byte {
-	data := r.randStringImpl(s, vals)
-	if dir == sys.DirOut {
+func (r *randGen) randString(s *state, typ *sys.BufferType) []byte {
+	var data []byte
+	if typ.Charset != "" {
+		data = r.randCharsetString(typ)
+	} else {
+		data = r.randStringImpl(s, typ.Values)
+	}
+	if typ.Dir() == sys.DirOut {
 		for i := range data {
 			data[i] = 0
 		}
@@ -187,16 +218,59 @@ func (r *randGen) randString(s *state, vals []string, dir sys.Dir) []byte {
 	return data
 }
 
+// randCharsetString generates a string of typ.Charset characters with length in [MinLen, MaxLen].
+// Short strings are more likely, but the boundary lengths are frequent as well.
+func (r *randGen) randCharsetString(typ *sys.BufferType) []byte {
+	n := typ.MinLen
+	switch span := int(typ.MaxLen - typ.MinLen); {
+	case span == 0:
+	case r.oneOf(10):
+		n = typ.MaxLen
+	case r.oneOf(10):
+	default:
+		// biasedRand prefers larger values, we want shorter strings.
+		n += uintptr(span - r.biasedRand(span+1, 10))
+	}
+	data := make([]byte, n, n+1)
+	for i := range data {
+		data[i] = typ.Charset[r.Intn(len(typ.Charset))]
+	}
+	return append(data, 0)
+}
+
+// mutateCharsetString inserts, removes or replaces a character in a typ.Charset string
+// keeping it within the charset and the length limits.
+func (r *randGen) mutateCharsetString(typ *sys.BufferType, data []byte) []byte {
+	if r.oneOf(3) {
+		return r.randCharsetString(typ)
+	}
+	str := append([]byte{}, data...)
+	if len(str) != 0 && str[len(str)-1] == 0 {
+		str = str[:len(str)-1]
+	}
+	ch := typ.Charset[r.Intn(len(typ.Charset))]
+	switch n := uintptr(len(str)); {
+	case n < typ.MaxLen && r.bin():
+		i := r.Intn(len(str) + 1)
+		str = append(str[:i], append([]byte{ch}, str[i:]...)...)
+	case n > typ.MinLen && r.bin():
+		i := r.Intn(len(str))
+		str = append(str[:i], str[i+1:]...)
+	case n != 0:
+		str[r.Intn(len(str))] = ch
+	default:
+		return r.randCharsetString(typ)
+	}
+	return append(str, 0)
+}
+
 func (r *randGen) randStringImpl(s *state, vals []string) []byte {
 	if len(vals) != 0 {
 		return []byte(vals[r.Intn(len(vals))])
 	}
 	if len(s.strings) != 0 && r.bin() {
 		// Return an existing string.
-		strings := make([]string, 0, len(s.strings))
-		for s := range s.strings {
-			strings = append(strings, s)
-		}
+		strings := sortedKeys(s.strings)
 		return []byte(strings[r.Intn(len(strings))])
 	}
 	dict := []string{"user", "keyring", "trusted", "system", "security", "selinux",
@@ -278,7 +352,7 @@ func (r *randGen) timespec(s *state, typ *sys.StructType, usec bool) (arg *Arg,
 			constArg(argType.Fields[1], 0),
 		})
 		var tpaddr *Arg
-		tpaddr, calls = r.addr(s, ptrArgType, 2*ptrSize, tp)
+		tpaddr, calls = r.addr(s, ptrArgType, 2*r.target.PtrSize, tp)
 		gettime := &Call{
 			Meta: meta,
 			Args: []*Arg{
@@ -320,26 +394,16 @@ func createMmapCall(start, npages uintptr) *Call {
 }
 
 func (r *randGen) addr1(s *state, typ sys.Type, size uintptr, data *Arg) (*Arg, []*Call) {
-	npages := (size + pageSize - 1) / pageSize
+	npages := (size + r.target.PageSize - 1) / r.target.PageSize
 	if npages == 0 {
 		npages = 1
 	}
 	if r.bin() {
 		return r.randPageAddr(s, typ, npages, data, false), nil
 	}
-	for i := uintptr(0); i < maxPages-npages; i++ {
-		free := true
-		for j := uintptr(0); j < npages; j++ {
-			if s.pages[i+j] {
-				free = false
-				break
-			}
-		}
-		if !free {
-			continue
-		}
-		c := createMmapCall(i, npages)
-		return pointerArg(typ, i, 0, 0, data), []*Call{c}
+	if page, ok := r.allocPages(s, npages); ok {
+		c := createMmapCall(page, npages)
+		return pointerArg(typ, page, 0, 0, data), []*Call{c}
 	}
 	return r.randPageAddr(s, typ, npages, data, false), nil
 }
@@ -355,12 +419,15 @@ func (r *randGen) addr(s *state, typ sys.Type, size uintptr, data *Arg) (*Arg, [
 	case r.nOutOf(50, 52):
 		arg.AddrOffset = -int(size)
 	case r.nOutOf(1, 2):
-		arg.AddrOffset = r.Intn(pageSize)
+		arg.AddrOffset = r.Intn(int(r.target.PageSize))
 	default:
 		if size > 0 {
 			arg.AddrOffset = -r.Intn(int(size))
 		}
 	}
+	if r.layout.Unaligned && r.oneOf(4) {
+		r.misalign(arg)
+	}
 	return arg, calls
 }
 
@@ -382,14 +449,16 @@ func (r *randGen) randPageAddr(s *state, typ sys.Type, npages uintptr, data *Arg
 		}
 		starts = append(starts, i)
 	}
-	*poolPtr = starts
-	pageStartPool.Put(poolPtr)
 	var page uintptr
 	if len(starts) != 0 {
 		page = starts[r.rand(len(starts))]
 	} else {
 		page = r.rand(int(maxPages - npages))
 	}
+	// Return the buffer to the pool only after we've used it,
+	// otherwise a concurrent generation can overwrite starts.
+	*poolPtr = starts
+	pageStartPool.Put(poolPtr)
 	if !vma {
 		npages = 0
 	}
@@ -413,6 +482,7 @@ func (r *randGen) createResource(s *state, res *sys.ResourceType) (arg *Arg, cal
 				all = append(all, kind1)
 			}
 		}
+		sort.Strings(all)
 		kind = all[r.Intn(len(all))]
 	}
 	// Find calls that produce the necessary resources.
@@ -438,9 +508,9 @@ func (r *randGen) createResource(s *state, res *sys.ResourceType) (arg *Arg, cal
 		s1.analyze(calls[len(calls)-1])
 		// Now see if we have what we want.
 		var allres []*Arg
-		for kind1, res1 := range s1.resources {
+		for _, kind1 := range s1.resourceKinds() {
 			if sys.IsCompatibleResource(kind, kind1) {
-				allres = append(allres, res1...)
+				allres = append(allres, s1.resources[kind1]...)
 			}
 		}
 		if len(allres) != 0 {
@@ -478,6 +548,8 @@ func (r *randGen) generateText(kind sys.TextKind) []byte {
 			text[i] = byte(r.Intn(256))
 		}
 		return text
+	case sys.Text_bpf:
+		return ebpf.Generate(r.Rand)
 	default:
 		cfg := createIfuzzConfig(kind)
 		return ifuzz.Generate(cfg, r.Rand)
@@ -488,6 +560,8 @@ func (r *randGen) mutateText(kind sys.TextKind, text []byte) []byte {
 	switch kind {
 	case sys.Text_arm64:
 		return mutateData(r, text, 40, 60)
+	case sys.Text_bpf:
+		return ebpf.Mutate(r.Rand, text)
 	default:
 		cfg := createIfuzzConfig(kind)
 		return ifuzz.Mutate(cfg, r.Rand, text)
@@ -557,7 +631,7 @@ func (r *randGen) generateParticularCall(s *state, meta *sys.Call) (calls []*Cal
 		Ret:  returnArg(meta.Ret),
 	}
 	c.Args, calls = r.generateArgs(s, meta.Args)
-	assignSizesCall(c)
+	assignSizesCall(r.target, c)
 	calls = append(calls, c)
 	for _, c1 := range calls {
 		sanitizeCall(c1)
@@ -568,7 +642,7 @@ func (r *randGen) generateParticularCall(s *state, meta *sys.Call) (calls []*Cal
 // GenerateAllSyzProg generates a program that contains all pseudo syz_ calls for testing.
 func GenerateAllSyzProg(rs rand.Source) *Prog {
 	p := new(Prog)
-	r := newRand(rs)
+	r := newRand(rs, p.target())
 	s := newState(nil)
 	handled := make(map[string]bool)
 	for _, meta := range sys.Calls {
@@ -613,7 +687,7 @@ func (r *randGen) generateArg(s *state, typ sys.Type) (arg *Arg, calls []*Call)
 		// output arguments (their elements can be referenced in subsequent calls).
 		switch typ.(type) {
 		case *sys.IntType, *sys.FlagsType, *sys.ConstType,
-			*sys.ResourceType, *sys.VmaType, *sys.ProcType:
+			*sys.ResourceType, *sys.VmaType, *sys.ProcType, *sys.VarintType:
 			return constArg(typ, typ.Default()), nil
 		}
 	}
@@ -631,10 +705,10 @@ func (r *randGen) generateArg(s *state, typ sys.Type) (arg *Arg, calls []*Call)
 		case r.nOutOf(1000, 1011):
 			// Get an existing resource.
 			var allres []*Arg
-			for name1, res1 := range s.resources {
+			for _, name1 := range s.resourceKinds() {
 				if sys.IsCompatibleResource(a.Desc.Name, name1) ||
 					r.oneOf(20) && sys.IsCompatibleResource(a.Desc.Kind[0], name1) {
-					allres = append(allres, res1...)
+					allres = append(allres, s.resources[name1]...)
 				}
 			}
 			if len(allres) != 0 {
@@ -665,7 +739,7 @@ func (r *randGen) generateArg(s *state, typ sys.Type) (arg *Arg, calls []*Call)
 			}
 			return dataArg(a, data), nil
 		case sys.BufferString:
-			data := r.randString(s, a.Values, a.Dir())
+			data := r.randString(s, a)
 			return dataArg(a, data), nil
 		case sys.BufferFilename:
 			var data []byte
@@ -679,7 +753,7 @@ func (r *randGen) generateArg(s *state, typ sys.Type) (arg *Arg, calls []*Call)
 					data = make([]byte, 4096) // PATH_MAX
 				}
 			} else {
-				data = []byte(r.filename(s))
+				data = []byte(r.filename(s, a))
 			}
 			return dataArg(a, data), nil
 		case sys.BufferText:
@@ -716,6 +790,19 @@ func (r *randGen) generateArg(s *state, typ sys.Type) (arg *Arg, calls []*Call)
 			v = r.randRangeInt(a.RangeBegin, a.RangeEnd)
 		}
 		return constArg(a, v), nil
+	case *sys.VarintType:
+		if a.RangeBegin != 0 || a.RangeEnd != 0 {
+			return constArg(a, r.randRangeInt(int64(a.RangeBegin), int64(a.RangeEnd))), nil
+		}
+		if r.bin() {
+			// Values around encoded length boundaries (0x7f/0x80, 0x3fff/0x4000, ...).
+			v := uintptr(1)<<(7*(r.Intn(9)+1)) - 1
+			if r.bin() {
+				v++
+			}
+			return constArg(a, v), nil
+		}
+		return constArg(a, r.randInt()), nil
 	case *sys.ProcType:
 		return constArg(a, r.rand(int(a.ValuesPerProc))), nil
 	case *sys.ArrayType:
@@ -741,11 +828,15 @@ func (r *randGen) generateArg(s *state, typ sys.Type) (arg *Arg, calls []*Call)
 		}
 		args, calls := r.generateArgs(s, a.Fields)
 		group := groupArg(a, args)
+		calls = append(calls, r.generateConds(s, group)...)
 		return group, calls
 	case *sys.UnionType:
 		optType := a.Options[r.Intn(len(a.Options))]
 		opt, calls := r.generateArg(s, optType)
 		return unionArg(a, opt, optType), calls
+	case *sys.CondType:
+		// Conditional fields are filled in by the parent struct (see generateConds).
+		return groupArg(a, nil), nil
 	case *sys.PtrType:
 		inner, calls := r.generateArg(s, a.Type)
 		if a.Dir() == sys.DirOut && inner == nil {
@@ -33,7 +33,7 @@ func generateSize(arg *Arg, lenType *sys.LenType) *Arg {
 	}
 }
 
-func assignSizes(args []*Arg, parentsMap map[*Arg]*Arg) {
+func assignSizes(t *Target, args []*Arg, parentsMap map[*Arg]*Arg) {
 	// Create a map of args and calculate size of the whole struct.
 	argsMap := make(map[string]*Arg)
 	for _, arg := range args {
@@ -49,6 +49,11 @@ func assignSizes(args []*Arg, parentsMap map[*Arg]*Arg) {
 			continue // Pointer to optional len field, no need to fill in value.
 		}
 		if typ, ok := arg.Type.(*sys.LenType); ok {
+			if typ.Expr != nil {
+				arg.Val = evalExpr(t, typ.Expr, arg, args, argsMap, parentsMap)
+				continue
+			}
+
 			buf, ok := argsMap[typ.Buf]
 			if ok {
 				*arg = *generateSize(buf.InnerArg(), typ)
@@ -84,7 +89,73 @@ func assignSizes(args []*Arg, parentsMap map[*Arg]*Arg) {
 	}
 }
 
-func assignSizesArray(args []*Arg) {
+// evalExpr evaluates lenexpr expression e for len field arg.
+// args are the fields of the struct that contains arg (or syscall args).
+func evalExpr(t *Target, e *sys.Expr, arg *Arg, args []*Arg, argsMap map[string]*Arg, parentsMap map[*Arg]*Arg) uintptr {
+	switch e.Op {
+	case sys.ExprConst:
+		return e.Val
+	case sys.ExprLen, sys.ExprBytesize:
+		target := exprTarget(e.Field, arg, argsMap, parentsMap)
+		if target == nil {
+			return 0 // optional pointer
+		}
+		switch target.Type.(type) {
+		case *sys.VmaType:
+			return target.AddrPagesNum * t.PageSize
+		case *sys.ArrayType:
+			if e.Op == sys.ExprLen {
+				return uintptr(len(target.Inner))
+			}
+		}
+		return target.Size()
+	case sys.ExprOffsetof:
+		offset := uintptr(0)
+		for _, fld := range args {
+			if fld.Type.FieldName() == e.Field && !sys.IsPad(fld.Type) {
+				return offset
+			}
+			if fld.Type.BitfieldLength() == 0 || fld.Type.BitfieldLast() {
+				offset += fld.Size()
+			}
+		}
+		panic(fmt.Sprintf("len field '%v' references non existent field '%v'", arg.Type.FieldName(), e.Field))
+	case sys.ExprAdd:
+		return evalExpr(t, e.Args[0], arg, args, argsMap, parentsMap) + evalExpr(t, e.Args[1], arg, args, argsMap, parentsMap)
+	case sys.ExprSub:
+		return evalExpr(t, e.Args[0], arg, args, argsMap, parentsMap) - evalExpr(t, e.Args[1], arg, args, argsMap, parentsMap)
+	case sys.ExprMul:
+		return evalExpr(t, e.Args[0], arg, args, argsMap, parentsMap) * evalExpr(t, e.Args[1], arg, args, argsMap, parentsMap)
+	case sys.ExprDiv:
+		v := evalExpr(t, e.Args[1], arg, args, argsMap, parentsMap)
+		if v == 0 {
+			return 0
+		}
+		return evalExpr(t, e.Args[0], arg, args, argsMap, parentsMap) / v
+	default:
+		panic(fmt.Sprintf("unknown expression op %v", e.Op))
+	}
+}
+
+// exprTarget returns the arg that name refers to in an expression of len field arg:
+// a sibling field (pointee for pointers), "parent" or an enclosing struct.
+func exprTarget(name string, arg *Arg, argsMap map[string]*Arg, parentsMap map[*Arg]*Arg) *Arg {
+	if buf, ok := argsMap[name]; ok {
+		return buf.InnerArg()
+	}
+	if name == "parent" {
+		return parentsMap[arg]
+	}
+	for parent := parentsMap[arg]; parent != nil; parent = parentsMap[parent] {
+		if name == parent.Type.Name() {
+			return parent
+		}
+	}
+	panic(fmt.Sprintf("len field '%v' references non existent field '%v', argsMap: %+v",
+		arg.Type.FieldName(), name, argsMap))
+}
+
+func assignSizesArray(t *Target, args []*Arg) {
 	parentsMap := make(map[*Arg]*Arg)
 	foreachArgArray(&args, nil, func(arg, base *Arg, _ *[]*Arg) {
 		if _, ok := arg.Type.(*sys.StructType); ok {
@@ -93,14 +164,14 @@ func assignSizesArray(args []*Arg) {
 			}
 		}
 	})
-	assignSizes(args, parentsMap)
+	assignSizes(t, args, parentsMap)
 	foreachArgArray(&args, nil, func(arg, base *Arg, _ *[]*Arg) {
 		if _, ok := arg.Type.(*sys.StructType); ok {
-			assignSizes(arg.Inner, parentsMap)
+			assignSizes(t, arg.Inner, parentsMap)
 		}
 	})
 }
 
-func assignSizesCall(c *Call) {
-	assignSizesArray(c.Args)
+func assignSizesCall(t *Target, c *Call) {
+	assignSizesArray(t, c.Args)
 }
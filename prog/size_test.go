@@ -15,7 +15,7 @@ func TestAssignSizeRandom(t *testing.T) {
 		p := Generate(rs, 10, nil)
 		data0 := p.Serialize()
 		for _, call := range p.Calls {
-			assignSizesCall(call)
+			assignSizesCall(defaultTarget, call)
 		}
 		if data1 := p.Serialize(); !bytes.Equal(data0, data1) {
 			t.Fatalf("different lens assigned, initial: %v, new: %v", data0, data1)
@@ -24,7 +24,7 @@ func TestAssignSizeRandom(t *testing.T) {
 			p.Mutate(rs, 10, nil, nil)
 			data0 := p.Serialize()
 			for _, call := range p.Calls {
-				assignSizesCall(call)
+				assignSizesCall(defaultTarget, call)
 			}
 			if data1 := p.Serialize(); !bytes.Equal(data0, data1) {
 				t.Fatalf("different lens assigned, initial: %v, new: %v", data0, data1)
@@ -42,6 +42,10 @@ func TestAssignSize(t *testing.T) {
 			"syz_test$length0(&(0x7f0000000000)={0xff, 0x0})",
 			"syz_test$length0(&(0x7f0000000000)={0xff, 0x2})",
 		},
+		{
+			"syz_test$cond0(&(0x7f0000000000)={0x2, 0x0, [], [{0x1, 0x0, \"0102\"}]})",
+			"syz_test$cond0(&(0x7f0000000000)={0x2, 0x10, [], [{0x1, 0x6, \"0102\"}]})",
+		},
 		{
 			"syz_test$length1(&(0x7f0000001000)={0xff, 0x0})",
 			"syz_test$length1(&(0x7f0000001000)={0xff, 0x4})",
@@ -122,6 +126,22 @@ func TestAssignSize(t *testing.T) {
 			"syz_test$length20(&(0x7f0000000000)={{{0xff, 0xff, 0xff, 0xff}, 0xff, 0xff, 0xff}, 0xff, 0xff})",
 			"syz_test$length20(&(0x7f0000000000)={{{0x4, 0x4, 0x7, 0x9}, 0x7, 0x7, 0x9}, 0x9, 0x9})",
 		},
+		{
+			"syz_test$varint0(&(0x7f0000000000)={0x3fff, 0xff, 0x4000, 0x42})",
+			"syz_test$varint0(&(0x7f0000000000)={0x3fff, 0x8, 0x4000, 0x42})",
+		},
+		{
+			"syz_test$length21(&(0x7f0000000000)={0xff, 0xff, 0xff, [0x1, 0x2], \"010203\"})",
+			"syz_test$length21(&(0x7f0000000000)={0x7, 0x2, 0x8, [0x1, 0x2], \"010203\"})",
+		},
+		{
+			"syz_test$length22(&(0x7f0000000000)=\"0102\", 0xff)",
+			"syz_test$length22(&(0x7f0000000000)=\"0102\", 0x3)",
+		},
+		{
+			"syz_test$nla0(&(0x7f0000000000)=[@a0={0xff, 0x1, 0x0, 0x0, 0x42}, @a2={0xff, 0x3, 0x0, 0x1, [@b0={0xff, 0x1, 0x0, 0x0, 0x43}, @b1={0xff, 0x2, 0x0, 0x0, \"010203\"}]}])",
+			"syz_test$nla0(&(0x7f0000000000)=[@a0={0x5, 0x1, 0x0, 0x0, 0x42}, @a2={0x14, 0x3, 0x0, 0x1, [@b0={0x6, 0x1, 0x0, 0x0, 0x43}, @b1={0x7, 0x2, 0x0, 0x0, \"010203\"}]}])",
+		},
 	}
 
 	for i, test := range tests {
@@ -130,7 +150,7 @@ func TestAssignSize(t *testing.T) {
 			t.Fatalf("failed to deserialize prog %v: %v", i, err)
 		}
 		for _, call := range p.Calls {
-			assignSizesCall(call)
+			assignSizesCall(defaultTarget, call)
 		}
 		p1 := strings.TrimSpace(string(p.Serialize()))
 		if p1 != test.sizedProg {
@@ -0,0 +1,149 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package prog
+
+import (
+	"fmt"
+	"math/rand"
+	"unsafe"
+
+	"github.com/google/syzkaller/sys"
+)
+
+// Squashing converts a typed struct/array/union arg into the raw bytes it occupies in memory,
+// so that the bytes can be mutated as a dumb blob. Unsquashing lifts the bytes back into
+// the typed form (the shape of the arg, i.e. array lengths and union options, is preserved).
+// This allows to alternate between structural and byte-level mutation of the same data.
+// Only args that consist of plain data (no pointers, resource references or varints)
+// can be squashed. Values of consts, lens, checksums, per-process values and output
+// fields are not lifted back as they are determined by the descriptions.
+
+// SquashArg returns the binary representation of arg.
+func SquashArg(arg *Arg) ([]byte, error) {
+	if err := checkSquashable(arg); err != nil {
+		return nil, err
+	}
+	return encodeStruct(arg, 0, nil), nil
+}
+
+// UnsquashArg updates values of arg from data previously returned by SquashArg for the same arg.
+func UnsquashArg(arg *Arg, data []byte) error {
+	if err := checkSquashable(arg); err != nil {
+		return err
+	}
+	if uintptr(len(data)) != arg.Size() {
+		return fmt.Errorf("data size %v does not match arg size %v", len(data), arg.Size())
+	}
+	foreachSubargOffset(arg, func(arg1 *Arg, offset uintptr) {
+		if arg1.Type.Dir() == sys.DirOut {
+			return
+		}
+		switch arg1.Kind {
+		case ArgConst:
+			switch typ := arg1.Type.(type) {
+			case *sys.IntType:
+				arg1.Val = decodeValue(data[offset:], typ.Size(), typ.BigEndian, typ.BitfieldOffset(), typ.BitfieldLength())
+			case *sys.FlagsType:
+				arg1.Val = decodeValue(data[offset:], typ.Size(), typ.BigEndian, typ.BitfieldOffset(), typ.BitfieldLength())
+			case *sys.ResourceType:
+				arg1.Val = decodeValue(data[offset:], typ.Size(), false, 0, 0)
+			}
+		case ArgData:
+			copy(arg1.Data, data[offset:])
+		}
+	})
+	return nil
+}
+
+func checkSquashable(arg *Arg) error {
+	if arg == nil || arg.Kind != ArgGroup && arg.Kind != ArgUnion {
+		return fmt.Errorf("only struct, array and union args can be squashed")
+	}
+	var err error
+	foreachSubargOffset(arg, func(arg1 *Arg, offset uintptr) {
+		if err != nil {
+			return
+		}
+		switch arg1.Kind {
+		case ArgConst, ArgData:
+			if _, ok := arg1.Type.(*sys.VarintType); ok {
+				err = fmt.Errorf("arg contains varint field %v", arg1.Type.FieldName())
+			}
+		default:
+			err = fmt.Errorf("arg contains non-data field %v", arg1.Type.FieldName())
+		}
+	})
+	return err
+}
+
+// decodeValue is the reverse of the value encoding done by encodeStruct.
+func decodeValue(data []byte, size uintptr, bigEndian bool, bfOff, bfLen uintptr) uintptr {
+	if bigEndian && bfLen != 0 {
+		// Big-endian bitfield offsets refer to the value of the storage unit.
+		var v uintptr
+		for i := uintptr(0); i < size; i++ {
+			v = v<<8 | uintptr(data[i])
+		}
+		return v >> bfOff & (1<<bfLen - 1)
+	}
+	addr := unsafe.Pointer(&data[0])
+	var v uintptr
+	switch size {
+	case 1:
+		v = uintptr(*(*uint8)(addr))
+	case 2:
+		v = uintptr(*(*uint16)(addr))
+	case 4:
+		v = uintptr(*(*uint32)(addr))
+	case 8:
+		v = uintptr(*(*uint64)(addr))
+	default:
+		panic(fmt.Sprintf("bad value size %v", size))
+	}
+	if bfLen != 0 {
+		v = v >> bfOff & (1<<bfLen - 1)
+	}
+	return encodeValue(v, size, bigEndian)
+}
+
+// squashStrategy mutates a struct pointed to by an argument as a blob of bytes.
+type squashStrategy struct{}
+
+func (squashStrategy) Name() string { return "squash" }
+
+func (squashStrategy) Mutate(rnd *rand.Rand, p *Prog, ctx *MutationContext) bool {
+	if len(p.Calls) == 0 {
+		return false
+	}
+	c := p.Calls[rnd.Intn(len(p.Calls))]
+	var args []*Arg
+	foreachArg(c, func(arg, _ *Arg, _ *[]*Arg) {
+		if arg.Kind == ArgPointer && arg.Res != nil && arg.Res.Type.Dir() != sys.DirOut &&
+			arg.Res.Size() != 0 && checkSquashable(arg.Res) == nil {
+			args = append(args, arg.Res)
+		}
+	})
+	if len(args) == 0 {
+		return false
+	}
+	arg := args[rnd.Intn(len(args))]
+	data, err := SquashArg(arg)
+	if err != nil {
+		panic(err)
+	}
+	r := &randGen{Rand: rnd, target: p.target(), dict: ctx.Dict}
+	data = mutateData(r, data, len(data), len(data))
+	if err := UnsquashArg(arg, data); err != nil {
+		panic(err)
+	}
+	p.assignConds(c)
+	assignSizesCall(p.target(), c)
+	return true
+}
+
+func init() {
+	// Not used by Prog.Mutate and disabled by default in mutators created with NewMutator,
+	// it needs to be explicitly enabled with a non-zero weight.
+	RegisterMutationStrategy(squashStrategy{}, 0)
+}
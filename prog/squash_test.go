@@ -0,0 +1,102 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package prog
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/syzkaller/sys"
+)
+
+func TestSquash(t *testing.T) {
+	tests := []string{
+		"syz_test$end0(&(0x7f0000000000)={0x42, 0x4243, 0x42434445, 0x4243444546474849, 0x42})",
+		"syz_test$bf0(&(0x7f0000000000)={0x42, 0x42, 0x42, 0x13, 0x42, 0x42, 0x42, 0x42})",
+		"syz_test$align0(&(0x7f0000000000)={0x1, 0x2, 0x3, 0x4, 0x5})",
+	}
+	for _, test := range tests {
+		p, err := Deserialize([]byte(test))
+		if err != nil {
+			t.Fatalf("failed to deserialize %q: %v", test, err)
+		}
+		arg := p.Calls[0].Args[0].Res
+		data, err := SquashArg(arg)
+		if err != nil {
+			t.Fatalf("failed to squash %q: %v", test, err)
+		}
+		if uintptr(len(data)) != arg.Size() {
+			t.Fatalf("squashed %q into %v bytes, want %v", test, len(data), arg.Size())
+		}
+		for _, arg1 := range arg.Inner {
+			if _, ok := arg1.Type.(*sys.IntType); ok {
+				arg1.Val = 0
+			}
+		}
+		if err := UnsquashArg(arg, data); err != nil {
+			t.Fatalf("failed to unsquash %q: %v", test, err)
+		}
+		if got := string(p.Serialize()); got != test+"\n" {
+			t.Fatalf("round trip changed program:\n%v\nwant:\n%v", got, test)
+		}
+	}
+}
+
+func TestSquashBitfields(t *testing.T) {
+	p, err := Deserialize([]byte("syz_test$bf2(&(0x7f0000000000)={0xa, 0xabc, 0x5, 0x2a5, 0x9, 0x6, 0x11})"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := SquashArg(p.Calls[0].Args[0].Res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0xa0, 0xc0, 0xab, 0x00, 0xb5, 0x28, 0x00, 0x09, 0xd1, 0x00, 0x00, 0x00}
+	if !bytes.Equal(data, want) {
+		t.Fatalf("squashed bitfields into\n%x\nwant\n%x", data, want)
+	}
+}
+
+func TestUnsquash(t *testing.T) {
+	p, err := Deserialize([]byte("syz_test$end0(&(0x7f0000000000)={0x42, 0x4243, 0x42434445, 0x4243444546474849, 0x42})"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	arg := p.Calls[0].Args[0].Res
+	data, err := SquashArg(arg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[0] = 0x11
+	data[1] = 0x12
+	data[2] = 0x34
+	if err := UnsquashArg(arg, data); err != nil {
+		t.Fatal(err)
+	}
+	if arg.Inner[0].Val != 0x11 || arg.Inner[1].Val != 0x1234 {
+		t.Fatalf("unsquashed wrong values: 0x%x, 0x%x", arg.Inner[0].Val, arg.Inner[1].Val)
+	}
+	if err := UnsquashArg(arg, data[1:]); err == nil {
+		t.Fatalf("unsquashed data of wrong size")
+	}
+	if _, err := SquashArg(p.Calls[0].Args[0]); err == nil {
+		t.Fatalf("squashed pointer arg")
+	}
+}
+
+func TestSquashStrategyDisabled(t *testing.T) {
+	m, err := NewMutator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range m.strategies {
+		if s.Name() == "squash" {
+			t.Fatalf("squash is enabled by default")
+		}
+	}
+	m, err = NewMutator(map[string]int{"squash": 1})
+	if err != nil || len(m.strategies) != 1 || m.strategies[0].Name() != "squash" {
+		t.Fatalf("failed to enable squash: %v", err)
+	}
+}
@@ -0,0 +1,854 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package prog
+
+// Conversion of strace output into programs.
+// Traces of real workloads give programs that bring kernel into realistic states
+// that are hard to reach with generation, so they are good corpus seeds.
+// The expected input is produced by:
+//
+//	strace -f -v -s 65500 -o trace.txt workload
+//
+// Every traced process gives a separate program. Syscalls are matched against
+// descriptions by name; if there are several variants (e.g. socket$unix),
+// the variant with matching constant arguments is selected. Arguments decoded by strace
+// (integers, symbolic flags, strings, structs and arrays) are converted according to
+// the description types, the rest get default values. Symbolic constants are resolved
+// with the consts map (see sys/*_arch.const files). Resources returned by syscalls
+// (e.g. fds) are linked to their subsequent uses.
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/syzkaller/sys"
+)
+
+type straceKind int
+
+const (
+	straceInt     straceKind = iota // integer or symbolic expression (e.g. O_RDWR|O_CREAT) in Expr
+	straceString                    // string in Data
+	straceStruct                    // fields in Inner
+	straceArray                     // elements in Inner
+	straceFunc                      // function-like expression (e.g. htons(80)), name in Expr, args in Inner
+	straceOmitted                   // "..." or something we can't parse
+)
+
+type straceVal struct {
+	Kind  straceKind
+	Name  string // field name for struct fields
+	Expr  string
+	Data  []byte
+	Inner []*straceVal
+}
+
+type straceCall struct {
+	name   string
+	args   []*straceVal
+	ret    uint64
+	hasRet bool // ret is known (e.g. it's not known for exit)
+	failed bool // the call returned an error
+}
+
+// ParseStrace converts strace output into programs, one program per traced process.
+// Syscalls that are not described or can't be parsed are skipped.
+func ParseStrace(data []byte, consts map[string]uint64) ([]*Prog, error) {
+	calls := make(map[string][]*sys.Call)
+	for _, meta := range sys.Calls {
+		calls[meta.CallName] = append(calls[meta.CallName], meta)
+	}
+	var pids []int
+	convs := make(map[int]*straceConv)
+	unfinished := make(map[int]string)
+	s := bufio.NewScanner(bytes.NewReader(data))
+	s.Buffer(nil, maxLineLen)
+	for s.Scan() {
+		pid, line := straceLinePid(s.Text())
+		switch {
+		case line == "", strings.HasPrefix(line, "---"), strings.HasPrefix(line, "+++"):
+			// Signals and process exits.
+			continue
+		case strings.HasSuffix(line, "<unfinished ...>"):
+			unfinished[pid] = strings.TrimSuffix(line, "<unfinished ...>")
+			continue
+		case strings.HasPrefix(line, "<..."):
+			pos := strings.Index(line, "resumed>")
+			start, ok := unfinished[pid]
+			if pos == -1 || !ok {
+				continue
+			}
+			delete(unfinished, pid)
+			line = start + line[pos+len("resumed>"):]
+		}
+		sc := parseStraceCall(line)
+		if sc == nil || len(calls[sc.name]) == 0 {
+			continue
+		}
+		cv := convs[pid]
+		if cv == nil {
+			cv = &straceConv{
+				consts: consts,
+				calls:  calls,
+				res:    make(map[uint64]*Arg),
+			}
+			convs[pid] = cv
+			pids = append(pids, pid)
+		}
+		cv.convertCall(sc)
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read strace output: %v", err)
+	}
+	var progs []*Prog
+	for _, pid := range pids {
+		cv := convs[pid]
+		if len(cv.calls1) == 0 {
+			continue
+		}
+		npages := cv.used
+		if npages == 0 {
+			npages = 1
+		}
+		p := &Prog{
+			Calls:    append([]*Call{createMmapCall(0, npages)}, cv.calls1...),
+			Comments: SetAnnotation(nil, AnnotationOrigin, "strace"),
+		}
+		if err := p.validate(); err != nil {
+			return nil, fmt.Errorf("converted program of process %v is invalid: %v", pid, err)
+		}
+		progs = append(progs, p)
+	}
+	return progs, nil
+}
+
+// straceLinePid strips pid ("[pid 123] " or "123 ") and timestamp from an strace line.
+func straceLinePid(line string) (int, string) {
+	line = strings.TrimSpace(line)
+	pid := 0
+	if strings.HasPrefix(line, "[pid ") {
+		end := strings.IndexByte(line, ']')
+		if end == -1 {
+			return 0, ""
+		}
+		pid, _ = strconv.Atoi(strings.TrimSpace(line[len("[pid "):end]))
+		line = strings.TrimSpace(line[end+1:])
+	} else if pos := strings.IndexByte(line, ' '); pos != -1 {
+		if v, err := strconv.Atoi(line[:pos]); err == nil {
+			pid = v
+			line = strings.TrimSpace(line[pos:])
+		}
+	}
+	// Timestamps printed with -t, -tt, -ttt or -r.
+	if pos := strings.IndexByte(line, ' '); pos != -1 && strings.Trim(line[:pos], "0123456789:.") == "" {
+		line = strings.TrimSpace(line[pos:])
+	}
+	return pid, line
+}
+
+// parseStraceCall parses "name(args...) = ret" line, returns nil if the line can't be parsed.
+func parseStraceCall(line string) *straceCall {
+	pos := strings.IndexByte(line, '(')
+	if pos <= 0 {
+		return nil
+	}
+	sc := &straceCall{name: line[:pos]}
+	for _, c := range sc.name {
+		if !isStraceIdentChar(byte(c)) {
+			return nil
+		}
+	}
+	p := &straceParser{s: line, i: pos + 1}
+	sc.args = p.list(')')
+	p.skipSpace()
+	if p.eof() || p.s[p.i] != '=' {
+		return nil
+	}
+	p.i++
+	p.skipSpace()
+	if !p.eof() && p.s[p.i] == '?' {
+		return sc
+	}
+	ret := p.value()
+	if v, ok := straceEval(ret, nil); ok {
+		sc.ret, sc.hasRet = v, true
+	}
+	p.skipSpace()
+	if !p.eof() && p.s[p.i] == 'E' {
+		sc.failed = true
+	}
+	return sc
+}
+
+type straceParser struct {
+	s string
+	i int
+}
+
+func (p *straceParser) eof() bool {
+	return p.i >= len(p.s)
+}
+
+func (p *straceParser) skipSpace() {
+	for !p.eof() {
+		if p.s[p.i] == ' ' || p.s[p.i] == '\t' {
+			p.i++
+			continue
+		}
+		// Comments like /* 20 vars */ or /* 2017-06-01T12:00:00+0000 */.
+		if strings.HasPrefix(p.s[p.i:], "/*") {
+			end := strings.Index(p.s[p.i:], "*/")
+			if end == -1 {
+				p.i = len(p.s)
+				return
+			}
+			p.i += end + 2
+			continue
+		}
+		return
+	}
+}
+
+// list parses comma or space separated values until the close character.
+func (p *straceParser) list(close byte) []*straceVal {
+	var vals []*straceVal
+	for {
+		p.skipSpace()
+		if p.eof() {
+			return vals
+		}
+		if p.s[p.i] == close {
+			p.i++
+			return vals
+		}
+		if p.s[p.i] == ',' {
+			p.i++
+			continue
+		}
+		name := p.fieldName()
+		v := p.value()
+		v.Name = name
+		vals = append(vals, v)
+	}
+}
+
+// fieldName parses "name=" prefix of a struct field, returns "" if there is none.
+func (p *straceParser) fieldName() string {
+	i := p.i
+	for i < len(p.s) && (isStraceIdentChar(p.s[i]) || p.s[i] == '.') {
+		i++
+	}
+	if i == p.i || i+1 >= len(p.s) || p.s[i] != '=' || p.s[i+1] == '=' {
+		return ""
+	}
+	name := p.s[p.i:i]
+	p.i = i + 1
+	return name
+}
+
+func (p *straceParser) value() *straceVal {
+	p.skipSpace()
+	if p.eof() {
+		return &straceVal{Kind: straceOmitted}
+	}
+	switch c := p.s[p.i]; {
+	case strings.HasPrefix(p.s[p.i:], "..."):
+		p.i += 3
+		return &straceVal{Kind: straceOmitted}
+	case c == '"' || c == '@' && p.i+1 < len(p.s) && p.s[p.i+1] == '"':
+		// '@' denotes abstract unix socket names.
+		if c == '@' {
+			p.i++
+		}
+		v := &straceVal{Kind: straceString, Data: p.str()}
+		if strings.HasPrefix(p.s[p.i:], "...") {
+			// Truncated string.
+			p.i += 3
+		}
+		return v
+	case c == '{':
+		p.i++
+		return &straceVal{Kind: straceStruct, Inner: p.list('}')}
+	case c == '[':
+		p.i++
+		return &straceVal{Kind: straceArray, Inner: p.list(']')}
+	case c == '~' && p.i+1 < len(p.s) && p.s[p.i+1] == '[':
+		// Inverted signal set.
+		p.i++
+		return p.value()
+	}
+	start := p.i
+	for !p.eof() {
+		c := p.s[p.i]
+		switch {
+		case c == '(' && p.i != start:
+			name := p.s[start:p.i]
+			p.i++
+			return &straceVal{Kind: straceFunc, Expr: name, Inner: p.list(')')}
+		case strings.HasPrefix(p.s[p.i:], "<<"):
+			p.i += 2
+		case c == '<':
+			// File path of fd printed with -y, e.g. 3</dev/null>.
+			expr := p.s[start:p.i]
+			if end := strings.IndexByte(p.s[p.i:], '>'); end != -1 {
+				p.i += end + 1
+			} else {
+				p.i = len(p.s)
+			}
+			return &straceVal{Kind: straceInt, Expr: expr}
+		case isStraceIdentChar(c) || strings.IndexByte("|-+&*.", c) != -1:
+			p.i++
+		default:
+			if p.i == start {
+				// Skip unknown character, so that the parser makes progress.
+				p.i++
+				return &straceVal{Kind: straceOmitted}
+			}
+			return &straceVal{Kind: straceInt, Expr: p.s[start:p.i]}
+		}
+	}
+	return &straceVal{Kind: straceInt, Expr: p.s[start:p.i]}
+}
+
+// str parses a quoted C string with escape sequences.
+func (p *straceParser) str() []byte {
+	var data []byte
+	for p.i++; !p.eof(); p.i++ {
+		c := p.s[p.i]
+		if c == '"' {
+			p.i++
+			break
+		}
+		if c != '\\' || p.i+1 == len(p.s) {
+			data = append(data, c)
+			continue
+		}
+		p.i++
+		switch c = p.s[p.i]; c {
+		case 'n':
+			data = append(data, '\n')
+		case 't':
+			data = append(data, '\t')
+		case 'r':
+			data = append(data, '\r')
+		case 'v':
+			data = append(data, '\v')
+		case 'f':
+			data = append(data, '\f')
+		case 'x':
+			if p.i+2 < len(p.s) {
+				if v, err := strconv.ParseUint(p.s[p.i+1:p.i+3], 16, 8); err == nil {
+					data = append(data, byte(v))
+					p.i += 2
+					break
+				}
+			}
+			data = append(data, c)
+		default:
+			if c < '0' || c > '7' {
+				data = append(data, c)
+				break
+			}
+			end := p.i
+			for end < len(p.s) && end < p.i+3 && p.s[end] >= '0' && p.s[end] <= '7' {
+				end++
+			}
+			v, _ := strconv.ParseUint(p.s[p.i:end], 8, 8)
+			data = append(data, byte(v))
+			p.i = end - 1
+		}
+	}
+	return data
+}
+
+func isStraceIdentChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '$'
+}
+
+// straceEval evaluates integer value v, symbolic constants are resolved with consts.
+func straceEval(v *straceVal, consts map[string]uint64) (uint64, bool) {
+	if v == nil {
+		return 0, false
+	}
+	switch v.Kind {
+	case straceInt:
+		var res uint64
+		for _, part := range strings.Split(v.Expr, "|") {
+			val, ok := straceEvalShift(part, consts)
+			if !ok {
+				return 0, false
+			}
+			res |= val
+		}
+		return res, true
+	case straceFunc:
+		switch v.Expr {
+		case "htons", "htonl", "ntohs", "ntohl":
+			// Big-endian fields are described as such, so they hold host values.
+			if len(v.Inner) == 1 {
+				return straceEval(v.Inner[0], consts)
+			}
+		case "makedev":
+			if len(v.Inner) == 2 {
+				major, ok1 := straceEval(v.Inner[0], consts)
+				minor, ok2 := straceEval(v.Inner[1], consts)
+				dev := minor&0xff | major&0xfff<<8 | minor&^0xff<<12 | major&^0xfff<<32
+				return dev, ok1 && ok2
+			}
+		case "inet_addr":
+			if len(v.Inner) == 1 && v.Inner[0].Kind == straceString {
+				var addr uint64
+				parts := strings.Split(string(v.Inner[0].Data), ".")
+				for _, part := range parts {
+					b, err := strconv.ParseUint(part, 10, 8)
+					if err != nil || len(parts) != 4 {
+						return 0, false
+					}
+					addr = addr<<8 | b
+				}
+				return addr, true
+			}
+		}
+	}
+	return 0, false
+}
+
+func straceEvalShift(expr string, consts map[string]uint64) (uint64, bool) {
+	if pos := strings.Index(expr, "<<"); pos != -1 {
+		val, ok1 := straceEvalShift(expr[:pos], consts)
+		shift, ok2 := straceEvalShift(expr[pos+2:], consts)
+		return val << shift, ok1 && ok2
+	}
+	expr = strings.TrimSpace(expr)
+	if expr == "NULL" {
+		return 0, true
+	}
+	if v, err := strconv.ParseInt(expr, 0, 64); err == nil {
+		return uint64(v), true
+	}
+	if v, err := strconv.ParseUint(expr, 0, 64); err == nil {
+		return v, true
+	}
+	v, ok := consts[expr]
+	return v, ok
+}
+
+// straceConv converts syscalls of a single process.
+type straceConv struct {
+	consts  map[string]uint64
+	calls   map[string][]*sys.Call // syscall variants by syscall name
+	calls1  []*Call                // converted calls
+	res     map[uint64]*Arg        // resources created by previous calls by their values
+	pending map[uint64]*Arg        // resources created by the current call
+	page    uintptr                // next page to allocate
+	used    uintptr                // number of used pages
+}
+
+func (cv *straceConv) convertCall(sc *straceCall) {
+	meta := cv.selectCall(sc)
+	if meta == nil {
+		return
+	}
+	c := &Call{
+		Meta: meta,
+		Ret:  returnArg(meta.Ret),
+	}
+	cv.pending = make(map[uint64]*Arg)
+	for i, typ := range meta.Args {
+		var v *straceVal
+		if i < len(sc.args) {
+			v = sc.args[i]
+		}
+		c.Args = append(c.Args, cv.arg(typ, v))
+	}
+	assignSizesCall(defaultTarget, c)
+	sanitizeCall(c)
+	cv.calls1 = append(cv.calls1, c)
+	if sc.failed {
+		return
+	}
+	for val, arg := range cv.pending {
+		cv.res[val] = arg
+	}
+	if _, ok := meta.Ret.(*sys.ResourceType); ok && sc.hasRet {
+		cv.res[sc.ret] = c.Ret
+	}
+	if sys.IsDestructor(meta) && len(sc.args) != 0 {
+		if val, ok := straceEval(sc.args[0], cv.consts); ok {
+			delete(cv.res, val)
+		}
+	}
+}
+
+// selectCall selects the syscall variant that matches the call arguments best.
+func (cv *straceConv) selectCall(sc *straceCall) *sys.Call {
+	var best *sys.Call
+	bestScore := -1
+	for _, meta := range cv.calls[sc.name] {
+		score := 0
+		for i, typ := range meta.Args {
+			if i >= len(sc.args) {
+				break
+			}
+			s := cv.match(typ, sc.args[i])
+			if s < 0 {
+				score = -1
+				break
+			}
+			score += s
+		}
+		if score > bestScore || score == bestScore && meta.Name == sc.name {
+			best, bestScore = meta, score
+		}
+	}
+	return best
+}
+
+// match returns the number of constants in v that match typ, or -1 if a constant does not match.
+func (cv *straceConv) match(typ sys.Type, v *straceVal) int {
+	switch t := typ.(type) {
+	case *sys.ConstType:
+		if t.IsPad || v == nil || v.Kind == straceOmitted {
+			return 0
+		}
+		// Unknown symbolic value is not an evidence for a specialized variant.
+		val, ok := straceEval(v, cv.consts)
+		if !ok {
+			return -1
+		}
+		mask := ^uint64(0)
+		if t.Size() < 8 {
+			mask = 1<<(8*t.Size()) - 1
+		}
+		if val&mask != uint64(t.Val)&mask {
+			return -1
+		}
+		return 1
+	case *sys.ResourceType:
+		// Prefer variants that accept exactly the resources created by previous calls.
+		val, ok := straceEval(v, cv.consts)
+		res := cv.res[val]
+		if !ok || res == nil {
+			return 0
+		}
+		name := res.Type.(*sys.ResourceType).Desc.Name
+		switch {
+		case name == t.Desc.Name:
+			return 1
+		case sys.IsCompatibleResource(t.Desc.Name, name):
+			return 0
+		}
+		return -1
+	case *sys.BufferType:
+		if len(t.Values) == 0 || v == nil || v.Kind != straceString {
+			return 0
+		}
+		for _, val := range t.Values {
+			if strings.TrimRight(val, "\x00") == strings.TrimRight(string(v.Data), "\x00") {
+				return 1
+			}
+		}
+		return -1
+	case *sys.PtrType:
+		if v == nil || v.Kind == straceInt {
+			return 0
+		}
+		return cv.match(t.Type, straceUnwrapPtr(t, v))
+	case *sys.StructType:
+		score := 0
+		for i, v1 := range straceFields(t, v) {
+			if v1 == nil {
+				continue
+			}
+			s := cv.match(t.Fields[i], v1)
+			if s < 0 {
+				return -1
+			}
+			score += s
+		}
+		return score
+	case *sys.UnionType:
+		best := -1
+		for _, opt := range t.Options {
+			if s := cv.match(opt, v); s > best {
+				best = s
+			}
+		}
+		return best
+	}
+	return 0
+}
+
+// straceUnwrapPtr returns pointee value of pointer typ,
+// strace prints pointers to integers as [val].
+func straceUnwrapPtr(typ *sys.PtrType, v *straceVal) *straceVal {
+	if v.Kind == straceArray && len(v.Inner) == 1 {
+		switch typ.Type.(type) {
+		case *sys.IntType, *sys.FlagsType, *sys.ResourceType, *sys.LenType, *sys.ConstType:
+			return v.Inner[0]
+		}
+	}
+	return v
+}
+
+// straceFields assigns strace struct fields to fields of struct typ:
+// by name if the names match, the rest by position.
+func straceFields(typ *sys.StructType, v *straceVal) []*straceVal {
+	vals := make([]*straceVal, len(typ.Fields))
+	if v == nil || v.Kind != straceStruct && v.Kind != straceArray {
+		return vals
+	}
+	matched := make(map[*straceVal]bool)
+	for _, v1 := range v.Inner {
+		if v1.Name == "" {
+			continue
+		}
+		// strace uses kernel names with prefixes, e.g. st_mode or sin_port.
+		short := v1.Name
+		if pos := strings.IndexByte(short, '_'); pos != -1 {
+			short = short[pos+1:]
+		}
+		for i, fld := range typ.Fields {
+			if vals[i] == nil && (fld.FieldName() == v1.Name || fld.FieldName() == short) {
+				vals[i] = v1
+				matched[v1] = true
+				break
+			}
+		}
+	}
+	var fields []int
+	for i, fld := range typ.Fields {
+		if _, ok := fld.(*sys.CondType); !ok && !sys.IsPad(fld) {
+			fields = append(fields, i)
+		}
+	}
+	for i, v1 := range v.Inner {
+		if i < len(fields) && !matched[v1] && vals[fields[i]] == nil {
+			vals[fields[i]] = v1
+		}
+	}
+	return vals
+}
+
+// arg converts strace value v (nil if not present) to an arg of type typ.
+func (cv *straceConv) arg(typ sys.Type, v *straceVal) *Arg {
+	if v == nil || v.Kind == straceOmitted {
+		return cv.defaultArg(typ)
+	}
+	if typ.Dir() == sys.DirOut {
+		switch typ.(type) {
+		case *sys.ResourceType:
+			// The resource can be used by subsequent calls if the call succeeds.
+			arg := constArg(typ, typ.Default())
+			if val, ok := straceEval(v, cv.consts); ok {
+				cv.pending[val] = arg
+			}
+			return arg
+		case *sys.IntType, *sys.FlagsType, *sys.ConstType,
+			*sys.VmaType, *sys.ProcType, *sys.VarintType:
+			return constArg(typ, typ.Default())
+		}
+	}
+	switch t := typ.(type) {
+	case *sys.ResourceType:
+		val, ok := straceEval(v, cv.consts)
+		if !ok {
+			return cv.defaultArg(typ)
+		}
+		if res := cv.res[val]; res != nil && sys.IsCompatibleResource(t.Desc.Name, res.Type.(*sys.ResourceType).Desc.Name) {
+			return resultArg(t, res)
+		}
+		for _, special := range t.SpecialValues() {
+			if uintptr(val) == special {
+				return constArg(t, special)
+			}
+		}
+		return cv.defaultArg(typ)
+	case *sys.IntType, *sys.FlagsType, *sys.VarintType:
+		val, ok := straceEval(v, cv.consts)
+		if !ok {
+			return cv.defaultArg(typ)
+		}
+		return constArg(typ, uintptr(val))
+	case *sys.ProcType:
+		val, ok := straceEval(v, cv.consts)
+		idx := int64(val) - t.ValuesStart
+		if !ok || idx < 0 || uint64(idx) >= t.ValuesPerProc {
+			return cv.defaultArg(typ)
+		}
+		return constArg(typ, uintptr(idx))
+	case *sys.BufferType:
+		if v.Kind != straceString {
+			return cv.defaultArg(typ)
+		}
+		data := append([]byte{}, v.Data...)
+		switch t.Kind {
+		case sys.BufferString:
+			if t.Length != 0 {
+				data = append(data, make([]byte, t.Length)...)[:t.Length]
+			} else if len(data) == 0 || data[len(data)-1] != 0 {
+				data = append(data, 0)
+			}
+		case sys.BufferFilename:
+			if len(data) == 0 || data[len(data)-1] != 0 {
+				data = append(data, 0)
+			}
+		case sys.BufferBlobRange:
+			if uintptr(len(data)) > t.RangeEnd {
+				data = data[:t.RangeEnd]
+			}
+			if uintptr(len(data)) < t.RangeBegin {
+				data = append(data, make([]byte, t.RangeBegin-uintptr(len(data)))...)
+			}
+		}
+		if t.Dir() == sys.DirOut {
+			data = make([]byte, len(data))
+		}
+		return dataArg(t, data)
+	case *sys.PtrType:
+		if v.Kind == straceInt {
+			// strace does not decode the pointee.
+			if val, ok := straceEval(v, cv.consts); ok && val == 0 && t.Optional() {
+				return constArg(t, 0)
+			}
+			return cv.defaultArg(typ)
+		}
+		return cv.pointer(t, cv.arg(t.Type, straceUnwrapPtr(t, v)))
+	case *sys.StructType:
+		vals := straceFields(t, v)
+		var inner []*Arg
+		for i, fld := range t.Fields {
+			inner = append(inner, cv.arg(fld, vals[i]))
+		}
+		for i, arg := range inner {
+			if typ1, ok := arg.Type.(*sys.CondType); ok && condMatches(inner, arg) {
+				arg.Inner = []*Arg{cv.arg(typ1.Type, vals[i])}
+			}
+		}
+		return groupArg(t, inner)
+	case *sys.ArrayType:
+		var inner []*Arg
+		switch v.Kind {
+		case straceArray, straceStruct:
+			for _, v1 := range v.Inner {
+				if v1.Kind != straceOmitted {
+					inner = append(inner, cv.arg(t.Type, v1))
+				}
+			}
+		case straceString:
+			if elem, ok := t.Type.(*sys.IntType); ok && elem.Size() == 1 {
+				for _, b := range v.Data {
+					inner = append(inner, constArg(elem, uintptr(b)))
+				}
+			}
+		}
+		if t.Kind == sys.ArrayRangeLen {
+			if uintptr(len(inner)) > t.RangeEnd {
+				inner = inner[:t.RangeEnd]
+			}
+			for uintptr(len(inner)) < t.RangeBegin {
+				inner = append(inner, cv.defaultArg(t.Type))
+			}
+		}
+		return groupArg(t, inner)
+	case *sys.UnionType:
+		opt, best := t.Options[0], -1
+		for _, opt1 := range t.Options {
+			if s := cv.match(opt1, v); s > best {
+				opt, best = opt1, s
+			}
+		}
+		return unionArg(t, cv.arg(opt, v), opt)
+	default:
+		return cv.defaultArg(typ)
+	}
+}
+
+// defaultArg returns a deterministic arg of type typ for values that strace does not show.
+func (cv *straceConv) defaultArg(typ sys.Type) *Arg {
+	switch t := typ.(type) {
+	case *sys.ConstType:
+		if t.Dir() == sys.DirOut {
+			return constArg(t, t.Default())
+		}
+		return constArg(t, t.Val)
+	case *sys.BufferType:
+		var data []byte
+		switch t.Kind {
+		case sys.BufferBlobRange:
+			data = make([]byte, t.RangeBegin)
+		case sys.BufferString:
+			if t.Length != 0 {
+				data = make([]byte, t.Length)
+			} else if len(t.Values) != 0 && t.Dir() != sys.DirOut {
+				data = []byte(t.Values[0])
+			}
+		case sys.BufferFilename:
+			if t.Dir() != sys.DirOut {
+				data = []byte("./file0\x00")
+			}
+		}
+		return dataArg(t, data)
+	case *sys.VmaType:
+		npages := uintptr(1)
+		if t.RangeBegin != 0 {
+			npages = uintptr(t.RangeBegin)
+		}
+		return pointerArg(t, cv.alloc(npages), 0, npages, nil)
+	case *sys.PtrType:
+		if t.Optional() {
+			return constArg(t, 0)
+		}
+		return cv.pointer(t, cv.defaultArg(t.Type))
+	case *sys.ArrayType:
+		var inner []*Arg
+		if t.Kind == sys.ArrayRangeLen {
+			for i := uintptr(0); i < t.RangeBegin; i++ {
+				inner = append(inner, cv.defaultArg(t.Type))
+			}
+		}
+		return groupArg(t, inner)
+	case *sys.StructType:
+		var inner []*Arg
+		for _, fld := range t.Fields {
+			inner = append(inner, cv.defaultArg(fld))
+		}
+		return groupArg(t, inner)
+	case *sys.UnionType:
+		return unionArg(t, cv.defaultArg(t.Options[0]), t.Options[0])
+	case *sys.CondType:
+		return groupArg(t, nil)
+	default:
+		return constArg(typ, typ.Default())
+	}
+}
+
+func (cv *straceConv) pointer(typ sys.Type, inner *Arg) *Arg {
+	npages := (inner.Size() + defaultTarget.PageSize - 1) / defaultTarget.PageSize
+	if npages == 0 {
+		npages = 1
+	}
+	return pointerArg(typ, cv.alloc(npages), 0, 0, inner)
+}
+
+// alloc allocates npages of memory for pointer args, when the whole data area is used
+// the allocation starts from the beginning again (calls are executed sequentially,
+// so reusing memory of previous calls is mostly fine).
+func (cv *straceConv) alloc(npages uintptr) uintptr {
+	if npages > maxPages {
+		npages = maxPages
+	}
+	if cv.page+npages > maxPages {
+		cv.page = 0
+	}
+	page := cv.page
+	cv.page += npages
+	if cv.used < cv.page {
+		cv.used = cv.page
+	}
+	return page
+}
@@ -0,0 +1,102 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package prog
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseStrace(t *testing.T) {
+	trace := `
+1001  open("/etc/passwd", O_RDONLY|O_CLOEXEC) = 3
+1001  fstat(3, {st_mode=S_IFREG|0644, st_size=2345, ...}) = 0
+1001  read(3, "root:x:0:0:root:/root:/bin/bash\n"..., 4096) = 2345
+1002  socket(AF_UNIX, SOCK_STREAM, 0 <unfinished ...>
+1001  close(3)                          = 0
+1002  <... socket resumed> )            = 3
+1001  read(3, "", 10)                   = -1 EBADF (Bad file descriptor)
+1002  connect(3, {sa_family=AF_UNIX, sun_path="/var/run/nscd/socket"}, 110) = -1 ENOENT (No such file or directory)
+1001  pipe([4, 5])                      = 0
+1001  write(5, "\x01\x02\x03", 3)       = 3
+1001  foobar(1, 2)                      = 0
+1001  --- SIGCHLD {si_signo=SIGCHLD, si_code=CLD_EXITED, si_pid=1002} ---
+1002  +++ exited with 0 +++
+`
+	consts := map[string]uint64{
+		"O_RDONLY":    0,
+		"O_CLOEXEC":   0x80000,
+		"S_IFREG":     0x8000,
+		"AF_UNIX":     1,
+		"SOCK_STREAM": 1,
+	}
+	progs, err := ParseStrace([]byte(trace), consts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(progs) != 2 {
+		t.Fatalf("got %v programs, want 2", len(progs))
+	}
+	want := []string{
+		`r0 = open(&(0x7f0000000000)="2f6574632f70617373776400", 0x80000, 0x0)`,
+		`fstat(r0, &(0x7f0000001000)={0x0, 0x0, 0x0,`,
+		`read(r0, &(0x7f0000002000)="` + strings.Repeat("00", 32) + `", 0x20)`,
+		`close(r0)`,
+		`read(0xffffffffffffffff, &(0x7f0000003000)="", 0x0)`,
+		`pipe(&(0x7f0000004000)={0xffffffffffffffff, <r1=>0xffffffffffffffff})`,
+		`write(r1, &(0x7f0000005000)="010203", 0x3)`,
+	}
+	checkStraceProg(t, progs[0], want)
+	checkStraceProg(t, progs[1], []string{
+		`r0 = socket$unix(0x1, 0x1, 0x0)`,
+		`connect$unix(r0,`,
+	})
+}
+
+func checkStraceProg(t *testing.T, p *Prog, want []string) {
+	lines := strings.Split(strings.TrimSpace(string(p.Serialize())), "\n")
+	if lines[0] != "# origin: strace" || !strings.HasPrefix(lines[2], "mmap(") {
+		t.Fatalf("bad program header:\n%s", p.Serialize())
+	}
+	lines = lines[3:]
+	if len(lines) != len(want) {
+		t.Fatalf("got %v calls, want %v:\n%s", len(lines), len(want), p.Serialize())
+	}
+	for i, line := range lines {
+		if !strings.HasPrefix(line, want[i]) {
+			t.Fatalf("bad call #%v:\n%v\nwant:\n%v", i, line, want[i])
+		}
+	}
+	if _, err := Deserialize(p.Serialize()); err != nil {
+		t.Fatalf("failed to deserialize converted program: %v\n%s", err, p.Serialize())
+	}
+}
+
+func TestParseStraceValues(t *testing.T) {
+	consts := map[string]uint64{"A": 1, "B": 4}
+	for _, test := range []struct {
+		expr string
+		val  uint64
+		ok   bool
+	}{
+		{"0", 0, true},
+		{"-1", ^uint64(0), true},
+		{"0x10", 0x10, true},
+		{"0644", 0644, true},
+		{"A|B", 5, true},
+		{"A|B|0x10", 0x15, true},
+		{"1<<3", 8, true},
+		{"NULL", 0, true},
+		{"C", 0, false},
+		{"htons(80)", 80, true},
+		{"inet_addr(\"127.0.0.1\")", 0x7f000001, true},
+		{"makedev(0x1, 0x3)", 0x103, true},
+	} {
+		p := &straceParser{s: test.expr}
+		val, ok := straceEval(p.value(), consts)
+		if val != test.val || ok != test.ok {
+			t.Errorf("%v: got %v/%v, want %v/%v", test.expr, val, ok, test.val, test.ok)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package prog
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+)
+
+// Target describes parameters of the architecture that programs are generated/executed for.
+// Note: syscall descriptions (sys package, incl. syscall numbers) are still compiled for a single
+// architecture, so Target only covers parameters that prog itself uses to lay out and encode programs,
+// and only targets that share the host descriptions (64-bit little-endian) are listed.
+type Target struct {
+	Arch       string
+	PtrSize    uintptr
+	PageSize   uintptr
+	DataOffset uintptr // address of the data area that pointers point into
+}
+
+var targets = map[string]*Target{
+	"amd64": {
+		Arch:       "amd64",
+		PtrSize:    8,
+		PageSize:   4 << 10,
+		DataOffset: 512 << 20,
+	},
+	"arm64": {
+		Arch:       "arm64",
+		PtrSize:    8,
+		PageSize:   4 << 10,
+		DataOffset: 512 << 20,
+	},
+	"ppc64le": {
+		Arch:       "ppc64le",
+		PtrSize:    8,
+		PageSize:   4 << 10,
+		DataOffset: 512 << 20,
+	},
+}
+
+// defaultTarget is used for programs that don't have Target set.
+var defaultTarget = func() *Target {
+	if t := targets[runtime.GOARCH]; t != nil {
+		return t
+	}
+	return targets["amd64"]
+}()
+
+// GetTarget returns target for the architecture arch.
+func GetTarget(arch string) (*Target, error) {
+	t := targets[arch]
+	if t == nil {
+		return nil, fmt.Errorf("unknown arch %q (supported: %v)", arch, Arches())
+	}
+	return t, nil
+}
+
+// DefaultTarget returns target for the architecture the binary is built for.
+func DefaultTarget() *Target {
+	return defaultTarget
+}
+
+// Arches returns sorted list of supported architectures.
+func Arches() []string {
+	var arches []string
+	for arch := range targets {
+		arches = append(arches, arch)
+	}
+	sort.Strings(arches)
+	return arches
+}
+
+// target returns target of the program.
+func (p *Prog) target() *Target {
+	if p.Target == nil {
+		return defaultTarget
+	}
+	return p.Target
+}
+
+// physicalAddr returns address of pointer arg in the target address space.
+func (t *Target) physicalAddr(arg *Arg) uintptr {
+	if arg.Kind != ArgPointer {
+		panic("physicalAddr: bad arg kind")
+	}
+	addr := arg.AddrPage*t.PageSize + t.DataOffset
+	if arg.AddrOffset >= 0 {
+		addr += uintptr(arg.AddrOffset)
+	} else {
+		addr += t.PageSize - uintptr(-arg.AddrOffset)
+	}
+	return addr
+}
@@ -86,7 +86,7 @@ func (c *Call) validate(ctx *validCtx) error {
 			default:
 				return fmt.Errorf("syscall %v: fd arg '%v' has bad kind %v", c.Meta.Name, typ.Name(), arg.Kind)
 			}
-		case *sys.StructType, *sys.ArrayType:
+		case *sys.StructType, *sys.ArrayType, *sys.CondType:
 			switch arg.Kind {
 			case ArgGroup:
 			default:
@@ -173,6 +173,20 @@ func (c *Call) validate(ctx *validCtx) error {
 						return err
 					}
 				}
+				for _, arg1 := range arg.Inner {
+					if _, ok := arg1.Type.(*sys.CondType); ok && len(arg1.Inner) != 0 && !condMatches(arg.Inner, arg1) {
+						return fmt.Errorf("syscall %v: conditional field '%v' is present, but its condition does not hold", c.Meta.Name, arg1.Type.FieldName())
+					}
+				}
+			case *sys.CondType:
+				if len(arg.Inner) > 1 {
+					return fmt.Errorf("syscall %v: conditional field '%v' has %v inner args", c.Meta.Name, typ.Name(), len(arg.Inner))
+				}
+				for _, arg1 := range arg.Inner {
+					if err := checkArg(arg1, typ1.Type); err != nil {
+						return err
+					}
+				}
 			case *sys.ArrayType:
 				for _, arg1 := range arg.Inner {
 					if err := checkArg(arg1, typ1.Type); err != nil {
@@ -207,6 +221,9 @@ func (c *Call) validate(ctx *validCtx) error {
 		return nil
 	}
 	for i, arg := range c.Args {
+		if arg == nil {
+			return fmt.Errorf("syscall %v: nil arg", c.Meta.Name)
+		}
 		if arg.Kind == ArgReturn {
 			return fmt.Errorf("syscall %v: arg '%v' has wrong return kind", c.Meta.Name, arg.Type.Name())
 		}
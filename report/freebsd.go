@@ -0,0 +1,60 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package report
+
+import (
+	"regexp"
+)
+
+var freebsdOopses = []*oops{
+	&oops{
+		[]byte("Fatal trap"),
+		[]oopsFormat{
+			{
+				// The first frames are kdb_backtrace, panic and trap handlers, the guilty frame follows calltrap.
+				compile("Fatal trap ([0-9]+): (.*) while in kernel mode(?:.*\\n)+?.*#[0-9]+ {{ADDR}} at calltrap\\+.*\\n.*#[0-9]+ {{ADDR}} at {{FUNC}}"),
+				"Fatal trap %[1]v: %[2]v in %[3]v",
+			},
+			{
+				compile("Fatal trap ([0-9]+): (.*) while in kernel mode"),
+				"Fatal trap %[1]v: %[2]v",
+			},
+		},
+		[]*regexp.Regexp{
+			compile("while in user mode"),
+		},
+	},
+	&oops{
+		[]byte("panic:"),
+		[]oopsFormat{
+			{
+				compile("panic: ([a-zA-Z0-9_]+): (?:.*\\n)+?.*#[0-9]+ {{ADDR}} at panic\\+.*\\n.*#[0-9]+ {{ADDR}} at {{FUNC}}"),
+				"panic: %[1]v in %[2]v",
+			},
+			{
+				compile("panic: (.*)"),
+				"panic: %[1]v",
+			},
+		},
+		[]*regexp.Regexp{
+			// Go panics of syz-fuzzer and syz-executor.
+			compile("panic: runtime error"),
+			compile("panic: executor failed"),
+		},
+	},
+	&oops{
+		[]byte("lock order reversal:"),
+		[]oopsFormat{
+			{
+				compile("lock order reversal:.*\\n.*1st {{ADDR}} ([^ ]+) .*\\n.*2nd {{ADDR}} ([^ ]+) "),
+				"lock order reversal: %[1]v / %[2]v",
+			},
+			{
+				compile("lock order reversal:"),
+				"lock order reversal",
+			},
+		},
+		[]*regexp.Regexp{},
+	},
+}
@@ -0,0 +1,53 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package report
+
+import (
+	"regexp"
+)
+
+var fuchsiaOopses = []*oops{
+	&oops{
+		[]byte("ZIRCON KERNEL PANIC"),
+		[]oopsFormat{
+			{
+				compile("ZIRCON KERNEL PANIC(?:.*\\n)+?.*panic \\(caller {{ADDR}} frame {{ADDR}}\\): (.*)"),
+				"ZIRCON KERNEL PANIC: %[1]v",
+			},
+			{
+				compile("ZIRCON KERNEL PANIC(?:.*\\n)+?.*ASSERT FAILED at \\((?:.*/)?([^/]+)\\): (.*)"),
+				"ZIRCON KERNEL PANIC: ASSERT FAILED at %[1]v: %[2]v",
+			},
+			{
+				compile("ZIRCON KERNEL PANIC"),
+				"ZIRCON KERNEL PANIC",
+			},
+		},
+		[]*regexp.Regexp{},
+	},
+	&oops{
+		[]byte("ASSERT FAILED at"),
+		[]oopsFormat{
+			{
+				compile("ASSERT FAILED at \\((?:.*/)?([^/]+)\\): (.*)"),
+				"ASSERT FAILED at %[1]v: %[2]v",
+			},
+		},
+		[]*regexp.Regexp{},
+	},
+	&oops{
+		[]byte("<== fatal exception"),
+		[]oopsFormat{
+			{
+				compile("<== fatal exception: process ([^\\[]+)\\[[0-9]+\\]"),
+				"fatal exception in %[1]v",
+			},
+			{
+				compile("<== fatal exception"),
+				"fatal exception",
+			},
+		},
+		[]*regexp.Regexp{},
+	},
+}
@@ -0,0 +1,205 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package report
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Maintainers allows to find maintainers of kernel source files.
+// It implements the subset of get_maintainer.pl logic that depends only on the MAINTAINERS file:
+// F: (file patterns), X: (excluded patterns) and N: (file name regexps) entries.
+type Maintainers struct {
+	sections []*maintainersSection
+}
+
+type maintainersSection struct {
+	name     string
+	emails   []string // M: and R: entries
+	lists    []string // L: entries
+	files    []string
+	excludes []string
+	regexps  []*regexp.Regexp
+}
+
+// ReadMaintainers parses MAINTAINERS file in the kernel source directory.
+func ReadMaintainers(kernelSrc string) (*Maintainers, error) {
+	data, err := ioutil.ReadFile(filepath.Join(kernelSrc, "MAINTAINERS"))
+	if err != nil {
+		return nil, err
+	}
+	return ParseMaintainers(data)
+}
+
+// ParseMaintainers parses contents of MAINTAINERS file.
+func ParseMaintainers(data []byte) (*Maintainers, error) {
+	m := &Maintainers{}
+	var sec *maintainersSection
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for lineNo := 1; s.Scan(); lineNo++ {
+		line := strings.TrimRight(s.Text(), " \t\r")
+		if line == "" {
+			sec = nil
+			continue
+		}
+		if len(line) < 2 || line[1] != ':' || line[0] < 'A' || line[0] > 'Z' {
+			// Section name (or the preamble text before the first section).
+			sec = &maintainersSection{name: line}
+			m.sections = append(m.sections, sec)
+			continue
+		}
+		if sec == nil {
+			continue
+		}
+		val := strings.TrimSpace(line[2:])
+		switch line[0] {
+		case 'M', 'R':
+			if email := extractEmail(val); email != "" {
+				sec.emails = append(sec.emails, email)
+			}
+		case 'L':
+			if email := extractEmail(val); email != "" {
+				sec.lists = append(sec.lists, email)
+			}
+		case 'F':
+			sec.files = append(sec.files, val)
+		case 'X':
+			sec.excludes = append(sec.excludes, val)
+		case 'N':
+			re, err := regexp.Compile(val)
+			if err != nil {
+				return nil, fmt.Errorf("line %v: bad N: regexp: %v", lineNo, err)
+			}
+			sec.regexps = append(sec.regexps, re)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Lookup returns emails of maintainers and mailing lists responsible for the source file
+// (e.g. as returned by ExtractGuiltyFile). Addresses from the most specific sections go first.
+func (m *Maintainers) Lookup(file string) []string {
+	var matches []maintainersMatch
+	for _, sec := range m.sections {
+		if depth := sec.match(file); depth >= 0 {
+			matches = append(matches, maintainersMatch{sec, depth})
+		}
+	}
+	sort.Stable(maintainersMatchArray(matches))
+	var res []string
+	dup := make(map[string]bool)
+	add := func(emails []string) {
+		for _, email := range emails {
+			if !dup[email] {
+				dup[email] = true
+				res = append(res, email)
+			}
+		}
+	}
+	for _, m := range matches {
+		add(m.sec.emails)
+	}
+	for _, m := range matches {
+		add(m.sec.lists)
+	}
+	return res
+}
+
+type maintainersMatch struct {
+	sec   *maintainersSection
+	depth int
+}
+
+type maintainersMatchArray []maintainersMatch
+
+func (a maintainersMatchArray) Len() int           { return len(a) }
+func (a maintainersMatchArray) Less(i, j int) bool { return a[i].depth > a[j].depth }
+func (a maintainersMatchArray) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+// match returns depth of the most specific pattern of the section that matches file,
+// or -1 if the section does not cover file.
+func (sec *maintainersSection) match(file string) int {
+	for _, pattern := range sec.excludes {
+		if matchFilePattern(pattern, file) {
+			return -1
+		}
+	}
+	depth := -1
+	for _, pattern := range sec.files {
+		if matchFilePattern(pattern, file) {
+			if d := patternDepth(pattern); d > depth {
+				depth = d
+			}
+		}
+	}
+	for _, re := range sec.regexps {
+		if re.MatchString(file) && depth < 0 {
+			depth = 0
+		}
+	}
+	return depth
+}
+
+// matchFilePattern matches file against F:/X: pattern as get_maintainer.pl does:
+// patterns ending with "/" match all files in the directory and its subdirectories,
+// wildcards match files only in the directory itself.
+func matchFilePattern(pattern, file string) bool {
+	if strings.HasSuffix(pattern, "/") {
+		// Match the pattern against the same number of leading path components of file.
+		n := strings.Count(pattern, "/")
+		pos := 0
+		for i := 0; i < n; i++ {
+			next := strings.IndexByte(file[pos:], '/')
+			if next == -1 {
+				return false
+			}
+			pos += next + 1
+		}
+		ok, _ := filepath.Match(pattern, file[:pos])
+		return ok
+	}
+	if ok, _ := filepath.Match(pattern, file); ok {
+		return true
+	}
+	// A pattern without wildcards can name a directory without the trailing slash.
+	if !strings.ContainsAny(pattern, "*?[") {
+		return strings.HasPrefix(file, pattern+"/")
+	}
+	return false
+}
+
+// patternDepth returns specificity of F: pattern: the number of path components,
+// a wildcard in the last component makes the pattern less specific.
+func patternDepth(pattern string) int {
+	pattern = strings.TrimSuffix(pattern, "/")
+	depth := 2 * (strings.Count(pattern, "/") + 1)
+	if strings.ContainsAny(pattern[strings.LastIndexByte(pattern, '/')+1:], "*?[") {
+		depth--
+	}
+	return depth
+}
+
+// extractEmail extracts the address from entries like "John Doe <john@doe.org>"
+// or "netdev@vger.kernel.org (moderated for non-subscribers)".
+func extractEmail(val string) string {
+	if start := strings.IndexByte(val, '<'); start != -1 {
+		if end := strings.IndexByte(val[start:], '>'); end != -1 {
+			return val[start+1 : start+end]
+		}
+	}
+	if fields := strings.Fields(val); len(fields) != 0 && strings.Contains(fields[0], "@") {
+		return fields[0]
+	}
+	return ""
+}
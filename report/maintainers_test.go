@@ -0,0 +1,97 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package report
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testMaintainers = `
+List of maintainers and how to submit kernel changes
+
+	Descriptions of section entries:
+
+	P: Person (obsolete)
+	M: Mail patches to: FullName <address@domain>
+
+Maintainers List (try to look for most precise areas first)
+
+		-----------------------------------
+
+NETWORKING [GENERAL]
+M:	"David S. Miller" <davem@davemloft.net>
+L:	netdev@vger.kernel.org
+W:	http://www.linuxfoundation.org/en/Net
+S:	Maintained
+F:	net/
+F:	include/net/
+X:	net/ipv4/
+X:	net/ipv6/
+
+NETWORKING [IPv4/IPv6]
+M:	"David S. Miller" <davem@davemloft.net>
+M:	Alexey Kuznetsov <kuznet@ms2.inr.ac.ru>
+L:	netdev@vger.kernel.org
+S:	Maintained
+F:	net/ipv4/
+F:	net/ipv6/
+
+NETWORKING [TCP]
+M:	Eric Dumazet <edumazet@google.com>
+L:	netdev@vger.kernel.org
+S:	Maintained
+F:	net/ipv4/tcp*.c
+F:	net/ipv4/syncookies.c
+
+SCTP PROTOCOL
+M:	Vlad Yasevich <vyasevich@gmail.com>
+L:	linux-sctp@vger.kernel.org
+S:	Maintained
+F:	net/sctp
+F:	include/net/sctp
+
+KVM
+R:	Reviewer One <reviewer@kernel.org>
+L:	kvm@vger.kernel.org (moderated for non-subscribers)
+N:	kvm
+
+THE REST
+M:	Linus Torvalds <torvalds@linux-foundation.org>
+L:	linux-kernel@vger.kernel.org
+S:	Buried alive in reporters
+F:	*
+F:	*/
+`
+
+func TestMaintainers(t *testing.T) {
+	m, err := ParseMaintainers([]byte(testMaintainers))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string][]string{
+		"net/ipv4/tcp_input.c": []string{"edumazet@google.com", "davem@davemloft.net", "kuznet@ms2.inr.ac.ru",
+			"torvalds@linux-foundation.org", "netdev@vger.kernel.org", "linux-kernel@vger.kernel.org"},
+		"net/ipv4/ip_output.c": []string{"davem@davemloft.net", "kuznet@ms2.inr.ac.ru",
+			"torvalds@linux-foundation.org", "netdev@vger.kernel.org", "linux-kernel@vger.kernel.org"},
+		"net/core/skbuff.c": []string{"davem@davemloft.net", "torvalds@linux-foundation.org",
+			"netdev@vger.kernel.org", "linux-kernel@vger.kernel.org"},
+		"net/sctp/socket.c": []string{"vyasevich@gmail.com", "davem@davemloft.net", "torvalds@linux-foundation.org",
+			"linux-sctp@vger.kernel.org", "netdev@vger.kernel.org", "linux-kernel@vger.kernel.org"},
+		"arch/x86/kvm/x86.c": []string{"torvalds@linux-foundation.org", "reviewer@kernel.org",
+			"linux-kernel@vger.kernel.org", "kvm@vger.kernel.org"},
+		"Makefile": []string{"torvalds@linux-foundation.org", "linux-kernel@vger.kernel.org"},
+	}
+	for file, want := range tests {
+		if got := m.Lookup(file); !reflect.DeepEqual(got, want) {
+			t.Errorf("Lookup(%q):\ngot:  %q\nwant: %q", file, got, want)
+		}
+	}
+}
+
+func TestMaintainersBadRegexp(t *testing.T) {
+	if _, err := ParseMaintainers([]byte("FOO\nN:\t(foo\n")); err == nil {
+		t.Fatalf("no error for a bad N: regexp")
+	}
+}
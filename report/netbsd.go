@@ -0,0 +1,44 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package report
+
+import (
+	"regexp"
+)
+
+var netbsdOopses = []*oops{
+	&oops{
+		[]byte(" in supervisor mode"),
+		[]oopsFormat{
+			{
+				// ddb backtrace: the guilty frame follows the trap frame.
+				compile("fatal ([a-z ]+) in supervisor mode(?:.*\\n)+?.*--- trap \\(number [0-9]+\\) ---.*\\n(?:\\[ *[0-9]+\\.[0-9]+\\] )?([a-zA-Z0-9_]+)\\(\\) at "),
+				"%[1]v in %[2]v",
+			},
+			{
+				compile("fatal ([a-z ]+) in supervisor mode"),
+				"%[1]v in supervisor mode",
+			},
+		},
+		[]*regexp.Regexp{},
+	},
+	&oops{
+		[]byte("panic: "),
+		[]oopsFormat{
+			{
+				compile("panic: kernel diagnostic assertion \"(.*)\" failed: file \"(?:.*/)?([^/\"]+)\", line ([0-9]+)"),
+				"assert failed: %[2]v:%[3]v: %[1]v",
+			},
+			{
+				compile("panic: (.*)"),
+				"panic: %[1]v",
+			},
+		},
+		[]*regexp.Regexp{
+			// Go panics of syz-fuzzer and syz-executor.
+			compile("panic: runtime error"),
+			compile("panic: executor failed"),
+		},
+	},
+}
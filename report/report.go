@@ -38,6 +38,18 @@ var oopses = []*oops{
 				compile("BUG: KASAN: ([a-z\\-]+) on address(?:.*\\n)+?.*(Read|Write) of size ([0-9]+)"),
 				"KASAN: %[1]v %[2]v of size %[3]v",
 			},
+			{
+				compile("BUG: KMSAN: ([a-z\\-]+) in {{FUNC}}"),
+				"KMSAN: %[1]v in %[2]v",
+			},
+			{
+				compile("BUG: KCSAN: data-race in {{RACEFUNC}} / {{RACEFUNC}}"),
+				"KCSAN: data-race in %[1]v / %[2]v",
+			},
+			{
+				compile("BUG: KCSAN: data-race in {{RACEFUNC}}"),
+				"KCSAN: data-race in %[1]v",
+			},
 			{
 				compile("BUG: unable to handle kernel paging request(?:.*\\n)+?.*IP: {{PC}} +{{FUNC}}"),
 				"BUG: unable to handle kernel paging request in %[1]v",
@@ -58,6 +70,10 @@ var oopses = []*oops{
 				compile("BUG: spinlock recursion"),
 				"BUG: spinlock recursion",
 			},
+			{
+				compile("BUG: soft lockup(?:.*\\n)+?.*RIP: [0-9]+:(?:{{PC}} +{{PC}} +)?{{FUNC}}"),
+				"BUG: soft lockup in %[1]v",
+			},
 			{
 				compile("BUG: soft lockup"),
 				"BUG: soft lockup",
@@ -84,6 +100,22 @@ var oopses = []*oops{
 	&oops{
 		[]byte("WARNING:"),
 		[]oopsFormat{
+			{
+				compile("WARNING: possible circular locking dependency detected(?:.*\\n)+?.*is trying to acquire lock:.*\\n{{LOCK}}(?:.*\\n)+?.*already holding lock:.*\\n{{LOCK}}"),
+				"possible deadlock in %[2]v (%[3]v / %[1]v)",
+			},
+			{
+				compile("WARNING: possible circular locking dependency detected(?:.*\\n)+?.*is trying to acquire lock:.*\\n{{LOCK}}"),
+				"possible deadlock in %[2]v (%[1]v)",
+			},
+			{
+				compile("WARNING: possible recursive locking detected(?:.*\\n)+?.*is trying to acquire lock:.*\\n{{LOCK}}"),
+				"possible recursive locking of %[1]v in %[2]v",
+			},
+			{
+				compile("WARNING: inconsistent lock state(?:.*\\n)+?.*takes:.*\\n{{LOCK}}"),
+				"inconsistent lock state of %[1]v in %[2]v",
+			},
 			{
 				compile("WARNING: .* at {{SRC}} {{FUNC}}"),
 				"WARNING in %[2]v",
@@ -97,8 +129,24 @@ var oopses = []*oops{
 		[]byte("INFO:"),
 		[]oopsFormat{
 			{
-				compile("INFO: possible circular locking dependency detected \\](?:.*\\n)+?.*is trying to acquire lock(?:.*\\n)+?.*at: {{PC}} +{{FUNC}}"),
-				"possible deadlock in %[1]v",
+				compile("INFO: possible circular locking dependency detected \\](?:.*\\n)+?.*is trying to acquire lock:.*\\n{{LOCK}}(?:.*\\n)+?.*already holding lock:.*\\n{{LOCK}}"),
+				"possible deadlock in %[2]v (%[3]v / %[1]v)",
+			},
+			{
+				compile("INFO: possible circular locking dependency detected \\](?:.*\\n)+?.*is trying to acquire lock:.*\\n{{LOCK}}"),
+				"possible deadlock in %[2]v (%[1]v)",
+			},
+			{
+				compile("INFO: possible recursive locking detected \\](?:.*\\n)+?.*is trying to acquire lock:.*\\n{{LOCK}}"),
+				"possible recursive locking of %[1]v in %[2]v",
+			},
+			{
+				compile("INFO: inconsistent lock state \\](?:.*\\n)+?.*takes:.*\\n{{LOCK}}"),
+				"inconsistent lock state of %[1]v in %[2]v",
+			},
+			{
+				compile("INFO: rcu_(?:preempt|sched|bh) (?:self-)?detected stall(?:.*\\n)+?.*RIP: [0-9]+:(?:{{PC}} +{{PC}} +)?{{FUNC}}"),
+				"INFO: rcu detected stall in %[1]v",
 			},
 			{
 				compile("INFO: rcu_preempt detected stalls"),
@@ -131,6 +179,20 @@ var oopses = []*oops{
 			compile("INFO: NMI handler .* took too long to run"),
 		},
 	},
+	&oops{
+		[]byte("Watchdog detected hard LOCKUP"),
+		[]oopsFormat{
+			{
+				compile("Watchdog detected hard LOCKUP(?:.*\\n)+?.*RIP: [0-9]+:(?:{{PC}} +{{PC}} +)?{{FUNC}}"),
+				"BUG: hard lockup in %[1]v",
+			},
+			{
+				compile("Watchdog detected hard LOCKUP"),
+				"BUG: hard lockup",
+			},
+		},
+		[]*regexp.Regexp{},
+	},
 	&oops{
 		[]byte("Unable to handle kernel paging request"),
 		[]oopsFormat{
@@ -217,7 +279,48 @@ var oopses = []*oops{
 	},
 	&oops{
 		[]byte("UBSAN:"),
-		[]oopsFormat{},
+		[]oopsFormat{
+			{
+				compile("UBSAN: ([a-z0-9\\-]+) in (?:\\./)?{{SRC}}"),
+				"UBSAN: %[1]v in %[2]v",
+			},
+			{
+				compile("UBSAN: Undefined behaviour in (?:\\./)?{{SRC}}.*\\n.*(?:shift exponent|negative shift|left shift of)"),
+				"UBSAN: shift-out-of-bounds in %[1]v",
+			},
+			{
+				compile("UBSAN: Undefined behaviour in (?:\\./)?{{SRC}}.*\\n.*index .* is out of range"),
+				"UBSAN: array-index-out-of-bounds in %[1]v",
+			},
+			{
+				compile("UBSAN: Undefined behaviour in (?:\\./)?{{SRC}}.*\\n.*signed integer overflow"),
+				"UBSAN: signed-integer-overflow in %[1]v",
+			},
+			{
+				compile("UBSAN: Undefined behaviour in (?:\\./)?{{SRC}}.*\\n.*negation of"),
+				"UBSAN: negation-overflow in %[1]v",
+			},
+			{
+				compile("UBSAN: Undefined behaviour in (?:\\./)?{{SRC}}.*\\n.*division (?:by zero|of)"),
+				"UBSAN: division-overflow in %[1]v",
+			},
+			{
+				compile("UBSAN: Undefined behaviour in (?:\\./)?{{SRC}}.*\\n.*load of value"),
+				"UBSAN: invalid-load in %[1]v",
+			},
+			{
+				compile("UBSAN: Undefined behaviour in (?:\\./)?{{SRC}}.*\\n.*misaligned address"),
+				"UBSAN: misaligned-access in %[1]v",
+			},
+			{
+				compile("UBSAN: Undefined behaviour in (?:\\./)?{{SRC}}.*\\n.*null pointer"),
+				"UBSAN: null-ptr-deref in %[1]v",
+			},
+			{
+				compile("UBSAN: Undefined behaviour in (?:\\./)?{{SRC}}"),
+				"UBSAN: Undefined behaviour in %[1]v",
+			},
+		},
 		[]*regexp.Regexp{},
 	},
 }
@@ -227,18 +330,97 @@ var (
 	questionableRe  = regexp.MustCompile(`(?:\[\<[0-9a-f]+\>\])? \? +[a-zA-Z0-9_.]+\+0x[0-9a-f]+/[0-9a-f]+`)
 	symbolizeRe     = regexp.MustCompile(`(?:\[\<(?:[0-9a-f]+)\>\])? +(?:[0-9]+:)?([a-zA-Z0-9_.]+)\+0x([0-9a-f]+)/0x([0-9a-f]+)`)
 	eoi             = []byte("<EOI>")
+	hangFrameRe     = regexp.MustCompile(`^(?:\[ *[0-9]+\.[0-9]+\] )? +(?:\[\<[0-9a-f]+\>\] )?([a-zA-Z0-9_]+)(?:\.|\+| )`)
+	hangSchedRe     = regexp.MustCompile(`^(?:__)?(?:schedule|io_schedule|preempt_schedule|context_switch|finish_task_switch|` +
+		`mutex_lock|rt_mutex|rwsem_down|call_rwsem|down|ldsem_down|wait_for_completion|wait_for_common|do_wait_for_common|` +
+		`wait_on_bit|out_of_line_wait_on_bit|bit_wait|lock_page|wait_on_page|percpu_down|_raw_spin)`)
+	kmsanOriginRe = regexp.MustCompile(`(?:Uninit was|Local variable .*) created at:`)
+	kmsanFrameRe  = regexp.MustCompile(`^(?:\[ *[0-9]+\.[0-9]+\] )? +(?:\[\<[0-9a-f]+\>\] )?([a-zA-Z0-9_]+)(?:\.|\+| |$)`)
+	// kmsanAllocRe matches KMSAN and allocator frames in origin stacks,
+	// these are the same for all origins and are skipped.
+	kmsanAllocRe = regexp.MustCompile(`^(?:__)?(?:kmsan_|msan_|kmalloc|kmem_cache_alloc|slab_|alloc_pages|__alloc_pages|` +
+		`kmalloc_|do_kmalloc|kzalloc|krealloc|vmalloc|__vmalloc|alloc_skb|__alloc_skb|__kmalloc|kmemdup|page_frag_alloc)`)
 )
 
 func compile(re string) *regexp.Regexp {
+	// Lockdep lock description: lock name, then the function where the lock is taken
+	// (inlined frames are printed as separate lines before the actual function).
+	re = strings.Replace(re, "{{LOCK}}", ".*?\\(([^ \\n]+?)\\)\\{[^}\\n]*\\}, at: (?:{{PC}} +)?"+
+		"(?:.* \\[inline\\].*\\n.*?\\([^ \\n]+?\\)\\{[^}\\n]*\\}, at: (?:{{PC}} +)?)*{{FUNC}}", -1)
 	re = strings.Replace(re, "{{ADDR}}", "0x[0-9a-f]+", -1)
 	re = strings.Replace(re, "{{PC}}", "\\[\\<[0-9a-f]+\\>\\]", -1)
 	re = strings.Replace(re, "{{FUNC}}", "([a-zA-Z0-9_]+)(?:\\.|\\+)", -1)
+	re = strings.Replace(re, "{{RACEFUNC}}", "([a-zA-Z0-9_]+)(?:[.+][a-zA-Z0-9_.+/]*)?", -1)
 	re = strings.Replace(re, "{{SRC}}", "([a-zA-Z0-9-_/.]+\\.[a-z]+:[0-9]+)", -1)
 	return regexp.MustCompile(re)
 }
 
-// ContainsCrash searches kernel console output for oops messages.
+// Reporter parses kernel console output of a particular OS.
+type Reporter interface {
+	// ContainsCrash searches kernel console output for oops messages.
+	ContainsCrash(output []byte) bool
+
+	// Parse extracts information about oops from console output.
+	// Desc contains a representative description of the first oops (empty if no oops found),
+	// text contains whole oops text,
+	// start and end denote region of output with oops message(s).
+	Parse(output []byte) (desc string, text []byte, start int, end int)
+}
+
+// NewReporter returns reporter for kernel os ("linux", "freebsd", "netbsd", "fuchsia" or "windows",
+// empty os means linux). Reports matching ignores are not considered as crashes.
+func NewReporter(os string, ignores []*regexp.Regexp) (Reporter, error) {
+	switch os {
+	case "", "linux":
+		return newLinuxReporter(ignores), nil
+	case "freebsd":
+		return &oopsReporter{oopses: freebsdOopses, ignores: ignores}, nil
+	case "netbsd":
+		return &oopsReporter{oopses: netbsdOopses, ignores: ignores, consoleLine: stripConsoleTimestamp}, nil
+	case "fuchsia":
+		return &oopsReporter{oopses: fuchsiaOopses, ignores: ignores, consoleLine: stripConsoleTimestamp}, nil
+	case "windows":
+		return &oopsReporter{oopses: windowsOopses, ignores: ignores}, nil
+	}
+	return nil, fmt.Errorf("unknown os %q", os)
+}
+
+// oopsReporter finds oopses by their headers and extracts descriptions with oopsFormat's.
+type oopsReporter struct {
+	oopses  []*oops
+	ignores []*regexp.Regexp
+	// consoleLine returns part of the console output line that belongs to the oops text,
+	// or nil if the line is not part of the text. If not set, all lines are included as is.
+	consoleLine func(line []byte) []byte
+	// postprocess canonicalizes or refines the description extracted from output.
+	postprocess func(desc string, output []byte) string
+}
+
+func newLinuxReporter(ignores []*regexp.Regexp) *oopsReporter {
+	return &oopsReporter{
+		oopses:      oopses,
+		ignores:     ignores,
+		consoleLine: linuxConsoleLine,
+		postprocess: func(desc string, output []byte) string {
+			desc = canonicalizeDataRace(desc)
+			desc = addKMSANOrigin(desc, output)
+			desc = addHungTaskFrame(desc, output)
+			return desc
+		},
+	}
+}
+
+// ContainsCrash searches Linux kernel console output for oops messages.
 func ContainsCrash(output []byte, ignores []*regexp.Regexp) bool {
+	return newLinuxReporter(ignores).ContainsCrash(output)
+}
+
+// Parse extracts information about oops from Linux kernel console output (see Reporter.Parse).
+func Parse(output []byte, ignores []*regexp.Regexp) (desc string, text []byte, start int, end int) {
+	return newLinuxReporter(ignores).Parse(output)
+}
+
+func (rep *oopsReporter) ContainsCrash(output []byte) bool {
 	for pos := 0; pos < len(output); {
 		next := bytes.IndexByte(output[pos:], '\n')
 		if next != -1 {
@@ -246,8 +428,8 @@ func ContainsCrash(output []byte, ignores []*regexp.Regexp) bool {
 		} else {
 			next = len(output)
 		}
-		for _, oops := range oopses {
-			match := matchOops(output[pos:next], oops, ignores)
+		for _, oops := range rep.oopses {
+			match := matchOops(output[pos:next], oops, rep.ignores)
 			if match == -1 {
 				continue
 			}
@@ -258,11 +440,7 @@ func ContainsCrash(output []byte, ignores []*regexp.Regexp) bool {
 	return false
 }
 
-// Parse extracts information about oops from console output.
-// Desc contains a representative description of the first oops (empty if no oops found),
-// text contains whole oops text,
-// start and end denote region of output with oops message(s).
-func Parse(output []byte, ignores []*regexp.Regexp) (desc string, text []byte, start int, end int) {
+func (rep *oopsReporter) Parse(output []byte) (desc string, text []byte, start int, end int) {
 	var oops *oops
 	for pos := 0; pos < len(output); {
 		next := bytes.IndexByte(output[pos:], '\n')
@@ -271,8 +449,8 @@ func Parse(output []byte, ignores []*regexp.Regexp) (desc string, text []byte, s
 		} else {
 			next = len(output)
 		}
-		for _, oops1 := range oopses {
-			match := matchOops(output[pos:next], oops1, ignores)
+		for _, oops1 := range rep.oopses {
+			match := matchOops(output[pos:next], oops1, rep.ignores)
 			if match == -1 {
 				continue
 			}
@@ -284,14 +462,15 @@ func Parse(output []byte, ignores []*regexp.Regexp) (desc string, text []byte, s
 			end = next
 		}
 		if oops != nil {
-			if consoleOutputRe.Match(output[pos:next]) &&
-				(!questionableRe.Match(output[pos:next]) || bytes.Index(output[pos:next], eoi) != -1) {
-				lineStart := bytes.Index(output[pos:next], []byte("] ")) + pos + 2
-				lineEnd := next
-				if lineEnd != 0 && output[lineEnd-1] == '\r' {
-					lineEnd--
+			line := output[pos:next]
+			if rep.consoleLine != nil {
+				line = rep.consoleLine(line)
+			}
+			if line != nil {
+				if len(line) != 0 && line[len(line)-1] == '\r' {
+					line = line[:len(line)-1]
 				}
-				text = append(text, output[lineStart:lineEnd]...)
+				text = append(text, line...)
 				text = append(text, '\n')
 			}
 		}
@@ -304,9 +483,261 @@ func Parse(output []byte, ignores []*regexp.Regexp) (desc string, text []byte, s
 	if len(desc) > 0 && desc[len(desc)-1] == '\r' {
 		desc = desc[:len(desc)-1]
 	}
+	if rep.postprocess != nil {
+		desc = rep.postprocess(desc, output[start:])
+	}
+	desc = normalizeDesc(desc)
 	return
 }
 
+// linuxConsoleLine strips the timestamp from Linux console output lines,
+// lines without timestamp (not printed by kernel) and questionable stack frames are skipped.
+func linuxConsoleLine(line []byte) []byte {
+	if !consoleOutputRe.Match(line) ||
+		questionableRe.Match(line) && bytes.Index(line, eoi) == -1 {
+		return nil
+	}
+	return line[bytes.Index(line, []byte("] "))+2:]
+}
+
+// stripConsoleTimestamp strips Linux-like "[  123.456] " timestamp if the line has it.
+func stripConsoleTimestamp(line []byte) []byte {
+	if consoleOutputRe.Match(line) {
+		return line[bytes.Index(line, []byte("] "))+2:]
+	}
+	return line
+}
+
+const kmsanPrefix = "KMSAN: uninit-value in "
+
+// addKMSANOrigin appends allocation site of the uninitialized value to KMSAN reports.
+// The same use of uninitialized value can be caused by unrelated bugs
+// in the code that allocates the value, so both sites are used for deduplication.
+func addKMSANOrigin(desc string, output []byte) string {
+	if !strings.HasPrefix(desc, kmsanPrefix) {
+		return desc
+	}
+	// Don't look for origin in the following reports.
+	header := []byte("BUG: KMSAN:")
+	if pos := bytes.Index(output, header); pos != -1 {
+		pos += len(header)
+		if next := bytes.Index(output[pos:], header); next != -1 {
+			output = output[:pos+next]
+		}
+	}
+	loc := kmsanOriginRe.FindIndex(output)
+	if loc == nil {
+		return desc
+	}
+	s := bufio.NewScanner(bytes.NewReader(output[loc[1]:]))
+	s.Scan() // the rest of the "created at:" line
+	for s.Scan() {
+		match := kmsanFrameRe.FindSubmatch(s.Bytes())
+		if match == nil {
+			break
+		}
+		fn := string(match[1])
+		if kmsanAllocRe.MatchString(fn) {
+			continue
+		}
+		return desc + " (origin " + fn + ")"
+	}
+	return desc
+}
+
+const dataRacePrefix = "KCSAN: data-race in "
+
+// IsDataRace returns true if desc (as returned by Parse) describes a data race
+// rather than a memory-safety bug or a kernel crash.
+func IsDataRace(desc string) bool {
+	return strings.HasPrefix(desc, dataRacePrefix)
+}
+
+const ubsanPrefix = "UBSAN: "
+
+// IsUBSAN returns true if desc (as returned by Parse) describes undefined behavior
+// detected by UBSAN. Unlike KASAN reports, UBSAN reports are not fatal and
+// the kernel continues to run after them.
+func IsUBSAN(desc string) bool {
+	return strings.HasPrefix(desc, ubsanPrefix)
+}
+
+var hangPrefixes = []string{
+	"INFO: task hung",
+	"INFO: rcu detected stall",
+	"BUG: soft lockup",
+	"BUG: hard lockup",
+	"BUG: spinlock lockup suspected",
+}
+
+// IsHang returns true if desc (as returned by Parse) describes a kernel hang
+// (hung task, soft/hard lockup or RCU stall) rather than a kernel crash.
+// Hangs are usually slow to reproduce and their logs are large and similar.
+func IsHang(desc string) bool {
+	for _, prefix := range hangPrefixes {
+		if strings.HasPrefix(desc, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+const hungTaskDesc = "INFO: task hung"
+
+// addHungTaskFrame appends the function where the hung task is stuck.
+// Frames of the scheduler and of sleeping primitives are the same for all hangs
+// and are skipped.
+func addHungTaskFrame(desc string, output []byte) string {
+	if desc != hungTaskDesc {
+		return desc
+	}
+	pos := bytes.Index(output, []byte("Call Trace:"))
+	if pos == -1 {
+		return desc
+	}
+	s := bufio.NewScanner(bytes.NewReader(output[pos:]))
+	s.Scan() // the "Call Trace:" line
+	for s.Scan() {
+		line := s.Bytes()
+		if questionableRe.Match(line) {
+			continue
+		}
+		match := hangFrameRe.FindSubmatch(line)
+		if match == nil {
+			break
+		}
+		fn := string(match[1])
+		if hangSchedRe.MatchString(fn) {
+			continue
+		}
+		return desc + " in " + fn
+	}
+	return desc
+}
+
+// Severity classifies crash descriptions returned by Parse.
+type Severity int
+
+const (
+	SeverityLow    Severity = iota // data races
+	SeverityMedium                 // undefined behavior
+	SeverityHigh                   // memory corruptions, kernel crashes and everything else
+)
+
+// GetSeverity returns severity of a crash with description desc.
+func GetSeverity(desc string) Severity {
+	switch {
+	case IsDataRace(desc):
+		return SeverityLow
+	case IsUBSAN(desc):
+		return SeverityMedium
+	default:
+		return SeverityHigh
+	}
+}
+
+// crashScores is an ordered list of crash description patterns with their scores,
+// the first matching pattern determines the score of a crash.
+var crashScores = []struct {
+	re    *regexp.Regexp
+	score int
+}{
+	{regexp.MustCompile(`^KASAN: (?:double-free|invalid-free)`), 100},
+	{regexp.MustCompile(`^KASAN: use-after-free Write`), 100},
+	{regexp.MustCompile(`^KASAN: [a-z\-]*out-of-bounds Write`), 90},
+	{regexp.MustCompile(`^KASAN: use-after-free Read`), 80},
+	{regexp.MustCompile(`^KASAN: [a-z\-]*out-of-bounds Read`), 70},
+	{regexp.MustCompile(`^(?:BUG: unable to handle kernel NULL pointer dereference|KASAN: null-ptr-deref)`), 50},
+	{regexp.MustCompile(`^KASAN: `), 60},
+	{regexp.MustCompile(`^(?:general protection fault|BUG: unable to handle kernel paging request)`), 60},
+	{regexp.MustCompile(`^KMSAN: `), 55},
+	{regexp.MustCompile(`^(?:kernel BUG|kernel panic|invalid opcode|divide error|BUG: )`), 40},
+	{regexp.MustCompile(`^(?:possible deadlock|possible recursive locking|inconsistent lock state)`), 35},
+	{regexp.MustCompile(`^WARNING`), 30},
+	{regexp.MustCompile(`^UBSAN: `), 25},
+	{regexp.MustCompile(`^memory leak`), 20},
+}
+
+// Score returns likely security impact of a crash with description desc (as returned by Parse)
+// in the range [0, 100]: memory corruptions (use-after-free writes first) score higher than
+// wild accesses, which score higher than WARNINGs, undefined behavior, leaks, hangs and data races.
+// Crashes that are not recognized (e.g. "lost connection to test machine") have score 0.
+func Score(desc string) int {
+	switch {
+	case IsDataRace(desc):
+		return 10
+	case IsHang(desc):
+		return 15
+	}
+	for _, s := range crashScores {
+		if s.re.MatchString(desc) {
+			return s.score
+		}
+	}
+	return 0
+}
+
+// canonicalizeDataRace orders the racing functions in a data race description,
+// so that "foo / bar" and "bar / foo" races are deduplicated as the same bug.
+func canonicalizeDataRace(desc string) string {
+	if !IsDataRace(desc) {
+		return desc
+	}
+	funcs := strings.Split(desc[len(dataRacePrefix):], " / ")
+	if len(funcs) != 2 || funcs[0] <= funcs[1] {
+		return desc
+	}
+	return dataRacePrefix + funcs[1] + " / " + funcs[0]
+}
+
+type descReplacement struct {
+	re  *regexp.Regexp
+	str string
+}
+
+// descReplacements replace elements of descriptions that change between kernel builds
+// and between occurrences of the same crash, so that they don't produce duplicate bugs.
+var descReplacements = []descReplacement{
+	{
+		// Console timestamps.
+		regexp.MustCompile(`\[ *[0-9]+\.[0-9]+\] ?`),
+		"",
+	},
+	{
+		// Executor process numbers and pids (syz-executor3/1234).
+		regexp.MustCompile(`syz-executor[0-9]*(?:/[0-9]+)?`),
+		"syz-executor",
+	},
+	{
+		// CPU and PID numbers (CPU#1, cpu 1, CPU: 1, PID: 1234, pid 1234).
+		regexp.MustCompile(`\b(CPU|cpu|PID|pid)(?:#|: ?| )[0-9]+`),
+		"${1}",
+	},
+	{
+		// Raw function references, offsets and sizes change with every build (foo+0x1052/0x2d80).
+		regexp.MustCompile(`\b([a-zA-Z_][a-zA-Z0-9_.]*)\+0x[0-9a-f]+(?:/0x[0-9a-f]+)?`),
+		"${1}",
+	},
+	{
+		// Pointers (0xffff88006bc1e8c0, ffffffff81234567).
+		regexp.MustCompile(`(^|[^a-zA-Z0-9_])(?:0x[0-9a-fA-F]{6,}|[0-9a-f]{8,})\b`),
+		"${1}ADDR",
+	},
+	{
+		// Source line numbers (fs/buffer.c:1917, include/linux/log2.h:57:13).
+		regexp.MustCompile(`(\.[a-zA-Z]+)(?::[0-9]+)+\b`),
+		"${1}:LINE",
+	},
+}
+
+// normalizeDesc replaces volatile elements of the description (see descReplacements).
+func normalizeDesc(desc string) string {
+	for _, repl := range descReplacements {
+		desc = repl.re.ReplaceAllString(desc, repl.str)
+	}
+	return strings.TrimSpace(desc)
+}
+
 func matchOops(line []byte, oops *oops, ignores []*regexp.Regexp) int {
 	match := bytes.Index(line, oops.header)
 	if match == -1 {
@@ -360,69 +791,55 @@ func extractDescription(output []byte, oops *oops) string {
 }
 
 func Symbolize(vmlinux string, text []byte) ([]byte, error) {
-	var symbolized []byte
-	symbols, err := symbolizer.ReadSymbols(vmlinux)
+	id, err := binaryID(vmlinux)
 	if err != nil {
 		return nil, err
 	}
-	symb := symbolizer.NewSymbolizer()
-	defer symb.Close()
-	symbFunc := func(bin string, pc uint64) ([]symbolizer.Frame, error) {
-		return symb.Symbolize(bin, pc)
+	bin, err := symbCache.get(id, func() (*symbBinary, error) {
+		return newSymbBinary(vmlinux)
+	})
+	if err != nil {
+		return nil, err
 	}
+	bin.mu.Lock()
+	defer bin.mu.Unlock()
 	// Strip vmlinux location from all paths.
-	strip, _ := filepath.Abs(vmlinux)
-	strip = filepath.Dir(strip) + string(filepath.Separator)
-	// Vmlinux may have been moved, so check if we can find debug info
-	// for __sanitizer_cov_trace_pc. We know where it is located,
-	// so we can infer correct strip prefix from it.
-	if covSymbols := symbols["__sanitizer_cov_trace_pc"]; len(covSymbols) != 0 {
-		for _, covSymb := range covSymbols {
-			frames, _ := symb.Symbolize(vmlinux, covSymb.Addr)
-			if len(frames) > 0 {
-				file := frames[len(frames)-1].File
-				if idx := strings.Index(file, "kernel/kcov.c"); idx != -1 {
-					strip = file[:idx]
-					break
-				}
-			}
-		}
+	strip := bin.strip
+	if strip == "" {
+		strip, _ = filepath.Abs(vmlinux)
+		strip = filepath.Dir(strip) + string(filepath.Separator)
 	}
+	// Symbolize all PCs in the text at once, addr2line is slow to answer one PC at a time.
+	var lines [][]byte
+	var pcs []uint64
 	s := bufio.NewScanner(bytes.NewReader(text))
 	for s.Scan() {
 		line := append([]byte{}, s.Bytes()...)
 		line = append(line, '\n')
-		line = symbolizeLine(symbFunc, symbols, vmlinux, strip, line)
-		symbolized = append(symbolized, line...)
+		lines = append(lines, line)
+		if pc, _, ok := symbolizeLinePC(bin.symbols, line); ok {
+			pcs = append(pcs, pc)
+		}
+	}
+	if err := bin.symbolize(vmlinux, pcs); err != nil {
+		return nil, err
+	}
+	symbFunc := func(_ string, pc uint64) ([]symbolizer.Frame, error) {
+		return bin.frames[pc], nil
+	}
+	var symbolized []byte
+	for _, line := range lines {
+		symbolized = append(symbolized, symbolizeLine(symbFunc, bin.symbols, vmlinux, strip, line)...)
 	}
 	return symbolized, nil
 }
 
 func symbolizeLine(symbFunc func(bin string, pc uint64) ([]symbolizer.Frame, error), symbols map[string][]symbolizer.Symbol, vmlinux, strip string, line []byte) []byte {
-	match := symbolizeRe.FindSubmatchIndex(line)
-	if match == nil {
-		return line
-	}
-	fn := line[match[2]:match[3]]
-	off, err := strconv.ParseUint(string(line[match[4]:match[5]]), 16, 64)
-	if err != nil {
+	pc, match, ok := symbolizeLinePC(symbols, line)
+	if !ok {
 		return line
 	}
-	size, err := strconv.ParseUint(string(line[match[6]:match[7]]), 16, 64)
-	if err != nil {
-		return line
-	}
-	symb := symbols[string(fn)]
-	if len(symb) == 0 {
-		return line
-	}
-	var funcStart uint64
-	for _, s := range symb {
-		if funcStart == 0 || int(size) == s.Size {
-			funcStart = s.Addr
-		}
-	}
-	frames, err := symbFunc(vmlinux, funcStart+off-1)
+	frames, err := symbFunc(vmlinux, pc)
 	if err != nil || len(frames) == 0 {
 		return line
 	}
@@ -449,6 +866,74 @@ func symbolizeLine(symbFunc func(bin string, pc uint64) ([]symbolizer.Frame, err
 }
 
 // replace replaces [start:end] in where with what, inplace.
+var (
+	guiltyFileRe = regexp.MustCompile(`(?:^|[ \t(])(?:\./)?([a-zA-Z0-9_\-/.]+\.(?:c|h|S)):[0-9]+`)
+	// guiltySkipRe matches files that appear in most reports (sanitizers, allocators,
+	// locking, scheduler, printing of the report itself, common helpers)
+	// and are unlikely to contain the bug.
+	guiltySkipRe = regexp.MustCompile(`^(?:mm/kasan/|mm/kmsan/|mm/sl[aou]b\.c|mm/slab_common\.c|mm/page_alloc\.c|` +
+		`mm/util\.c|mm/mempool\.c|mm/vmalloc\.c|mm/percpu\.c|lib/|include/|arch/[a-z0-9_]+/include/|` +
+		`arch/[a-z0-9_]+/kernel/|arch/[a-z0-9_]+/entry/|arch/x86/mm/fault\.c|arch/x86/lib/|` +
+		`kernel/kcov\.c|kernel/kcsan/|kernel/panic\.c|kernel/printk/|kernel/locking/|kernel/sched/|` +
+		`kernel/rcu/|kernel/time/|kernel/irq/|kernel/softirq\.c|kernel/workqueue\.c|kernel/kthread\.c|` +
+		`kernel/hung_task\.c|kernel/watchdog\.c|kernel/stacktrace\.c|kernel/exit\.c|kernel/fork\.c|kernel/signal\.c)`)
+)
+
+// ExtractGuiltyFile returns the kernel source file that most likely contains the bug
+// (the first file mentioned in the symbolized report that is not a common helper),
+// or an empty string if there are no source files in the report.
+func ExtractGuiltyFile(report []byte) string {
+	s := bufio.NewScanner(bytes.NewReader(report))
+	for s.Scan() {
+		for _, match := range guiltyFileRe.FindAllSubmatch(s.Bytes(), -1) {
+			file := string(match[1])
+			if !guiltySkipRe.MatchString(file) {
+				return file
+			}
+		}
+	}
+	return ""
+}
+
+// GuiltySubsystem returns the subsystem (source directory) of the guilty file
+// returned by ExtractGuiltyFile, e.g. "net/ipv4" for "net/ipv4/tcp.c".
+func GuiltySubsystem(file string) string {
+	dir := filepath.Dir(file)
+	if dir == "." {
+		return ""
+	}
+	return dir
+}
+
+// symbolizeLinePC returns PC of the call instruction for a "func+off/size" stack frame in line
+// and the corresponding symbolizeRe match.
+func symbolizeLinePC(symbols map[string][]symbolizer.Symbol, line []byte) (uint64, []int, bool) {
+	match := symbolizeRe.FindSubmatchIndex(line)
+	if match == nil {
+		return 0, nil, false
+	}
+	fn := line[match[2]:match[3]]
+	off, err := strconv.ParseUint(string(line[match[4]:match[5]]), 16, 64)
+	if err != nil {
+		return 0, nil, false
+	}
+	size, err := strconv.ParseUint(string(line[match[6]:match[7]]), 16, 64)
+	if err != nil {
+		return 0, nil, false
+	}
+	symb := symbols[string(fn)]
+	if len(symb) == 0 {
+		return 0, nil, false
+	}
+	var funcStart uint64
+	for _, s := range symb {
+		if funcStart == 0 || int(size) == s.Size {
+			funcStart = s.Addr
+		}
+	}
+	return funcStart + off - 1, match, true
+}
+
 func replace(where []byte, start, end int, what []byte) []byte {
 	if len(what) >= end-start {
 		where = append(where, what[end-start:]...)
@@ -130,7 +130,7 @@ WARNING: CPU: 3 PID: 23810 at /linux-src-3.18/net/netlink/genetlink.c:1037 genl_
 -------------------------------------------------------
 kacpi_hotplug/246 is trying to acquire lock:
  (kacpid){+.+.+.}, at: [<ffffffff8105bbd0>] flush_workqueue+0x0/0xb0
-`: `possible deadlock in flush_workqueue`,
+`: `possible deadlock in flush_workqueue (kacpid)`,
 
 		`
 [  131.449768] ======================================================
@@ -140,7 +140,69 @@ kacpi_hotplug/246 is trying to acquire lock:
 [  131.449807] swapper/2/0 is trying to acquire lock:
 [  131.449859]  (&port_lock_key){-.-...}, at: [<c036a6dc>]     serial8250_console_write+0x108/0x134
 [  131.449866] 
-`: `possible deadlock in serial8250_console_write`,
+`: `possible deadlock in serial8250_console_write (&port_lock_key)`,
+
+		`
+[   74.371446] ======================================================
+[   74.371449] [ INFO: possible circular locking dependency detected ]
+[   74.371453] 4.10.0-rc8+ #201 Not tainted
+[   74.371456] -------------------------------------------------------
+[   74.371459] syz-executor4/10765 is trying to acquire lock:
+[   74.371462]  (&mm->mmap_sem){++++++}, at: [<ffffffff8175ef01>] __might_fault+0xd1/0x1f0
+[   74.371470] 
+[   74.371470] but task is already holding lock:
+[   74.371474]  (&pipe->mutex/1){+.+.+.}, at: [<ffffffff81a0b7c6>] pipe_lock_nested fs/pipe.c:66 [inline]
+[   74.371474]  (&pipe->mutex/1){+.+.+.}, at: [<ffffffff81a0b7c6>] pipe_lock+0x56/0x70 fs/pipe.c:74
+[   74.371485] 
+[   74.371485] which lock already depends on the new lock.
+`: `possible deadlock in __might_fault (&pipe->mutex/1 / &mm->mmap_sem)`,
+
+		`
+[   63.224551] ======================================================
+[   63.224553] WARNING: possible circular locking dependency detected
+[   63.224556] 4.15.0-rc1+ #124 Not tainted
+[   63.224558] ------------------------------------------------------
+[   63.224561] syz-executor1/3651 is trying to acquire lock:
+[   63.224563]  (&(&port->lock)->rlock){-.-.}, at: [<00000000a6ad2a56>] pty_write+0xf9/0x1f0 drivers/tty/pty.c:120
+[   63.224575] 
+[   63.224575] but task is already holding lock:
+[   63.224577]  (&buf->lock){+.+.}, at: [<000000009c3b5e26>] tty_buffer_flush+0xbb/0x3b0 drivers/tty/tty_buffer.c:221
+[   63.224587] 
+`: `possible deadlock in pty_write (&buf->lock / &(&port->lock)->rlock)`,
+
+		`
+WARNING: possible circular locking dependency detected
+4.19.0-rc2+ #1 Not tainted
+------------------------------------------------------
+syz-executor0/5557 is trying to acquire lock:
+00000000f4d1a2c4 ((console_sem).lock){-.-.}, at: down_trylock+0x13/0x70 kernel/locking/semaphore.c:136
+
+but task is already holding lock:
+0000000036f40b06 (&(&port->lock)->rlock){-.-.}, at: pty_write+0xf9/0x1f0 drivers/tty/pty.c:120
+`: `possible deadlock in down_trylock (&(&port->lock)->rlock / (console_sem).lock)`,
+
+		`
+[  177.236342] =============================================
+[  177.236349] [ INFO: possible recursive locking detected ]
+[  177.236356] 4.10.0+ #37 Not tainted
+[  177.236361] ---------------------------------------------
+[  177.236368] syz-executor1/20342 is trying to acquire lock:
+[  177.236374]  (sk_lock-AF_INET6){+.+.+.}, at: [<ffffffff83dd6a97>] lock_sock include/net/sock.h:1460 [inline]
+[  177.236374]  (sk_lock-AF_INET6){+.+.+.}, at: [<ffffffff83dd6a97>] sctp_getsockopt+0x127/0x2a0 net/sctp/socket.c:6711
+[  177.236411] 
+[  177.236411] but task is already holding lock:
+`: `possible recursive locking of sk_lock-AF_INET6 in sctp_getsockopt`,
+
+		`
+================================
+WARNING: inconsistent lock state
+4.14.0-rc2+ #86 Not tainted
+--------------------------------
+inconsistent {SOFTIRQ-ON-W} -> {IN-SOFTIRQ-W} usage.
+syz-executor5/3260 [HC0[0]:SC1[1]:HE1:SE0] takes:
+ (&(&q->lock)->rlock){+.?.}, at: [<ffffffff8468d283>] spin_lock include/linux/spinlock.h:317 [inline]
+ (&(&q->lock)->rlock){+.?.}, at: [<ffffffff8468d283>] ipt_do_table+0xb3/0x1690 net/ipv4/netfilter/ip_tables.c:291
+`: `inconsistent lock state of &(&q->lock)->rlock in ipt_do_table`,
 
 		`
 [ INFO: suspicious RCU usage. ]
@@ -148,7 +210,7 @@ kacpi_hotplug/246 is trying to acquire lock:
 -------------------------------
 net/core/filter.c:1917 suspicious rcu_dereference_protected() usage!
 other info that might help us debug this:
-`: `suspicious RCU usage at net/core/filter.c:1917`,
+`: `suspicious RCU usage at net/core/filter.c:LINE`,
 
 		`
 [   37.540474] ===============================
@@ -172,7 +234,7 @@ r0 = ioctl$KVM_CREATE_VM(0xffffffffffffffff, 0xae01, 0x0)
 [   37.540610] [<ffffffff81055862>] vcpu_load+0x22/0x70
 [   37.540614] 
 [   37.540614] stack backtrace:
-`: `suspicious RCU usage at ./include/linux/kvm_host.h:536`,
+`: `suspicious RCU usage at ./include/linux/kvm_host.h:LINE`,
 
 		`
 [   80.586804] =====================================
@@ -235,7 +297,7 @@ r0 = ioctl$KVM_CREATE_VM(0xffffffffffffffff, 0xae01, 0x0)
 [    4.557015]  [<ffffffff81bcc1c1>] __ubsan_handle_shift_out_of_bounds+0xf1/0x140
 [    4.557030]  [<ffffffff822247af>] ? proc_do_submiturb+0x9af/0x2c30
 [    4.557034]  [<ffffffff82226794>] proc_do_submiturb+0x2994/0x2c30
-`: `UBSAN: Undefined behaviour in drivers/usb/core/devio.c:1517:25`,
+`: `UBSAN: shift-out-of-bounds in drivers/usb/core/devio.c:LINE`,
 
 		`
 [    3.805449] ================================================================================
@@ -256,18 +318,47 @@ r0 = ioctl$KVM_CREATE_VM(0xffffffffffffffff, 0xae01, 0x0)
 [    3.805496]  [<ffffffff81bcbc7e>] __ubsan_handle_add_overflow+0xe/0x10
 [    3.805500]  [<ffffffff82680a4a>] ip_idents_reserve+0x9a/0xd0
 [    3.805503]  [<ffffffff826835e9>] __ip_select_ident+0xc9/0x160
-`: `UBSAN: Undefined behaviour in ./arch/x86/include/asm/atomic.h:156:2`,
+`: `UBSAN: signed-integer-overflow in arch/x86/include/asm/atomic.h:LINE`,
 
 		`
 [   50.583499] UBSAN: Undefined behaviour in kernel/time/hrtimer.c:310:16
 [   50.583499] signed integer overflow:
-`: `UBSAN: Undefined behaviour in kernel/time/hrtimer.c:310:16`,
+`: `UBSAN: signed-integer-overflow in kernel/time/hrtimer.c:LINE`,
+
+		`
+[   22.112358] ================================================================================
+[   22.112363] UBSAN: Undefined behaviour in net/ipv4/tcp_input.c:5531:16
+[   22.112365] index 17 is out of range for type 'u32 [16]'
+[   22.112371] CPU: 0 PID: 4114 Comm: syz-executor Not tainted 4.10.0+ #12
+`: `UBSAN: array-index-out-of-bounds in net/ipv4/tcp_input.c:LINE`,
+
+		`
+[   22.112363] UBSAN: Undefined behaviour in mm/page_alloc.c:3117:11
+[   22.112365] load of value 255 is not a valid value for type '_Bool'
+`: `UBSAN: invalid-load in mm/page_alloc.c:LINE`,
+
+		`
+[   22.112363] UBSAN: Undefined behaviour in fs/ext4/super.c:777:4
+[   22.112365] something that we don't recognize yet
+`: `UBSAN: Undefined behaviour in fs/ext4/super.c:LINE`,
+
+		`
+[  112.537210] ================================================================================
+[  112.537217] UBSAN: array-index-out-of-bounds in net/sched/sch_taprio.c:1005:27
+[  112.537221] index 16 is out of range for type 'u16 [16]'
+[  112.537226] CPU: 1 PID: 8842 Comm: syz-executor.3 Not tainted 5.9.0-rc4+ #0
+`: `UBSAN: array-index-out-of-bounds in net/sched/sch_taprio.c:LINE`,
+
+		`
+[  112.537217] UBSAN: shift-out-of-bounds in ./include/linux/log2.h:57:13
+[  112.537221] shift exponent 64 is too large for 64-bit type 'long unsigned int'
+`: `UBSAN: shift-out-of-bounds in include/linux/log2.h:LINE`,
 
 		`
 ------------[ cut here ]------------
 kernel BUG at fs/buffer.c:1917!
 invalid opcode: 0000 [#1] SMP
-`: `kernel BUG at fs/buffer.c:1917!`,
+`: `kernel BUG at fs/buffer.c:LINE!`,
 
 		`
 [  167.347989] Disabling lock debugging due to kernel taint
@@ -397,10 +488,24 @@ unreferenced object 0xdb8040c0 (size 20):
     [<ffffffff>] 0xffffffff
 `: `memory leak in debug_objects_mem_init (size 20)`,
 
+		`
+2017/09/01 10:00:00 memory leak check: programs executed since the previous check:
+executing program 0:
+mmap(&(0x7f0000000000/0x1000)=nil, (0x1000), 0x3, 0x32, 0xffffffffffffffff, 0x0)
+2017/09/01 10:00:00 memory leak check: found new leaks:
+unreferenced object 0xffff88003a2b5e00 (size 64):
+  comm "syz-executor0", pid 5431, jiffies 4294893456 (age 9.364s)
+  backtrace:
+    [<ffffffff85c73a22>] kmemleak_alloc+0x72/0xc0 mm/kmemleak.c:915
+    [<ffffffff816cc14d>] kmem_cache_alloc_trace+0x12d/0x2c0 mm/slub.c:2745
+    [<ffffffff84b642c9>] packet_setsockopt+0x69/0x340 net/packet/af_packet.c:3701
+    [<ffffffff8489f1d8>] SyS_setsockopt+0x158/0x240 net/socket.c:1736
+`: `memory leak in packet_setsockopt (size 64)`,
+
 		`
 BUG: sleeping function called from invalid context at include/linux/wait.h:1095 
 in_atomic(): 1, irqs_disabled(): 0, pid: 3658, name: syz-fuzzer 
-`: `BUG: sleeping function called from invalid context at include/linux/wait.h:1095 `,
+`: `BUG: sleeping function called from invalid context at include/linux/wait.h:LINE`,
 
 		`
 INFO: rcu_preempt detected stalls on CPUs/tasks: { 2} (detected by 0, t=65008 jiffies, g=48068, c=48067, q=7339)
@@ -420,6 +525,32 @@ INFO: rcu_preempt detected stalls on CPUs/tasks: { 2} (detected by 0, t=65008 ji
 [   50.583499] INFO: rcu_sched self-detected stall on CPU
 `: `INFO: rcu detected stall`,
 
+		`
+[  277.780013] INFO: rcu_sched self-detected stall on CPU
+[  277.781045] 	1-...: (1 GPs behind) idle=1e3/140000000000001/0 softirq=72493/72494 fqs=6433 
+[  277.781045] 	 (t=26000 jiffies g=20584 c=20583 q=1056)
+[  277.781045] NMI backtrace for cpu 1
+[  277.781045] CPU: 1 PID: 8310 Comm: syz-executor2 Not tainted 4.10.0-rc8+ #1
+[  277.781045] Call Trace:
+[  277.781045]  <IRQ>
+[  277.781045]  dump_stack+0x2ee/0x3ef
+[  277.781045]  rcu_dump_cpu_stacks+0x192/0x1fb
+[  277.781045]  apic_timer_interrupt+0x93/0xa0
+[  277.781045]  </IRQ>
+[  277.781045] RIP: 0010:[<ffffffff81c4a5f4>]  [<ffffffff81c4a5f4>] __sanitizer_cov_trace_pc+0x44/0x50
+`: `INFO: rcu detected stall in __sanitizer_cov_trace_pc`,
+
+		`
+INFO: rcu_sched detected stalls on CPUs/tasks:
+	(detected by 0, t=10502 jiffies, g=5831, c=5830, q=72)
+NMI backtrace for cpu 1
+Call Trace:
+ <IRQ>
+ apic_timer_interrupt+0x93/0xa0 arch/x86/entry/entry_64.S:911
+ </IRQ>
+RIP: 0010:tcp_recvmsg+0x1a2/0x2a40 net/ipv4/tcp.c:1810
+`: `INFO: rcu detected stall in tcp_recvmsg`,
+
 		`
 BUG: spinlock lockup suspected on CPU#2, syz-executor/12636
 `: `BUG: spinlock lockup suspected`,
@@ -438,6 +569,65 @@ BUG: soft lockup - CPU#3 stuck for 11s! [syz-executor:643]
 BUG: spinlock lockup suspected on CPU#2, syz-executor/12636
 `: `BUG: soft lockup`,
 
+		`
+[   68.224813] ==================================================================
+[   68.225431] BUG: KMSAN: uninit-value in tcp_v4_rcv+0x8a1/0x4a30
+[   68.225888] CPU: 1 PID: 3046 Comm: syz-executor3 Not tainted 4.13.0+ #3
+[   68.226378] Hardware name: QEMU Standard PC (i440FX + PIIX, 1996), BIOS Bochs 01/01/2011
+[   68.226890] Call Trace:
+[   68.227065]  <IRQ>
+[   68.227236]  dump_stack+0x172/0x1c0
+[   68.227479]  kmsan_report+0x145/0x3d0
+[   68.227728]  __msan_warning_32+0x69/0xb0
+[   68.227986]  tcp_v4_rcv+0x8a1/0x4a30
+[   68.228233]  ip_local_deliver_finish+0x6ed/0xd40
+[   68.228522] origin:
+[   68.228675]  save_stack_trace+0x37/0x40
+[   68.228950]  kmsan_internal_poison_shadow+0xb1/0x1a0
+[   68.229243] Uninit was created at:
+[   68.229436]  kmsan_save_stack_with_flags+0x3c/0x90
+[   68.229708]  kmsan_internal_poison_shadow+0xb1/0x1a0
+[   68.229999]  kmsan_kmalloc+0x80/0xe0
+[   68.230236]  kmem_cache_alloc_node+0x1f8/0x3a0
+[   68.230510]  __alloc_skb+0x2b0/0x1000
+[   68.230764]  tun_get_user+0x1b94/0x5d80
+[   68.231016]  tun_chr_write_iter+0x19f/0x300
+[   68.231284] ==================================================================
+`: `KMSAN: uninit-value in tcp_v4_rcv (origin tun_get_user)`,
+
+		`
+[   68.225431] BUG: KMSAN: uninit-value in tcp_v4_rcv+0x8a1/0x4a30
+[   68.226890] Call Trace:
+[   68.227986]  tcp_v4_rcv+0x8a1/0x4a30
+[   68.229243] Uninit was created at:
+[   68.229436]  kmsan_save_stack_with_flags+0x3c/0x90
+[   68.229999]  kmsan_kmalloc+0x80/0xe0
+[   68.230236]  __kmalloc_node_track_caller+0x1f8/0x3a0
+[   68.230510]  __alloc_skb+0x2b0/0x1000
+[   68.230764]  sock_alloc_send_pskb+0x1b94/0x5d80
+[   68.231284] ==================================================================
+`: `KMSAN: uninit-value in tcp_v4_rcv (origin sock_alloc_send_pskb)`,
+
+		`
+BUG: KMSAN: uninit-value in strlen+0x5f/0xa0
+Call Trace:
+ strlen+0x5f/0xa0
+Local variable description: ----addr@__sys_bind
+Local variable addr created at:
+ __sys_bind+0x7e/0x560 net/socket.c:1476
+ __se_sys_bind net/socket.c:1505 [inline]
+`: `KMSAN: uninit-value in strlen (origin __sys_bind)`,
+
+		`
+[   68.225431] BUG: KMSAN: uninit-value in sctp_sf_do_5_1D_ce+0x1f1/0x1a0
+[   68.226890] Call Trace:
+[   68.227986]  sctp_sf_do_5_1D_ce+0x1f1/0x1a0
+[   68.231284] ==================================================================
+[   69.225431] BUG: KMSAN: uninit-value in tcp_v4_rcv+0x8a1/0x4a30
+[   69.229243] Uninit was created at:
+[   69.230764]  tun_get_user+0x1b94/0x5d80
+`: `KMSAN: uninit-value in sctp_sf_do_5_1D_ce`,
+
 		`
 [  213.269287] BUG: spinlock recursion on CPU#0, syz-executor7/5032
 [  213.281506]  lock: 0xffff88006c122d00, .magic: dead4ead, .owner: syz-executor7/5032, .owner_cpu: -1
@@ -476,8 +666,48 @@ BUG: spinlock lockup suspected on CPU#2, syz-executor/12636
 [  843.340437]  [<ffffffff835bec62>] ? preempt_schedule+0x62/0xa0
 [  843.346418]  [<ffffffff835cbdd2>] tty_ldisc_lock_pair_timeout+0xb2/0x160
 [  843.353363]  [<ffffffff81f8b03f>] tty_ldisc_hangup+0x21f/0x720
+`: `INFO: task hung in tty_ldisc_lock_pair_timeout`,
+
+		`
+[  246.752238] INFO: task syz-executor1:9218 blocked for more than 120 seconds.
+[  246.759625]       Not tainted 4.14.0-rc5+ #1
+[  246.764031] syz-executor1   D24808  9218   3063 0x00000004
+[  246.769668] Call Trace:
+[  246.772226]  __schedule+0x8eb/0x2060 kernel/sched/core.c:2797
+[  246.777911]  schedule+0xf5/0x430 kernel/sched/core.c:3477
+[  246.783172]  schedule_preempt_disabled+0x10/0x20 kernel/sched/core.c:3535
+[  246.789811]  __mutex_lock_common kernel/locking/mutex.c:833 [inline]
+[  246.789811]  __mutex_lock+0x7c8/0x1700 kernel/locking/mutex.c:893
+[  246.795491]  mutex_lock_nested+0x16/0x20 kernel/locking/mutex.c:908
+[  246.801454]  rtnl_lock+0x17/0x20 net/core/rtnetlink.c:72
+[  246.806613]  do_ip_setsockopt.isra.12+0x1d9/0x3210 net/ipv4/ip_sockglue.c:646
+`: `INFO: task hung in rtnl_lock`,
+
+		`
+INFO: task syz-executor1:9218 blocked for more than 120 seconds.
+      Not tainted 4.14.0-rc5+ #1
 `: `INFO: task hung`,
 
+		`
+[  180.119043] BUG: soft lockup - CPU#1 stuck for 22s! [syz-executor0:3271]
+[  180.119043] Modules linked in:
+[  180.119043] CPU: 1 PID: 3271 Comm: syz-executor0 Not tainted 4.13.0+ #2
+[  180.119043] task: ffff88003b2f4140 task.stack: ffff880033ba0000
+[  180.119043] RIP: 0010:__sanitizer_cov_trace_pc+0x1b/0x50 kernel/kcov.c:97
+[  180.119043] RSP: 0018:ffff880033ba7a30 EFLAGS: 00000246 ORIG_RAX: ffffffffffffff10
+`: `BUG: soft lockup in __sanitizer_cov_trace_pc`,
+
+		`
+[  118.234912] NMI watchdog: Watchdog detected hard LOCKUP on cpu 0
+[  118.234912] Modules linked in:
+[  118.234912] CPU: 0 PID: 4153 Comm: syz-executor2 Not tainted 4.14.0-rc4+ #1
+[  118.234912] RIP: 0010:native_queued_spin_lock_slowpath+0x14b/0x780 kernel/locking/qspinlock.c:480
+`: `BUG: hard lockup in native_queued_spin_lock_slowpath`,
+
+		`
+[  118.234912] NMI watchdog: Watchdog detected hard LOCKUP on cpu 0
+`: `BUG: hard lockup`,
+
 		`
 BUG UNIX (Not tainted): kasan: bad access detected
 `: ``,
@@ -486,6 +716,29 @@ BUG UNIX (Not tainted): kasan: bad access detected
 [901320.960000] INFO: lockdep is turned off.
 `: ``,
 
+		`
+[   54.432010] ==================================================================
+[   54.432822] BUG: KCSAN: data-race in tick_nohz_next_event / tick_sched_do_timer
+[   54.433543] 
+[   54.433707] write to 0xffffffff8a2c1f40 of 8 bytes by task 0 on cpu 1:
+[   54.434335]  tick_sched_do_timer+0x38/0x70
+`: `KCSAN: data-race in tick_nohz_next_event / tick_sched_do_timer`,
+
+		`
+[   54.432010] ==================================================================
+[   54.432822] BUG: KCSAN: data-race in tick_sched_do_timer / tick_nohz_next_event
+[   54.433543] 
+[   54.433707] write to 0xffffffff8a2c1f40 of 8 bytes by task 0 on cpu 1:
+`: `KCSAN: data-race in tick_nohz_next_event / tick_sched_do_timer`,
+
+		`
+[   61.120391] BUG: KCSAN: data-race in __ext4_update_other_inode_time.isra.0+0x1a/0x90 / ext4_mark_iloc_dirty
+`: `KCSAN: data-race in __ext4_update_other_inode_time / ext4_mark_iloc_dirty`,
+
+		`
+[   61.120391] BUG: KCSAN: data-race in blk_mq_sched_dispatch_requests
+`: `KCSAN: data-race in blk_mq_sched_dispatch_requests`,
+
 		`
 INFO: Stall ended before state dump start
 `: ``,
@@ -528,6 +781,147 @@ WARNING: /etc/ssh/moduli does not exist, using fixed modulus
 	}
 }
 
+func TestIsDataRace(t *testing.T) {
+	tests := map[string]bool{
+		"KCSAN: data-race in foo / bar":               true,
+		"KCSAN: data-race in foo":                     true,
+		"KASAN: use-after-free Read in foo":           false,
+		"WARNING in data_race":                        false,
+		"BUG: unable to handle kernel paging request": false,
+	}
+	for desc, race := range tests {
+		if res := IsDataRace(desc); res != race {
+			t.Errorf("IsDataRace(%q) = %v, want %v", desc, res, race)
+		}
+	}
+}
+
+func TestExtractGuiltyFile(t *testing.T) {
+	tests := []struct {
+		report    string
+		guilty    string
+		subsystem string
+	}{
+		{
+			`BUG: KASAN: use-after-free in __lock_acquire+0x3a82/0x4290 kernel/locking/lockdep.c:3378
+Read of size 8 at addr ffff88005f4ba210 by task syz-executor2/5411
+Call Trace:
+ __dump_stack lib/dump_stack.c:16 [inline]
+ dump_stack+0x292/0x395 lib/dump_stack.c:52
+ print_address_description+0x78/0x280 mm/kasan/report.c:252
+ kasan_report_error mm/kasan/report.c:351 [inline]
+ kasan_report+0x230/0x340 mm/kasan/report.c:409
+ __asan_report_load8_noabort+0x19/0x20 mm/kasan/report.c:430
+ __lock_acquire+0x3a82/0x4290 kernel/locking/lockdep.c:3378
+ lock_acquire+0x22d/0x560 kernel/locking/lockdep.c:3753
+ __raw_spin_lock_irqsave include/linux/spinlock_api_smp.h:112 [inline]
+ _raw_spin_lock_irqsave+0xa3/0xe0 kernel/locking/spinlock.c:159
+ skb_queue_tail+0x26/0x150 net/core/skbuff.c:2831
+ __netlink_sendskb+0x58/0xc0 net/netlink/af_netlink.c:1184
+`,
+			"net/core/skbuff.c",
+			"net/core",
+		},
+		{
+			`WARNING: CPU: 1 PID: 3065 at ./include/linux/kref.h:46 kref_get include/linux/kref.h:46 [inline]
+WARNING: CPU: 1 PID: 3065 at ./include/linux/kref.h:46 drm_gem_object_reference+0x26/0x30 drivers/gpu/drm/drm_gem.c:573
+`,
+			"drivers/gpu/drm/drm_gem.c",
+			"drivers/gpu/drm",
+		},
+		{
+			`kernel BUG at ./fs/buffer.c:1917!
+invalid opcode: 0000 [#1] SMP
+`,
+			"fs/buffer.c",
+			"fs",
+		},
+		{
+			`BUG: unable to handle kernel paging request at ffff88002bde1e40
+IP: [<ffffffff810a376f>] __call_rcu.constprop.76+0x1f/0x280
+`,
+			"",
+			"",
+		},
+	}
+	for i, test := range tests {
+		guilty := ExtractGuiltyFile([]byte(test.report))
+		if guilty != test.guilty {
+			t.Errorf("#%v: ExtractGuiltyFile = %q, want %q", i, guilty, test.guilty)
+		}
+		if subsystem := GuiltySubsystem(guilty); subsystem != test.subsystem {
+			t.Errorf("#%v: GuiltySubsystem(%q) = %q, want %q", i, guilty, subsystem, test.subsystem)
+		}
+	}
+}
+
+func TestIsHang(t *testing.T) {
+	tests := map[string]bool{
+		"INFO: task hung in rtnl_lock":                true,
+		"INFO: task hung":                             true,
+		"INFO: rcu detected stall in tcp_recvmsg":     true,
+		"BUG: soft lockup in foo":                     true,
+		"BUG: hard lockup":                            true,
+		"BUG: spinlock lockup suspected":              true,
+		"KASAN: use-after-free Read in foo":           false,
+		"BUG: unable to handle kernel paging request": false,
+		"possible deadlock in foo (bar)":              false,
+	}
+	for desc, hang := range tests {
+		if res := IsHang(desc); res != hang {
+			t.Errorf("IsHang(%q) = %v, want %v", desc, res, hang)
+		}
+	}
+}
+
+func TestSeverity(t *testing.T) {
+	tests := map[string]Severity{
+		"KCSAN: data-race in foo / bar":                      SeverityLow,
+		"UBSAN: shift-out-of-bounds in mm/foo.c:10":          SeverityMedium,
+		"UBSAN: Undefined behaviour in mm/foo.c:10":          SeverityMedium,
+		"KASAN: slab-out-of-bounds Read in foo":              SeverityHigh,
+		"BUG: unable to handle kernel paging request":        SeverityHigh,
+		"WARNING: CPU: 0 PID: 1 at mm/foo.c:10 UBSAN: foo()": SeverityHigh,
+	}
+	for desc, sev := range tests {
+		if res := GetSeverity(desc); res != sev {
+			t.Errorf("GetSeverity(%q) = %v, want %v", desc, res, sev)
+		}
+	}
+}
+
+func TestScore(t *testing.T) {
+	// Descriptions in the order of decreasing scores.
+	descs := []string{
+		"KASAN: use-after-free Write in remove_wait_queue",
+		"KASAN: slab-out-of-bounds Write in memcpy",
+		"KASAN: use-after-free Read in copy_from_iter",
+		"KASAN: slab-out-of-bounds Read in memcpy",
+		"KMSAN: uninit-value in tcp_v4_rcv (origin tun_get_user)",
+		"KASAN: null-ptr-deref Read of size 4",
+		"kernel BUG at fs/buffer.c:1917!",
+		"possible deadlock in flush_workqueue (kacpid)",
+		"WARNING in genl_unbind",
+		"UBSAN: shift-out-of-bounds in net/ipv4/tcp.c:10",
+		"memory leak in packet_setsockopt (size 64)",
+		"INFO: task hung in rtnl_lock",
+		"KCSAN: data-race in foo / bar",
+		"lost connection to test machine",
+	}
+	for i := 1; i < len(descs); i++ {
+		if Score(descs[i-1]) <= Score(descs[i]) {
+			t.Errorf("Score(%q) = %v is not greater than Score(%q) = %v",
+				descs[i-1], Score(descs[i-1]), descs[i], Score(descs[i]))
+		}
+	}
+	if Score(descs[0]) != 100 || Score(descs[len(descs)-1]) != 0 {
+		t.Errorf("scores are not in [0, 100]")
+	}
+	if Score("BUG: soft lockup in foo") != Score("INFO: task hung in bar") {
+		t.Errorf("hangs have different scores")
+	}
+}
+
 func TestIgnores(t *testing.T) {
 	const log = `
 		BUG: bug1
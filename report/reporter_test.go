@@ -0,0 +1,273 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package report
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func testReporter(t *testing.T, os string, tests map[string]string, ignores []*regexp.Regexp) {
+	reporter, err := NewReporter(os, ignores)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for log, crash := range tests {
+		if strings.Index(log, "\r\n") != -1 {
+			continue
+		}
+		tests[strings.Replace(log, "\n", "\r\n", -1)] = crash
+	}
+	for log, crash := range tests {
+		containsCrash := reporter.ContainsCrash([]byte(log))
+		expectCrash := (crash != "")
+		if expectCrash && !containsCrash {
+			t.Fatalf("%v: ContainsCrash did not find crash:\n%v", os, log)
+		}
+		if !expectCrash && containsCrash {
+			t.Fatalf("%v: ContainsCrash found unexpected crash:\n%v", os, log)
+		}
+		desc, _, _, _ := reporter.Parse([]byte(log))
+		if !expectCrash && desc != "" {
+			t.Fatalf("%v: Parse returned crash text '%v' for text:\n%v", os, desc, log)
+		}
+		if expectCrash && desc != crash {
+			t.Fatalf("%v: Parse returned bad crash text:\n%v\nwant:\n%v\nfor text:\n%v", os, desc, crash, log)
+		}
+	}
+}
+
+func TestNewReporter(t *testing.T) {
+	for _, os := range []string{"", "linux", "freebsd", "netbsd", "fuchsia", "windows"} {
+		if _, err := NewReporter(os, nil); err != nil {
+			t.Errorf("NewReporter(%q) failed: %v", os, err)
+		}
+	}
+	if _, err := NewReporter("plan9", nil); err == nil {
+		t.Errorf("NewReporter(\"plan9\") did not fail")
+	}
+}
+
+// TestParseCorpus parses sample reports in testdata/OS/.
+// Every file starts with "TITLE: expected title" line followed by an empty line and the console output.
+// Reports of the same crash from different kernel builds must produce the same title.
+func TestParseCorpus(t *testing.T) {
+	dirs, err := ioutil.ReadDir("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range dirs {
+		os := dir.Name()
+		reporter, err := NewReporter(os, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files, err := ioutil.ReadDir(filepath.Join("testdata", os))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, file := range files {
+			name := filepath.Join("testdata", os, file.Name())
+			data, err := ioutil.ReadFile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			const titlePrefix = "TITLE: "
+			pos := bytes.Index(data, []byte("\n\n"))
+			if !bytes.HasPrefix(data, []byte(titlePrefix)) || pos == -1 {
+				t.Fatalf("%v: bad format, want TITLE: line followed by an empty line", name)
+			}
+			title := string(data[len(titlePrefix):pos])
+			desc, _, _, _ := reporter.Parse(data[pos+2:])
+			if desc != title {
+				t.Errorf("%v: got title %q, want %q", name, desc, title)
+			}
+		}
+	}
+}
+
+func TestNormalizeDesc(t *testing.T) {
+	tests := map[string]string{
+		"kernel BUG at fs/buffer.c:1917!":                                     "kernel BUG at fs/buffer.c:LINE!",
+		"UBSAN: shift-out-of-bounds in include/linux/log2.h:57:13":            "UBSAN: shift-out-of-bounds in include/linux/log2.h:LINE",
+		"kernel panic: Kernel stack is corrupted in: ffffffff8146b3f6":        "kernel panic: Kernel stack is corrupted in: ADDR",
+		"panic: bad pointer 0xfffff80003b7a5f0":                               "panic: bad pointer ADDR",
+		"kernel panic: Watchdog detected hard LOCKUP on cpu 1":                "kernel panic: Watchdog detected hard LOCKUP on cpu",
+		"WARNING: CPU: 3 PID: 1234 at foo":                                    "WARNING: CPU PID at foo",
+		"BUG: spinlock bad magic on CPU#0, syz-executor3/4321":                "BUG: spinlock bad magic on CPU, syz-executor",
+		"kernel panic: [  100.123] in foo_decade+0x12/0x40":                   "kernel panic: in foo_decade",
+		"BUGCHECK 0x50 in win32k!NtUserSetWindowLongPtr":                      "BUGCHECK 0x50 in win32k!NtUserSetWindowLongPtr",
+		"possible deadlock in pty_write (&buf->lock / &(&port->lock)->rlock)": "possible deadlock in pty_write (&buf->lock / &(&port->lock)->rlock)",
+		"memory leak in sk_prot_alloc (size 1864)":                            "memory leak in sk_prot_alloc (size 1864)",
+	}
+	for desc, want := range tests {
+		if got := normalizeDesc(desc); got != want {
+			t.Errorf("normalizeDesc(%q) = %q, want %q", desc, got, want)
+		}
+	}
+}
+
+func TestParseLinuxReporter(t *testing.T) {
+	// Linux reporter must not report panics of other kernels and vice versa.
+	testReporter(t, "linux", map[string]string{
+		`
+Fatal trap 12: page fault while in kernel mode
+`: ``,
+		`
+[   99.132546] BUG: KASAN: use-after-free in foo+0x10/0x20
+[   99.132546] Read of size 8 at addr ffff88005f4ba210 by task syz-executor2/5411
+`: `KASAN: use-after-free Read in foo`,
+	}, nil)
+	testReporter(t, "freebsd", map[string]string{
+		`
+[   99.132546] BUG: KASAN: use-after-free in foo+0x10/0x20
+`: ``,
+	}, nil)
+}
+
+func TestParseFreeBSD(t *testing.T) {
+	testReporter(t, "freebsd", map[string]string{
+		`
+Fatal trap 12: page fault while in kernel mode
+cpuid = 0; apic id = 00
+fault virtual address	= 0x0
+fault code		= supervisor read data, page not present
+instruction pointer	= 0x20:0xffffffff80b4a6a5
+stack pointer	        = 0x28:0xfffffe0000399880
+frame pointer	        = 0x28:0xfffffe00003998a0
+current process		= 1003 (syz-executor)
+trap number		= 12
+panic: page fault
+cpuid = 0
+KDB: stack backtrace:
+#0 0xffffffff80aada97 at kdb_backtrace+0x67
+#1 0xffffffff80a6bb76 at vpanic+0x186
+#2 0xffffffff80a6b9e3 at panic+0x43
+#3 0xffffffff80edf832 at trap_fatal+0x322
+#4 0xffffffff80edf889 at trap_pfault+0x49
+#5 0xffffffff80edf0c6 at trap+0x286
+#6 0xffffffff80ec3641 at calltrap+0x8
+#7 0xffffffff80b4a6a5 at sbuf_bcat+0x15
+#8 0xffffffff80a81a4b at sysctl_handle_string+0x8b
+`: `Fatal trap 12: page fault in sbuf_bcat`,
+
+		`
+Fatal trap 9: general protection fault while in kernel mode
+cpuid = 1; apic id = 01
+`: `Fatal trap 9: general protection fault`,
+
+		`
+Fatal trap 12: page fault while in user mode
+cpuid = 0; apic id = 00
+`: ``,
+
+		`
+panic: vm_fault: fault on nofault entry, addr: 0xfffffe0012345000
+cpuid = 1
+KDB: stack backtrace:
+#0 0xffffffff80aada97 at kdb_backtrace+0x67
+#1 0xffffffff80a6bb76 at vpanic+0x186
+#2 0xffffffff80a6b9e3 at panic+0x43
+#3 0xffffffff80d4f2a1 at vm_fault_hold+0x2151
+#4 0xffffffff80d4d0f5 at vm_fault+0x75
+`: `panic: vm_fault in vm_fault_hold`,
+
+		`
+panic: Assertion td->td_critnest == 0 failed at /usr/src/sys/kern/subr_trap.c:120
+cpuid = 0
+`: `panic: Assertion td->td_critnest == 0 failed at /usr/src/sys/kern/subr_trap.c:LINE`,
+
+		`
+panic: runtime error: index out of range
+goroutine 1 [running]:
+`: ``,
+
+		`
+lock order reversal:
+ 1st 0xfffff80003b7a5f0 ufs (ufs) @ /usr/src/sys/kern/vfs_subr.c:2602
+ 2nd 0xfffffe0000d9b3a8 bufwait (bufwait) @ /usr/src/sys/ufs/ffs/ffs_vnops.c:263
+stack backtrace:
+`: `lock order reversal: ufs / bufwait`,
+	}, nil)
+}
+
+func TestParseNetBSD(t *testing.T) {
+	testReporter(t, "netbsd", map[string]string{
+		`
+[  80.2345327] uvm_fault(0xffffffff81462440, 0x0, 1) -> e
+[  80.2345327] fatal page fault in supervisor mode
+[  80.2345327] trap type 6 code 0 rip 0xffffffff80a6b2c4 cs 0x8 rflags 0x10246 cr2 0x8 ilevel 0 rsp 0xffff80001b0bfd48
+[  80.2345327] curlwp 0xfffffe801fa6c480 pid 1210.1 lowest kstack 0xffff80001b0bb2c0
+[  80.2345327] panic: trap
+[  80.2345327] cpu0: Begin traceback...
+[  80.2345327] vpanic() at netbsd:vpanic+0x140
+[  80.2345327] snprintf() at netbsd:snprintf
+[  80.2345327] trap() at netbsd:trap+0xa08
+[  80.2345327] --- trap (number 6) ---
+[  80.2345327] sys_msgctl() at netbsd:sys_msgctl+0x34
+[  80.2345327] syscall() at netbsd:syscall+0x1ed
+`: `page fault in sys_msgctl`,
+
+		`
+fatal protection fault in supervisor mode
+trap type 4 code 0 rip 0xffffffff80a6b2c4 cs 0x8 rflags 0x10246 cr2 0x8
+`: `protection fault in supervisor mode`,
+
+		`
+[  12.1234567] panic: kernel diagnostic assertion "mutex_owned(&uvm_pageqlock)" failed: file "/usr/src/sys/uvm/uvm_page.c", line 1376
+[  12.1234567] cpu0: Begin traceback...
+`: `assert failed: uvm_page.c:LINE: mutex_owned(&uvm_pageqlock)`,
+
+		`
+[  12.1234567] panic: lock error: Mutex: mutex_vector_enter,543: locking against myself
+`: `panic: lock error: Mutex: mutex_vector_enter,543: locking against myself`,
+	}, nil)
+}
+
+func TestParseFuchsia(t *testing.T) {
+	testReporter(t, "fuchsia", map[string]string{
+		`
+[00012.345] 01044.01056> ZIRCON KERNEL PANIC
+[00012.345] 01044.01056> panic (caller 0xffffffff0010a0b4 frame 0xffffff9fa5f0fe10): Unable to allocate page
+[00012.345] 01044.01056> platform_halt suggested_action 0 reason 3
+`: `ZIRCON KERNEL PANIC: Unable to allocate page`,
+
+		`
+ZIRCON KERNEL PANIC
+ASSERT FAILED at (kernel/object/channel_dispatcher.cpp:122): !peer_
+platform_halt suggested_action 0 reason 3
+`: `ZIRCON KERNEL PANIC: ASSERT FAILED at channel_dispatcher.cpp:LINE: !peer_`,
+
+		`
+[00125.500] 01102.01116> <== fatal exception: process syz-executor[3345] thread initial-thread[3347]
+[00125.500] 01102.01116> <== fatal page fault, PC at 0x2f3e1c2a1d8
+`: `fatal exception in syz-executor`,
+	}, nil)
+}
+
+func TestParseWindows(t *testing.T) {
+	testReporter(t, "windows", map[string]string{
+		`
+*** Fatal System Error: 0x0000000a
+                       (0x0000000000000000,0x0000000000000002,0x0000000000000000,0xFFFFF80002A5A1B0)
+
+Break instruction exception - code 80000003 (first chance)
+Probably caused by : ntkrnlmp.exe ( nt!KiPageFault+260 )
+`: `BUGCHECK 0xa in nt!KiPageFault`,
+
+		`
+*** Fatal System Error: 0x0000003b
+                       (0x00000000C0000005,0xFFFFF80002A5A1B0,0xFFFFF880009A1E20,0x0000000000000000)
+`: `BUGCHECK 0x3b`,
+
+		`
+BugCheck 50, {fffff8a00ab3f000, 0, fffff80002c2b4d4, 0}
+Probably caused by : win32k.sys ( win32k!NtUserSetWindowLongPtr+1f )
+`: `BUGCHECK 0x50 in win32k!NtUserSetWindowLongPtr`,
+	}, nil)
+}
@@ -0,0 +1,171 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package report
+
+import (
+	"debug/elf"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/google/syzkaller/symbolizer"
+)
+
+// Symbolize is frequently called for the same vmlinux (e.g. by manager for every crash),
+// so symbols, addr2line subprocesses and symbolized PCs are kept between calls.
+// Binaries are identified by build-id, so a rebuilt vmlinux at the same path
+// is not symbolized with stale data.
+const (
+	maxSymbBinaries = 4       // number of binaries kept in the cache, least recently used are evicted
+	maxSymbPCs      = 1 << 20 // number of symbolized PCs kept per binary
+)
+
+var symbCache = &symbBinaryCache{}
+
+type symbBinaryCache struct {
+	mu       sync.Mutex
+	binaries []*symbBinary // ordered from the least to the most recently used
+}
+
+type symbBinary struct {
+	mu      sync.Mutex
+	id      string
+	symbols map[string][]symbolizer.Symbol
+	symb    *symbolizer.Symbolizer
+	strip   string // source path prefix inferred from debug info, empty if unknown
+	frames  map[uint64][]symbolizer.Frame
+}
+
+// get returns cached state for binary with the given id, or creates it with create.
+func (c *symbBinaryCache) get(id string, create func() (*symbBinary, error)) (*symbBinary, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, bin := range c.binaries {
+		if bin.id == id {
+			copy(c.binaries[i:], c.binaries[i+1:])
+			c.binaries[len(c.binaries)-1] = bin
+			return bin, nil
+		}
+	}
+	bin, err := create()
+	if err != nil {
+		return nil, err
+	}
+	bin.id = id
+	if len(c.binaries) >= maxSymbBinaries {
+		evicted := c.binaries[0]
+		c.binaries = c.binaries[1:]
+		evicted.close()
+	}
+	c.binaries = append(c.binaries, bin)
+	return bin, nil
+}
+
+func newSymbBinary(vmlinux string) (*symbBinary, error) {
+	symbols, err := symbolizer.ReadSymbols(vmlinux)
+	if err != nil {
+		return nil, err
+	}
+	bin := &symbBinary{
+		symbols: symbols,
+		symb:    symbolizer.NewSymbolizer(),
+		frames:  make(map[uint64][]symbolizer.Frame),
+	}
+	// Vmlinux may have been moved, so check if we can find debug info
+	// for __sanitizer_cov_trace_pc. We know where it is located,
+	// so we can infer correct strip prefix from it.
+	for _, covSymb := range symbols["__sanitizer_cov_trace_pc"] {
+		frames, _ := bin.symb.Symbolize(vmlinux, covSymb.Addr)
+		if len(frames) > 0 {
+			file := frames[len(frames)-1].File
+			if idx := strings.Index(file, "kernel/kcov.c"); idx != -1 {
+				bin.strip = file[:idx]
+				break
+			}
+		}
+	}
+	return bin, nil
+}
+
+// symbolize symbolizes all pcs with a single addr2line query and caches the results.
+func (bin *symbBinary) symbolize(vmlinux string, pcs []uint64) error {
+	if len(bin.frames) > maxSymbPCs {
+		bin.frames = make(map[uint64][]symbolizer.Frame)
+	}
+	var missing []uint64
+	for _, pc := range pcs {
+		if _, ok := bin.frames[pc]; !ok {
+			missing = append(missing, pc)
+			// Mark it as queried, so that duplicates are not sent to addr2line.
+			bin.frames[pc] = nil
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	frames, err := bin.symb.SymbolizeArray(vmlinux, missing)
+	if err != nil {
+		for _, pc := range missing {
+			delete(bin.frames, pc)
+		}
+		return err
+	}
+	for _, frame := range frames {
+		bin.frames[frame.PC] = append(bin.frames[frame.PC], frame)
+	}
+	return nil
+}
+
+func (bin *symbBinary) close() {
+	bin.mu.Lock()
+	defer bin.mu.Unlock()
+	bin.symb.Close()
+}
+
+// binaryID returns build-id of the ELF binary bin.
+// If the binary does not have a build-id, the id is derived from path, size and modification time.
+func binaryID(bin string) (string, error) {
+	if id := readBuildID(bin); id != "" {
+		return id, nil
+	}
+	abs, err := filepath.Abs(bin)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%v:%v:%v", abs, info.Size(), info.ModTime().UnixNano()), nil
+}
+
+func readBuildID(bin string) string {
+	f, err := elf.Open(bin)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	sec := f.Section(".note.gnu.build-id")
+	if sec == nil {
+		return ""
+	}
+	data, err := sec.Data()
+	if err != nil {
+		return ""
+	}
+	// The note is: namesz, descsz, type (4 bytes each), name ("GNU\0"), desc (the id itself).
+	if len(data) < 12 {
+		return ""
+	}
+	namesz := f.ByteOrder.Uint32(data[0:])
+	descsz := f.ByteOrder.Uint32(data[4:])
+	off := 12 + uint64(namesz+3)/4*4
+	if off+uint64(descsz) > uint64(len(data)) || descsz == 0 {
+		return ""
+	}
+	return hex.EncodeToString(data[off : off+uint64(descsz)])
+}
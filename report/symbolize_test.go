@@ -0,0 +1,90 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package report
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/syzkaller/symbolizer"
+)
+
+func TestSymbBinaryCache(t *testing.T) {
+	c := &symbBinaryCache{}
+	created := 0
+	get := func(id string) *symbBinary {
+		bin, err := c.get(id, func() (*symbBinary, error) {
+			created++
+			return &symbBinary{symb: symbolizer.NewSymbolizer()}, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bin.id != id {
+			t.Fatalf("got binary %v, want %v", bin.id, id)
+		}
+		return bin
+	}
+	for i := 0; i < maxSymbBinaries; i++ {
+		get(fmt.Sprint(i))
+	}
+	if created != maxSymbBinaries {
+		t.Fatalf("created %v binaries, want %v", created, maxSymbBinaries)
+	}
+	// Binary 0 becomes the most recently used, so binary 1 is evicted.
+	get("0")
+	get("new")
+	if created != maxSymbBinaries+1 {
+		t.Fatalf("created %v binaries, want %v", created, maxSymbBinaries+1)
+	}
+	get("0")
+	if created != maxSymbBinaries+1 {
+		t.Fatalf("binary 0 was evicted")
+	}
+	get("1")
+	if created != maxSymbBinaries+2 {
+		t.Fatalf("binary 1 was not evicted")
+	}
+	if len(c.binaries) != maxSymbBinaries {
+		t.Fatalf("cache has %v binaries, want %v", len(c.binaries), maxSymbBinaries)
+	}
+}
+
+func TestBinaryID(t *testing.T) {
+	f, err := ioutil.TempFile("", "syz-vmlinux")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Write([]byte("not an elf"))
+	f.Close()
+	id1, err := binaryID(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	id2, err := binaryID(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id1 != id2 {
+		t.Fatalf("id changed for the same binary: %v -> %v", id1, id2)
+	}
+	if err := ioutil.WriteFile(f.Name(), []byte("rebuilt binary"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(f.Name(), time.Now(), time.Now().Add(time.Hour))
+	id3, err := binaryID(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id1 == id3 {
+		t.Fatalf("id did not change for a rebuilt binary: %v", id1)
+	}
+	if _, err := binaryID(f.Name() + ".nonexistent"); err == nil {
+		t.Fatalf("no error for a nonexistent binary")
+	}
+}
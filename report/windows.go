@@ -0,0 +1,40 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package report
+
+import (
+	"regexp"
+)
+
+var windowsOopses = []*oops{
+	&oops{
+		[]byte("*** Fatal System Error:"),
+		[]oopsFormat{
+			{
+				// Kernel debugger prints analysis of the bugcheck with the guilty module and function.
+				compile("\\*\\*\\* Fatal System Error: 0x0*([0-9a-fA-F]+)(?:.*\\n)+?.*Probably caused by : .*\\( ([a-zA-Z0-9_!]+)"),
+				"BUGCHECK 0x%[1]v in %[2]v",
+			},
+			{
+				compile("\\*\\*\\* Fatal System Error: 0x0*([0-9a-fA-F]+)"),
+				"BUGCHECK 0x%[1]v",
+			},
+		},
+		[]*regexp.Regexp{},
+	},
+	&oops{
+		[]byte("BugCheck "),
+		[]oopsFormat{
+			{
+				compile("BugCheck ([0-9A-F]+),(?:.*\\n)+?.*Probably caused by : .*\\( ([a-zA-Z0-9_!]+)"),
+				"BUGCHECK 0x%[1]v in %[2]v",
+			},
+			{
+				compile("BugCheck ([0-9A-F]+),"),
+				"BUGCHECK 0x%[1]v",
+			},
+		},
+		[]*regexp.Regexp{},
+	},
+}
@@ -0,0 +1,118 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package repro
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/syzkaller/csource"
+)
+
+// Reproducer bundle is a directory with the following files:
+//
+//	repro.prog  - syzkaller program, the first line is a comment with csource options
+//	repro.cprog - C program (only if the C reproducer works)
+//	repro.json  - Meta describing the reproducer
+const (
+	ProgFile  = "repro.prog"
+	CProgFile = "repro.cprog"
+	MetaFile  = "repro.json"
+)
+
+// Meta is the machine-readable description of a reproducer.
+// Durations are in nanoseconds.
+type Meta struct {
+	Title        string          `json:"title"`
+	Tag          string          `json:"tag,omitempty"`
+	Opts         csource.Options `json:"opts"`
+	CRepro       bool            `json:"crepro"`
+	Runs         int             `json:"runs"`
+	Crashes      int             `json:"crashes"`
+	CrashTime    time.Duration   `json:"crash_time"`
+	Duration     time.Duration   `json:"duration"`
+	TestDuration time.Duration   `json:"test_duration"`
+}
+
+// Meta returns description of the reproducer, tag identifies the kernel (e.g. commit).
+func (res *Result) Meta(tag string) *Meta {
+	return &Meta{
+		Title:        res.Title,
+		Tag:          tag,
+		Opts:         res.Opts,
+		CRepro:       res.CRepro,
+		Runs:         res.Runs,
+		Crashes:      res.Crashes,
+		CrashTime:    res.CrashTime,
+		Duration:     res.Duration,
+		TestDuration: res.TestDuration,
+	}
+}
+
+// Confidence describes reliability of the reproducer in human-readable form.
+func (meta *Meta) Confidence() string {
+	if meta.Runs == 0 {
+		return "reliability is unknown"
+	}
+	kind := "flaky"
+	switch {
+	case meta.Crashes == meta.Runs:
+		kind = "deterministic"
+	case meta.Crashes == 0:
+		kind = "did not reproduce"
+	}
+	str := fmt.Sprintf("%v: crashed %v out of %v runs", kind, meta.Crashes, meta.Runs)
+	if meta.Crashes != 0 {
+		str += fmt.Sprintf(", average time to crash %v", meta.CrashTime)
+	}
+	return str
+}
+
+// Save writes the reproducer bundle into dir.
+func (res *Result) Save(dir, tag string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create repro dir: %v", err)
+	}
+	prog := append([]byte(fmt.Sprintf("# %+v\n", res.Opts)), res.Prog.Serialize()...)
+	if err := ioutil.WriteFile(filepath.Join(dir, ProgFile), prog, 0660); err != nil {
+		return fmt.Errorf("failed to write repro: %v", err)
+	}
+	if res.CRepro {
+		cprog, err := csource.Write(res.Prog, res.Opts)
+		if err != nil {
+			return fmt.Errorf("failed to write C source: %v", err)
+		}
+		if formatted, err := csource.Format(cprog); err == nil {
+			cprog = formatted
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, CProgFile), cprog, 0660); err != nil {
+			return fmt.Errorf("failed to write C repro: %v", err)
+		}
+	}
+	meta, err := json.MarshalIndent(res.Meta(tag), "", "\t")
+	if err != nil {
+		return fmt.Errorf("failed to marshal repro meta: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, MetaFile), append(meta, '\n'), 0660); err != nil {
+		return fmt.Errorf("failed to write repro meta: %v", err)
+	}
+	return nil
+}
+
+// LoadMeta reads description of the reproducer saved in dir.
+func LoadMeta(dir string) (*Meta, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, MetaFile))
+	if err != nil {
+		return nil, err
+	}
+	meta := new(Meta)
+	if err := json.Unmarshal(data, meta); err != nil {
+		return nil, fmt.Errorf("failed to parse %v: %v", MetaFile, err)
+	}
+	return meta, nil
+}
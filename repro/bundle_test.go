@@ -0,0 +1,105 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package repro
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/syzkaller/csource"
+	"github.com/google/syzkaller/prog"
+)
+
+func TestBundle(t *testing.T) {
+	p, err := prog.Deserialize([]byte("getpid()\n"))
+	if err != nil {
+		t.Fatalf("failed to deserialize program: %v", err)
+	}
+	for _, crepro := range []bool{false, true} {
+		dir, err := ioutil.TempDir("", "syz-repro-test")
+		if err != nil {
+			t.Fatalf("failed to create temp dir: %v", err)
+		}
+		defer os.RemoveAll(dir)
+		res := &Result{
+			Prog:         p,
+			Opts:         csource.Options{Threaded: true, Procs: 2, Sandbox: "none"},
+			CRepro:       crepro,
+			Runs:         3,
+			Crashes:      2,
+			CrashTime:    5 * time.Second,
+			Title:        "KASAN: use-after-free in foo",
+			Duration:     time.Hour,
+			TestDuration: time.Minute,
+		}
+		if err := res.Save(filepath.Join(dir, "bundle"), "v4.13"); err != nil {
+			t.Fatalf("failed to save bundle: %v", err)
+		}
+		infos, err := ioutil.ReadDir(filepath.Join(dir, "bundle"))
+		if err != nil {
+			t.Fatalf("failed to read bundle dir: %v", err)
+		}
+		var files []string
+		for _, info := range infos {
+			files = append(files, info.Name())
+		}
+		want := []string{MetaFile, ProgFile}
+		if crepro {
+			want = []string{CProgFile, MetaFile, ProgFile}
+		}
+		if !reflect.DeepEqual(files, want) {
+			t.Fatalf("crepro=%v: bundle files %q, want %q", crepro, files, want)
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, "bundle", ProgFile))
+		if err != nil {
+			t.Fatalf("failed to read %v: %v", ProgFile, err)
+		}
+		if want := fmt.Sprintf("# %+v\ngetpid()\n", res.Opts); string(data) != want {
+			t.Fatalf("bad %v:\n%s\nwant:\n%s", ProgFile, data, want)
+		}
+		if crepro {
+			data, err := ioutil.ReadFile(filepath.Join(dir, "bundle", CProgFile))
+			if err != nil {
+				t.Fatalf("failed to read %v: %v", CProgFile, err)
+			}
+			if !strings.Contains(string(data), "__NR_getpid") {
+				t.Fatalf("%v does not contain the program:\n%s", CProgFile, data)
+			}
+		}
+		meta, err := LoadMeta(filepath.Join(dir, "bundle"))
+		if err != nil {
+			t.Fatalf("failed to load meta: %v", err)
+		}
+		if !reflect.DeepEqual(meta, res.Meta("v4.13")) {
+			t.Fatalf("loaded meta %+v, want %+v", *meta, *res.Meta("v4.13"))
+		}
+		if want := "flaky: crashed 2 out of 3 runs, average time to crash 5s"; meta.Confidence() != want {
+			t.Fatalf("confidence %q, want %q", meta.Confidence(), want)
+		}
+	}
+}
+
+func TestLoadMetaMissing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syz-repro-test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if _, err := LoadMeta(dir); err == nil {
+		t.Fatalf("loaded meta from empty dir")
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, MetaFile), []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadMeta(dir); err == nil {
+		t.Fatalf("loaded corrupted meta")
+	}
+}
@@ -5,9 +5,10 @@ package repro
 
 import (
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
-	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -16,7 +17,6 @@ import (
 	"github.com/google/syzkaller/fileutil"
 	. "github.com/google/syzkaller/log"
 	"github.com/google/syzkaller/prog"
-	"github.com/google/syzkaller/report"
 	"github.com/google/syzkaller/vm"
 )
 
@@ -24,6 +24,24 @@ type Result struct {
 	Prog   *prog.Prog
 	Opts   csource.Options
 	CRepro bool
+
+	// Reliability of the reproducer measured by running it on fresh VMs
+	// (the C reproducer if there is one).
+	Runs      int           // number of runs
+	Crashes   int           // number of runs that crashed
+	CrashTime time.Duration // average time to crash
+
+	Title        string        // title of the reproduced crash
+	Duration     time.Duration // time it took to find the reproducer
+	TestDuration time.Duration // duration of a single run of the reproducer
+}
+
+// confidenceRuns is the number of runs of the final reproducer to measure its reliability.
+const confidenceRuns = 10
+
+// Confidence describes reliability of the reproducer in human-readable form.
+func (res *Result) Confidence() string {
+	return res.Meta("").Confidence()
 }
 
 type context struct {
@@ -31,6 +49,8 @@ type context struct {
 	crashDesc    string
 	instances    chan *instance
 	bootRequests chan int
+	vms          int // number of VMs in the pool
+	repeats      int // number of concurrent runs of every test after the crashing program is found
 }
 
 type instance struct {
@@ -40,7 +60,14 @@ type instance struct {
 	executorBin string
 }
 
+// Run reproduces the first crash in crashLog.
 func Run(crashLog []byte, cfg *config.Config, vmIndexes []int) (*Result, error) {
+	return RunCrash(crashLog, 0, cfg, vmIndexes)
+}
+
+// RunCrash reproduces crash number crash (in the order returned by ParseCrashes) in crashLog.
+// If the log does not contain any crashes, the VM is assumed to hang at the end of the log.
+func RunCrash(crashLog []byte, crash int, cfg *config.Config, vmIndexes []int) (*Result, error) {
 	if len(vmIndexes) == 0 {
 		return nil, fmt.Errorf("no VMs provided")
 	}
@@ -51,18 +78,30 @@ func Run(crashLog []byte, cfg *config.Config, vmIndexes []int) (*Result, error)
 	if len(entries) == 0 {
 		return nil, fmt.Errorf("crash log does not contain any programs")
 	}
-	crashDesc, _, crashStart, _ := report.Parse(crashLog, cfg.ParsedIgnores)
-	if crashDesc == "" {
-		crashStart = len(crashLog) // assuming VM hanged
-		crashDesc = "hang"
+	crashDesc, crashStart, logStart := "hang", len(crashLog), 0 // assuming VM hanged
+	if crashes := ParseCrashes(crashLog, cfg.Reporter); crash < len(crashes) {
+		crashDesc, crashStart = crashes[crash].Title, crashes[crash].Start
+		if crash != 0 {
+			// Programs executed before the previous crash did not cause this one.
+			logStart = crashes[crash-1].Start
+		}
+	} else if crash != 0 {
+		return nil, fmt.Errorf("crash log contains %v crashes, no crash #%v", len(crashes), crash)
+	}
+	suspected := suspectPrograms(crashLog, entries, logStart, crashStart)
+	if len(suspected) == 0 {
+		return nil, fmt.Errorf("crash log does not contain any programs executed before the crash")
 	}
 	Logf(0, "reproducing crash '%v': %v programs, %v VMs", crashDesc, len(entries), len(vmIndexes))
+	start := time.Now()
 
 	ctx := &context{
 		cfg:          cfg,
 		crashDesc:    crashDesc,
 		instances:    make(chan *instance, len(vmIndexes)),
 		bootRequests: make(chan int, len(vmIndexes)),
+		vms:          len(vmIndexes),
+		repeats:      1,
 	}
 	var wg sync.WaitGroup
 	wg.Add(len(vmIndexes))
@@ -79,7 +118,7 @@ func Run(crashLog []byte, cfg *config.Config, vmIndexes []int) (*Result, error)
 						time.Sleep(10 * time.Second)
 						continue
 					}
-					vmInst, err := vm.Create(cfg.Type, vmCfg)
+					vmInst, err := vm.Create(vmCfg.Type, vmCfg)
 					if err != nil {
 						Logf(0, "reproducing crash '%v': failed to create VM: %v", crashDesc, err)
 						time.Sleep(10 * time.Second)
@@ -115,7 +154,12 @@ func Run(crashLog []byte, cfg *config.Config, vmIndexes []int) (*Result, error)
 		close(ctx.instances)
 	}()
 
-	res, err := ctx.repro(entries, crashStart)
+	res, err := ctx.repro(suspected)
+	Logf(0, "reproducing crash '%v': finished in %v", crashDesc, time.Since(start))
+	if res != nil {
+		res.Title = crashDesc
+		res.Duration = time.Since(start)
+	}
 
 	close(ctx.bootRequests)
 	for inst := range ctx.instances {
@@ -124,28 +168,9 @@ func Run(crashLog []byte, cfg *config.Config, vmIndexes []int) (*Result, error)
 	return res, err
 }
 
-func (ctx *context) repro(entries []*prog.LogEntry, crashStart int) (*Result, error) {
-	// Cut programs that were executed after crash.
-	for i, ent := range entries {
-		if ent.Start > crashStart {
-			entries = entries[:i]
-			break
-		}
-	}
-	// Extract last program on every proc.
-	procs := make(map[int]int)
-	for i, ent := range entries {
-		procs[ent.Proc] = i
-	}
-	var indices []int
-	for _, idx := range procs {
-		indices = append(indices, idx)
-	}
-	sort.Ints(indices)
-	var suspected []*prog.LogEntry
-	for i := len(indices) - 1; i >= 0; i-- {
-		suspected = append(suspected, entries[indices[i]])
-	}
+// repro tries the suspected programs (most likely first), minimizes the crashing one
+// and tries to simplify it into a C reproducer.
+func (ctx *context) repro(suspected []*prog.LogEntry) (*Result, error) {
 	Logf(2, "reproducing crash '%v': suspecting %v programs", ctx.crashDesc, len(suspected))
 	opts := csource.Options{
 		Threaded: true,
@@ -153,31 +178,42 @@ func (ctx *context) repro(entries []*prog.LogEntry, crashStart int) (*Result, er
 		Repeat:   true,
 		Procs:    ctx.cfg.Procs,
 		Sandbox:  ctx.cfg.Sandbox,
+		Netns:    ctx.cfg.Netns,
 		Repro:    true,
 	}
-	// Execute the suspected programs.
+	entryOpts := make([]csource.Options, len(suspected))
+	for i, ent := range suspected {
+		entryOpts[i] = opts
+		if ent.Fault {
+			// The crash may be on an error path, so inject the same fault.
+			entryOpts[i].Fault = true
+			entryOpts[i].FaultCall = ent.FaultCall
+			entryOpts[i].FaultNth = ent.FaultNth
+			entryOpts[i].Collide = false
+		}
+	}
+	// Execute the suspected programs concurrently on all VMs.
 	// We first try to execute each program for 10 seconds, that should detect simple crashes
 	// (i.e. no races and no hangs). Then we execute each program for 5 minutes
 	// to catch races and hangs. Note that the max duration must be larger than
 	// hang/no output detection duration in vm.MonitorExecution, which is currently set to 3 mins.
+	// If several programs crash, the most suspected one wins.
 	var res *Result
 	var duration time.Duration
 	for _, dur := range []time.Duration{10 * time.Second, 5 * time.Minute} {
-		for _, ent := range suspected {
-			crashed, err := ctx.testProg(ent.P, dur, opts, true)
-			if err != nil {
-				return nil, err
-			}
-			if crashed {
-				res = &Result{
-					Prog: ent.P,
-					Opts: opts,
-				}
-				duration = dur * 3 / 2
-				break
-			}
+		crashed, err := ctx.testParallel(len(suspected), true, func(i int) (bool, error) {
+			return ctx.testProg(suspected[i].P, dur, entryOpts[i], true)
+		})
+		if err != nil {
+			return nil, err
 		}
-		if res != nil {
+		if idx := firstCrashed(crashed); idx != -1 {
+			res = &Result{
+				Prog: suspected[idx].P,
+				Opts: entryOpts[idx],
+			}
+			duration = dur * 3 / 2
+			res.TestDuration = duration
 			break
 		}
 	}
@@ -185,97 +221,368 @@ func (ctx *context) repro(entries []*prog.LogEntry, crashStart int) (*Result, er
 		Logf(0, "reproducing crash '%v': no program crashed", ctx.crashDesc)
 		return nil, nil
 	}
+
+	// Measure how reliably the program crashes to choose how many times
+	// every minimization test needs to be executed to not miss the crash.
+	runs, err := ctx.testParallel(ctx.vms, false, func(int) (bool, error) {
+		return ctx.testProg(res.Prog, duration, res.Opts, false)
+	})
+	if err != nil {
+		return nil, err
+	}
+	crashes := 0
+	for _, crashed := range runs {
+		if crashed {
+			crashes++
+		}
+	}
+	ctx.repeats = testRepeats(crashes, len(runs), ctx.vms)
+	Logf(1, "reproducing crash '%v': program crashed %v/%v times, using %v runs per test",
+		ctx.crashDesc, crashes, len(runs), ctx.repeats)
 	defer func() {
 		res.Opts.Repro = false
 	}()
 
 	Logf(2, "reproducing crash '%v': minimizing guilty program", ctx.crashDesc)
-	res.Prog, _ = prog.Minimize(res.Prog, -1, func(p1 *prog.Prog, callIndex int) bool {
-		crashed, err := ctx.testProg(p1, duration, res.Opts, false)
-		if err != nil {
-			Logf(1, "reproducing crash '%v': minimization failed with %v", ctx.crashDesc, err)
-			return false
-		}
-		return crashed
-	}, true)
+	last := res.Prog
+	faultCall := -1
+	if res.Opts.Fault {
+		faultCall = res.Opts.FaultCall
+	}
+	// Every test takes several VM runs, so programs that grow in size are rejected without testing.
+	res.Prog, faultCall = prog.MinimizeWith(res.Prog, faultCall, prog.MinimizeOptions{
+		Pred: func(p1 *prog.Prog, callIndex int) bool {
+			opts := res.Opts
+			opts.FaultCall = callIndex
+			crashed, err := ctx.testProgRepeated(p1, duration, opts)
+			if err != nil {
+				Logf(1, "reproducing crash '%v': minimization failed with %v", ctx.crashDesc, err)
+				return false
+			}
+			if crashed {
+				for _, d := range prog.Diff(last, p1) {
+					Logf(3, "reproducing crash '%v': minimization step: %v", ctx.crashDesc, d)
+				}
+				last = p1.Clone()
+			}
+			return crashed
+		},
+		Cost:  prog.CostSize,
+		Crash: true,
+	})
+	if res.Opts.Fault {
+		res.Opts.FaultCall = faultCall
+	}
 
 	// Try to "minimize" threaded/collide/sandbox/etc to find simpler reproducer.
-	opts = res.Opts
-	opts.Collide = false
-	crashed, err := ctx.testProg(res.Prog, duration, opts, false)
+	err = ctx.simplify(res, false, func(opts csource.Options) (bool, error) {
+		return ctx.testProgRepeated(res.Prog, duration, opts)
+	})
 	if err != nil {
 		return res, err
 	}
-	if crashed {
-		res.Opts = opts
-		opts.Threaded = false
-		crashed, err := ctx.testProg(res.Prog, duration, opts, false)
+
+	res.CRepro, err = ctx.testCProg(res.Prog, duration, res.Opts)
+	if err != nil {
+		return res, err
+	}
+	if !res.CRepro && res.Opts.Threaded {
+		// The crash may depend on the exact thread/timing structure of executor.
+		opts := res.Opts
+		opts.Faithful = true
+		opts.CallTimeout = ctx.cfg.Call_Timeout
+		crashed, err := ctx.testCProg(res.Prog, duration, opts)
 		if err != nil {
 			return res, err
 		}
 		if crashed {
 			res.Opts = opts
+			res.CRepro = true
 		}
 	}
-	if res.Opts.Sandbox == "namespace" {
-		opts = res.Opts
-		opts.Sandbox = "none"
-		crashed, err := ctx.testProg(res.Prog, duration, opts, false)
+	if res.CRepro {
+		// The C program does not need everything executor needs (and the other way around),
+		// so once again look for the simplest environment that still reproduces the crash.
+		err = ctx.simplify(res, true, func(opts csource.Options) (bool, error) {
+			return ctx.testCProg(res.Prog, duration, opts)
+		})
 		if err != nil {
 			return res, err
 		}
-		if crashed {
-			res.Opts = opts
-		}
 	}
-	if res.Opts.Procs > 1 {
-		opts = res.Opts
+	if err := ctx.measureConfidence(res, duration); err != nil {
+		Logf(0, "reproducing crash '%v': failed to measure reproducer reliability: %v", ctx.crashDesc, err)
+	}
+	Logf(0, "reproducing crash '%v': reproducer is %v", ctx.crashDesc, res.Confidence())
+	return res, nil
+}
+
+// simplification turns off one feature of the reproducer environment,
+// apply returns false if the feature is already off.
+type simplification struct {
+	name  string
+	cOnly bool // applicable only to C reproducers
+	apply func(opts *csource.Options) bool
+}
+
+// simplifications are tried in order, later ones depend on earlier ones
+// (e.g. programs can't collide without threads).
+var simplifications = []simplification{
+	{"collide", false, func(opts *csource.Options) bool {
+		if !opts.Collide {
+			return false
+		}
+		opts.Collide = false
+		return true
+	}},
+	{"threaded", false, func(opts *csource.Options) bool {
+		if !opts.Threaded || opts.Collide {
+			return false
+		}
+		opts.Threaded = false
+		opts.Faithful = false
+		return true
+	}},
+	{"faithful", true, func(opts *csource.Options) bool {
+		if !opts.Faithful {
+			return false
+		}
+		opts.Faithful = false
+		return true
+	}},
+	{"fault", false, func(opts *csource.Options) bool {
+		if !opts.Fault {
+			return false
+		}
+		opts.Fault = false
+		return true
+	}},
+	{"sandbox", false, func(opts *csource.Options) bool {
+		if opts.Sandbox == "none" || opts.Sandbox == "" {
+			return false
+		}
+		opts.Sandbox = "none"
+		return true
+	}},
+	{"netns", false, func(opts *csource.Options) bool {
+		if !opts.Netns {
+			return false
+		}
+		opts.Netns = false
+		return true
+	}},
+	{"tun", true, func(opts *csource.Options) bool {
+		if !opts.Tun {
+			return false
+		}
+		opts.Tun = false
+		return true
+	}},
+	{"procs", false, func(opts *csource.Options) bool {
+		if opts.Procs <= 1 {
+			return false
+		}
 		opts.Procs = 1
-		crashed, err := ctx.testProg(res.Prog, duration, opts, false)
+		return true
+	}},
+	{"repeat", false, func(opts *csource.Options) bool {
+		if !opts.Repeat {
+			return false
+		}
+		opts.Repeat = false
+		return true
+	}},
+	{"harness setup", true, func(opts *csource.Options) bool {
+		if opts.Minimal {
+			return false
+		}
+		opts.Minimal = true
+		return true
+	}},
+}
+
+// simplify tries to turn off features of the reproducer environment one by one,
+// a feature stays off if test says that the crash still reproduces without it.
+func (ctx *context) simplify(res *Result, cprog bool, test func(opts csource.Options) (bool, error)) error {
+	for _, simpl := range simplifications {
+		opts := res.Opts
+		if simpl.cOnly && !cprog || !simpl.apply(&opts) {
+			continue
+		}
+		crashed, err := test(opts)
 		if err != nil {
-			return res, err
+			return err
 		}
 		if crashed {
+			Logf(2, "reproducing crash '%v': crash reproduces without %v", ctx.crashDesc, simpl.name)
 			res.Opts = opts
 		}
 	}
-	if res.Opts.Repeat {
-		opts = res.Opts
-		opts.Repeat = false
-		crashed, err := ctx.testProg(res.Prog, duration, opts, false)
-		if err != nil {
-			return res, err
+	return nil
+}
+
+// measureConfidence runs the final reproducer confidenceRuns times on fresh VMs
+// and records the hit rate and average time to crash in res.
+func (ctx *context) measureConfidence(res *Result, duration time.Duration) error {
+	var bin string
+	if res.CRepro {
+		var err error
+		if bin, err = ctx.buildCProg(res.Prog, res.Opts); err != nil {
+			return err
+		}
+		defer os.Remove(bin)
+	}
+	var mu sync.Mutex
+	var total time.Duration
+	runs, err := ctx.testParallel(confidenceRuns, false, func(int) (bool, error) {
+		var crashed bool
+		var crashTime time.Duration
+		var err error
+		if res.CRepro {
+			crashed, crashTime, err = ctx.runBin(bin, duration, true)
+		} else {
+			crashed, crashTime, err = ctx.runProg(res.Prog, duration, res.Opts, true)
 		}
+		mu.Lock()
+		total += crashTime
+		mu.Unlock()
+		return crashed, err
+	})
+	if err != nil {
+		return err
+	}
+	res.Runs = len(runs)
+	for _, crashed := range runs {
 		if crashed {
-			res.Opts = opts
+			res.Crashes++
 		}
 	}
+	if res.Crashes != 0 {
+		res.CrashTime = (total / time.Duration(res.Crashes)).Truncate(time.Second)
+	}
+	return nil
+}
 
-	src, err := csource.Write(res.Prog, res.Opts)
+func (ctx *context) buildCProg(p *prog.Prog, opts csource.Options) (string, error) {
+	src, err := csource.Write(p, opts)
 	if err != nil {
-		return res, err
+		return "", err
 	}
 	srcf, err := fileutil.WriteTempFile(src)
 	if err != nil {
-		return res, err
+		return "", err
 	}
-	bin, err := csource.Build("c", srcf)
+	defer os.Remove(srcf)
+	return csource.Build("c", srcf)
+}
+
+func (ctx *context) testCProg(p *prog.Prog, duration time.Duration, opts csource.Options) (crashed bool, err error) {
+	bin, err := ctx.buildCProg(p, opts)
 	if err != nil {
-		return res, err
+		return false, err
 	}
 	defer os.Remove(bin)
-	crashed, err = ctx.testBin(bin, duration, false)
-	if err != nil {
-		return res, err
+	runs, err := ctx.testParallel(ctx.repeats, true, func(int) (bool, error) {
+		return ctx.testBin(bin, duration, false)
+	})
+	return firstCrashed(runs) != -1, err
+}
+
+// testProgRepeated executes the program ctx.repeats times concurrently,
+// which is needed to not miss crashes that don't reproduce reliably.
+func (ctx *context) testProgRepeated(p *prog.Prog, duration time.Duration, opts csource.Options) (bool, error) {
+	runs, err := ctx.testParallel(ctx.repeats, true, func(int) (bool, error) {
+		return ctx.testProg(p, duration, opts, false)
+	})
+	return firstCrashed(runs) != -1, err
+}
+
+// testParallel runs tests [0, n) concurrently on all VMs and returns which of them crashed.
+// If stopOnCrash is set, tests after a crashed test are not started.
+// An error is returned only if no test crashed.
+func (ctx *context) testParallel(n int, stopOnCrash bool, test func(i int) (bool, error)) ([]bool, error) {
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		crashed  = make([]bool, n)
+		first    = -1
+		firstErr error
+	)
+	slots := make(chan bool, ctx.vms)
+	for i := 0; i < n; i++ {
+		slots <- true
+		mu.Lock()
+		stop := stopOnCrash && first != -1
+		mu.Unlock()
+		if stop {
+			<-slots
+			break
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			res, err := test(i)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			crashed[i] = res
+			if res && (first == -1 || i < first) {
+				first = i
+			}
+		}(i)
 	}
-	res.CRepro = crashed
-	return res, nil
+	wg.Wait()
+	if first == -1 && firstErr != nil {
+		return nil, firstErr
+	}
+	return crashed, nil
+}
+
+func firstCrashed(crashed []bool) int {
+	for i, c := range crashed {
+		if c {
+			return i
+		}
+	}
+	return -1
+}
+
+// testRepeats returns the number of runs of every test that is needed to detect the crash
+// with 95% probability given that the program crashed crashes out of runs times.
+func testRepeats(crashes, runs, max int) int {
+	if crashes == 0 {
+		// The crash did not reproduce at all this time, it is very flaky.
+		return max
+	}
+	rate := float64(crashes) / float64(runs)
+	if rate >= 1 {
+		return 1
+	}
+	n := int(math.Ceil(math.Log(0.05) / math.Log(1-rate)))
+	if n > max {
+		n = max
+	}
+	return n
 }
 
 func (ctx *context) testProg(p *prog.Prog, duration time.Duration, opts csource.Options, reboot bool) (crashed bool, err error) {
+	crashed, _, err = ctx.runProg(p, duration, opts, reboot)
+	return
+}
+
+// runProg is testProg that also returns time from the program start to the crash.
+func (ctx *context) runProg(p *prog.Prog, duration time.Duration, opts csource.Options, reboot bool) (crashed bool, crashTime time.Duration, err error) {
 	inst := <-ctx.instances
 	if inst == nil {
-		return false, fmt.Errorf("all VMs failed to boot")
+		return false, 0, fmt.Errorf("all VMs failed to boot")
 	}
 	defer func() {
 		ctx.returnInstance(inst, reboot, crashed)
@@ -284,29 +591,30 @@ func (ctx *context) testProg(p *prog.Prog, duration time.Duration, opts csource.
 	pstr := p.Serialize()
 	progFile, err := fileutil.WriteTempFile(pstr)
 	if err != nil {
-		return false, err
+		return false, 0, err
 	}
 	defer os.Remove(progFile)
 	vmProgFile, err := inst.Copy(progFile)
 	if err != nil {
-		return false, fmt.Errorf("failed to copy to VM: %v", err)
+		return false, 0, fmt.Errorf("failed to copy to VM: %v", err)
 	}
 
-	repeat := "1"
-	if opts.Repeat {
-		repeat = "0"
-	}
-	command := fmt.Sprintf("%v -executor %v -cover=0 -procs=%v -repeat=%v -sandbox %v -threaded=%v -collide=%v %v",
-		inst.execprogBin, inst.executorBin, opts.Procs, repeat, opts.Sandbox, opts.Threaded, opts.Collide, vmProgFile)
+	command := ExecprogCommand(ctx.cfg, inst.execprogBin, inst.executorBin, vmProgFile, opts)
 	Logf(2, "reproducing crash '%v': testing program (duration=%v, %+v): %s",
 		ctx.crashDesc, duration, opts, p)
 	return ctx.testImpl(inst, command, duration)
 }
 
 func (ctx *context) testBin(bin string, duration time.Duration, reboot bool) (crashed bool, err error) {
+	crashed, _, err = ctx.runBin(bin, duration, reboot)
+	return
+}
+
+// runBin is testBin that also returns time from the program start to the crash.
+func (ctx *context) runBin(bin string, duration time.Duration, reboot bool) (crashed bool, crashTime time.Duration, err error) {
 	inst := <-ctx.instances
 	if inst == nil {
-		return false, fmt.Errorf("all VMs failed to boot")
+		return false, 0, fmt.Errorf("all VMs failed to boot")
 	}
 	defer func() {
 		ctx.returnInstance(inst, reboot, crashed)
@@ -314,25 +622,46 @@ func (ctx *context) testBin(bin string, duration time.Duration, reboot bool) (cr
 
 	bin, err = inst.Copy(bin)
 	if err != nil {
-		return false, fmt.Errorf("failed to copy to VM: %v", err)
+		return false, 0, fmt.Errorf("failed to copy to VM: %v", err)
 	}
 	Logf(2, "reproducing crash '%v': testing compiled C program", ctx.crashDesc)
 	return ctx.testImpl(inst, bin, duration)
 }
 
-func (ctx *context) testImpl(inst vm.Instance, command string, duration time.Duration) (crashed bool, err error) {
+// ExecprogCommand returns the command that executes programs from progFile in the VM
+// with syz-execprog the same way as the reproducer does it.
+func ExecprogCommand(cfg *config.Config, execprog, executor, progFile string, opts csource.Options) string {
+	repeat := "1"
+	if opts.Repeat {
+		repeat = "0"
+	}
+	seccomp := ""
+	if opts.Sandbox == "namespace" && len(cfg.Seccomp_Deny) != 0 {
+		seccomp = fmt.Sprintf(" -seccomp_deny=%v", strings.Join(cfg.Seccomp_Deny, ","))
+	}
+	fault := ""
+	if opts.Fault {
+		fault = fmt.Sprintf(" -fault_call=%v -fault_nth=%v", opts.FaultCall, opts.FaultNth)
+	}
+	return fmt.Sprintf("%v -executor %v -cover=0 -procs=%v -repeat=%v -sandbox %v -threaded=%v -collide=%v -netns=%v -call_timeout=%v%v%v %v",
+		execprog, executor, opts.Procs, repeat, opts.Sandbox, opts.Threaded, opts.Collide, opts.Netns,
+		time.Duration(cfg.Call_Timeout)*time.Millisecond, seccomp, fault, progFile)
+}
+
+func (ctx *context) testImpl(inst vm.Instance, command string, duration time.Duration) (crashed bool, crashTime time.Duration, err error) {
+	start := time.Now()
 	outc, errc, err := inst.Run(duration, nil, command)
 	if err != nil {
-		return false, fmt.Errorf("failed to run command in VM: %v", err)
+		return false, 0, fmt.Errorf("failed to run command in VM: %v", err)
 	}
-	desc, text, output, crashed, timedout := vm.MonitorExecution(outc, errc, false, false, ctx.cfg.ParsedIgnores)
+	desc, text, output, crashed, timedout := vm.MonitorExecution(outc, errc, false, false, ctx.cfg.Reporter)
 	_, _, _ = text, output, timedout
 	if !crashed {
 		Logf(2, "reproducing crash '%v': program did not crash", ctx.crashDesc)
-		return false, nil
+		return false, 0, nil
 	}
 	Logf(2, "reproducing crash '%v': program crashed: %v", ctx.crashDesc, desc)
-	return true, nil
+	return true, time.Since(start), nil
 }
 
 func (ctx *context) returnInstance(inst *instance, reboot, crashed bool) {
@@ -0,0 +1,153 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package repro
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/syzkaller/prog"
+	"github.com/google/syzkaller/report"
+)
+
+const (
+	// suspectRanks is the number of last programs on every proc that are suspected.
+	suspectRanks = 3
+	// crashWindow is how long before the crash a program must be started to be suspected
+	// (programs started earlier are suspected only if they are the last ones on their procs).
+	crashWindow = time.Minute
+)
+
+// Crash is a crash found in a log. A log can contain several crashes,
+// e.g. if it is console output of several test runs.
+type Crash struct {
+	Title string
+	Start int // start offset of the crash report in the log
+}
+
+// ParseCrashes returns all crashes in the log in the order they happened.
+// A crash report ends at the next executed program.
+func ParseCrashes(log []byte, reporter report.Reporter) []Crash {
+	var crashes []Crash
+	for pos := 0; pos < len(log); {
+		desc, _, start, _ := reporter.Parse(log[pos:])
+		if desc == "" {
+			break
+		}
+		crashes = append(crashes, Crash{desc, pos + start})
+		next := bytes.Index(log[pos+start+1:], []byte("executing program "))
+		if next == -1 {
+			break
+		}
+		pos += start + 1 + next
+	}
+	return crashes
+}
+
+// suspectPrograms returns programs from the log that could have caused the crash at crashStart,
+// most likely first. Programs executed before logStart (i.e. before the previous crash) are ignored.
+// The last program on every proc is the prime suspect, then the second to last and so on.
+// Programs with the same rank are ordered by start time with the latest first.
+// If the log has kernel console timestamps, programs started more than crashWindow
+// before the crash are tried last (if they are the last ones on their procs) or not at all.
+// A program executed several times (e.g. on different procs) is returned once.
+func suspectPrograms(log []byte, entries []*prog.LogEntry, logStart, crashStart int) []*prog.LogEntry {
+	times := parseConsoleTimes(log)
+	for len(times) != 0 && times[0].pos < logStart {
+		times = times[1:]
+	}
+	crashTime, haveCrashTime := timeAt(times, crashStart)
+	var suspects []suspect
+	ranks := make(map[int]int)
+	for i := len(entries) - 1; i >= 0; i-- {
+		ent := entries[i]
+		if ent.Start > crashStart || ent.Start < logStart {
+			continue
+		}
+		rank := ranks[ent.Proc]
+		ranks[ent.Proc]++
+		stale := false
+		if start, ok := timeAt(times, ent.Start); ok && haveCrashTime {
+			stale = crashTime-start > crashWindow
+		}
+		if rank >= suspectRanks || stale && rank != 0 {
+			continue
+		}
+		suspects = append(suspects, suspect{ent, rank, stale})
+	}
+	sort.Stable(suspectsByPriority(suspects))
+	var res []*prog.LogEntry
+	seen := make(map[string]bool)
+	for _, s := range suspects {
+		key := fmt.Sprintf("%v %v %v\n%s", s.ent.Fault, s.ent.FaultCall, s.ent.FaultNth, s.ent.P.Serialize())
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		res = append(res, s.ent)
+	}
+	return res
+}
+
+type suspect struct {
+	ent   *prog.LogEntry
+	rank  int  // number of programs executed on the same proc after this one
+	stale bool // started more than crashWindow before the crash
+}
+
+type suspectsByPriority []suspect
+
+func (s suspectsByPriority) Len() int      { return len(s) }
+func (s suspectsByPriority) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s suspectsByPriority) Less(i, j int) bool {
+	if s[i].stale != s[j].stale {
+		return !s[i].stale
+	}
+	return s[i].rank < s[j].rank
+}
+
+// consoleTime is a kernel console timestamp (time since boot) of the log line at offset pos.
+type consoleTime struct {
+	pos  int
+	time time.Duration
+}
+
+// parseConsoleTimes extracts "[  123.456789] " timestamps of kernel console lines.
+func parseConsoleTimes(log []byte) []consoleTime {
+	var times []consoleTime
+	for pos := 0; pos < len(log); {
+		end := bytes.IndexByte(log[pos:], '\n')
+		if end == -1 {
+			end = len(log)
+		} else {
+			end += pos
+		}
+		line := log[pos:end]
+		if len(line) != 0 && line[0] == '[' {
+			if rb := bytes.IndexByte(line, ']'); rb != -1 {
+				sec, err := strconv.ParseFloat(strings.TrimSpace(string(line[1:rb])), 64)
+				if err == nil {
+					times = append(times, consoleTime{pos, time.Duration(sec * float64(time.Second))})
+				}
+			}
+		}
+		pos = end + 1
+	}
+	return times
+}
+
+// timeAt returns timestamp of the last console line that starts at or before pos.
+// Timestamps are reset when the kernel reboots, so the result is meaningful only for
+// offsets within the output of a single boot.
+func timeAt(times []consoleTime, pos int) (time.Duration, bool) {
+	i := sort.Search(len(times), func(i int) bool { return times[i].pos > pos })
+	if i == 0 {
+		return 0, false
+	}
+	return times[i-1].time, true
+}
@@ -0,0 +1,175 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package repro
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/syzkaller/prog"
+	"github.com/google/syzkaller/report"
+)
+
+func TestSuspectPrograms(t *testing.T) {
+	// PREV and CRASH mark the previous crash and the crash being reproduced.
+	tests := []struct {
+		log  string
+		want []string
+	}{
+		{
+			log:  "",
+			want: nil,
+		},
+		{
+			log:  "booting\nCRASH\n",
+			want: nil,
+		},
+		{
+			// Programs are ordered by rank on their procs, the last program is the first.
+			log: `executing program 0:
+getpid()
+executing program 0:
+getuid()
+executing program 0:
+getgid()
+executing program 0:
+gettid()
+`,
+			want: []string{"gettid()", "getgid()", "getuid()"},
+		},
+		{
+			// Programs with the same rank are ordered by start time, the latest is the first.
+			log: `executing program 0:
+getpid()
+executing program 1:
+getuid()
+executing program 0:
+getgid()
+executing program 1:
+gettid()
+CRASH
+`,
+			want: []string{"gettid()", "getgid()", "getuid()", "getpid()"},
+		},
+		{
+			// Programs executed after the crash or before the previous one are ignored.
+			log: `executing program 0:
+getpid()
+PREV
+executing program 1:
+getuid()
+executing program 0:
+getgid()
+CRASH
+executing program 1:
+gettid()
+`,
+			want: []string{"getgid()", "getuid()"},
+		},
+		{
+			// The same program is tried once, programs with fault injection are different.
+			log: `executing program 0:
+getpid()
+executing program 1:
+getpid()
+executing program 2 (fault-call:0 fault-nth:1):
+getpid()
+executing program 1:
+getuid()
+executing program 2:
+getpid()
+`,
+			want: []string{"getpid()", "getuid()", "getpid()"},
+		},
+		{
+			// Programs started more than crashWindow before the crash are tried last
+			// if they are the last on their procs, or not at all.
+			log: `[   10.000000] executing program 0:
+getpid()
+[   20.000000] executing program 1:
+getuid()
+[   30.000000] executing program 0:
+getgid()
+[  100.000000] executing program 1:
+gettid()
+[  110.000000] CRASH
+`,
+			want: []string{"gettid()", "getgid()"},
+		},
+	}
+	for i, test := range tests {
+		log := []byte(test.log)
+		crashStart := strings.Index(test.log, "CRASH")
+		if crashStart == -1 {
+			crashStart = len(log)
+		}
+		logStart := strings.Index(test.log, "PREV")
+		if logStart == -1 {
+			logStart = 0
+		}
+		var got []string
+		for _, ent := range suspectPrograms(log, prog.ParseLog(log), logStart, crashStart) {
+			got = append(got, strings.TrimSpace(string(ent.P.Serialize())))
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("#%v: got suspects %q, want %q", i, got, test.want)
+		}
+	}
+}
+
+func TestSuspectProgramsFault(t *testing.T) {
+	log := []byte("executing program 2 (fault-call:0 fault-nth:1):\ngetpid()\nexecuting program 1:\ngetpid()\n")
+	suspects := suspectPrograms(log, prog.ParseLog(log), 0, len(log))
+	if len(suspects) != 2 || suspects[0].Fault || !suspects[1].Fault || suspects[1].FaultNth != 1 {
+		t.Fatalf("bad suspects: %+v", suspects)
+	}
+}
+
+func TestParseCrashes(t *testing.T) {
+	tests := []struct {
+		log  string
+		want []string
+	}{
+		{"", nil},
+		{"executing program 0:\ngetpid()\n", nil},
+		{
+			`executing program 0:
+getpid()
+[   10.000000] BUG: KASAN: use-after-free in foo+0x10/0x20
+[   10.000001] Read of size 8
+`,
+			[]string{"KASAN: use-after-free Read in foo"},
+		},
+		{
+			`executing program 0:
+getpid()
+[   10.000000] BUG: KASAN: use-after-free in foo+0x10/0x20
+[   10.000001] Read of size 8
+[   10.000002] BUG: unable to handle kernel NULL pointer dereference at 0000000000000010
+executing program 0:
+getuid()
+[   20.000000] WARNING: CPU: 0 PID: 1 at net/core/dev.c:1 bar+0x1/0x2
+`,
+			[]string{"KASAN: use-after-free Read in foo", "WARNING in bar"},
+		},
+	}
+	reporter, err := report.NewReporter("linux", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, test := range tests {
+		crashes := ParseCrashes([]byte(test.log), reporter)
+		var got []string
+		for _, crash := range crashes {
+			got = append(got, crash.Title)
+			if !strings.HasPrefix(test.log[crash.Start:], "[") {
+				t.Errorf("#%v: crash %q starts at %v: %q", i, crash.Title, crash.Start, test.log[crash.Start:])
+			}
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("#%v: got crashes %q, want %q", i, got, test.want)
+		}
+	}
+}
@@ -5,11 +5,36 @@
 // between various parts of the system.
 package rpctype
 
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/gob"
+	"io/ioutil"
+	"time"
+)
+
+// Capability flags are announced by both sides when a connection is established.
+// A side uses an extension only if the other side has announced it too,
+// so that binaries of different versions can still talk to each other.
+const (
+	CapCompression uint64 = 1 << iota // batches of programs are sent compressed in Packed fields
+	CapBloom                          // hub and manager exchange Bloom filters of their corpora on connect
+	CapCrashes                        // managers share crash titles and reproducers via hub
+	CapResume                         // managers resume syncing after reconnect without sending the corpus
+
+	Caps = CapCompression | CapBloom | CapCrashes | CapResume // all capabilities supported by this version
+)
+
+// HubBloomFPRate is the false positive rate of corpus Bloom filters exchanged by hub and managers.
+// A false positive means that a program is not transferred to the peer that misses it.
+const HubBloomFPRate = 0.001
+
 type RpcInput struct {
 	Call      string
 	Prog      []byte
 	CallIndex int
 	Cover     []uint32
+	Signal    []uint32 // edge signal (empty if the fuzzer uses PC coverage as signal)
 }
 
 type RpcCandidate struct {
@@ -17,20 +42,55 @@ type RpcCandidate struct {
 	Minimized bool
 }
 
+// RpcCallResult is a sampled result of execution of a single call.
+type RpcCallResult struct {
+	Call     string
+	Errno    int // -1 if the call did not finish (blocked or timed out)
+	Cover    int // coverage size
+	Duration time.Duration
+}
+
 type ConnectArgs struct {
 	Name string
+	Caps uint64
 }
 
 type ConnectRes struct {
 	Prios        [][]float32
 	EnabledCalls string
 	NeedCheck    bool
+	Dict         [][]byte // tokens for buffer mutation
+	Caps         uint64   // negotiated capabilities
 }
 
 type CheckArgs struct {
-	Name  string
-	Kcov  bool
-	Calls []string
+	Name           string
+	Kcov           bool
+	FaultInjection bool
+	UsbEmulation   bool
+	Calls          []string
+	Modules        []RpcModule // loaded kernel modules, used to symbolize coverage of module code
+	Machine        *RpcMachineInfo
+}
+
+// RpcMachineInfo describes features of the VM, see host.MachineInfo for the fields.
+type RpcMachineInfo struct {
+	Kernel          string
+	Syscalls        int
+	Namespaces      []string
+	Kcov            bool
+	KcovComparisons bool
+	FaultInjection  bool
+	LeakChecking    bool
+	UsbEmulation    bool
+}
+
+// RpcModule is a kernel module loaded in the VM (see host.Module).
+type RpcModule struct {
+	Name string
+	Addr uint64
+	Size uint64
+	Text uint64
 }
 
 type NewInputArgs struct {
@@ -39,13 +99,25 @@ type NewInputArgs struct {
 }
 
 type PollArgs struct {
-	Name  string
-	Stats map[string]uint64
+	Name          string
+	Stats         map[string]uint64
+	SlowCalls     map[string]uint64  // number of slow executions per syscall since the previous poll
+	DisabledCalls []string           // syscalls that turned out to be unavailable on the target kernel
+	CallWeights   map[string]float32 // current adaptive syscall weights (for debugging)
+	CallResults   []RpcCallResult    // sampled call results since the previous poll
 }
 
 type PollRes struct {
 	Candidates []RpcCandidate
 	NewInputs  []RpcInput
+	NewTokens  [][]byte // tokens added to the dictionary since the previous poll
+	Packed     []byte   // the whole PollRes packed with Pack (if CapCompression is negotiated)
+}
+
+type HubCapsArgs struct {
+	Name string
+	Key  string
+	Caps uint64
 }
 
 type HubConnectArgs struct {
@@ -54,15 +126,106 @@ type HubConnectArgs struct {
 	Fresh  bool
 	Calls  []string
 	Corpus [][]byte
+
+	PackedCorpus []byte // Corpus packed with Pack (if CapCompression is negotiated)
+	CorpusBloom  []byte // Bloom filter of Corpus signatures sent instead of Corpus (if CapBloom is negotiated)
+
+	// Resume asks the hub to continue syncing with the corpus it already knows (if CapResume is negotiated),
+	// the manager sends neither Corpus nor CorpusBloom.
+	Resume bool
+}
+
+type HubConnectRes struct {
+	// Bloom filter of the hub corpus signatures (if CapBloom is negotiated),
+	// the manager sends programs that are not in the filter with the following syncs.
+	CorpusBloom []byte
+
+	// Resumed is set if the hub accepted the Resume request, then CorpusBloom (if not empty)
+	// is the filter of the manager corpus known to the hub. Otherwise the manager needs to connect as usual.
+	Resumed bool
 }
 
 type HubSyncArgs struct {
 	Name string
 	Key  string
+	Caps uint64 // negotiated capabilities
 	Add  [][]byte
 	Del  []string
+
+	// Calls is the new set of enabled syscalls if it changed since the previous sync
+	// (e.g. some syscalls turned out to be unavailable), nil if it did not change.
+	Calls []string
+
+	Crashes []HubCrash // crashes found since the previous sync (if CapCrashes is negotiated)
+
+	PackedAdd []byte // Add packed with Pack (if CapCompression is negotiated)
 }
 
 type HubSyncRes struct {
-	Inputs [][]byte
+	Inputs  [][]byte
+	Crashes []HubCrash // crashes found by other managers (if CapCrashes is negotiated)
+
+	PackedInputs []byte // Inputs packed with Pack (if CapCompression is negotiated)
+}
+
+// HubCrash is a crash found by a manager and shared with other managers via hub.
+type HubCrash struct {
+	Manager string // the manager that found the crash, set by hub
+	Title   string
+	Repro   []byte // syzkaller reproducer (as saved in repro.prog), nil if there is none
+}
+
+// DashReportArgs are sent by managers to the dashboard (syz-dash) every minute,
+// even if there are no new crashes, so that the dashboard knows what kernels are tested.
+type DashReportArgs struct {
+	Name    string
+	Key     string
+	Tag     string // kernel commit the manager tests (config tag), used to track fixes
+	Crashes []DashCrash
+	Repros  []DashRepro
+}
+
+// DashCrash is a crash found since the previous report.
+type DashCrash struct {
+	Title  string
+	Count  int    // number of times the crash happened since the previous report
+	Log    []byte // log and report of the first of them
+	Report []byte
+}
+
+// DashRepro is a reproducer found since the previous report.
+type DashRepro struct {
+	Title  string
+	Syz    []byte // syzkaller reproducer (as saved in repro.prog)
+	C      []byte // C reproducer, nil if there is none
+	Report []byte
+}
+
+// Pack serializes v and compresses the result.
+// Programs are textual and highly redundant, so corpus transfers shrink several times.
+func Pack(v interface{}) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	w, err := flate.NewWriter(buf, flate.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	if err := gob.NewEncoder(w).Encode(v); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unpack decodes data returned by Pack into v.
+func Unpack(data []byte, v interface{}) error {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	if err := gob.NewDecoder(r).Decode(v); err != nil {
+		return err
+	}
+	// Read till the end to check integrity of the compressed stream.
+	_, err := ioutil.ReadAll(r)
+	return err
 }
@@ -0,0 +1,45 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package rpctype
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestPack(t *testing.T) {
+	var progs [][]byte
+	for i := 0; i < 100; i++ {
+		progs = append(progs, []byte("mmap(&(0x7f0000000000/0x1000)=nil, (0x1000), 0x3, 0x32, 0xffffffffffffffff, 0x0)\n"))
+	}
+	res := &PollRes{
+		Candidates: []RpcCandidate{{Prog: progs[0], Minimized: true}},
+		NewInputs:  []RpcInput{{Call: "mmap", Prog: progs[1], CallIndex: 0, Cover: []uint32{1, 2, 3}}},
+	}
+	for _, v := range []interface{}{progs, res} {
+		data, err := Pack(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v1 := reflect.New(reflect.TypeOf(v)).Interface()
+		if err := Unpack(data, v1); err != nil {
+			t.Fatal(err)
+		}
+		if v1 := reflect.ValueOf(v1).Elem().Interface(); !reflect.DeepEqual(v, v1) {
+			t.Fatalf("unpacked %+v, want %+v", v1, v)
+		}
+	}
+	data, err := Pack(progs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := len(bytes.Join(progs, nil)); len(data) > size/10 {
+		t.Errorf("packed %v bytes into %v bytes", size, len(data))
+	}
+	var progs1 [][]byte
+	if err := Unpack(data[:len(data)/2], &progs1); err == nil {
+		t.Errorf("unpacked truncated data")
+	}
+}
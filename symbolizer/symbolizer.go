@@ -43,7 +43,13 @@ func (s *Symbolizer) Symbolize(bin string, pc uint64) ([]Frame, error) {
 }
 
 func (s *Symbolizer) SymbolizeArray(bin string, pcs []uint64) ([]Frame, error) {
-	sub, err := s.getSubprocess(bin)
+	return s.SymbolizeSection(bin, "", pcs)
+}
+
+// SymbolizeSection is like SymbolizeArray, but pcs are offsets in the section of bin
+// (e.g. in .text of a relocatable kernel module object). Empty section means absolute addresses.
+func (s *Symbolizer) SymbolizeSection(bin, section string, pcs []uint64) ([]Frame, error) {
+	sub, err := s.getSubprocess(bin, section)
 	if err != nil {
 		return nil, err
 	}
@@ -59,11 +65,16 @@ func (s *Symbolizer) Close() {
 	}
 }
 
-func (s *Symbolizer) getSubprocess(bin string) (*subprocess, error) {
-	if sub := s.subprocs[bin]; sub != nil {
+func (s *Symbolizer) getSubprocess(bin, section string) (*subprocess, error) {
+	key := bin + ":" + section
+	if sub := s.subprocs[key]; sub != nil {
 		return sub, nil
 	}
-	cmd := exec.Command("addr2line", "-afi", "-e", bin)
+	args := []string{"-afi", "-e", bin}
+	if section != "" {
+		args = append(args, "-j", section)
+	}
+	cmd := exec.Command("addr2line", args...)
 	stdin, err := cmd.StdinPipe()
 	if err != nil {
 		return nil, err
@@ -88,7 +99,7 @@ func (s *Symbolizer) getSubprocess(bin string) (*subprocess, error) {
 	if s.subprocs == nil {
 		s.subprocs = make(map[string]*subprocess)
 	}
-	s.subprocs[bin] = sub
+	s.subprocs[key] = sub
 	return sub, nil
 }
 
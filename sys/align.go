@@ -15,6 +15,8 @@ func initAlign() {
 			rec(t1.Type)
 		case *ArrayType:
 			rec(t1.Type)
+		case *CondType:
+			rec(t1.Type)
 		case *StructType:
 			if !t1.padded {
 				t1.padded = true
@@ -59,26 +61,70 @@ func setBitfieldOffset(t Type, offset uintptr, last bool) {
 	}
 }
 
+// markBitfields groups consecutive bitfields into storage units and assigns bit offsets.
+// Bitfields are allocated from the least significant bit of the storage unit for little-endian
+// units and from the most significant bit for big-endian units (as __BIG_ENDIAN_BITFIELD does).
+// A bitfield with an explicit offset is placed at that offset, leaving a hole before it.
 func markBitfields(t *StructType) {
-	var bfOffset uintptr
+	var pos uintptr // number of allocated bits in the current storage unit
 	for i, f := range t.Fields {
 		if f.BitfieldLength() == 0 {
 			continue
 		}
-		off, last := bfOffset, false
-		bfOffset += f.BitfieldLength()
-		if i == len(t.Fields)-1 || // Last bitfield in a group, if last field of the struct...
-			t.Fields[i+1].BitfieldLength() == 0 || // or next field is not a bitfield...
-			f.Size() != t.Fields[i+1].Size() || // or next field is of different size...
-			bfOffset+t.Fields[i+1].BitfieldLength() > f.Size()*8 { // or next field does not fit into the current group.
-			last, bfOffset = true, 0
+		start := bitfieldPos(f, pos)
+		pos = start + f.BitfieldLength()
+		last := false
+		if i == len(t.Fields)-1 { // Last bitfield in a group, if last field of the struct...
+			last = true
+		} else if next := t.Fields[i+1]; next.BitfieldLength() == 0 || // or next field is not a bitfield...
+			f.Size() != next.Size() || // or next field is of different size...
+			IsBigEndian(f) != IsBigEndian(next) || // or next field is of different endianness...
+			bitfieldPos(next, pos) < pos || // or next field starts before the current one ends...
+			bitfieldPos(next, pos)+next.BitfieldLength() > f.Size()*8 { // or next field does not fit into the current group.
+			last = true
+		}
+		off := start
+		if IsBigEndian(f) {
+			off = f.Size()*8 - start - f.BitfieldLength()
 		}
 		setBitfieldOffset(f, off, last)
+		if last {
+			pos = 0
+		}
+	}
+}
+
+// bitfieldPos returns position of the first bit of bitfield f in the allocation order
+// of its storage unit, pos is the number of bits already allocated in the unit.
+func bitfieldPos(f Type, pos uintptr) uintptr {
+	if !bitfieldFixed(f) {
+		return pos
+	}
+	if IsBigEndian(f) {
+		return f.Size()*8 - f.BitfieldOffset() - f.BitfieldLength()
+	}
+	return f.BitfieldOffset()
+}
+
+func bitfieldFixed(t Type) bool {
+	switch t1 := t.(type) {
+	case *IntType:
+		return t1.BitfieldFixed
+	case *ConstType:
+		return t1.BitfieldFixed
+	case *LenType:
+		return t1.BitfieldFixed
+	case *FlagsType:
+		return t1.BitfieldFixed
+	case *ProcType:
+		return t1.BitfieldFixed
 	}
+	return false
 }
 
 func addAlignment(t *StructType) {
 	if t.packed {
+		addExplicitAlignment(t)
 		// If a struct is packed, statically sized and has explicitly set alignment, add a padding.
 		if !t.Varlen() && t.align != 0 && t.Size()%t.align != 0 {
 			pad := t.align - t.Size()%t.align
@@ -89,12 +135,30 @@ func addAlignment(t *StructType) {
 	var fields []Type
 	var off uintptr
 	align := t.align
+	explicit := false
+	varlen := false // offset is not statically known after a variable length field
 	for i, f := range t.Fields {
+		if a := padAlign(f); a != 0 {
+			// Explicit align[N] field, the struct itself also becomes N-aligned.
+			if varlen {
+				panic(fmt.Sprintf("align field %+v after a variable length field in struct %+v", f, t))
+			}
+			explicit = true
+			if align < a {
+				align = a
+			}
+			if off%a != 0 {
+				pad := a - off%a
+				off += pad
+				fields = append(fields, makeExplicitPad(pad))
+			}
+			continue
+		}
 		a := f.Align()
 		if align < a {
 			align = a
 		}
-		if i > 0 && (t.Fields[i-1].BitfieldLength() == 0 || t.Fields[i-1].BitfieldLast()) {
+		if !varlen && i > 0 && (t.Fields[i-1].BitfieldLength() == 0 || t.Fields[i-1].BitfieldLast()) {
 			// Append padding if the last field is not a bitfield or it's the last bitfield in a set.
 			if off%a != 0 {
 				pad := a - off%a
@@ -103,12 +167,17 @@ func addAlignment(t *StructType) {
 			}
 		}
 		if f.Varlen() && i != len(t.Fields)-1 {
-			panic(fmt.Sprintf("variable length field %+v in the middle of a struct %+v", f, t))
+			// Only conditional fields can follow a variable length field
+			// (e.g. several alternative trailing fields).
+			if _, ok := t.Fields[i+1].(*CondType); !ok {
+				panic(fmt.Sprintf("variable length field %+v in the middle of a struct %+v", f, t))
+			}
 		}
 		fields = append(fields, f)
-		if (f.BitfieldLength() == 0 || f.BitfieldLast()) && (i != len(t.Fields)-1 || !f.Varlen()) {
-			// Increase offset if the current field is not a bitfield or it's the last bitfield in a set,
-			// except when it's the last field in a struct and has variable length.
+		if f.Varlen() {
+			varlen = true
+		} else if f.BitfieldLength() == 0 || f.BitfieldLast() {
+			// Increase offset if the current field is not a bitfield or it's the last bitfield in a set.
 			off += f.Size()
 		}
 	}
@@ -117,9 +186,48 @@ func addAlignment(t *StructType) {
 		off += pad
 		fields = append(fields, makePad(pad))
 	}
+	if explicit {
+		t.align = align
+	}
 	t.Fields = fields
 }
 
+// addExplicitAlignment replaces align[N] fields in a packed struct with padding,
+// offsets are counted from the beginning of the struct.
+func addExplicitAlignment(t *StructType) {
+	var fields []Type
+	var off uintptr
+	varlen := false
+	for _, f := range t.Fields {
+		if a := padAlign(f); a != 0 {
+			if varlen {
+				panic(fmt.Sprintf("align field %+v after a variable length field in struct %+v", f, t))
+			}
+			if off%a != 0 {
+				pad := a - off%a
+				off += pad
+				fields = append(fields, makeExplicitPad(pad))
+			}
+			continue
+		}
+		fields = append(fields, f)
+		if f.Varlen() {
+			varlen = true
+		} else if f.BitfieldLength() == 0 || f.BitfieldLast() {
+			off += f.Size()
+		}
+	}
+	t.Fields = fields
+}
+
+// padAlign returns N for align[N] fields and 0 for all other fields.
+func padAlign(t Type) uintptr {
+	if ct, ok := t.(*ConstType); ok && ct.IsPad {
+		return ct.PadAlign
+	}
+	return 0
+}
+
 func makePad(sz uintptr) Type {
 	return &ConstType{
 		IntTypeCommon: IntTypeCommon{
@@ -130,3 +238,9 @@ func makePad(sz uintptr) Type {
 		IsPad: true,
 	}
 }
+
+func makeExplicitPad(sz uintptr) Type {
+	pad := makePad(sz).(*ConstType)
+	pad.PadZero = true
+	return pad
+}
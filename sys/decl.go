@@ -48,6 +48,25 @@ func IsPad(t Type) bool {
 	return false
 }
 
+// IsBigEndian returns true if t is a big-endian integer.
+func IsBigEndian(t Type) bool {
+	switch t1 := t.(type) {
+	case *IntType:
+		return t1.BigEndian
+	case *ConstType:
+		return t1.BigEndian
+	case *LenType:
+		return t1.BigEndian
+	case *FlagsType:
+		return t1.BigEndian
+	case *ProcType:
+		return t1.BigEndian
+	case *CsumType:
+		return t1.BigEndian
+	}
+	return false
+}
+
 type TypeCommon struct {
 	TypeName   string
 	FldName    string // for struct fields and named args
@@ -96,10 +115,11 @@ const (
 )
 
 type ResourceDesc struct {
-	Name   string
-	Type   Type
-	Kind   []string
-	Values []uintptr
+	Name       string
+	Type       Type
+	Kind       []string
+	Values     []uintptr
+	Destructor string // name of the call that releases the resource (passed as the first arg), if any
 }
 
 type ResourceType struct {
@@ -125,11 +145,12 @@ func (t *ResourceType) Align() uintptr {
 
 type IntTypeCommon struct {
 	TypeCommon
-	TypeSize    uintptr
-	BigEndian   bool
-	BitfieldOff uintptr
-	BitfieldLen uintptr
-	BitfieldLst bool
+	TypeSize      uintptr
+	BigEndian     bool
+	BitfieldOff   uintptr // offset of the least significant bit of the bitfield in the storage unit value
+	BitfieldLen   uintptr
+	BitfieldLst   bool
+	BitfieldFixed bool // BitfieldOff is set explicitly in the description
 }
 
 func (t *IntTypeCommon) Size() uintptr {
@@ -154,8 +175,18 @@ func (t *IntTypeCommon) BitfieldLast() bool {
 
 type ConstType struct {
 	IntTypeCommon
-	Val   uintptr
-	IsPad bool
+	Val      uintptr
+	IsPad    bool
+	PadZero  bool    // explicit padding (pad[N]/align[N] fields) that is zeroed in memory
+	PadAlign uintptr // align[N] field, replaced with padding up to the next N-byte boundary by initAlign
+}
+
+func (t *ConstType) Align() uintptr {
+	if t.IsPad {
+		// Padding itself does not need to be aligned.
+		return 1
+	}
+	return t.Size()
 }
 
 type IntKind int
@@ -174,6 +205,27 @@ type IntType struct {
 	RangeEnd   int64
 }
 
+// VarintType is an integer encoded as unsigned LEB128 (protobuf varint):
+// 7 bits per byte starting from the least significant bits,
+// the high bit of each byte is set if more bytes follow.
+type VarintType struct {
+	TypeCommon
+	RangeBegin uint64 // both 0 if the value is not limited
+	RangeEnd   uint64
+}
+
+func (t *VarintType) Varlen() bool {
+	return true
+}
+
+func (t *VarintType) Size() uintptr {
+	panic(fmt.Sprintf("varint size is not statically known: %v", t.Name()))
+}
+
+func (t *VarintType) Align() uintptr {
+	return 1
+}
+
 type FlagsType struct {
 	IntTypeCommon
 	Vals []uintptr
@@ -183,6 +235,29 @@ type LenType struct {
 	IntTypeCommon
 	ByteSize uintptr // want size in multiple of bytes instead of array size
 	Buf      string
+	Expr     *Expr // if set, the value is calculated by the expression instead of Buf
+}
+
+type ExprOp int
+
+const (
+	ExprConst    ExprOp = iota // Val
+	ExprLen                    // len of Field (number of elements for arrays, size in bytes otherwise)
+	ExprBytesize               // size of Field in bytes
+	ExprOffsetof               // offset of Field in the parent struct in bytes
+	ExprAdd                    // Args[0] + Args[1]
+	ExprSub                    // Args[0] - Args[1]
+	ExprMul                    // Args[0] * Args[1]
+	ExprDiv                    // Args[0] / Args[1], 0 if Args[1] is 0
+)
+
+// Expr is an arithmetic expression over sizes/offsets of fields used by lenexpr fields.
+// As with len fields, Field is the name of a sibling field, "parent" or a name of an enclosing struct.
+type Expr struct {
+	Op    ExprOp
+	Val   uintptr
+	Field string
+	Args  []*Expr
 }
 
 type ProcType struct {
@@ -194,12 +269,25 @@ type ProcType struct {
 type CsumKind int
 
 const (
-	CsumIPv4 CsumKind = iota
+	CsumInet   CsumKind = iota // internet checksum (RFC 1071) of Buf
+	CsumPseudo                 // same, but also covers IPv4/IPv6 pseudo-header of the enclosing packet
+	CsumCRC32                  // IEEE 802.3 CRC-32 of Buf
+	CsumCRC16                  // CRC-16 (polynomial 0x8005) of Buf
 )
 
 type CsumType struct {
 	IntTypeCommon
-	Kind CsumKind
+	Kind     CsumKind
+	Buf      string       // name of the struct the checksum is calculated over ("parent" for the containing struct)
+	Regions  []CsumRegion // if not empty, the checksum is calculated over these regions instead of Buf
+	Protocol uintptr      // protocol number used in the pseudo-header for CsumPseudo
+}
+
+// CsumRegion is a range of fields [First, Last] of the struct that contains the checksum field.
+// The checksum field itself is skipped if it falls into the range.
+type CsumRegion struct {
+	First string
+	Last  string
 }
 
 type VmaType struct {
@@ -234,6 +322,7 @@ const (
 	Text_x86_32
 	Text_x86_64
 	Text_arm64
+	Text_bpf
 )
 
 type BufferType struct {
@@ -242,11 +331,18 @@ type BufferType struct {
 	RangeBegin uintptr  // for BufferBlobRange kind
 	RangeEnd   uintptr  // for BufferBlobRange kind
 	Text       TextKind // for BufferText
-	SubKind    string
+	SubKind    string   // string flags name for BufferString, path pool name for BufferFilename
 	Values     []string // possible values for BufferString kind
 	Length     uintptr  // max string length for BufferString kind
+	Charset    string   // characters of generated BufferString values (empty means any)
+	MinLen     uintptr  // min length of generated BufferString values with Charset (without terminating zero)
+	MaxLen     uintptr  // max length of generated BufferString values with Charset (without terminating zero)
 }
 
+// FilenamePools are names of pools of existing paths (discovered on the target at runtime)
+// that filename arguments can be chosen from (e.g. filename[dev]).
+var FilenamePools = []string{"dev", "sysfs", "proc"}
+
 func (t *BufferType) Varlen() bool {
 	switch t.Kind {
 	case BufferBlobRand:
@@ -295,6 +391,7 @@ type ArrayType struct {
 	Kind       ArrayKind
 	RangeBegin uintptr
 	RangeEnd   uintptr
+	Unordered  bool // order of elements does not matter (e.g. netlink attributes)
 }
 
 func (t *ArrayType) Varlen() bool {
@@ -324,6 +421,27 @@ func (t *ArrayType) Align() uintptr {
 	return t.Type.Align()
 }
 
+// CondType is a struct field that is present only if
+// an earlier field of the same struct has a particular value.
+type CondType struct {
+	TypeCommon
+	Field string  // name of the controlling field
+	Value uintptr // the field is present iff the controlling field is equal to Value
+	Type  Type
+}
+
+func (t *CondType) Varlen() bool {
+	return true
+}
+
+func (t *CondType) Size() uintptr {
+	panic(fmt.Sprintf("conditional field size is not statically known: %v", t.Name()))
+}
+
+func (t *CondType) Align() uintptr {
+	return t.Type.Align()
+}
+
 type PtrType struct {
 	TypeCommon
 	Type Type
@@ -428,16 +546,27 @@ func (t *UnionType) Align() uintptr {
 	return align
 }
 
-var ctors = make(map[string][]*Call)
+var (
+	ctors       = make(map[string][]*Call)
+	destructors = make(map[string]bool)
+)
 
 // ResourceConstructors returns a list of calls that can create a resource of the given kind.
 func ResourceConstructors(name string) []*Call {
 	return ctors[name]
 }
 
+// IsDestructor returns true if the call is a destructor of some resource.
+func IsDestructor(c *Call) bool {
+	return destructors[c.Name]
+}
+
 func initResources() {
 	for name, res := range Resources {
 		ctors[name] = resourceCtors(res.Kind, false)
+		if res.Destructor != "" {
+			destructors[res.Destructor] = true
+		}
 	}
 }
 
@@ -567,6 +696,8 @@ func ForeachType(meta *Call, f func(Type)) {
 			rec(a.Type)
 		case *ArrayType:
 			rec(a.Type)
+		case *CondType:
+			rec(a.Type)
 		case *StructType:
 			if seen[a] {
 				return // prune recursion via pointers to structs/unions
@@ -584,7 +715,7 @@ func ForeachType(meta *Call, f func(Type)) {
 				rec(opt)
 			}
 		case *ResourceType, *BufferType, *VmaType, *LenType,
-			*FlagsType, *ConstType, *IntType, *ProcType, *CsumType:
+			*FlagsType, *ConstType, *IntType, *ProcType, *CsumType, *VarintType:
 		default:
 			panic("unknown type")
 		}
@@ -0,0 +1,180 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	. "github.com/google/syzkaller/sysparser"
+)
+
+// evalIoctls computes values of ioctl command macros used in desc for arch
+// and adds them to consts, so that they can be looked up as named constants.
+// Commands that refer to constants missing on the arch are left undefined.
+func evalIoctls(arch *Arch, desc *Description, consts map[string]uint64) {
+	enc, ok := IoctlEncodings[arch.Name]
+	if !ok {
+		failf("no ioctl encoding for %v", arch.Name)
+	}
+	for _, vals := range desc.Flags {
+		for _, v := range vals {
+			if !IsIoctl(v) {
+				continue
+			}
+			if _, ok := consts[v]; ok {
+				continue
+			}
+			c, err := ParseIoctl(v)
+			if err != nil {
+				failf("%v", err)
+			}
+			typ, ok1 := IoctlOperand(c.Type, consts)
+			nr, ok2 := IoctlOperand(c.Nr, consts)
+			if !ok1 || !ok2 {
+				continue
+			}
+			if typ >= 1<<8 || nr >= 1<<8 {
+				failf("ioctl command %v: type and nr must fit into 8 bits", v)
+			}
+			var size uint64
+			if c.Dir != "" {
+				size = ioctlSize(c.Size, desc, consts)
+				if size >= 1<<enc.SizeBits {
+					failf("ioctl command %v: argument size %v does not fit into %v bits", v, size, enc.SizeBits)
+				}
+			}
+			consts[v] = enc.Command(c, typ, nr, size)
+		}
+	}
+}
+
+func ioctlSize(s string, desc *Description, consts map[string]uint64) uint64 {
+	if s[0] >= '0' && s[0] <= '9' {
+		v, err := strconv.ParseUint(s, 0, 64)
+		if err != nil {
+			failf("bad ioctl argument size %v", s)
+		}
+		return v
+	}
+	size, _ := typeSize([]string{s}, desc, consts)
+	return size
+}
+
+var intTypeRe = regexp.MustCompile("^int([0-9]+|ptr)(be)?$")
+
+// typeSize returns size and alignment of type typ (type name followed by options).
+// Only statically sized types without bitfields are supported, because
+// this is what ioctl arguments are.
+func typeSize(typ []string, desc *Description, consts map[string]uint64) (uint64, uint64) {
+	name, a := typ[0], typ[1:]
+	if len(a) != 0 && a[len(a)-1] == "opt" {
+		a = a[:len(a)-1]
+	}
+	intSize := func(t string) (uint64, uint64) {
+		if !intTypeRe.MatchString(t) {
+			failf("can't compute size of %v: bad int type %v", typ, t)
+		}
+		size, _, _ := decodeIntType(t)
+		return size, size
+	}
+	if intTypeRe.MatchString(name) {
+		return intSize(name)
+	}
+	if inner, ok := desc.Unnamed[name]; ok {
+		return typeSize(inner, desc, consts)
+	}
+	if res, ok := desc.Resources[name]; ok {
+		return typeSize([]string{res.Base}, desc, consts)
+	}
+	if str, ok := desc.Structs[name]; ok {
+		return structSize(str, desc, consts)
+	}
+	switch name {
+	case "ptr", "buffer", "vma":
+		return ptrSize, ptrSize
+	case "pad":
+		if len(a) == 1 {
+			n, err := strconv.ParseUint(a[0], 0, 64)
+			if err == nil {
+				return n, 1
+			}
+		}
+	case "fileoff", "proc":
+		if len(a) == 0 {
+			return ptrSize, ptrSize
+		}
+		return intSize(a[0])
+	case "const", "flags", "len", "bytesize", "bytesize2", "bytesize4", "bytesize8", "lenexpr":
+		if len(a) < 2 {
+			return ptrSize, ptrSize
+		}
+		return intSize(a[1])
+	case "csum":
+		return intSize(a[len(a)-1])
+	case "array":
+		if len(a) == 2 {
+			begin, end := parseRange(a[1], consts)
+			if begin == end {
+				n, err := strconv.ParseUint(begin, 0, 64)
+				if err == nil {
+					size, align := typeSize(a[:1], desc, consts)
+					return size * n, align
+				}
+			}
+		}
+	}
+	failf("can't compute size of %v: type is not statically sized or not supported", typ)
+	return 0, 0
+}
+
+func structSize(str Struct, desc *Description, consts map[string]uint64) (uint64, uint64) {
+	if str.Varlen {
+		failf("can't compute size of %v: union is variable length", str.Name)
+	}
+	var size, align uint64
+	for _, f := range str.Flds {
+		if f[1] == "align" && len(f) == 3 {
+			a, err := strconv.ParseUint(f[2], 0, 64)
+			if err != nil || a == 0 {
+				failf("bad alignment of %v.%v: %v", str.Name, f[0], f[2])
+			}
+			if !str.Packed && align < a {
+				align = a
+			}
+			if !str.IsUnion {
+				size = (size + a - 1) / a * a
+			}
+			continue
+		}
+		for _, t := range f[1:] {
+			if strings.HasPrefix(t, "int") && strings.Contains(t, ":") {
+				failf("can't compute size of %v: bitfields are not supported", str.Name)
+			}
+		}
+		fsize, falign := typeSize(f[1:], desc, consts)
+		if str.Packed {
+			falign = 1
+		}
+		if align < falign {
+			align = falign
+		}
+		if str.IsUnion {
+			if size < fsize {
+				size = fsize
+			}
+			continue
+		}
+		size = (size + falign - 1) / falign * falign
+		size += fsize
+	}
+	if str.Align != 0 {
+		align = uint64(str.Align)
+	}
+	if align != 0 {
+		size = (size + align - 1) / align * align
+	}
+	return size, align
+}
@@ -0,0 +1,105 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	. "github.com/google/syzkaller/sysparser"
+)
+
+func TestEvalIoctls(t *testing.T) {
+	// Expected values are from kernel headers of the corresponding arches,
+	// 0 means that the command is not valid on the arch.
+	tests := []struct {
+		cmd     string
+		generic uint64 // amd64 and arm64
+		powerpc uint64 // ppc64le
+	}{
+		{"_IO(KVMIO,0x01)", 0xae01, 0x2000ae01},                                  // KVM_CREATE_VM
+		{"_IOR('T',0x30,int32)", 0x80045430, 0x40045430},                         // TIOCGPTN
+		{"_IOW('T',202,int32)", 0x400454ca, 0x800454ca},                          // TUNSETIFF
+		{"_IOR(0x12,114,intptr)", 0x80081272, 0x40081272},                        // BLKGETSIZE64
+		{"_IOWR(DRM_IOCTL_BASE,0x00,drm_version)", 0xc0406400, 0xc0406400},       // DRM_IOCTL_VERSION
+		{"_IOW(KVMIO,0x46,kvm_userspace_memory_region)", 0x4020ae46, 0x8020ae46}, // KVM_SET_USER_MEMORY_REGION
+		{"_IOR('T',1,0x1fff)", 0x9fff5401, 0x5fff5401},                           // max size on powerpc
+		{"_IOR('T',1,0x3fff)", 0xbfff5401, 0},                                    // max size on other arches
+	}
+	structs := map[string]Struct{
+		"drm_version": {Name: "drm_version", Flds: [][]string{
+			{"version_major", "int32"},
+			{"version_minor", "int32"},
+			{"version_patchlevel", "int32"},
+			{"name_len", "len", "name", "intptr"},
+			{"name", "buffer", "out"},
+			{"date_len", "len", "date", "intptr"},
+			{"date", "buffer", "out"},
+			{"desc_len", "len", "desc", "intptr"},
+			{"desc", "buffer", "out"},
+		}},
+		"kvm_userspace_memory_region": {Name: "kvm_userspace_memory_region", Flds: [][]string{
+			{"slot", "int32"},
+			{"flags", "int32"},
+			{"paddr", "int64"},
+			{"size", "len", "addr", "int64"},
+			{"addr", "vma"},
+		}},
+	}
+	for _, arch := range archs {
+		desc := &Description{Flags: make(map[string][]string), Structs: structs}
+		wants := make(map[string]uint64)
+		for _, test := range tests {
+			want := test.generic
+			if arch.Name == "ppc64le" {
+				want = test.powerpc
+			}
+			if want != 0 {
+				desc.Flags["flags"] = append(desc.Flags["flags"], test.cmd)
+				wants[test.cmd] = want
+			}
+		}
+		consts := map[string]uint64{"KVMIO": 0xae, "DRM_IOCTL_BASE": 'd'}
+		evalIoctls(arch, desc, consts)
+		for cmd, want := range wants {
+			if got := consts[cmd]; got != want {
+				t.Errorf("%v: %v = 0x%x, want 0x%x", arch.Name, cmd, got, want)
+			}
+		}
+	}
+}
+
+func TestEvalIoctlsMissingConst(t *testing.T) {
+	desc := &Description{Flags: map[string][]string{"flags": {"_IO(KVMIO,0x01)"}}}
+	consts := make(map[string]uint64)
+	evalIoctls(archs[0], desc, consts)
+	if v, ok := consts["_IO(KVMIO,0x01)"]; ok {
+		t.Fatalf("command with undefined type is evaluated to 0x%x", v)
+	}
+}
+
+func TestEvalIoctlsSizeOverflow(t *testing.T) {
+	// Errors terminate the process, so the evaluation is done in a subprocess
+	// (the test binary itself started with SYZ_SYSGEN_IOCTL).
+	if cmd := os.Getenv("SYZ_SYSGEN_IOCTL"); cmd != "" {
+		desc := &Description{Flags: map[string][]string{"flags": {cmd}}}
+		for _, arch := range archs {
+			if arch.Name == "ppc64le" {
+				evalIoctls(arch, desc, make(map[string]uint64))
+			}
+		}
+		return
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestEvalIoctlsSizeOverflow$")
+	cmd.Env = append(os.Environ(), "SYZ_SYSGEN_IOCTL=_IOR('T',1,0x2000)")
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		t.Fatalf("size overflow is not detected:\n%s", out)
+	}
+	if want := "argument size 8192 does not fit into 13 bits"; !strings.Contains(string(out), want) {
+		t.Fatalf("bad error:\n%s\nwant:\n%v", out, want)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	. "github.com/google/syzkaller/sysparser"
+)
+
+// Pseudo-syscall plugins live in pseudoDir, executor/pseudo/syz_foo.h implements syz_foo
+// as a function that accepts as many uintptr_t arguments as the syz_foo descriptions have.
+// Plugins get numbers after the builtin pseudo-syscalls (see syzkalls) and are dispatched
+// from execute_pseudo_syscall in the generated executor/pseudo.h.
+const (
+	pseudoDir     = "executor/pseudo"
+	pseudoBaseNR  = 1001000
+	maxPseudoArgs = 9
+)
+
+type PseudoCallData struct {
+	Name string
+	Args string
+	Code string
+}
+
+// readPseudoCalls reads pseudo-syscall plugins and registers them in syzkalls.
+func readPseudoCalls(syscalls []Syscall) []PseudoCallData {
+	files, err := filepath.Glob(filepath.Join(pseudoDir, "*.h"))
+	if err != nil {
+		failf("failed to find pseudo-syscall plugins: %v", err)
+	}
+	sort.Strings(files)
+	nargs := make(map[string]int)
+	for _, c := range syscalls {
+		if nargs[c.CallName] < len(c.Args) {
+			nargs[c.CallName] = len(c.Args)
+		}
+	}
+	var calls []PseudoCallData
+	for i, f := range files {
+		name := strings.TrimSuffix(filepath.Base(f), ".h")
+		if !strings.HasPrefix(name, "syz_") {
+			failf("pseudo-syscall plugin %v: name must start with syz_", f)
+		}
+		if _, ok := syzkalls[name]; ok {
+			failf("pseudo-syscall plugin %v: %v is a builtin pseudo-syscall", f, name)
+		}
+		n, ok := nargs[name]
+		if !ok {
+			failf("pseudo-syscall plugin %v: no descriptions for %v", f, name)
+		}
+		if n > maxPseudoArgs {
+			failf("pseudo-syscall plugin %v: %v has %v args, executor supports at most %v", f, name, n, maxPseudoArgs)
+		}
+		code, err := ioutil.ReadFile(f)
+		if err != nil {
+			failf("failed to read pseudo-syscall plugin: %v", err)
+		}
+		var args []string
+		for a := 0; a < n; a++ {
+			args = append(args, fmt.Sprintf("a%v", a))
+		}
+		syzkalls[name] = pseudoBaseNR + uint64(i)
+		calls = append(calls, PseudoCallData{name, strings.Join(args, ", "), string(code)})
+	}
+	for _, c := range syscalls {
+		if _, ok := syzkalls[c.CallName]; strings.HasPrefix(c.CallName, "syz_") && !ok {
+			failf("pseudo-syscall %v is neither builtin nor implemented in %v", c.Name, pseudoDir)
+		}
+	}
+	return calls
+}
+
+func generatePseudoCalls(calls []PseudoCallData) {
+	hdrcode := "executor/pseudo.h"
+	logf(1, "Generate header with pseudo-syscall plugins in %v", hdrcode)
+	buf := new(bytes.Buffer)
+	if err := pseudoTempl.Execute(buf, calls); err != nil {
+		failf("failed to execute pseudo-syscalls template: %v", err)
+	}
+	writeFile(hdrcode, buf.Bytes())
+}
+
+var pseudoTempl = template.Must(template.New("").Parse(
+	`// AUTOGENERATED FILE
+
+{{range $c := $}}#ifdef __NR_{{$c.Name}}
+{{$c.Code}}
+#endif
+
+{{end}}static uintptr_t execute_pseudo_syscall(int nr, uintptr_t a0, uintptr_t a1, uintptr_t a2, uintptr_t a3, uintptr_t a4, uintptr_t a5, uintptr_t a6, uintptr_t a7, uintptr_t a8)
+{
+	switch (nr) {
+{{range $c := $}}#ifdef __NR_{{$c.Name}}
+	case __NR_{{$c.Name}}:
+		return {{$c.Name}}({{$c.Args}});
+#endif
+{{end}}	}
+	return syscall(nr, a0, a1, a2, a3, a4, a5);
+}
+`))
@@ -23,13 +23,17 @@ var archs = []*Arch{
 }
 
 var syzkalls = map[string]uint64{
-	"syz_test":          1000001,
-	"syz_open_dev":      1000002,
-	"syz_open_pts":      1000003,
-	"syz_fuse_mount":    1000004,
-	"syz_fuseblk_mount": 1000005,
-	"syz_emit_ethernet": 1000006,
-	"syz_kvm_setup_cpu": 1000007,
+	"syz_test":               1000001,
+	"syz_open_dev":           1000002,
+	"syz_open_pts":           1000003,
+	"syz_fuse_mount":         1000004,
+	"syz_fuseblk_mount":      1000005,
+	"syz_emit_ethernet":      1000006,
+	"syz_kvm_setup_cpu":      1000007,
+	"syz_usb_connect":        1000008,
+	"syz_usb_disconnect":     1000009,
+	"syz_binder_transaction": 1000010,
+	"syz_mount_image":        1000011,
 }
 
 func generateExecutorSyscalls(syscalls []Syscall, consts map[string]map[string]uint64) {
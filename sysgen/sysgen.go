@@ -4,12 +4,12 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
 	"flag"
 	"fmt"
 	"go/format"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -17,6 +17,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/google/syzkaller/hash"
 	. "github.com/google/syzkaller/sysparser"
 )
 
@@ -35,31 +36,40 @@ func main() {
 	if err != nil {
 		failf("failed to find input files: %v", err)
 	}
-	var r io.Reader = bytes.NewReader(nil)
-	for _, f := range inputFiles {
-		inf, err := os.Open(f)
-		logf(1, "Load descriptions from file %v", f)
+	logf(1, "Parse system call descriptions")
+	desc := ParseFiles(inputFiles)
+
+	// Revision covers included files as well.
+	var data []byte
+	for _, f := range desc.Files {
+		data1, err := ioutil.ReadFile(f)
 		if err != nil {
-			failf("failed to open input file: %v", err)
+			failf("failed to read input file: %v", err)
 		}
-		defer inf.Close()
-		r = io.MultiReader(r, bufio.NewReader(inf))
+		data = append(data, data1...)
 	}
 
-	logf(1, "Parse system call descriptions")
-	desc := Parse(r)
+	pseudoCalls := readPseudoCalls(desc.Syscalls)
 
 	consts := make(map[string]map[string]uint64)
 	for _, arch := range archs {
 		logf(0, "generating %v...", arch.Name)
-		consts[arch.Name] = readConsts(arch.Name)
+		archConsts, err := ReadConsts("sys", arch.Name)
+		if err != nil {
+			failf("%v", err)
+		}
+		for name, nr := range syzkalls {
+			archConsts["__NR_"+name] = nr
+		}
+		consts[arch.Name] = archConsts
+		evalIoctls(arch, desc, consts[arch.Name])
 
 		unsupported := make(map[string]bool)
 		archFlags := make(map[string][]string)
 		for f, vals := range desc.Flags {
 			var archVals []string
 			for _, val := range vals {
-				if isIdentifier(val) {
+				if isIdentifier(val) || IsIoctl(val) {
 					if v, ok := consts[arch.Name][val]; ok {
 						archVals = append(archVals, fmt.Sprint(v))
 					} else {
@@ -80,54 +90,29 @@ func main() {
 		out := new(bytes.Buffer)
 		archDesc := *desc
 		archDesc.Flags = archFlags
-		generate(arch.Name, &archDesc, consts[arch.Name], out)
+		revision := descRevision(data, consts[arch.Name])
+		generate(arch.Name, &archDesc, consts[arch.Name], revision, out)
 		writeSource(sysFile, out.Bytes())
 		logf(0, "")
 	}
 
 	generateExecutorSyscalls(desc.Syscalls, consts)
+	generatePseudoCalls(pseudoCalls)
 }
 
-func readConsts(arch string) map[string]uint64 {
-	constFiles, err := filepath.Glob("sys/*_" + arch + ".const")
-	if err != nil {
-		failf("failed to find const files: %v", err)
-	}
-	consts := make(map[string]uint64)
-	for _, fname := range constFiles {
-		f, err := os.Open(fname)
-		if err != nil {
-			failf("failed to open const file: %v", err)
-		}
-		defer f.Close()
-		s := bufio.NewScanner(f)
-		for s.Scan() {
-			line := s.Text()
-			if line == "" || line[0] == '#' {
-				continue
-			}
-			eq := strings.IndexByte(line, '=')
-			if eq == -1 {
-				failf("malformed const file %v: no '=' in '%v'", fname, line)
-			}
-			name := strings.TrimSpace(line[:eq])
-			val, err := strconv.ParseUint(strings.TrimSpace(line[eq+1:]), 0, 64)
-			if err != nil {
-				failf("malformed const file %v: bad value in '%v'", fname, line)
-			}
-			if old, ok := consts[name]; ok && old != val {
-				failf("const %v has different values for %v: %v vs %v", name, arch, old, val)
-			}
-			consts[name] = val
-		}
-		if err := s.Err(); err != nil {
-			failf("failed to read const file: %v", err)
-		}
+// descRevision returns a hash of the descriptions and consts for an arch,
+// it changes whenever generated programs can change.
+func descRevision(data []byte, consts map[string]uint64) string {
+	var constArr []NameValue
+	for name, val := range consts {
+		constArr = append(constArr, NameValue{name, val})
 	}
-	for name, nr := range syzkalls {
-		consts["__NR_"+name] = nr
+	sort.Sort(NameValueArray(constArr))
+	buf := bytes.NewBuffer(append([]byte{}, data...))
+	for _, nv := range constArr {
+		fmt.Fprintf(buf, "%v = %v\n", nv.name, nv.val)
 	}
-	return consts
+	return hash.String(buf.Bytes())
 }
 
 var skipCurrentSyscall string
@@ -138,11 +123,13 @@ func skipSyscall(why string) {
 	}
 }
 
-func generate(arch string, desc *Description, consts map[string]uint64, out io.Writer) {
+func generate(arch string, desc *Description, consts map[string]uint64, revision string, out io.Writer) {
 	unsupported := make(map[string]bool)
 
 	fmt.Fprintf(out, "// AUTOGENERATED FILE\n")
 	fmt.Fprintf(out, "package sys\n\n")
+	fmt.Fprintf(out, "// Revision is a hash of the syscall descriptions.\n")
+	fmt.Fprintf(out, "const Revision = \"%v\"\n\n", revision)
 
 	generateResources(desc, consts, out)
 	generateStructs(desc, consts, out)
@@ -183,6 +170,9 @@ func generate(arch string, desc *Description, consts map[string]uint64, out io.W
 
 	var constArr []NameValue
 	for name, val := range consts {
+		if !isIdentifier(name) {
+			continue // computed ioctl commands
+		}
 		constArr = append(constArr, NameValue{name, val})
 	}
 	sort.Sort(NameValueArray(constArr))
@@ -207,8 +197,13 @@ func generateResources(desc *Description, consts map[string]uint64, out io.Write
 		name := res.Name
 		kind := []string{name}
 		var values []string
+		destructor := ""
 	loop:
 		for {
+			if destructor == "" {
+				// Derived resources inherit destructor of the parent resource.
+				destructor = res.Destructor
+			}
 			var values1 []string
 			for _, v := range res.Values {
 				if v1, ok := consts[v]; ok {
@@ -249,11 +244,35 @@ func generateResources(desc *Description, consts map[string]uint64, out io.Write
 			}
 			fmt.Fprintf(out, "%v", v)
 		}
-		fmt.Fprintf(out, "}},\n")
+		fmt.Fprintf(out, "}")
+		if destructor != "" {
+			checkDestructor(desc, name, kind, destructor)
+			fmt.Fprintf(out, ", Destructor: \"%v\"", destructor)
+		}
+		fmt.Fprintf(out, "},\n")
 	}
 	fmt.Fprintf(out, "}\n")
 }
 
+// checkDestructor checks that the destructor call exists and accepts the resource as the first argument.
+func checkDestructor(desc *Description, name string, kind []string, destructor string) {
+	for _, c := range desc.Syscalls {
+		if c.Name != destructor {
+			continue
+		}
+		if len(c.Args) == 0 || len(c.Args[0]) != 2 {
+			failf("destructor '%v' of resource '%v' must accept the resource as the first argument", destructor, name)
+		}
+		for _, k := range kind {
+			if c.Args[0][1] == k {
+				return
+			}
+		}
+		failf("destructor '%v' of resource '%v' accepts '%v' as the first argument", destructor, name, c.Args[0][1])
+	}
+	failf("destructor '%v' of resource '%v' is not defined", destructor, name)
+}
+
 type structKey struct {
 	name  string
 	field string
@@ -289,7 +308,13 @@ func generateStructFields(str Struct, key structKey, desc *Description, consts m
 		fields = "Options"
 	}
 	fmt.Fprintf(out, "func() { s := Structs[\"%v\"].(*%v)\n", key, typ)
-	for _, a := range str.Flds {
+	for i, a := range str.Flds {
+		if a[1] == "cond" {
+			checkCondField(str, i)
+		}
+		if a[1] == "csum" {
+			checkCsumField(str, i)
+		}
 		fmt.Fprintf(out, "s.%v = append(s.%v, ", fields, fields)
 		generateArg(str.Name, a[0], a[1], key.dir, a[2:], desc, consts, false, true, out)
 		fmt.Fprintf(out, ")\n")
@@ -297,6 +322,77 @@ func generateStructFields(str Struct, key structKey, desc *Description, consts m
 	fmt.Fprintf(out, "}()\n")
 }
 
+// checkCondField checks that i-th field of str is a valid conditional field:
+// it must be a struct field and refer to an earlier int/flags/const field.
+func checkCondField(str Struct, i int) {
+	fld := str.Flds[i]
+	if str.IsUnion {
+		failf("union %v contains conditional field %v", str.Name, fld[0])
+	}
+	if len(fld) < 3 {
+		return // reported by generateArg
+	}
+	for _, f := range str.Flds[:i] {
+		if f[0] != fld[2] {
+			continue
+		}
+		if f[1] != "flags" && f[1] != "const" && !strings.HasPrefix(f[1], "int") {
+			failf("conditional field %v in struct %v refers to field %v of type %v, want int/flags/const",
+				fld[0], str.Name, f[0], f[1])
+		}
+		return
+	}
+	failf("conditional field %v in struct %v refers to unknown or subsequent field %v", fld[0], str.Name, fld[2])
+}
+
+// parseCsumRegions parses quoted list of checksum regions (e.g. "f0:f2, f4")
+// into pairs of the first and the last field names.
+func parseCsumRegions(s string) ([][2]string, error) {
+	var regions [][2]string
+	for _, r := range strings.Split(strings.Trim(s, "\""), ",") {
+		r = strings.TrimSpace(r)
+		first, last := r, r
+		if colon := strings.IndexByte(r, ':'); colon != -1 {
+			first, last = strings.TrimSpace(r[:colon]), strings.TrimSpace(r[colon+1:])
+		}
+		if first == "" || last == "" || !isIdentifier(first) || !isIdentifier(last) {
+			return nil, fmt.Errorf("bad region '%v' in %v", r, s)
+		}
+		regions = append(regions, [2]string{first, last})
+	}
+	return regions, nil
+}
+
+// checkCsumField checks that regions of i-th field of str (if it's a checksum over regions)
+// refer to fields of str and the first field of every region precedes the last one.
+func checkCsumField(str Struct, i int) {
+	fld := str.Flds[i]
+	if len(fld) < 3 || fld[2][0] != '"' {
+		return
+	}
+	if str.IsUnion {
+		failf("union %v contains checksum %v over field regions", str.Name, fld[0])
+	}
+	regions, err := parseCsumRegions(fld[2])
+	if err != nil {
+		return // reported by generateArg
+	}
+	index := func(name string) int {
+		for j, f := range str.Flds {
+			if f[0] == name {
+				return j
+			}
+		}
+		failf("checksum %v in struct %v refers to unknown field %v", fld[0], str.Name, name)
+		return -1
+	}
+	for _, r := range regions {
+		if index(r[0]) > index(r[1]) {
+			failf("checksum %v in struct %v: region %v:%v is reversed", fld[0], str.Name, r[0], r[1])
+		}
+	}
+}
+
 func generateStructs(desc *Description, consts map[string]uint64, out io.Writer) {
 	// Struct fields can refer to other structs. Go compiler won't like if
 	// we refer to Structs map during Structs map initialization. So we do
@@ -335,6 +431,22 @@ func generateStructs(desc *Description, consts map[string]uint64, out io.Writer)
 	fmt.Fprintf(out, "}\n")
 }
 
+const defaultMaxStrchars = 32
+
+// filenamePools must match sys.FilenamePools.
+var filenamePools = []string{"dev", "sysfs", "proc"}
+
+// charsets are named character sets for strchars type.
+var charsets = map[string]string{
+	"alpha":  "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	"digit":  "0123456789",
+	"xdigit": "0123456789abcdef",
+	"alnum":  "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789",
+	"ident":  "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_",
+	"path":   "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-./",
+	"print":  " !\"#$%&'()*+,-./0123456789:;<=>?@ABCDEFGHIJKLMNOPQRSTUVWXYZ[\\]^_`abcdefghijklmnopqrstuvwxyz{|}~",
+}
+
 func parseRange(buffer string, consts map[string]uint64) (string, string) {
 	lookupConst := func(name string) string {
 		if v, ok := consts[name]; ok {
@@ -377,9 +489,14 @@ func generateArg(
 	common := func() string {
 		return fmt.Sprintf("TypeCommon: TypeCommon{TypeName: \"%v\", FldName: %v, ArgDir: %v, IsOptional: %v}", typ, name, fmtDir(dir), opt)
 	}
-	intCommon := func(typeSize uint64, bigEndian bool, bitfieldLen uint64) string {
-		// BitfieldOff and BitfieldLst will be filled in in initAlign().
-		return fmt.Sprintf("IntTypeCommon: IntTypeCommon{%v, TypeSize: %v, BigEndian: %v, BitfieldLen: %v}", common(), typeSize, bigEndian, bitfieldLen)
+	intCommon := func(typeSize uint64, bigEndian bool, bf bitfield) string {
+		// BitfieldLst and BitfieldOff (if not fixed) will be filled in in initAlign().
+		fixed := ""
+		if bf.fixed {
+			fixed = fmt.Sprintf(", BitfieldOff: %v, BitfieldFixed: true", bf.off)
+		}
+		return fmt.Sprintf("IntTypeCommon: IntTypeCommon{%v, TypeSize: %v, BigEndian: %v, BitfieldLen: %v%v}",
+			common(), typeSize, bigEndian, bf.len, fixed)
 	}
 	canBeArg := false
 	switch typ {
@@ -387,18 +504,29 @@ func generateArg(
 		canBeArg = true
 		size := uint64(ptrSize)
 		bigEndian := false
-		bitfieldLen := uint64(0)
+		var bf bitfield
 		if isField {
 			if want := 1; len(a) != want {
 				failf("wrong number of arguments for %v arg %v, want %v, got %v", typ, name, want, len(a))
 			}
-			size, bigEndian, bitfieldLen = decodeIntType(a[0])
+			size, bigEndian, bf = decodeIntType(a[0])
 		} else {
 			if want := 0; len(a) != want {
 				failf("wrong number of arguments for %v arg %v, want %v, got %v", typ, name, want, len(a))
 			}
 		}
-		fmt.Fprintf(out, "&IntType{%v, Kind: IntFileoff}", intCommon(size, bigEndian, bitfieldLen))
+		fmt.Fprintf(out, "&IntType{%v, Kind: IntFileoff}", intCommon(size, bigEndian, bf))
+	case "intvar":
+		var begin, end string
+		switch len(a) {
+		case 0:
+			begin, end = "0", "0"
+		case 1:
+			begin, end = parseRange(a[0], consts)
+		default:
+			failf("wrong number of arguments for %v arg %v, want 0 or 1, got %v", typ, name, len(a))
+		}
+		fmt.Fprintf(out, "&VarintType{%v, RangeBegin: %v, RangeEnd: %v}", common(), begin, end)
 	case "buffer":
 		canBeArg = true
 		if want := 1; len(a) != want {
@@ -484,12 +612,12 @@ func generateArg(
 		canBeArg = true
 		size := uint64(ptrSize)
 		bigEndian := false
-		bitfieldLen := uint64(0)
+		var bf bitfield
 		if isField {
 			if want := 2; len(a) != want {
 				failf("wrong number of arguments for %v arg %v, want %v, got %v", typ, name, want, len(a))
 			}
-			size, bigEndian, bitfieldLen = decodeIntType(a[1])
+			size, bigEndian, bf = decodeIntType(a[1])
 		} else {
 			if want := 1; len(a) != want {
 				failf("wrong number of arguments for %v arg %v, want %v, got %v", typ, name, want, len(a))
@@ -499,30 +627,104 @@ func generateArg(
 		if typ != "len" {
 			byteSize = decodeByteSizeType(typ)
 		}
-		fmt.Fprintf(out, "&LenType{%v, Buf: \"%v\", ByteSize: %v}", intCommon(size, bigEndian, bitfieldLen), a[0], byteSize)
+		fmt.Fprintf(out, "&LenType{%v, Buf: \"%v\", ByteSize: %v}", intCommon(size, bigEndian, bf), a[0], byteSize)
+	case "lenexpr":
+		// lenexpr["len(hdr)+len(data)", int16]
+		canBeArg = true
+		size := uint64(ptrSize)
+		bigEndian := false
+		var bf bitfield
+		if isField {
+			if want := 2; len(a) != want {
+				failf("wrong number of arguments for %v arg %v, want %v, got %v", typ, name, want, len(a))
+			}
+			size, bigEndian, bf = decodeIntType(a[1])
+		} else {
+			if want := 1; len(a) != want {
+				failf("wrong number of arguments for %v arg %v, want %v, got %v", typ, name, want, len(a))
+			}
+		}
+		if len(a[0]) < 2 || a[0][0] != '"' || a[0][len(a[0])-1] != '"' {
+			failf("expression for %v arg %v must be quoted, got %v", typ, name, a[0])
+		}
+		e, err := ParseExpr(a[0][1 : len(a[0])-1])
+		if err != nil {
+			failf("%v arg %v: %v", typ, name, err)
+		}
+		fmt.Fprintf(out, "&LenType{%v, Expr: %v}", intCommon(size, bigEndian, bf), generateExpr(e, isField, consts))
 	case "csum":
-		if want := 2; len(a) != want {
-			failf("wrong number of arguments for %v arg %v, want %v, got %v", typ, name, want, len(a))
+		if len(a) < 3 {
+			failf("wrong number of arguments for %v arg %v, want at least 3, got %v", typ, name, len(a))
 		}
-		size, bigEndian, bitfieldLen := decodeIntType(a[1])
 		var kind string
-		switch a[0] {
-		case "ipv4":
-			kind = "CsumIPv4"
+		protocol := "0"
+		switch a[1] {
+		case "inet":
+			// csum[buf, inet, int16be]
+			if want := 3; len(a) != want {
+				failf("wrong number of arguments for %v arg %v, want %v, got %v", typ, name, want, len(a))
+			}
+			kind = "CsumInet"
+		case "crc32", "crc16":
+			// csum[buf, crc32, int32]
+			if want := 3; len(a) != want {
+				failf("wrong number of arguments for %v arg %v, want %v, got %v", typ, name, want, len(a))
+			}
+			kind = "CsumCRC32"
+			if a[1] == "crc16" {
+				kind = "CsumCRC16"
+			}
+		case "pseudo":
+			// csum[buf, pseudo, proto, int16be]
+			if want := 4; len(a) != want {
+				failf("wrong number of arguments for %v arg %v, want %v, got %v", typ, name, want, len(a))
+			}
+			kind = "CsumPseudo"
+			protocol = a[2]
+			if v, ok := consts[protocol]; ok {
+				protocol = fmt.Sprint(v)
+			} else if isIdentifier(protocol) {
+				protocol = "0"
+				skipSyscall(fmt.Sprintf("missing const %v", a[2]))
+			}
 		default:
-			failf("unknown checksum kind '%v'", a[0])
+			failf("unknown checksum kind '%v'", a[1])
+		}
+		size, bigEndian, bf := decodeIntType(a[len(a)-1])
+		wantSize := uint64(2)
+		if kind == "CsumCRC32" {
+			wantSize = 4
+		}
+		if size != wantSize || bf.len != 0 {
+			failf("bad underlying type %v for %v checksum %v, want %v-byte int", a[len(a)-1], a[1], name, wantSize)
 		}
-		fmt.Fprintf(out, "&CsumType{%v, Kind: %v}", intCommon(size, bigEndian, bitfieldLen), kind)
+		buf, regions := a[0], "nil"
+		if buf[0] == '"' {
+			// csum["f0:f2, f4", inet, int16be]
+			if !isField {
+				failf("checksum %v over field regions is not a struct field", name)
+			}
+			rr, err := parseCsumRegions(buf)
+			if err != nil {
+				failf("checksum %v: %v", name, err)
+			}
+			buf, regions = "", "[]CsumRegion{"
+			for _, r := range rr {
+				regions += fmt.Sprintf("{%q, %q}, ", r[0], r[1])
+			}
+			regions += "}"
+		}
+		fmt.Fprintf(out, "&CsumType{%v, Kind: %v, Buf: \"%v\", Regions: %v, Protocol: %v}", intCommon(size, bigEndian, bf), kind, buf, regions, protocol)
 	case "flags":
 		canBeArg = true
 		size := uint64(ptrSize)
 		bigEndian := false
-		bitfieldLen := uint64(0)
+		var bf bitfield
 		if isField {
 			if want := 2; len(a) != want {
 				failf("wrong number of arguments for %v arg %v, want %v, got %v", typ, name, want, len(a))
 			}
-			size, bigEndian, bitfieldLen = decodeIntType(a[1])
+			size, bigEndian, bf = decodeIntType(a[1])
 		} else {
 			if want := 1; len(a) != want {
 				failf("wrong number of arguments for %v arg %v, want %v, got %v", typ, name, want, len(a))
@@ -533,20 +735,20 @@ func generateArg(
 			failf("unknown flag %v", a[0])
 		}
 		if len(vals) == 0 {
-			fmt.Fprintf(out, "&IntType{%v}", intCommon(size, bigEndian, bitfieldLen))
+			fmt.Fprintf(out, "&IntType{%v}", intCommon(size, bigEndian, bf))
 		} else {
-			fmt.Fprintf(out, "&FlagsType{%v, Vals: []uintptr{%v}}", intCommon(size, bigEndian, bitfieldLen), strings.Join(vals, ","))
+			fmt.Fprintf(out, "&FlagsType{%v, Vals: []uintptr{%v}}", intCommon(size, bigEndian, bf), strings.Join(vals, ","))
 		}
 	case "const":
 		canBeArg = true
 		size := uint64(ptrSize)
 		bigEndian := false
-		bitfieldLen := uint64(0)
+		var bf bitfield
 		if isField {
 			if want := 2; len(a) != want {
 				failf("wrong number of arguments for %v arg %v, want %v, got %v", typ, name, want, len(a))
 			}
-			size, bigEndian, bitfieldLen = decodeIntType(a[1])
+			size, bigEndian, bf = decodeIntType(a[1])
 		} else {
 			if want := 1; len(a) != want {
 				failf("wrong number of arguments for %v arg %v, want %v, got %v", typ, name, want, len(a))
@@ -555,25 +757,44 @@ func generateArg(
 		val := a[0]
 		if v, ok := consts[a[0]]; ok {
 			val = fmt.Sprint(v)
-		} else if isIdentifier(a[0]) {
+		} else if isIdentifier(a[0]) || IsIoctl(a[0]) {
 			// This is an identifier for which we don't have a value for this arch.
 			// Skip this syscall on this arch.
 			val = "0"
 			skipSyscall(fmt.Sprintf("missing const %v", a[0]))
 		}
-		fmt.Fprintf(out, "&ConstType{%v, Val: uintptr(%v)}", intCommon(size, bigEndian, bitfieldLen), val)
+		fmt.Fprintf(out, "&ConstType{%v, Val: uintptr(%v)}", intCommon(size, bigEndian, bf), val)
+	case "pad", "align":
+		if !isField {
+			failf("%v %v can be used only as a struct field", name, typ)
+		}
+		if want := 1; len(a) != want {
+			failf("wrong number of arguments for %v arg %v, want %v, got %v", typ, name, want, len(a))
+		}
+		n, err := strconv.ParseUint(a[0], 0, 64)
+		if err != nil || n == 0 {
+			failf("bad %v size for %v: %v", typ, name, a[0])
+		}
+		if typ == "pad" {
+			fmt.Fprintf(out, "&ConstType{%v, IsPad: true, PadZero: true}", intCommon(n, false, bitfield{}))
+		} else {
+			if n&(n-1) != 0 || n > 4096 {
+				failf("bad alignment for %v: %v, must be a sane power of 2", name, n)
+			}
+			fmt.Fprintf(out, "&ConstType{%v, IsPad: true, PadZero: true, PadAlign: %v}", intCommon(0, false, bitfield{}), n)
+		}
 	case "proc":
 		canBeArg = true
 		size := uint64(ptrSize)
 		bigEndian := false
-		bitfieldLen := uint64(0)
+		var bf bitfield
 		var valuesStart string
 		var valuesPerProc string
 		if isField {
 			if want := 3; len(a) != want {
 				failf("wrong number of arguments for %v arg %v, want %v, got %v", typ, name, want, len(a))
 			}
-			size, bigEndian, bitfieldLen = decodeIntType(a[0])
+			size, bigEndian, bf = decodeIntType(a[0])
 			valuesStart = a[1]
 			valuesPerProc = a[2]
 		} else {
@@ -601,25 +822,64 @@ func generateArg(
 		if valuesStartInt+maxPids*valuesPerProcInt >= (1 << (size * 8)) {
 			failf("not enough values starting from '%v' with step '%v' and type size '%v' for 32 procs", valuesStartInt, valuesPerProcInt, size)
 		}
-		fmt.Fprintf(out, "&ProcType{%v, ValuesStart: %v, ValuesPerProc: %v}", intCommon(size, bigEndian, bitfieldLen), valuesStartInt, valuesPerProcInt)
+		fmt.Fprintf(out, "&ProcType{%v, ValuesStart: %v, ValuesPerProc: %v}", intCommon(size, bigEndian, bf), valuesStartInt, valuesPerProcInt)
 	case "signalno":
 		canBeArg = true
 		if want := 0; len(a) != want {
 			failf("wrong number of arguments for %v arg %v, want %v, got %v", typ, name, want, len(a))
 		}
-		fmt.Fprintf(out, "&IntType{%v, Kind: IntSignalno}", intCommon(4, false, 0))
+		fmt.Fprintf(out, "&IntType{%v, Kind: IntSignalno}", intCommon(4, false, bitfield{}))
+	case "strchars":
+		if len(a) != 1 && len(a) != 2 {
+			failf("wrong number of arguments for %v arg %v, want 1 or 2, got %v", typ, name, len(a))
+		}
+		var charset string
+		if a[0][0] == '"' {
+			charset = a[0][1 : len(a[0])-1]
+		} else {
+			var ok bool
+			if charset, ok = charsets[a[0]]; !ok {
+				failf("unknown charset %v for %v arg %v", a[0], typ, name)
+			}
+		}
+		if charset == "" {
+			failf("empty charset for %v arg %v", typ, name)
+		}
+		minLen, maxLen := uint64(0), uint64(defaultMaxStrchars)
+		if len(a) == 2 {
+			begin, end := parseRange(a[1], consts)
+			var err1, err2 error
+			minLen, err1 = strconv.ParseUint(begin, 10, 64)
+			maxLen, err2 = strconv.ParseUint(end, 10, 64)
+			if err1 != nil || err2 != nil || minLen > maxLen {
+				failf("bad length range %v for %v arg %v", a[1], typ, name)
+			}
+		}
+		fmt.Fprintf(out, "&BufferType{%v, Kind: BufferString, Charset: %q, MinLen: %v, MaxLen: %v}",
+			common(), charset, minLen, maxLen)
 	case "filename":
-		if want := 0; len(a) != want {
-			failf("wrong number of arguments for %v arg %v, want %v, got %v", typ, name, want, len(a))
+		if len(a) > 1 {
+			failf("wrong number of arguments for %v arg %v, want 0 or 1, got %v", typ, name, len(a))
+		}
+		pool := ""
+		if len(a) == 1 {
+			pool = a[0]
+			found := false
+			for _, p := range filenamePools {
+				found = found || p == pool
+			}
+			if !found {
+				failf("unknown filename pool %v for %v arg %v (supported: %v)", pool, typ, name, filenamePools)
+			}
 		}
-		fmt.Fprintf(out, "&BufferType{%v, Kind: BufferFilename}", common())
+		fmt.Fprintf(out, "&BufferType{%v, Kind: BufferFilename, SubKind: %q}", common(), pool)
 	case "text":
 		if want := 1; len(a) != want {
 			failf("wrong number of arguments for %v arg %v, want %v, got %v", typ, name, want, len(a))
 		}
 		kind := ""
 		switch a[0] {
-		case "x86_real", "x86_16", "x86_32", "x86_64", "arm64":
+		case "x86_real", "x86_16", "x86_32", "x86_64", "arm64", "bpf":
 			kind = "Text_" + a[0]
 		default:
 			failf("unknown text type %v for %v arg %v", a[0], typ, name)
@@ -629,20 +889,40 @@ func generateArg(
 		if len(a) != 1 && len(a) != 2 {
 			failf("wrong number of arguments for %v arg %v, want 1 or 2, got %v", typ, name, len(a))
 		}
+		unordered := ""
+		if isNlattrSet(a[0], desc) {
+			unordered = ", Unordered: true"
+		}
 		if len(a) == 1 {
 			if a[0] == "int8" {
 				fmt.Fprintf(out, "&BufferType{%v, Kind: BufferBlobRand}", common())
 			} else {
-				fmt.Fprintf(out, "&ArrayType{%v, Type: %v, Kind: ArrayRandLen}", common(), generateType(a[0], dir, desc, consts))
+				fmt.Fprintf(out, "&ArrayType{%v, Type: %v, Kind: ArrayRandLen%v}", common(), generateType(a[0], dir, desc, consts), unordered)
 			}
 		} else {
 			begin, end := parseRange(a[1], consts)
 			if a[0] == "int8" {
 				fmt.Fprintf(out, "&BufferType{%v, Kind: BufferBlobRange, RangeBegin: %v, RangeEnd: %v}", common(), begin, end)
 			} else {
-				fmt.Fprintf(out, "&ArrayType{%v, Type: %v, Kind: ArrayRangeLen, RangeBegin: %v, RangeEnd: %v}", common(), generateType(a[0], dir, desc, consts), begin, end)
+				fmt.Fprintf(out, "&ArrayType{%v, Type: %v, Kind: ArrayRangeLen, RangeBegin: %v, RangeEnd: %v%v}", common(), generateType(a[0], dir, desc, consts), begin, end, unordered)
 			}
 		}
+	case "cond":
+		// cond[field, value, type]
+		if want := 3; len(a) != want {
+			failf("wrong number of arguments for %v arg %v, want %v, got %v", typ, name, want, len(a))
+		}
+		if !isField || parent == "" {
+			failf("%v %v can be only a struct field", name, typ)
+		}
+		val := a[1]
+		if v, ok := consts[val]; ok {
+			val = fmt.Sprint(v)
+		} else if isIdentifier(val) {
+			val = "0"
+			skipSyscall(fmt.Sprintf("missing const %v", a[1]))
+		}
+		fmt.Fprintf(out, "&CondType{%v, Field: \"%v\", Value: uintptr(%v), Type: %v}", common(), a[0], val, generateType(a[2], dir, desc, consts))
 	case "ptr":
 		canBeArg = true
 		if want := 2; len(a) != want {
@@ -651,17 +931,17 @@ func generateArg(
 		dir = "in"
 		fmt.Fprintf(out, "&PtrType{%v, Type: %v}", common(), generateType(a[1], a[0], desc, consts))
 	default:
-		intRegExp := regexp.MustCompile("^int([0-9]+|ptr)(be)?(:[0-9]+)?$")
+		intRegExp := regexp.MustCompile("^int([0-9]+|ptr)(be)?(:[0-9]+(@[0-9]+)?)?$")
 		if intRegExp.MatchString(typ) {
 			canBeArg = true
-			size, bigEndian, bitfieldLen := decodeIntType(typ)
+			size, bigEndian, bf := decodeIntType(typ)
 			switch len(a) {
 			case 0:
-				fmt.Fprintf(out, "&IntType{%v}", intCommon(size, bigEndian, bitfieldLen))
+				fmt.Fprintf(out, "&IntType{%v}", intCommon(size, bigEndian, bf))
 			case 1:
 				begin, end := parseRange(a[0], consts)
 				fmt.Fprintf(out, "&IntType{%v, Kind: IntRange, RangeBegin: %v, RangeEnd: %v}",
-					intCommon(size, bigEndian, bitfieldLen), begin, end)
+					intCommon(size, bigEndian, bf), begin, end)
 			default:
 				failf("wrong number of arguments for %v arg %v, want 0 or 1, got %v", typ, name, len(a))
 			}
@@ -691,6 +971,51 @@ func generateArg(
 	}
 }
 
+// generateExpr generates sys.Expr for the parsed lenexpr expression e.
+// offsetof is allowed only in struct fields.
+func generateExpr(e *Expr, isField bool, consts map[string]uint64) string {
+	switch e.Op {
+	case "const":
+		return fmt.Sprintf("&Expr{Op: ExprConst, Val: %v}", e.Val)
+	case "ident":
+		v, ok := consts[e.Name]
+		if !ok {
+			skipSyscall(fmt.Sprintf("missing const %v", e.Name))
+		}
+		return fmt.Sprintf("&Expr{Op: ExprConst, Val: %v}", v)
+	case "len", "bytesize", "offsetof":
+		if e.Op == "offsetof" && !isField {
+			failf("offsetof(%v) is used outside of a struct", e.Name)
+		}
+		op := map[string]string{"len": "ExprLen", "bytesize": "ExprBytesize", "offsetof": "ExprOffsetof"}[e.Op]
+		return fmt.Sprintf("&Expr{Op: %v, Field: \"%v\"}", op, e.Name)
+	case "+", "-", "*", "/":
+		op := map[string]string{"+": "ExprAdd", "-": "ExprSub", "*": "ExprMul", "/": "ExprDiv"}[e.Op]
+		return fmt.Sprintf("&Expr{Op: %v, Args: []*Expr{%v, %v}}", op, generateExpr(e.Args[0], isField, consts), generateExpr(e.Args[1], isField, consts))
+	default:
+		failf("unknown expression op %v", e.Op)
+		return ""
+	}
+}
+
+// isNlattrSet returns true if typ is a netlink attribute or a union of attributes,
+// kernel does not depend on the order of attributes in arrays of such elements.
+func isNlattrSet(typ string, desc *Description) bool {
+	str, ok := desc.Structs[typ]
+	if !ok {
+		return false
+	}
+	if !str.IsUnion {
+		return str.Nlattr
+	}
+	for _, f := range str.Flds {
+		if len(f) != 2 || !isNlattrSet(f[1], desc) {
+			return false
+		}
+	}
+	return true
+}
+
 func generateType(typ, dir string, desc *Description, consts map[string]uint64) string {
 	buf := new(bytes.Buffer)
 	generateArg("", "", typ, dir, nil, desc, consts, false, true, buf)
@@ -711,16 +1036,32 @@ func fmtDir(s string) string {
 	}
 }
 
-func decodeIntType(typ string) (uint64, bool, uint64) {
+// bitfield describes bitfield part of an int type (e.g. int32:4 or int32:4@12).
+type bitfield struct {
+	len   uint64
+	off   uint64
+	fixed bool // offset is set explicitly with @
+}
+
+func decodeIntType(typ string) (uint64, bool, bitfield) {
 	bigEndian := false
-	bitfieldLen := uint64(0)
+	var bf bitfield
 
 	parts := strings.Split(typ, ":")
 	if len(parts) == 2 {
 		var err error
-		bitfieldLen, err = strconv.ParseUint(parts[1], 10, 64)
+		lenStr := parts[1]
+		if at := strings.IndexByte(lenStr, '@'); at != -1 {
+			bf.off, err = strconv.ParseUint(lenStr[at+1:], 10, 64)
+			if err != nil {
+				failf("failed to parse bitfield offset '%v'", lenStr[at+1:])
+			}
+			bf.fixed = true
+			lenStr = lenStr[:at]
+		}
+		bf.len, err = strconv.ParseUint(lenStr, 10, 64)
 		if err != nil {
-			failf("failed to parse bitfield length '%v'", parts[1])
+			failf("failed to parse bitfield length '%v'", lenStr)
 		}
 		typ = parts[0]
 	}
@@ -740,11 +1081,14 @@ func decodeIntType(typ string) (uint64, bool, uint64) {
 		sz, _ = strconv.ParseInt(typ[3:], 10, 64)
 	}
 
-	if bitfieldLen > uint64(sz) {
-		failf("bitfield of size %v is too large for base type of size %v", bitfieldLen, sz/8)
+	if bf.len > uint64(sz) {
+		failf("bitfield of size %v is too large for base type of size %v", bf.len, sz/8)
+	}
+	if bf.fixed && (bf.len == 0 || bf.off+bf.len > uint64(sz)) {
+		failf("bitfield of size %v at offset %v does not fit into base type of size %v", bf.len, bf.off, sz/8)
 	}
 
-	return uint64(sz / 8), bigEndian, bitfieldLen
+	return uint64(sz / 8), bigEndian, bf
 }
 
 func decodeByteSizeType(typ string) uint8 {
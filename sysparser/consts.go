@@ -0,0 +1,62 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package sysparser
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ReadConsts reads values of named constants for arch from dir/*_arch.const files
+// (generated by syz-extract). Lines have the form "NAME = value", empty lines and
+// lines starting with '#' are ignored.
+func ReadConsts(dir, arch string) (map[string]uint64, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*_"+arch+".const"))
+	if err != nil || len(files) == 0 {
+		return nil, fmt.Errorf("failed to find const files for %v in %v", arch, dir)
+	}
+	consts := make(map[string]uint64)
+	for _, file := range files {
+		if err := readConstFile(file, consts); err != nil {
+			return nil, err
+		}
+	}
+	return consts, nil
+}
+
+func readConstFile(file string, consts map[string]uint64) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("failed to open const file: %v", err)
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := s.Text()
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		eq := strings.IndexByte(line, '=')
+		if eq == -1 {
+			return fmt.Errorf("malformed const file %v: no '=' in '%v'", file, line)
+		}
+		name := strings.TrimSpace(line[:eq])
+		val, err := strconv.ParseUint(strings.TrimSpace(line[eq+1:]), 0, 64)
+		if err != nil {
+			return fmt.Errorf("malformed const file %v: bad value in '%v'", file, line)
+		}
+		if old, ok := consts[name]; ok && old != val {
+			return fmt.Errorf("const %v has different values in const files: %v vs %v", name, old, val)
+		}
+		consts[name] = val
+	}
+	if err := s.Err(); err != nil {
+		return fmt.Errorf("failed to read const file: %v", err)
+	}
+	return nil
+}
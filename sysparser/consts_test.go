@@ -0,0 +1,46 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package sysparser
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestReadConsts(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a_amd64.const": "# comment\n\nFOO = 1\nBAR = 0x10\n",
+		"b_amd64.const": "FOO=1\nBAZ = 18446744073709551516\n",
+		"a_arm64.const": "FOO = 2\n",
+	})
+	defer os.RemoveAll(dir)
+	consts, err := ReadConsts(dir, "amd64")
+	if err != nil {
+		t.Fatalf("failed to read consts: %v", err)
+	}
+	want := map[string]uint64{"FOO": 1, "BAR": 0x10, "BAZ": 0xffffffffffffff9c}
+	if !reflect.DeepEqual(consts, want) {
+		t.Fatalf("got consts %v, want %v", consts, want)
+	}
+	if _, err := ReadConsts(dir, "ppc64le"); err == nil {
+		t.Fatalf("read consts for arch without const files")
+	}
+}
+
+func TestReadConstsErrors(t *testing.T) {
+	for _, data := range []string{
+		"FOO 1\n",
+		"FOO = bar\n",
+		"FOO = -1\n",
+		"FOO = 1\nFOO = 2\n",
+	} {
+		dir := writeFiles(t, map[string]string{"a_amd64.const": data})
+		_, err := ReadConsts(dir, "amd64")
+		os.RemoveAll(dir)
+		if err == nil {
+			t.Fatalf("read malformed const file:\n%v", data)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package sysparser
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Expr is a parsed length expression (lenexpr type), e.g. "len(hdr)+bytesize(data)/4".
+// Op is one of:
+//
+//	"const": integer literal Val
+//	"ident": named constant Name
+//	"len", "bytesize", "offsetof": function of field Name
+//	"+", "-", "*", "/": binary operator over Args
+type Expr struct {
+	Op   string
+	Val  uint64
+	Name string
+	Args []*Expr
+}
+
+// ParseExpr parses a length expression.
+func ParseExpr(s string) (*Expr, error) {
+	p := &exprParser{s: s}
+	e := p.sum()
+	p.skipWs()
+	if p.err == nil && p.i != len(p.s) {
+		p.failf("unexpected '%v'", p.s[p.i:])
+	}
+	if p.err != nil {
+		return nil, fmt.Errorf("bad expression '%v': %v", s, p.err)
+	}
+	return e, nil
+}
+
+// Idents returns names of all named constants used in the expression.
+func (e *Expr) Idents() []string {
+	if e.Op == "ident" {
+		return []string{e.Name}
+	}
+	var res []string
+	for _, arg := range e.Args {
+		res = append(res, arg.Idents()...)
+	}
+	return res
+}
+
+type exprParser struct {
+	s   string
+	i   int
+	err error
+}
+
+func (p *exprParser) failf(msg string, args ...interface{}) {
+	if p.err == nil {
+		p.err = fmt.Errorf(msg, args...)
+	}
+	p.i = len(p.s)
+}
+
+func (p *exprParser) skipWs() {
+	for p.i < len(p.s) && (p.s[p.i] == ' ' || p.s[p.i] == '\t') {
+		p.i++
+	}
+}
+
+func (p *exprParser) peek() byte {
+	p.skipWs()
+	if p.i == len(p.s) {
+		return 0
+	}
+	return p.s[p.i]
+}
+
+func (p *exprParser) expect(ch byte) {
+	if p.peek() != ch {
+		p.failf("want '%c'", ch)
+		return
+	}
+	p.i++
+}
+
+// sum = product (("+" | "-") product)*
+func (p *exprParser) sum() *Expr {
+	e := p.product()
+	for op := p.peek(); op == '+' || op == '-'; op = p.peek() {
+		p.i++
+		e = &Expr{Op: string(op), Args: []*Expr{e, p.product()}}
+	}
+	return e
+}
+
+// product = term (("*" | "/") term)*
+func (p *exprParser) product() *Expr {
+	e := p.term()
+	for op := p.peek(); op == '*' || op == '/'; op = p.peek() {
+		p.i++
+		e = &Expr{Op: string(op), Args: []*Expr{e, p.term()}}
+	}
+	return e
+}
+
+// term = number | ident | ("len" | "bytesize" | "offsetof") "(" field ")" | "(" sum ")"
+func (p *exprParser) term() *Expr {
+	if p.peek() == '(' {
+		p.i++
+		e := p.sum()
+		p.expect(')')
+		return e
+	}
+	id := p.ident()
+	if id == "" {
+		p.failf("want term")
+		return &Expr{Op: "const"}
+	}
+	if id[0] >= '0' && id[0] <= '9' {
+		v, err := strconv.ParseUint(id, 0, 64)
+		if err != nil {
+			p.failf("bad number %v", id)
+		}
+		return &Expr{Op: "const", Val: v}
+	}
+	switch id {
+	case "len", "bytesize", "offsetof":
+		p.expect('(')
+		fld := p.ident()
+		if fld == "" {
+			p.failf("want field name")
+		}
+		p.expect(')')
+		return &Expr{Op: id, Name: fld}
+	}
+	return &Expr{Op: "ident", Name: id}
+}
+
+func (p *exprParser) ident() string {
+	p.skipWs()
+	start := p.i
+	for p.i < len(p.s) &&
+		(p.s[p.i] >= 'a' && p.s[p.i] <= 'z' ||
+			p.s[p.i] >= 'A' && p.s[p.i] <= 'Z' ||
+			p.s[p.i] >= '0' && p.s[p.i] <= '9' ||
+			p.s[p.i] == '_') {
+		p.i++
+	}
+	return p.s[start:p.i]
+}
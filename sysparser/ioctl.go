@@ -0,0 +1,174 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package sysparser
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Ioctl is a parsed ioctl command macro, e.g. _IOW('k', 3, foo_arg).
+// The command value depends on the arch, so it is computed by sysgen.
+type Ioctl struct {
+	Dir  string // "", "R", "W" or "WR" for _IO, _IOR, _IOW and _IOWR respectively
+	Type string // integer, character literal (e.g. 'k') or named constant
+	Nr   string // integer or named constant
+	Size string // type of the argument (intN, intptr, struct or union) or its size in bytes, empty for _IO
+}
+
+var ioctlMacros = map[string]string{
+	"_IO":   "",
+	"_IOR":  "R",
+	"_IOW":  "W",
+	"_IOWR": "WR",
+}
+
+// IsIoctl returns whether s is an ioctl command macro.
+func IsIoctl(s string) bool {
+	paren := strings.IndexByte(s, '(')
+	if paren == -1 {
+		return false
+	}
+	_, ok := ioctlMacros[s[:paren]]
+	return ok
+}
+
+// ParseIoctl parses an ioctl command macro, whitespace is not allowed.
+func ParseIoctl(s string) (*Ioctl, error) {
+	paren := strings.IndexByte(s, '(')
+	if paren == -1 || !strings.HasSuffix(s, ")") {
+		return nil, fmt.Errorf("bad ioctl command '%v'", s)
+	}
+	dir, ok := ioctlMacros[s[:paren]]
+	if !ok {
+		return nil, fmt.Errorf("bad ioctl command '%v': unknown macro %v", s, s[:paren])
+	}
+	args := strings.Split(s[paren+1:len(s)-1], ",")
+	want := 3
+	if dir == "" {
+		want = 2
+	}
+	if len(args) != want {
+		return nil, fmt.Errorf("bad ioctl command '%v': want %v arguments, got %v", s, want, len(args))
+	}
+	for _, arg := range args {
+		if arg == "" {
+			return nil, fmt.Errorf("bad ioctl command '%v': empty argument", s)
+		}
+	}
+	for _, arg := range args[:2] {
+		if _, _, err := ioctlOperand(arg, nil); err != nil {
+			return nil, fmt.Errorf("bad ioctl command '%v': %v", s, err)
+		}
+	}
+	c := &Ioctl{Dir: dir, Type: args[0], Nr: args[1]}
+	if dir != "" {
+		c.Size = args[2]
+	}
+	return c, nil
+}
+
+// Idents returns named constants used in the command.
+func (c *Ioctl) Idents() []string {
+	var res []string
+	for _, arg := range []string{c.Type, c.Nr} {
+		if isIdentifier(arg) {
+			res = append(res, arg)
+		}
+	}
+	return res
+}
+
+// IoctlOperand returns value of command operand s (Type or Nr of a parsed command),
+// named constants are looked up in consts. It returns false if the constant is missing.
+func IoctlOperand(s string, consts map[string]uint64) (uint64, bool) {
+	v, ok, _ := ioctlOperand(s, consts)
+	return v, ok
+}
+
+func ioctlOperand(s string, consts map[string]uint64) (uint64, bool, error) {
+	if len(s) == 3 && s[0] == '\'' && s[2] == '\'' {
+		return uint64(s[1]), true, nil
+	}
+	if isIdentifier(s) {
+		v, ok := consts[s]
+		return v, ok, nil
+	}
+	v, err := strconv.ParseUint(s, 0, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("bad operand %v", s)
+	}
+	return v, true, nil
+}
+
+// IoctlEncoding describes how ioctl commands are encoded on an arch
+// (see include/uapi/asm-generic/ioctl.h and arch/powerpc/include/uapi/asm/ioctl.h).
+// Command is dir<<(16+SizeBits) | size<<16 | type<<8 | nr.
+type IoctlEncoding struct {
+	SizeBits uint64
+	DirNone  uint64
+	DirWrite uint64
+	DirRead  uint64
+}
+
+var (
+	genericIoctl = IoctlEncoding{SizeBits: 14, DirNone: 0, DirWrite: 1, DirRead: 2}
+	powerpcIoctl = IoctlEncoding{SizeBits: 13, DirNone: 1, DirWrite: 4, DirRead: 2}
+)
+
+// IoctlEncodings maps supported arches to their ioctl encodings.
+var IoctlEncodings = map[string]IoctlEncoding{
+	"amd64":   genericIoctl,
+	"arm64":   genericIoctl,
+	"ppc64le": powerpcIoctl,
+}
+
+// Command returns value of command c with the given values of the operands and the argument size.
+func (enc IoctlEncoding) Command(c *Ioctl, typ, nr, size uint64) uint64 {
+	dir := enc.DirNone
+	if c.Dir != "" {
+		dir = 0
+		if strings.Contains(c.Dir, "R") {
+			dir |= enc.DirRead
+		}
+		if strings.Contains(c.Dir, "W") {
+			dir |= enc.DirWrite
+		}
+	}
+	return dir<<(16+enc.SizeBits) | size<<16 | typ<<8 | nr
+}
+
+// Size returns the argument size encoded in command value cmd.
+func (enc IoctlEncoding) Size(cmd uint64) uint64 {
+	return cmd >> 16 & (1<<enc.SizeBits - 1)
+}
+
+// addIoctlFlags creates fake flags for named constants used in ioctl commands,
+// so that they are extracted along with flag values.
+func addIoctlFlags(flags map[string][]string) {
+	var names []string
+	for name := range flags {
+		names = append(names, name)
+	}
+	// Iterate in a stable order, so that generated names don't change between runs.
+	sort.Strings(names)
+	for _, name := range names {
+		for _, v := range flags[name] {
+			if !IsIoctl(v) {
+				continue
+			}
+			c, err := ParseIoctl(v)
+			if err != nil {
+				failf("%v", err)
+			}
+			for _, id := range c.Idents() {
+				flag := fmt.Sprintf("const_flag_%v", constSeq)
+				constSeq++
+				flags[flag] = []string{id}
+			}
+		}
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package sysparser
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseIoctl(t *testing.T) {
+	tests := []struct {
+		s      string
+		ioctl  *Ioctl
+		idents []string
+	}{
+		{"_IO(KVMIO,0x01)", &Ioctl{Dir: "", Type: "KVMIO", Nr: "0x01"}, []string{"KVMIO"}},
+		{"_IOR('T',0x30,int32)", &Ioctl{Dir: "R", Type: "'T'", Nr: "0x30", Size: "int32"}, nil},
+		{"_IOW('T',202,int32)", &Ioctl{Dir: "W", Type: "'T'", Nr: "202", Size: "int32"}, nil},
+		{"_IOWR(DRM_IOCTL_BASE,DRM_VERSION,drm_version)",
+			&Ioctl{Dir: "WR", Type: "DRM_IOCTL_BASE", Nr: "DRM_VERSION", Size: "drm_version"},
+			[]string{"DRM_IOCTL_BASE", "DRM_VERSION"}},
+		{"_IOR(0x12,114,8)", &Ioctl{Dir: "R", Type: "0x12", Nr: "114", Size: "8"}, nil},
+	}
+	for _, test := range tests {
+		if !IsIoctl(test.s) {
+			t.Fatalf("%v: not recognized as ioctl command", test.s)
+		}
+		c, err := ParseIoctl(test.s)
+		if err != nil {
+			t.Fatalf("%v: failed to parse: %v", test.s, err)
+		}
+		if !reflect.DeepEqual(c, test.ioctl) {
+			t.Fatalf("%v: got %+v, want %+v", test.s, *c, *test.ioctl)
+		}
+		if idents := c.Idents(); !reflect.DeepEqual(idents, test.idents) {
+			t.Fatalf("%v: got idents %q, want %q", test.s, idents, test.idents)
+		}
+	}
+}
+
+func TestParseIoctlErrors(t *testing.T) {
+	for _, s := range []string{
+		"_IO",
+		"_IO('T')",
+		"_IO('T',1,int32)",
+		"_IOR('T',1)",
+		"_IOWR('T',,int32)",
+		"_IOR('T',1,int32",
+		"_IOX('T',1,int32)",
+		"_IO('TT',1)",
+		"_IO('T',1x)",
+		"_IOR(T-1,1,int32)",
+	} {
+		if _, err := ParseIoctl(s); err == nil {
+			t.Fatalf("%v: parsed successfully", s)
+		}
+	}
+	for _, s := range []string{"_IO", "FOO(1)", "0x5401", "_IOX('T',1)"} {
+		if IsIoctl(s) {
+			t.Fatalf("%v: recognized as ioctl command", s)
+		}
+	}
+}
+
+func TestIoctlEncodings(t *testing.T) {
+	consts := map[string]uint64{"KVMIO": 0xae}
+	tests := []struct {
+		cmd     string
+		size    uint64
+		generic uint64
+		powerpc uint64
+	}{
+		{"_IO(KVMIO,0x01)", 0, 0xae01, 0x2000ae01},
+		{"_IOR('T',0x30,int32)", 4, 0x80045430, 0x40045430},
+		{"_IOW('T',202,int32)", 4, 0x400454ca, 0x800454ca},
+		{"_IOWR('d',0x00,drm_version)", 64, 0xc0406400, 0xc0406400},
+		{"_IOR('T',1,0x1fff)", 0x1fff, 0x9fff5401, 0x5fff5401},
+	}
+	for _, test := range tests {
+		c, err := ParseIoctl(test.cmd)
+		if err != nil {
+			t.Fatalf("%v: failed to parse: %v", test.cmd, err)
+		}
+		typ, ok1 := IoctlOperand(c.Type, consts)
+		nr, ok2 := IoctlOperand(c.Nr, consts)
+		if !ok1 || !ok2 {
+			t.Fatalf("%v: failed to get operands", test.cmd)
+		}
+		for arch, enc := range IoctlEncodings {
+			want := test.generic
+			if arch == "ppc64le" {
+				want = test.powerpc
+			}
+			cmd := enc.Command(c, typ, nr, test.size)
+			if cmd != want {
+				t.Errorf("%v: %v = 0x%x, want 0x%x", arch, test.cmd, cmd, want)
+			}
+			if size := enc.Size(cmd); size != test.size {
+				t.Errorf("%v: size of %v is %v, want %v", arch, test.cmd, size, test.size)
+			}
+		}
+	}
+	if _, ok := IoctlOperand("FOO", consts); ok {
+		t.Errorf("got value of undefined constant")
+	}
+}
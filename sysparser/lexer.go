@@ -5,14 +5,15 @@ package sysparser
 
 import (
 	"fmt"
-	"io"
 	"os"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 )
 
 type Description struct {
+	Files     []string // all parsed description files, including the included ones
 	Includes  []string
 	Defines   map[string]string
 	Syscalls  []Syscall
@@ -37,25 +38,103 @@ type Struct struct {
 	Packed  bool
 	Varlen  bool
 	Align   int
+	Nlattr  bool // netlink attribute (see expandNlattrs)
 }
 
 type Resource struct {
-	Name   string
-	Base   string
-	Values []string
+	Name       string
+	Base       string
+	Values     []string
+	Destructor string
 }
 
-func Parse(in io.Reader) *Description {
-	p := newParser(in)
-	var includes []string
-	defines := make(map[string]string)
-	var syscalls []Syscall
-	structs := make(map[string]Struct)
-	unnamed := make(map[string][]string)
-	flags := make(map[string][]string)
-	strflags := make(map[string][]string)
-	resources := make(map[string]Resource)
+// ParseFiles parses descriptions from files and from all files they refer to with
+// include "file.txt" directives (paths are relative to the including file).
+// Every file is parsed once, so a file can be both listed and included,
+// and include cycles are harmless. All files share a single namespace.
+func ParseFiles(files []string) *Description {
+	ctx := &parseCtx{
+		parsed:    make(map[string]bool),
+		pos:       make(map[string]string),
+		headers:   make(map[string]bool),
+		defines:   make(map[string]string),
+		structs:   make(map[string]Struct),
+		unnamed:   make(map[string][]string),
+		flags:     make(map[string][]string),
+		strflags:  make(map[string][]string),
+		resources: make(map[string]Resource),
+		templates: make(map[string]*template),
+	}
+	for _, file := range files {
+		ctx.parseFile(file, nil)
+	}
+	sort.Sort(syscallArray(ctx.syscalls))
+	expandTemplates(ctx.templates, ctx.syscalls, ctx.structs, ctx.unnamed, ctx.flags)
+	addIoctlFlags(ctx.flags)
+	expandNlattrs(ctx.syscalls, ctx.structs, ctx.unnamed)
+	return &Description{
+		Files:     ctx.files,
+		Includes:  ctx.includes,
+		Defines:   ctx.defines,
+		Syscalls:  ctx.syscalls,
+		Structs:   ctx.structs,
+		Unnamed:   ctx.unnamed,
+		Flags:     ctx.flags,
+		StrFlags:  ctx.strflags,
+		Resources: ctx.resources,
+	}
+}
+
+type parseCtx struct {
+	files     []string
+	parsed    map[string]bool
+	pos       map[string]string // "kind name" -> position of the definition
+	headers   map[string]bool
+	includes  []string
+	defines   map[string]string
+	syscalls  []Syscall
+	structs   map[string]Struct
+	unnamed   map[string][]string
+	flags     map[string][]string
+	strflags  map[string][]string
+	resources map[string]Resource
+	templates map[string]*template
+}
+
+// parseFile parses file unless it was already parsed,
+// from is the parser of the including file (nil for top-level files).
+func (ctx *parseCtx) parseFile(file string, from *parser) {
+	file = filepath.Clean(file)
+	if ctx.parsed[file] {
+		return
+	}
+	ctx.parsed[file] = true
+	ctx.files = append(ctx.files, file)
+	f, err := os.Open(file)
+	if err != nil {
+		if from != nil {
+			from.failf("failed to open included file: %v", err)
+		}
+		failf("failed to open input file: %v", err)
+	}
+	defer f.Close()
+	ctx.parse(newParser(file, f))
+}
+
+// define records a definition of name at the current position.
+// Structs, unions and resources share the "type" kind.
+func (ctx *parseCtx) define(p *parser, kind, what, name string) {
+	key := kind + " " + name
+	if prev, ok := ctx.pos[key]; ok {
+		p.failf("%v '%v' is already defined at %v", what, name, prev)
+	}
+	ctx.pos[key] = p.Pos()
+}
+
+func (ctx *parseCtx) parse(p *parser) {
+	unnamed, flags := ctx.unnamed, ctx.flags
 	var str *Struct
+	var tmpl *template
 	for p.Scan() {
 		if p.EOF() || p.Char() == '#' {
 			continue
@@ -70,7 +149,7 @@ func Parse(in io.Reader) *Description {
 						case "varlen":
 							str.Varlen = true
 						default:
-							failf("unknown union %v attribute: %v", str.Name, attr)
+							p.failf("unknown union %v attribute: %v", str.Name, attr)
 						}
 					} else {
 						switch {
@@ -81,33 +160,40 @@ func Parse(in io.Reader) *Description {
 						case strings.HasPrefix(attr, "align_"):
 							a, err := strconv.ParseUint(attr[6:], 10, 64)
 							if err != nil {
-								failf("bad struct %v alignment %v: %v", str.Name, attr, err)
+								p.failf("bad struct %v alignment %v: %v", str.Name, attr, err)
 							}
 							if a&(a-1) != 0 || a == 0 || a > 1<<30 {
-								failf("bad struct %v alignment %v: must be sane power of 2", str.Name, a)
+								p.failf("bad struct %v alignment %v: must be sane power of 2", str.Name, a)
 							}
 							str.Align = int(a)
 						default:
-							failf("unknown struct %v attribute: %v", str.Name, attr)
+							p.failf("unknown struct %v attribute: %v", str.Name, attr)
 						}
 					}
 				}
 				if str.IsUnion {
 					if len(str.Flds) <= 1 {
-						failf("union %v has only %v fields, need at least 2", str.Name, len(str.Flds))
+						p.failf("union %v has only %v fields, need at least 2", str.Name, len(str.Flds))
 					}
 				}
 				fields := make(map[string]bool)
 				for _, f := range str.Flds {
 					if f[0] == "parent" {
-						failf("struct/union %v contains reserved field 'parent'", str.Name)
+						p.failf("struct/union %v contains reserved field 'parent'", str.Name)
 					}
 					if fields[f[0]] {
-						failf("duplicate field %v in struct/union %v", f[0], str.Name)
+						p.failf("duplicate field %v in struct/union %v", f[0], str.Name)
 					}
 					fields[f[0]] = true
 				}
-				structs[str.Name] = *str
+				if tmpl != nil {
+					tmpl.Struct = *str
+					ctx.templates[str.Name] = tmpl
+					tmpl = nil
+					unnamed, flags = ctx.unnamed, ctx.flags
+				} else {
+					ctx.structs[str.Name] = *str
+				}
 				str = nil
 			} else {
 				p.SkipWs()
@@ -117,7 +203,16 @@ func Parse(in io.Reader) *Description {
 			}
 		} else {
 			name := p.Ident()
-			if name == "include" {
+			if name == "include" && p.Char() == '"' {
+				// Description include, e.g. include "common.txt".
+				file := p.Ident()
+				if !p.EOF() {
+					p.failf("trailing data after include")
+				}
+				ctx.parseFile(filepath.Join(filepath.Dir(p.file), file[1:len(file)-1]), p)
+				continue
+			} else if name == "include" {
+				// Kernel header include, e.g. include <linux/types.h>.
 				p.Parse('<')
 				var include []byte
 				for {
@@ -129,7 +224,40 @@ func Parse(in io.Reader) *Description {
 					include = append(include, ch)
 				}
 				p.Parse('>')
-				includes = append(includes, string(include))
+				if !ctx.headers[string(include)] {
+					ctx.headers[string(include)] = true
+					ctx.includes = append(ctx.includes, string(include))
+				}
+			} else if name == "type" {
+				// Template, e.g. type buf_for[T] {.
+				p.SkipWs()
+				id := p.Ident()
+				p.Parse('[')
+				params := make(map[string]bool)
+				tmpl = &template{unnamed: make(map[string][]string)}
+				for {
+					param := p.Ident()
+					if params[param] {
+						p.failf("duplicate parameter %v of template %v", param, id)
+					}
+					params[param] = true
+					tmpl.Params = append(tmpl.Params, param)
+					if p.Char() == ']' {
+						break
+					}
+					p.Parse(',')
+				}
+				p.Parse(']')
+				ch := p.Char()
+				if ch != '{' && ch != '[' {
+					p.failf("want '{' or '[' after parameters of template %v, got '%v'", id, string(ch))
+				}
+				p.Parse(ch)
+				ctx.define(p, "type", "template", id)
+				// Types in the template body refer to the parameters,
+				// they become real types and const flags only when the template is instantiated.
+				unnamed, flags = tmpl.unnamed, make(map[string][]string)
+				str = &Struct{Name: id, IsUnion: ch == '['}
 			} else if name == "define" {
 				key := p.Ident()
 				var val []byte
@@ -138,10 +266,8 @@ func Parse(in io.Reader) *Description {
 					p.Parse(ch)
 					val = append(val, ch)
 				}
-				if defines[key] != "" {
-					failf("%v define is defined multiple times", key)
-				}
-				defines[key] = fmt.Sprintf("(%s)", val)
+				ctx.define(p, "define", "define", key)
+				ctx.defines[key] = fmt.Sprintf("(%s)", val)
 			} else if name == "resource" {
 				p.SkipWs()
 				id := p.Ident()
@@ -152,18 +278,28 @@ func Parse(in io.Reader) *Description {
 				if !p.EOF() && p.Char() == ':' {
 					p.Parse(':')
 					vals = append(vals, p.Ident())
-					for !p.EOF() {
+					for !p.EOF() && p.Char() != '[' {
 						p.Parse(',')
 						vals = append(vals, p.Ident())
 					}
 				}
-				if _, ok := resources[id]; ok {
-					failf("resource '%v' is defined multiple times", id)
-				}
-				if _, ok := structs[id]; ok {
-					failf("struct '%v' is redefined as resource", name)
+				destructor := ""
+				if !p.EOF() {
+					// Attributes, e.g. [destructor[close]].
+					p.Parse('[')
+					if attr := p.Ident(); attr != "destructor" {
+						p.failf("unknown resource '%v' attribute '%v'", id, attr)
+					}
+					p.Parse('[')
+					destructor = p.Ident()
+					p.Parse(']')
+					p.Parse(']')
+					if !p.EOF() {
+						p.failf("trailing data after resource '%v'", id)
+					}
 				}
-				resources[id] = Resource{id, base, vals}
+				ctx.define(p, "type", "resource", id)
+				ctx.resources[id] = Resource{id, base, vals, destructor}
 			} else {
 				switch ch := p.Char(); ch {
 				case '(':
@@ -190,11 +326,12 @@ func Parse(in io.Reader) *Description {
 					fields := make(map[string]bool)
 					for _, a := range args {
 						if fields[a[0]] {
-							failf("duplicate arg %v in syscall %v", a[0], name)
+							p.failf("duplicate arg %v in syscall %v", a[0], name)
 						}
 						fields[a[0]] = true
 					}
-					syscalls = append(syscalls, Syscall{name, callName, args, ret})
+					ctx.define(p, "syscall", "syscall", name)
+					ctx.syscalls = append(ctx.syscalls, Syscall{name, callName, args, ret})
 				case '=':
 					// flag
 					p.Parse('=')
@@ -211,39 +348,31 @@ func Parse(in io.Reader) *Description {
 						}
 						p.Parse(',')
 					}
+					ctx.define(p, "flags", "flags", name)
 					if str {
-						strflags[name] = vals
+						ctx.strflags[name] = vals
 					} else {
 						flags[name] = vals
 					}
 				case '{', '[':
 					p.Parse(ch)
-					if _, ok := structs[name]; ok {
-						failf("struct '%v' is defined multiple times", name)
-					}
-					if _, ok := resources[name]; ok {
-						failf("resource '%v' is redefined as struct", name)
+					what := "struct"
+					if ch == '[' {
+						what = "union"
 					}
+					ctx.define(p, "type", what, name)
 					str = &Struct{Name: name, IsUnion: ch == '['}
 				default:
-					failf("bad line (%v)", p.Str())
+					p.failf("bad line (%v)", p.Str())
 				}
 			}
 		}
 		if !p.EOF() {
-			failf("trailing data (%v)", p.Str())
+			p.failf("trailing data (%v)", p.Str())
 		}
 	}
-	sort.Sort(syscallArray(syscalls))
-	return &Description{
-		Includes:  includes,
-		Defines:   defines,
-		Syscalls:  syscalls,
-		Structs:   structs,
-		Unnamed:   unnamed,
-		Flags:     flags,
-		StrFlags:  strflags,
-		Resources: resources,
+	if str != nil {
+		p.failf("struct/union %v is not terminated", str.Name)
 	}
 }
 
@@ -286,19 +415,58 @@ func parseType1(p *parser, unnamed map[string][]string, flags map[string][]strin
 		}
 		p.Parse(']')
 	}
-	if name == "const" && len(typ) > 1 {
+	if err := addConstFlags(typ, flags); err != nil {
+		p.failf("%v", err)
+	}
+	return typ
+}
+
+// addConstFlags creates fake flags for named constants used in options of typ,
+// so that the constants are extracted along with flag values.
+func addConstFlags(typ []string, flags map[string][]string) error {
+	if typ[0] == "const" && len(typ) > 1 {
 		// Create a fake flag with the const value.
 		id := fmt.Sprintf("const_flag_%v", constSeq)
 		constSeq++
 		flags[id] = typ[1:2]
 	}
-	if name == "array" && len(typ) > 2 {
+	if typ[0] == "array" && len(typ) > 2 {
 		// Create a fake flag with the const value.
 		id := fmt.Sprintf("const_flag_%v", constSeq)
 		constSeq++
 		flags[id] = typ[2:3]
 	}
-	return typ
+	if typ[0] == "lenexpr" && len(typ) > 1 {
+		// Create fake flags with named constants used in the expression.
+		e, err := ParseExpr(strings.Trim(typ[1], "\""))
+		if err != nil {
+			return err
+		}
+		for _, id := range e.Idents() {
+			flag := fmt.Sprintf("const_flag_%v", constSeq)
+			constSeq++
+			flags[flag] = []string{id}
+		}
+	}
+	if (typ[0] == "nlattr" || typ[0] == "nlnest") && len(typ) > 1 {
+		// Create a fake flag with the attribute type.
+		id := fmt.Sprintf("const_flag_%v", constSeq)
+		constSeq++
+		flags[id] = typ[1:2]
+	}
+	if typ[0] == "cond" && len(typ) > 2 {
+		// Create a fake flag with the condition value.
+		id := fmt.Sprintf("const_flag_%v", constSeq)
+		constSeq++
+		flags[id] = typ[2:3]
+	}
+	if typ[0] == "csum" && len(typ) > 4 && typ[2] == "pseudo" {
+		// Create a fake flag with the protocol value.
+		id := fmt.Sprintf("const_flag_%v", constSeq)
+		constSeq++
+		flags[id] = typ[3:4]
+	}
+	return nil
 }
 
 type syscallArray []Syscall
@@ -0,0 +1,226 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package sysparser
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// writeFiles creates description files in a temp dir and returns the dir.
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "sysparser-test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	for name, data := range files {
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func syscallNames(desc *Description) []string {
+	var names []string
+	for _, c := range desc.Syscalls {
+		names = append(names, c.Name)
+	}
+	return names
+}
+
+func TestIncludeNested(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.txt":      "include \"b.txt\"\nfoo$a()\n",
+		"b.txt":      "include \"sub/c.txt\"\nfoo$b(x flags_c)\n",
+		"sub/c.txt":  "include \"d.txt\"\nflags_c = 1, 2\n",
+		"sub/d.txt":  "foo$d()\n",
+		"unused.txt": "foo$unused()\n",
+	})
+	defer os.RemoveAll(dir)
+	desc := ParseFiles([]string{filepath.Join(dir, "a.txt")})
+	wantFiles := []string{
+		filepath.Join(dir, "a.txt"),
+		filepath.Join(dir, "b.txt"),
+		filepath.Join(dir, "sub", "c.txt"),
+		filepath.Join(dir, "sub", "d.txt"),
+	}
+	if !reflect.DeepEqual(desc.Files, wantFiles) {
+		t.Fatalf("parsed files %q, want %q", desc.Files, wantFiles)
+	}
+	if names, want := syscallNames(desc), []string{"foo$a", "foo$b", "foo$d"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("parsed syscalls %q, want %q", names, want)
+	}
+	if !reflect.DeepEqual(desc.Flags["flags_c"], []string{"1", "2"}) {
+		t.Fatalf("flags from nested include are not parsed: %q", desc.Flags["flags_c"])
+	}
+}
+
+func TestIncludeListedAndIncluded(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.txt": "include \"b.txt\"\nfoo$a()\n",
+		"b.txt": "foo$b()\n",
+	})
+	defer os.RemoveAll(dir)
+	// b.txt is parsed once, so its definitions are not reported as duplicates.
+	for _, files := range [][]string{{"a.txt", "b.txt"}, {"b.txt", "a.txt"}} {
+		desc := ParseFiles([]string{filepath.Join(dir, files[0]), filepath.Join(dir, files[1])})
+		if len(desc.Files) != 2 {
+			t.Fatalf("%v: parsed files %q, want 2 files", files, desc.Files)
+		}
+		if names, want := syscallNames(desc), []string{"foo$a", "foo$b"}; !reflect.DeepEqual(names, want) {
+			t.Fatalf("%v: parsed syscalls %q, want %q", files, names, want)
+		}
+	}
+}
+
+func TestIncludeCycle(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.txt": "include \"b.txt\"\nfoo$a()\n",
+		"b.txt": "include \"a.txt\"\nfoo$b()\ninclude \"b.txt\"\n",
+	})
+	defer os.RemoveAll(dir)
+	desc := ParseFiles([]string{filepath.Join(dir, "a.txt")})
+	if len(desc.Files) != 2 {
+		t.Fatalf("parsed files %q, want 2 files", desc.Files)
+	}
+	if names, want := syscallNames(desc), []string{"foo$a", "foo$b"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("parsed syscalls %q, want %q", names, want)
+	}
+}
+
+func TestIncludeDuplicateDefinition(t *testing.T) {
+	// Parsing errors terminate the process, so the parsing is done in a subprocess
+	// (the test binary itself started with SYZ_SYSPARSER_FILES).
+	if files := os.Getenv("SYZ_SYSPARSER_FILES"); files != "" {
+		ParseFiles(strings.Split(files, ","))
+		return
+	}
+	dir := writeFiles(t, map[string]string{
+		"a.txt": "foo$a()\ninclude \"b.txt\"\n",
+		"b.txt": "# comment\n\nfoo$a(x int32)\n",
+	})
+	defer os.RemoveAll(dir)
+	cmd := exec.Command(os.Args[0], "-test.run=^TestIncludeDuplicateDefinition$")
+	cmd.Env = append(os.Environ(), "SYZ_SYSPARSER_FILES="+filepath.Join(dir, "a.txt"))
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		t.Fatalf("duplicate definition is not detected:\n%s", out)
+	}
+	want := filepath.Join(dir, "b.txt") + ":3: syscall 'foo$a' is already defined at " + filepath.Join(dir, "a.txt") + ":1"
+	if !strings.Contains(string(out), want) {
+		t.Fatalf("bad error:\n%s\nwant:\n%v", out, want)
+	}
+}
+
+const templateDescriptions = `
+type buf_for[T] {
+	len	len[data, int32]
+	data	T
+}
+
+type pair[A, B] {
+	a	A
+	b	B
+}
+
+foo$a(a ptr[in, buf_for[array[int8]]], b ptr[in, buf_for[int64]])
+foo$b(a ptr[in, buf_for[array[int8]]])
+foo$c(a ptr[in, pair[int32, buf_for[array[int8, 4]]]])
+foo$d(a ptr[in, buf_for[pair[int8, int16]]])
+`
+
+// argStruct returns the struct name behind ptr argument arg of syscall call.
+func argStruct(t *testing.T, desc *Description, call string, arg int) string {
+	for _, c := range desc.Syscalls {
+		if c.Name != call {
+			continue
+		}
+		typ := c.Args[arg][1:]
+		if len(typ) != 3 || typ[0] != "ptr" {
+			t.Fatalf("%v: bad argument %q", call, typ)
+		}
+		if inner, ok := desc.Unnamed[typ[2]]; ok {
+			typ = inner
+		} else {
+			typ = typ[2:]
+		}
+		if len(typ) != 1 {
+			t.Fatalf("%v: template is not expanded: %q", call, typ)
+		}
+		return typ[0]
+	}
+	t.Fatalf("no syscall %v", call)
+	return ""
+}
+
+func TestTemplateReused(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"a.txt": templateDescriptions})
+	defer os.RemoveAll(dir)
+	desc := ParseFiles([]string{filepath.Join(dir, "a.txt")})
+	if name := argStruct(t, desc, "foo$a", 0); name != "buf_for_array_int8" {
+		t.Fatalf("foo$a: got struct %v, want buf_for_array_int8", name)
+	}
+	if name := argStruct(t, desc, "foo$b", 0); name != "buf_for_array_int8" {
+		t.Fatalf("foo$b: got struct %v, want buf_for_array_int8", name)
+	}
+	if name := argStruct(t, desc, "foo$a", 1); name != "buf_for_int64" {
+		t.Fatalf("foo$a: got struct %v, want buf_for_int64", name)
+	}
+	str := desc.Structs["buf_for_array_int8"]
+	if len(str.Flds) != 2 || !reflect.DeepEqual(str.Flds[0], []string{"len", "len", "data", "int32"}) {
+		t.Fatalf("bad fields of buf_for_array_int8: %q", str.Flds)
+	}
+	if !reflect.DeepEqual(str.Flds[1], []string{"data", "array", "int8"}) {
+		t.Fatalf("bad data field of buf_for_array_int8: %q", str.Flds[1])
+	}
+}
+
+func TestTemplateNested(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"a.txt": templateDescriptions})
+	defer os.RemoveAll(dir)
+	desc := ParseFiles([]string{filepath.Join(dir, "a.txt")})
+	if name := argStruct(t, desc, "foo$c", 0); name != "pair_int32_buf_for_array_int8_4" {
+		t.Fatalf("foo$c: got struct %v, want pair_int32_buf_for_array_int8_4", name)
+	}
+	if name := argStruct(t, desc, "foo$d", 0); name != "buf_for_pair_int8_int16" {
+		t.Fatalf("foo$d: got struct %v, want buf_for_pair_int8_int16", name)
+	}
+	flds := map[string][][]string{
+		"pair_int32_buf_for_array_int8_4": {{"a", "int32"}, {"b", "buf_for_array_int8_4"}},
+		"pair_int8_int16":                 {{"a", "int8"}, {"b", "int16"}},
+		"buf_for_pair_int8_int16":         {{"len", "len", "data", "int32"}, {"data", "pair_int8_int16"}},
+	}
+	for name, want := range flds {
+		if got := desc.Structs[name].Flds; !reflect.DeepEqual(got, want) {
+			t.Fatalf("bad fields of %v: %q, want %q", name, got, want)
+		}
+	}
+	var names []string
+	for name := range desc.Structs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	want := []string{
+		"buf_for_array_int8",
+		"buf_for_array_int8_4",
+		"buf_for_int64",
+		"buf_for_pair_int8_int16",
+		"pair_int32_buf_for_array_int8_4",
+		"pair_int8_int16",
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("generated structs %q, want %q", names, want)
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package sysparser
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Netlink attributes nlattr[TYPE, payload] and nested attributes nlnest[TYPE, payload]
+// are expanded into structs that mirror struct nlattr (uapi/linux/netlink.h)
+// followed by the payload and padded to NLA_ALIGNTO:
+//
+//	nlattrN {
+//		nla_len		lenexpr["bytesize(payload)+4", int16]
+//		nla_type	const[TYPE, int16:14]
+//		nla_net_byteorder	const[0, int16:1]
+//		nla_nested	const[0, int16:1]	# 1 for nlnest
+//		payload		payload
+//	} [packed, align_4]
+
+var nlattrSeq int
+
+func expandNlattrs(syscalls []Syscall, structs map[string]Struct, unnamed map[string][]string) {
+	var expand func(typ []string) []string
+	expand = func(typ []string) []string {
+		if len(typ) == 0 || typ[0] != "nlattr" && typ[0] != "nlnest" {
+			return typ
+		}
+		if len(typ) != 3 {
+			failf("wrong number of arguments for %v, want 2, got %v", typ[0], len(typ)-1)
+		}
+		nested := "0"
+		if typ[0] == "nlnest" {
+			nested = "1"
+		}
+		payload := []string{typ[2]}
+		if inner, ok := unnamed[typ[2]]; ok {
+			// Inline the type, so that the field keeps the name referenced by nla_len.
+			payload = inner
+		}
+		name := fmt.Sprintf("nlattr%v", nlattrSeq)
+		nlattrSeq++
+		structs[name] = Struct{
+			Name: name,
+			Flds: [][]string{
+				{"nla_len", "lenexpr", "\"bytesize(payload)+4\"", "int16"},
+				{"nla_type", "const", typ[1], "int16:14"},
+				{"nla_net_byteorder", "const", "0", "int16:1"},
+				{"nla_nested", "const", nested, "int16:1"},
+				append([]string{"payload"}, expand(payload)...),
+			},
+			Packed: true,
+			Align:  4,
+			Nlattr: true,
+		}
+		return []string{name}
+	}
+	for _, c := range syscalls {
+		for i, a := range c.Args {
+			c.Args[i] = append(a[:1:1], expand(a[1:])...)
+		}
+	}
+	// Iterate in a stable order, so that generated names don't change between runs.
+	var names []string
+	for name := range structs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for i, f := range structs[name].Flds {
+			structs[name].Flds[i] = append(f[:1:1], expand(f[1:])...)
+		}
+	}
+	var ids []string
+	for id := range unnamed {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		unnamed[id] = expand(unnamed[id])
+	}
+}
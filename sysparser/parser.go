@@ -11,20 +11,21 @@ import (
 )
 
 type parser struct {
-	r *bufio.Scanner
-	s string
-	i int
-	l int
+	file string
+	r    *bufio.Scanner
+	s    string
+	i    int
+	l    int
 }
 
-func newParser(r io.Reader) *parser {
-	return &parser{r: bufio.NewScanner(r)}
+func newParser(file string, r io.Reader) *parser {
+	return &parser{file: file, r: bufio.NewScanner(r)}
 }
 
 func (p *parser) Scan() bool {
 	if !p.r.Scan() {
 		if err := p.r.Err(); err != nil {
-			failf("failed to read input file: %v", err)
+			failf("failed to read input file %v: %v", p.file, err)
 		}
 		return false
 	}
@@ -81,20 +82,65 @@ func (p *parser) Ident() string {
 				p.s[p.i] >= 'A' && p.s[p.i] <= 'Z' ||
 				p.s[p.i] >= '0' && p.s[p.i] <= '9' ||
 				p.s[p.i] == '_' || p.s[p.i] == '$' || // $ is for n-way syscalls (like ptrace$peek)
-				p.s[p.i] == '-' || p.s[p.i] == ':') { // : is for ranged int (like int32[-3:10])
+				p.s[p.i] == '-' || p.s[p.i] == ':' || // : is for ranged int (like int32[-3:10])
+				p.s[p.i] == '@') { // @ is for bitfield offset (like int32:4@12)
 			p.i++
 		}
 		if start == p.i {
 			p.failf("failed to parse identifier at pos %v", start)
 		}
 		end = p.i
+		if name := p.s[start:p.i]; IsIoctl(name + "(") {
+			p.SkipWs()
+			if !p.EOF() && p.s[p.i] == '(' {
+				return p.ioctl(name)
+			}
+			p.i = end
+		}
 	}
 	s := p.s[start:end]
 	p.SkipWs()
 	return s
 }
 
+// ioctl parses arguments of ioctl command macro name (e.g. _IOW('k', 3, foo_arg)),
+// the result has all whitespace removed.
+func (p *parser) ioctl(name string) string {
+	buf := []byte(name)
+	depth := 0
+	for {
+		if p.EOF() {
+			p.failf("unterminated ioctl command %s", buf)
+		}
+		ch := p.s[p.i]
+		p.i++
+		if ch == ' ' || ch == '\t' {
+			continue
+		}
+		buf = append(buf, ch)
+		if ch == '(' {
+			depth++
+		} else if ch == ')' {
+			depth--
+			if depth == 0 {
+				break
+			}
+		}
+	}
+	s := string(buf)
+	if _, err := ParseIoctl(s); err != nil {
+		p.failf("%v", err)
+	}
+	p.SkipWs()
+	return s
+}
+
+// Pos returns the current position as file:line.
+func (p *parser) Pos() string {
+	return fmt.Sprintf("%v:%v", p.file, p.l)
+}
+
 func (p *parser) failf(msg string, args ...interface{}) {
-	fmt.Fprintf(os.Stderr, "line #%v: %v\n", p.l, p.s)
-	failf(msg, args...)
+	fmt.Fprintf(os.Stderr, "%v: %v\n\t%v\n", p.Pos(), fmt.Sprintf(msg, args...), p.s)
+	os.Exit(1)
 }
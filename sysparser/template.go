@@ -0,0 +1,173 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package sysparser
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Templates are parameterized structs and unions:
+//
+//	type buf_for[T] {
+//		len	len[data, int32]
+//		data	T
+//	}
+//
+// Every use of a template with arguments (e.g. buf_for[int64]) is replaced with
+// a struct generated from the template body by substitution of the parameters.
+// The struct is named after the text of the use (e.g. buf_for_int64 for buf_for[int64]
+// and buf_for_array_int8 for buf_for[array[int8]]). All uses with the same arguments
+// share the struct.
+
+type template struct {
+	Params  []string
+	Struct  Struct
+	unnamed map[string][]string // nested types of the body, e.g. array[T] in ptr[in, array[T]]
+}
+
+// maxTemplateDepth limits nesting of instantiations to catch templates
+// that instantiate themselves with ever growing arguments.
+const maxTemplateDepth = 16
+
+func expandTemplates(templates map[string]*template, syscalls []Syscall, structs map[string]Struct,
+	unnamed map[string][]string, flags map[string][]string) {
+	if len(templates) == 0 {
+		return
+	}
+	// Nested types are expanded in place, so remember their original text
+	// to name and share instances by the text of the arguments.
+	orig := make(map[string][]string)
+	for id, typ := range unnamed {
+		orig[id] = typ
+	}
+	instances := make(map[string]string)
+	generated := make(map[string]string)
+	var expand func(typ []string, depth int) []string
+	expand = func(typ []string, depth int) []string {
+		if len(typ) == 0 || templates[typ[0]] == nil {
+			return typ
+		}
+		tmpl, args := templates[typ[0]], typ[1:]
+		if len(args) != len(tmpl.Params) {
+			failf("wrong number of arguments for template %v, want %v, got %v", typ[0], len(tmpl.Params), len(args))
+		}
+		key := typeText(typ, orig)
+		if name, ok := instances[key]; ok {
+			return []string{name}
+		}
+		if depth > maxTemplateDepth {
+			failf("template %v is instantiated recursively", typ[0])
+		}
+		name := instanceName(typ, orig)
+		if _, ok := structs[name]; ok {
+			failf("struct %v generated for template %v is already defined", name, typ[0])
+		}
+		if other, ok := generated[name]; ok {
+			failf("struct %v is generated for both %v and %v", name, other, key)
+		}
+		generated[name] = key
+		// Register the instance before expanding the body,
+		// so that the body can refer to the same instance (e.g. via a pointer).
+		instances[key] = name
+		subst := make(map[string]string)
+		for i, param := range tmpl.Params {
+			subst[param] = args[i]
+		}
+		var substitute func(body []string) []string
+		substitute = func(body []string) []string {
+			res := make([]string, len(body))
+			for i, t := range body {
+				if arg, ok := subst[t]; ok {
+					t = arg
+				} else if inner, ok := tmpl.unnamed[t]; ok {
+					t = fmt.Sprintf("unnamed%v", unnamedSeq)
+					unnamedSeq++
+					orig[t] = substitute(inner)
+					unnamed[t] = expand(orig[t], depth+1)
+				}
+				res[i] = t
+			}
+			if err := addConstFlags(res, flags); err != nil {
+				failf("template %v: %v", typ[0], err)
+			}
+			return res
+		}
+		str := tmpl.Struct
+		str.Name = name
+		str.Flds = nil
+		for _, f := range tmpl.Struct.Flds {
+			fld := substitute(f[1:])
+			if inner, ok := orig[fld[0]]; ok && len(fld) == 1 {
+				// Field type is a parameter with a composite argument (e.g. data T for buf_for[array[int8]]),
+				// nested types are not expected in place of field types.
+				fld = inner
+			}
+			str.Flds = append(str.Flds, append(f[:1:1], expand(fld, depth+1)...))
+		}
+		structs[name] = str
+		return []string{name}
+	}
+	for i := range syscalls {
+		c := &syscalls[i]
+		for j, a := range c.Args {
+			c.Args[j] = append(a[:1:1], expand(a[1:], 0)...)
+		}
+		c.Ret = expand(c.Ret, 0)
+	}
+	// Iterate in a stable order, so that generated names don't change between runs.
+	var names []string
+	for name := range structs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for i, f := range structs[name].Flds {
+			structs[name].Flds[i] = append(f[:1:1], expand(f[1:], 0)...)
+		}
+	}
+	var ids []string
+	for id := range unnamed {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		unnamed[id] = expand(unnamed[id], 0)
+	}
+}
+
+// typeText returns text of type typ with nested types inlined, e.g. buf_for[array[int8]].
+func typeText(typ []string, unnamed map[string][]string) string {
+	if len(typ) == 1 {
+		if inner, ok := unnamed[typ[0]]; ok {
+			return typeText(inner, unnamed)
+		}
+		return typ[0]
+	}
+	var args []string
+	for _, arg := range typ[1:] {
+		args = append(args, typeText([]string{arg}, unnamed))
+	}
+	return typ[0] + "[" + strings.Join(args, ", ") + "]"
+}
+
+// instanceName returns name of the struct for template instantiation typ,
+// e.g. buf_for_int64 for buf_for[int64] and buf_for_array_int8 for buf_for[array[int8]].
+func instanceName(typ []string, unnamed map[string][]string) string {
+	name := typ[0]
+	for _, arg := range typ[1:] {
+		if inner, ok := unnamed[arg]; ok {
+			name += "_" + instanceName(inner, unnamed)
+			continue
+		}
+		name += "_" + strings.Map(func(c rune) rune {
+			if c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+				return c
+			}
+			return '_'
+		}, arg)
+	}
+	return name
+}
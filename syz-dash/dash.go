@@ -0,0 +1,340 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+// syz-dash collects crashes and reproducers from multiple managers into bugs
+// and tracks their lifecycle (see syz-dash/state): bugs are reported/invalidated
+// and fix commits are attached to them in the web UI, the fix commits are looked up
+// in the monitored kernel git trees, and bugs are closed automatically once the crash
+// stops occurring on kernels that contain the fix.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/rpc"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/syzkaller/config"
+	. "github.com/google/syzkaller/log"
+	. "github.com/google/syzkaller/rpctype"
+	"github.com/google/syzkaller/syz-dash/state"
+)
+
+var (
+	flagConfig = flag.String("config", "", "config file")
+
+	cfg *Config
+)
+
+type Config struct {
+	Http     string
+	Rpc      string
+	Workdir  string
+	Managers []ManagerConfig
+	Repos    []RepoConfig
+
+	// Days managers need to test kernels with the fix commits without the crash
+	// before the bug is closed as fixed (default: 3).
+	Close_After int
+}
+
+// ManagerConfig describes credentials of one manager.
+type ManagerConfig struct {
+	Name string
+	Key  string
+}
+
+// RepoConfig describes a monitored kernel git tree, fix commits are looked up in it
+// and kernel commits that managers test (their tags) must be in one of the trees.
+type RepoConfig struct {
+	Name   string
+	Git    string
+	Branch string
+}
+
+type Dash struct {
+	mu       sync.Mutex
+	st       *state.State
+	managers map[string]*ManagerConfig
+
+	// notFixed caches kernel commits that don't contain fix commits of a bug,
+	// keyed by fixedBuild.key(), git history of a commit does not change.
+	notFixed map[string]bool
+}
+
+func main() {
+	flag.Parse()
+	cfg = readConfig(*flagConfig)
+	EnableLogCaching(1000, 1<<20)
+
+	st, err := state.Make(cfg.Workdir)
+	if err != nil {
+		Fatalf("failed to load state: %v", err)
+	}
+	dash := &Dash{
+		st:       st,
+		managers: make(map[string]*ManagerConfig),
+		notFixed: make(map[string]bool),
+	}
+	for i := range cfg.Managers {
+		mgr := &cfg.Managers[i]
+		dash.managers[mgr.Name] = mgr
+	}
+
+	dash.initHttp(cfg.Http)
+	go dash.fixLoop()
+
+	ln, err := net.Listen("tcp", cfg.Rpc)
+	if err != nil {
+		Fatalf("failed to listen on %v: %v", cfg.Rpc, err)
+	}
+	Logf(0, "serving rpc on tcp://%v", ln.Addr())
+	s := rpc.NewServer()
+	s.Register(dash)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			Logf(0, "failed to accept an rpc connection: %v", err)
+			continue
+		}
+		conn.(*net.TCPConn).SetKeepAlive(true)
+		conn.(*net.TCPConn).SetKeepAlivePeriod(time.Minute)
+		go s.ServeConn(conn)
+	}
+}
+
+func (dash *Dash) Report(a *DashReportArgs, r *int) error {
+	mgr := dash.managers[a.Name]
+	if mgr == nil || mgr.Key != a.Key {
+		Logf(0, "report from unauthorized manager %v", a.Name)
+		return fmt.Errorf("unauthorized manager")
+	}
+	dash.mu.Lock()
+	defer dash.mu.Unlock()
+
+	now := time.Now()
+	if err := dash.st.Report(a.Name, a.Tag, now); err != nil {
+		Logf(0, "report error: %v", err)
+		return err
+	}
+	for _, crash := range a.Crashes {
+		Logf(0, "crash from %v: %v (%v times)", a.Name, crash.Title, crash.Count)
+		if err := dash.st.AddCrash(a.Name, a.Tag, crash.Title, crash.Count, crash.Log, crash.Report, now); err != nil {
+			Logf(0, "report error: %v", err)
+			return err
+		}
+	}
+	for _, repro := range a.Repros {
+		Logf(0, "reproducer from %v: %v", a.Name, repro.Title)
+		if err := dash.st.AddRepro(a.Name, a.Tag, repro.Title, repro.Syz, repro.C, repro.Report, now); err != nil {
+			Logf(0, "report error: %v", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// fixLoop periodically updates the monitored git trees, looks up fix commits in them,
+// checks whether kernels that managers test contain the fixes, and closes fixed bugs.
+func (dash *Dash) fixLoop() {
+	for ; ; time.Sleep(time.Hour) {
+		for _, repo := range cfg.Repos {
+			if err := gitUpdate(repoDir(repo.Name), repo.Git, repo.Branch); err != nil {
+				Logf(0, "failed to update repo %v: %v", repo.Name, err)
+			}
+		}
+		dash.findFixes()
+		dash.findFixedBuilds()
+
+		dash.mu.Lock()
+		closed, err := dash.st.CloseFixed(time.Now(), time.Duration(cfg.Close_After)*24*time.Hour)
+		if err != nil {
+			Logf(0, "failed to close fixed bugs: %v", err)
+		}
+		for _, bug := range closed {
+			Logf(0, "bug %v '%v' is fixed", bug.ID, bug.Title)
+		}
+		if err := dash.st.Compact(); err != nil {
+			Logf(0, "failed to compact databases: %v", err)
+		}
+		dash.mu.Unlock()
+	}
+}
+
+func repoDir(name string) string {
+	return filepath.Join(cfg.Workdir, "repo", name)
+}
+
+type fixCommit struct {
+	bug   string
+	title string
+}
+
+// findFixes looks up fix commits that were not found yet in the repos.
+// Git is not run under the lock, it can take a while on kernel trees.
+func (dash *Dash) findFixes() {
+	var fixes []fixCommit
+	dash.mu.Lock()
+	for _, bug := range dash.st.Bugs {
+		if !bug.Open() {
+			continue
+		}
+		for _, fix := range bug.FixCommits {
+			if fix.Hash == "" {
+				fixes = append(fixes, fixCommit{bug.ID, fix.Title})
+			}
+		}
+	}
+	dash.mu.Unlock()
+
+	for _, fix := range fixes {
+		for _, repo := range cfg.Repos {
+			hash, err := gitFindCommit(repoDir(repo.Name), "HEAD", fix.title)
+			if err != nil {
+				Logf(0, "failed to look up commit in %v: %v", repo.Name, err)
+				continue
+			}
+			if hash == "" {
+				continue
+			}
+			Logf(0, "fix commit '%v' found in %v: %v", fix.title, repo.Name, hash)
+			dash.mu.Lock()
+			err = dash.st.SetFixHash(fix.bug, fix.title, repo.Name, hash)
+			dash.mu.Unlock()
+			if err != nil {
+				Logf(0, "failed to record fix commit: %v", err)
+			}
+			break
+		}
+	}
+}
+
+type fixedBuild struct {
+	bug   string
+	tag   string
+	fixes []string
+}
+
+func (build *fixedBuild) key() string {
+	return build.bug + "\n" + build.tag + "\n" + strings.Join(build.fixes, "\n")
+}
+
+// findFixedBuilds checks whether kernels that managers currently test contain fix commits.
+// Bugs that are fixed are checked as well, so that crashes on kernels with the fix create new bugs.
+func (dash *Dash) findFixedBuilds() {
+	var builds []fixedBuild
+	dash.mu.Lock()
+	tags := make(map[string]bool)
+	for _, mgr := range dash.st.Managers {
+		if mgr.Tag != "" {
+			tags[mgr.Tag] = true
+		}
+	}
+	for _, bug := range dash.st.Bugs {
+		if bug.Status == state.StatusInvalid || !bug.FixesFound() {
+			continue
+		}
+		for tag := range tags {
+			if _, ok := bug.FixedBuilds[tag]; ok {
+				continue
+			}
+			build := fixedBuild{bug: bug.ID, tag: tag}
+			for _, fix := range bug.FixCommits {
+				build.fixes = append(build.fixes, fix.Title)
+			}
+			if !dash.notFixed[build.key()] {
+				builds = append(builds, build)
+			}
+		}
+	}
+	dash.mu.Unlock()
+
+	for _, build := range builds {
+		fixed, err := dash.buildContains(build.tag, build.fixes)
+		if err != nil {
+			Logf(1, "failed to check kernel commit %v: %v", build.tag, err)
+			continue
+		}
+		if !fixed {
+			dash.mu.Lock()
+			dash.notFixed[build.key()] = true
+			dash.mu.Unlock()
+			continue
+		}
+		Logf(0, "kernel commit %v contains fix for bug %v", build.tag, build.bug)
+		dash.mu.Lock()
+		err = dash.st.AddFixedBuild(build.bug, build.tag, time.Now())
+		dash.mu.Unlock()
+		if err != nil {
+			Logf(0, "failed to record fixed build: %v", err)
+		}
+	}
+}
+
+// buildContains returns true if the kernel commit contains commits with all the titles.
+// The titles are looked up in the history of the kernel commit, since the fix
+// can have a different hash there than in the tree where it was found first.
+func (dash *Dash) buildContains(tag string, titles []string) (bool, error) {
+	for _, repo := range cfg.Repos {
+		dir := repoDir(repo.Name)
+		if !gitHasCommit(dir, tag) {
+			continue
+		}
+		for _, title := range titles {
+			hash, err := gitFindCommit(dir, tag, title)
+			if err != nil || hash == "" {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+	return false, fmt.Errorf("commit is not found in any repo")
+}
+
+func readConfig(filename string) *Config {
+	if filename == "" {
+		Fatalf("supply config in -config flag")
+	}
+	cfg := new(Config)
+	if err := config.LoadFile(filename, cfg); err != nil {
+		Fatalf("%v", err)
+	}
+	if cfg.Http == "" || cfg.Rpc == "" || cfg.Workdir == "" {
+		Fatalf("config params http, rpc and workdir must be specified")
+	}
+	if cfg.Close_After == 0 {
+		cfg.Close_After = 3
+	}
+	if cfg.Close_After < 0 {
+		Fatalf("config param close_after must not be negative")
+	}
+	names := make(map[string]bool)
+	for i := range cfg.Managers {
+		mgr := &cfg.Managers[i]
+		if mgr.Name == "" || mgr.Key == "" {
+			Fatalf("manager #%v: name and key must be specified", i)
+		}
+		if names[mgr.Name] {
+			Fatalf("duplicate manager %v", mgr.Name)
+		}
+		names[mgr.Name] = true
+	}
+	repos := make(map[string]bool)
+	for i, repo := range cfg.Repos {
+		if repo.Git == "" || !repoNameRe.MatchString(repo.Name) {
+			Fatalf("repo #%v: git and name (letters, digits, '_' and '-') must be specified", i)
+		}
+		if repos[repo.Name] {
+			Fatalf("duplicate repo %v", repo.Name)
+		}
+		repos[repo.Name] = true
+	}
+	return cfg
+}
+
+var repoNameRe = regexp.MustCompile("^[a-zA-Z0-9_-]+$")
@@ -0,0 +1,63 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+func gitUpdate(dir, repo, branch string) error {
+	if _, err := runCmd(dir, "git", "pull"); err != nil {
+		if err := os.RemoveAll(dir); err != nil {
+			return fmt.Errorf("failed to remove repo dir: %v", err)
+		}
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return fmt.Errorf("failed to create repo dir: %v", err)
+		}
+		if _, err := runCmd("", "git", "clone", repo, dir); err != nil {
+			return err
+		}
+	}
+	if branch != "" {
+		if _, err := runCmd(dir, "git", "checkout", branch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// gitFindCommit returns hash of the commit with the title in the history of rev,
+// or an empty string if there is no such commit.
+func gitFindCommit(dir, rev, title string) (string, error) {
+	output, err := runCmd(dir, "git", "log", "--fixed-strings", "--grep", title, "--format=%H %s", rev)
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		// The title can be mentioned in the description of other commits (e.g. of reverts).
+		if len(line) > 41 && line[41:] == title {
+			return line[:40], nil
+		}
+	}
+	return "", nil
+}
+
+// gitHasCommit returns true if the repo has the commit.
+func gitHasCommit(dir, commit string) bool {
+	_, err := runCmd(dir, "git", "cat-file", "-e", commit+"^{commit}")
+	return err == nil
+}
+
+func runCmd(dir, bin string, args ...string) ([]byte, error) {
+	cmd := exec.Command(bin, args...)
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run %v %+v: %v\n%s", bin, args, err, output)
+	}
+	return output, nil
+}
@@ -0,0 +1,486 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/google/syzkaller/log"
+	"github.com/google/syzkaller/syz-dash/state"
+)
+
+func (dash *Dash) initHttp(addr string) {
+	http.HandleFunc("/", dash.httpSummary)
+	http.HandleFunc("/bug", dash.httpBug)
+	http.HandleFunc("/text", dash.httpText)
+	http.HandleFunc("/log", ServeHTTP)
+
+	ln, err := net.Listen("tcp4", addr)
+	if err != nil {
+		Fatalf("failed to listen on %v: %v", addr, err)
+	}
+	Logf(0, "serving http on http://%v", ln.Addr())
+	go func() {
+		err := http.Serve(ln, nil)
+		Fatalf("failed to serve http: %v", err)
+	}()
+}
+
+func (dash *Dash) httpSummary(w http.ResponseWriter, r *http.Request) {
+	data := &UISummaryData{
+		Log: CachedLogOutput(),
+	}
+	now := time.Now()
+	dash.mu.Lock()
+	for _, mgr := range dash.st.Managers {
+		data.Managers = append(data.Managers, UIManager{
+			Name:       mgr.Name,
+			Tag:        mgr.Tag,
+			LastReport: mgr.LastReport,
+			Online:     now.Sub(mgr.LastReport) < onlinePeriod,
+		})
+	}
+	for _, bug := range dash.st.Bugs {
+		if bug.Open() {
+			data.Open = append(data.Open, makeUIBug(bug))
+		} else {
+			data.Closed = append(data.Closed, makeUIBug(bug))
+		}
+	}
+	dash.mu.Unlock()
+	sort.Sort(UIManagerArray(data.Managers))
+	sort.Sort(UIBugArray(data.Open))
+	sort.Sort(UIBugArray(data.Closed))
+	if err := summaryTemplate.Execute(w, data); err != nil {
+		Logf(0, "failed to execute template: %v", err)
+		http.Error(w, fmt.Sprintf("failed to execute template: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+// httpBug shows a bug, POST requests change its status (status param)
+// or fix commits (commits param, one commit title per line).
+func (dash *Dash) httpBug(w http.ResponseWriter, r *http.Request) {
+	id := r.FormValue("id")
+	dash.mu.Lock()
+	defer dash.mu.Unlock()
+	bug := dash.st.Bugs[id]
+	if bug == nil {
+		http.Error(w, "no such bug", http.StatusNotFound)
+		return
+	}
+	if r.Method == "POST" {
+		var err error
+		if status := r.FormValue("status"); status != "" {
+			Logf(0, "bug %v '%v': status %v -> %v", bug.ID, bug.Title, bug.Status, status)
+			err = dash.st.SetStatus(id, status, time.Now())
+		} else {
+			var titles []string
+			for _, title := range strings.Split(r.FormValue("commits"), "\n") {
+				if title = strings.TrimSpace(title); title != "" {
+					titles = append(titles, title)
+				}
+			}
+			Logf(0, "bug %v '%v': fix commits %q", bug.ID, bug.Title, titles)
+			err = dash.st.SetFixCommits(id, titles)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Redirect(w, r, "/bug?id="+id, http.StatusSeeOther)
+		return
+	}
+	data := &UIBugData{
+		UIBug:    makeUIBug(bug),
+		Statuses: []string{state.StatusNew, state.StatusReported, state.StatusFixed, state.StatusInvalid},
+	}
+	for _, fix := range bug.FixCommits {
+		data.FixCommits = append(data.FixCommits, *fix)
+		data.FixTitles += fix.Title + "\n"
+	}
+	for tag, t := range bug.FixedBuilds {
+		data.FixedBuilds = append(data.FixedBuilds, UIFixedBuild{tag, t})
+	}
+	sort.Sort(UIFixedBuildArray(data.FixedBuilds))
+	for name, t := range bug.Managers {
+		data.CrashManagers = append(data.CrashManagers, UIManager{Name: name, LastReport: t})
+	}
+	sort.Sort(UIManagerArray(data.CrashManagers))
+	// Recent crashes first.
+	for i := len(bug.Crashes) - 1; i >= 0; i-- {
+		crash := bug.Crashes[i]
+		data.Crashes = append(data.Crashes, UICrash{
+			Index:     i,
+			Manager:   crash.Manager,
+			Tag:       crash.Tag,
+			Time:      crash.Time,
+			HasLog:    crash.Log != nil,
+			HasReport: crash.Report != nil,
+		})
+	}
+	data.ReproManager = bug.ReproManager
+	if err := bugTemplate.Execute(w, data); err != nil {
+		Logf(0, "failed to execute template: %v", err)
+		http.Error(w, fmt.Sprintf("failed to execute template: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+// httpText serves crash logs/reports (kind log or report with crash index)
+// and reproducers (kind syz, c or repro_report) of a bug.
+func (dash *Dash) httpText(w http.ResponseWriter, r *http.Request) {
+	dash.mu.Lock()
+	defer dash.mu.Unlock()
+	bug := dash.st.Bugs[r.FormValue("id")]
+	if bug == nil {
+		http.Error(w, "no such bug", http.StatusNotFound)
+		return
+	}
+	var text []byte
+	switch kind := r.FormValue("kind"); kind {
+	case "log", "report":
+		i, err := strconv.Atoi(r.FormValue("crash"))
+		if err != nil || i < 0 || i >= len(bug.Crashes) {
+			http.Error(w, "bad crash index", http.StatusBadRequest)
+			return
+		}
+		text = bug.Crashes[i].Log
+		if kind == "report" {
+			text = bug.Crashes[i].Report
+		}
+	case "syz":
+		text = bug.ReproSyz
+	case "c":
+		text = bug.ReproC
+	case "repro_report":
+		text = bug.ReproReport
+	default:
+		http.Error(w, "bad kind", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(text)
+}
+
+// Managers report every minute, so a manager that did not report for longer is most likely down.
+const onlinePeriod = 5 * time.Minute
+
+func makeUIBug(bug *state.Bug) UIBug {
+	ui := UIBug{
+		ID:         bug.ID,
+		Title:      bug.Title,
+		Seq:        bug.Seq,
+		Status:     bug.Status,
+		NumCrashes: bug.NumCrashes,
+		Managers:   len(bug.Managers),
+		FirstTime:  bug.FirstTime,
+		LastTime:   bug.LastTime,
+		Closed:     bug.Closed,
+		HasSyz:     bug.ReproSyz != nil,
+		HasC:       bug.ReproC != nil,
+	}
+	switch {
+	case len(bug.FixCommits) == 0:
+	case !bug.CrashAfterFix.IsZero():
+		ui.Fix = fmt.Sprintf("crashed with the fix on %v", bug.CrashAfterFix.Format("2006-01-02 15:04"))
+	case !bug.FixesFound():
+		ui.Fix = "fix not merged"
+	case len(bug.FixedBuilds) == 0:
+		ui.Fix = "fix not tested"
+	default:
+		ui.Fix = fmt.Sprintf("fix tested on %v kernels", len(bug.FixedBuilds))
+	}
+	return ui
+}
+
+func compileTemplate(html string) *template.Template {
+	return template.Must(template.New("").Parse(strings.Replace(html, "{{STYLE}}", htmlStyle, -1)))
+}
+
+type UISummaryData struct {
+	Managers []UIManager
+	Open     []UIBug
+	Closed   []UIBug
+	Log      string
+}
+
+type UIBugData struct {
+	UIBug
+	Statuses      []string
+	FixCommits    []state.FixCommit
+	FixTitles     string
+	FixedBuilds   []UIFixedBuild
+	CrashManagers []UIManager
+	Crashes       []UICrash
+	ReproManager  string
+}
+
+type UIManager struct {
+	Name       string
+	Tag        string
+	LastReport time.Time // last crash on the bug page
+	Online     bool
+}
+
+type UIBug struct {
+	ID         string
+	Title      string
+	Seq        int
+	Status     string
+	Fix        string // fix tracking status, empty if there are no fix commits
+	NumCrashes int
+	Managers   int
+	FirstTime  time.Time
+	LastTime   time.Time
+	Closed     time.Time
+	HasSyz     bool
+	HasC       bool
+}
+
+type UIFixedBuild struct {
+	Tag  string
+	Time time.Time
+}
+
+type UICrash struct {
+	Index     int
+	Manager   string
+	Tag       string
+	Time      time.Time
+	HasLog    bool
+	HasReport bool
+}
+
+type UIManagerArray []UIManager
+
+func (a UIManagerArray) Len() int           { return len(a) }
+func (a UIManagerArray) Less(i, j int) bool { return a[i].Name < a[j].Name }
+func (a UIManagerArray) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+type UIBugArray []UIBug
+
+func (a UIBugArray) Len() int           { return len(a) }
+func (a UIBugArray) Less(i, j int) bool { return a[i].LastTime.After(a[j].LastTime) }
+func (a UIBugArray) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+type UIFixedBuildArray []UIFixedBuild
+
+func (a UIFixedBuildArray) Len() int           { return len(a) }
+func (a UIFixedBuildArray) Less(i, j int) bool { return a[i].Time.Before(a[j].Time) }
+func (a UIFixedBuildArray) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+var summaryTemplate = compileTemplate(`
+<!doctype html>
+<html>
+<head>
+	<title>syz-dash</title>
+	{{STYLE}}
+</head>
+<body>
+<b>syz-dash</b>
+<br><br>
+
+<table>
+	<caption>Managers:</caption>
+	<tr>
+		<th>Name</th>
+		<th>Kernel</th>
+		<th>Last report</th>
+	</tr>
+	{{range $m := $.Managers}}
+	<tr>
+		<td>{{$m.Name}}</td>
+		<td>{{$m.Tag}}</td>
+		<td>{{$m.LastReport.Format "2006-01-02 15:04:05"}}{{if not $m.Online}} (offline){{end}}</td>
+	</tr>
+	{{end}}
+</table>
+<br><br>
+
+<b>Open bugs:</b>
+{{template "bugs" $.Open}}
+<br><br>
+<b>Closed bugs:</b>
+{{template "bugs" $.Closed}}
+<br><br>
+
+Log:
+<br>
+<textarea id="log_textarea" readonly rows="30">
+{{.Log}}
+</textarea>
+<script>
+	var textarea = document.getElementById("log_textarea");
+	textarea.scrollTop = textarea.scrollHeight;
+</script>
+
+</body></html>
+
+{{define "bugs"}}
+<table>
+	<tr>
+		<th>Title</th>
+		<th>Status</th>
+		<th>Fix</th>
+		<th>Count</th>
+		<th>Managers</th>
+		<th>First</th>
+		<th>Last</th>
+		<th>Repro</th>
+	</tr>
+	{{range $b := .}}
+	<tr>
+		<td><a href="/bug?id={{$b.ID}}">{{$b.Title}}{{if $b.Seq}} ({{$b.Seq}}){{end}}</a></td>
+		<td>{{$b.Status}}{{if not $b.Closed.IsZero}} {{$b.Closed.Format "2006-01-02"}}{{end}}</td>
+		<td>{{$b.Fix}}</td>
+		<td>{{$b.NumCrashes}}</td>
+		<td>{{$b.Managers}}</td>
+		<td>{{$b.FirstTime.Format "2006-01-02 15:04"}}</td>
+		<td>{{$b.LastTime.Format "2006-01-02 15:04"}}</td>
+		<td>{{if $b.HasC}}C{{else if $b.HasSyz}}syz{{end}}</td>
+	</tr>
+	{{end}}
+</table>
+{{end}}
+`)
+
+var bugTemplate = compileTemplate(`
+<!doctype html>
+<html>
+<head>
+	<title>{{.Title}} - syz-dash</title>
+	{{STYLE}}
+</head>
+<body>
+<a href="/">syz-dash</a>
+<br><br>
+<b>{{.Title}}{{if .Seq}} ({{.Seq}}){{end}}</b>
+<br><br>
+
+Status: {{.Status}}{{if not .Closed.IsZero}} since {{.Closed.Format "2006-01-02 15:04"}}{{end}}
+<form method="post" action="/bug?id={{.ID}}">
+	{{range $s := $.Statuses}}
+	<button type="submit" name="status" value="{{$s}}"{{if eq $s $.Status}} disabled{{end}}>{{$s}}</button>
+	{{end}}
+</form>
+<br>
+
+Crashes: {{.NumCrashes}}, first {{.FirstTime.Format "2006-01-02 15:04"}}, last {{.LastTime.Format "2006-01-02 15:04"}}
+<br><br>
+
+<table>
+	<caption>Fix commits: {{.Fix}}</caption>
+	<tr>
+		<th>Title</th>
+		<th>Repo</th>
+		<th>Commit</th>
+	</tr>
+	{{range $f := $.FixCommits}}
+	<tr>
+		<td>{{$f.Title}}</td>
+		<td>{{$f.Repo}}</td>
+		<td>{{$f.Hash}}</td>
+	</tr>
+	{{end}}
+</table>
+<form method="post" action="/bug?id={{.ID}}">
+	<textarea name="commits" rows="3" placeholder="fix commit titles, one per line">{{.FixTitles}}</textarea>
+	<br>
+	<input type="submit" value="set fix commits">
+</form>
+<br>
+
+{{if .FixedBuilds}}
+<table>
+	<caption>Kernels with the fix:</caption>
+	<tr>
+		<th>Kernel</th>
+		<th>Since</th>
+	</tr>
+	{{range $b := $.FixedBuilds}}
+	<tr>
+		<td>{{$b.Tag}}</td>
+		<td>{{$b.Time.Format "2006-01-02 15:04"}}</td>
+	</tr>
+	{{end}}
+</table>
+<br><br>
+{{end}}
+
+{{if .HasSyz}}
+Reproducer from {{.ReproManager}}:
+<a href="/text?id={{.ID}}&kind=syz">syz</a>
+{{if .HasC}}<a href="/text?id={{.ID}}&kind=c">C</a>{{end}}
+<a href="/text?id={{.ID}}&kind=repro_report">report</a>
+<br><br>
+{{end}}
+
+<table>
+	<caption>Managers:</caption>
+	<tr>
+		<th>Name</th>
+		<th>Last crash</th>
+	</tr>
+	{{range $m := $.CrashManagers}}
+	<tr>
+		<td>{{$m.Name}}</td>
+		<td>{{$m.LastReport.Format "2006-01-02 15:04:05"}}</td>
+	</tr>
+	{{end}}
+</table>
+<br><br>
+
+<table>
+	<caption>Recent crashes:</caption>
+	<tr>
+		<th>Time</th>
+		<th>Manager</th>
+		<th>Kernel</th>
+		<th>Log</th>
+		<th>Report</th>
+	</tr>
+	{{range $c := $.Crashes}}
+	<tr>
+		<td>{{$c.Time.Format "2006-01-02 15:04:05"}}</td>
+		<td>{{$c.Manager}}</td>
+		<td>{{$c.Tag}}</td>
+		<td>{{if $c.HasLog}}<a href="/text?id={{$.ID}}&kind=log&crash={{$c.Index}}">log</a>{{end}}</td>
+		<td>{{if $c.HasReport}}<a href="/text?id={{$.ID}}&kind=report&crash={{$c.Index}}">report</a>{{end}}</td>
+	</tr>
+	{{end}}
+</table>
+
+</body></html>
+`)
+
+const htmlStyle = `
+	<style type="text/css" media="screen">
+		table {
+			border-collapse:collapse;
+			border:1px solid;
+		}
+		table caption {
+			font-weight: bold;
+		}
+		table td {
+			border:1px solid;
+			padding: 3px;
+		}
+		table th {
+			border:1px solid;
+			padding: 3px;
+		}
+		textarea {
+			width:100%;
+		}
+	</style>
+`
@@ -0,0 +1,376 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+// Package state holds syz-dash bugs and their lifecycle:
+// a bug is created as new on the first crash with a title, a human moves it to reported
+// (e.g. after sending it upstream) or invalid, and attaches commits that fix it.
+// Once the fix commits appear in kernels that managers test and the crash stops occurring
+// on such kernels, the bug is closed as fixed. A crash with the same title on a kernel
+// that contains the fix creates a new bug.
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/syzkaller/db"
+	"github.com/google/syzkaller/hash"
+	. "github.com/google/syzkaller/log"
+)
+
+// Bug statuses.
+const (
+	StatusNew      = "new"
+	StatusReported = "reported"
+	StatusFixed    = "fixed"
+	StatusInvalid  = "invalid"
+)
+
+// maxCrashes is the number of recent crashes (logs and reports) kept for every bug.
+const maxCrashes = 10
+
+// State holds all bugs and managers known to syz-dash.
+// It is persisted to and can be restored from a directory.
+type State struct {
+	dir      string
+	Bugs     map[string]*Bug
+	Managers map[string]*Manager
+	bugsDB   *db.DB
+	mgrDB    *db.DB
+}
+
+// Bug is a group of crashes with the same title.
+type Bug struct {
+	ID     string
+	Title  string
+	Seq    int // number of the bug among bugs with the same title (they are created when fixes don't work)
+	Status string
+
+	FirstTime  time.Time
+	LastTime   time.Time
+	NumCrashes int
+	Managers   map[string]time.Time // manager -> last crash
+	Crashes    []*Crash             // up to maxCrashes recent crashes
+
+	ReproSyz     []byte
+	ReproC       []byte
+	ReproReport  []byte
+	ReproManager string
+
+	FixCommits []*FixCommit
+	// FixedBuilds are kernel commits (manager tags) that contain all fix commits,
+	// mapped to the time the dashboard learned about them.
+	FixedBuilds map[string]time.Time
+	// CrashAfterFix is the time of the last crash on a kernel that contains all fix commits.
+	// The bug is not closed automatically if it is set, the fix commits seem to be wrong.
+	CrashAfterFix time.Time
+	Closed        time.Time
+}
+
+// FixCommit is a commit that fixes a bug. Commits are identified by titles,
+// because the same commit has different hashes in different trees.
+type FixCommit struct {
+	Title string
+	Repo  string // repo where the commit was found, empty if it was not found yet
+	Hash  string // commit hash in Repo
+}
+
+// Crash is a single occurrence of a bug.
+type Crash struct {
+	Manager string
+	Tag     string // kernel commit the manager tested
+	Time    time.Time
+	Log     []byte
+	Report  []byte
+}
+
+// Manager is a syz-manager instance that reports to the dashboard.
+type Manager struct {
+	Name       string
+	Tag        string // kernel commit the manager tests
+	LastReport time.Time
+}
+
+// Make creates State and initializes it from dir.
+func Make(dir string) (*State, error) {
+	st := &State{
+		dir:      dir,
+		Bugs:     make(map[string]*Bug),
+		Managers: make(map[string]*Manager),
+	}
+	os.MkdirAll(st.dir, 0750)
+	var err error
+	st.bugsDB, err = db.Open(filepath.Join(st.dir, "bugs.db"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bug database: %v", err)
+	}
+	for key, rec := range st.bugsDB.Records {
+		bug := new(Bug)
+		if err := json.Unmarshal(rec.Val, bug); err != nil {
+			Logf(0, "bad bug record %v: %v", key, err)
+			st.bugsDB.Delete(key)
+			continue
+		}
+		st.Bugs[bug.ID] = bug
+	}
+	if err := st.bugsDB.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to flush bug database: %v", err)
+	}
+	st.mgrDB, err = db.Open(filepath.Join(st.dir, "managers.db"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open manager database: %v", err)
+	}
+	for key, rec := range st.mgrDB.Records {
+		mgr := new(Manager)
+		if err := json.Unmarshal(rec.Val, mgr); err != nil {
+			Logf(0, "bad manager record %v: %v", key, err)
+			st.mgrDB.Delete(key)
+			continue
+		}
+		st.Managers[mgr.Name] = mgr
+	}
+	if err := st.mgrDB.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to flush manager database: %v", err)
+	}
+	return st, nil
+}
+
+// Open returns true if the bug is not closed.
+func (bug *Bug) Open() bool {
+	return bug.Status == StatusNew || bug.Status == StatusReported
+}
+
+// FixesFound returns true if the bug has fix commits and all of them were found in repos.
+func (bug *Bug) FixesFound() bool {
+	for _, fix := range bug.FixCommits {
+		if fix.Hash == "" {
+			return false
+		}
+	}
+	return len(bug.FixCommits) != 0
+}
+
+// Report records a report from a manager, it is sent periodically even if there are no new crashes.
+func (st *State) Report(name, tag string, now time.Time) error {
+	mgr := st.Managers[name]
+	if mgr == nil {
+		mgr = &Manager{Name: name}
+		st.Managers[name] = mgr
+	}
+	if mgr.Tag == tag && now.Sub(mgr.LastReport) < time.Hour {
+		// Don't rewrite the database every minute.
+		mgr.LastReport = now
+		return nil
+	}
+	mgr.Tag = tag
+	mgr.LastReport = now
+	data, err := json.Marshal(mgr)
+	if err != nil {
+		return err
+	}
+	st.mgrDB.Save(name, data, 0)
+	return st.mgrDB.Flush()
+}
+
+// AddCrash records count crashes with the title that happened on the manager testing kernel commit tag.
+func (st *State) AddCrash(name, tag, title string, count int, log, report []byte, now time.Time) error {
+	bug := st.bugForCrash(title, tag)
+	if bug.FirstTime.IsZero() {
+		bug.FirstTime = now
+	}
+	bug.LastTime = now
+	bug.NumCrashes += count
+	bug.Managers[name] = now
+	if _, ok := bug.FixedBuilds[tag]; ok && tag != "" && bug.Open() {
+		Logf(0, "bug %v '%v' happened on %v that contains the fix", bug.ID, bug.Title, tag)
+		bug.CrashAfterFix = now
+	}
+	if log != nil || report != nil {
+		bug.Crashes = append(bug.Crashes, &Crash{
+			Manager: name,
+			Tag:     tag,
+			Time:    now,
+			Log:     log,
+			Report:  report,
+		})
+		if len(bug.Crashes) > maxCrashes {
+			bug.Crashes = bug.Crashes[len(bug.Crashes)-maxCrashes:]
+		}
+	}
+	return st.saveBug(bug)
+}
+
+// AddRepro records a reproducer for the crash with the title.
+// The reproducer replaces the existing one only if it has a C reproducer and the existing one has not.
+func (st *State) AddRepro(name, tag, title string, syz, c, report []byte, now time.Time) error {
+	bug := st.bugForCrash(title, tag)
+	if bug.ReproSyz != nil && (bug.ReproC != nil || c == nil) {
+		return nil
+	}
+	bug.ReproSyz = syz
+	bug.ReproC = c
+	bug.ReproReport = report
+	bug.ReproManager = name
+	return st.saveBug(bug)
+}
+
+// bugForCrash returns the bug a crash belongs to, creating a new bug if necessary:
+// crashes go to the open bug with the title, or to the last closed one unless it is fixed
+// and the crash happened on a kernel with the fix (or the bug was marked as fixed without fix commits).
+func (st *State) bugForCrash(title, tag string) *Bug {
+	var last *Bug
+	for _, bug := range st.Bugs {
+		if bug.Title != title {
+			continue
+		}
+		if bug.Open() {
+			return bug
+		}
+		if last == nil || last.Seq < bug.Seq {
+			last = bug
+		}
+	}
+	seq := 0
+	if last != nil {
+		_, fixedBuild := last.FixedBuilds[tag]
+		if last.Status != StatusFixed || len(last.FixCommits) != 0 && (!fixedBuild || tag == "") {
+			return last
+		}
+		Logf(0, "bug %v '%v' happened again on %v after it was fixed", last.ID, last.Title, tag)
+		seq = last.Seq + 1
+	}
+	bug := &Bug{
+		ID:          bugID(title, seq),
+		Title:       title,
+		Seq:         seq,
+		Status:      StatusNew,
+		Managers:    make(map[string]time.Time),
+		FixedBuilds: make(map[string]time.Time),
+	}
+	st.Bugs[bug.ID] = bug
+	return bug
+}
+
+func bugID(title string, seq int) string {
+	return hash.String([]byte(fmt.Sprintf("%v\n%v", title, seq)))
+}
+
+// SetStatus changes the bug status by a human.
+func (st *State) SetStatus(id, status string, now time.Time) error {
+	bug := st.Bugs[id]
+	if bug == nil {
+		return fmt.Errorf("no bug %v", id)
+	}
+	switch status {
+	case StatusNew, StatusReported, StatusFixed, StatusInvalid:
+	default:
+		return fmt.Errorf("bad bug status %q", status)
+	}
+	reopen := !bug.Open() && (status == StatusNew || status == StatusReported)
+	if reopen {
+		for _, other := range st.Bugs {
+			if other.Title == bug.Title && other.Open() {
+				return fmt.Errorf("bug %v with the same title is open", other.ID)
+			}
+		}
+		bug.Closed = time.Time{}
+	} else if bug.Open() && (status == StatusFixed || status == StatusInvalid) {
+		bug.Closed = now
+	}
+	bug.Status = status
+	return st.saveBug(bug)
+}
+
+// SetFixCommits sets titles of commits that fix the bug.
+// Fix tracking starts from scratch, commits need to be found in repos again.
+func (st *State) SetFixCommits(id string, titles []string) error {
+	bug := st.Bugs[id]
+	if bug == nil {
+		return fmt.Errorf("no bug %v", id)
+	}
+	bug.FixCommits = nil
+	for _, title := range titles {
+		bug.FixCommits = append(bug.FixCommits, &FixCommit{Title: title})
+	}
+	bug.FixedBuilds = make(map[string]time.Time)
+	bug.CrashAfterFix = time.Time{}
+	return st.saveBug(bug)
+}
+
+// SetFixHash records that the fix commit with the title was found in the repo.
+func (st *State) SetFixHash(id, title, repo, hash string) error {
+	bug := st.Bugs[id]
+	if bug == nil {
+		return fmt.Errorf("no bug %v", id)
+	}
+	for _, fix := range bug.FixCommits {
+		if fix.Title == title {
+			fix.Repo = repo
+			fix.Hash = hash
+		}
+	}
+	return st.saveBug(bug)
+}
+
+// AddFixedBuild records that kernel commit tag contains all fix commits of the bug.
+func (st *State) AddFixedBuild(id, tag string, now time.Time) error {
+	bug := st.Bugs[id]
+	if bug == nil {
+		return fmt.Errorf("no bug %v", id)
+	}
+	if _, ok := bug.FixedBuilds[tag]; ok {
+		return nil
+	}
+	bug.FixedBuilds[tag] = now
+	return st.saveBug(bug)
+}
+
+// CloseFixed closes open bugs with fix commits that were tested by managers for closeAfter
+// (since the first kernel with the fix) without crashes, and returns the closed bugs.
+func (st *State) CloseFixed(now time.Time, closeAfter time.Duration) ([]*Bug, error) {
+	var closed []*Bug
+	for _, bug := range st.Bugs {
+		if !bug.Open() || !bug.FixesFound() || !bug.CrashAfterFix.IsZero() || len(bug.FixedBuilds) == 0 {
+			continue
+		}
+		var first time.Time
+		for _, t := range bug.FixedBuilds {
+			if first.IsZero() || first.After(t) {
+				first = t
+			}
+		}
+		if now.Sub(first) < closeAfter {
+			continue
+		}
+		bug.Status = StatusFixed
+		bug.Closed = now
+		if err := st.saveBug(bug); err != nil {
+			return closed, err
+		}
+		closed = append(closed, bug)
+	}
+	return closed, nil
+}
+
+// Compact reclaims space occupied by old versions of records.
+func (st *State) Compact() error {
+	if err := st.bugsDB.Compact(); err != nil {
+		return fmt.Errorf("failed to compact bug database: %v", err)
+	}
+	if err := st.mgrDB.Compact(); err != nil {
+		return fmt.Errorf("failed to compact manager database: %v", err)
+	}
+	return nil
+}
+
+func (st *State) saveBug(bug *Bug) error {
+	data, err := json.Marshal(bug)
+	if err != nil {
+		return err
+	}
+	st.bugsDB.Save(bug.ID, data, 0)
+	return st.bugsDB.Flush()
+}
@@ -0,0 +1,203 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package state
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func makeState(t *testing.T) (*State, string) {
+	dir, err := ioutil.TempDir("", "syz-dash-state-test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	st, err := Make(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("failed to make state: %v", err)
+	}
+	return st, dir
+}
+
+func onlyBug(t *testing.T, st *State, title string, status string) *Bug {
+	var res *Bug
+	for _, bug := range st.Bugs {
+		if bug.Title == title && bug.Status == status {
+			if res != nil {
+				t.Fatalf("several %v bugs '%v'", status, title)
+			}
+			res = bug
+		}
+	}
+	if res == nil {
+		t.Fatalf("no %v bug '%v'", status, title)
+	}
+	return res
+}
+
+func TestLifecycle(t *testing.T) {
+	st, dir := makeState(t)
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	const title = "KASAN: use-after-free in foo"
+	if err := st.AddCrash("mgr0", "old", title, 2, []byte("log"), []byte("report"), now); err != nil {
+		t.Fatalf("failed to add crash: %v", err)
+	}
+	if err := st.AddCrash("mgr1", "old", title, 1, nil, nil, now); err != nil {
+		t.Fatalf("failed to add crash: %v", err)
+	}
+	bug := onlyBug(t, st, title, StatusNew)
+	if bug.NumCrashes != 3 || len(bug.Managers) != 2 || len(bug.Crashes) != 1 {
+		t.Fatalf("bad bug: crashes %v, managers %v, logs %v", bug.NumCrashes, len(bug.Managers), len(bug.Crashes))
+	}
+	if err := st.AddRepro("mgr1", "old", title, []byte("syz"), nil, nil, now); err != nil {
+		t.Fatalf("failed to add repro: %v", err)
+	}
+	if err := st.AddRepro("mgr0", "old", title, []byte("syz2"), []byte("c"), nil, now); err != nil {
+		t.Fatalf("failed to add repro: %v", err)
+	}
+	if string(bug.ReproSyz) != "syz2" || string(bug.ReproC) != "c" || bug.ReproManager != "mgr0" {
+		t.Fatalf("C reproducer did not replace syz reproducer: %q %q %v", bug.ReproSyz, bug.ReproC, bug.ReproManager)
+	}
+
+	if err := st.SetStatus(bug.ID, StatusReported, now); err != nil {
+		t.Fatalf("failed to set status: %v", err)
+	}
+	if err := st.SetStatus(bug.ID, "foo", now); err == nil {
+		t.Fatalf("set bad status")
+	}
+	if err := st.SetFixCommits(bug.ID, []string{"foo: fix use-after-free"}); err != nil {
+		t.Fatalf("failed to set fix commits: %v", err)
+	}
+	if closed, err := st.CloseFixed(now.Add(30*24*time.Hour), time.Hour); err != nil || len(closed) != 0 {
+		t.Fatalf("closed bug without the fix in repos: %v, %v", closed, err)
+	}
+	if err := st.SetFixHash(bug.ID, "foo: fix use-after-free", "linux", "abcd"); err != nil {
+		t.Fatalf("failed to set fix hash: %v", err)
+	}
+	if closed, err := st.CloseFixed(now.Add(30*24*time.Hour), time.Hour); err != nil || len(closed) != 0 {
+		t.Fatalf("closed bug without tested fix: %v, %v", closed, err)
+	}
+	if err := st.AddFixedBuild(bug.ID, "new", now); err != nil {
+		t.Fatalf("failed to add fixed build: %v", err)
+	}
+	// Old kernels still crash, this does not prevent closing.
+	if err := st.AddCrash("mgr0", "old", title, 1, nil, nil, now); err != nil {
+		t.Fatalf("failed to add crash: %v", err)
+	}
+	if closed, err := st.CloseFixed(now.Add(time.Minute), time.Hour); err != nil || len(closed) != 0 {
+		t.Fatalf("closed bug too early: %v, %v", closed, err)
+	}
+	if closed, err := st.CloseFixed(now.Add(2*time.Hour), time.Hour); err != nil || len(closed) != 1 || closed[0] != bug {
+		t.Fatalf("did not close fixed bug: %v, %v", closed, err)
+	}
+	if bug.Status != StatusFixed || bug.Closed.IsZero() {
+		t.Fatalf("bad closed bug: status %v, closed %v", bug.Status, bug.Closed)
+	}
+
+	// Crashes on old kernels go to the fixed bug, crashes on kernels with the fix create a new bug.
+	if err := st.AddCrash("mgr1", "old", title, 1, nil, nil, now); err != nil {
+		t.Fatalf("failed to add crash: %v", err)
+	}
+	if len(st.Bugs) != 1 || bug.NumCrashes != 5 {
+		t.Fatalf("crash on old kernel was not added to fixed bug: %v bugs, %v crashes", len(st.Bugs), bug.NumCrashes)
+	}
+	if err := st.AddCrash("mgr1", "new", title, 1, nil, nil, now); err != nil {
+		t.Fatalf("failed to add crash: %v", err)
+	}
+	bug2 := onlyBug(t, st, title, StatusNew)
+	if bug2.ID == bug.ID || bug2.Seq != 1 || bug2.NumCrashes != 1 {
+		t.Fatalf("bad new bug: id %v, seq %v, crashes %v", bug2.ID, bug2.Seq, bug2.NumCrashes)
+	}
+	if err := st.SetStatus(bug.ID, StatusReported, now); err == nil {
+		t.Fatalf("reopened bug while another bug with the same title is open")
+	}
+
+	// The state is restored from the directory.
+	st1, err := Make(dir)
+	if err != nil {
+		t.Fatalf("failed to make state: %v", err)
+	}
+	if len(st1.Bugs) != 2 {
+		t.Fatalf("restored %v bugs, want 2", len(st1.Bugs))
+	}
+	restored := onlyBug(t, st1, title, StatusFixed)
+	if restored.NumCrashes != 5 || len(restored.FixedBuilds) != 1 || !restored.FixesFound() {
+		t.Fatalf("bad restored bug: %+v", restored)
+	}
+}
+
+func TestCrashAfterFix(t *testing.T) {
+	st, dir := makeState(t)
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	const title = "WARNING in bar"
+	if err := st.AddCrash("mgr0", "old", title, 1, nil, nil, now); err != nil {
+		t.Fatalf("failed to add crash: %v", err)
+	}
+	bug := onlyBug(t, st, title, StatusNew)
+	if err := st.SetFixCommits(bug.ID, []string{"bar: fix warning"}); err != nil {
+		t.Fatalf("failed to set fix commits: %v", err)
+	}
+	if err := st.SetFixHash(bug.ID, "bar: fix warning", "linux", "abcd"); err != nil {
+		t.Fatalf("failed to set fix hash: %v", err)
+	}
+	if err := st.AddFixedBuild(bug.ID, "new", now); err != nil {
+		t.Fatalf("failed to add fixed build: %v", err)
+	}
+	if err := st.AddCrash("mgr0", "new", title, 1, nil, nil, now); err != nil {
+		t.Fatalf("failed to add crash: %v", err)
+	}
+	if len(st.Bugs) != 1 || bug.CrashAfterFix.IsZero() {
+		t.Fatalf("crash on kernel with the fix is not recorded")
+	}
+	if closed, err := st.CloseFixed(now.Add(30*24*time.Hour), time.Hour); err != nil || len(closed) != 0 {
+		t.Fatalf("closed bug that happens with the fix: %v, %v", closed, err)
+	}
+	// Fixing the fix commits starts tracking from scratch.
+	if err := st.SetFixCommits(bug.ID, []string{"bar: really fix warning"}); err != nil {
+		t.Fatalf("failed to set fix commits: %v", err)
+	}
+	if !bug.CrashAfterFix.IsZero() || len(bug.FixedBuilds) != 0 || bug.FixesFound() {
+		t.Fatalf("fix tracking is not reset")
+	}
+}
+
+func TestManualFix(t *testing.T) {
+	st, dir := makeState(t)
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	const title = "INFO: task hung in baz"
+	if err := st.AddCrash("mgr0", "", title, 1, nil, nil, now); err != nil {
+		t.Fatalf("failed to add crash: %v", err)
+	}
+	bug := onlyBug(t, st, title, StatusNew)
+	if err := st.SetStatus(bug.ID, StatusInvalid, now); err != nil {
+		t.Fatalf("failed to set status: %v", err)
+	}
+	// Invalid bugs absorb crashes.
+	if err := st.AddCrash("mgr0", "", title, 1, nil, nil, now); err != nil {
+		t.Fatalf("failed to add crash: %v", err)
+	}
+	if len(st.Bugs) != 1 || bug.NumCrashes != 2 {
+		t.Fatalf("crash was not added to invalid bug")
+	}
+	// Bugs fixed without fix commits are considered fixed in all kernels.
+	if err := st.SetStatus(bug.ID, StatusFixed, now); err != nil {
+		t.Fatalf("failed to set status: %v", err)
+	}
+	if err := st.AddCrash("mgr0", "", title, 1, nil, nil, now); err != nil {
+		t.Fatalf("failed to add crash: %v", err)
+	}
+	onlyBug(t, st, title, StatusNew)
+	if len(st.Bugs) != 2 {
+		t.Fatalf("crash after manual fix did not create a new bug")
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"sort"
 	"strings"
 
@@ -19,7 +20,9 @@ import (
 var (
 	flagLinux    = flag.String("linux", "", "path to linux kernel source checkout")
 	flagLinuxBld = flag.String("linuxbld", "", "path to linux kernel build directory")
-	flagArch     = flag.String("arch", "", "arch to generate")
+	flagArch     = flag.String("arch", "", "comma-separated list of archs to generate")
+	flagBuild    = flag.Bool("build", false, "configure and build kernel headers for each arch before extraction")
+	flagMissing  = flag.Bool("allowmissing", false, "don't fail on constants that are missing for some archs")
 	flagV        = flag.Int("v", 0, "verbosity")
 )
 
@@ -28,12 +31,14 @@ type Arch struct {
 	KernelHeaderArch string
 	KernelInclude    string
 	CFlags           []string
+	KernelArch       string // ARCH for kernel make
+	CrossCompile     string // CROSS_COMPILE for kernel make
 }
 
 var archs = map[string]*Arch{
-	"amd64":   {[]string{"__x86_64__"}, "x86", "asm/unistd.h", []string{"-m64"}},
-	"arm64":   {[]string{"__aarch64__"}, "arm64", "asm/unistd.h", []string{}},
-	"ppc64le": {[]string{"__ppc64__", "__PPC64__", "__powerpc64__"}, "powerpc", "asm/unistd.h", []string{"-D__powerpc64__"}},
+	"amd64":   {[]string{"__x86_64__"}, "x86", "asm/unistd.h", []string{"-m64"}, "x86_64", "x86_64-linux-gnu-"},
+	"arm64":   {[]string{"__aarch64__"}, "arm64", "asm/unistd.h", []string{}, "arm64", "aarch64-linux-gnu-"},
+	"ppc64le": {[]string{"__ppc64__", "__PPC64__", "__powerpc64__"}, "powerpc", "asm/unistd.h", []string{"-D__powerpc64__"}, "powerpc", "powerpc64le-linux-gnu-"},
 }
 
 func main() {
@@ -48,30 +53,86 @@ func main() {
 	if *flagArch == "" {
 		failf("-arch flag is required")
 	}
-	if archs[*flagArch] == nil {
-		failf("unknown arch %v", *flagArch)
+	archNames := strings.Split(*flagArch, ",")
+	for _, arch := range archNames {
+		if archs[arch] == nil {
+			failf("unknown arch %v", arch)
+		}
 	}
-	if len(flag.Args()) != 1 {
-		failf("usage: syz-extract -linux=/linux/checkout -arch=arch sys/input_file.txt")
+	if len(flag.Args()) == 0 {
+		failf("usage: syz-extract -linux=/linux/checkout -arch=arch1,arch2 sys/input_file1.txt sys/input_file2.txt")
 	}
 
-	inname := flag.Args()[0]
-	outname := strings.TrimSuffix(inname, ".txt") + "_" + *flagArch + ".const"
-
-	inf, err := os.Open(inname)
-	if err != nil {
-		failf("failed to open input file: %v", err)
+	descs := make(map[string]*Description)
+	for _, inname := range flag.Args() {
+		descs[inname] = ParseFiles([]string{inname})
+	}
+	// missing[file][const] is the list of archs the const is missing for.
+	missing := make(map[string]map[string][]string)
+	for _, arch := range archNames {
+		if *flagBuild {
+			buildKernel(arch, archs[arch])
+		}
+		for _, inname := range flag.Args() {
+			logf(0, "extracting %v for %v", inname, arch)
+			consts, vals := compileConsts(archs[arch], descs[inname])
+			for _, v := range vals {
+				if _, ok := consts[v]; ok {
+					continue
+				}
+				if missing[inname] == nil {
+					missing[inname] = make(map[string][]string)
+				}
+				missing[inname][v] = append(missing[inname][v], arch)
+			}
+			out := new(bytes.Buffer)
+			generateConsts(arch, consts, out)
+			outname := strings.TrimSuffix(inname, ".txt") + "_" + arch + ".const"
+			if err := ioutil.WriteFile(outname, out.Bytes(), 0660); err != nil {
+				failf("failed to write output file: %v", err)
+			}
+		}
+	}
+	if reportMissing(missing) && !*flagMissing {
+		failf("some constants are missing, fix the descriptions or rerun with -allowmissing")
 	}
-	defer inf.Close()
+}
 
-	desc := Parse(inf)
-	consts := compileConsts(archs[*flagArch], desc)
+// buildKernel configures the kernel for arch and builds enough of it
+// to produce generated headers required by the extraction.
+func buildKernel(name string, arch *Arch) {
+	logf(0, "building kernel headers for %v", name)
+	args := []string{"-C", *flagLinux, "ARCH=" + arch.KernelArch, "CROSS_COMPILE=" + arch.CrossCompile}
+	if *flagLinuxBld != *flagLinux {
+		args = append(args, "O="+*flagLinuxBld)
+	}
+	for _, target := range []string{"defconfig", "init/main.o"} {
+		cmd := exec.Command("make", append(args, target)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			failf("make %v for %v failed: %v\n%s", target, name, err, out)
+		}
+	}
+}
 
-	out := new(bytes.Buffer)
-	generateConsts(*flagArch, consts, out)
-	if err := ioutil.WriteFile(outname, out.Bytes(), 0660); err != nil {
-		failf("failed to write output file: %v", err)
+// reportMissing prints constants that are missing for some archs
+// and returns whether there are any.
+func reportMissing(missing map[string]map[string][]string) bool {
+	var files []string
+	for inname := range missing {
+		files = append(files, inname)
+	}
+	sort.Strings(files)
+	for _, inname := range files {
+		var vals []string
+		for v := range missing[inname] {
+			vals = append(vals, v)
+		}
+		sort.Strings(vals)
+		for _, v := range vals {
+			fmt.Fprintf(os.Stderr, "%v: const %v is missing for %v\n", inname, v, strings.Join(missing[inname][v], ", "))
+		}
 	}
+	return len(files) != 0
 }
 
 func generateConsts(arch string, consts map[string]uint64, out io.Writer) {
@@ -87,7 +148,8 @@ func generateConsts(arch string, consts map[string]uint64, out io.Writer) {
 	}
 }
 
-func compileConsts(arch *Arch, desc *Description) map[string]uint64 {
+// compileConsts returns values of constants used in desc and the list of all requested constants.
+func compileConsts(arch *Arch, desc *Description) (map[string]uint64, []string) {
 	vals := make(map[string]bool)
 	for _, fvals := range desc.Flags {
 		for _, v := range fvals {
@@ -118,14 +180,14 @@ func compileConsts(arch *Arch, desc *Description) map[string]uint64 {
 		valArr = append(valArr, v)
 	}
 	if len(valArr) == 0 {
-		return nil
+		return nil, nil
 	}
 
 	consts, err := fetchValues(arch.KernelHeaderArch, valArr, append(desc.Includes, arch.KernelInclude), desc.Defines, arch.CFlags)
 	if err != nil {
 		failf("%v", err)
 	}
-	return consts
+	return consts, valArr
 }
 
 func isIdentifier(s string) bool {
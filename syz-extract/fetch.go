@@ -40,7 +40,7 @@ func fetchValues(arch string, vals []string, includes []string, defines map[stri
 			for _, match := range matches {
 				val := string(match[1])
 				if !undeclared[val] && valMap[val] {
-					logf(0, "undefined const: %v", val)
+					logf(1, "undefined const: %v", val)
 					undeclared[val] = true
 				}
 			}
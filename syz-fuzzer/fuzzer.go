@@ -12,6 +12,7 @@ import (
 	"crypto/sha1"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"math/rand"
 	"net/http"
 	_ "net/http/pprof"
@@ -19,6 +20,7 @@ import (
 	"net/rpc/jsonrpc"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"runtime"
 	"runtime/debug"
 	"strconv"
@@ -45,10 +47,63 @@ var (
 	flagLeak     = flag.Bool("leak", false, "detect memory leaks")
 	flagOutput   = flag.String("output", "stdout", "write programs to none/stdout/dmesg/file")
 	flagPprof    = flag.String("pprof", "", "address to serve pprof profiles")
+
+	flagLeakPeriod = flag.Duration("leak_period", time.Minute, "period of memory leak checking")
+
+	flagFaults       = flag.Bool("faults", false, "inject faults into new corpus inputs")
+	flagFaultNth     = flag.Int("fault_nth", 100, "max fault site index per call")
+	flagFaultPercent = flag.Int("fault_percent", 100, "percent of new inputs to inject faults into")
+
+	flagMutations  = flag.String("mutations", "", "comma-separated list of mutation strategies with weights (e.g. insert_call:60,splice:1)")
+	flagAddrLayout = flag.String("addr_layout", "", "comma-separated list of address space shaping features (gaps, scatter, unaligned)")
+	flagCleanup    = flag.Bool("cleanup_calls", false, "append destructor calls for created resources to half of generated programs")
+
+	flagMinFreeMem    = flag.Int("min_free_mem", 64, "restart executors and drop caches when available memory is below this (in MB, 0 to disable)")
+	flagMaxRssPercent = flag.Int("max_rss_percent", 25, "restart executors and drop caches when RSS of executors exceeds this percent of total memory (0 to disable)")
+
+	flagSlowCall = flag.Duration("slow_call", 100*time.Millisecond, "calls that take longer are considered slow")
+
+	flagResultSample = flag.Int("result_sample", 100, "send every n-th call result (errno, coverage, duration) to manager (0 to disable)")
 )
 
 const (
 	programLength = 30
+	maxPoolPaths  = 1000 // max number of discovered paths in a filename pool
+	maxHintExecs  = 1000 // max number of hint executions per new input
+	leakWindowLen = 100  // max number of recent programs dumped with a leak report
+
+	// A syscall is deprioritized if at least half of its executions are slow,
+	// and disabled if all of its executions fail with ENOSYS/EPERM
+	// (after it was executed at least slowCallMinExecs times).
+	slowCallMinExecs  = 100
+	slowCallPrioScale = 0.1
+
+	// Rarely chosen syscalls don't reach slowCallMinExecs executions for a long time,
+	// so every enabled syscall is also probed probeExecs times with random arguments
+	// at start and then every probePeriod (syscalls can become unavailable during fuzzing,
+	// e.g. when a program changes a sysctl). Syscalls that fail with ENOSYS/EPERM
+	// in all probe executions are disabled.
+	probeExecs  = 3
+	probePeriod = 30 * time.Minute
+
+	// Syscalls that recently produced new coverage are chosen more frequently.
+	// Yield of a syscall is a moving average of the number of new coverage PCs per fuzzing execution,
+	// every yieldPeriod the average over the period is merged into it with weight 1 - yieldDecay
+	// (so that stale winners don't dominate forever).
+	// Choice table priorities are multiplied by 1 + yieldBoost * yield / max yield.
+	yieldBoost  = 3
+	yieldDecay  = 0.5
+	yieldPeriod = time.Minute
+
+	// Calls that are rejected at argument validation (e.g. fail with EINVAL, see ipc.CallInfo.Result)
+	// in at least half of executions (after slowCallMinExecs executions) have strict argument constraints.
+	// When such call is rejected in a fuzzed program, we retry the program with mutated arguments
+	// of the call up to maxRejectRetries times before giving up on the call,
+	// but no more than maxRejectExecs times in total.
+	maxRejectRetries = 3
+	maxRejectExecs   = 10
+
+	maxCallResults = 1000 // max number of sampled call results sent to manager in one poll
 )
 
 type Sig [sha1.Size]byte
@@ -60,7 +115,7 @@ func hash(data []byte) Sig {
 type Input struct {
 	p         *prog.Prog
 	call      int
-	cover     cover.Cover
+	cover     cover.Cover // signal of the call (see callSignal)
 	minimized bool
 }
 
@@ -72,6 +127,7 @@ type Candidate struct {
 var (
 	manager *rpc.Client
 
+	// corpusCover, maxCover and flakes contain edge signal instead of PCs if edgeSignal is set.
 	coverMu     sync.RWMutex
 	corpusCover []cover.Cover
 	maxCover    []cover.Cover
@@ -79,6 +135,7 @@ var (
 
 	corpusMu     sync.RWMutex
 	corpus       []*prog.Prog
+	corpusSigs   []prog.CoverSig // coverage signatures of corpus programs, used for splicing
 	corpusHashes map[Sig]struct{}
 
 	triageMu   sync.RWMutex
@@ -92,10 +149,47 @@ var (
 	statExecCandidate uint64
 	statExecTriage    uint64
 	statExecMinimize  uint64
+	statExecFault     uint64
+	statExecHints     uint64
+	statExecProbe     uint64
 	statNewInput      uint64
+	statMemWatchdog   uint64
+	statExecRejected  uint64
+	statCallResults   uint64 // number of executed calls, used for sampling of call results
+
+	allTriaged     uint32
+	noCover        bool
+	edgeSignal     bool
+	compsSupported bool
+
+	// Per-syscall execution statistics indexed by syscall ID.
+	callExecs    []uint64
+	callSlow     []uint64
+	callSlowPoll []uint64 // slow executions since the last poll of manager
+	callNoSys    []uint64
+	callNoPerm   []uint64
+	callRejected []uint64
+	callProbe    []uint32 // outcome of the last probe (probeNoSys/probeNoPerm), reset by updateCallStats
+	slowCalls    map[int]bool
+
+	probeQueue chan []*sys.Call // batches of syscalls to probe, executed by procs
+
+	mutator *prog.Mutator
+	dict    *prog.Dictionary
+
+	procYields  []procYield // per-proc yield statistics since the last update of yields
+	callYield   []float64   // modified only by the main goroutine
+	callWeights []float32   // current choice table weights based on yield
 
-	allTriaged uint32
-	noCover    bool
+	// ctCalls is the set of currently enabled syscalls,
+	// it is modified only by the main goroutine.
+	ctMu    sync.RWMutex
+	ct      *prog.ChoiceTable
+	prios   [][]float32
+	ctCalls map[*sys.Call]bool
+
+	resultsMu   sync.Mutex
+	callResults []RpcCallResult // sampled call results since the last poll of manager
 )
 
 func main() {
@@ -130,6 +224,7 @@ func main() {
 	corpusCover = make([]cover.Cover, sys.CallCount)
 	maxCover = make([]cover.Cover, sys.CallCount)
 	corpusHashes = make(map[Sig]struct{})
+	initCallStats(*flagProcs)
 
 	Logf(0, "dialing manager at %v", *flagManager)
 	conn, err := jsonrpc.Dial("tcp", *flagManager)
@@ -137,19 +232,38 @@ func main() {
 		panic(err)
 	}
 	manager = conn
-	a := &ConnectArgs{*flagName}
+	a := &ConnectArgs{Name: *flagName, Caps: Caps}
 	r := &ConnectRes{}
 	if err := manager.Call("Manager.Connect", a, r); err != nil {
 		panic(err)
 	}
 	calls := buildCallList(r.EnabledCalls)
-	ct := prog.BuildChoiceTable(r.Prios, calls)
+	prios = r.Prios
+	ctCalls = make(map[*sys.Call]bool)
+	for c := range calls {
+		ctCalls[c] = true
+	}
+	ct = prog.BuildChoiceTable(prios, calls)
 
 	if r.NeedCheck {
-		a := &CheckArgs{Name: *flagName}
-		if fd, err := syscall.Open("/sys/kernel/debug/kcov", syscall.O_RDWR, 0); err == nil {
-			syscall.Close(fd)
-			a.Kcov = true
+		info, err := host.DetectMachineInfo()
+		if err != nil {
+			Fatalf("failed to detect machine features: %v", err)
+		}
+		machine := RpcMachineInfo(*info)
+		a := &CheckArgs{
+			Name:           *flagName,
+			Kcov:           info.Kcov,
+			FaultInjection: info.FaultInjection,
+			UsbEmulation:   info.UsbEmulation,
+			Machine:        &machine,
+		}
+		if modules, err := host.Modules(); err != nil {
+			Logf(0, "failed to read kernel modules: %v", err)
+		} else {
+			for _, m := range modules {
+				a.Modules = append(a.Modules, RpcModule{Name: m.Name, Addr: m.Addr, Size: m.Size, Text: m.Text})
+			}
 		}
 		for c := range calls {
 			a.Calls = append(a.Calls, c.Name)
@@ -161,6 +275,27 @@ func main() {
 
 	kmemleakInit()
 
+	if *flagAddrLayout != "" {
+		layout, err := prog.ParseAddrLayout(strings.Split(*flagAddrLayout, ","))
+		if err != nil {
+			Fatalf("bad -addr_layout flag: %v", err)
+		}
+		prog.SetAddrLayout(layout)
+	}
+	prog.SetGenerateCleanup(*flagCleanup)
+	for _, pool := range sys.FilenamePools {
+		paths := host.DiscoverPaths(pool, maxPoolPaths)
+		Logf(1, "discovered %v paths for filename pool %v", len(paths), pool)
+		prog.SetFilenamePool(pool, paths)
+	}
+
+	mutator, err = buildMutator(*flagMutations)
+	if err != nil {
+		Fatalf("%v", err)
+	}
+	dict = prog.NewDictionary(r.Dict)
+	mutator.SetDictionary(dict)
+
 	flags, timeout, err := ipc.DefaultFlags()
 	if err != nil {
 		panic(err)
@@ -168,17 +303,30 @@ func main() {
 	if _, ok := calls[sys.CallMap["syz_emit_ethernet"]]; ok {
 		flags |= ipc.FlagEnableTun
 	}
+	if *flagFaults {
+		flags |= ipc.FlagEnableFault
+	}
 	noCover = flags&ipc.FlagCover == 0
+	edgeSignal = flags&ipc.FlagEdgeSignal != 0
+	if !noCover {
+		compsSupported = host.KcovComparisonsSupported()
+		Logf(0, "kcov comparisons supported: %v", compsSupported)
+	}
+	// The callback is invoked when all procs are quiesced.
+	lastLeakCheck := time.Now()
 	leakCallback := func() {
-		if atomic.LoadUint32(&allTriaged) != 0 {
+		if atomic.LoadUint32(&allTriaged) != 0 && time.Since(lastLeakCheck) >= *flagLeakPeriod {
 			// Scan for leaks once in a while (it is damn slow).
 			kmemleakScan(true)
+			lastLeakCheck = time.Now()
 		}
 	}
 	if !*flagLeak {
 		leakCallback = nil
 	}
 	gate = ipc.NewGate(2**flagProcs, leakCallback)
+	probeQueue = make(chan []*sys.Call, *flagProcs)
+	requestProbe()
 	needPoll := make(chan struct{}, 1)
 	needPoll <- struct{}{}
 	envs := make([]*ipc.Env, *flagProcs)
@@ -195,6 +343,13 @@ func main() {
 			rnd := rand.New(rs)
 
 			for i := 0; ; i++ {
+				select {
+				case calls := <-probeQueue:
+					probeCalls(pid, env, rs, calls)
+					continue
+				default:
+				}
+
 				triageMu.RLock()
 				if len(triage) != 0 || len(candidates) != 0 {
 					triageMu.RUnlock()
@@ -232,24 +387,31 @@ func main() {
 				if len(corpus) == 0 || i%100 == 0 {
 					// Generate a new prog.
 					corpusMu.RUnlock()
-					p := prog.Generate(rnd, programLength, ct)
+					p := prog.Generate(rnd, programLength, choiceTable())
 					Logf(1, "#%v: generated: %s", i, p)
-					execute(pid, env, p, false, &statExecGen)
+					info := execute(pid, env, p, false, &statExecGen)
+					retryRejected(pid, env, rs, p, info)
 				} else {
 					// Mutate an existing prog.
-					p0 := corpus[rnd.Intn(len(corpus))]
+					idx := rnd.Intn(len(corpus))
+					p0 := corpus[idx]
 					p := p0.Clone()
-					p.Mutate(rs, programLength, ct, corpus)
+					mutator.MutateWithCover(rs, p, corpusSigs[idx], programLength, choiceTable(), corpus, corpusSigs)
 					corpusMu.RUnlock()
 					Logf(1, "#%v: mutated: %s <- %s", i, p, p0)
-					execute(pid, env, p, false, &statExecFuzz)
+					info := execute(pid, env, p, false, &statExecFuzz)
+					retryRejected(pid, env, rs, p, info)
 				}
 			}
 		}()
 	}
 
+	go memoryWatchdog(envs)
+
 	var execTotal uint64
 	var lastPoll time.Time
+	lastYieldUpdate := time.Now()
+	lastProbe := time.Now()
 	var lastPrint time.Time
 	ticker := time.NewTicker(3 * time.Second).C
 	for {
@@ -273,8 +435,27 @@ func main() {
 			triageMu.RUnlock()
 
 			a := &PollArgs{
-				Name:  *flagName,
-				Stats: make(map[string]uint64),
+				Name:          *flagName,
+				Stats:         make(map[string]uint64),
+				SlowCalls:     make(map[string]uint64),
+				DisabledCalls: updateCallStats(),
+			}
+			if time.Since(lastProbe) >= probePeriod {
+				requestProbe()
+				lastProbe = time.Now()
+			}
+			if time.Since(lastYieldUpdate) >= yieldPeriod {
+				a.CallWeights = updateYieldWeights()
+				lastYieldUpdate = time.Now()
+			}
+			resultsMu.Lock()
+			a.CallResults = callResults
+			callResults = nil
+			resultsMu.Unlock()
+			for id := range callSlowPoll {
+				if n := atomic.SwapUint64(&callSlowPoll[id], 0); n != 0 {
+					a.SlowCalls[sys.Calls[id].Name] = n
+				}
 			}
 			for _, env := range envs {
 				a.Stats["exec total"] += atomic.SwapUint64(&env.StatExecs, 0)
@@ -295,11 +476,32 @@ func main() {
 			execMinimize := atomic.SwapUint64(&statExecMinimize, 0)
 			a.Stats["exec minimize"] = execMinimize
 			execTotal += execMinimize
+			execFault := atomic.SwapUint64(&statExecFault, 0)
+			a.Stats["exec fault"] = execFault
+			execTotal += execFault
+			execHints := atomic.SwapUint64(&statExecHints, 0)
+			a.Stats["exec hints"] = execHints
+			execTotal += execHints
+			execProbe := atomic.SwapUint64(&statExecProbe, 0)
+			a.Stats["exec probe"] = execProbe
+			execTotal += execProbe
 			a.Stats["fuzzer new inputs"] = atomic.SwapUint64(&statNewInput, 0)
+			a.Stats["memory watchdog"] = atomic.SwapUint64(&statMemWatchdog, 0)
+			execRejected := atomic.SwapUint64(&statExecRejected, 0)
+			a.Stats["exec rejected retry"] = execRejected
+			execTotal += execRejected
 			r := &PollRes{}
 			if err := manager.Call("Manager.Poll", a, r); err != nil {
 				panic(err)
 			}
+			if len(r.Packed) != 0 {
+				if err := Unpack(r.Packed, r); err != nil {
+					panic(err)
+				}
+			}
+			if len(r.NewTokens) != 0 {
+				Logf(1, "received %v new dictionary tokens", dict.Add(r.NewTokens...))
+			}
 			for _, inp := range r.NewInputs {
 				addInput(inp)
 			}
@@ -311,6 +513,7 @@ func main() {
 				if noCover {
 					corpusMu.Lock()
 					corpus = append(corpus, p)
+					corpusSigs = append(corpusSigs, prog.CoverSig{})
 					corpusMu.Unlock()
 				} else {
 					triageMu.Lock()
@@ -368,6 +571,25 @@ func buildCallList(enabledCalls string) map[*sys.Call]bool {
 	return calls
 }
 
+// buildMutator creates a mutator from the -mutations flag value (e.g. "insert_call:60,splice:1").
+func buildMutator(mutations string) (*prog.Mutator, error) {
+	weights := make(map[string]int)
+	if mutations != "" {
+		for _, s := range strings.Split(mutations, ",") {
+			parts := strings.Split(s, ":")
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("bad mutation strategy '%v' in -mutations flag", s)
+			}
+			w, err := strconv.Atoi(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("bad mutation strategy weight '%v' in -mutations flag", s)
+			}
+			weights[parts[0]] = w
+		}
+	}
+	return prog.NewMutator(weights)
+}
+
 func addInput(inp RpcInput) {
 	corpusMu.Lock()
 	defer corpusMu.Unlock()
@@ -389,13 +611,19 @@ func addInput(inp RpcInput) {
 	if _, ok := corpusHashes[sig]; ok {
 		return
 	}
+	// Inputs of a manager that did not use edge signal contain only PCs,
+	// they still give some signal.
 	cov := cover.Canonicalize(inp.Cover)
+	if edgeSignal && len(inp.Signal) != 0 {
+		cov = cover.Canonicalize(inp.Signal)
+	}
 	diff := cover.Difference(cov, maxCover[call.CallID])
 	diff = cover.Difference(diff, flakes)
 	if len(diff) == 0 {
 		return
 	}
 	corpus = append(corpus, p)
+	corpusSigs = append(corpusSigs, prog.MakeCoverSig(cov))
 	corpusCover[call.CallID] = cover.Union(corpusCover[call.CallID], cov)
 	maxCover[call.CallID] = cover.Union(maxCover[call.CallID], cov)
 	corpusHashes[hash(inp.Prog)] = struct{}{}
@@ -424,9 +652,10 @@ func triageInput(pid int, env *ipc.Env, inp Input) {
 
 	notexecuted := false
 	minCover := inp.cover
+	var inputCover cover.Cover // PCs of the input, sent to manager along with edge signal
 	for i := 0; i < 3; i++ {
-		allCover := execute1(pid, env, inp.p, &statExecTriage)
-		if len(allCover[inp.call]) == 0 {
+		info := execute1(pid, env, nil, inp.p, &statExecTriage)
+		if len(info[inp.call].Cover) == 0 {
 			// The call was not executed. Happens sometimes, reason unknown.
 			if notexecuted {
 				return // if it happened twice, give up
@@ -434,8 +663,11 @@ func triageInput(pid int, env *ipc.Env, inp Input) {
 			notexecuted = true
 			continue
 		}
+		if edgeSignal {
+			inputCover = cover.Copy(info[inp.call].Cover)
+		}
 		coverMu.RLock()
-		cov := allCover[inp.call]
+		cov := callSignal(&info[inp.call])
 		diff := cover.SymmetricDifference(inp.cover, cov)
 		minCover = cover.Intersection(minCover, cov)
 		updateFlakes := len(diff) != 0 && len(cover.Difference(diff, flakes)) != 0
@@ -457,18 +689,21 @@ func triageInput(pid int, env *ipc.Env, inp Input) {
 
 	if !inp.minimized {
 		inp.p, inp.call = prog.Minimize(inp.p, inp.call, func(p1 *prog.Prog, call1 int) bool {
-			allCover := execute(pid, env, p1, false, &statExecMinimize)
+			info := execute(pid, env, p1, false, &statExecMinimize)
 			coverMu.RLock()
 			defer coverMu.RUnlock()
 
-			if len(allCover[call1]) == 0 {
+			if len(info[call1].Cover) == 0 {
 				return false // The call was not executed.
 			}
-			cov := allCover[call1]
+			cov := callSignal(&info[call1])
 			if len(cover.Intersection(newCover, cov)) != len(newCover) {
 				return false
 			}
 			minCover = cover.Intersection(minCover, cov)
+			if edgeSignal {
+				inputCover = cover.Copy(info[call1].Cover)
+			}
 			return true
 		}, false)
 	}
@@ -477,11 +712,28 @@ func triageInput(pid int, env *ipc.Env, inp Input) {
 	atomic.AddUint64(&statNewInput, 1)
 	data := inp.p.Serialize()
 	Logf(2, "added new input for %v to corpus:\n%s", call.CallName, data)
-	a := &NewInputArgs{*flagName, RpcInput{call.CallName, data, inp.call, []uint32(inp.cover)}}
+	input := RpcInput{
+		Call:      call.CallName,
+		Prog:      data,
+		CallIndex: inp.call,
+		Cover:     []uint32(inp.cover),
+	}
+	if edgeSignal {
+		input.Cover = []uint32(inputCover)
+		input.Signal = []uint32(inp.cover)
+	}
+	a := &NewInputArgs{*flagName, input}
 	if err := manager.Call("Manager.NewInput", a, nil); err != nil {
 		panic(err)
 	}
 
+	if *flagFaults && rand.Intn(100) < *flagFaultPercent {
+		failCall(pid, env, inp.p, inp.call)
+	}
+	if compsSupported {
+		executeHintSeed(pid, env, inp.p, inp.call)
+	}
+
 	corpusMu.Lock()
 	defer corpusMu.Unlock()
 	coverMu.Lock()
@@ -489,44 +741,178 @@ func triageInput(pid int, env *ipc.Env, inp Input) {
 
 	corpusCover[call.CallID] = cover.Union(corpusCover[call.CallID], minCover)
 	corpus = append(corpus, inp.p)
+	corpusSigs = append(corpusSigs, prog.MakeCoverSig(minCover))
 	corpusHashes[hash(data)] = struct{}{}
 }
 
-func execute(pid int, env *ipc.Env, p *prog.Prog, minimized bool, stat *uint64) []cover.Cover {
-	allCover := execute1(pid, env, p, stat)
+// failCall systematically injects faults into the call-th call of p:
+// it fails the first fault site, then the second, and so on
+// until the call has no more fault sites or fault_nth limit is reached.
+func failCall(pid int, env *ipc.Env, p *prog.Prog, call int) {
+	for nth := 0; nth < *flagFaultNth; nth++ {
+		opts := &ipc.ExecOpts{
+			Flags:     ipc.ExecFlagInjectFault,
+			FaultCall: call,
+			FaultNth:  nth,
+		}
+		info := execute1(pid, env, opts, p, &statExecFault)
+		if len(info) <= call || !info[call].FaultInjected {
+			break
+		}
+	}
+}
+
+// executeHintSeed collects comparison operands for the call-th call of p
+// and executes programs produced by replacing matching argument values
+// with the other comparison operands (at most maxHintExecs of them).
+func executeHintSeed(pid int, env *ipc.Env, p *prog.Prog, call int) {
+	opts := &ipc.ExecOpts{Flags: ipc.ExecFlagCollectComps}
+	info := execute1(pid, env, opts, p, &statExecHints)
+	if len(info) <= call {
+		return
+	}
+	p.MutateWithHints(call, info[call].Comps, maxHintExecs, func(p *prog.Prog) {
+		execute(pid, env, p, false, &statExecHints)
+	})
+}
+
+const (
+	probeOK = iota
+	probeNoSys
+	probeNoPerm
+)
+
+// requestProbe queues all enabled syscalls for probing, they are split into batches
+// so that all procs probe in parallel. Must be called from the main goroutine.
+func requestProbe() {
+	if len(probeQueue) != 0 {
+		// The previous probe is still in progress.
+		return
+	}
+	var calls []*sys.Call
+	for c := range ctCalls {
+		calls = append(calls, c)
+	}
+	for i := 0; i < *flagProcs; i++ {
+		var batch []*sys.Call
+		for j := i; j < len(calls); j += *flagProcs {
+			batch = append(batch, calls[j])
+		}
+		probeQueue <- batch
+	}
+}
+
+// probeCalls executes each of the calls probeExecs times with random arguments
+// and records syscalls that fail with ENOSYS or EPERM in all executions in callProbe.
+func probeCalls(pid int, env *ipc.Env, rs rand.Source, calls []*sys.Call) {
+	ct := choiceTable()
+	for _, c := range calls {
+		nosys, noperm := 0, 0
+		for i := 0; i < probeExecs; i++ {
+			p := prog.GenerateCall(rs, c, ct)
+			info := execute(pid, env, p, false, &statExecProbe)
+			switch info[len(info)-1].Errno {
+			case int(syscall.ENOSYS):
+				nosys++
+			case int(syscall.EPERM):
+				noperm++
+			}
+		}
+		switch {
+		case nosys == probeExecs:
+			atomic.StoreUint32(&callProbe[c.ID], probeNoSys)
+		case noperm == probeExecs:
+			atomic.StoreUint32(&callProbe[c.ID], probeNoPerm)
+		}
+	}
+}
+
+// retryRejected re-executes p with mutated arguments of calls that were rejected
+// at argument validation if the calls are rejected most of the time (see maxRejectRetries).
+func retryRejected(pid int, env *ipc.Env, rs rand.Source, p *prog.Prog, info []ipc.CallInfo) {
+	tries := make([]int, len(p.Calls)) // number of retries of every call site
+	for exec := 0; exec < maxRejectExecs; exec++ {
+		results := ipc.CallResults(info)
+		for i, res := range results {
+			if res == prog.CallRejected && (tries[i] >= maxRejectRetries || !rejectHeavy(p.Calls[i].Meta.ID)) {
+				// Give up on the call site.
+				results[i] = prog.CallFailed
+			}
+		}
+		ncalls := len(p.Calls)
+		p = p.Clone()
+		idx := p.MutateRejected(rs, results, choiceTable())
+		if idx == -1 {
+			return
+		}
+		if inserted := len(p.Calls) - ncalls; inserted != 0 {
+			// New calls are inserted right before the mutated call.
+			tries = append(tries[:idx-inserted], append(make([]int, inserted), tries[idx-inserted:]...)...)
+		}
+		tries[idx]++
+		Logf(1, "retrying call %v that was rejected: %s", idx, p)
+		info = execute(pid, env, p, false, &statExecRejected)
+	}
+}
+
+func rejectHeavy(id int) bool {
+	execs := atomic.LoadUint64(&callExecs[id])
+	return execs >= slowCallMinExecs && atomic.LoadUint64(&callRejected[id])*2 >= execs
+}
+
+func execute(pid int, env *ipc.Env, p *prog.Prog, minimized bool, stat *uint64) []ipc.CallInfo {
+	info := execute1(pid, env, nil, p, stat)
+	// Triage, minimization, etc re-execute the same programs, only new programs measure yield.
+	fuzzing := stat == &statExecGen || stat == &statExecFuzz
+	allCover := make([]cover.Cover, len(info))
+	for i := range info {
+		allCover[i] = callSignal(&info[i])
+	}
 	coverMu.RLock()
 	defer coverMu.RUnlock()
 	for i, cov := range allCover {
-		if len(cov) == 0 {
+		callID := p.Calls[i].Meta.CallID
+		if len(cov) == 0 || !cover.HasDifference(cov, maxCover[callID]) {
+			if fuzzing && info[i].Errno != -1 {
+				recordYield(pid, p.Calls[i].Meta.ID, 0)
+			}
 			continue
 		}
-		callID := p.Calls[i].Meta.CallID
-		if cover.HasDifference(cov, maxCover[callID]) {
-			diff := cover.Difference(cov, maxCover[callID])
+		diff := cover.Difference(cov, maxCover[callID])
+		if fuzzing {
+			recordYield(pid, p.Calls[i].Meta.ID, len(diff))
+		}
 
-			coverMu.RUnlock()
-			coverMu.Lock()
-			maxCover[callID] = cover.Union(maxCover[callID], diff)
-			coverMu.Unlock()
-			coverMu.RLock()
+		coverMu.RUnlock()
+		coverMu.Lock()
+		maxCover[callID] = cover.Union(maxCover[callID], diff)
+		coverMu.Unlock()
+		coverMu.RLock()
 
-			inp := Input{
-				p:         p.Clone(),
-				call:      i,
-				cover:     cover.Copy(cov),
-				minimized: minimized,
-			}
-			triageMu.Lock()
-			triage = append(triage, inp)
-			triageMu.Unlock()
+		inp := Input{
+			p:         p.Clone(),
+			call:      i,
+			cover:     cover.Copy(cov),
+			minimized: minimized,
 		}
+		triageMu.Lock()
+		triage = append(triage, inp)
+		triageMu.Unlock()
+	}
+	return info
+}
+
+// callSignal returns the feedback signal of the call: edge signal if it is enabled, otherwise PC coverage.
+func callSignal(inf *ipc.CallInfo) cover.Cover {
+	if edgeSignal {
+		return cover.Cover(inf.Signal)
 	}
-	return allCover
+	return cover.Cover(inf.Cover)
 }
 
 var logMu sync.Mutex
 
-func execute1(pid int, env *ipc.Env, p *prog.Prog, stat *uint64) []cover.Cover {
+func execute1(pid int, env *ipc.Env, opts *ipc.ExecOpts, p *prog.Prog, stat *uint64) []ipc.CallInfo {
 	if false {
 		// For debugging, this function must not be executed with locks held.
 		corpusMu.Lock()
@@ -540,22 +926,29 @@ func execute1(pid int, env *ipc.Env, p *prog.Prog, stat *uint64) []cover.Cover {
 	// Limit concurrency window and do leak checking once in a while.
 	idx := gate.Enter()
 	defer gate.Leave(idx)
+	if *flagLeak {
+		leakWindowAdd(pid, p)
+	}
 
 	// The following output helps to understand what program crashed kernel.
 	// It must not be intermixed.
+	faultStr := ""
+	if opts != nil && opts.Flags&ipc.ExecFlagInjectFault != 0 {
+		faultStr = fmt.Sprintf(" (fault-call:%v fault-nth:%v)", opts.FaultCall, opts.FaultNth)
+	}
 	switch *flagOutput {
 	case "none":
 		// This case intentionally left blank.
 	case "stdout":
 		data := p.Serialize()
 		logMu.Lock()
-		Logf(0, "executing program %v:\n%s", pid, data)
+		Logf(0, "executing program %v%v:\n%s", pid, faultStr, data)
 		logMu.Unlock()
 	case "dmesg":
 		fd, err := syscall.Open("/dev/kmsg", syscall.O_WRONLY, 0)
 		if err == nil {
 			buf := new(bytes.Buffer)
-			fmt.Fprintf(buf, "syzkaller: executing program %v:\n%s", pid, p.Serialize())
+			fmt.Fprintf(buf, "syzkaller: executing program %v%v:\n%s", pid, faultStr, p.Serialize())
 			syscall.Write(fd, buf.Bytes())
 			syscall.Close(fd)
 		}
@@ -570,13 +963,12 @@ func execute1(pid int, env *ipc.Env, p *prog.Prog, stat *uint64) []cover.Cover {
 	try := 0
 retry:
 	atomic.AddUint64(stat, 1)
-	output, rawCover, errnos, failed, hanged, err := env.Exec(p)
-	_ = errnos
+	output, info, failed, hanged, err := env.Exec(opts, p)
 	if failed {
 		// BUG in output should be recognized by manager.
 		Logf(0, "BUG: executor-detected bug:\n%s", output)
 		// Don't return any cover so that the input is not added to corpus.
-		return make([]cover.Cover, len(p.Calls))
+		return make([]ipc.CallInfo, len(p.Calls))
 	}
 	if err != nil {
 		if _, ok := err.(ipc.ExecutorFailure); ok || try > 10 {
@@ -589,11 +981,377 @@ retry:
 		goto retry
 	}
 	Logf(2, "result failed=%v hanged=%v:\n%v\n", failed, hanged, string(output))
-	cov := make([]cover.Cover, len(p.Calls))
-	for i, c := range rawCover {
-		cov[i] = cover.Cover(c)
+	recordCallStats(p, info, hanged)
+	return info
+}
+
+// initCallStats allocates per-syscall statistics, they are indexed by Call.ID
+// (sys.CallCount is the number of distinct syscall names, not variants).
+func initCallStats(procs int) {
+	n := len(sys.Calls)
+	callExecs = make([]uint64, n)
+	callSlow = make([]uint64, n)
+	callSlowPoll = make([]uint64, n)
+	callNoSys = make([]uint64, n)
+	callNoPerm = make([]uint64, n)
+	callRejected = make([]uint64, n)
+	callProbe = make([]uint32, n)
+	callYield = make([]float64, n)
+	procYields = make([]procYield, procs)
+	for pid := range procYields {
+		procYields[pid].execs = make([]uint64, n)
+		procYields[pid].newCover = make([]uint64, n)
+	}
+	slowCalls = make(map[int]bool)
+}
+
+// recordCallStats updates per-syscall execution statistics with results of execution of p.
+// Calls that did not finish (Errno -1) are blocked or timed out, so they are counted as slow.
+// If the whole program hanged, calls after the first unfinished one may not have been started at all,
+// so they are ignored.
+func recordCallStats(p *prog.Prog, info []ipc.CallInfo, hanged bool) {
+	blocked := false
+	for i, inf := range info {
+		if inf.Errno == -1 {
+			if hanged && blocked {
+				continue
+			}
+			blocked = true
+		}
+		id := p.Calls[i].Meta.ID
+		atomic.AddUint64(&callExecs[id], 1)
+		switch inf.Errno {
+		case int(syscall.ENOSYS):
+			atomic.AddUint64(&callNoSys[id], 1)
+		case int(syscall.EPERM):
+			atomic.AddUint64(&callNoPerm[id], 1)
+		}
+		if inf.Result() == prog.CallRejected {
+			atomic.AddUint64(&callRejected[id], 1)
+		}
+		if *flagResultSample > 0 && atomic.AddUint64(&statCallResults, 1)%uint64(*flagResultSample) == 0 {
+			resultsMu.Lock()
+			if len(callResults) < maxCallResults {
+				callResults = append(callResults, RpcCallResult{
+					Call:     p.Calls[i].Meta.Name,
+					Errno:    inf.Errno,
+					Cover:    len(inf.Cover),
+					Duration: inf.Duration,
+				})
+			}
+			resultsMu.Unlock()
+		}
+		if inf.Errno == -1 || inf.Duration >= *flagSlowCall {
+			atomic.AddUint64(&callSlow[id], 1)
+			atomic.AddUint64(&callSlowPoll[id], 1)
+		}
+	}
+}
+
+func choiceTable() *prog.ChoiceTable {
+	ctMu.RLock()
+	defer ctMu.RUnlock()
+	return ct
+}
+
+// updateCallStats analyzes per-syscall execution statistics.
+// Syscalls that consistently take longer than the slow_call threshold are chosen less frequently.
+// Syscalls that always fail with ENOSYS or EPERM (in fuzzing or probe executions)
+// are not present or not permitted on the target kernel, so they are disabled;
+// names of the disabled syscalls are returned.
+func updateCallStats() []string {
+	updated := false
+	var disabled []string
+	for id := range callExecs {
+		c := sys.Calls[id]
+		if !ctCalls[c] {
+			continue
+		}
+		reason := ""
+		switch atomic.SwapUint32(&callProbe[id], probeOK) {
+		case probeNoSys:
+			reason = fmt.Sprintf("all %v probe executions failed with ENOSYS", probeExecs)
+		case probeNoPerm:
+			reason = fmt.Sprintf("all %v probe executions failed with EPERM", probeExecs)
+		}
+		execs := atomic.LoadUint64(&callExecs[id])
+		if reason == "" && execs >= slowCallMinExecs {
+			if nosys := atomic.LoadUint64(&callNoSys[id]); nosys == execs {
+				reason = fmt.Sprintf("all %v executions failed with ENOSYS", execs)
+			} else if noperm := atomic.LoadUint64(&callNoPerm[id]); noperm == execs {
+				reason = fmt.Sprintf("all %v executions failed with EPERM", execs)
+			}
+		}
+		if reason != "" {
+			Logf(0, "disabling syscall %v: %v", c.Name, reason)
+			disabled = append(disabled, c.Name)
+			delete(ctCalls, c)
+			updated = true
+			continue
+		}
+		if execs < slowCallMinExecs {
+			continue
+		}
+		slow := atomic.LoadUint64(&callSlow[id])
+		if slowCalls[id] || slow*2 < execs {
+			continue
+		}
+		Logf(0, "deprioritizing slow syscall %v (%v out of %v executions are slow)", c.Name, slow, execs)
+		slowCalls[id] = true
+		updated = true
+	}
+	if len(disabled) != 0 {
+		// Disabled syscalls could be the only way to create resources for other syscalls.
+		trans := sys.TransitivelyEnabledCalls(ctCalls)
+		for c := range ctCalls {
+			if !trans[c] {
+				Logf(0, "disabling transitively unavailable syscall: %v", c.Name)
+				disabled = append(disabled, c.Name)
+				delete(ctCalls, c)
+			}
+		}
+	}
+	if len(ctCalls) == 0 {
+		Fatalf("all syscalls are disabled")
+	}
+	if updated {
+		rebuildChoiceTable()
+	}
+	return disabled
+}
+
+// procYield holds yield statistics of fuzzing executions of a single proc.
+// Counters are incremented only by the proc and reset by mergeYields.
+type procYield struct {
+	execs    []uint64
+	newCover []uint64
+}
+
+func recordYield(pid, id, newCover int) {
+	y := &procYields[pid]
+	atomic.AddUint64(&y.execs[id], 1)
+	atomic.AddUint64(&y.newCover[id], uint64(newCover))
+}
+
+// mergeYields merges per-proc statistics collected since the last merge into callYield.
+func mergeYields() {
+	for id := range callYield {
+		var execs, newCover uint64
+		for pid := range procYields {
+			execs += atomic.SwapUint64(&procYields[pid].execs[id], 0)
+			newCover += atomic.SwapUint64(&procYields[pid].newCover[id], 0)
+		}
+		callYield[id] *= yieldDecay
+		if execs != 0 {
+			callYield[id] += (1 - yieldDecay) * float64(newCover) / float64(execs)
+		}
+	}
+}
+
+// updateYieldWeights updates syscall yields, recalculates choice table weights from them
+// and rebuilds the choice table. It returns the non-default weights for debugging.
+func updateYieldWeights() map[string]float32 {
+	mergeYields()
+	maxYield := 0.0
+	for _, y := range callYield {
+		if maxYield < y {
+			maxYield = y
+		}
+	}
+	weights := make([]float32, len(callYield))
+	for id, y := range callYield {
+		weights[id] = 1
+		if maxYield > 0 {
+			weights[id] += float32(yieldBoost * y / maxYield)
+		}
+	}
+	callWeights = weights
+	rebuildChoiceTable()
+	res := make(map[string]float32)
+	for id, w := range weights {
+		if w > 1.01 && ctCalls[sys.Calls[id]] {
+			res[sys.Calls[id].Name] = w
+		}
 	}
-	return cov
+	return res
+}
+
+func rebuildChoiceTable() {
+	prios1 := make([][]float32, len(prios))
+	for i := range prios {
+		prios1[i] = append([]float32{}, prios[i]...)
+		for id := range slowCalls {
+			prios1[i][id] *= slowCallPrioScale
+		}
+		if callWeights != nil {
+			for id, w := range callWeights {
+				prios1[i][id] *= w
+			}
+		}
+	}
+	calls := make(map[*sys.Call]bool)
+	for c := range ctCalls {
+		calls[c] = true
+	}
+	ct1 := prog.BuildChoiceTable(prios1, calls)
+	ctMu.Lock()
+	ct = ct1
+	ctMu.Unlock()
+}
+
+// memoryWatchdog monitors available memory and RSS of executors. If memory is low,
+// it restarts executor processes (together with all test processes) and drops caches.
+// Otherwise OOM killer can kill the fuzzer itself, which is detected as a lost connection
+// and the program that was executing at the moment is lost.
+// Restarting executors does not help if the fuzzer itself uses too much memory,
+// so in that case it only returns memory to the OS and logs the RSS.
+func memoryWatchdog(envs []*ipc.Env) {
+	if *flagMinFreeMem == 0 && *flagMaxRssPercent == 0 {
+		return
+	}
+	var lastRestart, lastFuzzerRss time.Time
+	for range time.NewTicker(time.Second).C {
+		if time.Since(lastRestart) < 10*time.Second {
+			continue
+		}
+		total, avail, err := readMemInfo()
+		if err != nil {
+			Logf(0, "memory watchdog: %v", err)
+			return
+		}
+		var pids []int
+		for _, env := range envs {
+			if pid := env.ExecutorPid(); pid != 0 {
+				pids = append(pids, pid)
+			}
+		}
+		executorRss, err := processTreeRss("/proc", pids)
+		if err != nil {
+			Logf(0, "memory watchdog: %v", err)
+			return
+		}
+		maxRss := total / 100 * uint64(*flagMaxRssPercent)
+		if rss, err := readRss(); err == nil && *flagMaxRssPercent != 0 && rss > maxRss &&
+			time.Since(lastFuzzerRss) >= time.Minute {
+			Logf(0, "memory watchdog: fuzzer RSS %vMB", rss>>20)
+			lastFuzzerRss = time.Now()
+			debug.FreeOSMemory()
+		}
+		lowMem := *flagMinFreeMem != 0 && avail < uint64(*flagMinFreeMem)<<20
+		highRss := *flagMaxRssPercent != 0 && executorRss > maxRss
+		if !lowMem && !highRss {
+			continue
+		}
+		Logf(0, "memory watchdog: available memory %vMB, executors RSS %vMB, restarting executors",
+			avail>>20, executorRss>>20)
+		lastRestart = time.Now()
+		atomic.AddUint64(&statMemWatchdog, 1)
+		for _, env := range envs {
+			env.RequestRestart()
+		}
+		debug.FreeOSMemory()
+		// Drop page cache, dentries and inodes.
+		if err := ioutil.WriteFile("/proc/sys/vm/drop_caches", []byte("3"), 0); err != nil {
+			Logf(1, "memory watchdog: failed to drop caches: %v", err)
+		}
+	}
+}
+
+// readMemInfo returns total and available memory in bytes.
+func readMemInfo() (total, avail uint64, err error) {
+	data, err := ioutil.ReadFile("/proc/meminfo")
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read /proc/meminfo: %v", err)
+	}
+	var free uint64
+	haveAvail := false
+	for _, line := range strings.Split(string(data), "\n") {
+		var name string
+		var v uint64
+		if n, _ := fmt.Sscanf(line, "%s %d kB", &name, &v); n != 2 {
+			continue
+		}
+		switch name {
+		case "MemTotal:":
+			total = v << 10
+		case "MemFree:":
+			free = v << 10
+		case "MemAvailable:":
+			avail = v << 10
+			haveAvail = true
+		}
+	}
+	if total == 0 {
+		return 0, 0, fmt.Errorf("failed to parse /proc/meminfo")
+	}
+	if !haveAvail {
+		// MemAvailable is present only since linux 3.14.
+		avail = free
+	}
+	return total, avail, nil
+}
+
+// readRss returns resident set size of the current process in bytes.
+func readRss() (uint64, error) {
+	data, err := ioutil.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0, fmt.Errorf("failed to read /proc/self/statm: %v", err)
+	}
+	var size, rss uint64
+	if n, _ := fmt.Sscanf(string(data), "%d %d", &size, &rss); n != 2 {
+		return 0, fmt.Errorf("failed to parse /proc/self/statm: %q", data)
+	}
+	return rss * uint64(os.Getpagesize()), nil
+}
+
+// processTreeRss returns total resident set size of processes roots and all their descendants in bytes.
+// procDir is the procfs mount point. Processes that exit while the tree is read are ignored.
+func processTreeRss(procDir string, roots []int) (uint64, error) {
+	if len(roots) == 0 {
+		return 0, nil
+	}
+	dirs, err := ioutil.ReadDir(procDir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %v: %v", procDir, err)
+	}
+	children := make(map[int][]int)
+	rss := make(map[int]uint64)
+	for _, dir := range dirs {
+		pid, err := strconv.Atoi(dir.Name())
+		if err != nil {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(procDir, dir.Name(), "stat"))
+		if err != nil {
+			continue
+		}
+		// The second field is the executable name in parentheses, it can contain spaces and parentheses.
+		pos := bytes.LastIndexByte(data, ')')
+		if pos == -1 {
+			return 0, fmt.Errorf("failed to parse %v/%v/stat: %q", procDir, pid, data)
+		}
+		// The fields after the name are state, ppid, ..., rss is the 22nd of them.
+		fields := strings.Fields(string(data[pos+1:]))
+		if len(fields) < 22 {
+			return 0, fmt.Errorf("failed to parse %v/%v/stat: %q", procDir, pid, data)
+		}
+		ppid, err1 := strconv.Atoi(fields[1])
+		pages, err2 := strconv.ParseUint(fields[21], 10, 64)
+		if err1 != nil || err2 != nil {
+			return 0, fmt.Errorf("failed to parse %v/%v/stat: %q", procDir, pid, data)
+		}
+		children[ppid] = append(children[ppid], pid)
+		rss[pid] = pages * uint64(os.Getpagesize())
+	}
+	total := uint64(0)
+	pending := append([]int{}, roots...)
+	for len(pending) != 0 {
+		pid := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		total += rss[pid]
+		pending = append(pending, children[pid]...)
+	}
+	return total, nil
 }
 
 func kmemleakInit() {
@@ -618,7 +1376,71 @@ func kmemleakInit() {
 	}
 }
 
-var kmemleakBuf []byte
+var (
+	kmemleakBuf []byte
+
+	// leakWindow holds programs executed since the last leak check,
+	// these are the programs that could trigger the leaks found by the check.
+	leakWindowMu  sync.Mutex
+	leakWindow    []leakWindowEntry
+	leakWindowPos int
+
+	// reportedLeaks holds backtraces of already reported leaks.
+	reportedLeaks = make(map[string]bool)
+)
+
+type leakWindowEntry struct {
+	pid  int
+	data []byte
+}
+
+func leakWindowAdd(pid int, p *prog.Prog) {
+	ent := leakWindowEntry{pid, p.Serialize()}
+	leakWindowMu.Lock()
+	defer leakWindowMu.Unlock()
+	if len(leakWindow) < leakWindowLen {
+		leakWindow = append(leakWindow, ent)
+	} else {
+		leakWindow[leakWindowPos] = ent
+	}
+	leakWindowPos = (leakWindowPos + 1) % leakWindowLen
+}
+
+// leakWindowDump returns programs executed since the last leak check
+// in the same format as fuzzer uses for program execution logging
+// and resets the window.
+func leakWindowDump() []byte {
+	leakWindowMu.Lock()
+	defer leakWindowMu.Unlock()
+	buf := new(bytes.Buffer)
+	for i := range leakWindow {
+		ent := leakWindow[(leakWindowPos+i)%len(leakWindow)]
+		fmt.Fprintf(buf, "executing program %v:\n%s\n", ent.pid, ent.data)
+	}
+	leakWindow = nil
+	leakWindowPos = 0
+	return buf.Bytes()
+}
+
+// newLeaks splits kmemleak report into individual leaks and returns
+// only the leaks with backtraces that were not reported before.
+func newLeaks(report []byte) []byte {
+	const header = "unreferenced object"
+	var res []byte
+	for _, leak := range bytes.Split(report, []byte(header))[1:] {
+		backtrace := leak
+		if pos := bytes.Index(leak, []byte("backtrace:")); pos != -1 {
+			backtrace = leak[pos:]
+		}
+		if reportedLeaks[string(backtrace)] {
+			continue
+		}
+		reportedLeaks[string(backtrace)] = true
+		res = append(res, header...)
+		res = append(res, leak...)
+	}
+	return res
+}
 
 func kmemleakScan(report bool) {
 	fd, err := syscall.Open("/sys/kernel/debug/kmemleak", syscall.O_RDWR, 0)
@@ -658,12 +1480,16 @@ func kmemleakScan(report bool) {
 			if err != nil {
 				panic(err)
 			}
-			if n != 0 {
-				// BUG in output should be recognized by manager.
-				Logf(0, "BUG: memory leak:\n%s\n", kmemleakBuf[:n])
+			if leaks := newLeaks(kmemleakBuf[:n]); len(leaks) != 0 {
+				// Programs from the window go first, so that the leak report
+				// is attributed to them during reproduction.
+				// The leak report itself should be recognized by manager.
+				Logf(0, "memory leak check: programs executed since the previous check:\n%s", leakWindowDump())
+				Logf(0, "memory leak check: found new leaks:\n%s\n", leaks)
 			}
 		}
 	}
+	leakWindowDump()
 	if _, err := syscall.Write(fd, []byte("clear")); err != nil {
 		panic(err)
 	}
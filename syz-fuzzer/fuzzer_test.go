@@ -0,0 +1,141 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/google/syzkaller/ipc"
+	"github.com/google/syzkaller/prog"
+)
+
+func TestRecordCallStats(t *testing.T) {
+	initCallStats(1)
+	p, err := prog.Deserialize([]byte("getpid()\nread(0xffffffffffffffff, &(0x7f0000000000)=\"\", 0x0)\ngetuid()\n"))
+	if err != nil {
+		t.Fatalf("failed to deserialize program: %v", err)
+	}
+	getpid, read, getuid := p.Calls[0].Meta.ID, p.Calls[1].Meta.ID, p.Calls[2].Meta.ID
+
+	// read is blocked and did not finish within the call timeout, the following call is executed.
+	recordCallStats(p, []ipc.CallInfo{
+		{Errno: 0, Duration: time.Millisecond},
+		{Errno: -1},
+		{Errno: int(syscall.EPERM), Duration: time.Millisecond},
+	}, false)
+	for _, id := range []int{getpid, read, getuid} {
+		if callExecs[id] != 1 {
+			t.Fatalf("call %v: %v executions, want 1", id, callExecs[id])
+		}
+	}
+	if callSlow[getpid] != 0 || callSlow[read] != 1 || callSlow[getuid] != 0 {
+		t.Fatalf("bad slow calls: getpid %v, read %v, getuid %v", callSlow[getpid], callSlow[read], callSlow[getuid])
+	}
+	if callSlowPoll[read] != 1 || callNoPerm[getuid] != 1 {
+		t.Fatalf("bad stats: slow poll %v, eperm %v", callSlowPoll[read], callNoPerm[getuid])
+	}
+
+	// The whole program hanged in read, getuid was not started.
+	recordCallStats(p, []ipc.CallInfo{
+		{Errno: 0, Duration: time.Millisecond},
+		{Errno: -1},
+		{Errno: -1},
+	}, true)
+	if callExecs[read] != 2 || callSlow[read] != 2 {
+		t.Fatalf("blocked read: %v executions, %v slow, want 2, 2", callExecs[read], callSlow[read])
+	}
+	if callExecs[getuid] != 1 || callSlow[getuid] != 0 {
+		t.Fatalf("not started getuid: %v executions, %v slow, want 1, 0", callExecs[getuid], callSlow[getuid])
+	}
+}
+
+func TestMergeYields(t *testing.T) {
+	initCallStats(2)
+	// Proc 0 found 10 new PCs in call 0, proc 1 executed it 3 more times without new coverage.
+	recordYield(0, 0, 10)
+	for i := 0; i < 3; i++ {
+		recordYield(1, 0, 0)
+	}
+	recordYield(1, 1, 0)
+	mergeYields()
+	if y := callYield[0]; y != (1-yieldDecay)*10/4 {
+		t.Fatalf("bad yield of call 0: %v", y)
+	}
+	if y := callYield[1]; y != 0 {
+		t.Fatalf("bad yield of call 1: %v", y)
+	}
+	for pid := range procYields {
+		if procYields[pid].execs[0] != 0 || procYields[pid].newCover[0] != 0 {
+			t.Fatalf("proc %v statistics are not reset", pid)
+		}
+	}
+	// Without new executions the yield is decayed.
+	mergeYields()
+	if y := callYield[0]; y != yieldDecay*(1-yieldDecay)*10/4 {
+		t.Fatalf("bad decayed yield of call 0: %v", y)
+	}
+}
+
+func TestProcessTreeRss(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syz-fuzzer-test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	procs := []struct {
+		pid, ppid int
+		comm      string
+		rss       uint64
+	}{
+		{1, 0, "init", 100},
+		{10, 1, "syz-fuzzer", 1000}, // the fuzzer itself is not counted
+		{20, 10, "syz-executor", 10},
+		{21, 20, "syz-executor", 20},
+		{22, 21, "a) (b", 30},
+		{30, 10, "syz-executor", 40},
+		{31, 1, "sshd", 50},
+	}
+	for _, p := range procs {
+		if err := os.Mkdir(filepath.Join(dir, fmt.Sprint(p.pid)), 0700); err != nil {
+			t.Fatal(err)
+		}
+		stat := fmt.Sprintf("%v (%v) S %v 1 1 0 -1 4194560 1 0 0 0 0 0 0 0 20 0 1 0 100 1000 %v 18446744073709551615\n",
+			p.pid, p.comm, p.ppid, p.rss)
+		if err := ioutil.WriteFile(filepath.Join(dir, fmt.Sprint(p.pid), "stat"), []byte(stat), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Not a process.
+	if err := os.Mkdir(filepath.Join(dir, "self"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	page := uint64(os.Getpagesize())
+	tests := []struct {
+		roots []int
+		rss   uint64
+	}{
+		{nil, 0},
+		{[]int{20}, 60 * page},
+		{[]int{20, 30}, 100 * page},
+		{[]int{30, 40}, 40 * page}, // exited executor
+	}
+	for i, test := range tests {
+		rss, err := processTreeRss(dir, test.roots)
+		if err != nil {
+			t.Fatalf("test #%v: %v", i, err)
+		}
+		if rss != test.rss {
+			t.Errorf("test #%v: rss %v, want %v", i, rss, test.rss)
+		}
+	}
+	if rss, err := processTreeRss("/proc", []int{os.Getpid()}); err != nil || rss == 0 {
+		t.Fatalf("failed to read RSS of the test process: %v, %v", rss, err)
+	}
+}
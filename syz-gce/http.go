@@ -18,6 +18,7 @@ import (
 func initHttp(addr string) {
 	http.HandleFunc("/", httpManager)
 	http.HandleFunc("/syz-gce", httpSummary)
+	http.HandleFunc("/syz-gce/log", ServeHTTP)
 
 	ln, err := net.Listen("tcp4", addr)
 	if err != nil {
@@ -32,9 +33,15 @@ func initHttp(addr string) {
 
 func httpSummary(w http.ResponseWriter, r *http.Request) {
 	data := &UISummaryData{
-		Name:    cfg.Name,
-		Manager: atomic.LoadUint32(&managerHttpPort) != 0,
-		Log:     CachedLogOutput(),
+		Name:       cfg.Name,
+		Manager:    atomic.LoadUint32(&managerHttpPort) != 0,
+		LogName:    r.FormValue("log"),
+		LogBuffers: Buffers(),
+	}
+	if data.LogName != "" {
+		data.Log = CachedNamedOutput(data.LogName)
+	} else {
+		data.Log = CachedLogOutput()
 	}
 	if err := summaryTemplate.Execute(w, data); err != nil {
 		http.Error(w, fmt.Sprintf("failed to execute template: %v", err), http.StatusInternalServerError)
@@ -61,9 +68,11 @@ func compileTemplate(html string) *template.Template {
 }
 
 type UISummaryData struct {
-	Name    string
-	Manager bool
-	Log     string
+	Name       string
+	Manager    bool
+	Log        string
+	LogName    string
+	LogBuffers []string
 }
 
 var summaryTemplate = compileTemplate(`
@@ -85,6 +94,10 @@ manager is not running
 <br><br>
 
 Log:
+{{if $.LogName}}<a href="/syz-gce">all</a>{{else}}<b>all</b>{{end}}
+{{range $b := $.LogBuffers}}
+	{{if eq $b $.LogName}}<b>{{$b}}</b>{{else}}<a href="/syz-gce?log={{$b}}">{{$b}}</a>{{end}}
+{{end}}
 <br>
 <textarea id="log_textarea" readonly rows="50">
 {{.Log}}
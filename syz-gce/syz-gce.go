@@ -51,12 +51,20 @@ var (
 	storageClient   *storage.Client
 	GCE             *gce.Context
 	managerHttpPort uint32
+
+	// Logs of the update steps that can be viewed separately in the UI.
+	managerLog   = Named("manager")
+	imageLog     = Named("image")
+	kernelLog    = Named("kernel")
+	syzkallerLog = Named("syzkaller")
 )
 
 type Config struct {
 	Name            string
 	Hub_Addr        string
 	Hub_Key         string
+	Dashboard_Addr  string
+	Dashboard_Key   string
 	Image_Archive   string
 	Image_Path      string
 	Image_Name      string
@@ -117,7 +125,7 @@ func main() {
 				if managerCmd == nil {
 					Fatalf("spurious manager stop signal")
 				}
-				Logf(0, "syz-manager exited with %v", err)
+				managerLog.Logf(0, "syz-manager exited with %v", err)
 				managerCmd = nil
 				atomic.StoreUint32(&managerHttpPort, 0)
 			case s := <-sigC:
@@ -128,14 +136,14 @@ func main() {
 				case syscall.SIGINT:
 					Logf(0, "SIGINT")
 					if managerCmd != nil {
-						Logf(0, "shutting down syz-manager...")
+						managerLog.Logf(0, "shutting down syz-manager...")
 						managerCmd.Process.Signal(syscall.SIGINT)
 						select {
 						case err := <-managerStopped:
 							if managerCmd == nil {
 								Fatalf("spurious manager stop signal")
 							}
-							Logf(0, "syz-manager exited with %v", err)
+							managerLog.Logf(0, "syz-manager exited with %v", err)
 						case <-sigC:
 							managerCmd.Process.Kill()
 						case <-time.After(time.Minute):
@@ -151,7 +159,7 @@ func main() {
 		// Poll syzkaller repo.
 		syzkallerHash, err := updateSyzkallerBuild()
 		if err != nil {
-			Logf(0, "failed to update syzkaller: %v", err)
+			syzkallerLog.Logf(0, "failed to update syzkaller: %v", err)
 			continue
 		}
 
@@ -194,10 +202,10 @@ func main() {
 		if managerCmd != nil {
 			if !stoppingManager {
 				stoppingManager = true
-				Logf(0, "stopping syz-manager...")
+				managerLog.Logf(0, "stopping syz-manager...")
 				managerCmd.Process.Signal(syscall.SIGINT)
 			} else {
-				Logf(0, "killing syz-manager...")
+				managerLog.Logf(0, "killing syz-manager...")
 				managerCmd.Process.Kill()
 			}
 			delayDuration = time.Minute
@@ -206,29 +214,29 @@ func main() {
 
 		// Download and extract image from GCS.
 		if lastImageUpdated != imageUpdated {
-			Logf(0, "downloading image archive...")
+			imageLog.Logf(0, "downloading image archive...")
 			if err := os.RemoveAll("image"); err != nil {
-				Logf(0, "failed to remove image dir: %v", err)
+				imageLog.Logf(0, "failed to remove image dir: %v", err)
 				continue
 			}
 			if err := downloadAndExtract(imageArchive, "image"); err != nil {
-				Logf(0, "failed to download and extract %v: %v", cfg.Image_Archive, err)
+				imageLog.Logf(0, "failed to download and extract %v: %v", cfg.Image_Archive, err)
 				continue
 			}
 
-			Logf(0, "uploading image...")
+			imageLog.Logf(0, "uploading image...")
 			if err := uploadFile("image/disk.tar.gz", cfg.Image_Path); err != nil {
-				Logf(0, "failed to upload image: %v", err)
+				imageLog.Logf(0, "failed to upload image: %v", err)
 				continue
 			}
 
-			Logf(0, "creating gce image...")
+			imageLog.Logf(0, "creating gce image...")
 			if err := GCE.DeleteImage(cfg.Image_Name); err != nil {
-				Logf(0, "failed to delete GCE image: %v", err)
+				imageLog.Logf(0, "failed to delete GCE image: %v", err)
 				continue
 			}
 			if err := GCE.CreateImage(cfg.Image_Name, cfg.Image_Path); err != nil {
-				Logf(0, "failed to create GCE image: %v", err)
+				imageLog.Logf(0, "failed to create GCE image: %v", err)
 				continue
 			}
 		}
@@ -236,53 +244,53 @@ func main() {
 
 		// Rebuild kernel.
 		if lastLinuxHash != linuxHash {
-			Logf(0, "building linux kernel...")
+			kernelLog.Logf(0, "building linux kernel...")
 			if err := buildKernel(linuxDir, cfg.Linux_Compiler); err != nil {
-				Logf(0, "build failed: %v", err)
+				kernelLog.Logf(0, "build failed: %v", err)
 				continue
 			}
 
 			scriptFile := filepath.Join(buildDir, "create-gce-image.sh")
 			if err := ioutil.WriteFile(scriptFile, []byte(createImageScript), 0700); err != nil {
-				Logf(0, "failed to write script file: %v", err)
+				kernelLog.Logf(0, "failed to write script file: %v", err)
 				continue
 			}
 
-			Logf(0, "building image...")
+			kernelLog.Logf(0, "building image...")
 			vmlinux := filepath.Join(linuxDir, "vmlinux")
 			bzImage := filepath.Join(linuxDir, "arch/x86/boot/bzImage")
 			if _, err := runCmd(buildDir, scriptFile, abs(wd, cfg.Linux_Userspace), bzImage, vmlinux, linuxHash); err != nil {
-				Logf(0, "image build failed: %v", err)
+				kernelLog.Logf(0, "image build failed: %v", err)
 				continue
 			}
 			os.Remove(filepath.Join(buildDir, "disk.raw"))
 			os.Remove(filepath.Join(buildDir, "image.tar.gz"))
 			os.MkdirAll("image/obj", 0700)
 			if err := ioutil.WriteFile("image/tag", []byte(linuxHash), 0600); err != nil {
-				Logf(0, "failed to write tag file: %v", err)
+				kernelLog.Logf(0, "failed to write tag file: %v", err)
 				continue
 			}
 			if err := os.Rename(filepath.Join(buildDir, "key"), "image/key"); err != nil {
-				Logf(0, "failed to rename key file: %v", err)
+				kernelLog.Logf(0, "failed to rename key file: %v", err)
 				continue
 			}
 			if err := os.Rename(vmlinux, "image/obj/vmlinux"); err != nil {
-				Logf(0, "failed to rename vmlinux file: %v", err)
+				kernelLog.Logf(0, "failed to rename vmlinux file: %v", err)
 				continue
 			}
-			Logf(0, "uploading image...")
+			imageLog.Logf(0, "uploading image...")
 			if err := uploadFile(filepath.Join(buildDir, "disk.tar.gz"), cfg.Image_Path); err != nil {
-				Logf(0, "failed to upload image: %v", err)
+				imageLog.Logf(0, "failed to upload image: %v", err)
 				continue
 			}
 
-			Logf(0, "creating gce image...")
+			imageLog.Logf(0, "creating gce image...")
 			if err := GCE.DeleteImage(cfg.Image_Name); err != nil {
-				Logf(0, "failed to delete GCE image: %v", err)
+				imageLog.Logf(0, "failed to delete GCE image: %v", err)
 				continue
 			}
 			if err := GCE.CreateImage(cfg.Image_Name, cfg.Image_Path); err != nil {
-				Logf(0, "failed to create GCE image: %v", err)
+				imageLog.Logf(0, "failed to create GCE image: %v", err)
 				continue
 			}
 		}
@@ -290,9 +298,9 @@ func main() {
 
 		// Rebuild syzkaller.
 		if lastSyzkallerHash != syzkallerHash {
-			Logf(0, "building syzkaller...")
+			syzkallerLog.Logf(0, "building syzkaller...")
 			if _, err := runCmd("gopath/src/github.com/google/syzkaller", "make"); err != nil {
-				Logf(0, "failed to update/build syzkaller: %v", err)
+				syzkallerLog.Logf(0, "failed to update/build syzkaller: %v", err)
 				continue
 			}
 		}
@@ -301,18 +309,18 @@ func main() {
 		// Restart syz-manager.
 		port, err := chooseUnusedPort()
 		if err != nil {
-			Logf(0, "failed to choose an unused port: %v", err)
+			managerLog.Logf(0, "failed to choose an unused port: %v", err)
 			continue
 		}
 		if err := writeManagerConfig(port, "manager.cfg"); err != nil {
-			Logf(0, "failed to write manager config: %v", err)
+			managerLog.Logf(0, "failed to write manager config: %v", err)
 			continue
 		}
 
-		Logf(0, "starting syz-manager...")
+		managerLog.Logf(0, "starting syz-manager...")
 		managerCmd = exec.Command("gopath/src/github.com/google/syzkaller/bin/syz-manager", "-config=manager.cfg")
 		if err := managerCmd.Start(); err != nil {
-			Logf(0, "failed to start syz-manager: %v", err)
+			managerLog.Logf(0, "failed to start syz-manager: %v", err)
 			managerCmd = nil
 			continue
 		}
@@ -329,13 +337,28 @@ func readConfig(filename string) *Config {
 	if filename == "" {
 		Fatalf("supply config in -config flag")
 	}
-	data, err := ioutil.ReadFile(filename)
-	if err != nil {
-		Fatalf("failed to read config file: %v", err)
-	}
 	cfg := new(Config)
-	if err := json.Unmarshal(data, cfg); err != nil {
-		Fatalf("failed to parse config file: %v", err)
+	cfg.Sandbox = "setuid"
+	if err := config.LoadFile(filename, cfg); err != nil {
+		Fatalf("%v", err)
+	}
+	if cfg.Name == "" {
+		Fatalf("config param name is empty")
+	}
+	if cfg.Http_Port <= 0 || cfg.Http_Port > 65535 {
+		Fatalf("invalid config param http_port: %v, want (0, 65535]", cfg.Http_Port)
+	}
+	if cfg.Machine_Count <= 0 || cfg.Machine_Count > 1000 {
+		Fatalf("invalid config param machine_count: %v, want (1, 1000]", cfg.Machine_Count)
+	}
+	if cfg.Machine_Type == "" {
+		Fatalf("config param machine_type is empty")
+	}
+	if cfg.Procs < 0 || cfg.Procs > 32 {
+		Fatalf("invalid config param procs: %v, want [1, 32]", cfg.Procs)
+	}
+	if err := config.CheckSandbox(cfg.Sandbox); err != nil {
+		Fatalf("%v", err)
 	}
 	return cfg
 }
@@ -349,22 +372,24 @@ func writeManagerConfig(httpPort int, file string) error {
 		tag = tag[:len(tag)-1]
 	}
 	managerCfg := &config.Config{
-		Name:         cfg.Name,
-		Hub_Addr:     cfg.Hub_Addr,
-		Hub_Key:      cfg.Hub_Key,
-		Http:         fmt.Sprintf(":%v", httpPort),
-		Rpc:          ":0",
-		Workdir:      "workdir",
-		Vmlinux:      "image/obj/vmlinux",
-		Tag:          string(tag),
-		Syzkaller:    "gopath/src/github.com/google/syzkaller",
-		Type:         "gce",
-		Machine_Type: cfg.Machine_Type,
-		Count:        cfg.Machine_Count,
-		Image:        cfg.Image_Name,
-		Sandbox:      cfg.Sandbox,
-		Procs:        cfg.Procs,
-		Cover:        true,
+		Name:           cfg.Name,
+		Hub_Addr:       cfg.Hub_Addr,
+		Hub_Key:        cfg.Hub_Key,
+		Dashboard_Addr: cfg.Dashboard_Addr,
+		Dashboard_Key:  cfg.Dashboard_Key,
+		Http:           fmt.Sprintf(":%v", httpPort),
+		Rpc:            ":0",
+		Workdir:        "workdir",
+		Vmlinux:        "image/obj/vmlinux",
+		Tag:            string(tag),
+		Syzkaller:      "gopath/src/github.com/google/syzkaller",
+		Type:           "gce",
+		Machine_Type:   cfg.Machine_Type,
+		Count:          cfg.Machine_Count,
+		Image:          cfg.Image_Name,
+		Sandbox:        cfg.Sandbox,
+		Procs:          cfg.Procs,
+		Cover:          true,
 	}
 	if _, err := os.Stat("image/key"); err == nil {
 		managerCfg.Sshkey = "image/key"
@@ -4,18 +4,22 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"net"
 	"net/http"
 	"sort"
 	"strings"
+	"time"
 
 	. "github.com/google/syzkaller/log"
 )
 
 func (hub *Hub) initHttp(addr string) {
 	http.HandleFunc("/", hub.httpSummary)
+	http.HandleFunc("/stats", hub.httpStats)
+	http.HandleFunc("/log", ServeHTTP)
 
 	ln, err := net.Listen("tcp4", addr)
 	if err != nil {
@@ -30,43 +34,143 @@ func (hub *Hub) initHttp(addr string) {
 
 func (hub *Hub) httpSummary(w http.ResponseWriter, r *http.Request) {
 	hub.mu.Lock()
-	defer hub.mu.Unlock()
+	data := hub.collectStats()
+	hub.mu.Unlock()
 
-	data := &UISummaryData{
-		Log: CachedLogOutput(),
+	data.Log = CachedLogOutput()
+	if err := summaryTemplate.Execute(w, data); err != nil {
+		Logf(0, "failed to execute template: %v", err)
+		http.Error(w, fmt.Sprintf("failed to execute template: %v", err), http.StatusInternalServerError)
+		return
 	}
+}
+
+// httpStats serves the same statistics as the summary page (and the whole corpus history) in JSON.
+func (hub *Hub) httpStats(w http.ResponseWriter, r *http.Request) {
+	hub.mu.Lock()
+	data := hub.collectStats()
+	data.History = hub.history
+	hub.mu.Unlock()
+
+	out, err := json.MarshalIndent(data, "", "\t")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to marshal stats: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
+
+func (hub *Hub) collectStats() *UISummaryData {
+	now := time.Now()
+	data := new(UISummaryData)
 	total := UIManager{
-		Name:   "total",
-		Corpus: len(hub.st.Corpus.Records),
+		Name: "total",
 	}
-	for name, mgr := range hub.st.Managers {
-		total.Added += mgr.Added
-		total.Deleted += mgr.Added
-		total.New += mgr.New
-		data.Managers = append(data.Managers, UIManager{
-			Name:    name,
-			Corpus:  len(mgr.Corpus.Records),
-			Added:   mgr.Added,
-			Deleted: mgr.Deleted,
-			New:     mgr.New,
+	for ns, st := range hub.st {
+		total.Corpus += len(st.Corpus.Records)
+		for name, mgr := range st.Managers {
+			total.Added += mgr.Added
+			total.Deleted += mgr.Deleted
+			total.New += mgr.New
+			total.Filtered += mgr.Filtered
+			total.Syncs += mgr.Syncs
+			access := ""
+			if cfg := hub.managers[name]; cfg != nil && cfg.Namespace == ns {
+				access = cfg.Access
+			}
+			data.Managers = append(data.Managers, UIManager{
+				Name:      name,
+				Namespace: ns,
+				Access:    access,
+				Corpus:    len(mgr.Corpus.Records),
+				Added:     mgr.Added,
+				Deleted:   mgr.Deleted,
+				New:       mgr.New,
+				Filtered:  mgr.Filtered,
+				Connected: mgr.Connected,
+				LastSync:  mgr.LastSync,
+				Syncs:     mgr.Syncs,
+				Online:    !mgr.LastSync.IsZero() && now.Sub(mgr.LastSync) < onlinePeriod,
+			})
+		}
+		data.Namespaces = append(data.Namespaces, UINamespace{
+			Name:   ns,
+			Corpus: len(st.Corpus.Records),
+			Growth: hub.corpusGrowth(ns, len(st.Corpus.Records), now),
 		})
 	}
 	sort.Sort(UIManagerArray(data.Managers))
+	sort.Sort(UINamespaceArray(data.Namespaces))
 	data.Managers = append([]UIManager{total}, data.Managers...)
-	if err := summaryTemplate.Execute(w, data); err != nil {
-		Logf(0, "failed to execute template: %v", err)
-		http.Error(w, fmt.Sprintf("failed to execute template: %v", err), http.StatusInternalServerError)
-		return
+	return data
+}
+
+const (
+	historyPeriod = 10 * time.Minute
+	historySize   = int(7 * 24 * time.Hour / historyPeriod)
+	// Managers sync every minute, so a manager that did not sync for longer is most likely down.
+	onlinePeriod = 5 * time.Minute
+)
+
+// growthPeriods are periods for which corpus growth is shown on the summary page.
+var growthPeriods = []time.Duration{time.Hour, 24 * time.Hour, 7 * 24 * time.Hour}
+
+// corpusSample is the size of every namespace corpus at some point in time.
+type corpusSample struct {
+	Time   time.Time
+	Corpus map[string]int // namespace -> number of programs
+}
+
+func (hub *Hub) historyLoop() {
+	for {
+		hub.mu.Lock()
+		sample := corpusSample{
+			Time:   time.Now(),
+			Corpus: make(map[string]int),
+		}
+		for ns, st := range hub.st {
+			sample.Corpus[ns] = len(st.Corpus.Records)
+		}
+		hub.history = append(hub.history, sample)
+		if len(hub.history) > historySize {
+			hub.history = hub.history[len(hub.history)-historySize:]
+		}
+		hub.mu.Unlock()
+		time.Sleep(historyPeriod)
 	}
 }
 
+// corpusGrowth returns how much the namespace corpus has grown during each of growthPeriods.
+// If the history is shorter than a period, growth since the oldest sample is returned.
+func (hub *Hub) corpusGrowth(ns string, corpus int, now time.Time) []int {
+	var growth []int
+	for _, period := range growthPeriods {
+		if len(hub.history) == 0 {
+			growth = append(growth, 0)
+			continue
+		}
+		// The latest sample taken at least period ago.
+		i := sort.Search(len(hub.history), func(i int) bool {
+			return now.Sub(hub.history[i].Time) < period
+		})
+		if i != 0 {
+			i--
+		}
+		growth = append(growth, corpus-hub.history[i].Corpus[ns])
+	}
+	return growth
+}
+
 func compileTemplate(html string) *template.Template {
 	return template.Must(template.New("").Parse(strings.Replace(html, "{{STYLE}}", htmlStyle, -1)))
 }
 
 type UISummaryData struct {
-	Managers []UIManager
-	Log      string
+	Managers   []UIManager
+	Namespaces []UINamespace
+	History    []corpusSample `json:",omitempty"`
+	Log        string         `json:"-"`
 }
 
 type UIManager struct {
@@ -75,13 +179,39 @@ type UIManager struct {
 	Added   int
 	Deleted int
 	New     int
+
+	Filtered  int // programs not sent because of unsupported syscalls
+	Namespace string
+	Access    string // empty if the manager is not in the config anymore
+
+	Connected time.Time // zero if the manager did not connect since the hub start
+	LastSync  time.Time
+	Syncs     int
+	Online    bool // synced recently
 }
 
+type UINamespace struct {
+	Name   string
+	Corpus int
+	Growth []int // corpus growth during growthPeriods
+}
+
+type UINamespaceArray []UINamespace
+
+func (a UINamespaceArray) Len() int           { return len(a) }
+func (a UINamespaceArray) Less(i, j int) bool { return a[i].Name < a[j].Name }
+func (a UINamespaceArray) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
 type UIManagerArray []UIManager
 
-func (a UIManagerArray) Len() int           { return len(a) }
-func (a UIManagerArray) Less(i, j int) bool { return a[i].Name < a[j].Name }
-func (a UIManagerArray) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a UIManagerArray) Len() int      { return len(a) }
+func (a UIManagerArray) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a UIManagerArray) Less(i, j int) bool {
+	if a[i].Namespace != a[j].Namespace {
+		return a[i].Namespace < a[j].Namespace
+	}
+	return a[i].Name < a[j].Name
+}
 
 var summaryTemplate = compileTemplate(`
 <!doctype html>
@@ -94,22 +224,53 @@ var summaryTemplate = compileTemplate(`
 <b>syz-hub</b>
 <br><br>
 
+<table>
+	<caption>Corpus:</caption>
+	<tr>
+		<th>Namespace</th>
+		<th>Programs</th>
+		<th>Last hour</th>
+		<th>Last day</th>
+		<th>Last week</th>
+	</tr>
+	{{range $ns := $.Namespaces}}
+	<tr>
+		<td>{{if $ns.Name}}{{$ns.Name}}{{else}}main{{end}}</td>
+		<td>{{$ns.Corpus}}</td>
+		{{range $g := $ns.Growth}}<td>{{printf "%+d" $g}}</td>{{end}}
+	</tr>
+	{{end}}
+</table>
+<br><br>
+
 <table>
 	<caption>Managers:</caption>
 	<tr>
 		<th>Name</th>
+		<th>Namespace</th>
+		<th>Access</th>
 		<th>Corpus</th>
 		<th>Added</th>
 		<th>Deleted</th>
 		<th>New</th>
+		<th>Filtered</th>
+		<th>Connected</th>
+		<th>Last sync</th>
+		<th>Syncs</th>
 	</tr>
 	{{range $m := $.Managers}}
 	<tr>
 		<td>{{$m.Name}}</td>
+		<td>{{$m.Namespace}}</td>
+		<td>{{$m.Access}}</td>
 		<td>{{$m.Corpus}}</td>
 		<td>{{$m.Added}}</td>
 		<td>{{$m.Deleted}}</td>
 		<td>{{$m.New}}</td>
+		<td>{{$m.Filtered}}</td>
+		<td>{{if not $m.Connected.IsZero}}{{$m.Connected.Format "2006-01-02 15:04:05"}}{{end}}</td>
+		<td>{{if not $m.LastSync.IsZero}}{{$m.LastSync.Format "2006-01-02 15:04:05"}}{{if not $m.Online}} (offline){{end}}{{end}}</td>
+		<td>{{$m.Syncs}}</td>
 	</tr>
 	{{end}}
 </table>
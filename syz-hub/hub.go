@@ -4,15 +4,17 @@
 package main
 
 import (
-	"encoding/json"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"net"
 	"net/rpc"
+	"path/filepath"
+	"regexp"
 	"sync"
 	"time"
 
+	"github.com/google/syzkaller/bloom"
+	"github.com/google/syzkaller/config"
 	. "github.com/google/syzkaller/log"
 	. "github.com/google/syzkaller/rpctype"
 	"github.com/google/syzkaller/syz-hub/state"
@@ -28,16 +30,30 @@ type Config struct {
 	Http     string
 	Rpc      string
 	Workdir  string
-	Managers []struct {
-		Name string
-		Key  string
-	}
+	Managers []ManagerConfig
+}
+
+// ManagerConfig describes credentials and access rights of one manager.
+type ManagerConfig struct {
+	Name string
+	Key  string
+	// Corpus namespace the manager syncs with, the main corpus if empty.
+	// Managers exchange programs only with managers in the same namespace.
+	Namespace string
+	// Access to the namespace corpus: "rw" (default) to send and receive programs,
+	// "r" to only receive programs, "w" to only send programs.
+	Access string
 }
 
+func (mgr *ManagerConfig) canRead() bool  { return mgr.Access != "w" }
+func (mgr *ManagerConfig) canWrite() bool { return mgr.Access != "r" }
+
 type Hub struct {
-	mu   sync.Mutex
-	st   *state.State
-	keys map[string]string
+	mu       sync.Mutex
+	st       map[string]*state.State // namespace -> state
+	managers map[string]*ManagerConfig
+
+	history []corpusSample // corpus sizes sampled every historyPeriod
 }
 
 func main() {
@@ -45,19 +61,14 @@ func main() {
 	cfg = readConfig(*flagConfig)
 	EnableLogCaching(1000, 1<<20)
 
-	st, err := state.Make(cfg.Workdir)
+	hub, err := newHub(cfg)
 	if err != nil {
-		Fatalf("failed to load state: %v", err)
-	}
-	hub := &Hub{
-		st:   st,
-		keys: make(map[string]string),
-	}
-	for _, mgr := range cfg.Managers {
-		hub.keys[mgr.Name] = mgr.Key
+		Fatalf("%v", err)
 	}
 
 	hub.initHttp(cfg.Http)
+	go hub.compactLoop()
+	go hub.historyLoop()
 
 	ln, err := net.Listen("tcp", cfg.Rpc)
 	if err != nil {
@@ -78,51 +89,244 @@ func main() {
 	}
 }
 
-func (hub *Hub) Connect(a *HubConnectArgs, r *int) error {
-	if key, ok := hub.keys[a.Name]; !ok || key != a.Key {
+// newHub loads states of all namespaces used by the managers in cfg.
+func newHub(cfg *Config) (*Hub, error) {
+	hub := &Hub{
+		st:       make(map[string]*state.State),
+		managers: make(map[string]*ManagerConfig),
+	}
+	for i := range cfg.Managers {
+		mgr := &cfg.Managers[i]
+		hub.managers[mgr.Name] = mgr
+		if hub.st[mgr.Namespace] != nil {
+			continue
+		}
+		// The main corpus is stored in the workdir itself.
+		dir := cfg.Workdir
+		if mgr.Namespace != "" {
+			dir = filepath.Join(cfg.Workdir, "namespace", mgr.Namespace)
+		}
+		st, err := state.Make(dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load state: %v", err)
+		}
+		hub.st[mgr.Namespace] = st
+	}
+	return hub, nil
+}
+
+// auth returns config of the manager with the given credentials, or nil if they are wrong.
+func (hub *Hub) auth(name, key string) *ManagerConfig {
+	mgr := hub.managers[name]
+	if mgr == nil || mgr.Key != key {
+		return nil
+	}
+	return mgr
+}
+
+func (hub *Hub) Caps(a *HubCapsArgs, r *uint64) error {
+	if hub.auth(a.Name, a.Key) == nil {
+		Logf(0, "caps from unauthorized manager %v", a.Name)
+		return fmt.Errorf("unauthorized manager")
+	}
+	*r = a.Caps & Caps
+	return nil
+}
+
+func (hub *Hub) Connect(a *HubConnectArgs, r *HubConnectRes) error {
+	mgr := hub.auth(a.Name, a.Key)
+	if mgr == nil {
 		Logf(0, "connect from unauthorized manager %v", a.Name)
 		return fmt.Errorf("unauthorized manager")
 	}
+	if a.Resume {
+		return hub.resume(mgr, a, r)
+	}
+	if len(a.PackedCorpus) != 0 {
+		if err := Unpack(a.PackedCorpus, &a.Corpus); err != nil {
+			Logf(0, "connect from %v: failed to unpack corpus: %v", a.Name, err)
+			return err
+		}
+	}
+	var filter *bloom.Filter
+	if len(a.CorpusBloom) != 0 {
+		var err error
+		if filter, err = bloom.Deserialize(a.CorpusBloom); err != nil {
+			Logf(0, "connect from %v: %v", a.Name, err)
+			return err
+		}
+	}
 	hub.mu.Lock()
 	defer hub.mu.Unlock()
 
-	Logf(0, "connect from %v: fresh=%v calls=%v corpus=%v", a.Name, a.Fresh, len(a.Calls), len(a.Corpus))
-	if err := hub.st.Connect(a.Name, a.Fresh, a.Calls, a.Corpus); err != nil {
+	st := hub.st[mgr.Namespace]
+	var err error
+	if filter != nil && mgr.canWrite() {
+		Logf(0, "connect from %v: fresh=%v calls=%v corpus filter=%vB", a.Name, a.Fresh, len(a.Calls), len(a.CorpusBloom))
+		err = st.ConnectFiltered(a.Name, a.Fresh, a.Calls, filter)
+	} else {
+		Logf(0, "connect from %v: fresh=%v calls=%v corpus=%v", a.Name, a.Fresh, len(a.Calls), len(a.Corpus))
+		if !mgr.canWrite() {
+			a.Corpus = nil
+		}
+		err = st.Connect(a.Name, a.Fresh, a.Calls, a.Corpus)
+	}
+	if err != nil {
 		Logf(0, "connect error: %v", err)
 		return err
 	}
+	if filter != nil {
+		r.CorpusBloom = st.Filter(HubBloomFPRate).Serialize()
+	}
+	return nil
+}
+
+// resume continues syncing with a manager that lost connection (e.g. because the hub restarted).
+// If the hub has no state for the manager, it replies with Resumed unset and the manager connects as usual.
+func (hub *Hub) resume(mgr *ManagerConfig, a *HubConnectArgs, r *HubConnectRes) error {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	filter, err := hub.st[mgr.Namespace].Resume(a.Name, a.Calls, HubBloomFPRate)
+	if err != nil {
+		Logf(0, "resume from %v: %v, requesting full connect", a.Name, err)
+		return nil
+	}
+	Logf(0, "resume from %v: calls=%v", a.Name, len(a.Calls))
+	if mgr.canWrite() {
+		// Read-only managers don't upload programs, so there is nothing to resend.
+		r.CorpusBloom = filter.Serialize()
+	}
+	r.Resumed = true
 	return nil
 }
 
 func (hub *Hub) Sync(a *HubSyncArgs, r *HubSyncRes) error {
-	if key, ok := hub.keys[a.Name]; !ok || key != a.Key {
+	mgr := hub.auth(a.Name, a.Key)
+	if mgr == nil {
 		Logf(0, "sync from unauthorized manager %v", a.Name)
 		return fmt.Errorf("unauthorized manager")
 	}
+	if len(a.PackedAdd) != 0 {
+		if err := Unpack(a.PackedAdd, &a.Add); err != nil {
+			Logf(0, "sync from %v: failed to unpack inputs: %v", a.Name, err)
+			return err
+		}
+	}
 	hub.mu.Lock()
 	defer hub.mu.Unlock()
 
-	inputs, err := hub.st.Sync(a.Name, a.Add, a.Del)
+	if !mgr.canWrite() {
+		// Read-only managers neither add programs nor keep them alive in the corpus.
+		a.Add, a.Del = nil, nil
+	}
+	st := hub.st[mgr.Namespace]
+	if a.Calls != nil {
+		Logf(0, "sync from %v: calls=%v", a.Name, len(a.Calls))
+		if err := st.SetCalls(a.Name, a.Calls); err != nil {
+			Logf(0, "sync error: %v", err)
+			return err
+		}
+	}
+	inputs, err := st.Sync(a.Name, a.Add, a.Del)
 	if err != nil {
 		Logf(0, "sync error: %v", err)
 		return err
 	}
-	r.Inputs = inputs
-	Logf(0, "sync from %v: add=%v del=%v new=%v", a.Name, len(a.Add), len(a.Del), len(inputs))
+	if !mgr.canRead() {
+		inputs = nil
+	}
+	if a.Caps&CapCrashes != 0 {
+		if err := hub.syncCrashes(st, mgr, a, r); err != nil {
+			Logf(0, "sync error: %v", err)
+			return err
+		}
+	}
+	if a.Caps&CapCompression != 0 && len(inputs) != 0 {
+		if r.PackedInputs, err = Pack(inputs); err != nil {
+			return err
+		}
+	} else {
+		r.Inputs = inputs
+	}
+	Logf(0, "sync from %v: add=%v del=%v new=%v crashes=%v new crashes=%v",
+		a.Name, len(a.Add), len(a.Del), len(inputs), len(a.Crashes), len(r.Crashes))
+	return nil
+}
+
+func (hub *Hub) syncCrashes(st *state.State, mgr *ManagerConfig, a *HubSyncArgs, r *HubSyncRes) error {
+	if mgr.canWrite() {
+		var crashes []state.Crash
+		for _, crash := range a.Crashes {
+			crashes = append(crashes, state.Crash{Title: crash.Title, Repro: crash.Repro})
+		}
+		if err := st.AddCrashes(mgr.Name, crashes); err != nil {
+			return err
+		}
+	}
+	if mgr.canRead() {
+		crashes, err := st.PendingCrashes(mgr.Name)
+		if err != nil {
+			return err
+		}
+		for _, crash := range crashes {
+			r.Crashes = append(r.Crashes, HubCrash{
+				Manager: crash.Manager,
+				Title:   crash.Title,
+				Repro:   crash.Repro,
+			})
+		}
+	}
 	return nil
 }
 
+// compactLoop periodically reclaims space in the databases,
+// they grow with every program that managers add and delete.
+func (hub *Hub) compactLoop() {
+	for range time.NewTicker(time.Hour).C {
+		hub.mu.Lock()
+		for ns, st := range hub.st {
+			if err := st.Compact(); err != nil {
+				Logf(0, "failed to compact namespace %q: %v", ns, err)
+			}
+		}
+		hub.mu.Unlock()
+	}
+}
+
 func readConfig(filename string) *Config {
 	if filename == "" {
 		Fatalf("supply config in -config flag")
 	}
-	data, err := ioutil.ReadFile(filename)
-	if err != nil {
-		Fatalf("failed to read config file: %v", err)
-	}
 	cfg := new(Config)
-	if err := json.Unmarshal(data, cfg); err != nil {
-		Fatalf("failed to parse config file: %v", err)
+	if err := config.LoadFile(filename, cfg); err != nil {
+		Fatalf("%v", err)
+	}
+	if cfg.Http == "" || cfg.Rpc == "" || cfg.Workdir == "" {
+		Fatalf("config params http, rpc and workdir must be specified")
+	}
+	names := make(map[string]bool)
+	for i := range cfg.Managers {
+		mgr := &cfg.Managers[i]
+		if mgr.Name == "" || mgr.Key == "" {
+			Fatalf("manager #%v: name and key must be specified", i)
+		}
+		if names[mgr.Name] {
+			Fatalf("duplicate manager %v", mgr.Name)
+		}
+		names[mgr.Name] = true
+		if mgr.Namespace != "" && !namespaceRe.MatchString(mgr.Namespace) {
+			Fatalf("manager %v: bad namespace %q", mgr.Name, mgr.Namespace)
+		}
+		switch mgr.Access {
+		case "":
+			mgr.Access = "rw"
+		case "rw", "r", "w":
+		default:
+			Fatalf("manager %v: bad access %q, want rw, r or w", mgr.Name, mgr.Access)
+		}
 	}
 	return cfg
 }
+
+var namespaceRe = regexp.MustCompile("^[a-zA-Z0-9_-]+$")
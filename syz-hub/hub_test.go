@@ -0,0 +1,113 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	. "github.com/google/syzkaller/rpctype"
+)
+
+func TestAccess(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syz-hub-test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	hub, err := newHub(&Config{
+		Workdir: dir,
+		Managers: []ManagerConfig{
+			{Name: "main0", Key: "key0", Access: "rw"},
+			{Name: "main1", Key: "key1", Access: "rw"},
+			{Name: "ns0", Key: "key2", Namespace: "ns", Access: "rw"},
+			{Name: "ns1", Key: "key3", Namespace: "ns", Access: "rw"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create hub: %v", err)
+	}
+	calls := []string{"getpid", "gettid"}
+
+	// Wrong keys, including keys of managers in other namespaces, and unknown managers are rejected.
+	for _, creds := range [][2]string{
+		{"main0", ""},
+		{"main0", "key1"},
+		{"main0", "key2"},
+		{"ns0", "key0"},
+		{"ns0", "key3"},
+		{"foo", "key0"},
+		{"", ""},
+	} {
+		name, key := creds[0], creds[1]
+		var caps uint64
+		if err := hub.Caps(&HubCapsArgs{Name: name, Key: key, Caps: Caps}, &caps); err == nil {
+			t.Fatalf("%v/%v: caps succeeded", name, key)
+		}
+		connect := &HubConnectArgs{Name: name, Key: key, Fresh: true, Calls: calls,
+			Corpus: [][]byte{[]byte("getpid()\n")}}
+		if err := hub.Connect(connect, new(HubConnectRes)); err == nil {
+			t.Fatalf("%v/%v: connect succeeded", name, key)
+		}
+		sync := &HubSyncArgs{Name: name, Key: key, Caps: CapCrashes,
+			Add: [][]byte{[]byte("gettid()\n")}, Crashes: []HubCrash{{Title: "crash"}}}
+		if err := hub.Sync(sync, new(HubSyncRes)); err == nil {
+			t.Fatalf("%v/%v: sync succeeded", name, key)
+		}
+	}
+	for _, st := range hub.st {
+		if len(st.Managers) != 0 || len(st.Corpus.Records) != 0 || len(st.Crashes.Records) != 0 {
+			t.Fatalf("rejected managers changed state: %v managers, %v programs, %v crashes",
+				len(st.Managers), len(st.Corpus.Records), len(st.Crashes.Records))
+		}
+	}
+
+	connect := func(name, key string, corpus ...string) {
+		a := &HubConnectArgs{Name: name, Key: key, Fresh: true, Calls: calls}
+		for _, p := range corpus {
+			a.Corpus = append(a.Corpus, []byte(p))
+		}
+		if err := hub.Connect(a, new(HubConnectRes)); err != nil {
+			t.Fatalf("%v: failed to connect: %v", name, err)
+		}
+	}
+	sync := func(name, key string, crashes ...HubCrash) *HubSyncRes {
+		r := new(HubSyncRes)
+		if err := hub.Sync(&HubSyncArgs{Name: name, Key: key, Caps: CapCrashes, Crashes: crashes}, r); err != nil {
+			t.Fatalf("%v: failed to sync: %v", name, err)
+		}
+		return r
+	}
+	connect("main0", "key0", "getpid()\n")
+	connect("ns0", "key2", "gettid()\n")
+	connect("main1", "key1")
+	connect("ns1", "key3")
+	sync("main0", "key0", HubCrash{Title: "main crash", Repro: []byte("getpid()\n")})
+	sync("ns0", "key2", HubCrash{Title: "ns crash", Repro: []byte("gettid()\n")})
+
+	// Managers receive programs and crashes only from their own namespace.
+	tests := []struct {
+		name, key string
+		inputs    [][]byte
+		crashes   []HubCrash
+	}{
+		{"main1", "key1", [][]byte{[]byte("getpid()\n")},
+			[]HubCrash{{Manager: "main0", Title: "main crash", Repro: []byte("getpid()\n")}}},
+		{"ns1", "key3", [][]byte{[]byte("gettid()\n")},
+			[]HubCrash{{Manager: "ns0", Title: "ns crash", Repro: []byte("gettid()\n")}}},
+		{"main0", "key0", nil, nil},
+		{"ns0", "key2", nil, nil},
+	}
+	for _, test := range tests {
+		r := sync(test.name, test.key)
+		if !reflect.DeepEqual(r.Inputs, test.inputs) {
+			t.Fatalf("%v: got inputs %q, want %q", test.name, r.Inputs, test.inputs)
+		}
+		if !reflect.DeepEqual(r.Crashes, test.crashes) {
+			t.Fatalf("%v: got crashes %+v, want %+v", test.name, r.Crashes, test.crashes)
+		}
+	}
+}
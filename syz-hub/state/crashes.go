@@ -0,0 +1,99 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+
+	"github.com/google/syzkaller/db"
+	"github.com/google/syzkaller/hash"
+	. "github.com/google/syzkaller/log"
+)
+
+// Crash is a crash found by a manager. Every manager has at most one crash with the same title,
+// the crash is updated when the manager finds a reproducer for it.
+type Crash struct {
+	Manager string
+	Title   string
+	Repro   []byte
+}
+
+func (st *State) loadCrashes() error {
+	var err error
+	st.Crashes, err = db.Open(filepath.Join(st.dir, "crashes.db"))
+	if err != nil {
+		return fmt.Errorf("failed to open crash database: %v", err)
+	}
+	for key, rec := range st.Crashes.Records {
+		crash := new(Crash)
+		if err := json.Unmarshal(rec.Val, crash); err != nil {
+			Logf(0, "bad crash record: %v", err)
+			st.Crashes.Delete(key)
+			continue
+		}
+		if st.crashSeq < rec.Seq {
+			st.crashSeq = rec.Seq
+		}
+	}
+	return st.Crashes.Flush()
+}
+
+// AddCrashes records crashes found by the manager.
+func (st *State) AddCrashes(name string, crashes []Crash) error {
+	mgr := st.Managers[name]
+	if mgr == nil || mgr.Connected.IsZero() {
+		return fmt.Errorf("unconnected manager %v", name)
+	}
+	if len(crashes) == 0 {
+		return nil
+	}
+	st.crashSeq++
+	for _, crash := range crashes {
+		crash.Manager = name
+		key := hash.String([]byte(name + "\n" + crash.Title))
+		if rec, ok := st.Crashes.Records[key]; ok && crash.Repro == nil {
+			old := new(Crash)
+			if err := json.Unmarshal(rec.Val, old); err == nil && old.Repro != nil {
+				// Don't lose the reproducer if the crash happens again.
+				continue
+			}
+		}
+		data, err := json.Marshal(crash)
+		if err != nil {
+			return err
+		}
+		st.Crashes.Save(key, data, st.crashSeq)
+	}
+	return st.Crashes.Flush()
+}
+
+// PendingCrashes returns crashes found by other managers that were not sent to the manager yet.
+func (st *State) PendingCrashes(name string) ([]Crash, error) {
+	mgr := st.Managers[name]
+	if mgr == nil || mgr.Connected.IsZero() {
+		return nil, fmt.Errorf("unconnected manager %v", name)
+	}
+	if mgr.crashSeq == st.crashSeq {
+		return nil, nil
+	}
+	var crashes []Crash
+	for _, rec := range st.Crashes.Records {
+		if rec.Seq <= mgr.crashSeq {
+			continue
+		}
+		var crash Crash
+		if err := json.Unmarshal(rec.Val, &crash); err != nil {
+			return nil, fmt.Errorf("bad crash record: %v", err)
+		}
+		if crash.Manager == name {
+			continue
+		}
+		crashes = append(crashes, crash)
+	}
+	mgr.crashSeq = st.crashSeq
+	writeFile(filepath.Join(mgr.dir, "crash_seq"), []byte(fmt.Sprint(mgr.crashSeq)))
+	return crashes, nil
+}
@@ -9,8 +9,10 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/google/syzkaller/bloom"
 	"github.com/google/syzkaller/db"
 	"github.com/google/syzkaller/hash"
 	. "github.com/google/syzkaller/log"
@@ -24,6 +26,9 @@ type State struct {
 	dir      string
 	Corpus   *db.DB
 	Managers map[string]*Manager
+
+	crashSeq uint64
+	Crashes  *db.DB // crashes shared by managers, see AddCrashes
 }
 
 // Manager represents one syz-manager instance.
@@ -35,8 +40,14 @@ type Manager struct {
 	Added     int
 	Deleted   int
 	New       int
+	Filtered  int // programs not sent because the manager does not support some of their syscalls
 	Calls     map[string]struct{}
 	Corpus    *db.DB
+
+	LastSync time.Time
+	Syncs    int
+
+	crashSeq uint64 // last crash sent to the manager
 }
 
 // Make creates State and initializes it from dir.
@@ -71,6 +82,10 @@ func Make(dir string) (*State, error) {
 		Fatalf("failed to flush corpus database: %v", err)
 	}
 
+	if err := st.loadCrashes(); err != nil {
+		return nil, err
+	}
+
 	managersDir := filepath.Join(st.dir, "manager")
 	os.MkdirAll(managersDir, 0700)
 	managers, err := ioutil.ReadDir(managersDir)
@@ -88,6 +103,12 @@ func Make(dir string) (*State, error) {
 		if st.seq < mgr.seq {
 			st.seq = mgr.seq
 		}
+		crashSeqStr, _ := ioutil.ReadFile(filepath.Join(mgr.dir, "crash_seq"))
+		mgr.crashSeq, _ = strconv.ParseUint(string(crashSeqStr), 10, 64)
+		if callsStr, err := ioutil.ReadFile(filepath.Join(mgr.dir, "calls")); err == nil {
+			// The manager can resume syncing without uploading its corpus again.
+			mgr.setCalls(strings.Fields(string(callsStr)))
+		}
 		mgr.Corpus, err = db.Open(filepath.Join(mgr.dir, "corpus.db"))
 		if err != nil {
 			return nil, fmt.Errorf("failed to open manager corpus database %v: %v", mgr.dir, err)
@@ -98,9 +119,92 @@ func Make(dir string) (*State, error) {
 }
 
 func (st *State) Connect(name string, fresh bool, calls []string, corpus [][]byte) error {
+	mgr, err := st.connect(name, fresh, calls)
+	if err != nil {
+		return err
+	}
+	st.addInputs(mgr, corpus)
+	st.purgeCorpus()
+	return nil
+}
+
+// ConnectFiltered is Connect for a manager that sends only a Bloom filter of its corpus signatures.
+// Programs that match the filter are assumed to be in the manager corpus,
+// the manager sends the rest of its corpus with the following syncs.
+func (st *State) ConnectFiltered(name string, fresh bool, calls []string, filter *bloom.Filter) error {
+	mgr, err := st.connect(name, fresh, calls)
+	if err != nil {
+		return err
+	}
+	for key := range st.Corpus.Records {
+		sig, err := hash.FromString(key)
+		if err != nil {
+			return err
+		}
+		if filter.Test(sig) {
+			mgr.Corpus.Save(key, nil, 0)
+		}
+	}
+	if err := mgr.Corpus.Flush(); err != nil {
+		Logf(0, "failed to flush corpus database: %v", err)
+	}
+	st.purgeCorpus()
+	return nil
+}
+
+// Filter returns a Bloom filter of the corpus signatures.
+func (st *State) Filter(fpRate float64) *bloom.Filter {
+	filter := bloom.New(len(st.Corpus.Records), fpRate)
+	for key := range st.Corpus.Records {
+		if sig, err := hash.FromString(key); err == nil {
+			filter.Add(sig)
+		}
+	}
+	return filter
+}
+
+// Resume continues syncing with a manager that was connected before the hub restarted
+// (or before the manager lost connection for other reasons) without a full connect.
+// It returns a Bloom filter of the manager corpus signatures known to the hub,
+// the manager needs to send programs that are not in the filter again.
+func (st *State) Resume(name string, calls []string, fpRate float64) (*bloom.Filter, error) {
+	mgr := st.Managers[name]
+	if mgr == nil || mgr.Calls == nil {
+		return nil, fmt.Errorf("no state for manager %v", name)
+	}
+	mgr.Connected = time.Now()
+	mgr.setCalls(calls)
+	filter := bloom.New(len(mgr.Corpus.Records), fpRate)
+	for key := range mgr.Corpus.Records {
+		if sig, err := hash.FromString(key); err == nil {
+			filter.Add(sig)
+		}
+	}
+	return filter, nil
+}
+
+// Compact reclaims space occupied by deleted programs in all databases.
+func (st *State) Compact() error {
+	if err := st.Corpus.Compact(); err != nil {
+		return fmt.Errorf("failed to compact corpus database: %v", err)
+	}
+	if err := st.Crashes.Compact(); err != nil {
+		return fmt.Errorf("failed to compact crash database: %v", err)
+	}
+	for _, mgr := range st.Managers {
+		if err := mgr.Corpus.Compact(); err != nil {
+			return fmt.Errorf("failed to compact manager %v corpus database: %v", mgr.name, err)
+		}
+	}
+	return nil
+}
+
+func (st *State) connect(name string, fresh bool, calls []string) (*Manager, error) {
 	mgr := st.Managers[name]
 	if mgr == nil {
-		mgr = new(Manager)
+		mgr = &Manager{
+			name: name,
+		}
 		st.Managers[name] = mgr
 		mgr.dir = filepath.Join(st.dir, "manager", name)
 		os.MkdirAll(mgr.dir, 0700)
@@ -108,13 +212,12 @@ func (st *State) Connect(name string, fresh bool, calls []string, corpus [][]byt
 	mgr.Connected = time.Now()
 	if fresh {
 		mgr.seq = 0
+		mgr.crashSeq = 0
 	}
 	writeFile(filepath.Join(mgr.dir, "seq"), []byte(fmt.Sprint(mgr.seq)))
+	writeFile(filepath.Join(mgr.dir, "crash_seq"), []byte(fmt.Sprint(mgr.crashSeq)))
 
-	mgr.Calls = make(map[string]struct{})
-	for _, c := range calls {
-		mgr.Calls[c] = struct{}{}
-	}
+	mgr.setCalls(calls)
 
 	corpusFile := filepath.Join(mgr.dir, "corpus.db")
 	os.Remove(corpusFile)
@@ -122,11 +225,9 @@ func (st *State) Connect(name string, fresh bool, calls []string, corpus [][]byt
 	mgr.Corpus, err = db.Open(corpusFile)
 	if err != nil {
 		Logf(0, "failed to open corpus database: %v", err)
-		return err
+		return nil, err
 	}
-	st.addInputs(mgr, corpus)
-	st.purgeCorpus()
-	return nil
+	return mgr, nil
 }
 
 func (st *State) Sync(name string, add [][]byte, del []string) ([][]byte, error) {
@@ -148,9 +249,32 @@ func (st *State) Sync(name string, add [][]byte, del []string) ([][]byte, error)
 	mgr.Added += len(add)
 	mgr.Deleted += len(del)
 	mgr.New += len(inputs)
+	mgr.LastSync = time.Now()
+	mgr.Syncs++
 	return inputs, err
 }
 
+// SetCalls updates the set of syscalls enabled on the manager,
+// programs with other syscalls are not sent to the manager.
+func (st *State) SetCalls(name string, calls []string) error {
+	mgr := st.Managers[name]
+	if mgr == nil || mgr.Connected.IsZero() {
+		return fmt.Errorf("unconnected manager %v", name)
+	}
+	mgr.setCalls(calls)
+	return nil
+}
+
+func (mgr *Manager) setCalls(calls []string) {
+	mgr.Calls = make(map[string]struct{})
+	for _, c := range calls {
+		mgr.Calls[c] = struct{}{}
+	}
+	if mgr.dir != "" {
+		writeFile(filepath.Join(mgr.dir, "calls"), []byte(strings.Join(calls, "\n")))
+	}
+}
+
 func (st *State) pendingInputs(mgr *Manager) ([][]byte, error) {
 	if mgr.seq == st.seq {
 		return nil, nil
@@ -168,6 +292,7 @@ func (st *State) pendingInputs(mgr *Manager) ([][]byte, error) {
 			return nil, fmt.Errorf("failed to extract call set: %v\nprogram: %s", err, rec.Val)
 		}
 		if !managerSupportsAllCalls(mgr.Calls, calls) {
+			mgr.Filtered++
 			continue
 		}
 		inputs = append(inputs, rec.Val)
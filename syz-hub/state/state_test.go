@@ -7,6 +7,9 @@ import (
 	"io/ioutil"
 	"os"
 	"testing"
+
+	"github.com/google/syzkaller/bloom"
+	"github.com/google/syzkaller/hash"
 )
 
 func TestState(t *testing.T) {
@@ -25,3 +28,172 @@ func TestState(t *testing.T) {
 		t.Fatalf("synced with unconnected manager")
 	}
 }
+
+func TestConnectFiltered(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syz-hub-state-test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	st, err := Make(dir)
+	if err != nil {
+		t.Fatalf("failed to make state: %v", err)
+	}
+	calls := []string{"getpid", "gettid"}
+	progs := [][]byte{[]byte("getpid()\n"), []byte("gettid()\n")}
+	if err := st.Connect("foo", true, calls, progs); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	filter := bloom.New(1, 0.001)
+	filter.Add(hash.Hash(progs[0]))
+	if err := st.ConnectFiltered("bar", true, calls, filter); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	inputs, err := st.Sync("bar", nil, nil)
+	if err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+	if len(inputs) != 1 || string(inputs[0]) != string(progs[1]) {
+		t.Fatalf("got inputs %q, want only %q", inputs, progs[1])
+	}
+	if !st.Filter(0.001).Test(hash.Hash(progs[1])) {
+		t.Fatalf("program is not in corpus filter")
+	}
+}
+
+func TestSetCalls(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syz-hub-state-test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	st, err := Make(dir)
+	if err != nil {
+		t.Fatalf("failed to make state: %v", err)
+	}
+	calls := []string{"getpid", "gettid"}
+	progs := [][]byte{[]byte("getpid()\n"), []byte("gettid()\n")}
+	if err := st.Connect("foo", true, calls, progs); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	if err := st.SetCalls("bar", calls); err == nil {
+		t.Fatalf("set calls of unconnected manager")
+	}
+	if err := st.Connect("bar", true, calls, nil); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	if err := st.SetCalls("bar", calls[:1]); err != nil {
+		t.Fatalf("failed to set calls: %v", err)
+	}
+	inputs, err := st.Sync("bar", nil, nil)
+	if err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+	if len(inputs) != 1 || string(inputs[0]) != string(progs[0]) {
+		t.Fatalf("got inputs %q, want only %q", inputs, progs[0])
+	}
+	if filtered := st.Managers["bar"].Filtered; filtered != 1 {
+		t.Fatalf("filtered %v programs, want 1", filtered)
+	}
+}
+
+func TestCrashes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syz-hub-state-test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	st, err := Make(dir)
+	if err != nil {
+		t.Fatalf("failed to make state: %v", err)
+	}
+	if err := st.AddCrashes("foo", []Crash{{Title: "BUG"}}); err == nil {
+		t.Fatalf("added crashes of unconnected manager")
+	}
+	for _, name := range []string{"foo", "bar"} {
+		if err := st.Connect(name, true, nil, nil); err != nil {
+			t.Fatalf("failed to connect: %v", err)
+		}
+	}
+	repro := []byte("getpid()\n")
+	if err := st.AddCrashes("foo", []Crash{{Title: "BUG", Repro: repro}, {Title: "WARNING"}}); err != nil {
+		t.Fatalf("failed to add crashes: %v", err)
+	}
+	// The crash happens again, but the reproducer must not be lost.
+	if err := st.AddCrashes("foo", []Crash{{Title: "BUG"}}); err != nil {
+		t.Fatalf("failed to add crashes: %v", err)
+	}
+	if crashes, err := st.PendingCrashes("foo"); err != nil || len(crashes) != 0 {
+		t.Fatalf("got own crashes %+v, err %v", crashes, err)
+	}
+	crashes, err := st.PendingCrashes("bar")
+	if err != nil || len(crashes) != 2 {
+		t.Fatalf("got crashes %+v, err %v, want 2 crashes", crashes, err)
+	}
+	for _, crash := range crashes {
+		if crash.Manager != "foo" || crash.Title == "BUG" && string(crash.Repro) != string(repro) {
+			t.Fatalf("bad crash %+v", crash)
+		}
+	}
+	if crashes, err := st.PendingCrashes("bar"); err != nil || len(crashes) != 0 {
+		t.Fatalf("got crashes %+v again, err %v", crashes, err)
+	}
+	// The state must survive restart.
+	st, err = Make(dir)
+	if err != nil {
+		t.Fatalf("failed to make state: %v", err)
+	}
+	if len(st.Crashes.Records) != 2 {
+		t.Fatalf("got %v crashes after restart, want 2", len(st.Crashes.Records))
+	}
+}
+
+func TestResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syz-hub-state-test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	st, err := Make(dir)
+	if err != nil {
+		t.Fatalf("failed to make state: %v", err)
+	}
+	if _, err := st.Resume("foo", nil, 0.001); err == nil {
+		t.Fatalf("resumed unknown manager")
+	}
+	calls := []string{"getpid", "gettid"}
+	progs := [][]byte{[]byte("getpid()\n"), []byte("gettid()\n")}
+	if err := st.Connect("foo", true, calls[:1], progs[:1]); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	if err := st.Connect("bar", true, calls, progs[1:]); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+
+	// Restart the hub.
+	st, err = Make(dir)
+	if err != nil {
+		t.Fatalf("failed to make state: %v", err)
+	}
+	if err := st.Compact(); err != nil {
+		t.Fatalf("failed to compact: %v", err)
+	}
+	filter, err := st.Resume("foo", calls[:1], 0.001)
+	if err != nil {
+		t.Fatalf("failed to resume: %v", err)
+	}
+	if !filter.Test(hash.Hash(progs[0])) || filter.Test(hash.Hash(progs[1])) {
+		t.Fatalf("bad manager corpus filter")
+	}
+	inputs, err := st.Sync("foo", nil, nil)
+	if err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+	if len(inputs) != 0 {
+		t.Fatalf("got inputs %q, want none: gettid is not enabled", inputs)
+	}
+}
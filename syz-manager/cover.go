@@ -10,13 +10,17 @@ import (
 	"html/template"
 	"io"
 	"io/ioutil"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/google/syzkaller/cover"
 	. "github.com/google/syzkaller/log"
+	. "github.com/google/syzkaller/rpctype"
 	"github.com/google/syzkaller/symbolizer"
 )
 
@@ -49,9 +53,30 @@ func (a uint64Array) Len() int           { return len(a) }
 func (a uint64Array) Less(i, j int) bool { return a[i] < a[j] }
 func (a uint64Array) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 
+// kernelModule is a kernel module loaded in VMs with the object file that contains its code.
+type kernelModule struct {
+	RpcModule
+	obj string // "" if the object file is not found
+}
+
+type moduleArray []*kernelModule
+
+func (a moduleArray) Len() int           { return len(a) }
+func (a moduleArray) Less(i, j int) bool { return a[i].Addr < a[j].Addr }
+func (a moduleArray) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+// moduleCover is the result of objdump of a module object file.
+type moduleCover struct {
+	symbols symbolArray // functions in .text
+	pcs     []uint64    // sorted offsets of __sanitizer_cov_trace_pc calls in .text
+}
+
 var (
 	allCoverPCs   []uint64
 	allCoverReady = make(chan bool)
+
+	moduleCoverMu sync.Mutex
+	moduleCovers  = make(map[string]*moduleCover) // object file -> cover
 )
 
 const (
@@ -72,33 +97,135 @@ func initAllCover(vmlinux string) {
 	}()
 }
 
-func generateCoverHtml(w io.Writer, vmlinux string, cov []uint32) error {
+// findModules locates object files of the loaded modules in dirs and returns the modules sorted by address.
+// Module names in /proc/modules use underscores, while object files can use dashes.
+func findModules(dirs []string, loaded []RpcModule) []*kernelModule {
+	objs := make(map[string]string)
+	for _, dir := range dirs {
+		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() || !strings.HasSuffix(path, ".ko") {
+				return nil
+			}
+			name := strings.Replace(strings.TrimSuffix(info.Name(), ".ko"), "-", "_", -1)
+			if objs[name] == "" {
+				objs[name] = path
+			}
+			return nil
+		})
+	}
+	var modules []*kernelModule
+	found := 0
+	for _, m := range loaded {
+		obj := objs[m.Name]
+		if obj != "" {
+			found++
+		} else {
+			Logf(1, "can't find object file of kernel module %v", m.Name)
+		}
+		modules = append(modules, &kernelModule{m, obj})
+	}
+	if len(loaded) != 0 {
+		Logf(0, "found object files for %v/%v loaded kernel modules", found, len(loaded))
+	}
+	sort.Sort(moduleArray(modules))
+	return modules
+}
+
+// findModule returns the module that contains pc, or nil.
+func findModule(modules []*kernelModule, pc uint64) *kernelModule {
+	idx := sort.Search(len(modules), func(i int) bool {
+		return pc < modules[i].Addr+modules[i].Size
+	})
+	if idx == len(modules) || pc < modules[idx].Addr {
+		return nil
+	}
+	return modules[idx]
+}
+
+// coverFrames symbolizes covered PCs and not covered instrumented PCs in the same functions.
+// PCs in modules are symbolized against the module object files, PCs in modules without object files are ignored.
+func coverFrames(vmlinux string, modules []*kernelModule, cov []uint32) (coveredFrames, uncoveredFrames []symbolizer.Frame, prefix string, err error) {
 	if len(cov) == 0 {
-		return fmt.Errorf("No coverage data available")
+		return nil, nil, "", fmt.Errorf("No coverage data available")
 	}
 
 	base, err := getVmOffset(vmlinux)
 	if err != nil {
-		return err
+		return nil, nil, "", err
 	}
-	pcs := make([]uint64, len(cov))
-	for i, pc := range cov {
-		pcs[i] = cover.RestorePC(pc, base) - callLen
+	var pcs []uint64
+	modulePCs := make(map[*kernelModule][]uint64) // offsets in .text of the module
+	for _, pc32 := range cov {
+		pc := cover.RestorePC(pc32, base) - callLen
+		if m := findModule(modules, pc); m != nil {
+			if m.obj != "" && pc >= m.Text {
+				modulePCs[m] = append(modulePCs[m], pc-m.Text)
+			}
+			continue
+		}
+		pcs = append(pcs, pc)
 	}
-	uncovered, err := uncoveredPcsInFuncs(vmlinux, pcs)
-	if err != nil {
-		return err
+	if len(pcs) != 0 {
+		uncovered, err := uncoveredPcsInFuncs(vmlinux, pcs)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		if coveredFrames, err = symbolize(vmlinux, pcs); err != nil {
+			return nil, nil, "", err
+		}
+		if len(coveredFrames) == 0 {
+			return nil, nil, "", fmt.Errorf("'%s' does not have debug info (set CONFIG_DEBUG_INFO=y)", vmlinux)
+		}
+		if uncoveredFrames, err = symbolize(vmlinux, uncovered); err != nil {
+			return nil, nil, "", err
+		}
+	}
+	for _, m := range modules {
+		if len(modulePCs[m]) == 0 {
+			continue
+		}
+		covered, uncovered, err := moduleFrames(m, modulePCs[m])
+		if err != nil {
+			return nil, nil, "", fmt.Errorf("failed to symbolize coverage of module %v: %v", m.Name, err)
+		}
+		coveredFrames = append(coveredFrames, covered...)
+		uncoveredFrames = append(uncoveredFrames, uncovered...)
+	}
+	if len(coveredFrames) == 0 {
+		return nil, nil, "", fmt.Errorf("no coverage in vmlinux or kernel modules with object files")
 	}
+	prefix = filePrefix(coveredFrames, filePrefix(uncoveredFrames, ""))
+	return coveredFrames, uncoveredFrames, prefix, nil
+}
 
-	coveredFrames, prefix, err := symbolize(vmlinux, pcs)
+// moduleFrames symbolizes covered offsets in .text of the module and not covered instrumented PCs
+// in the same functions. Frame PCs are converted back to kernel addresses.
+func moduleFrames(m *kernelModule, pcs []uint64) (coveredFrames, uncoveredFrames []symbolizer.Frame, err error) {
+	mc, err := readModuleCover(m.obj)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	if len(coveredFrames) == 0 {
-		return fmt.Errorf("'%s' does not have debug info (set CONFIG_DEBUG_INFO=y)", vmlinux)
+	uncovered := uncoveredPCs(mc.symbols, mc.pcs, pcs)
+	symb := symbolizer.NewSymbolizer()
+	defer symb.Close()
+	if coveredFrames, err = symb.SymbolizeSection(m.obj, ".text", pcs); err != nil {
+		return nil, nil, err
+	}
+	if uncoveredFrames, err = symb.SymbolizeSection(m.obj, ".text", uncovered); err != nil {
+		return nil, nil, err
+	}
+	// See symbolize for the -1.
+	for i := range coveredFrames {
+		coveredFrames[i].PC += m.Text - 1
 	}
+	for i := range uncoveredFrames {
+		uncoveredFrames[i].PC += m.Text - 1
+	}
+	return coveredFrames, uncoveredFrames, nil
+}
 
-	uncoveredFrames, prefix, err := symbolize(vmlinux, uncovered)
+func generateCoverHtml(w io.Writer, vmlinux string, modules []*kernelModule, cov []uint32) error {
+	coveredFrames, uncoveredFrames, prefix, err := coverFrames(vmlinux, modules, cov)
 	if err != nil {
 		return err
 	}
@@ -146,6 +273,35 @@ func generateCoverHtml(w io.Writer, vmlinux string, cov []uint32) error {
 	return nil
 }
 
+// generateCoverExport writes coverage in lcov or json format (see cover.WriteLCOV and cover.WriteJSON),
+// hits is the number of inputs that cover every PC.
+func generateCoverExport(w io.Writer, vmlinux string, modules []*kernelModule, format string, cov []uint32, hits map[uint32]int) error {
+	coveredFrames, uncoveredFrames, _, err := coverFrames(vmlinux, modules, cov)
+	if err != nil {
+		return err
+	}
+	var lines []cover.LineCover
+	funcs := make(map[string]bool)
+	for _, frame := range coveredFrames {
+		// Frame PCs point before the call instruction (see symbolize), hits are keyed by truncated return PCs.
+		pc := uint32(frame.PC + 1 + callLen)
+		lines = append(lines, cover.LineCover{File: frame.File, Line: frame.Line, Func: frame.Func, Hits: hits[pc]})
+		funcs[frame.Func] = true
+	}
+	for _, frame := range uncoveredFrames {
+		if funcs[frame.Func] {
+			lines = append(lines, cover.LineCover{File: frame.File, Line: frame.Line, Func: frame.Func})
+		}
+	}
+	switch format {
+	case "lcov":
+		return cover.WriteLCOV(w, "syzkaller", lines)
+	case "json":
+		return cover.WriteJSON(w, lines)
+	}
+	return fmt.Errorf("unknown coverage format %v", format)
+}
+
 func fileSet(covered, uncovered []symbolizer.Frame) map[string][]coverage {
 	files := make(map[string]map[int]bool)
 	funcs := make(map[string]bool)
@@ -248,10 +404,14 @@ func uncoveredPcsInFuncs(vmlinux string, pcs []uint64) ([]uint64, error) {
 	sort.Sort(symbols)
 
 	<-allCoverReady
-	if len(allCoverPCs) == 0 {
-		return nil, nil
-	}
+	return uncoveredPCs(symbols, allCoverPCs, pcs), nil
+}
 
+// uncoveredPCs returns PCs from sorted allPCs that are in the same functions as pcs, but are not in pcs.
+func uncoveredPCs(symbols symbolArray, allPCs []uint64, pcs []uint64) []uint64 {
+	if len(allPCs) == 0 {
+		return nil
+	}
 	handledFuncs := make(map[uint64]bool)
 	uncovered := make(map[uint64]bool)
 	for _, pc := range pcs {
@@ -267,23 +427,92 @@ func uncoveredPcsInFuncs(vmlinux string, pcs []uint64) ([]uint64, error) {
 		}
 		if !handledFuncs[s.start] {
 			handledFuncs[s.start] = true
-			startPC := sort.Search(len(allCoverPCs), func(i int) bool {
-				return s.start <= allCoverPCs[i]
+			startPC := sort.Search(len(allPCs), func(i int) bool {
+				return s.start <= allPCs[i]
 			})
-			endPC := sort.Search(len(allCoverPCs), func(i int) bool {
-				return s.end < allCoverPCs[i]
+			endPC := sort.Search(len(allPCs), func(i int) bool {
+				return s.end < allPCs[i]
 			})
-			for _, pc1 := range allCoverPCs[startPC:endPC] {
+			for _, pc1 := range allPCs[startPC:endPC] {
 				uncovered[pc1] = true
 			}
 		}
 		delete(uncovered, pc)
 	}
-	uncoveredPCs := make([]uint64, 0, len(uncovered))
+	res := make([]uint64, 0, len(uncovered))
 	for pc := range uncovered {
-		uncoveredPCs = append(uncoveredPCs, pc)
+		res = append(res, pc)
+	}
+	return res
+}
+
+// readModuleCover returns functions and instrumented PCs in .text of the module object file (cached).
+func readModuleCover(obj string) (*moduleCover, error) {
+	moduleCoverMu.Lock()
+	defer moduleCoverMu.Unlock()
+	if mc := moduleCovers[obj]; mc != nil {
+		return mc, nil
+	}
+	mc, err := objdumpModule(obj)
+	if err != nil {
+		return nil, err
+	}
+	moduleCovers[obj] = mc
+	return mc, nil
+}
+
+// objdumpModule disassembles .text of the relocatable module object file.
+// Calls are not relocated in the object file, so __sanitizer_cov_trace_pc calls are found by relocations.
+func objdumpModule(obj string) (*moduleCover, error) {
+	out, err := exec.Command("objdump", "-dr", "--no-show-raw-insn", "-j", ".text", obj).Output()
+	if err != nil {
+		return nil, fmt.Errorf("objdump failed: %v", err)
+	}
+	mc := new(moduleCover)
+	var last uint64 // offset of the last instruction
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		ln := strings.TrimSpace(s.Text())
+		colon := strings.IndexByte(ln, ':')
+		if colon == -1 {
+			continue
+		}
+		// A function starts as: "0000000000000010 <loop_set_fd>:"
+		if strings.HasSuffix(ln, ">:") {
+			lt := strings.IndexByte(ln, '<')
+			if lt == -1 {
+				continue
+			}
+			addr, err := strconv.ParseUint(strings.TrimSpace(ln[:lt]), 16, 64)
+			if err != nil {
+				continue
+			}
+			if n := len(mc.symbols); n != 0 {
+				mc.symbols[n-1].end = addr
+			}
+			mc.symbols = append(mc.symbols, symbol{addr, addr, ln[lt+1 : len(ln)-2]})
+			continue
+		}
+		off, err := strconv.ParseUint(ln[:colon], 16, 64)
+		if err != nil {
+			continue
+		}
+		// A relocation looks as: "1b: R_X86_64_PLT32	__sanitizer_cov_trace_pc-0x4",
+		// it points to the operand of the call instruction.
+		if strings.HasPrefix(ln[colon+1:], " R_") {
+			if strings.Contains(ln, "__sanitizer_cov_trace_pc") && off != 0 {
+				mc.pcs = append(mc.pcs, off-1)
+			}
+			continue
+		}
+		last = off
 	}
-	return uncoveredPCs, nil
+	if n := len(mc.symbols); n != 0 {
+		mc.symbols[n-1].end = last + 1
+	}
+	sort.Sort(mc.symbols)
+	sort.Sort(uint64Array(mc.pcs))
+	return mc, nil
 }
 
 // coveredPCs returns list of PCs of __sanitizer_cov_trace_pc calls in binary bin.
@@ -326,33 +555,36 @@ func coveredPCs(bin string) ([]uint64, error) {
 	return pcs, nil
 }
 
-func symbolize(vmlinux string, pcs []uint64) ([]symbolizer.Frame, string, error) {
+func symbolize(vmlinux string, pcs []uint64) ([]symbolizer.Frame, error) {
 	symb := symbolizer.NewSymbolizer()
 	defer symb.Close()
 
 	frames, err := symb.SymbolizeArray(vmlinux, pcs)
 	if err != nil {
-		return nil, "", err
+		return nil, err
 	}
-
-	prefix := ""
 	for i := range frames {
-		frame := &frames[i]
-		frame.PC--
+		frames[i].PC--
+	}
+	return frames, nil
+}
+
+// filePrefix returns the common prefix of prefix (if not empty) and file names of frames.
+func filePrefix(frames []symbolizer.Frame, prefix string) string {
+	for _, frame := range frames {
 		if prefix == "" {
 			prefix = frame.File
-		} else {
-			i := 0
-			for ; i < len(prefix) && i < len(frame.File); i++ {
-				if prefix[i] != frame.File[i] {
-					break
-				}
+			continue
+		}
+		i := 0
+		for ; i < len(prefix) && i < len(frame.File); i++ {
+			if prefix[i] != frame.File[i] {
+				break
 			}
-			prefix = prefix[:i]
 		}
-
+		prefix = prefix[:i]
 	}
-	return frames, prefix, nil
+	return prefix
 }
 
 type templateData struct {
@@ -0,0 +1,131 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"net/rpc"
+	"path/filepath"
+	"time"
+
+	. "github.com/google/syzkaller/log"
+	"github.com/google/syzkaller/repro"
+	. "github.com/google/syzkaller/rpctype"
+)
+
+// Crashes and reproducers are reported to the dashboard (syz-dash) every minute,
+// the reports also tell the dashboard what kernel (config tag) the manager tests.
+
+const (
+	dashMaxLog     = 1 << 20 // only the tail of larger crash logs is sent
+	dashMaxPending = 100     // max number of crash titles queued while the dashboard is unreachable
+)
+
+var dashLog = Named("dash")
+
+func (mgr *Manager) dashLoop() {
+	for {
+		time.Sleep(time.Minute)
+		mgr.dashReport()
+	}
+}
+
+// dashCrash queues the crash for the next report, crashes with the same title are merged.
+// Must be called with mgr.mu held.
+func (mgr *Manager) dashCrash(crash DashCrash) {
+	for i := range mgr.dashCrashes {
+		if mgr.dashCrashes[i].Title == crash.Title {
+			mgr.dashCrashes[i].Count += crash.Count
+			return
+		}
+	}
+	if len(mgr.dashCrashes) >= dashMaxPending {
+		dashLog.Logf(1, "dropping crash '%v': too many pending crashes", crash.Title)
+		return
+	}
+	mgr.dashCrashes = append(mgr.dashCrashes, crash)
+}
+
+func (mgr *Manager) dashSaveCrash(crash *Crash) {
+	output := crash.output
+	if len(output) > dashMaxLog {
+		output = output[len(output)-dashMaxLog:]
+	}
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+	mgr.dashCrash(DashCrash{
+		Title:  crash.desc,
+		Count:  1,
+		Log:    output,
+		Report: []byte(crash.text),
+	})
+}
+
+// dashSaveRepro queues the reproducer saved in the crash dir for the next report.
+func (mgr *Manager) dashSaveRepro(crash *Crash, dir string) {
+	syz, err := ioutil.ReadFile(filepath.Join(dir, repro.ProgFile))
+	if err != nil {
+		dashLog.Logf(0, "failed to read repro: %v", err)
+		return
+	}
+	// There is no C reproducer if the crash is not reproducible with C program.
+	c, _ := ioutil.ReadFile(filepath.Join(dir, repro.CProgFile))
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+	mgr.dashRepros = append(mgr.dashRepros, DashRepro{
+		Title:  crash.desc,
+		Syz:    syz,
+		C:      c,
+		Report: []byte(crash.text),
+	})
+}
+
+func (mgr *Manager) dashReport() {
+	mgr.mu.Lock()
+	a := &DashReportArgs{
+		Name:    mgr.cfg.Name,
+		Key:     mgr.cfg.Dashboard_Key,
+		Tag:     mgr.cfg.Tag,
+		Crashes: mgr.dashCrashes,
+		Repros:  mgr.dashRepros,
+	}
+	mgr.dashCrashes = nil
+	mgr.dashRepros = nil
+	mgr.mu.Unlock()
+
+	// The rpc can take a while (crash logs are large), so it is done without holding mgr.mu.
+	err := mgr.dashCall(a)
+	if err == nil {
+		if len(a.Crashes) != 0 || len(a.Repros) != 0 {
+			dashLog.Logf(0, "reported %v crashes, %v reproducers", len(a.Crashes), len(a.Repros))
+		}
+		return
+	}
+	dashLog.Logf(0, "Dash.Report rpc failed: %v", err)
+	// Queue the crashes again, they are reported when the dashboard becomes reachable.
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+	crashes := mgr.dashCrashes
+	mgr.dashCrashes = nil
+	for _, crash := range append(a.Crashes, crashes...) {
+		mgr.dashCrash(crash)
+	}
+	mgr.dashRepros = append(a.Repros, mgr.dashRepros...)
+}
+
+func (mgr *Manager) dashCall(a *DashReportArgs) error {
+	if mgr.dash == nil {
+		conn, err := rpc.Dial("tcp", mgr.cfg.Dashboard_Addr)
+		if err != nil {
+			return err
+		}
+		mgr.dash = conn
+	}
+	if err := mgr.dash.Call("Dash.Report", a, new(int)); err != nil {
+		mgr.dash.Close()
+		mgr.dash = nil
+		return err
+	}
+	return nil
+}
@@ -4,6 +4,9 @@
 package main
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"fmt"
 	"html/template"
 	"io"
@@ -13,15 +16,21 @@ import (
 	_ "net/http/pprof"
 	"os"
 	"path/filepath"
+	"reflect"
 	"runtime"
 	"sort"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/google/syzkaller/cover"
+	"github.com/google/syzkaller/csource"
+	"github.com/google/syzkaller/hash"
 	. "github.com/google/syzkaller/log"
 	"github.com/google/syzkaller/prog"
+	"github.com/google/syzkaller/report"
+	"github.com/google/syzkaller/repro"
 	"github.com/google/syzkaller/sys"
 )
 
@@ -33,8 +42,13 @@ func (mgr *Manager) initHttp() {
 	http.HandleFunc("/crash", mgr.httpCrash)
 	http.HandleFunc("/cover", mgr.httpCover)
 	http.HandleFunc("/prio", mgr.httpPrio)
+	http.HandleFunc("/weights", mgr.httpWeights)
+	http.HandleFunc("/errnos", mgr.httpErrnos)
 	http.HandleFunc("/file", mgr.httpFile)
 	http.HandleFunc("/report", mgr.httpReport)
+	http.HandleFunc("/bundle", mgr.httpBundle)
+	http.HandleFunc("/cprog", mgr.httpCProg)
+	http.HandleFunc("/log", ServeHTTP)
 
 	ln, err := net.Listen("tcp4", mgr.cfg.Http)
 	if err != nil {
@@ -58,12 +72,37 @@ func (mgr *Manager) httpSummary(w http.ResponseWriter, r *http.Request) {
 	data.Stats = append(data.Stats, UIStat{Name: "fuzzing", Value: fmt.Sprint(mgr.fuzzingTime / 60e9 * 60e9)})
 	data.Stats = append(data.Stats, UIStat{Name: "corpus", Value: fmt.Sprint(len(mgr.corpus))})
 	data.Stats = append(data.Stats, UIStat{Name: "triage queue", Value: fmt.Sprint(len(mgr.candidates))})
+	if mgr.vmChecked {
+		data.Stats = append(data.Stats, UIStat{Name: "syscalls",
+			Value: fmt.Sprintf("%v (%v disabled as unavailable)", len(mgr.enabledCalls), len(mgr.disabledCalls))})
+	}
+	if info := mgr.machine; info != nil {
+		data.MachineCached = mgr.machineCached
+		data.Machine = []UIStat{
+			{Name: "kernel", Value: info.Kernel},
+			{Name: "supported syscalls", Value: fmt.Sprint(info.Syscalls)},
+			{Name: "namespaces", Value: strings.Join(info.Namespaces, " ")},
+			{Name: "kcov", Value: fmt.Sprint(info.Kcov)},
+			{Name: "kcov comparisons", Value: fmt.Sprint(info.KcovComparisons)},
+			{Name: "fault injection", Value: fmt.Sprint(info.FaultInjection)},
+			{Name: "leak checking", Value: fmt.Sprint(info.LeakChecking)},
+			{Name: "usb emulation", Value: fmt.Sprint(info.UsbEmulation)},
+		}
+		data.MachineWarnings = machineMismatches(mgr.cfg, info)
+	}
 
-	var err error
-	if data.Crashes, err = mgr.collectCrashes(); err != nil {
+	crashes, err := mgr.collectCrashes()
+	if err != nil {
 		http.Error(w, fmt.Sprintf("failed to collect crashes: %v", err), http.StatusInternalServerError)
 		return
 	}
+	for _, crash := range crashes {
+		if report.IsDataRace(crash.Description) {
+			data.Races = append(data.Races, crash)
+		} else {
+			data.Crashes = append(data.Crashes, crash)
+		}
+	}
 
 	type CallCov struct {
 		count int
@@ -94,10 +133,25 @@ func (mgr *Manager) httpSummary(w http.ResponseWriter, r *http.Request) {
 			Inputs:      cc.count,
 			Cover:       len(cc.cov),
 			UniqueCover: len(unique),
+			Slow:        mgr.slowCalls[c],
 		})
 	}
+	for c, slow := range mgr.slowCalls {
+		if calls[c] == nil {
+			data.Calls = append(data.Calls, UICallType{
+				Name: c,
+				Slow: slow,
+			})
+		}
+	}
 	sort.Sort(UICallTypeArray(data.Calls))
 	data.Stats = append(data.Stats, UIStat{Name: "cover", Value: fmt.Sprint(len(cov)), Link: "/cover"})
+	if mgr.weightsFrom != "" {
+		data.Stats = append(data.Stats, UIStat{Name: "boosted syscalls", Value: fmt.Sprint(len(mgr.callWeights)), Link: "/weights"})
+	}
+	if len(mgr.callResults) != 0 {
+		data.Stats = append(data.Stats, UIStat{Name: "errnos", Value: fmt.Sprint(len(mgr.callResults)), Link: "/errnos"})
+	}
 
 	var intStats []UIStat
 	for k, v := range mgr.stats {
@@ -114,7 +168,13 @@ func (mgr *Manager) httpSummary(w http.ResponseWriter, r *http.Request) {
 	}
 	sort.Sort(UIStatArray(intStats))
 	data.Stats = append(data.Stats, intStats...)
-	data.Log = CachedLogOutput()
+	data.LogName = r.FormValue("log")
+	data.LogBuffers = Buffers()
+	if data.LogName != "" {
+		data.Log = CachedNamedOutput(data.LogName)
+	} else {
+		data.Log = CachedLogOutput()
+	}
 
 	if err := summaryTemplate.Execute(w, data); err != nil {
 		http.Error(w, fmt.Sprintf("failed to execute template: %v", err), http.StatusInternalServerError)
@@ -178,14 +238,24 @@ func (mgr *Manager) httpCover(w http.ResponseWriter, r *http.Request) {
 	var cov cover.Cover
 	call := r.FormValue("call")
 	unique := r.FormValue("unique") != "" && call != ""
+	format := r.FormValue("format")
+	hits := make(map[uint32]int) // number of inputs that cover the PC, only for export formats
 	perCall := false
 	if n, err := strconv.Atoi(call); err == nil && n < len(mgr.corpus) {
 		cov = mgr.corpus[n].Cover
+		for _, pc := range cov {
+			hits[pc] = 1
+		}
 	} else {
 		perCall = true
 		for _, inp := range mgr.corpus {
 			if call == "" || call == inp.Call {
 				cov = cover.Union(cov, cover.Cover(inp.Cover))
+				if format != "" {
+					for _, pc := range inp.Cover {
+						hits[pc]++
+					}
+				}
 			}
 		}
 	}
@@ -193,7 +263,22 @@ func (mgr *Manager) httpCover(w http.ResponseWriter, r *http.Request) {
 		cov = cover.Intersection(cov, mgr.uniqueCover(perCall))
 	}
 
-	if err := generateCoverHtml(w, mgr.cfg.Vmlinux, cov); err != nil {
+	if format != "" {
+		var buf bytes.Buffer
+		if err := generateCoverExport(&buf, mgr.cfg.Vmlinux, mgr.modules, format, cov, hits); err != nil {
+			http.Error(w, fmt.Sprintf("failed to export coverage: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if format == "json" {
+			w.Header().Set("Content-Type", "application/json")
+		} else {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		}
+		w.Write(buf.Bytes())
+		runtime.GC()
+		return
+	}
+	if err := generateCoverHtml(w, mgr.cfg.Vmlinux, mgr.modules, cov); err != nil {
 		http.Error(w, fmt.Sprintf("failed to generate coverage profile: %v", err), http.StatusInternalServerError)
 		return
 	}
@@ -257,6 +342,22 @@ func (mgr *Manager) httpPrio(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (mgr *Manager) httpWeights(w http.ResponseWriter, r *http.Request) {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	data := &UIPrioData{Call: mgr.weightsFrom}
+	for call, weight := range mgr.callWeights {
+		data.Prios = append(data.Prios, UIPrio{call, weight})
+	}
+	sort.Sort(UIPrioArray(data.Prios))
+
+	if err := weightsTemplate.Execute(w, data); err != nil {
+		http.Error(w, fmt.Sprintf("failed to execute template: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
 func (mgr *Manager) httpFile(w http.ResponseWriter, r *http.Request) {
 	mgr.mu.Lock()
 	defer mgr.mu.Unlock()
@@ -291,6 +392,7 @@ func (mgr *Manager) httpReport(w http.ResponseWriter, r *http.Request) {
 	prog, _ := ioutil.ReadFile(filepath.Join(mgr.crashdir, crashID, "repro.prog"))
 	cprog, _ := ioutil.ReadFile(filepath.Join(mgr.crashdir, crashID, "repro.cprog"))
 	report, _ := ioutil.ReadFile(filepath.Join(mgr.crashdir, crashID, "repro.report"))
+	meta, _ := repro.LoadMeta(filepath.Join(mgr.crashdir, crashID))
 
 	fmt.Fprintf(w, "Syzkaller hit '%s' bug on commit %s.\n\n", trimNewLines(desc), trimNewLines(tag))
 	if len(report) != 0 {
@@ -299,6 +401,9 @@ func (mgr *Manager) httpReport(w http.ResponseWriter, r *http.Request) {
 	if len(prog) == 0 && len(cprog) == 0 {
 		fmt.Fprintf(w, "The bug is not reproducible.\n")
 	} else {
+		if meta != nil {
+			fmt.Fprintf(w, "Reproducer reliability: %v.\n\n", meta.Confidence())
+		}
 		fmt.Fprintf(w, "Syzkaller reproducer:\n%s\n\n", prog)
 		if len(cprog) != 0 {
 			fmt.Fprintf(w, "C reproducer:\n%s\n\n", cprog)
@@ -306,6 +411,179 @@ func (mgr *Manager) httpReport(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// httpCProg generates C reproducer for a crash with harness options selected on the crash page.
+// Without the options it uses the options the reproducer was found with.
+func (mgr *Manager) httpCProg(w http.ResponseWriter, r *http.Request) {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	crashID := r.FormValue("id")
+	data, err := ioutil.ReadFile(filepath.Join(mgr.crashdir, crashID, "repro.prog"))
+	if err != nil {
+		http.Error(w, "failed to read repro file", http.StatusInternalServerError)
+		return
+	}
+	p, err := prog.Deserialize(data)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to deserialize program: %v", err), http.StatusInternalServerError)
+		return
+	}
+	opts := readReproOpts(filepath.Join(mgr.crashdir, crashID), data)
+	if r.FormValue("harness") != "" {
+		opts.Threaded = r.FormValue("threaded") != ""
+		opts.Collide = r.FormValue("collide") != ""
+		opts.Faithful = r.FormValue("faithful") != ""
+		opts.Repeat = r.FormValue("repeat") != ""
+		opts.NoFork = r.FormValue("nofork") != ""
+		opts.Sandbox = r.FormValue("sandbox")
+		opts.Netns = r.FormValue("netns") != ""
+		opts.Tun = r.FormValue("tun") != ""
+		opts.Minimal = r.FormValue("minimal") != ""
+		opts.Procs, err = strconv.Atoi(r.FormValue("procs"))
+		if err != nil || opts.Procs < 1 {
+			http.Error(w, "bad procs value", http.StatusBadRequest)
+			return
+		}
+	}
+	src, err := csource.Write(p, opts)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to generate C source: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if formatted, err := csource.Format(src); err == nil {
+		src = formatted
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(src)
+}
+
+// readReproOpts returns csource options the reproducer in dir was found with.
+// Reproducers saved without repro.json have the options only in the first line of repro.prog.
+func readReproOpts(dir string, prog []byte) csource.Options {
+	if meta, err := repro.LoadMeta(dir); err == nil {
+		return meta.Opts
+	}
+	return parseReproOpts(prog)
+}
+
+// parseReproOpts parses csource options saved in the first line of repro.prog,
+// it has "# {Threaded:true Collide:true ...}" format.
+func parseReproOpts(data []byte) csource.Options {
+	opts := csource.Options{
+		Threaded: true,
+		Collide:  true,
+		Repeat:   true,
+		Procs:    1,
+		Sandbox:  "none",
+	}
+	if pos := strings.IndexByte(string(data), '\n'); pos != -1 {
+		data = data[:pos]
+	}
+	line := strings.TrimSpace(string(data))
+	if !strings.HasPrefix(line, "# {") || !strings.HasSuffix(line, "}") {
+		return opts
+	}
+	v := reflect.ValueOf(&opts).Elem()
+	for _, kv := range strings.Fields(line[3 : len(line)-1]) {
+		pos := strings.IndexByte(kv, ':')
+		if pos == -1 {
+			continue
+		}
+		f := v.FieldByName(kv[:pos])
+		val := kv[pos+1:]
+		switch f.Kind() {
+		case reflect.Bool:
+			f.SetBool(val == "true")
+		case reflect.Int:
+			if n, err := strconv.Atoi(val); err == nil {
+				f.SetInt(int64(n))
+			}
+		case reflect.String:
+			f.SetString(val)
+		}
+	}
+	return opts
+}
+
+// httpBundle serves a tar.gz archive with everything needed to debug a crash:
+// description, the latest report and console log, reproducers, kernel tag and kernel config.
+func (mgr *Manager) httpBundle(w http.ResponseWriter, r *http.Request) {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	crashID := r.FormValue("id")
+	if _, err := hash.FromString(crashID); err != nil {
+		http.Error(w, "bad crash id", http.StatusBadRequest)
+		return
+	}
+	crash := mgr.readCrash(crashID, true)
+	if crash == nil {
+		http.Error(w, "failed to read crash info", http.StatusInternalServerError)
+		return
+	}
+	dir := filepath.Join(mgr.crashdir, crashID)
+	type bundleFile struct {
+		name string
+		file string
+	}
+	files := []bundleFile{
+		{"description", filepath.Join(dir, "description")},
+		{"repro.prog", filepath.Join(dir, "repro.prog")},
+		{"repro.cprog", filepath.Join(dir, "repro.cprog")},
+		{"repro.report", filepath.Join(dir, "repro.report")},
+		{"repro.tag", filepath.Join(dir, "repro.tag")},
+		{"repro.json", filepath.Join(dir, "repro.json")},
+		// Kernel config is expected to be in the build dir next to vmlinux.
+		{"kernel.config", filepath.Join(filepath.Dir(mgr.cfg.Vmlinux), ".config")},
+	}
+	if len(crash.Crashes) != 0 {
+		// Crashes are sorted by time, so the first one is the latest.
+		index := strconv.Itoa(crash.Crashes[0].Index)
+		files = append(files, []bundleFile{
+			{"log", filepath.Join(dir, "log"+index)},
+			{"report", filepath.Join(dir, "report"+index)},
+			{"tag", filepath.Join(dir, "tag"+index)},
+		}...)
+	}
+
+	// The archive is assembled in memory, so that a failure results in an error
+	// rather than in a truncated archive served with 200 status.
+	buf := new(bytes.Buffer)
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f.file)
+		if err != nil {
+			continue
+		}
+		hdr := &tar.Header{
+			Name:    filepath.Join(crashID, f.name),
+			Mode:    0640,
+			Size:    int64(len(data)),
+			ModTime: time.Now(),
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			http.Error(w, fmt.Sprintf("failed to write crash bundle: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if _, err := tw.Write(data); err != nil {
+			http.Error(w, fmt.Sprintf("failed to write crash bundle: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tw.Close(); err != nil {
+		http.Error(w, fmt.Sprintf("failed to write crash bundle: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := gz.Close(); err != nil {
+		http.Error(w, fmt.Sprintf("failed to write crash bundle: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%v.tar.gz", crashID))
+	w.Write(buf.Bytes())
+}
+
 func (mgr *Manager) collectCrashes() ([]*UICrashType, error) {
 	dirs, err := readdirnames(mgr.crashdir)
 	if err != nil {
@@ -336,6 +614,13 @@ func (mgr *Manager) readCrash(dir string, full bool) *UICrashType {
 		return nil
 	}
 	desc = trimNewLines(desc)
+	guilty, _ := ioutil.ReadFile(filepath.Join(mgr.crashdir, dir, "guilty"))
+	guilty = trimNewLines(guilty)
+	var maintainers []string
+	if full {
+		data, _ := ioutil.ReadFile(filepath.Join(mgr.crashdir, dir, "maintainers"))
+		maintainers = strings.Fields(string(data))
+	}
 	stat, err := descFile.Stat()
 	if err != nil {
 		return nil
@@ -365,7 +650,7 @@ func (mgr *Manager) readCrash(dir string, full bool) *UICrashType {
 			hasRepro = true
 		} else if f == "repro.cprog" {
 			hasCRepro = true
-		} else if f == "repro.report" {
+		} else if f == "repro.report" || f == "repro.json" {
 		} else if f == "repro0" || f == "repro1" || f == "repro2" {
 			reproAttempts++
 		}
@@ -399,14 +684,31 @@ func (mgr *Manager) readCrash(dir string, full bool) *UICrashType {
 	} else if reproAttempts >= maxReproAttempts {
 		triaged = "non-reproducible"
 	}
-	return &UICrashType{
+	crash := &UICrashType{
 		Description: string(desc),
+		Guilty:      string(guilty),
+		Subsystem:   report.GuiltySubsystem(string(guilty)),
+		Maintainers: maintainers,
+		Score:       report.Score(string(desc)),
 		LastTime:    modTime.Format(dateFormat),
 		ID:          dir,
 		Count:       len(crashes),
 		Triaged:     triaged,
 		Crashes:     crashes,
+		Hub:         mgr.hubTitles[string(desc)],
+	}
+	if origin, err := ioutil.ReadFile(filepath.Join(mgr.crashdir, dir, "hub")); err == nil {
+		crash.Hub = append([]string{string(trimNewLines(origin))}, crash.Hub...)
+	}
+	if full && hasRepro {
+		data, _ := ioutil.ReadFile(filepath.Join(mgr.crashdir, dir, "repro.prog"))
+		crash.HasRepro = true
+		crash.ReproOpts = readReproOpts(filepath.Join(mgr.crashdir, dir), data)
+		if meta, err := repro.LoadMeta(filepath.Join(mgr.crashdir, dir)); err == nil {
+			crash.ReproStats = meta.Confidence()
+		}
 	}
+	return crash
 }
 
 func readdirnames(dir string) ([]string, error) {
@@ -426,20 +728,36 @@ func trimNewLines(data []byte) []byte {
 }
 
 type UISummaryData struct {
-	Name    string
-	Stats   []UIStat
-	Calls   []UICallType
-	Crashes []*UICrashType
-	Log     string
+	Name            string
+	Stats           []UIStat
+	Machine         []UIStat
+	MachineCached   bool
+	MachineWarnings []string // config params that need features the machine does not have
+	Calls           []UICallType
+	Crashes         []*UICrashType
+	Races           []*UICrashType
+	Log             string
+	LogName         string   // named log buffer shown instead of the whole log
+	LogBuffers      []string // names of all log buffers (VM instances and subsystems)
 }
 
 type UICrashType struct {
 	Description string
+	Guilty      string
+	Subsystem   string
+	Maintainers []string
+	Score       int
 	LastTime    string
 	ID          string
 	Count       int
 	Triaged     string
 	Crashes     []*UICrash
+	Hub         []string // other managers that found the crash
+
+	// Options of the C reproducer harness, set if the crash has a repro.
+	HasRepro   bool
+	ReproOpts  csource.Options
+	ReproStats string
 }
 
 type UICrash struct {
@@ -462,6 +780,7 @@ type UICallType struct {
 	Inputs      int
 	Cover       int
 	UniqueCover int
+	Slow        uint64
 }
 
 type UIInput struct {
@@ -493,9 +812,16 @@ func (a UIStatArray) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 
 type UICrashTypeArray []*UICrashType
 
-func (a UICrashTypeArray) Len() int           { return len(a) }
-func (a UICrashTypeArray) Less(i, j int) bool { return a[i].Description < a[j].Description }
-func (a UICrashTypeArray) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a UICrashTypeArray) Len() int      { return len(a) }
+func (a UICrashTypeArray) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+
+// Less sorts crashes by decreasing score, so that the most severe crashes are triaged first.
+func (a UICrashTypeArray) Less(i, j int) bool {
+	if a[i].Score != a[j].Score {
+		return a[i].Score > a[j].Score
+	}
+	return a[i].Description < a[j].Description
+}
 
 type UICrashArray []*UICrash
 
@@ -530,17 +856,41 @@ var summaryTemplate = template.Must(template.New("").Parse(addStyle(`
 </table>
 <br>
 
+{{if $.Machine}}
+<table>
+	<caption>Machine{{if $.MachineCached}} (cached from the previous run){{end}}:</caption>
+	{{range $s := $.Machine}}
+	<tr>
+		<td>{{$s.Name}}</td>
+		<td>{{$s.Value}}</td>
+	</tr>
+	{{end}}
+	{{range $w := $.MachineWarnings}}
+	<tr>
+		<td><b>warning</b></td>
+		<td><b>{{$w}}</b></td>
+	</tr>
+	{{end}}
+</table>
+<br>
+{{end}}
+
 <table>
 	<caption>Crashes:</caption>
 	<tr>
 		<th>Description</th>
+		<th>Score</th>
+		<th>Subsystem</th>
 		<th>Count</th>
 		<th>Last Time</th>
 		<th>Report</th>
+		<th>Hub</th>
 	</tr>
 	{{range $c := $.Crashes}}
 	<tr>
 		<td><a href="/crash?id={{$c.ID}}">{{$c.Description}}</a></td>
+		<td>{{$c.Score}}</td>
+		<td title="{{$c.Guilty}}">{{$c.Subsystem}}</td>
 		<td>{{$c.Count}}</td>
 		<td>{{$c.LastTime}}</td>
 		<td>
@@ -548,12 +898,42 @@ var summaryTemplate = template.Must(template.New("").Parse(addStyle(`
 				<a href="/report?id={{$c.ID}}">{{$c.Triaged}}</a>
 			{{end}}
 		</td>
+		<td>{{range $c.Hub}}{{.}} {{end}}</td>
 	</tr>
 	{{end}}
 </table>
 <br>
 
+{{if $.Races}}
+<table>
+	<caption>Data races:</caption>
+	<tr>
+		<th>Description</th>
+		<th>Count</th>
+		<th>Last Time</th>
+		<th>Report</th>
+	</tr>
+	{{range $c := $.Races}}
+	<tr>
+		<td><a href="/crash?id={{$c.ID}}">{{$c.Description}}</a></td>
+		<td>{{$c.Count}}</td>
+		<td>{{$c.LastTime}}</td>
+		<td>
+			{{if $c.Triaged}}
+				<a href="/report?id={{$c.ID}}">{{$c.Triaged}}</a>
+			{{end}}
+		</td>
+	</tr>
+	{{end}}
+</table>
+<br>
+{{end}}
+
 <b>Log:</b>
+{{if $.LogName}}<a href="/">all</a>{{else}}<b>all</b>{{end}}
+{{range $b := $.LogBuffers}}
+	{{if eq $b $.LogName}}<b>{{$b}}</b>{{else}}<a href="/?log={{$b}}">{{$b}}</a>{{end}}
+{{end}}
 <br>
 <textarea id="log_textarea" readonly rows="20">
 {{.Log}}
@@ -572,6 +952,7 @@ var summaryTemplate = template.Must(template.New("").Parse(addStyle(`
 		<a href='/corpus?call={{$c.Name}}'>inputs:{{$c.Inputs}}</a>
 		<a href='/cover?call={{$c.Name}}'>cover:{{$c.Cover}}</a>
 		<a href='/cover?call={{$c.Name}}&unique=1'>unique:{{$c.UniqueCover}}</a>
+		{{if $c.Slow}}slow:{{$c.Slow}}{{end}}
 		<a href='/prio?call={{$c.Name}}'>prio</a> <br>
 {{end}}
 </body></html>
@@ -585,14 +966,50 @@ var crashTemplate = template.Must(template.New("").Parse(addStyle(`
 	{{STYLE}}
 </head>
 <body>
-<b>{{.Description}}</b>
+<b>{{.Description}}</b> (score {{.Score}})
 <br><br>
 
+{{if .Guilty}}
+Guilty file: {{.Guilty}}
+<br>
+{{end}}
+{{if .Maintainers}}
+Maintainers: {{range $m := .Maintainers}}<a href="mailto:{{$m}}">{{$m}}</a> {{end}}
+<br>
+{{end}}
+<br>
+
 {{if .Triaged}}
 Report: <a href="/report?id={{.ID}}">{{.Triaged}}</a>
 {{end}}
+<a href="/bundle?id={{.ID}}">download bundle</a>
 <br><br>
 
+{{if .HasRepro}}
+{{if .ReproStats}}Reproducer: {{.ReproStats}}<br>{{end}}
+<form action="/cprog" method="get">
+	<input type="hidden" name="id" value="{{.ID}}">
+	<input type="hidden" name="harness" value="1">
+	C reproducer:
+	<label><input type="checkbox" name="threaded" {{if .ReproOpts.Threaded}}checked{{end}}>threaded</label>
+	<label><input type="checkbox" name="collide" {{if .ReproOpts.Collide}}checked{{end}}>collide</label>
+	<label><input type="checkbox" name="faithful" {{if .ReproOpts.Faithful}}checked{{end}}>faithful</label>
+	<label><input type="checkbox" name="repeat" {{if .ReproOpts.Repeat}}checked{{end}}>repeat</label>
+	<label><input type="checkbox" name="nofork" {{if .ReproOpts.NoFork}}checked{{end}}>no fork</label>
+	<label>procs <input type="number" name="procs" min="1" value="{{.ReproOpts.Procs}}"></label>
+	<label>sandbox <select name="sandbox">
+		<option value="none" {{if eq .ReproOpts.Sandbox "none"}}selected{{end}}>none</option>
+		<option value="setuid" {{if eq .ReproOpts.Sandbox "setuid"}}selected{{end}}>setuid</option>
+		<option value="namespace" {{if eq .ReproOpts.Sandbox "namespace"}}selected{{end}}>namespace</option>
+	</select></label>
+	<label><input type="checkbox" name="netns" {{if .ReproOpts.Netns}}checked{{end}}>netns</label>
+	<label><input type="checkbox" name="tun" {{if .ReproOpts.Tun}}checked{{end}}>tun</label>
+	<label><input type="checkbox" name="minimal" {{if .ReproOpts.Minimal}}checked{{end}}>minimal</label>
+	<input type="submit" value="generate">
+</form>
+<br>
+{{end}}
+
 <table>
 	<tr>
 		<th>#</th>
@@ -635,6 +1052,43 @@ var corpusTemplate = template.Must(template.New("").Parse(addStyle(`
 </body></html>
 `)))
 
+func (mgr *Manager) httpErrnos(w http.ResponseWriter, r *http.Request) {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	var data []UICallErrnos
+	for call, cr := range mgr.callResults {
+		res := UICallErrnos{
+			Call:    call,
+			Samples: cr.Samples,
+			Blocked: float64(cr.Blocked) * 100 / float64(cr.Samples),
+		}
+		if finished := cr.Samples - cr.Blocked; finished != 0 {
+			res.Cover = cr.Cover / finished
+			res.Duration = cr.Duration / time.Duration(finished)
+		}
+		for errno, n := range cr.Errnos {
+			name := "success"
+			if errno != 0 {
+				name = syscall.Errno(errno).Error()
+			}
+			res.Errnos = append(res.Errnos, UIErrno{
+				Errno:   errno,
+				Name:    name,
+				Percent: float64(n) * 100 / float64(cr.Samples),
+			})
+		}
+		sort.Sort(UIErrnoArray(res.Errnos))
+		data = append(data, res)
+	}
+	sort.Sort(UICallErrnosArray(data))
+
+	if err := errnosTemplate.Execute(w, data); err != nil {
+		http.Error(w, fmt.Sprintf("failed to execute template: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
 type UIPrioData struct {
 	Call  string
 	Prios []UIPrio
@@ -651,6 +1105,69 @@ func (a UIPrioArray) Len() int           { return len(a) }
 func (a UIPrioArray) Less(i, j int) bool { return a[i].Prio > a[j].Prio }
 func (a UIPrioArray) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 
+type UICallErrnos struct {
+	Call     string
+	Samples  uint64
+	Blocked  float64       // percent of samples where the call did not finish
+	Cover    uint64        // average coverage size of finished calls
+	Duration time.Duration // average duration of finished calls
+	Errnos   []UIErrno
+}
+
+type UIErrno struct {
+	Errno   int
+	Name    string
+	Percent float64
+}
+
+type UICallErrnosArray []UICallErrnos
+
+func (a UICallErrnosArray) Len() int           { return len(a) }
+func (a UICallErrnosArray) Less(i, j int) bool { return a[i].Call < a[j].Call }
+func (a UICallErrnosArray) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+type UIErrnoArray []UIErrno
+
+func (a UIErrnoArray) Len() int           { return len(a) }
+func (a UIErrnoArray) Less(i, j int) bool { return a[i].Percent > a[j].Percent }
+func (a UIErrnoArray) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+var errnosTemplate = template.Must(template.New("").Parse(addStyle(`
+<!doctype html>
+<html>
+<head>
+	<title>syzkaller errnos</title>
+	{{STYLE}}
+</head>
+<body>
+<table>
+	<caption>Sampled call results:</caption>
+	<tr>
+		<th>Syscall</th>
+		<th>Samples</th>
+		<th>Avg cover</th>
+		<th>Avg time</th>
+		<th>Blocked</th>
+		<th>Errnos</th>
+	</tr>
+	{{range $c := $}}
+	<tr>
+		<td>{{$c.Call}}</td>
+		<td>{{$c.Samples}}</td>
+		<td>{{$c.Cover}}</td>
+		<td>{{$c.Duration}}</td>
+		<td>{{if $c.Blocked}}{{printf "%.1f" $c.Blocked}}%{{end}}</td>
+		<td>
+			{{range $e := $c.Errnos}}
+				{{$e.Name}} ({{$e.Errno}}): {{printf "%.1f" $e.Percent}}%<br>
+			{{end}}
+		</td>
+	</tr>
+	{{end}}
+</table>
+</body></html>
+`)))
+
 var prioTemplate = template.Must(template.New("").Parse(addStyle(`
 <!doctype html>
 <html>
@@ -666,6 +1183,21 @@ Priorities for {{$.Call}} <br> <br>
 </body></html>
 `)))
 
+var weightsTemplate = template.Must(template.New("").Parse(addStyle(`
+<!doctype html>
+<html>
+<head>
+	<title>syzkaller adaptive weights</title>
+	{{STYLE}}
+</head>
+<body>
+Syscalls boosted due to recent new coverage (as reported by {{$.Call}}) <br> <br>
+{{range $p := $.Prios}}
+	{{printf "%.4f\t%s" $p.Prio $p.Call}} <br>
+{{end}}
+</body></html>
+`)))
+
 func addStyle(html string) string {
 	return strings.Replace(html, "{{STYLE}}", htmlStyle, -1)
 }
@@ -0,0 +1,104 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/syzkaller/config"
+	"github.com/google/syzkaller/hash"
+)
+
+func TestHttpBundle(t *testing.T) {
+	workdir, err := ioutil.TempDir("", "syz-manager-test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(workdir)
+	crashID := hash.String([]byte("KASAN: use-after-free in foo"))
+	files := map[string]string{
+		filepath.Join("crashes", crashID, "description"): "KASAN: use-after-free in foo\n",
+		filepath.Join("crashes", crashID, "log0"):        "log",
+		filepath.Join("crashes", crashID, "report0"):     "report",
+		filepath.Join("crashes", crashID, "repro.prog"):  "getpid()\n",
+		filepath.Join("kernel", ".config"):               "CONFIG_KASAN=y\n",
+		filepath.Join("passwd"):                          "secret",
+	}
+	for name, data := range files {
+		file := filepath.Join(workdir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mgr := &Manager{
+		cfg: &config.Config{
+			Workdir: workdir,
+			Vmlinux: filepath.Join(workdir, "kernel", "vmlinux"),
+		},
+		crashdir: filepath.Join(workdir, "crashes"),
+	}
+
+	get := func(id string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mgr.httpBundle(rec, httptest.NewRequest("GET", "/bundle?id="+id, nil))
+		return rec
+	}
+	// IDs that are not hashes must not reach the file system.
+	for _, id := range []string{"", "..", "../passwd", "../../" + crashID[6:], strings.ToUpper(crashID) + "0"} {
+		if rec := get(id); rec.Code != http.StatusBadRequest {
+			t.Fatalf("id %q: status %v, want %v", id, rec.Code, http.StatusBadRequest)
+		}
+	}
+	if rec := get(hash.String([]byte("foo"))); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("unknown crash: status %v", rec.Code)
+	}
+
+	rec := get(crashID)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %v: %s", rec.Code, rec.Body.Bytes())
+	}
+	gz, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("failed to read bundle: %v", err)
+	}
+	got := make(map[string]string)
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed to read bundle: %v", err)
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("failed to read bundle: %v", err)
+		}
+		got[hdr.Name] = string(data)
+	}
+	want := map[string]string{
+		filepath.Join(crashID, "description"):   "KASAN: use-after-free in foo\n",
+		filepath.Join(crashID, "repro.prog"):    "getpid()\n",
+		filepath.Join(crashID, "kernel.config"): "CONFIG_KASAN=y\n",
+		filepath.Join(crashID, "log"):           "log",
+		filepath.Join(crashID, "report"):        "report",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("bad bundle contents:\n%q\nwant:\n%q", got, want)
+	}
+}
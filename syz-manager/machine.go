@@ -0,0 +1,121 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/google/syzkaller/config"
+	. "github.com/google/syzkaller/log"
+	. "github.com/google/syzkaller/rpctype"
+)
+
+// Machine info reported by the VM check is cached in workdir/machine/<tag>.json,
+// so that it (and mismatches with the config) is shown right after restart before VMs boot.
+
+var machineTagRe = regexp.MustCompile(`[^a-zA-Z0-9._-]`)
+
+// machineInfoFile returns the cache file for the kernel image (identified by tag).
+func machineInfoFile(cfg *config.Config) string {
+	tag := cfg.Tag
+	if tag == "" {
+		tag = "default"
+	}
+	return filepath.Join(cfg.Workdir, "machine", machineTagRe.ReplaceAllString(tag, "_")+".json")
+}
+
+func (mgr *Manager) loadMachineInfo() {
+	data, err := ioutil.ReadFile(machineInfoFile(mgr.cfg))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			Logf(0, "failed to read machine info: %v", err)
+		}
+		return
+	}
+	info := new(RpcMachineInfo)
+	if err := json.Unmarshal(data, info); err != nil {
+		Logf(0, "failed to parse machine info: %v", err)
+		return
+	}
+	mgr.machine = info
+	mgr.machineCached = true
+	for _, msg := range machineMismatches(mgr.cfg, info) {
+		Logf(0, "WARNING: %v (cached machine info for tag %q)", msg, mgr.cfg.Tag)
+	}
+}
+
+// updateMachineInfo remembers machine info reported by a VM and updates the cache.
+func (mgr *Manager) updateMachineInfo(info *RpcMachineInfo) {
+	if mgr.machine != nil && !reflect.DeepEqual(mgr.machine, info) {
+		Logf(0, "machine info changed since the previous run: %+v -> %+v", *mgr.machine, *info)
+	}
+	mgr.machine = info
+	mgr.machineCached = false
+	for _, msg := range machineMismatches(mgr.cfg, info) {
+		Logf(0, "WARNING: %v", msg)
+	}
+	file := machineInfoFile(mgr.cfg)
+	data, err := json.MarshalIndent(info, "", "\t")
+	if err != nil {
+		panic(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		Logf(0, "failed to create machine info dir: %v", err)
+		return
+	}
+	if err := ioutil.WriteFile(file, data, 0660); err != nil {
+		Logf(0, "failed to write machine info: %v", err)
+	}
+}
+
+// machineMismatches returns descriptions of config params that need features the machine does not have.
+func machineMismatches(cfg *config.Config, info *RpcMachineInfo) []string {
+	var res []string
+	if cfg.Cover && !info.Kcov {
+		res = append(res, "cover is enabled, but kcov is not supported (enable CONFIG_KCOV and mount debugfs)")
+	}
+	if cfg.Leak && !info.LeakChecking {
+		res = append(res, "leak is enabled, but kmemleak is not supported (enable CONFIG_KMEMLEAK and mount debugfs)")
+	}
+	if cfg.Fault_Injection && !info.FaultInjection {
+		res = append(res, "fault_injection is enabled, but fault injection is not supported"+
+			" (enable CONFIG_FAULT_INJECTION, CONFIG_FAILSLAB, CONFIG_FAIL_PAGE_ALLOC and mount debugfs)")
+	}
+	if cfg.Usb_Emulation && !info.UsbEmulation {
+		res = append(res, "usb_emulation is enabled, but USB emulation is not supported"+
+			" (enable CONFIG_USB_RAW_GADGET and CONFIG_USB_DUMMY_HCD)")
+	}
+	var need []string
+	if cfg.Sandbox == "namespace" {
+		need = []string{"ipc", "mnt", "net", "pid", "user", "uts"}
+	} else if cfg.Netns {
+		need = []string{"net"}
+	}
+	var missing []string
+	for _, ns := range need {
+		if !contains(info.Namespaces, ns) {
+			missing = append(missing, ns)
+		}
+	}
+	if len(missing) != 0 {
+		res = append(res, fmt.Sprintf("sandbox/netns need namespaces that are not supported: %v", strings.Join(missing, ", ")))
+	}
+	return res
+}
+
+func contains(list []string, s string) bool {
+	for _, s1 := range list {
+		if s1 == s {
+			return true
+		}
+	}
+	return false
+}
@@ -15,14 +15,16 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/google/syzkaller/bloom"
 	"github.com/google/syzkaller/config"
 	"github.com/google/syzkaller/cover"
-	"github.com/google/syzkaller/csource"
 	"github.com/google/syzkaller/db"
 	"github.com/google/syzkaller/hash"
 	. "github.com/google/syzkaller/log"
@@ -45,16 +47,31 @@ var (
 	flagBench  = flag.String("bench", "", "write execution statistics into this file periodically")
 )
 
+// Logs of subsystems that can be viewed separately in the UI,
+// VM instances log to buffers named after the instance (see log.Named).
+var (
+	loopLog = Named("loop")
+	hubLog  = Named("hub")
+	seedLog = Named("seeds")
+)
+
 type Manager struct {
 	cfg          *config.Config
 	crashdir     string
 	port         int
 	corpusDB     *db.DB
+	coverDB      *db.DB // coverage of corpus inputs, see coverKey
 	startTime    time.Time
 	firstConnect time.Time
 	fuzzingTime  time.Duration
 	stats        map[string]uint64
+	slowCalls    map[string]uint64
+	callResults  map[string]*CallResults // aggregated sampled call results
+	callWeights  map[string]float32      // last adaptive syscall weights reported by a fuzzer
+	weightsFrom  string
 	crashTypes   map[string]bool
+	raceWindow   time.Time // start of the current data race rate limiting window
+	raceCount    int       // data races saved in the current window
 	vmStop       chan bool
 	vmChecked    bool
 	fresh        bool
@@ -62,22 +79,53 @@ type Manager struct {
 
 	mu              sync.Mutex
 	enabledSyscalls string
-	enabledCalls    []string // as determined by fuzzer
+	enabledCalls    []string        // as determined by fuzzer
+	disabledCalls   map[string]bool // found to be unavailable during fuzzing
+	machine         *RpcMachineInfo // as determined by fuzzer (or loaded from cache, see machine.go)
+	machineCached   bool            // machine is loaded from cache and not yet confirmed by a VM
 
 	candidates     []RpcCandidate // untriaged inputs
 	disabledHashes []string
 	corpus         []RpcInput
 	corpusCover    []cover.Cover
+	corpusSignal   []cover.Cover   // same as corpusCover if fuzzers use PC coverage as signal
+	modules        []*kernelModule // kernel modules loaded in VMs (reported by the VM check)
 	prios          [][]float32
+	learnedPrios   *prog.PrioTable // loaded from the previous run and imported from other managers
+	dict           *prog.Dictionary
+
+	maintainers *report.Maintainers // parsed MAINTAINERS file from cfg.Kernel_Src
 
 	fuzzers   map[string]*Fuzzer
 	hub       *rpc.Client
 	hubCorpus map[hash.Sig]bool
+	hubCaps   uint64
+
+	hubDisabled int // number of disabled calls when the enabled calls were last sent to the hub
+
+	hubCrashes []HubCrash          // crashes to share via hub with the next sync
+	hubTitles  map[string][]string // crash title -> other managers that found it (as reported by hub)
+	hubReproc  chan *Crash         // reproducers from other managers to check on this kernel
+
+	dash        *rpc.Client
+	dashCrashes []DashCrash // crashes to report to the dashboard with the next report
+	dashRepros  []DashRepro
 }
 
 type Fuzzer struct {
 	name   string
+	caps   uint64 // negotiated capabilities
 	inputs []RpcInput
+	tokens int // number of dictionary tokens sent to the fuzzer
+}
+
+// CallResults aggregates sampled results of a single syscall.
+type CallResults struct {
+	Samples  uint64
+	Blocked  uint64         // number of samples where the call did not finish (blocked or timed out)
+	Errnos   map[int]uint64 // errno -> number of samples, for finished calls
+	Cover    uint64         // total coverage size of finished calls
+	Duration time.Duration  // total duration of finished calls
 }
 
 type Crash struct {
@@ -85,6 +133,8 @@ type Crash struct {
 	desc   string
 	text   []byte
 	output []byte
+
+	hub string // manager that shared the reproducer via hub, empty for local crashes
 }
 
 func main() {
@@ -121,14 +171,29 @@ func RunManager(cfg *config.Config, syscalls map[int]bool) {
 		crashdir:        crashdir,
 		startTime:       time.Now(),
 		stats:           make(map[string]uint64),
+		slowCalls:       make(map[string]uint64),
+		callResults:     make(map[string]*CallResults),
+		callWeights:     make(map[string]float32),
+		disabledCalls:   make(map[string]bool),
 		crashTypes:      make(map[string]bool),
+		hubTitles:       make(map[string][]string),
+		hubReproc:       make(chan *Crash, 100),
 		enabledSyscalls: enabledSyscalls,
 		corpusCover:     make([]cover.Cover, sys.CallCount),
+		corpusSignal:    make([]cover.Cover, sys.CallCount),
 		fuzzers:         make(map[string]*Fuzzer),
 		fresh:           true,
 		vmStop:          make(chan bool),
 	}
 
+	if cfg.Kernel_Src != "" {
+		maintainers, err := report.ReadMaintainers(cfg.Kernel_Src)
+		if err != nil {
+			Logf(0, "failed to read MAINTAINERS: %v", err)
+		}
+		mgr.maintainers = maintainers
+	}
+
 	Logf(0, "loading corpus...")
 	dbFilename := filepath.Join(cfg.Workdir, "corpus.db")
 	if _, err := os.Stat(dbFilename); err != nil {
@@ -141,6 +206,7 @@ func RunManager(cfg *config.Config, syscalls map[int]bool) {
 	if err != nil {
 		Fatalf("failed to open corpus database: %v", err)
 	}
+	converted := 0
 	for key, rec := range mgr.corpusDB.Records {
 		p, err := prog.Deserialize(rec.Val)
 		if err != nil {
@@ -148,6 +214,16 @@ func RunManager(cfg *config.Config, syscalls map[int]bool) {
 			mgr.corpusDB.Delete(key)
 			continue
 		}
+		// Keys are hashes of the text form of programs regardless of the storage format.
+		if cfg.Binary_Corpus != prog.IsBinary(rec.Val) {
+			data := p.Serialize()
+			if cfg.Binary_Corpus {
+				data = p.SerializeBinary()
+			}
+			mgr.corpusDB.Save(key, data, rec.Seq)
+			rec = mgr.corpusDB.Records[key]
+			converted++
+		}
 		disabled := false
 		for _, c := range p.Calls {
 			if !syscalls[c.Meta.ID] {
@@ -161,8 +237,7 @@ func RunManager(cfg *config.Config, syscalls map[int]bool) {
 			// it is not deleted during minimization.
 			// TODO: use mgr.enabledCalls which accounts for missing devices, etc.
 			// But it is available only after vm check.
-			sig := hash.Hash(rec.Val)
-			mgr.disabledHashes = append(mgr.disabledHashes, sig.String())
+			mgr.disabledHashes = append(mgr.disabledHashes, key)
 			continue
 		}
 		mgr.candidates = append(mgr.candidates, RpcCandidate{
@@ -170,8 +245,26 @@ func RunManager(cfg *config.Config, syscalls map[int]bool) {
 			Minimized: true, // don't reminimize programs from corpus, it takes lots of time on start
 		})
 	}
+	if converted != 0 {
+		if err := mgr.corpusDB.Flush(); err != nil {
+			Fatalf("failed to save corpus database: %v", err)
+		}
+		Logf(0, "converted %v corpus programs (binary_corpus=%v)", converted, cfg.Binary_Corpus)
+	}
+	if cfg.Cover {
+		mgr.coverDB, err = db.Open(filepath.Join(cfg.Workdir, "cover.db"))
+		if err != nil {
+			Fatalf("failed to open coverage database: %v", err)
+		}
+	}
 	mgr.fresh = len(mgr.corpusDB.Records) == 0
 	Logf(0, "loaded %v programs (%v total)", len(mgr.candidates), len(mgr.corpusDB.Records))
+	mgr.loadPrios()
+	mgr.loadMachineInfo()
+	mgr.dict = prog.NewDictionary(nil)
+	if err := mgr.loadDict(); err != nil {
+		Fatalf("%v", err)
+	}
 
 	// Create HTTP server.
 	mgr.initHttp()
@@ -261,6 +354,14 @@ func RunManager(cfg *config.Config, syscalls map[int]bool) {
 		}()
 	}
 
+	if mgr.cfg.Dashboard_Addr != "" {
+		go mgr.dashLoop()
+	}
+
+	if mgr.cfg.Seeds != "" {
+		go mgr.seedLoop()
+	}
+
 	go func() {
 		c := make(chan os.Signal, 2)
 		signal.Notify(c, syscall.SIGINT)
@@ -313,12 +414,12 @@ func (mgr *Manager) vmLoop() {
 			if !mgr.needRepro(crash.desc) {
 				continue
 			}
-			Logf(1, "loop: add to repro queue '%v'", crash.desc)
+			loopLog.Logf(1, "add to repro queue '%v'", crash.desc)
 			reproducing[crash.desc] = true
 			reproQueue = append(reproQueue, crash)
 		}
 
-		Logf(1, "loop: shutdown=%v instances=%v/%v %+v repro: pending=%v reproducing=%v queued=%v",
+		loopLog.Logf(1, "shutdown=%v instances=%v/%v %+v repro: pending=%v reproducing=%v queued=%v",
 			shutdown == nil, len(instances), mgr.cfg.Count, instances,
 			len(pendingRepro), len(reproducing), len(reproQueue))
 		if shutdown == nil {
@@ -327,13 +428,18 @@ func (mgr *Manager) vmLoop() {
 			}
 		} else {
 			for len(reproQueue) != 0 && len(instances) >= reproInstances {
-				last := len(reproQueue) - 1
-				crash := reproQueue[last]
-				reproQueue[last] = nil
-				reproQueue = reproQueue[:last]
+				// Reproduce crashes with the highest score first, the most recent one among equal.
+				best := len(reproQueue) - 1
+				for i := best - 1; i >= 0; i-- {
+					if report.Score(reproQueue[i].desc) > report.Score(reproQueue[best].desc) {
+						best = i
+					}
+				}
+				crash := reproQueue[best]
+				reproQueue = append(reproQueue[:best], reproQueue[best+1:]...)
 				vmIndexes := append([]int{}, instances[len(instances)-reproInstances:]...)
 				instances = instances[:len(instances)-reproInstances]
-				Logf(1, "loop: starting repro of '%v' on instances %+v", crash.desc, vmIndexes)
+				loopLog.Logf(1, "starting repro of '%v' on instances %+v", crash.desc, vmIndexes)
 				go func() {
 					res, err := repro.Run(crash.output, mgr.cfg, vmIndexes)
 					reproDone <- &ReproResult{vmIndexes, crash, res, err}
@@ -343,7 +449,7 @@ func (mgr *Manager) vmLoop() {
 				last := len(instances) - 1
 				idx := instances[last]
 				instances = instances[:last]
-				Logf(1, "loop: starting instance %v", idx)
+				loopLog.Logf(1, "starting instance %v", idx)
 				go func() {
 					vmCfg, err := config.CreateVMConfig(mgr.cfg, idx)
 					if err != nil {
@@ -362,10 +468,10 @@ func (mgr *Manager) vmLoop() {
 
 		select {
 		case stopRequest <- true:
-			Logf(1, "loop: issued stop request")
+			loopLog.Logf(1, "issued stop request")
 			stopPending = true
 		case res := <-runDone:
-			Logf(1, "loop: instance %v finished, crash=%v", res.idx, res.crash != nil)
+			loopLog.Logf(1, "instance %v finished, crash=%v", res.idx, res.crash != nil)
 			if res.err != nil && shutdown != nil {
 				Logf(0, "%v", res.err)
 			}
@@ -373,10 +479,11 @@ func (mgr *Manager) vmLoop() {
 			instances = append(instances, res.idx)
 			// On shutdown qemu crashes with "qemu: terminating on signal 2",
 			// which we detect as "lost connection". Don't save that as crash.
-			if shutdown != nil && res.crash != nil && !mgr.isSuppressed(res.crash) {
+			if shutdown != nil && res.crash != nil && !mgr.isSuppressed(res.crash) &&
+				!mgr.isRateLimited(res.crash) {
 				mgr.saveCrash(res.crash)
 				if mgr.needRepro(res.crash.desc) {
-					Logf(1, "loop: add pending repro for '%v'", res.crash.desc)
+					loopLog.Logf(1, "add pending repro for '%v'", res.crash.desc)
 					pendingRepro[res.crash] = true
 				}
 			}
@@ -385,7 +492,7 @@ func (mgr *Manager) vmLoop() {
 			if res.res != nil {
 				crepro = res.res.CRepro
 			}
-			Logf(1, "loop: repro on instances %+v finished '%v', repro=%v crepro=%v",
+			loopLog.Logf(1, "repro on instances %+v finished '%v', repro=%v crepro=%v",
 				res.instances, res.crash.desc, res.res != nil, crepro)
 			if res.err != nil {
 				Logf(0, "repro failed: %v", res.err)
@@ -393,15 +500,20 @@ func (mgr *Manager) vmLoop() {
 			delete(reproducing, res.crash.desc)
 			instances = append(instances, res.instances...)
 			mgr.saveRepro(res.crash, res.res)
+		case crash := <-mgr.hubReproc:
+			if shutdown != nil && mgr.needRepro(crash.desc) {
+				loopLog.Logf(1, "add pending repro for '%v' from hub", crash.desc)
+				pendingRepro[crash] = true
+			}
 		case <-shutdown:
-			Logf(1, "loop: shutting down...")
+			loopLog.Logf(1, "shutting down...")
 			shutdown = nil
 		}
 	}
 }
 
 func (mgr *Manager) runInstance(vmCfg *vm.Config, first bool) (*Crash, error) {
-	inst, err := vm.Create(mgr.cfg.Type, vmCfg)
+	inst, err := vm.Create(vmCfg.Type, vmCfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create instance: %v", err)
 	}
@@ -433,24 +545,54 @@ func (mgr *Manager) runInstance(vmCfg *vm.Config, first bool) (*Crash, error) {
 	start := time.Now()
 	atomic.AddUint32(&mgr.numFuzzing, 1)
 	defer atomic.AddUint32(&mgr.numFuzzing, ^uint32(0))
-	cmd := fmt.Sprintf("%v -executor=%v -name=%v -manager=%v -output=%v -procs=%v -leak=%v -cover=%v -sandbox=%v -debug=%v -v=%d",
-		fuzzerBin, executorBin, vmCfg.Name, fwdAddr, mgr.cfg.Output, procs, leak, mgr.cfg.Cover, mgr.cfg.Sandbox, *flagDebug, fuzzerV)
+	cmd := fmt.Sprintf("%v -executor=%v -name=%v -manager=%v -output=%v -procs=%v -leak=%v -cover=%v -signal=%v -sandbox=%v -netns=%v -debug=%v -v=%d",
+		fuzzerBin, executorBin, vmCfg.Name, fwdAddr, mgr.cfg.Output, procs, leak, mgr.cfg.Cover, mgr.cfg.Signal, mgr.cfg.Sandbox, mgr.cfg.Netns, *flagDebug, fuzzerV)
+	if leak {
+		cmd += fmt.Sprintf(" -leak_period=%v", time.Duration(mgr.cfg.Leak_Period)*time.Second)
+	}
+	cmd += fmt.Sprintf(" -call_timeout=%v -slow_call=%v",
+		time.Duration(mgr.cfg.Call_Timeout)*time.Millisecond, time.Duration(mgr.cfg.Slow_Call)*time.Millisecond)
+	resultSample := mgr.cfg.Result_Sample
+	if resultSample < 0 {
+		resultSample = 0
+	}
+	cmd += fmt.Sprintf(" -result_sample=%v", resultSample)
+	if len(mgr.cfg.Mutation_Strategies) != 0 {
+		var strategies []string
+		for name, w := range mgr.cfg.Mutation_Strategies {
+			strategies = append(strategies, fmt.Sprintf("%v:%v", name, w))
+		}
+		sort.Strings(strategies)
+		cmd += fmt.Sprintf(" -mutations=%v", strings.Join(strategies, ","))
+	}
+	if len(mgr.cfg.Addr_Layout) != 0 {
+		cmd += fmt.Sprintf(" -addr_layout=%v", strings.Join(mgr.cfg.Addr_Layout, ","))
+	}
+	if mgr.cfg.Cleanup_Calls {
+		cmd += " -cleanup_calls=true"
+	}
+	if len(mgr.cfg.Seccomp_Deny) != 0 {
+		cmd += fmt.Sprintf(" -seccomp_deny=%v", strings.Join(mgr.cfg.Seccomp_Deny, ","))
+	}
+	if mgr.cfg.Fault_Injection {
+		cmd += fmt.Sprintf(" -faults=true -fault_nth=%v -fault_percent=%v", mgr.cfg.Fault_Nth, mgr.cfg.Fault_Percent)
+	}
 	outc, errc, err := inst.Run(time.Hour, mgr.vmStop, cmd)
 	if err != nil {
 		return nil, fmt.Errorf("failed to run fuzzer: %v", err)
 	}
 
-	desc, text, output, crashed, timedout := vm.MonitorExecution(outc, errc, mgr.cfg.Type == "local", true, mgr.cfg.ParsedIgnores)
+	desc, text, output, crashed, timedout := vm.MonitorExecution(outc, errc, vmCfg.Type == "local", true, mgr.cfg.Reporter)
 	if timedout {
 		// This is the only "OK" outcome.
-		Logf(0, "%v: running for %v, restarting (%v)", vmCfg.Name, time.Since(start), desc)
+		Named(vmCfg.Name).Logf(0, "running for %v, restarting (%v)", time.Since(start), desc)
 		return nil, nil
 	}
 	if !crashed {
 		// syz-fuzzer exited, but it should not.
 		desc = "lost connection to test machine"
 	}
-	return &Crash{vmCfg.Name, desc, text, output}, nil
+	return &Crash{vmName: vmCfg.Name, desc: desc, text: text, output: output}, nil
 }
 
 func (mgr *Manager) isSuppressed(crash *Crash) bool {
@@ -458,7 +600,7 @@ func (mgr *Manager) isSuppressed(crash *Crash) bool {
 		if !re.Match(crash.output) {
 			continue
 		}
-		Logf(1, "%v: suppressing '%v' with '%v'", crash.vmName, crash.desc, re.String())
+		Named(crash.vmName).Logf(1, "suppressing '%v' with '%v'", crash.desc, re.String())
 		mgr.mu.Lock()
 		mgr.stats["suppressed"]++
 		mgr.mu.Unlock()
@@ -467,13 +609,58 @@ func (mgr *Manager) isSuppressed(crash *Crash) bool {
 	return false
 }
 
+// isRateLimited returns true if crash is a data race that exceeds Max_Data_Races per hour.
+// Data races tend to be numerous on KCSAN kernels and would otherwise flood
+// crashes dir and repro queue.
+func (mgr *Manager) isRateLimited(crash *Crash) bool {
+	if mgr.cfg.Max_Data_Races == 0 || !report.IsDataRace(crash.desc) {
+		return false
+	}
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+	if time.Since(mgr.raceWindow) > time.Hour {
+		mgr.raceWindow = time.Now()
+		mgr.raceCount = 0
+	}
+	if mgr.raceCount >= mgr.cfg.Max_Data_Races {
+		Named(crash.vmName).Logf(1, "dropping data race '%v'", crash.desc)
+		mgr.stats["data races dropped"]++
+		return true
+	}
+	mgr.raceCount++
+	return false
+}
+
 func (mgr *Manager) saveCrash(crash *Crash) {
-	Logf(0, "%v: crash: %v", crash.vmName, crash.desc)
+	Named(crash.vmName).Logf(0, "crash: %v", crash.desc)
 	mgr.mu.Lock()
-	mgr.stats["crashes"]++
-	if !mgr.crashTypes[crash.desc] {
-		mgr.crashTypes[crash.desc] = true
-		mgr.stats["crash types"]++
+	if !mgr.crashTypes[crash.desc] && mgr.cfg.Hub_Addr != "" {
+		mgr.hubCrashes = append(mgr.hubCrashes, HubCrash{Title: crash.desc})
+	}
+	if report.IsDataRace(crash.desc) {
+		mgr.stats["data races"]++
+		if !mgr.crashTypes[crash.desc] {
+			mgr.crashTypes[crash.desc] = true
+			mgr.stats["data race types"]++
+		}
+	} else if report.IsHang(crash.desc) {
+		mgr.stats["hangs"]++
+		if !mgr.crashTypes[crash.desc] {
+			mgr.crashTypes[crash.desc] = true
+			mgr.stats["hang types"]++
+		}
+	} else if report.IsUBSAN(crash.desc) {
+		mgr.stats["ubsan reports"]++
+		if !mgr.crashTypes[crash.desc] {
+			mgr.crashTypes[crash.desc] = true
+			mgr.stats["ubsan report types"]++
+		}
+	} else {
+		mgr.stats["crashes"]++
+		if !mgr.crashTypes[crash.desc] {
+			mgr.crashTypes[crash.desc] = true
+			mgr.stats["crash types"]++
+		}
 	}
 	mgr.mu.Unlock()
 
@@ -484,12 +671,17 @@ func (mgr *Manager) saveCrash(crash *Crash) {
 	if err := ioutil.WriteFile(filepath.Join(dir, "description"), []byte(crash.desc+"\n"), 0660); err != nil {
 		Logf(0, "failed to write crash: %v", err)
 	}
-	// Save up to 100 reports. If we already have 100, overwrite the oldest one.
+	// Save up to maxCrashLogs reports. If we already have maxCrashLogs, overwrite the oldest one.
 	// Newer reports are generally more useful. Overwriting is also needed
 	// to be able to understand if a particular bug still happens or already fixed.
+	// Hang logs are large and rarely differ, so fewer of them are kept.
+	maxLogs := maxCrashLogs
+	if report.IsHang(crash.desc) && mgr.cfg.Hang_Logs < maxLogs {
+		maxLogs = mgr.cfg.Hang_Logs
+	}
 	oldestI := 0
 	var oldestTime time.Time
-	for i := 0; i < 100; i++ {
+	for i := 0; i < maxLogs; i++ {
 		info, err := os.Stat(filepath.Join(dir, fmt.Sprintf("log%v", i)))
 		if err != nil {
 			oldestI = i
@@ -512,13 +704,27 @@ func (mgr *Manager) saveCrash(crash *Crash) {
 			crash.text = symbolized
 		}
 		ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("report%v", oldestI)), []byte(crash.text), 0660)
+		if guilty := report.ExtractGuiltyFile(crash.text); guilty != "" {
+			ioutil.WriteFile(filepath.Join(dir, "guilty"), []byte(guilty+"\n"), 0660)
+			if mgr.maintainers != nil {
+				if emails := mgr.maintainers.Lookup(guilty); len(emails) != 0 {
+					ioutil.WriteFile(filepath.Join(dir, "maintainers"), []byte(strings.Join(emails, "\n")+"\n"), 0660)
+				}
+			}
+		}
+	}
+	if mgr.cfg.Dashboard_Addr != "" {
+		mgr.dashSaveCrash(crash)
 	}
 }
 
-const maxReproAttempts = 3
+const (
+	maxCrashLogs     = 100
+	maxReproAttempts = 3
+)
 
 func (mgr *Manager) needRepro(desc string) bool {
-	if !mgr.cfg.Reproduce {
+	if !mgr.cfg.Reproduce || !mgr.cfg.Reproduce_Hangs && report.IsHang(desc) {
 		return false
 	}
 	sig := hash.Hash([]byte(desc))
@@ -547,27 +753,79 @@ func (mgr *Manager) saveRepro(crash *Crash, res *repro.Result) {
 		}
 		return
 	}
-	opts := fmt.Sprintf("# %+v\n", res.Opts)
-	prog := res.Prog.Serialize()
-	ioutil.WriteFile(filepath.Join(dir, "repro.prog"), append([]byte(opts), prog...), 0660)
+	res.Title = crash.desc
+	res.Prog.Comments = prog.SetAnnotation(res.Prog.Comments, prog.AnnotationOrigin, "repro")
+	res.Prog.Comments = prog.SetAnnotation(res.Prog.Comments, prog.AnnotationTime, time.Now().Format(time.RFC3339))
+	if crash.hub != "" {
+		// The crash was found by another manager, so there is no crash dir yet.
+		os.MkdirAll(dir, 0700)
+		ioutil.WriteFile(filepath.Join(dir, "description"), []byte(crash.desc+"\n"), 0660)
+		ioutil.WriteFile(filepath.Join(dir, "hub"), []byte(crash.hub+"\n"), 0660)
+	}
+	if err := res.Save(dir, mgr.cfg.Tag); err != nil {
+		Logf(0, "failed to save repro: %v", err)
+	} else {
+		if crash.hub == "" && mgr.cfg.Hub_Addr != "" {
+			data, _ := ioutil.ReadFile(filepath.Join(dir, repro.ProgFile))
+			mgr.mu.Lock()
+			mgr.hubCrashes = append(mgr.hubCrashes, HubCrash{Title: crash.desc, Repro: data})
+			mgr.mu.Unlock()
+		}
+		if mgr.cfg.Dashboard_Addr != "" {
+			mgr.dashSaveRepro(crash, dir)
+		}
+	}
 	if len(mgr.cfg.Tag) > 0 {
 		ioutil.WriteFile(filepath.Join(dir, "repro.tag"), []byte(mgr.cfg.Tag), 0660)
 	}
 	if len(crash.text) > 0 {
 		ioutil.WriteFile(filepath.Join(dir, "repro.report"), []byte(crash.text), 0660)
 	}
-	if res.CRepro {
-		cprog, err := csource.Write(res.Prog, res.Opts)
-		if err == nil {
-			formatted, err := csource.Format(cprog)
-			if err == nil {
-				cprog = formatted
+}
+
+// prioDecay is the weight of the priority table loaded on start relative to the table
+// learned from the current corpus. Since the saved table includes the loaded one,
+// the decay makes old knowledge gradually fade away instead of accumulating forever.
+const prioDecay = 0.5
+
+// loadPrios loads priority table saved by the previous run and tables of other managers.
+func (mgr *Manager) loadPrios() {
+	mgr.learnedPrios = prog.NewPrioTable()
+	files := append([]string{filepath.Join(mgr.cfg.Workdir, "prios")}, mgr.cfg.Import_Prios...)
+	for i, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			if i != 0 || !os.IsNotExist(err) {
+				Logf(0, "failed to read priority table: %v", err)
 			}
-			ioutil.WriteFile(filepath.Join(dir, "repro.cprog"), cprog, 0660)
-		} else {
-			Logf(0, "failed to write C source: %v", err)
+			continue
+		}
+		table, err := prog.DeserializePrioTable(data)
+		if err != nil {
+			Logf(0, "failed to load priority table %v: %v", file, err)
+			continue
 		}
+		mgr.learnedPrios.Merge(table, 1)
 	}
+	Logf(0, "loaded priority table with %v calls", mgr.learnedPrios.Calls())
+}
+
+// loadDict (re)reads dictionaries and adds new tokens to mgr.dict.
+func (mgr *Manager) loadDict() error {
+	for _, file := range mgr.cfg.Dictionaries {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read dictionary: %v", err)
+		}
+		tokens, err := prog.ParseDictionary(data)
+		if err != nil {
+			return fmt.Errorf("failed to parse dictionary %v: %v", file, err)
+		}
+		if added := mgr.dict.Add(tokens...); added != 0 {
+			Logf(0, "loaded %v new tokens from dictionary %v", added, file)
+		}
+	}
+	return nil
 }
 
 func (mgr *Manager) minimizeCorpus() {
@@ -581,7 +839,7 @@ func (mgr *Manager) minimizeCorpus() {
 		for _, inp := range mgr.corpus {
 			c := calls[inp.Call]
 			c.inputs = append(c.inputs, inp)
-			c.cov = append(c.cov, inp.Cover)
+			c.cov = append(c.cov, inputSignal(&inp))
 			calls[inp.Call] = c
 		}
 		// Now minimize and build new corpus.
@@ -602,10 +860,15 @@ func (mgr *Manager) minimizeCorpus() {
 		}
 		corpus = append(corpus, p)
 	}
-	mgr.prios = prog.CalculatePriorities(corpus)
+	prios := prog.LearnPrioTable(corpus)
+	prios.Merge(mgr.learnedPrios, prioDecay)
+	mgr.prios = prog.CalculatePrioritiesFrom(prios)
 
 	// Don't minimize persistent corpus until fuzzers have triaged all inputs from it.
 	if len(mgr.candidates) == 0 {
+		if err := ioutil.WriteFile(filepath.Join(mgr.cfg.Workdir, "prios"), prios.Serialize(), 0640); err != nil {
+			Logf(0, "failed to save priority table: %v", err)
+		}
 		hashes := make(map[string]bool)
 		for _, inp := range mgr.corpus {
 			sig := hash.Hash(inp.Prog)
@@ -620,11 +883,25 @@ func (mgr *Manager) minimizeCorpus() {
 			}
 		}
 		mgr.corpusDB.Flush()
+		if mgr.coverDB != nil {
+			inputs := make(map[string]bool)
+			for _, inp := range mgr.corpus {
+				inputs[coverKey(hash.Hash(inp.Prog), inp.CallIndex)] = true
+			}
+			for key := range mgr.coverDB.Records {
+				if !inputs[key] {
+					mgr.coverDB.Delete(key)
+				}
+			}
+			if err := mgr.coverDB.Flush(); err != nil {
+				Logf(0, "failed to save coverage database: %v", err)
+			}
+		}
 	}
 }
 
 func (mgr *Manager) Connect(a *ConnectArgs, r *ConnectRes) error {
-	Logf(1, "fuzzer %v connected", a.Name)
+	Named(a.Name).Logf(1, "fuzzer connected")
 	mgr.mu.Lock()
 	defer mgr.mu.Unlock()
 
@@ -635,6 +912,7 @@ func (mgr *Manager) Connect(a *ConnectArgs, r *ConnectRes) error {
 	mgr.stats["vm restarts"]++
 	f := &Fuzzer{
 		name: a.Name,
+		caps: a.Caps & Caps,
 	}
 	mgr.fuzzers[a.Name] = f
 	mgr.minimizeCorpus()
@@ -642,8 +920,22 @@ func (mgr *Manager) Connect(a *ConnectArgs, r *ConnectRes) error {
 		f.inputs = append(f.inputs, inp)
 	}
 	r.Prios = mgr.prios
+	if err := mgr.loadDict(); err != nil {
+		Logf(0, "%v", err)
+	}
+	r.Dict = mgr.dict.Tokens()
+	f.tokens = len(r.Dict)
 	r.EnabledCalls = mgr.enabledSyscalls
+	if len(mgr.disabledCalls) != 0 {
+		// Don't make new fuzzers rediscover syscalls that are known to be unavailable.
+		var ids []string
+		for _, c := range mgr.enabledCalls {
+			ids = append(ids, fmt.Sprint(sys.CallMap[c].ID))
+		}
+		r.EnabledCalls = strings.Join(ids, ",")
+	}
 	r.NeedCheck = !mgr.vmChecked
+	r.Caps = f.caps
 
 	return nil
 }
@@ -655,20 +947,46 @@ func (mgr *Manager) Check(a *CheckArgs, r *int) error {
 	if mgr.vmChecked {
 		return nil
 	}
-	Logf(1, "fuzzer %v vm check: %v calls enabled", a.Name, len(a.Calls))
+	Named(a.Name).Logf(1, "vm check: %v calls enabled", len(a.Calls))
 	if len(a.Calls) == 0 {
 		Fatalf("no system calls enabled")
 	}
+	if a.Machine != nil {
+		// Update the cache before the checks below, so that the mismatch is shown after restart.
+		mgr.updateMachineInfo(a.Machine)
+	}
 	if mgr.cfg.Cover && !a.Kcov {
 		Fatalf("/sys/kernel/debug/kcov is missing. Enable CONFIG_KCOV and mount debugfs")
 	}
+	if mgr.cfg.Fault_Injection && !a.FaultInjection {
+		Fatalf("fault injection is not supported by the kernel. Enable CONFIG_FAULT_INJECTION, CONFIG_FAILSLAB, CONFIG_FAIL_PAGE_ALLOC and mount debugfs")
+	}
+	if mgr.cfg.Usb_Emulation && !a.UsbEmulation {
+		Fatalf("USB emulation is not supported by the kernel. Enable CONFIG_USB_RAW_GADGET and CONFIG_USB_DUMMY_HCD")
+	}
 	mgr.vmChecked = true
 	mgr.enabledCalls = a.Calls
+	mgr.modules = findModules(mgr.cfg.Module_Obj, a.Modules)
 	return nil
 }
 
+// coverKey returns key of coverage of the callIndex-th call of the program with signature sig in coverDB
+// (e.g. syz-corpus uses it to analyze coverage of the corpus).
+func coverKey(sig hash.Sig, callIndex int) string {
+	return fmt.Sprintf("%v-%v", sig.String(), callIndex)
+}
+
+// inputSignal returns the feedback signal of the input:
+// edge signal if the fuzzer collected it, otherwise PC coverage.
+func inputSignal(inp *RpcInput) cover.Cover {
+	if len(inp.Signal) != 0 {
+		return inp.Signal
+	}
+	return inp.Cover
+}
+
 func (mgr *Manager) NewInput(a *NewInputArgs, r *int) error {
-	Logf(2, "new input from %v for syscall %v", a.Name, a.Call)
+	Named(a.Name).Logf(2, "new input for syscall %v", a.Call)
 	mgr.mu.Lock()
 	defer mgr.mu.Unlock()
 
@@ -678,17 +996,32 @@ func (mgr *Manager) NewInput(a *NewInputArgs, r *int) error {
 	}
 
 	call := sys.CallID[a.Call]
-	if len(cover.Difference(a.Cover, mgr.corpusCover[call])) == 0 {
+	signal := inputSignal(&a.RpcInput)
+	if len(cover.Difference(signal, mgr.corpusSignal[call])) == 0 {
 		return nil
 	}
+	mgr.corpusSignal[call] = cover.Union(mgr.corpusSignal[call], signal)
 	mgr.corpusCover[call] = cover.Union(mgr.corpusCover[call], a.Cover)
 	mgr.corpus = append(mgr.corpus, a.RpcInput)
 	mgr.stats["manager new inputs"]++
 	sig := hash.Hash(a.RpcInput.Prog)
-	mgr.corpusDB.Save(sig.String(), a.RpcInput.Prog, 0)
+	data := a.RpcInput.Prog
+	if mgr.cfg.Binary_Corpus {
+		if bin, err := prog.ConvertToBinary(data); err == nil {
+			data = bin
+		}
+	}
+	mgr.corpusDB.Save(sig.String(), data, 0)
 	if err := mgr.corpusDB.Flush(); err != nil {
 		Logf(0, "failed to save corpus database: %v", err)
 	}
+	if mgr.coverDB != nil {
+		cov := cover.Canonicalize(cover.Copy(a.Cover))
+		mgr.coverDB.Save(coverKey(sig, a.CallIndex), cover.Serialize(cov), 0)
+		if err := mgr.coverDB.Flush(); err != nil {
+			Logf(0, "failed to save coverage database: %v", err)
+		}
+	}
 	for _, f1 := range mgr.fuzzers {
 		if f1 == f {
 			continue
@@ -699,19 +1032,60 @@ func (mgr *Manager) NewInput(a *NewInputArgs, r *int) error {
 }
 
 func (mgr *Manager) Poll(a *PollArgs, r *PollRes) error {
-	Logf(2, "poll from %v", a.Name)
+	Named(a.Name).Logf(2, "poll")
 	mgr.mu.Lock()
 	defer mgr.mu.Unlock()
 
 	for k, v := range a.Stats {
 		mgr.stats[k] += v
 	}
+	for k, v := range a.SlowCalls {
+		mgr.slowCalls[k] += v
+	}
+	for _, res := range a.CallResults {
+		cr := mgr.callResults[res.Call]
+		if cr == nil {
+			cr = &CallResults{Errnos: make(map[int]uint64)}
+			mgr.callResults[res.Call] = cr
+		}
+		cr.Samples++
+		if res.Errno == -1 {
+			// The call did not finish, so there is no errno and the duration is unknown.
+			cr.Blocked++
+			continue
+		}
+		cr.Errnos[res.Errno]++
+		cr.Cover += uint64(res.Cover)
+		cr.Duration += res.Duration
+	}
+	if a.CallWeights != nil {
+		mgr.callWeights = a.CallWeights
+		mgr.weightsFrom = a.Name
+	}
+	for _, c := range a.DisabledCalls {
+		if mgr.disabledCalls[c] {
+			continue
+		}
+		Named(a.Name).Logf(0, "syscall %v is unavailable, disabling", c)
+		mgr.disabledCalls[c] = true
+		for i, c1 := range mgr.enabledCalls {
+			if c1 == c {
+				mgr.enabledCalls = append(mgr.enabledCalls[:i:i], mgr.enabledCalls[i+1:]...)
+				break
+			}
+		}
+	}
 
 	f := mgr.fuzzers[a.Name]
 	if f == nil {
 		Fatalf("fuzzer %v is not connected", a.Name)
 	}
 
+	if tokens := mgr.dict.Tokens(); len(tokens) > f.tokens {
+		r.NewTokens = tokens[f.tokens:]
+		f.tokens = len(tokens)
+	}
+
 	for i := 0; i < 100 && len(f.inputs) > 0; i++ {
 		last := len(f.inputs) - 1
 		r.NewInputs = append(r.NewInputs, f.inputs[last])
@@ -730,6 +1104,14 @@ func (mgr *Manager) Poll(a *PollArgs, r *PollRes) error {
 		mgr.candidates = nil
 	}
 
+	if f.caps&CapCompression != 0 && (len(r.Candidates) != 0 || len(r.NewInputs) != 0) {
+		packed, err := Pack(r)
+		if err != nil {
+			return fmt.Errorf("failed to pack poll reply: %v", err)
+		}
+		*r = PollRes{Packed: packed}
+	}
+
 	return nil
 }
 
@@ -744,35 +1126,40 @@ func (mgr *Manager) hubSync() {
 	if mgr.hub == nil {
 		conn, err := rpc.Dial("tcp", mgr.cfg.Hub_Addr)
 		if err != nil {
-			Logf(0, "failed to connect to hub at %v: %v", mgr.cfg.Hub_Addr, err)
+			hubLog.Logf(0, "failed to connect to hub at %v: %v", mgr.cfg.Hub_Addr, err)
 			return
 		}
 		mgr.hub = conn
-		a := &HubConnectArgs{
-			Name:  mgr.cfg.Name,
-			Key:   mgr.cfg.Hub_Key,
-			Fresh: mgr.fresh,
-			Calls: mgr.enabledCalls,
+		ca := &HubCapsArgs{
+			Name: mgr.cfg.Name,
+			Key:  mgr.cfg.Hub_Key,
+			Caps: Caps,
 		}
-		mgr.hubCorpus = make(map[hash.Sig]bool)
-		for _, inp := range mgr.corpus {
-			mgr.hubCorpus[hash.Hash(inp.Prog)] = true
-			a.Corpus = append(a.Corpus, inp.Prog)
+		if err := mgr.hub.Call("Hub.Caps", ca, &mgr.hubCaps); err != nil {
+			// Old hubs don't know about capabilities, talk to them without extensions.
+			hubLog.Logf(1, "Hub.Caps rpc failed: %v", err)
+			mgr.hubCaps = 0
 		}
-		if err := mgr.hub.Call("Hub.Connect", a, nil); err != nil {
-			Logf(0, "Hub.Connect rpc failed: %v", err)
+		resumed := mgr.hubCorpus != nil && mgr.hubCaps&CapResume != 0 && mgr.hubResume()
+		if !resumed && !mgr.hubConnect() {
 			mgr.hub.Close()
 			mgr.hub = nil
-			mgr.hubCorpus = nil
 			return
 		}
-		mgr.fresh = false
-		Logf(0, "connected to hub at %v, corpus %v", mgr.cfg.Hub_Addr, len(mgr.corpus))
 	}
 
 	a := &HubSyncArgs{
 		Name: mgr.cfg.Name,
 		Key:  mgr.cfg.Hub_Key,
+		Caps: mgr.hubCaps,
+	}
+	if mgr.hubCaps&CapCrashes != 0 {
+		a.Crashes = mgr.hubCrashes
+	}
+	if len(mgr.disabledCalls) != mgr.hubDisabled {
+		// Some calls turned out to be unavailable, don't receive programs with them.
+		a.Calls = mgr.enabledCalls
+		mgr.hubDisabled = len(mgr.disabledCalls)
 	}
 	corpus := make(map[hash.Sig]bool)
 	for _, inp := range mgr.corpus {
@@ -791,17 +1178,38 @@ func (mgr *Manager) hubSync() {
 		delete(mgr.hubCorpus, sig)
 		a.Del = append(a.Del, sig.String())
 	}
+	nadd := len(a.Add)
+	if mgr.hubCaps&CapCompression != 0 && nadd != 0 {
+		packed, err := Pack(a.Add)
+		if err != nil {
+			Fatalf("failed to pack corpus: %v", err)
+		}
+		a.Add, a.PackedAdd = nil, packed
+	}
 	r := new(HubSyncRes)
 	if err := mgr.hub.Call("Hub.Sync", a, r); err != nil {
-		Logf(0, "Hub.Sync rpc failed: %v", err)
+		hubLog.Logf(0, "Hub.Sync rpc failed: %v", err)
 		mgr.hub.Close()
 		mgr.hub = nil
 		return
 	}
+	if len(r.PackedInputs) != 0 {
+		if err := Unpack(r.PackedInputs, &r.Inputs); err != nil {
+			hubLog.Logf(0, "failed to unpack hub inputs: %v", err)
+			mgr.hub.Close()
+			mgr.hub = nil
+			return
+		}
+	}
+	enabled := make(map[string]bool)
+	for _, c := range mgr.enabledCalls {
+		enabled[c] = true
+	}
 	dropped := 0
 	for _, inp := range r.Inputs {
 		_, err := prog.Deserialize(inp)
-		if err != nil {
+		if err != nil || !allCallsEnabled(inp, enabled) {
+			// Old hubs send programs regardless of enabled calls.
 			dropped++
 			continue
 		}
@@ -810,9 +1218,148 @@ func (mgr *Manager) hubSync() {
 			Minimized: false, // don't trust programs from hub
 		})
 	}
-	mgr.stats["hub add"] += uint64(len(a.Add))
+	// Old hubs don't support crash exchange, so don't accumulate the crashes for them either.
+	mgr.hubCrashes = nil
+	mgr.hubSyncCrashes(r.Crashes)
+	mgr.stats["hub add"] += uint64(nadd)
 	mgr.stats["hub del"] += uint64(len(a.Del))
 	mgr.stats["hub drop"] += uint64(dropped)
 	mgr.stats["hub new"] += uint64(len(r.Inputs) - dropped)
-	Logf(0, "hub sync: add %v, del %v, drop %v, new %v", len(a.Add), len(a.Del), dropped, len(r.Inputs)-dropped)
+	hubLog.Logf(0, "sync: add %v, del %v, drop %v, new %v", nadd, len(a.Del), dropped, len(r.Inputs)-dropped)
+}
+
+// hubConnect uploads the whole corpus (or its Bloom filter) to the hub.
+func (mgr *Manager) hubConnect() bool {
+	a := &HubConnectArgs{
+		Name:  mgr.cfg.Name,
+		Key:   mgr.cfg.Hub_Key,
+		Fresh: mgr.fresh,
+		Calls: mgr.enabledCalls,
+	}
+	useBloom := mgr.hubCaps&CapBloom != 0
+	var filter *bloom.Filter
+	if useBloom {
+		// Send only signatures of the corpus, programs that the hub misses
+		// are sent with the sync below.
+		filter = bloom.New(len(mgr.corpus), HubBloomFPRate)
+	}
+	mgr.hubCorpus = make(map[hash.Sig]bool)
+	for _, inp := range mgr.corpus {
+		sig := hash.Hash(inp.Prog)
+		if useBloom {
+			filter.Add(sig)
+			continue
+		}
+		mgr.hubCorpus[sig] = true
+		a.Corpus = append(a.Corpus, inp.Prog)
+	}
+	if useBloom {
+		a.CorpusBloom = filter.Serialize()
+	} else if mgr.hubCaps&CapCompression != 0 {
+		packed, err := Pack(a.Corpus)
+		if err != nil {
+			Fatalf("failed to pack corpus: %v", err)
+		}
+		a.Corpus, a.PackedCorpus = nil, packed
+	}
+	r := new(HubConnectRes)
+	if err := mgr.hub.Call("Hub.Connect", a, r); err != nil {
+		hubLog.Logf(0, "Hub.Connect rpc failed: %v", err)
+		mgr.hubCorpus = nil
+		return false
+	}
+	if useBloom && len(r.CorpusBloom) != 0 {
+		hubFilter, err := bloom.Deserialize(r.CorpusBloom)
+		if err != nil {
+			hubLog.Logf(0, "bad hub corpus filter: %v", err)
+		} else {
+			for _, inp := range mgr.corpus {
+				if sig := hash.Hash(inp.Prog); hubFilter.Test(sig) {
+					mgr.hubCorpus[sig] = true
+				}
+			}
+		}
+	}
+	mgr.fresh = false
+	mgr.hubDisabled = len(mgr.disabledCalls)
+	hubLog.Logf(0, "connected to hub at %v, corpus %v", mgr.cfg.Hub_Addr, len(mgr.corpus))
+	return true
+}
+
+// hubResume continues syncing after the connection to the hub was lost
+// (e.g. the hub restarted) without uploading the corpus again.
+// The hub tells which of the previously sent programs it has,
+// the rest are sent again with the following sync.
+func (mgr *Manager) hubResume() bool {
+	a := &HubConnectArgs{
+		Name:   mgr.cfg.Name,
+		Key:    mgr.cfg.Hub_Key,
+		Calls:  mgr.enabledCalls,
+		Resume: true,
+	}
+	r := new(HubConnectRes)
+	if err := mgr.hub.Call("Hub.Connect", a, r); err != nil {
+		hubLog.Logf(0, "Hub.Connect rpc failed: %v", err)
+		return false
+	}
+	if !r.Resumed {
+		return false
+	}
+	if len(r.CorpusBloom) != 0 {
+		hubFilter, err := bloom.Deserialize(r.CorpusBloom)
+		if err != nil {
+			hubLog.Logf(0, "bad hub corpus filter: %v", err)
+			return false
+		}
+		for sig := range mgr.hubCorpus {
+			if !hubFilter.Test(sig) {
+				delete(mgr.hubCorpus, sig)
+			}
+		}
+	}
+	mgr.hubDisabled = len(mgr.disabledCalls)
+	hubLog.Logf(0, "resumed syncing with hub at %v, corpus %v", mgr.cfg.Hub_Addr, len(mgr.corpus))
+	return true
+}
+
+// hubSyncCrashes records crashes found by other managers and queues their reproducers
+// to check whether the bugs also affect this kernel.
+func (mgr *Manager) hubSyncCrashes(crashes []HubCrash) {
+	for _, crash := range crashes {
+		known := false
+		for _, name := range mgr.hubTitles[crash.Title] {
+			known = known || name == crash.Manager
+		}
+		if !known {
+			mgr.hubTitles[crash.Title] = append(mgr.hubTitles[crash.Title], crash.Manager)
+		}
+		if crash.Repro == nil {
+			continue
+		}
+		hubLog.Logf(0, "reproducer for '%v' from %v", crash.Title, crash.Manager)
+		select {
+		case mgr.hubReproc <- &Crash{
+			vmName: "hub",
+			desc:   crash.Title,
+			output: []byte(fmt.Sprintf("executing program 0:\n%s\n", crash.Repro)),
+			hub:    crash.Manager,
+		}:
+		default:
+			hubLog.Logf(0, "too many reproducers to check, dropping '%v'", crash.Title)
+		}
+	}
+}
+
+// allCallsEnabled checks that all syscalls used by the program are enabled.
+func allCallsEnabled(data []byte, enabled map[string]bool) bool {
+	calls, err := prog.CallSet(data)
+	if err != nil {
+		return false
+	}
+	for c := range calls {
+		if !enabled[c] {
+			return false
+		}
+	}
+	return true
 }
@@ -0,0 +1,128 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/google/syzkaller/db"
+	"github.com/google/syzkaller/prog"
+	. "github.com/google/syzkaller/rpctype"
+	"github.com/google/syzkaller/sysparser"
+)
+
+// seedLoop periodically scans cfg.Seeds and adds programs from new and modified files to candidates,
+// so that seeds can be injected without restarting the manager.
+func (mgr *Manager) seedLoop() {
+	var consts map[string]uint64         // for strace logs, loaded when the first log is found
+	loaded := make(map[string]time.Time) // file name -> modification time of the loaded version
+	for ; ; time.Sleep(10 * time.Second) {
+		mgr.mu.Lock()
+		checked := mgr.vmChecked
+		mgr.mu.Unlock()
+		if !checked {
+			// Enabled calls are not known yet.
+			continue
+		}
+		files, err := ioutil.ReadDir(mgr.cfg.Seeds)
+		if err != nil {
+			seedLog.Logf(0, "failed to read seeds dir: %v", err)
+			continue
+		}
+		for _, file := range files {
+			name := file.Name()
+			if !file.Mode().IsRegular() || strings.HasPrefix(name, ".") || loaded[name].Equal(file.ModTime()) {
+				continue
+			}
+			if time.Since(file.ModTime()) < 5*time.Second {
+				// The file may still be being written, pick it up on the next scan.
+				continue
+			}
+			loaded[name] = file.ModTime()
+			data, err := ioutil.ReadFile(filepath.Join(mgr.cfg.Seeds, name))
+			if err != nil {
+				seedLog.Logf(0, "failed to read seed file: %v", err)
+				continue
+			}
+			if consts == nil && !db.IsDB(data) && !isProg(data) {
+				if consts, err = sysparser.ReadConsts(filepath.Join(mgr.cfg.Syzkaller, "sys"), runtime.GOARCH); err != nil {
+					seedLog.Logf(0, "can't convert strace logs: %v", err)
+					consts = make(map[string]uint64)
+				}
+			}
+			format, progs, err := parseSeed(filepath.Join(mgr.cfg.Seeds, name), data, consts)
+			if err != nil {
+				seedLog.Logf(0, "failed to load seed file %v: %v", name, err)
+				continue
+			}
+			mgr.addSeeds(name, format, progs)
+		}
+	}
+}
+
+// parseSeed detects format of the seed file and returns the programs it contains.
+// Supported formats are corpus.db files, programs (text or binary) and strace logs.
+func parseSeed(file string, data []byte, consts map[string]uint64) (string, [][]byte, error) {
+	if db.IsDB(data) {
+		// The database may be written by a running manager, so don't db.Open it.
+		records, err := db.ReadRecords(file)
+		if err != nil {
+			return "", nil, err
+		}
+		var progs [][]byte
+		for _, rec := range records {
+			progs = append(progs, rec.Val)
+		}
+		return "corpus", progs, nil
+	}
+	if isProg(data) {
+		return "program", [][]byte{data}, nil
+	}
+	progs, err := prog.ParseStrace(data, consts)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(progs) == 0 {
+		return "", nil, fmt.Errorf("unknown format (not a program, strace log or corpus.db)")
+	}
+	var res [][]byte
+	for _, p := range progs {
+		res = append(res, p.Serialize())
+	}
+	return "strace", res, nil
+}
+
+func isProg(data []byte) bool {
+	_, err := prog.Deserialize(data)
+	return err == nil
+}
+
+// addSeeds adds programs that use only enabled calls to candidates.
+func (mgr *Manager) addSeeds(file, format string, progs [][]byte) {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+	enabled := make(map[string]bool)
+	for _, c := range mgr.enabledCalls {
+		enabled[c] = true
+	}
+	dropped := 0
+	for _, data := range progs {
+		if !isProg(data) || !allCallsEnabled(data, enabled) {
+			dropped++
+			continue
+		}
+		mgr.candidates = append(mgr.candidates, RpcCandidate{
+			Prog:      data,
+			Minimized: false, // seeds are usually not minimized
+		})
+	}
+	mgr.stats["seed new"] += uint64(len(progs) - dropped)
+	mgr.stats["seed drop"] += uint64(dropped)
+	seedLog.Logf(0, "loaded seed file %v (%v): new %v, drop %v", file, format, len(progs)-dropped, dropped)
+}
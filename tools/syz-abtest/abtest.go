@@ -0,0 +1,211 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+// syz-abtest runs two syz-manager configurations side-by-side for A/B testing
+// of fuzzing parameters (e.g. different mutation settings or syscall sets).
+// Both managers start with the same seed corpus and run on their own VM pools
+// (as specified by count/pools in the corresponding configs).
+// Every manager writes benchmark data into a separate file in the output dir,
+// these files can be later visualized with syz-benchcmp.
+// The tool also periodically prints comparable coverage/throughput metrics.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/google/syzkaller/config"
+	"github.com/google/syzkaller/fileutil"
+	. "github.com/google/syzkaller/log"
+)
+
+var (
+	flagConfigA  = flag.String("config_a", "", "config file for the first (baseline) manager")
+	flagConfigB  = flag.String("config_b", "", "config file for the second (experimental) manager")
+	flagCorpus   = flag.String("corpus", "", "seed corpus.db copied into workdirs of both managers (optional)")
+	flagOutput   = flag.String("output", "abtest", "output dir for benchmark files")
+	flagManager  = flag.String("manager", "bin/syz-manager", "syz-manager binary")
+	flagDuration = flag.Duration("duration", 0, "duration of the test (0 means until interrupted)")
+	flagPeriod   = flag.Duration("period", 10*time.Minute, "period of printing of comparison stats")
+)
+
+type arm struct {
+	name   string
+	config string
+	bench  string
+	cmd    *exec.Cmd
+}
+
+func main() {
+	flag.Parse()
+	if *flagConfigA == "" || *flagConfigB == "" {
+		fmt.Fprintf(os.Stderr, "usage: syz-abtest -config_a=a.cfg -config_b=b.cfg [flags]\n")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	cfgA, _, err := config.Parse(*flagConfigA)
+	if err != nil {
+		Fatalf("failed to parse config_a: %v", err)
+	}
+	cfgB, _, err := config.Parse(*flagConfigB)
+	if err != nil {
+		Fatalf("failed to parse config_b: %v", err)
+	}
+	if cfgA.Workdir == cfgB.Workdir {
+		Fatalf("configs must use different workdirs")
+	}
+	if cfgA.Http == cfgB.Http {
+		Fatalf("configs must use different http addresses")
+	}
+	if err := os.MkdirAll(*flagOutput, 0700); err != nil {
+		Fatalf("failed to create output dir: %v", err)
+	}
+	arms := []*arm{
+		{name: "a", config: *flagConfigA},
+		{name: "b", config: *flagConfigB},
+	}
+	for i, cfg := range []*config.Config{cfgA, cfgB} {
+		a := arms[i]
+		a.bench = filepath.Join(*flagOutput, a.name)
+		if _, err := os.Stat(a.bench); err == nil {
+			Fatalf("bench file %v already exists", a.bench)
+		}
+		if err := os.MkdirAll(cfg.Workdir, 0700); err != nil {
+			Fatalf("failed to create workdir: %v", err)
+		}
+		corpusDB := filepath.Join(cfg.Workdir, "corpus.db")
+		os.Remove(corpusDB)
+		if *flagCorpus != "" {
+			if err := fileutil.CopyFile(*flagCorpus, corpusDB, false); err != nil {
+				Fatalf("failed to copy seed corpus: %v", err)
+			}
+		}
+	}
+
+	done := make(chan error, len(arms))
+	running := 0
+	// stop interrupts all running managers and waits for them to exit,
+	// so that we don't leave a manager and its VMs behind.
+	stop := func() {
+		for _, a := range arms[:running] {
+			a.cmd.Process.Signal(syscall.SIGINT)
+		}
+		for ; running > 0; running-- {
+			<-done
+		}
+	}
+	for _, a := range arms {
+		a := a
+		a.cmd = exec.Command(*flagManager, "-config", a.config, "-bench", a.bench)
+		a.cmd.Stdout = os.Stdout
+		a.cmd.Stderr = os.Stderr
+		if err := a.cmd.Start(); err != nil {
+			stop()
+			Fatalf("failed to start manager %v: %v", a.name, err)
+		}
+		running++
+		go func() {
+			done <- a.cmd.Wait()
+		}()
+		Logf(0, "started manager %v (%v), bench file %v", a.name, a.config, a.bench)
+	}
+
+	var deadline <-chan time.Time
+	if *flagDuration != 0 {
+		deadline = time.After(*flagDuration)
+	}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT)
+	ticker := time.NewTicker(*flagPeriod).C
+loop:
+	for {
+		select {
+		case <-ticker:
+			printStats(arms)
+		case <-deadline:
+			Logf(0, "test duration expired")
+			break loop
+		case <-sig:
+			Logf(0, "shutting down...")
+			break loop
+		case err := <-done:
+			running--
+			Logf(0, "manager exited prematurely: %v, shutting down...", err)
+			stop()
+			Fatalf("manager exited prematurely: %v", err)
+		}
+	}
+	stop()
+	printStats(arms)
+}
+
+func printStats(arms []*arm) {
+	stats := make([]map[string]uint64, len(arms))
+	for i, a := range arms {
+		stats[i] = lastRecord(a.bench)
+		if stats[i] == nil {
+			Logf(0, "no bench data for manager %v yet", a.name)
+			return
+		}
+	}
+	Logf(0, "comparison:\n%s", formatComparison(compareStats(stats[0], stats[1])))
+}
+
+// statDiff is a comparison of a single metric of the two managers.
+type statDiff struct {
+	name string
+	a, b uint64
+}
+
+// compareStats returns comparable metrics of the two bench records.
+func compareStats(a, b map[string]uint64) []statDiff {
+	var res []statDiff
+	for _, name := range []string{"fuzzing", "coverage", "corpus", "exec total", "crash types"} {
+		res = append(res, statDiff{name, a[name], b[name]})
+	}
+	// Fuzzing time can differ slightly, so normalize throughput per VM-second.
+	rate := func(st map[string]uint64) uint64 {
+		if st["fuzzing"] == 0 {
+			return 0
+		}
+		return st["exec total"] / st["fuzzing"]
+	}
+	return append(res, statDiff{"execs/sec", rate(a), rate(b)})
+}
+
+func formatComparison(diffs []statDiff) []byte {
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "%-16v%16v%16v%16v\n", "", "a", "b", "diff")
+	for _, d := range diffs {
+		fmt.Fprintf(buf, "%-16v%16v%16v%+16d\n", d.name, d.a, d.b, int64(d.b)-int64(d.a))
+	}
+	return buf.Bytes()
+}
+
+// lastRecord returns the last complete record from syz-manager bench file.
+func lastRecord(fname string) map[string]uint64 {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var last map[string]uint64
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for dec.More() {
+		v := make(map[string]uint64)
+		if err := dec.Decode(&v); err != nil {
+			break
+		}
+		last = v
+	}
+	return last
+}
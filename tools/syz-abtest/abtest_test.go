@@ -0,0 +1,59 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompareStats(t *testing.T) {
+	a := map[string]uint64{"fuzzing": 100, "coverage": 1000, "corpus": 50, "exec total": 5000}
+	b := map[string]uint64{"fuzzing": 50, "coverage": 900, "corpus": 60, "exec total": 5000, "crash types": 2}
+	want := []statDiff{
+		{"fuzzing", 100, 50},
+		{"coverage", 1000, 900},
+		{"corpus", 50, 60},
+		{"exec total", 5000, 5000},
+		{"crash types", 0, 2},
+		{"execs/sec", 50, 100},
+	}
+	got := compareStats(a, b)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v\nwant %+v", got, want)
+	}
+	// No division by zero before fuzzing starts.
+	got = compareStats(map[string]uint64{}, map[string]uint64{"exec total": 10})
+	if rate := got[len(got)-1]; rate.a != 0 || rate.b != 0 {
+		t.Fatalf("bad rate without fuzzing time: %+v", rate)
+	}
+	out := string(formatComparison(compareStats(a, b)))
+	for _, line := range []string{"coverage", "-100", "execs/sec", "+50"} {
+		if !strings.Contains(out, line) {
+			t.Fatalf("comparison does not contain %q:\n%v", line, out)
+		}
+	}
+}
+
+func TestLastRecord(t *testing.T) {
+	f, err := ioutil.TempFile("", "syz-abtest-test")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	// The last record can be partially written by the running manager.
+	f.WriteString(`{"coverage": 1}` + "\n" + `{"coverage": 2, "corpus": 3}` + "\n" + `{"coverage": 4, "cor`)
+	f.Close()
+	got := lastRecord(f.Name())
+	want := map[string]uint64{"coverage": 2, "corpus": 3}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if lastRecord(f.Name()+".nonexistent") != nil {
+		t.Fatalf("got record from nonexistent file")
+	}
+}
@@ -0,0 +1,382 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+// syz-check is a lint pass over syscall descriptions that reports common mistakes
+// that sysgen accepts: resources that are never used or never created, structs and unions
+// that no syscall refers to, len fields that refer to non-existent or scalar fields,
+// unbounded arrays whose size is not given by any len field and ioctl commands
+// that are encoded differently for different arches in const files.
+// It is run from the syzkaller checkout, by default over all sys/*.txt files.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/syzkaller/sysparser"
+)
+
+var (
+	flagV = flag.Int("v", 0, "verbosity")
+)
+
+// archs must match archs in sysgen.
+var archs = []string{"amd64", "arm64", "ppc64le"}
+
+type checker struct {
+	desc     *sysparser.Description
+	warnings map[string]bool
+	visited  map[string]bool // struct+dir+parents combinations that are already checked
+	used     map[string]bool // structs and unions reachable from syscalls
+	resIn    map[string]bool // resources passed to syscalls
+	resOut   map[string]bool // resources returned from syscalls
+	test     bool            // checking syz_test calls that exercise corner cases on purpose
+}
+
+func main() {
+	flag.Parse()
+	files := flag.Args()
+	if len(files) == 0 {
+		var err error
+		if files, err = filepath.Glob("sys/*.txt"); err != nil || len(files) == 0 {
+			failf("failed to find description files: %v", err)
+		}
+	}
+	c := &checker{
+		desc:     sysparser.ParseFiles(files),
+		warnings: make(map[string]bool),
+		visited:  make(map[string]bool),
+		used:     make(map[string]bool),
+		resIn:    make(map[string]bool),
+		resOut:   make(map[string]bool),
+	}
+	// Test calls go last, so that structs shared with them are checked for real syscalls.
+	for _, test := range []bool{false, true} {
+		c.test = test
+		for _, call := range c.desc.Syscalls {
+			if (call.CallName == "syz_test") != test {
+				continue
+			}
+			c.checkFields("syscall "+call.Name, call.Args, nil)
+			for _, arg := range call.Args {
+				c.walk(arg[1:], "in", nil)
+			}
+			if len(call.Ret) != 0 {
+				c.walk(call.Ret, "out", nil)
+			}
+		}
+	}
+	c.test = false
+	c.checkResources()
+	c.checkStructs()
+	c.checkConsts()
+
+	var warnings []string
+	for w := range c.warnings {
+		warnings = append(warnings, w)
+	}
+	sort.Strings(warnings)
+	for _, w := range warnings {
+		fmt.Printf("%v\n", w)
+	}
+	if len(warnings) != 0 {
+		failf("found %v problems", len(warnings))
+	}
+}
+
+func (c *checker) warnf(msg string, args ...interface{}) {
+	if c.test {
+		return
+	}
+	c.warnings[fmt.Sprintf(msg, args...)] = true
+}
+
+// resolve returns the type that name refers to in type options (names of unnamed types are generated by the parser).
+func (c *checker) resolve(name string) []string {
+	if inner, ok := c.desc.Unnamed[name]; ok {
+		return inner
+	}
+	return []string{name}
+}
+
+// walk visits type typ (type name followed by options) used in direction dir.
+// parents are the enclosing structs that len fields can refer to by name,
+// they are tracked the same way prog.assignSizes does it: through struct fields and pointers,
+// but not through arrays and unions.
+func (c *checker) walk(typ []string, dir string, parents map[string]bool) {
+	switch typ[0] {
+	case "ptr":
+		c.walk(c.resolve(typ[2]), typ[1], parents)
+	case "array":
+		c.walk(c.resolve(typ[1]), dir, nil)
+	case "cond":
+		c.walk(c.resolve(typ[3]), dir, parents)
+	default:
+		if _, ok := c.desc.Resources[typ[0]]; ok {
+			if dir != "out" {
+				c.resIn[typ[0]] = true
+			}
+			if dir != "in" {
+				c.resOut[typ[0]] = true
+			}
+		} else if str, ok := c.desc.Structs[typ[0]]; ok {
+			c.walkStruct(str, dir, parents)
+		}
+	}
+}
+
+func (c *checker) walkStruct(str sysparser.Struct, dir string, parents map[string]bool) {
+	c.used[str.Name] = true
+	var names []string
+	for name := range parents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	key := fmt.Sprintf("%v %v %v", str.Name, dir, names)
+	if c.visited[key] {
+		return
+	}
+	c.visited[key] = true
+	var fieldParents map[string]bool
+	if !str.IsUnion {
+		fieldParents = map[string]bool{str.Name: true}
+		for name := range parents {
+			fieldParents[name] = true
+		}
+		c.checkFields("struct "+str.Name, str.Flds, fieldParents)
+	}
+	for _, f := range str.Flds {
+		c.walk(f[1:], dir, fieldParents)
+	}
+}
+
+// checkFields checks len fields and unbounded arrays among struct fields or syscall args,
+// parents are the struct itself and enclosing structs (nil for syscalls).
+func (c *checker) checkFields(what string, flds [][]string, parents map[string]bool) {
+	fields := make(map[string][]string)
+	for _, f := range flds {
+		fields[f[0]] = f[1:]
+	}
+	sized := make(map[string]bool)
+	for _, f := range flds {
+		for _, target := range c.lenTargets(what, f) {
+			sized[target] = true
+			if typ, ok := fields[target]; ok {
+				if target == f[0] {
+					c.warnf("%v: len field %v refers to itself", what, f[0])
+				} else if f[1] != "lenexpr" && isScalar(c.desc, typ) {
+					c.warnf("%v: len field %v refers to %v of scalar type %v", what, f[0], target, typ[0])
+				}
+			} else if !parents[target] && (parents == nil || target != "parent") {
+				c.warnf("%v: len field %v refers to non-existent field %v", what, f[0], target)
+			}
+		}
+	}
+	for i, f := range flds {
+		typ := f[1:]
+		if typ[0] == "ptr" {
+			typ = c.resolve(typ[2])
+		}
+		if typ[0] != "array" || len(typ) != 2 || sized[f[0]] {
+			continue
+		}
+		// The last field of a struct is commonly sized by a len field of an enclosing struct.
+		if parents != nil && i == len(flds)-1 {
+			continue
+		}
+		c.warnf("%v: array %v has no size bounds and no len field", what, f[0])
+	}
+}
+
+// lenTargets returns the names of the fields whose size field f gives.
+// Sizes of scalar fields are fine in lenexpr (e.g. "bytesize(payload)+4" for a header and an int payload).
+func (c *checker) lenTargets(what string, f []string) []string {
+	switch f[1] {
+	case "len", "bytesize", "bytesize2", "bytesize4", "bytesize8":
+		return []string{f[2]}
+	case "lenexpr":
+		e, err := sysparser.ParseExpr(strings.Trim(f[2], "\""))
+		if err != nil {
+			c.warnf("%v: len field %v: %v", what, f[0], err)
+			return nil
+		}
+		return exprFields(e)
+	}
+	return nil
+}
+
+// exprFields returns names of the fields used in len() and bytesize() of the expression,
+// offsetof() is not returned since it does not describe the size of the field.
+func exprFields(e *sysparser.Expr) []string {
+	switch e.Op {
+	case "len", "bytesize":
+		return []string{e.Name}
+	case "offsetof", "const", "ident":
+		return nil
+	}
+	var res []string
+	for _, arg := range e.Args {
+		res = append(res, exprFields(arg)...)
+	}
+	return res
+}
+
+// isScalar returns true for types whose size is fixed by the type itself,
+// a len field of such field is always the same.
+func isScalar(desc *sysparser.Description, typ []string) bool {
+	switch typ[0] {
+	case "len", "bytesize", "bytesize2", "bytesize4", "bytesize8", "lenexpr",
+		"flags", "const", "proc", "fileoff", "signalno", "csum":
+		return true
+	}
+	if _, ok := desc.Resources[typ[0]]; ok {
+		return true
+	}
+	return strings.HasPrefix(typ[0], "int") && typ[0] != "intvar"
+}
+
+func (c *checker) checkResources() {
+	children := make(map[string][]string)
+	for name, res := range c.desc.Resources {
+		children[res.Base] = append(children[res.Base], name)
+	}
+	// any returns true if pred holds for the resource or any resource derived from it.
+	var any func(name string, pred map[string]bool) bool
+	any = func(name string, pred map[string]bool) bool {
+		if pred[name] {
+			return true
+		}
+		for _, child := range children[name] {
+			if any(child, pred) {
+				return true
+			}
+		}
+		return false
+	}
+	for name := range c.desc.Resources {
+		if !any(name, c.resIn) && !any(name, c.resOut) {
+			c.warnf("resource %v is not used by any syscall", name)
+		} else if c.resIn[name] && !any(name, c.resOut) {
+			c.warnf("resource %v is used as input, but no syscall creates it", name)
+		}
+	}
+}
+
+func (c *checker) checkStructs() {
+	for name, str := range c.desc.Structs {
+		if c.used[name] || strings.HasPrefix(name, "nlattr") {
+			// Netlink attribute structs are generated for every use,
+			// the struct that contains the attribute is reported instead.
+			continue
+		}
+		what := "struct"
+		if str.IsUnion {
+			what = "union"
+		}
+		c.warnf("%v %v is not used by any syscall", what, name)
+	}
+}
+
+// checkConsts reports ioctl commands that encode different direction, type, number or argument size
+// for different arches. Other constants can legitimately differ (e.g. O_DIRECT),
+// but all supported arches are 64-bit, so ioctl commands should differ only in encoding.
+func (c *checker) checkConsts() {
+	consts := make(map[string]map[string]uint64)
+	for _, arch := range archs {
+		archConsts, err := sysparser.ReadConsts("sys", arch)
+		if err != nil {
+			failf("%v", err)
+		}
+		for name, val := range archConsts {
+			if consts[name] == nil {
+				consts[name] = make(map[string]uint64)
+			}
+			consts[name][arch] = val
+		}
+	}
+	for name, vals := range consts {
+		// Values that are the same for all arches are not encoded per arch (e.g. PR_SET_PTRACER).
+		distinct := make(map[uint64]bool)
+		for _, val := range vals {
+			distinct[val] = true
+		}
+		if len(distinct) == 1 {
+			continue
+		}
+		var cmds []ioctl
+		for _, arch := range archs {
+			if val, ok := vals[arch]; ok {
+				cmd, ok := decodeIoctl(arch, val)
+				if !ok {
+					// Legacy ioctl numbers (e.g. TCSBRK) and non-ioctl constants.
+					cmds = nil
+					break
+				}
+				cmds = append(cmds, cmd)
+			}
+		}
+		for _, cmd := range cmds {
+			if cmd != cmds[0] {
+				c.warnf("ioctl %v is encoded differently for different arches: %v", name, fmtIoctls(vals))
+				break
+			}
+		}
+	}
+}
+
+type ioctl struct {
+	dir  string
+	typ  uint64
+	nr   uint64
+	size uint64
+}
+
+// decodeIoctl decodes an _IOC() value, ok is false if val is not encoded as ioctl command.
+func decodeIoctl(arch string, val uint64) (cmd ioctl, ok bool) {
+	if val>>32 != 0 || val>>16 == 0 {
+		return cmd, false
+	}
+	cmd.typ = val >> 8 & 0xff
+	cmd.nr = val & 0xff
+	if cmd.typ == 0 {
+		// Flag values (e.g. CLONE_IO) that only look like ioctl commands.
+		return cmd, false
+	}
+	if arch == "ppc64le" {
+		// See arch/powerpc/include/uapi/asm/ioctl.h.
+		cmd.size = val >> 16 & 0x1fff
+		dirs := map[uint64]string{1: "none", 2: "read", 4: "write", 6: "readwrite"}
+		cmd.dir, ok = dirs[val>>29]
+		return cmd, ok
+	}
+	cmd.size = val >> 16 & 0x3fff
+	cmd.dir = []string{"none", "write", "read", "readwrite"}[val>>30]
+	return cmd, true
+}
+
+func fmtIoctls(vals map[string]uint64) string {
+	var res []string
+	for _, arch := range archs {
+		if val, ok := vals[arch]; ok {
+			cmd, _ := decodeIoctl(arch, val)
+			res = append(res, fmt.Sprintf("%v %v (dir=%v type=%#x nr=%#x size=%v)",
+				arch, val, cmd.dir, cmd.typ, cmd.nr, cmd.size))
+		}
+	}
+	return strings.Join(res, ", ")
+}
+
+func failf(msg string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, msg+"\n", args...)
+	os.Exit(1)
+}
+
+func logf(v int, msg string, args ...interface{}) {
+	if *flagV >= v {
+		fmt.Fprintf(os.Stderr, msg+"\n", args...)
+	}
+}
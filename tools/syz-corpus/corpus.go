@@ -0,0 +1,312 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+// syz-corpus prints statistics about composition and coverage of a corpus
+// and optionally compares it with another corpus (e.g. one grown from a different seed),
+// usage: syz-corpus [-vmlinux=vmlinux] [-top=N] workdir/corpus.db [other/corpus.db].
+// Coverage is read from cover.db that syz-manager keeps next to corpus.db.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/syzkaller/cover"
+	"github.com/google/syzkaller/db"
+	. "github.com/google/syzkaller/log"
+	"github.com/google/syzkaller/prog"
+	"github.com/google/syzkaller/symbolizer"
+)
+
+var (
+	flagVmlinux = flag.String("vmlinux", "", "kernel image to break down coverage by kernel files")
+	flagTop     = flag.Int("top", 30, "number of rows in tables (0 means all)")
+)
+
+// callLen is the length of the __sanitizer_cov_trace_pc call instruction on amd64,
+// kcov returns PCs after the call.
+const callLen = 5
+
+type corpus struct {
+	file   string
+	progs  map[string]*prog.Prog // hash -> program
+	inputs []input               // empty if there is no coverage for the corpus
+	cover  cover.Cover           // total coverage
+}
+
+// input is coverage of one call of a corpus program.
+type input struct {
+	call string
+	cov  cover.Cover
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 1 && flag.NArg() != 2 {
+		fmt.Fprintf(os.Stderr, "usage: syz-corpus [flags] corpus.db [other-corpus.db]\n")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	c := loadCorpus(flag.Arg(0))
+	printComposition(c)
+	if len(c.inputs) != 0 {
+		printCoverage(c)
+	}
+	if flag.NArg() == 2 {
+		printOverlap(c, loadCorpus(flag.Arg(1)))
+	}
+}
+
+func loadCorpus(file string) *corpus {
+	c := &corpus{
+		file:  file,
+		progs: make(map[string]*prog.Prog),
+	}
+	// The database can be used by a running manager, so it must not be modified.
+	records, err := db.ReadRecords(file)
+	if err != nil {
+		Fatalf("failed to read database: %v", err)
+	}
+	for key, rec := range records {
+		p, err := prog.Deserialize(rec.Val)
+		if err != nil {
+			Logf(0, "skipping broken program %v: %v", key, err)
+			continue
+		}
+		c.progs[key] = p
+	}
+	coverFile := filepath.Join(filepath.Dir(file), "cover.db")
+	if _, err := os.Stat(coverFile); err != nil {
+		Logf(0, "no coverage for %v (%v does not exist)", file, coverFile)
+		return c
+	}
+	// Keys in cover.db are program hash and call index separated by '-'.
+	covers, err := db.ReadRecords(coverFile)
+	if err != nil {
+		Fatalf("failed to read database: %v", err)
+	}
+	for key, rec := range covers {
+		pos := strings.LastIndexByte(key, '-')
+		if pos == -1 {
+			continue
+		}
+		p := c.progs[key[:pos]]
+		idx, err := strconv.Atoi(key[pos+1:])
+		if p == nil || err != nil || idx < 0 || idx >= len(p.Calls) {
+			continue
+		}
+		cov, err := cover.Deserialize(rec.Val)
+		if err != nil {
+			Logf(0, "skipping broken coverage %v: %v", key, err)
+			continue
+		}
+		c.inputs = append(c.inputs, input{p.Calls[idx].Meta.Name, cov})
+		c.cover = cover.Union(c.cover, cov)
+	}
+	return c
+}
+
+func printComposition(c *corpus) {
+	calls := make(map[string]*stat)
+	total, longest := 0, 0
+	for _, p := range c.progs {
+		total += len(p.Calls)
+		if longest < len(p.Calls) {
+			longest = len(p.Calls)
+		}
+		seen := make(map[string]bool)
+		for _, call := range p.Calls {
+			s := calls[call.Meta.Name]
+			if s == nil {
+				s = &stat{name: call.Meta.Name}
+				calls[call.Meta.Name] = s
+			}
+			s.values[0]++
+			if !seen[call.Meta.Name] {
+				seen[call.Meta.Name] = true
+				s.values[1]++
+			}
+		}
+	}
+	avg := 0.0
+	if len(c.progs) != 0 {
+		avg = float64(total) / float64(len(c.progs))
+	}
+	fmt.Printf("%v: %v programs, %v calls, %v distinct syscalls, average length %.1f, max length %v\n",
+		c.file, len(c.progs), total, len(calls), avg, longest)
+	printTable("syscall frequency", []string{"calls", "programs"}, calls, total)
+}
+
+func printCoverage(c *corpus) {
+	inputs := make(map[string]int)
+	totalCover := make(map[string]int) // sum of coverage of all inputs
+	callCover := make(map[string]cover.Cover)
+	for _, inp := range c.inputs {
+		inputs[inp.call]++
+		totalCover[inp.call] += len(inp.cov)
+		callCover[inp.call] = cover.Union(callCover[inp.call], inp.cov)
+	}
+	owners := make(map[uint32]int) // number of syscalls that cover the PC
+	for _, cov := range callCover {
+		for _, pc := range cov {
+			owners[pc]++
+		}
+	}
+	calls := make(map[string]*stat)
+	for call, cov := range callCover {
+		only := 0 // PCs that no other syscall covers
+		for _, pc := range cov {
+			if owners[pc] == 1 {
+				only++
+			}
+		}
+		calls[call] = &stat{call, [4]int{len(cov), only, inputs[call], totalCover[call]}}
+	}
+	fmt.Printf("\ncoverage: %v inputs, %v PCs\n", len(c.inputs), len(c.cover))
+	printTable("coverage per syscall", []string{"PCs", "only PCs", "inputs", "total PCs"}, calls, len(c.cover))
+	if *flagVmlinux == "" {
+		return
+	}
+	files, err := fileCoverage(c.cover)
+	if err != nil {
+		Fatalf("%v", err)
+	}
+	printTable("coverage per file", []string{"PCs"}, files, len(c.cover))
+}
+
+// fileCoverage symbolizes cov and returns the number of PCs per kernel source file.
+func fileCoverage(cov cover.Cover) (map[string]*stat, error) {
+	pcs := make([]uint64, len(cov))
+	for i, pc := range cov {
+		pcs[i] = cover.RestorePC(pc, 0xffffffff) - callLen
+	}
+	symb := symbolizer.NewSymbolizer()
+	defer symb.Close()
+	frames, err := symb.SymbolizeArray(*flagVmlinux, pcs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to symbolize coverage: %v", err)
+	}
+	files := make(map[string]*stat)
+	for _, frame := range frames {
+		if frame.Inline {
+			// Attribute inlined code to the function it is inlined into.
+			continue
+		}
+		s := files[frame.File]
+		if s == nil {
+			s = &stat{name: frame.File}
+			files[frame.File] = s
+		}
+		s.values[0]++
+	}
+	return files, nil
+}
+
+func printOverlap(c0, c1 *corpus) {
+	fmt.Printf("\n")
+	printComposition(c1)
+	if len(c1.inputs) != 0 {
+		printCoverage(c1)
+	}
+	common := 0
+	for key := range c0.progs {
+		if c1.progs[key] != nil {
+			common++
+		}
+	}
+	fmt.Printf("\nprograms: %v common, %v only in %v, %v only in %v\n",
+		common, len(c0.progs)-common, c0.file, len(c1.progs)-common, c1.file)
+	calls0, calls1 := callSet(c0), callSet(c1)
+	fmt.Printf("syscalls only in %v: %v\n", c0.file, strings.Join(difference(calls0, calls1), " "))
+	fmt.Printf("syscalls only in %v: %v\n", c1.file, strings.Join(difference(calls1, calls0), " "))
+	if len(c0.inputs) == 0 || len(c1.inputs) == 0 {
+		return
+	}
+	both := cover.Intersection(c0.cover, c1.cover)
+	fmt.Printf("coverage: %v PCs common, %v only in %v, %v only in %v\n",
+		len(both), len(c0.cover)-len(both), c0.file, len(c1.cover)-len(both), c1.file)
+	if *flagVmlinux == "" {
+		return
+	}
+	for _, x := range []struct {
+		c     *corpus
+		other cover.Cover
+	}{{c0, c1.cover}, {c1, c0.cover}} {
+		only := cover.Difference(x.c.cover, x.other)
+		files, err := fileCoverage(only)
+		if err != nil {
+			Fatalf("%v", err)
+		}
+		printTable("coverage only in "+x.c.file+" per file", []string{"PCs"}, files, len(only))
+	}
+}
+
+func callSet(c *corpus) map[string]bool {
+	calls := make(map[string]bool)
+	for _, p := range c.progs {
+		for _, call := range p.Calls {
+			calls[call.Meta.Name] = true
+		}
+	}
+	return calls
+}
+
+func difference(set0, set1 map[string]bool) []string {
+	var res []string
+	for name := range set0 {
+		if !set1[name] {
+			res = append(res, name)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// stat is a table row, rows are sorted by the first value.
+type stat struct {
+	name   string
+	values [4]int
+}
+
+type statArray []*stat
+
+func (a statArray) Len() int      { return len(a) }
+func (a statArray) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a statArray) Less(i, j int) bool {
+	if a[i].values[0] != a[j].values[0] {
+		return a[i].values[0] > a[j].values[0]
+	}
+	return a[i].name < a[j].name
+}
+
+// printTable prints the stats sorted by the first value and its percentage of total.
+func printTable(title string, columns []string, stats map[string]*stat, total int) {
+	var rows []*stat
+	for _, s := range stats {
+		rows = append(rows, s)
+	}
+	sort.Sort(statArray(rows))
+	if *flagTop != 0 && len(rows) > *flagTop {
+		rows = rows[:*flagTop]
+	}
+	fmt.Printf("\n%v (%v rows):\n", title, len(stats))
+	for _, col := range columns {
+		fmt.Printf("%10v", col)
+	}
+	fmt.Printf("%8v  %v\n", "%", "name")
+	for _, s := range rows {
+		for i := range columns {
+			fmt.Printf("%10v", s.values[i])
+		}
+		pct := 0.0
+		if total != 0 {
+			pct = float64(s.values[0]) * 100 / float64(total)
+		}
+		fmt.Printf("%8.1f  %v\n", pct, s.name)
+	}
+}
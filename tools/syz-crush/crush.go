@@ -1,25 +1,31 @@
 // Copyright 2016 syzkaller project authors. All rights reserved.
 // Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
 
-// syz-crush replays crash log on multiple VMs. Usage:
-//   syz-crush -config=config.file execution.log
-// Intended for reproduction of particularly elusive crashes.
+// syz-crush replays crash log or reproducer on multiple VMs. Usage:
+//   syz-crush -config=config.file execution.log|repro.c|repro-dir
+// Intended for reproduction of particularly elusive crashes,
+// for estimating reliability of reproducers and for validating fixes.
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/google/syzkaller/config"
+	"github.com/google/syzkaller/csource"
 	. "github.com/google/syzkaller/log"
+	"github.com/google/syzkaller/repro"
 	"github.com/google/syzkaller/vm"
 	_ "github.com/google/syzkaller/vm/adb"
 	_ "github.com/google/syzkaller/vm/gce"
@@ -28,9 +34,27 @@ import (
 )
 
 var (
-	flagConfig = flag.String("config", "", "configuration file")
+	flagConfig      = flag.String("config", "", "configuration file")
+	flagCount       = flag.Int("count", 0, "number of VMs to use (overrides config count param)")
+	flagDuration    = flag.Duration("duration", 0, "stop after this time (0 means run until interrupted)")
+	flagRestartTime = flag.Duration("restart_time", time.Hour, "restart a VM that did not crash after this time")
+	flagOutput      = flag.String("output", ".", "directory to save crash logs to")
+	flagC           = flag.Bool("c", false, "run C program of the reproducer bundle instead of the syzkaller program")
 )
 
+// Kinds of replayed input.
+const (
+	inputLog    = iota // crash log, all programs are executed with options from config
+	inputProg          // syzkaller program of a reproducer bundle, executed with the bundle options
+	inputBinary        // compiled C reproducer
+)
+
+type input struct {
+	kind int
+	file string // log, program or binary on the host
+	opts csource.Options
+}
+
 func main() {
 	flag.Parse()
 	cfg, _, err := config.Parse(*flagConfig)
@@ -38,13 +62,36 @@ func main() {
 		Fatalf("%v", err)
 	}
 	if len(flag.Args()) != 1 {
-		Fatalf("usage: syz-crush -config=config.file execution.log")
+		Fatalf("usage: syz-crush -config=config.file execution.log|repro.c|repro-dir")
+	}
+	if *flagCount > 0 {
+		cfg.Count = *flagCount
+	}
+	if err := os.MkdirAll(*flagOutput, 0750); err != nil {
+		Fatalf("failed to create output dir: %v", err)
+	}
+	in := loadInput(flag.Args()[0])
+	if in.kind == inputBinary {
+		defer os.Remove(in.file)
 	}
 
-	Logf(0, "booting test machines...")
+	st := &stats{
+		start:   time.Now(),
+		crashes: make(map[string]int),
+	}
 	var shutdown uint32
+	var shutdownOnce sync.Once
+	stop := func() {
+		shutdownOnce.Do(func() {
+			Logf(-1, "shutting down...")
+			atomic.StoreUint32(&shutdown, 1)
+			close(vm.Shutdown)
+		})
+	}
+
+	Logf(0, "booting %v test machines...", cfg.Count)
 	var wg sync.WaitGroup
-	wg.Add(cfg.Count + 1)
+	wg.Add(cfg.Count)
 	for i := 0; i < cfg.Count; i++ {
 		i := i
 		go func() {
@@ -57,7 +104,7 @@ func main() {
 				if err != nil {
 					Fatalf("failed to create VM config: %v", err)
 				}
-				runInstance(cfg, vmCfg)
+				runInstance(cfg, vmCfg, in, st)
 				if atomic.LoadUint32(&shutdown) != 0 {
 					break
 				}
@@ -69,60 +116,123 @@ func main() {
 		c := make(chan os.Signal, 2)
 		signal.Notify(c, syscall.SIGINT)
 		<-c
-		wg.Done()
-		atomic.StoreUint32(&shutdown, 1)
-		close(vm.Shutdown)
-		Logf(-1, "shutting down...")
-		atomic.StoreUint32(&shutdown, 1)
+		stop()
 		<-c
 		Fatalf("terminating")
 	}()
+	if *flagDuration != 0 {
+		go func() {
+			time.Sleep(*flagDuration)
+			stop()
+		}()
+	}
+	go func() {
+		for range time.NewTicker(10 * time.Minute).C {
+			Logf(0, "%v", st.summary())
+		}
+	}()
 	wg.Wait()
+	fmt.Printf("%v", st.summary())
 }
 
-func runInstance(cfg *config.Config, vmCfg *vm.Config) {
-	inst, err := vm.Create(cfg.Type, vmCfg)
+// loadInput prepares the replayed input given on the command line.
+// A directory is a reproducer bundle (see repro.Result.Save), a .c or .cprog file is a C reproducer,
+// anything else is a crash log (or a file with programs).
+func loadInput(file string) *input {
+	fi, err := os.Stat(file)
 	if err != nil {
-		Logf(0, "failed to create instance: %v", err)
-		return
+		Fatalf("%v", err)
 	}
-	defer inst.Close()
+	if fi.IsDir() {
+		meta, err := repro.LoadMeta(file)
+		if err != nil {
+			Fatalf("failed to load reproducer: %v", err)
+		}
+		Logf(0, "replaying reproducer of %q: %v", meta.Title, meta.Confidence())
+		if *flagC {
+			if !meta.CRepro {
+				Fatalf("the reproducer does not have a C program")
+			}
+			return loadCProg(filepath.Join(file, repro.CProgFile))
+		}
+		// Run the program until the VM is restarted, even if the reproducer does not repeat it.
+		opts := meta.Opts
+		opts.Repeat = true
+		return &input{kind: inputProg, file: filepath.Join(file, repro.ProgFile), opts: opts}
+	}
+	if strings.HasSuffix(file, ".c") || strings.HasSuffix(file, ".cprog") {
+		return loadCProg(file)
+	}
+	return &input{kind: inputLog, file: file}
+}
 
-	execprogBin, err := inst.Copy(filepath.Join(cfg.Syzkaller, "bin", "syz-execprog"))
+func loadCProg(file string) *input {
+	bin, err := csource.Build("c", file)
 	if err != nil {
-		Logf(0, "failed to copy execprog: %v", err)
-		return
+		Fatalf("failed to build C reproducer: %v", err)
 	}
-	executorBin, err := inst.Copy(filepath.Join(cfg.Syzkaller, "bin", "syz-executor"))
+	return &input{kind: inputBinary, file: bin}
+}
+
+func runInstance(cfg *config.Config, vmCfg *vm.Config, in *input, st *stats) {
+	inst, err := vm.Create(vmCfg.Type, vmCfg)
 	if err != nil {
-		Logf(0, "failed to copy executor: %v", err)
+		Logf(0, "failed to create instance: %v", err)
 		return
 	}
-	logFile, err := inst.Copy(flag.Args()[0])
+	defer inst.Close()
+
+	vmFile, err := inst.Copy(in.file)
 	if err != nil {
-		Logf(0, "failed to copy log: %v", err)
+		Logf(0, "failed to copy %v: %v", in.file, err)
 		return
 	}
-
-	cmd := fmt.Sprintf("%v -executor=%v -repeat=0 -procs=%v -cover=0 -sandbox=%v %v",
-		execprogBin, executorBin, cfg.Procs, cfg.Sandbox, logFile)
-	outc, errc, err := inst.Run(time.Hour, nil, cmd)
+	var cmd string
+	if in.kind == inputBinary {
+		// Programs that don't repeat themselves exit, restart them to not report lost connection.
+		cmd = fmt.Sprintf("while true; do %v; done", vmFile)
+	} else {
+		execprogBin, err := inst.Copy(filepath.Join(cfg.Syzkaller, "bin", "syz-execprog"))
+		if err != nil {
+			Logf(0, "failed to copy execprog: %v", err)
+			return
+		}
+		executorBin, err := inst.Copy(filepath.Join(cfg.Syzkaller, "bin", "syz-executor"))
+		if err != nil {
+			Logf(0, "failed to copy executor: %v", err)
+			return
+		}
+		if in.kind == inputProg {
+			cmd = repro.ExecprogCommand(cfg, execprogBin, executorBin, vmFile, in.opts)
+		} else {
+			cmd = fmt.Sprintf("%v -executor=%v -repeat=0 -procs=%v -cover=0 -sandbox=%v %v",
+				execprogBin, executorBin, cfg.Procs, cfg.Sandbox, vmFile)
+		}
+	}
+	start := time.Now()
+	outc, errc, err := inst.Run(*flagRestartTime, nil, cmd)
 	if err != nil {
-		Logf(0, "failed to run execprog: %v", err)
+		Logf(0, "failed to run command: %v", err)
 		return
 	}
 
 	Logf(0, "%v: crushing...", vmCfg.Name)
-	desc, _, output, crashed, timedout := vm.MonitorExecution(outc, errc, cfg.Type == "local", true, cfg.ParsedIgnores)
+	desc, _, output, crashed, timedout := vm.MonitorExecution(outc, errc, vmCfg.Type == "local", true, cfg.Reporter)
 	if timedout {
 		// This is the only "OK" outcome.
 		Logf(0, "%v: running long enough, restarting", vmCfg.Name)
+		st.add("", time.Since(start))
 	} else {
 		if !crashed {
-			// syz-execprog exited, but it should not.
+			// The command exited, but it should not.
 			desc = "lost connection to test machine"
 		}
-		f, err := ioutil.TempFile(".", "syz-crush")
+		if isShutdown() {
+			// The run was interrupted, it neither crashed nor survived.
+			return
+		}
+		st.add(desc, time.Since(start))
+		f, err := ioutil.TempFile(*flagOutput, "syz-crush")
 		if err != nil {
 			Logf(0, "failed to create temp file: %v", err)
 			return
@@ -133,3 +243,74 @@ func runInstance(cfg *config.Config, vmCfg *vm.Config) {
 	}
 	return
 }
+
+func isShutdown() bool {
+	select {
+	case <-vm.Shutdown:
+		return true
+	default:
+		return false
+	}
+}
+
+// stats collects outcomes of all runs.
+type stats struct {
+	mu        sync.Mutex
+	start     time.Time
+	runs      int
+	runTime   time.Duration  // total time of all runs
+	crashTime time.Duration  // total time of crashed runs
+	crashes   map[string]int // crash title -> count
+}
+
+func (st *stats) add(desc string, d time.Duration) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.runs++
+	st.runTime += d
+	if desc != "" {
+		st.crashTime += d
+		st.crashes[desc]++
+	}
+}
+
+func (st *stats) summary() string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	total := 0
+	var titles []titleCount
+	for title, n := range st.crashes {
+		total += n
+		titles = append(titles, titleCount{title, n})
+	}
+	sort.Sort(titleCountArray(titles))
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "runs: %v in %v (VM time %v), crashed: %v",
+		st.runs, time.Since(st.start).Truncate(time.Second), st.runTime.Truncate(time.Second), total)
+	if total != 0 {
+		fmt.Fprintf(buf, " (%.1f%%), average time to crash %v, crashes per VM-hour %.2f",
+			float64(total)*100/float64(st.runs), (st.crashTime / time.Duration(total)).Truncate(time.Second),
+			float64(total)/st.runTime.Hours())
+	}
+	fmt.Fprintf(buf, "\n")
+	for _, t := range titles {
+		fmt.Fprintf(buf, "%6v %v\n", t.count, t.title)
+	}
+	return buf.String()
+}
+
+type titleCount struct {
+	title string
+	count int
+}
+
+type titleCountArray []titleCount
+
+func (a titleCountArray) Len() int      { return len(a) }
+func (a titleCountArray) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a titleCountArray) Less(i, j int) bool {
+	if a[i].count != a[j].count {
+		return a[i].count > a[j].count
+	}
+	return a[i].title < a[j].title
+}
@@ -1,29 +1,57 @@
 // Copyright 2017 syzkaller project authors. All rights reserved.
 // Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
 
+// syz-db inspects and modifies corpus databases (corpus.db of syz-manager and syz-hub).
+// Database keys are hashes of the text form of programs regardless of the storage format.
 package main
 
 import (
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/google/syzkaller/db"
 	"github.com/google/syzkaller/hash"
+	"github.com/google/syzkaller/prog"
+)
+
+var (
+	flagCall     = flag.String("call", "", "filter: keep only programs that use any of these comma-separated syscalls")
+	flagExclude  = flag.String("exclude", "", "filter: drop programs that use any of these comma-separated syscalls")
+	flagMinCalls = flag.Int("min-calls", 0, "filter: drop programs with fewer calls")
+	flagMaxCalls = flag.Int("max-calls", 0, "filter: drop programs with more calls (0 means no limit)")
+	flagFormat   = flag.String("format", "", "storage format of programs in the output database: text or binary (default: keep as is)")
 )
 
 func main() {
-	if len(os.Args) != 4 {
+	flag.Usage = usage
+	flag.Parse()
+	args := flag.Args()
+	if len(args) == 0 {
 		usage()
 	}
-	switch os.Args[1] {
-	case "pack":
-		pack(os.Args[2], os.Args[3])
-	case "unpack":
-		unpack(os.Args[2], os.Args[3])
+	switch cmd, args := args[0], args[1:]; {
+	case cmd == "pack" && len(args) == 2:
+		pack(args[0], args[1])
+	case cmd == "unpack" && len(args) == 2:
+		unpack(args[0], args[1])
+	case cmd == "list" && len(args) == 1:
+		list(args[0])
+	case cmd == "dump" && len(args) == 1:
+		dump(args[0])
+	case cmd == "filter" && len(args) == 2:
+		filter(args[0], args[1])
+	case cmd == "merge" && len(args) >= 2:
+		merge(args[0], args[1:])
+	case cmd == "dedup" && len(args) == 2:
+		dedup(args[0], args[1])
+	case cmd == "repack" && len(args) == 2:
+		repack(args[0], args[1])
 	default:
 		usage()
 	}
@@ -33,6 +61,15 @@ func usage() {
 	fmt.Fprintf(os.Stderr, "usage:\n")
 	fmt.Fprintf(os.Stderr, "  syz-db pack dir corpus.db\n")
 	fmt.Fprintf(os.Stderr, "  syz-db unpack corpus.db dir\n")
+	fmt.Fprintf(os.Stderr, "  syz-db list corpus.db\n")
+	fmt.Fprintf(os.Stderr, "  syz-db dump corpus.db\n")
+	fmt.Fprintf(os.Stderr, "  syz-db [-call=c1,c2] [-exclude=c1,c2] [-min-calls=N] [-max-calls=N] filter in.db out.db\n")
+	fmt.Fprintf(os.Stderr, "  syz-db merge out.db in1.db in2.db...\n")
+	fmt.Fprintf(os.Stderr, "  syz-db dedup in.db out.db\n")
+	fmt.Fprintf(os.Stderr, "  syz-db repack in.db out.db\n")
+	fmt.Fprintf(os.Stderr, "pack, filter, merge, dedup and repack accept -format=text|binary to convert programs\n")
+	fmt.Fprintf(os.Stderr, "flags:\n")
+	flag.PrintDefaults()
 	os.Exit(1)
 }
 
@@ -41,11 +78,7 @@ func pack(dir, file string) {
 	if err != nil {
 		failf("failed to read dir: %v", err)
 	}
-	os.Remove(file)
-	db, err := db.Open(file)
-	if err != nil {
-		failf("failed to open database file: %v", err)
-	}
+	records := make(map[string]db.Record)
 	for _, file := range files {
 		data, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
 		if err != nil {
@@ -60,32 +93,225 @@ func pack(dir, file string) {
 				key = parts[0]
 			}
 		}
-		if sig := hash.String(data); key != sig {
+		if sig := textHash(data); key != sig {
 			fmt.Fprintf(os.Stderr, "fixing hash %v -> %v\n", key, sig)
 			key = sig
 		}
-		db.Save(key, data, seq)
-	}
-	if err := db.Flush(); err != nil {
-		failf("failed to save database file: %v", err)
+		records[key] = db.Record{Val: data, Seq: seq}
 	}
+	writeDB(file, records)
 }
 
 func unpack(file, dir string) {
-	db, err := db.Open(file)
+	// Stream the database instead of loading it into memory,
+	// later entries for the same key override earlier ones.
+	it, err := db.NewIterator(file)
 	if err != nil {
 		failf("failed to open database: %v", err)
 	}
+	defer it.Close()
 	os.Mkdir(dir, 0750)
-	for key, rec := range db.Records {
-		fname := filepath.Join(dir, key)
-		if rec.Seq != 0 {
-			fname += fmt.Sprintf("-%v", rec.Seq)
+	files := make(map[string]string)
+	for it.Next() {
+		if old := files[it.Key]; old != "" {
+			os.Remove(old)
+			delete(files, it.Key)
+		}
+		if it.Deleted {
+			continue
+		}
+		fname := filepath.Join(dir, it.Key)
+		if it.Rec.Seq != 0 {
+			fname += fmt.Sprintf("-%v", it.Rec.Seq)
 		}
-		if err := ioutil.WriteFile(fname, rec.Val, 0640); err != nil {
+		if err := ioutil.WriteFile(fname, it.Rec.Val, 0640); err != nil {
 			failf("failed to output file: %v", err)
 		}
+		files[it.Key] = fname
+	}
+	if err := it.Err(); err != nil {
+		failf("%v", err)
+	}
+}
+
+// list prints one line per program: key, seq, storage format, size in bytes and calls.
+func list(file string) {
+	records := readDB(file)
+	for _, key := range sortedKeys(records) {
+		rec := records[key]
+		format := "text"
+		if prog.IsBinary(rec.Val) {
+			format = "binary"
+		}
+		calls := "<broken>"
+		if p, err := prog.Deserialize(rec.Val); err == nil {
+			var names []string
+			for _, c := range p.Calls {
+				names = append(names, c.Meta.Name)
+			}
+			calls = strings.Join(names, " ")
+		}
+		fmt.Printf("%v %v %v %v %v\n", key, rec.Seq, format, len(rec.Val), calls)
+	}
+}
+
+// dump prints all programs in the text format.
+func dump(file string) {
+	records := readDB(file)
+	for _, key := range sortedKeys(records) {
+		rec := records[key]
+		text, err := prog.ConvertToText(rec.Val)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "program %v is broken: %v\n", key, err)
+			continue
+		}
+		fmt.Printf("# %v seq=%v\n%s\n", key, rec.Seq, text)
+	}
+}
+
+func filter(in, out string) {
+	include := callSet(*flagCall)
+	exclude := callSet(*flagExclude)
+	records := readDB(in)
+	res := make(map[string]db.Record)
+	for key, rec := range records {
+		p, err := prog.Deserialize(rec.Val)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "dropping broken program %v: %v\n", key, err)
+			continue
+		}
+		if len(p.Calls) < *flagMinCalls || *flagMaxCalls != 0 && len(p.Calls) > *flagMaxCalls {
+			continue
+		}
+		included, excluded := len(include) == 0, false
+		for _, c := range p.Calls {
+			included = included || include[c.Meta.Name]
+			excluded = excluded || exclude[c.Meta.Name]
+		}
+		if included && !excluded {
+			res[key] = rec
+		}
+	}
+	fmt.Fprintf(os.Stderr, "kept %v out of %v programs\n", len(res), len(records))
+	writeDB(out, res)
+}
+
+// merge writes union of the databases, for programs present in several databases
+// the one with the highest seq is taken.
+func merge(out string, ins []string) {
+	res := make(map[string]db.Record)
+	for _, in := range ins {
+		for key, rec := range readDB(in) {
+			if old, ok := res[key]; !ok || old.Seq < rec.Seq {
+				res[key] = rec
+			}
+		}
+	}
+	fmt.Fprintf(os.Stderr, "merged %v programs\n", len(res))
+	writeDB(out, res)
+}
+
+// dedup drops broken programs and programs that are the same after re-serialization
+// (e.g. saved by different syzkaller versions that format programs differently),
+// and fixes keys that don't match program hashes.
+func dedup(in, out string) {
+	records := readDB(in)
+	res := make(map[string]db.Record)
+	for _, key := range sortedKeys(records) {
+		rec := records[key]
+		p, err := prog.Deserialize(rec.Val)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "dropping broken program %v: %v\n", key, err)
+			continue
+		}
+		sig := hash.String(p.Serialize())
+		if old, ok := res[sig]; ok && old.Seq >= rec.Seq {
+			continue
+		}
+		if prog.IsBinary(rec.Val) {
+			rec.Val = p.SerializeBinary()
+		} else {
+			rec.Val = p.Serialize()
+		}
+		res[sig] = rec
+	}
+	fmt.Fprintf(os.Stderr, "kept %v out of %v programs\n", len(res), len(records))
+	writeDB(out, res)
+}
+
+// repack rewrites the database without deleted and overwritten records.
+func repack(in, out string) {
+	writeDB(out, readDB(in))
+}
+
+// readDB reads the database without modifying it (db.Open can compact the file).
+func readDB(file string) map[string]db.Record {
+	records, err := db.ReadRecords(file)
+	if err != nil {
+		failf("failed to read database: %v", err)
+	}
+	return records
+}
+
+// writeDB replaces the file with a database containing records converted to the -format format.
+func writeDB(file string, records map[string]db.Record) {
+	os.Remove(file)
+	out, err := db.Open(file)
+	if err != nil {
+		failf("failed to open database file: %v", err)
+	}
+	for key, rec := range records {
+		val, err := convert(rec.Val)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to convert program %v: %v\n", key, err)
+			val = rec.Val
+		}
+		out.Save(key, val, rec.Seq)
+	}
+	if err := out.Flush(); err != nil {
+		failf("failed to save database file: %v", err)
+	}
+}
+
+func convert(data []byte) ([]byte, error) {
+	switch *flagFormat {
+	case "":
+		return data, nil
+	case "text":
+		return prog.ConvertToText(data)
+	case "binary":
+		return prog.ConvertToBinary(data)
+	default:
+		failf("unknown format %q, want text or binary", *flagFormat)
+	}
+	return nil, nil
+}
+
+// textHash returns the key of the program, that is the hash of its text form.
+func textHash(data []byte) string {
+	if text, err := prog.ConvertToText(data); err == nil {
+		data = text
+	}
+	return hash.String(data)
+}
+
+func callSet(list string) map[string]bool {
+	set := make(map[string]bool)
+	for _, c := range strings.Split(list, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			set[c] = true
+		}
+	}
+	return set
+}
+
+func sortedKeys(records map[string]db.Record) []string {
+	var keys []string
+	for key := range records {
+		keys = append(keys, key)
 	}
+	sort.Strings(keys)
+	return keys
 }
 
 func failf(msg string, args ...interface{}) {
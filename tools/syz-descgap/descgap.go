@@ -0,0 +1,235 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+// syz-descgap reports kernel interfaces that lack syscall descriptions:
+// syscalls present in vmlinux that are not described (ordered by size of the handler)
+// and ioctl handlers in the kernel source whose commands are not described
+// (ordered by the number of undescribed commands).
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/google/syzkaller/symbolizer"
+	"github.com/google/syzkaller/sysparser"
+)
+
+var (
+	flagVmlinux = flag.String("vmlinux", "", "path to vmlinux (for syscall list)")
+	flagLinux   = flag.String("linux", "", "path to linux kernel source checkout (for ioctl handlers)")
+	flagDesc    = flag.String("desc", "sys/*.txt", "glob of description files")
+	flagAll     = flag.Bool("all", false, "also list ioctl handlers that don't use named commands")
+)
+
+func main() {
+	flag.Parse()
+	if *flagVmlinux == "" && *flagLinux == "" {
+		fmt.Fprintf(os.Stderr, "usage: syz-descgap [-vmlinux vmlinux] [-linux /linux/checkout] [-desc 'sys/*.txt']\n")
+		os.Exit(1)
+	}
+	files, err := filepath.Glob(*flagDesc)
+	if err != nil || len(files) == 0 {
+		fmt.Fprintf(os.Stderr, "failed to find description files: %v\n", err)
+		os.Exit(1)
+	}
+	desc := sysparser.ParseFiles(files)
+	if *flagVmlinux != "" {
+		syscalls, err := undescribedSyscalls(*flagVmlinux, desc)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("undescribed syscalls (%v):\n", len(syscalls))
+		for _, sc := range syscalls {
+			fmt.Printf("\t%-32v handler size %v\n", sc.name, sc.size)
+		}
+	}
+	if *flagLinux != "" {
+		handlers, err := undescribedIoctls(*flagLinux, desc)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("ioctl handlers with undescribed commands (%v):\n", len(handlers))
+		for _, h := range handlers {
+			fmt.Printf("\t%v (%v): %v commands, %v described\n",
+				h.file, strings.Join(h.funcs, ", "), len(h.cmds), len(h.cmds)-len(h.missing))
+			if len(h.missing) != 0 {
+				fmt.Printf("\t\t%v\n", strings.Join(h.missing, " "))
+			}
+		}
+	}
+}
+
+type syscall struct {
+	name string
+	size int
+}
+
+// Prefixes of syscall handler symbols in different kernel versions and archs.
+var syscallPrefixes = []string{"sys_", "SyS_", "__do_sys_", "__se_sys_", "__x64_sys_", "__arm64_sys_"}
+
+func undescribedSyscalls(vmlinux string, desc *sysparser.Description) ([]syscall, error) {
+	symbols, err := symbolizer.ReadSymbols(vmlinux)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vmlinux symbols: %v", err)
+	}
+	described := make(map[string]bool)
+	for _, c := range desc.Syscalls {
+		described[c.CallName] = true
+	}
+	sizes := make(map[string]int)
+	for sym, ss := range symbols {
+		for _, prefix := range syscallPrefixes {
+			if !strings.HasPrefix(sym, prefix) {
+				continue
+			}
+			name := sym[len(prefix):]
+			if name == "ni_syscall" || described[name] {
+				continue
+			}
+			for _, s := range ss {
+				if sizes[name] < s.Size {
+					sizes[name] = s.Size
+				}
+			}
+		}
+	}
+	var res []syscall
+	for name, size := range sizes {
+		res = append(res, syscall{name, size})
+	}
+	sort.Sort(syscallArray(res))
+	return res, nil
+}
+
+type syscallArray []syscall
+
+func (a syscallArray) Len() int      { return len(a) }
+func (a syscallArray) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a syscallArray) Less(i, j int) bool {
+	if a[i].size != a[j].size {
+		return a[i].size > a[j].size
+	}
+	return a[i].name < a[j].name
+}
+
+type ioctlHandler struct {
+	file    string
+	funcs   []string
+	cmds    []string
+	missing []string
+}
+
+var (
+	handlerRe = regexp.MustCompile(`\.(?:unlocked_)?ioctl\s*=\s*([a-zA-Z0-9_]+)`)
+	cmdRe     = regexp.MustCompile(`(?m)^\s*#\s*define\s+([A-Za-z0-9_]+)\s+_IO(?:R|W|WR)?(?:_BAD)?\s*\(`)
+	identRe   = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]*`)
+)
+
+func undescribedIoctls(linux string, desc *sysparser.Description) ([]*ioctlHandler, error) {
+	// Consts referenced by descriptions, ioctl commands are among them.
+	described := make(map[string]bool)
+	for _, vals := range desc.Flags {
+		for _, v := range vals {
+			described[v] = true
+		}
+	}
+	for v := range desc.Defines {
+		described[v] = true
+	}
+	// Ioctl commands are collected from all headers and sources,
+	// since drivers frequently define commands in private headers.
+	cmds := make(map[string]bool)
+	var sources []string
+	err := filepath.Walk(linux, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			switch info.Name() {
+			case ".git", "Documentation", "scripts", "tools", "samples":
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(path, ".c") && !strings.HasSuffix(path, ".h") {
+			return nil
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		for _, match := range cmdRe.FindAllSubmatch(data, -1) {
+			cmds[string(match[1])] = true
+		}
+		if strings.HasSuffix(path, ".c") && handlerRe.Match(data) {
+			sources = append(sources, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk kernel sources: %v", err)
+	}
+	var res []*ioctlHandler
+	for _, path := range sources {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		h := &ioctlHandler{file: path}
+		if rel, err := filepath.Rel(linux, path); err == nil {
+			h.file = rel
+		}
+		funcs := make(map[string]bool)
+		for _, match := range handlerRe.FindAllSubmatch(data, -1) {
+			if fn := string(match[1]); fn != "NULL" && !funcs[fn] {
+				funcs[fn] = true
+				h.funcs = append(h.funcs, fn)
+			}
+		}
+		used := make(map[string]bool)
+		for _, id := range identRe.FindAll(data, -1) {
+			cmd := string(id)
+			if !cmds[cmd] || used[cmd] {
+				continue
+			}
+			used[cmd] = true
+			h.cmds = append(h.cmds, cmd)
+			if !described[cmd] {
+				h.missing = append(h.missing, cmd)
+			}
+		}
+		if len(h.funcs) == 0 || len(h.missing) == 0 && (len(h.cmds) != 0 || !*flagAll) {
+			continue
+		}
+		sort.Strings(h.missing)
+		res = append(res, h)
+	}
+	sort.Sort(handlerArray(res))
+	return res, nil
+}
+
+// handlerArray sorts handlers with more undescribed commands first,
+// partially described handlers go before undescribed ones with the same number.
+type handlerArray []*ioctlHandler
+
+func (a handlerArray) Len() int      { return len(a) }
+func (a handlerArray) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a handlerArray) Less(i, j int) bool {
+	if len(a[i].missing) != len(a[j].missing) {
+		return len(a[i].missing) > len(a[j].missing)
+	}
+	di, dj := len(a[i].cmds)-len(a[i].missing), len(a[j].cmds)-len(a[j].missing)
+	if di != dj {
+		return di > dj
+	}
+	return a[i].file < a[j].file
+}
@@ -22,6 +22,7 @@ import (
 	"github.com/google/syzkaller/ipc"
 	. "github.com/google/syzkaller/log"
 	"github.com/google/syzkaller/prog"
+	"github.com/google/syzkaller/symbolizer"
 )
 
 var (
@@ -30,6 +31,10 @@ var (
 	flagRepeat    = flag.Int("repeat", 1, "repeat execution that many times (0 for infinite loop)")
 	flagProcs     = flag.Int("procs", 1, "number of parallel processes to execute programs")
 	flagOutput    = flag.String("output", "none", "write programs to none/stdout")
+	flagFaultCall = flag.Int("fault_call", -1, "inject fault into this call (0-based)")
+	flagFaultNth  = flag.Int("fault_nth", 0, "inject fault on n-th operation (0-based)")
+	flagCalls     = flag.Bool("calls", false, "print errno, coverage and new coverage of every executed call")
+	flagVmlinux   = flag.String("vmlinux", "", "with -calls, symbolize new coverage PCs using this kernel image")
 )
 
 func main() {
@@ -60,10 +65,20 @@ func main() {
 	if err != nil {
 		Fatalf("%v", err)
 	}
+	if *flagCalls {
+		flags |= ipc.FlagCover
+	}
 	if *flagCoverFile != "" {
 		flags |= ipc.FlagCover
 		flags &= ^ipc.FlagDedupCover
 	}
+	execOpts := new(ipc.ExecOpts)
+	if *flagFaultCall >= 0 {
+		flags |= ipc.FlagEnableFault
+		execOpts.Flags |= ipc.ExecFlagInjectFault
+		execOpts.FaultCall = *flagFaultCall
+		execOpts.FaultNth = *flagFaultNth
+	}
 
 	var wg sync.WaitGroup
 	wg.Add(*flagProcs)
@@ -72,6 +87,13 @@ func main() {
 	var pos int
 	var lastPrint time.Time
 	var shutdown uint32
+	cp := &callPrinter{
+		seen: make(map[uint32]bool),
+	}
+	if *flagVmlinux != "" {
+		cp.symb = symbolizer.NewSymbolizer()
+		defer cp.symb.Close()
+	}
 	for p := 0; p < *flagProcs; p++ {
 		pid := p
 		go func() {
@@ -106,7 +128,7 @@ func main() {
 						Logf(0, "executing program %v:\n%s", pid, data)
 						logMu.Unlock()
 					}
-					output, cov, _, failed, hanged, err := env.Exec(p)
+					output, info, failed, hanged, err := env.Exec(execOpts, p)
 					if atomic.LoadUint32(&shutdown) != 0 {
 						return false
 					}
@@ -116,11 +138,20 @@ func main() {
 					if flags&ipc.FlagDebug != 0 || err != nil {
 						fmt.Printf("result: failed=%v hanged=%v err=%v\n\n%s", failed, hanged, err, output)
 					}
+					if *flagFaultCall >= 0 && *flagFaultCall < len(info) {
+						fmt.Printf("fault injected: %v\n", info[*flagFaultCall].FaultInjected)
+					}
+					if *flagCalls {
+						logMu.Lock()
+						cp.print(p, info)
+						logMu.Unlock()
+					}
 					if *flagCoverFile != "" {
 						// Coverage is dumped in sanitizer format.
 						// github.com/google/sanitizers/tools/sancov command can be used to dump PCs,
 						// then they can be piped via addr2line to symbolize.
-						for i, c := range cov {
+						for i, inf := range info {
+							c := inf.Cover
 							fmt.Printf("call #%v: coverage %v\n", i, len(c))
 							if len(c) == 0 {
 								continue
@@ -156,3 +187,57 @@ func main() {
 
 	wg.Wait()
 }
+
+// callPrinter prints results of individual calls, so that description authors can check
+// that a program works as intended and reaches the intended kernel code.
+type callPrinter struct {
+	seen map[uint32]bool // PCs covered by previously executed calls
+	symb *symbolizer.Symbolizer
+}
+
+// callLen is the length of the __sanitizer_cov_trace_pc call instruction on amd64,
+// kcov returns PCs after the call.
+const callLen = 5
+
+func (cp *callPrinter) print(p *prog.Prog, info []ipc.CallInfo) {
+	for i, c := range p.Calls {
+		if i >= len(info) {
+			break
+		}
+		inf := info[i]
+		result := "not executed"
+		switch {
+		case inf.Errno == 0:
+			result = "ok"
+		case inf.Errno > 0:
+			result = fmt.Sprintf("errno %v (%v)", inf.Errno, syscall.Errno(inf.Errno))
+		}
+		var newPCs []uint32
+		for _, pc := range inf.Cover {
+			if !cp.seen[pc] {
+				cp.seen[pc] = true
+				newPCs = append(newPCs, pc)
+			}
+		}
+		fmt.Printf("call #%v %v: %v, coverage %v, new %v\n", i, c.Meta.Name, result, len(inf.Cover), len(newPCs))
+		if cp.symb == nil || len(newPCs) == 0 {
+			continue
+		}
+		pcs := make([]uint64, len(newPCs))
+		for j, pc := range newPCs {
+			pcs[j] = cover.RestorePC(pc, 0xffffffff) - callLen
+		}
+		frames, err := cp.symb.SymbolizeArray(*flagVmlinux, pcs)
+		if err != nil {
+			fmt.Printf("\tfailed to symbolize: %v\n", err)
+			continue
+		}
+		for _, frame := range frames {
+			inline := ""
+			if frame.Inline {
+				inline = " [inline]"
+			}
+			fmt.Printf("\t0x%x %v %v:%v%v\n", frame.PC, frame.Func, frame.File, frame.Line, inline)
+		}
+	}
+}
@@ -2,6 +2,7 @@
 // Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
 
 // mutates mutates a given program and prints result.
+// Several mutants are separated by empty lines.
 package main
 
 import (
@@ -10,19 +11,31 @@ import (
 	"io/ioutil"
 	"math/rand"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/google/syzkaller/db"
 	"github.com/google/syzkaller/prog"
 )
 
 var (
-	flagSeed = flag.Int("seed", -1, "prng seed")
+	flagSeed      = flag.Int("seed", -1, "prng seed")
+	flagCount     = flag.Int("count", 1, "number of mutants to generate, each one is a mutation of the original program")
+	flagRounds    = flag.Int("rounds", 1, "number of times every mutant is mutated")
+	flagLen       = flag.Int("len", 0, "max number of calls in a mutant (default: number of calls in the program + 10)")
+	flagMutations = flag.String("mutations", "", "comma-separated mutation strategies with optional weights "+
+		"(e.g. insert_call:60,mutate_arg), all strategies with default weights if empty; available: "+
+		strings.Join(prog.MutationStrategies(), ", "))
+	flagCorpus = flag.String("corpus", "", "corpus database with programs for splicing")
 )
 
 func main() {
 	flag.Parse()
 	if flag.NArg() != 1 {
-		fmt.Fprintf(os.Stderr, "usage: mutate program\n")
+		fmt.Fprintf(os.Stderr, "usage: mutate [flags] program\n")
+		flag.PrintDefaults()
 		os.Exit(1)
 	}
 	data, err := ioutil.ReadFile(flag.Arg(0))
@@ -35,15 +48,88 @@ func main() {
 		fmt.Fprintf(os.Stderr, "failed to deserialize the program: %v\n", err)
 		os.Exit(1)
 	}
+	mutator, err := buildMutator(*flagMutations)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	corpus, err := loadCorpus(*flagCorpus)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
 
-	prios := prog.CalculatePriorities(nil)
+	prios := prog.CalculatePriorities(corpus)
 	ct := prog.BuildChoiceTable(prios, nil)
 
 	seed := time.Now().UnixNano()
 	if *flagSeed != -1 {
 		seed = int64(*flagSeed)
+	} else {
+		fmt.Fprintf(os.Stderr, "seed: %v\n", seed)
+	}
+	ncalls := *flagLen
+	if ncalls == 0 {
+		ncalls = len(p.Calls) + 10
 	}
 	rs := rand.NewSource(seed)
-	p.Mutate(rs, len(p.Calls)+10, ct, nil)
-	fmt.Printf("%s\n", p.Serialize())
+	for i := 0; i < *flagCount; i++ {
+		p1 := p.Clone()
+		for j := 0; j < *flagRounds; j++ {
+			mutator.Mutate(rs, p1, ncalls, ct, corpus)
+		}
+		if i != 0 {
+			fmt.Printf("\n")
+		}
+		fmt.Printf("%s", p1.Serialize())
+	}
+}
+
+// buildMutator creates a mutator from the -mutations flag value (e.g. "insert_call:60,splice"),
+// strategies without weight get weight 1.
+func buildMutator(mutations string) (*prog.Mutator, error) {
+	weights := make(map[string]int)
+	if mutations != "" {
+		for _, s := range strings.Split(mutations, ",") {
+			parts := strings.Split(s, ":")
+			w := 1
+			if len(parts) > 2 {
+				return nil, fmt.Errorf("bad mutation strategy '%v' in -mutations flag", s)
+			}
+			if len(parts) == 2 {
+				var err error
+				if w, err = strconv.Atoi(parts[1]); err != nil {
+					return nil, fmt.Errorf("bad mutation strategy weight '%v' in -mutations flag", s)
+				}
+			}
+			weights[parts[0]] = w
+		}
+	}
+	return prog.NewMutator(weights)
+}
+
+func loadCorpus(file string) ([]*prog.Prog, error) {
+	if file == "" {
+		return nil, nil
+	}
+	corpusDB, err := db.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open corpus database: %v", err)
+	}
+	// Sort programs, so that mutants are reproducible with the same seed.
+	var keys []string
+	for key := range corpusDB.Records {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var corpus []*prog.Prog
+	for _, key := range keys {
+		p, err := prog.Deserialize(corpusDB.Records[key].Val)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "skipping broken program in corpus: %v\n", err)
+			continue
+		}
+		corpus = append(corpus, p)
+	}
+	return corpus, nil
 }
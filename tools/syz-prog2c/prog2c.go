@@ -4,26 +4,54 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/google/syzkaller/csource"
 	"github.com/google/syzkaller/prog"
 )
 
 var (
-	flagThreaded = flag.Bool("threaded", false, "create threaded program")
-	flagCollide  = flag.Bool("collide", false, "create collide program")
-	flagRepeat   = flag.Bool("repeat", false, "repeat program infinitely or not")
-	flagProcs    = flag.Int("procs", 4, "number of parallel processes")
-	flagSandbox  = flag.String("sandbox", "none", "sandbox to use (none, setuid, namespace)")
-	flagProg     = flag.String("prog", "", "file with program to convert (required)")
+	flagThreaded  = flag.Bool("threaded", false, "create threaded program")
+	flagCollide   = flag.Bool("collide", false, "create collide program")
+	flagFaithful  = flag.Bool("faithful", false, "execute threaded/collide program the same way executor does")
+	flagTimeout   = flag.Int("call_timeout", 20, "call timeout for faithful program (in ms)")
+	flagFaultCall = flag.Int("fault_call", -1, "inject fault into this call (0-based)")
+	flagFaultNth  = flag.Int("fault_nth", 0, "inject fault on n-th operation (0-based)")
+	flagRepeat    = flag.Bool("repeat", false, "repeat program infinitely or not")
+	flagProcs     = flag.Int("procs", 4, "number of parallel processes")
+	flagSandbox   = flag.String("sandbox", "none", "sandbox to use (none, setuid, namespace)")
+	flagNetns     = flag.Bool("netns", false, "create a separate network namespace with virtual devices")
+	flagNoFork    = flag.Bool("nofork", false, "don't fork a process per iteration of repeated program")
+	flagTun       = flag.Bool("tun", false, "set up tun device even if the program does not use it")
+	flagMinimal   = flag.Bool("minimal", false, "create one-shot program without sandbox and process setup")
+	flagProg      = flag.String("prog", "", "file with program to convert (required)")
+	flagProfile   = flag.String("profile", "", "target build environment ("+strings.Join(csource.ProfileNames(), ", ")+"), glibc x86_64 if empty")
+
+	flagDir   = flag.String("dir", "", "batch mode: convert all programs in this dir instead of -prog")
+	flagOut   = flag.String("out", "", "batch mode: output dir for C files and Makefile")
+	flagBuild = flag.Bool("build", false, "batch mode: build every C file with the compiler of the profile")
+	flagJobs  = flag.Int("j", runtime.NumCPU(), "batch mode: number of parallel builds")
 )
 
 func main() {
 	flag.Parse()
+	if *flagDir != "" {
+		if *flagOut == "" {
+			fmt.Fprintf(os.Stderr, "batch mode requires -out\n")
+			os.Exit(1)
+		}
+		batch(*flagDir, *flagOut)
+		return
+	}
 	if *flagProg == "" {
 		flag.PrintDefaults()
 		os.Exit(1)
@@ -33,22 +61,9 @@ func main() {
 		fmt.Fprintf(os.Stderr, "failed to read prog file: %v\n", err)
 		os.Exit(1)
 	}
-	p, err := prog.Deserialize(data)
+	src, err := convert(data)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "failed to deserialize the program: %v\n", err)
-		os.Exit(1)
-	}
-	opts := csource.Options{
-		Threaded: *flagThreaded,
-		Collide:  *flagCollide,
-		Repeat:   *flagRepeat,
-		Procs:    *flagProcs,
-		Sandbox:  *flagSandbox,
-		Repro:    false,
-	}
-	src, err := csource.Write(p, opts)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "failed to generate C source: %v\n", err)
+		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
 	if formatted, err := csource.Format(src); err != nil {
@@ -58,3 +73,185 @@ func main() {
 	}
 	os.Stdout.Write(src)
 }
+
+func options() csource.Options {
+	return csource.Options{
+		Threaded:    *flagThreaded,
+		Collide:     *flagCollide,
+		Repeat:      *flagRepeat,
+		Procs:       *flagProcs,
+		Sandbox:     *flagSandbox,
+		Netns:       *flagNetns,
+		Repro:       false,
+		Faithful:    *flagFaithful,
+		CallTimeout: *flagTimeout,
+		Fault:       *flagFaultCall >= 0,
+		FaultCall:   *flagFaultCall,
+		FaultNth:    *flagFaultNth,
+		Profile:     *flagProfile,
+		NoFork:      *flagNoFork,
+		Tun:         *flagTun,
+		Minimal:     *flagMinimal,
+	}
+}
+
+// convert generates C source for the serialized program data.
+func convert(data []byte) ([]byte, error) {
+	p, err := prog.Deserialize(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to deserialize the program: %v", err)
+	}
+	src, err := csource.Write(p, options())
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate C source: %v", err)
+	}
+	return src, nil
+}
+
+// batch converts every program in dir into out/name.c and writes out/Makefile that builds them all.
+// With -build every C file is also compiled, so that csource regressions are caught over a whole corpus.
+// Exits with non-zero status if any program fails to convert or to build.
+func batch(dir, out string) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read dir: %v\n", err)
+		os.Exit(1)
+	}
+	if err := os.MkdirAll(out, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create output dir: %v\n", err)
+		os.Exit(1)
+	}
+	profile, ok := csource.Profiles[*flagProfile]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown profile %q\n", *flagProfile)
+		os.Exit(1)
+	}
+	var names []string
+	seen := make(map[string]bool)
+	failed := make(map[string]string) // program name -> reason
+	formatFailed := false
+	for _, file := range files {
+		if !file.Mode().IsRegular() || strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read prog file: %v\n", err)
+			os.Exit(1)
+		}
+		name := binaryName(file.Name())
+		for i := 1; seen[name]; i++ {
+			name = fmt.Sprintf("%v_%v", binaryName(file.Name()), i)
+		}
+		seen[name] = true
+		src, err := convert(data)
+		if err != nil {
+			failed[name] = err.Error()
+			continue
+		}
+		if formatted, err := csource.Format(src); err != nil {
+			if !formatFailed {
+				// clang-format is most likely missing, no point in reporting this for every file.
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				formatFailed = true
+			}
+		} else {
+			src = formatted
+		}
+		if err := ioutil.WriteFile(filepath.Join(out, name+".c"), src, 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write C file: %v\n", err)
+			os.Exit(1)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if err := ioutil.WriteFile(filepath.Join(out, "Makefile"), makefile(profile, names), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write Makefile: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("converted %v programs out of %v\n", len(names), len(names)+len(failed))
+	if *flagBuild {
+		built := build(out, names, failed)
+		fmt.Printf("built %v programs out of %v with %v\n", built, len(names), profile.CC)
+	}
+	if len(failed) == 0 {
+		return
+	}
+	var bad []string
+	for name := range failed {
+		bad = append(bad, name)
+	}
+	sort.Strings(bad)
+	for _, name := range bad {
+		fmt.Printf("\n%v: %v\n", name, failed[name])
+	}
+	os.Exit(1)
+}
+
+// build compiles C files of the programs in parallel,
+// adds the programs that fail to build to failed and returns the number of built programs.
+func build(out string, names []string, failed map[string]string) int {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	built := 0
+	work := make(chan string)
+	for i := 0; i < *flagJobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range work {
+				bin, err := csource.BuildProfile(*flagProfile, "c", filepath.Join(out, name+".c"))
+				mu.Lock()
+				if err != nil {
+					// The error contains the whole source, the compiler output is at the end.
+					lines := strings.Split(strings.TrimSpace(err.Error()), "\n")
+					if len(lines) > 20 {
+						lines = lines[len(lines)-20:]
+					}
+					failed[name] = "failed to build program:\n" + strings.Join(lines, "\n")
+				} else {
+					os.Remove(bin)
+					built++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, name := range names {
+		work <- name
+	}
+	close(work)
+	wg.Wait()
+	return built
+}
+
+// binaryName turns a program file name into a name that is safe to use as a make target.
+func binaryName(file string) string {
+	file = strings.TrimSuffix(file, filepath.Ext(file))
+	return strings.Map(func(c rune) rune {
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-' {
+			return c
+		}
+		return '_'
+	}, file)
+}
+
+// makefile generates a Makefile that builds the programs with the compiler and flags of the profile
+// (the same ones csource.BuildProfile uses), CC, CFLAGS and LDFLAGS can be overridden on the make command line.
+func makefile(profile csource.Profile, names []string) []byte {
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "# AUTOGENERATED by syz-prog2c\n\n")
+	fmt.Fprintf(buf, "CC = %v\n", profile.CC)
+	fmt.Fprintf(buf, "CFLAGS = -Wall -Werror -pthread -O1 -g %v\n", strings.Join(profile.CFlags, " "))
+	ldflags := ""
+	if profile.Static {
+		ldflags = "-static"
+	}
+	fmt.Fprintf(buf, "LDFLAGS = %v\n\n", ldflags)
+	fmt.Fprintf(buf, "BINS = %v\n\n", strings.Join(names, " "))
+	fmt.Fprintf(buf, ".PHONY: all clean\n\n")
+	fmt.Fprintf(buf, "all: $(BINS)\n\n")
+	fmt.Fprintf(buf, "%%: %%.c\n\t$(CC) $(CFLAGS) $< -o $@ $(LDFLAGS)\n\n")
+	fmt.Fprintf(buf, "clean:\n\trm -f $(BINS)\n")
+	return buf.Bytes()
+}
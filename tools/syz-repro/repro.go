@@ -25,6 +25,8 @@ import (
 var (
 	flagConfig = flag.String("config", "", "configuration file")
 	flagCount  = flag.Int("count", 0, "number of VMs to use (overrides config count param)")
+	flagCrash  = flag.Int("crash", 0, "index of the crash to reproduce if the log contains several crashes")
+	flagOutput = flag.String("output", "", "save reproducer bundle (repro.prog, repro.cprog, repro.json) to this dir")
 )
 
 func main() {
@@ -47,6 +49,11 @@ func main() {
 	if err != nil {
 		Fatalf("failed to open log file: %v", err)
 	}
+	if crashes := repro.ParseCrashes(data, cfg.Reporter); len(crashes) > 1 {
+		for i, crash := range crashes {
+			Logf(0, "crash #%v: %v", i, crash.Title)
+		}
+	}
 	vmIndexes := make([]int, cfg.Count)
 	for i := range vmIndexes {
 		vmIndexes[i] = i
@@ -62,15 +69,21 @@ func main() {
 		Fatalf("terminating")
 	}()
 
-	res, err := repro.Run(data, cfg, vmIndexes)
+	res, err := repro.RunCrash(data, *flagCrash, cfg, vmIndexes)
 	if err != nil {
 		Logf(0, "reproduction failed: %v", err)
 	}
 	if res == nil {
 		return
 	}
+	if *flagOutput != "" {
+		if err := res.Save(*flagOutput, cfg.Tag); err != nil {
+			Fatalf("%v", err)
+		}
+	}
 
-	fmt.Printf("opts: %+v crepro: %v\n\n", res.Opts, res.CRepro)
+	fmt.Printf("opts: %+v crepro: %v\n", res.Opts, res.CRepro)
+	fmt.Printf("reliability: %v\n\n", res.Confidence())
 	fmt.Printf("%s\n", res.Prog.Serialize())
 	if res.CRepro {
 		src, err := csource.Write(res.Prog, res.Opts)
@@ -0,0 +1,89 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+// syz-trace2syz converts strace output of real workloads into syzkaller programs
+// that can be used to seed corpus. The trace should be obtained with:
+//	strace -f -v -s 65500 -o trace.txt workload
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/google/syzkaller/db"
+	"github.com/google/syzkaller/hash"
+	"github.com/google/syzkaller/prog"
+	"github.com/google/syzkaller/sysparser"
+)
+
+var (
+	flagArch   = flag.String("arch", runtime.GOARCH, "target arch (selects const files)")
+	flagSys    = flag.String("sys", "sys", "dir with syscall descriptions const files")
+	flagDir    = flag.String("dir", "", "write programs to this dir (one file per program)")
+	flagCorpus = flag.String("corpus", "", "add programs to this corpus.db")
+)
+
+func main() {
+	flag.Parse()
+	if flag.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "usage: syz-trace2syz [flags] trace.txt...\n")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	consts, err := sysparser.ReadConsts(*flagSys, *flagArch)
+	if err != nil {
+		failf("%v", err)
+	}
+	var progs []*prog.Prog
+	for _, file := range flag.Args() {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			failf("failed to read trace file: %v", err)
+		}
+		progs1, err := prog.ParseStrace(data, consts)
+		if err != nil {
+			failf("failed to convert %v: %v", file, err)
+		}
+		progs = append(progs, progs1...)
+	}
+	if *flagDir == "" && *flagCorpus == "" {
+		for _, p := range progs {
+			fmt.Printf("%s\n", p.Serialize())
+		}
+		return
+	}
+	if *flagDir != "" {
+		if err := os.MkdirAll(*flagDir, 0755); err != nil {
+			failf("failed to create output dir: %v", err)
+		}
+		for _, p := range progs {
+			data := p.Serialize()
+			if err := ioutil.WriteFile(filepath.Join(*flagDir, hash.String(data)), data, 0640); err != nil {
+				failf("failed to write program: %v", err)
+			}
+		}
+	}
+	if *flagCorpus != "" {
+		corpus, err := db.Open(*flagCorpus)
+		if err != nil {
+			failf("failed to open corpus database: %v", err)
+		}
+		for _, p := range progs {
+			data := p.Serialize()
+			corpus.Save(hash.String(data), data, 0)
+		}
+		if err := corpus.Flush(); err != nil {
+			failf("failed to save corpus database: %v", err)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "converted %v programs\n", len(progs))
+}
+
+func failf(msg string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, msg+"\n", args...)
+	os.Exit(1)
+}
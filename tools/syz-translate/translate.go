@@ -0,0 +1,353 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+// syz-translate rewrites a corpus database collected on one arch for another arch,
+// e.g. to bootstrap fuzzing on arm64 with an existing amd64 corpus.
+// Programs refer to syscalls by name, so only arch-dependent values need to be translated:
+// values of consts, flags and special resource values are remapped by the names of the constants
+// in the descriptions using sys/*.const files, ioctl commands are re-encoded for the destination arch.
+// Calls that are not supported on the destination arch are dropped.
+// The source arch is the arch syz-translate is built for (descriptions are compiled in for GOARCH),
+// and it must be run from the syzkaller checkout, usage: syz-translate -arch=arm64 in.db out.db.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/google/syzkaller/db"
+	"github.com/google/syzkaller/hash"
+	. "github.com/google/syzkaller/log"
+	"github.com/google/syzkaller/prog"
+	"github.com/google/syzkaller/sys"
+	"github.com/google/syzkaller/sysparser"
+)
+
+var (
+	flagArch = flag.String("arch", "", "destination arch")
+)
+
+type translator struct {
+	desc      *sysparser.Description
+	calls     map[string]sysparser.Syscall
+	srcArch   string
+	dstArch   string
+	srcConsts map[string]uint64
+	dstConsts map[string]uint64
+
+	droppedCalls map[string]int
+	remapped     int
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 2 || *flagArch == "" {
+		fmt.Fprintf(os.Stderr, "usage: syz-translate -arch=arm64 in.db out.db\n")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	if *flagArch == runtime.GOARCH {
+		Fatalf("the corpus is already for %v (syz-translate translates from the arch it is built for)", *flagArch)
+	}
+	if _, ok := sysparser.IoctlEncodings[runtime.GOARCH]; !ok {
+		Fatalf("unsupported source arch %v", runtime.GOARCH)
+	}
+	if _, ok := sysparser.IoctlEncodings[*flagArch]; !ok {
+		Fatalf("unsupported destination arch %v", *flagArch)
+	}
+	tr := newTranslator("sys", runtime.GOARCH, *flagArch)
+
+	records, err := db.ReadRecords(flag.Arg(0))
+	if err != nil {
+		Fatalf("failed to read input database: %v", err)
+	}
+	os.Remove(flag.Arg(1))
+	out, err := db.Open(flag.Arg(1))
+	if err != nil {
+		Fatalf("failed to open output database: %v", err)
+	}
+	dropped, broken := 0, 0
+	for key, rec := range records {
+		p, err := prog.Deserialize(rec.Val)
+		if err != nil {
+			Logf(1, "skipping broken program %v: %v", key, err)
+			broken++
+			continue
+		}
+		tr.translate(p)
+		if len(p.Calls) == 0 {
+			dropped++
+			continue
+		}
+		data := p.Serialize()
+		sig := hash.String(data)
+		if prog.IsBinary(rec.Val) {
+			data = p.SerializeBinary()
+		}
+		if old, ok := out.Records[sig]; ok && old.Seq >= rec.Seq {
+			continue
+		}
+		out.Save(sig, data, rec.Seq)
+	}
+	if err := out.Flush(); err != nil {
+		Fatalf("failed to save output database: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "translated %v programs out of %v (%v broken, %v without supported calls), remapped %v values\n",
+		len(out.Records), len(records), broken, dropped, tr.remapped)
+	var names []string
+	for name := range tr.droppedCalls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "dropped %v %v calls\n", tr.droppedCalls[name], name)
+	}
+}
+
+// translate rewrites p for the destination arch in place, unsupported calls are removed.
+func (tr *translator) translate(p *prog.Prog) {
+	for i := 0; i < len(p.Calls); i++ {
+		c := p.Calls[i]
+		desc, ok := tr.calls[c.Meta.Name]
+		supported := ok && len(desc.Args) == len(c.Args) && tr.supported(desc)
+		for j := 0; supported && j < len(c.Args); j++ {
+			supported = tr.translateArg(c.Args[j], desc.Args[j][1:])
+		}
+		if !supported {
+			tr.droppedCalls[c.Meta.Name]++
+			p.RemoveCall(i)
+			i--
+		}
+	}
+}
+
+// newTranslator creates a translator from srcArch to dstArch using descriptions and const files in dir.
+func newTranslator(dir, srcArch, dstArch string) *translator {
+	files, err := filepath.Glob(filepath.Join(dir, "*.txt"))
+	if err != nil || len(files) == 0 {
+		Fatalf("failed to find description files: %v", err)
+	}
+	srcConsts, err := sysparser.ReadConsts(dir, srcArch)
+	if err != nil {
+		Fatalf("%v", err)
+	}
+	dstConsts, err := sysparser.ReadConsts(dir, dstArch)
+	if err != nil {
+		Fatalf("%v", err)
+	}
+	tr := &translator{
+		desc:         sysparser.ParseFiles(files),
+		calls:        make(map[string]sysparser.Syscall),
+		srcArch:      srcArch,
+		dstArch:      dstArch,
+		srcConsts:    srcConsts,
+		dstConsts:    dstConsts,
+		droppedCalls: make(map[string]int),
+	}
+	for _, c := range tr.desc.Syscalls {
+		tr.calls[c.Name] = c
+	}
+	return tr
+}
+
+// supported says if the syscall has a number on the destination arch, pseudo-syscalls are supported everywhere.
+func (tr *translator) supported(c sysparser.Syscall) bool {
+	if strings.HasPrefix(c.CallName, "syz_") {
+		return true
+	}
+	_, ok := tr.dstConsts["__NR_"+c.CallName]
+	return ok
+}
+
+// resolve returns the type that name refers to in type options (names of unnamed types are generated by the parser).
+func (tr *translator) resolve(name string) []string {
+	if inner, ok := tr.desc.Unnamed[name]; ok {
+		return inner
+	}
+	return []string{name}
+}
+
+// translateArg translates arg of description type typ (type name followed by options),
+// returns false if a constant used by the arg is missing on the destination arch.
+func (tr *translator) translateArg(arg *prog.Arg, typ []string) bool {
+	if arg == nil {
+		return true
+	}
+	switch t := arg.Type.(type) {
+	case *sys.PtrType:
+		if typ[0] == "ptr" {
+			return tr.translateArg(arg.Res, tr.resolve(typ[2]))
+		}
+	case *sys.ArrayType:
+		if typ[0] == "array" {
+			for _, inner := range arg.Inner {
+				if !tr.translateArg(inner, tr.resolve(typ[1])) {
+					return false
+				}
+			}
+		}
+	case *sys.CondType:
+		if typ[0] == "cond" {
+			for _, inner := range arg.Inner {
+				if !tr.translateArg(inner, tr.resolve(typ[3])) {
+					return false
+				}
+			}
+		}
+	case *sys.StructType:
+		str := tr.desc.Structs[t.Name()]
+		for _, inner := range arg.Inner {
+			if sys.IsPad(inner.Type) {
+				continue
+			}
+			if fld := findField(str, inner.Type.FieldName()); fld != nil && !tr.translateArg(inner, fld) {
+				return false
+			}
+		}
+	case *sys.UnionType:
+		str := tr.desc.Structs[t.Name()]
+		if fld := findField(str, arg.OptionType.FieldName()); fld != nil {
+			return tr.translateArg(arg.Option, fld)
+		}
+	case *sys.ConstType:
+		if typ[0] == "const" && !t.IsPad {
+			val, ok := tr.value(typ[1], uint64(arg.Val))
+			if !ok {
+				return false
+			}
+			tr.set(arg, val)
+		}
+	case *sys.FlagsType:
+		if typ[0] == "flags" {
+			tr.set(arg, tr.flags(t, tr.desc.Flags[typ[1]], uint64(arg.Val)))
+		}
+	case *sys.ResourceType:
+		if arg.Kind == prog.ArgConst {
+			tr.set(arg, tr.resource(t.Desc.Name, uint64(arg.Val)))
+		}
+	}
+	return true
+}
+
+func (tr *translator) set(arg *prog.Arg, val uint64) {
+	if uintptr(val) != arg.Val {
+		arg.Val = uintptr(val)
+		tr.remapped++
+	}
+}
+
+// findField returns type of field name of the struct or union.
+func findField(str sysparser.Struct, name string) []string {
+	for _, f := range str.Flds {
+		if f[0] == name {
+			return f[1:]
+		}
+	}
+	return nil
+}
+
+// value returns the destination value of constant name (named constant, number or ioctl command),
+// src is the value on the source arch.
+func (tr *translator) value(name string, src uint64) (uint64, bool) {
+	if sysparser.IsIoctl(name) {
+		return tr.ioctl(name, src)
+	}
+	if isIdentifier(name) {
+		v, ok := tr.dstConsts[name]
+		return v, ok
+	}
+	return src, true
+}
+
+// ioctl re-encodes ioctl command name with value src for the destination arch.
+// Argument size is taken from the source value, since structs have the same layout on all arches.
+func (tr *translator) ioctl(name string, src uint64) (uint64, bool) {
+	c, err := sysparser.ParseIoctl(name)
+	if err != nil {
+		return 0, false
+	}
+	typ, ok1 := sysparser.IoctlOperand(c.Type, tr.dstConsts)
+	nr, ok2 := sysparser.IoctlOperand(c.Nr, tr.dstConsts)
+	if !ok1 || !ok2 {
+		return 0, false
+	}
+	srcEnc, dstEnc := sysparser.IoctlEncodings[tr.srcArch], sysparser.IoctlEncodings[tr.dstArch]
+	return dstEnc.Command(c, typ, nr, srcEnc.Size(src)), true
+}
+
+// flags translates value of flags type t with flag names, the value is either one of the flags
+// or a combination of them, flags that are missing on the destination arch are dropped.
+func (tr *translator) flags(t *sys.FlagsType, names []string, val uint64) uint64 {
+	// Source values of the flags are taken from the type, it contains values of the flags
+	// that are present on the source arch in the order of the names.
+	var src, dst []uint64
+	var present []string
+	for _, name := range names {
+		if _, ok := tr.srcConsts[name]; ok || !isIdentifier(name) && !sysparser.IsIoctl(name) {
+			present = append(present, name)
+		} else if sysparser.IsIoctl(name) {
+			if c, err := sysparser.ParseIoctl(name); err == nil {
+				_, ok1 := sysparser.IoctlOperand(c.Type, tr.srcConsts)
+				_, ok2 := sysparser.IoctlOperand(c.Nr, tr.srcConsts)
+				if ok1 && ok2 {
+					present = append(present, name)
+				}
+			}
+		}
+	}
+	if len(present) != len(t.Vals) {
+		// The descriptions don't match the compiled in tables.
+		return val
+	}
+	for i, name := range present {
+		v, ok := tr.value(name, uint64(t.Vals[i]))
+		if !ok {
+			v = 0
+		}
+		src = append(src, uint64(t.Vals[i]))
+		dst = append(dst, v)
+	}
+	for i := range src {
+		if src[i] == val {
+			return dst[i]
+		}
+	}
+	res, rem := uint64(0), val
+	for i := range src {
+		if src[i] != 0 && rem&src[i] == src[i] {
+			res |= dst[i]
+			rem &^= src[i]
+		}
+	}
+	// Bits that don't correspond to any flag are left as is.
+	return res | rem
+}
+
+// resource translates special value val of the resource (e.g. AT_FDCWD).
+func (tr *translator) resource(name string, val uint64) uint64 {
+	for res, ok := tr.desc.Resources[name]; ok; res, ok = tr.desc.Resources[res.Base] {
+		for _, v := range res.Values {
+			if src, ok := tr.srcConsts[v]; ok && src == val {
+				if dst, ok := tr.dstConsts[v]; ok {
+					return dst
+				}
+			}
+		}
+	}
+	return val
+}
+
+func isIdentifier(s string) bool {
+	for i, c := range s {
+		if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || i > 0 && (c >= '0' && c <= '9') {
+			continue
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,126 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/google/syzkaller/prog"
+	"github.com/google/syzkaller/sys"
+	"github.com/google/syzkaller/sysparser"
+)
+
+func TestTranslate(t *testing.T) {
+	if runtime.GOARCH != "amd64" {
+		t.Skipf("the test program is written for amd64")
+	}
+	tr := newTranslator("../../sys", "amd64", "arm64")
+	// O_DIRECTORY|O_NOFOLLOW|O_DIRECT is 0x34000 on amd64 and 0x1c000 on arm64,
+	// epoll_wait does not exist on arm64.
+	p, err := prog.Deserialize([]byte(`r0 = openat(0xffffffffffffff9c, &(0x7f0000000000)="2e00", 0x34000, 0x0)
+epoll_wait(0xffffffffffffffff, &(0x7f0000001000)=[], 0x0, 0x0)
+close(r0)
+`))
+	if err != nil {
+		t.Fatalf("failed to deserialize program: %v", err)
+	}
+	tr.translate(p)
+	want := `r0 = openat(0xffffffffffffff9c, &(0x7f0000000000)="2e00", 0x1c000, 0x0)
+close(r0)
+`
+	if got := string(p.Serialize()); got != want {
+		t.Fatalf("got program:\n%v\nwant:\n%v", got, want)
+	}
+	if want := map[string]int{"epoll_wait": 1}; !reflect.DeepEqual(tr.droppedCalls, want) {
+		t.Fatalf("dropped calls %v, want %v", tr.droppedCalls, want)
+	}
+}
+
+func TestValue(t *testing.T) {
+	tr := &translator{
+		srcArch:   "amd64",
+		dstArch:   "ppc64le",
+		srcConsts: map[string]uint64{"FOO": 1, "BAR": 2, "KVMIO": 0xae, "DRM_IOCTL_BASE": 'd'},
+		dstConsts: map[string]uint64{"FOO": 3, "KVMIO": 0xae},
+	}
+	tests := []struct {
+		name string
+		src  uint64
+		dst  uint64
+		ok   bool
+	}{
+		{"FOO", 1, 3, true},
+		{"BAR", 2, 0, false},
+		{"0x10", 0x10, 0x10, true},
+		{"_IO(KVMIO,0x01)", 0xae01, 0x2000ae01, true},
+		{"_IOR('T',0x30,int32)", 0x80045430, 0x40045430, true},
+		{"_IOWR('d',0x00,drm_version)", 0xc0406400, 0xc0406400, true},
+		{"_IOWR(DRM_IOCTL_BASE,0x00,drm_version)", 0xc0406400, 0, false},
+	}
+	for _, test := range tests {
+		dst, ok := tr.value(test.name, test.src)
+		if dst != test.dst || ok != test.ok {
+			t.Errorf("%v: got 0x%x/%v, want 0x%x/%v", test.name, dst, ok, test.dst, test.ok)
+		}
+	}
+}
+
+func TestFlags(t *testing.T) {
+	tr := &translator{
+		srcConsts: map[string]uint64{"A": 0x1, "B": 0x2, "C": 0x4},
+		dstConsts: map[string]uint64{"A": 0x10, "B": 0x20, "D": 0x40},
+	}
+	// D is missing on the source arch, so it is not in the compiled in values.
+	names := []string{"A", "D", "B", "C"}
+	typ := &sys.FlagsType{Vals: []uintptr{0x1, 0x2, 0x4}}
+	tests := []struct {
+		src uint64
+		dst uint64
+	}{
+		{0x1, 0x10},
+		{0x2, 0x20},
+		{0x3, 0x30},
+		{0x4, 0x0},     // C is missing on the destination arch
+		{0x7, 0x30},    // so it is dropped from combinations
+		{0x101, 0x110}, // unknown bits are left as is
+		{0x0, 0x0},
+	}
+	for _, test := range tests {
+		if dst := tr.flags(typ, names, test.src); dst != test.dst {
+			t.Errorf("0x%x: got 0x%x, want 0x%x", test.src, dst, test.dst)
+		}
+	}
+	// If the descriptions don't match the compiled in values, the value is not changed.
+	if dst := tr.flags(&sys.FlagsType{Vals: []uintptr{0x1}}, names, 0x1); dst != 0x1 {
+		t.Errorf("mismatching flags: got 0x%x, want 0x1", dst)
+	}
+}
+
+func TestResource(t *testing.T) {
+	tr := &translator{
+		desc: &sysparser.Description{Resources: map[string]sysparser.Resource{
+			"fd":     {Name: "fd", Base: "int32", Values: []string{"NO_FD"}},
+			"fd_dir": {Name: "fd_dir", Base: "fd", Values: []string{"AT_FDCWD"}},
+		}},
+		srcConsts: map[string]uint64{"NO_FD": 0xffffffff, "AT_FDCWD": 0xffffff9c},
+		dstConsts: map[string]uint64{"NO_FD": 0xfffffffe, "AT_FDCWD": 0xffffff9d},
+	}
+	tests := []struct {
+		res string
+		src uint64
+		dst uint64
+	}{
+		{"fd_dir", 0xffffff9c, 0xffffff9d},
+		{"fd_dir", 0xffffffff, 0xfffffffe}, // values of the base resource
+		{"fd", 0xffffff9c, 0xffffff9c},     // values of derived resources are not used
+		{"fd", 0x3, 0x3},
+	}
+	for _, test := range tests {
+		if dst := tr.resource(test.res, test.src); dst != test.dst {
+			t.Errorf("%v 0x%x: got 0x%x, want 0x%x", test.res, test.src, dst, test.dst)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+// syz-uapicheck cross-checks sizes of structs/unions and offsets of their fields
+// declared in syscall descriptions against the kernel UAPI headers.
+// Structs are matched to C structs by name and fields are matched by name,
+// structs and fields that don't have a C counterpart are skipped.
+// The check is done for the arch syz-uapicheck is built for (GOARCH).
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/syzkaller/sys"
+	"github.com/google/syzkaller/sysparser"
+)
+
+var (
+	flagLinux    = flag.String("linux", "", "path to linux kernel source checkout")
+	flagLinuxBld = flag.String("linuxbld", "", "path to linux kernel build directory")
+	flagV        = flag.Int("v", 0, "verbosity")
+)
+
+type Arch struct {
+	KernelHeaderArch string
+	CFlags           []string
+}
+
+// archs must match archs in syz-extract.
+var archs = map[string]*Arch{
+	"amd64":   {"x86", []string{"-m64"}},
+	"arm64":   {"arm64", []string{}},
+	"ppc64le": {"powerpc", []string{"-D__powerpc64__"}},
+}
+
+// probe is a C expression whose value is compared with the value
+// computed from the descriptions (e.g. sizeof(struct foo)).
+type probe struct {
+	what string
+	expr string
+	val  uintptr
+}
+
+func main() {
+	flag.Parse()
+	if *flagLinux == "" {
+		failf("provide path to linux kernel checkout via -linux flag")
+	}
+	if *flagLinuxBld == "" {
+		*flagLinuxBld = *flagLinux
+	}
+	arch := archs[runtime.GOARCH]
+	if arch == nil {
+		failf("unsupported arch %v", runtime.GOARCH)
+	}
+	files := flag.Args()
+	if len(files) == 0 {
+		var err error
+		if files, err = filepath.Glob("sys/*.txt"); err != nil || len(files) == 0 {
+			failf("failed to find description files: %v", err)
+		}
+	}
+
+	types := make(map[string]sys.Type)
+	for _, t := range sys.Structs {
+		types[t.Name()] = t
+	}
+	checked := make(map[string]bool)
+	drift := 0
+	for _, file := range files {
+		desc := sysparser.ParseFiles([]string{file})
+		var probes []*probe
+		var names []string
+		for name := range desc.Structs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if checked[name] || types[name] == nil {
+				continue
+			}
+			checked[name] = true
+			probes = append(probes, typeProbes(types[name])...)
+		}
+		if len(probes) == 0 {
+			continue
+		}
+		vals, err := fetchValues(arch, probes, desc.Includes, desc.Defines)
+		if err != nil {
+			failf("%v: %v", file, err)
+		}
+		logf(1, "%v: checked %v out of %v probes", file, len(vals), len(probes))
+		for _, p := range probes {
+			if v, ok := vals[p]; ok && v != p.val {
+				fmt.Printf("%v: %v is %v in descriptions, but %v in kernel headers\n", file, p.what, p.val, v)
+				drift++
+			}
+		}
+	}
+	if drift != 0 {
+		failf("found %v mismatches", drift)
+	}
+}
+
+// typeProbes returns probes for size and field offsets of struct/union t.
+func typeProbes(t sys.Type) []*probe {
+	kind := "struct"
+	if _, ok := t.(*sys.UnionType); ok {
+		kind = "union"
+	}
+	ctype := kind + " " + t.Name()
+	var probes []*probe
+	if !t.Varlen() {
+		probes = append(probes, &probe{
+			what: fmt.Sprintf("size of %v", ctype),
+			expr: fmt.Sprintf("sizeof(%v)", ctype),
+			val:  t.Size(),
+		})
+	}
+	str, ok := t.(*sys.StructType)
+	if !ok {
+		return probes
+	}
+	var off uintptr
+	for _, f := range str.Fields {
+		if f.Varlen() {
+			// Offsets of subsequent fields are not statically known.
+			break
+		}
+		if !sys.IsPad(f) && f.BitfieldLength() == 0 && f.FieldName() != "" {
+			probes = append(probes, &probe{
+				what: fmt.Sprintf("offset of %v.%v", ctype, f.FieldName()),
+				expr: fmt.Sprintf("__builtin_offsetof(%v, %v)", ctype, f.FieldName()),
+				val:  off,
+			})
+		}
+		if f.BitfieldLength() == 0 || f.BitfieldLast() {
+			off += f.Size()
+		}
+	}
+	return probes
+}
+
+var errorRe = regexp.MustCompile(`<stdin>:([0-9]+):[0-9]+: error:`)
+
+// fetchValues evaluates probes in a C program compiled against the kernel headers.
+// Probes that fail to compile (e.g. the struct or the field does not exist in C)
+// are dropped, values of the remaining probes are returned.
+func fetchValues(arch *Arch, probes []*probe, includes []string, defines map[string]string) (map[*probe]uintptr, error) {
+	failed := make(map[*probe]bool)
+	for {
+		src, lines := generateSource(probes, includes, defines, failed)
+		bin, out, err := runCompiler(arch, src)
+		if err == nil {
+			defer os.Remove(bin)
+			return runProbes(bin, probes, failed)
+		}
+		matches := errorRe.FindAllSubmatch(out, -1)
+		progress := false
+		for _, match := range matches {
+			line, _ := strconv.Atoi(string(match[1]))
+			if p := lines[line]; p != nil && !failed[p] {
+				logf(2, "dropping %v", p.what)
+				failed[p] = true
+				progress = true
+			}
+		}
+		if !progress {
+			return nil, fmt.Errorf("failed to run gcc: %v\n%s", err, out)
+		}
+	}
+}
+
+func generateSource(probes []*probe, includes []string, defines map[string]string, failed map[*probe]bool) ([]byte, map[int]*probe) {
+	buf := new(bytes.Buffer)
+	line := 1
+	printf := func(msg string, args ...interface{}) {
+		fmt.Fprintf(buf, msg+"\n", args...)
+		line++
+	}
+	for _, inc := range includes {
+		printf("#include <%v>", inc)
+	}
+	for k, v := range defines {
+		printf("#ifndef %v\n#define %v %v\n#endif", k, k, v)
+		line += 2
+	}
+	printf("int printf(const char *format, ...);")
+	printf("int main() {")
+	lines := make(map[int]*probe)
+	for _, p := range probes {
+		if failed[p] {
+			continue
+		}
+		lines[line] = p
+		printf("\tprintf(\"%%lu\\n\", (unsigned long)(%v));", p.expr)
+	}
+	printf("\treturn 0;")
+	printf("}")
+	return buf.Bytes(), lines
+}
+
+func runProbes(bin string, probes []*probe, failed map[*probe]bool) (map[*probe]uintptr, error) {
+	out, err := exec.Command(bin).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run probes binary: %v\n%s", err, out)
+	}
+	vals := strings.Fields(string(out))
+	res := make(map[*probe]uintptr)
+	for _, p := range probes {
+		if failed[p] {
+			continue
+		}
+		if len(vals) == 0 {
+			return nil, fmt.Errorf("probes binary printed too few values")
+		}
+		v, err := strconv.ParseUint(vals[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse value %q: %v", vals[0], err)
+		}
+		res[p] = uintptr(v)
+		vals = vals[1:]
+	}
+	return res, nil
+}
+
+func runCompiler(arch *Arch, src []byte) (bin string, out []byte, err error) {
+	binFile, err := ioutil.TempFile("", "syz-uapicheck")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create temp file: %v", err)
+	}
+	binFile.Close()
+
+	hdrArch := arch.KernelHeaderArch
+	args := []string{"-x", "c", "-", "-o", binFile.Name(), "-fmessage-length=0"}
+	args = append(args, arch.CFlags...)
+	args = append(args, []string{
+		"-w",
+		"-I.",
+		"-D__KERNEL__",
+		"-DKBUILD_MODNAME=\"-\"",
+		"-I" + *flagLinux + "/arch/" + hdrArch + "/include",
+		"-I" + *flagLinuxBld + "/arch/" + hdrArch + "/include/generated/uapi",
+		"-I" + *flagLinuxBld + "/arch/" + hdrArch + "/include/generated",
+		"-I" + *flagLinuxBld + "/include",
+		"-I" + *flagLinux + "/include",
+		"-I" + *flagLinux + "/arch/" + hdrArch + "/include/uapi",
+		"-I" + *flagLinux + "/include/uapi",
+		"-I" + *flagLinuxBld + "/include/generated/uapi",
+		"-I" + *flagLinux,
+		"-include", *flagLinux + "/include/linux/kconfig.h",
+	}...)
+	cmd := exec.Command("gcc", args...)
+	cmd.Stdin = bytes.NewReader(src)
+	out, err = cmd.CombinedOutput()
+	if err != nil {
+		os.Remove(binFile.Name())
+		return "", out, err
+	}
+	return binFile.Name(), nil, nil
+}
+
+func failf(msg string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, msg+"\n", args...)
+	os.Exit(1)
+}
+
+func logf(v int, msg string, args ...interface{}) {
+	if *flagV >= v {
+		fmt.Fprintf(os.Stderr, msg+"\n", args...)
+	}
+}
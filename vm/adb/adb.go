@@ -12,6 +12,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"strings"
 	"sync"
 	"time"
 
@@ -236,9 +237,37 @@ func (inst *instance) repair() error {
 	if err := inst.waitForSsh(); err != nil {
 		return err
 	}
+	inst.setupDevice()
 	return nil
 }
 
+// setupDevice prepares the device for fuzzing of Android-specific interfaces (binder, ashmem).
+// All steps need root (userdebug build), but the rest of the kernel can be fuzzed without them,
+// so failures are only logged. Note: old adb versions don't propagate exit status of shell commands,
+// so results are checked by output.
+func (inst *instance) setupDevice() {
+	if out, err := inst.adb("shell", "id -u"); err != nil || strings.TrimSpace(string(out)) != "0" {
+		Logf(0, "device %v: adb is not root, binder and ashmem won't be fuzzed properly (userdebug build is required)",
+			inst.cfg.Device)
+		return
+	}
+	// Stop the Android framework. Otherwise fuzzed binder transactions reach
+	// system services, which crash and restart the framework,
+	// so the device spends its time restarting userspace instead of fuzzing.
+	// Ignore errors, the command is missing on some minimal images.
+	inst.adb("shell", "stop")
+	// SELinux policy denies most binder and ashmem operations to processes started from adb shell.
+	inst.adb("shell", "setenforce 0")
+	if out, err := inst.adb("shell", "getenforce"); err != nil || strings.TrimSpace(string(out)) != "Permissive" {
+		Logf(0, "device %v: failed to switch SELinux to permissive mode: %s %v", inst.cfg.Device, out, err)
+	}
+	for _, dev := range []string{"/dev/binder", "/dev/ashmem"} {
+		if out, _ := inst.adb("shell", "test -c "+dev+" && echo ok"); strings.TrimSpace(string(out)) != "ok" {
+			Logf(0, "device %v: %v is missing, it won't be fuzzed", inst.cfg.Device, dev)
+		}
+	}
+}
+
 func (inst *instance) waitForSsh() error {
 	var err error
 	for i := 0; i < 300; i++ {